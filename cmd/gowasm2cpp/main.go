@@ -4,32 +4,198 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/profile"
 
 	"github.com/hajimehoshi/go2cpp/gowasm2cpp"
+	"github.com/hajimehoshi/go2cpp/version"
 )
 
 var (
-	flagOut       = flag.String("out", ".", "Output directory")
-	flagInclude   = flag.String("include", "", "Include path")
-	flagWasm      = flag.String("wasm", "", "WebAssembly file generated by Go")
-	flagNamespace = flag.String("namespace", "", "Namespace")
-	flagProfile   = flag.Bool("profile", false, "Take profiles")
+	flagOut           = flag.String("out", ".", "Output directory")
+	flagInclude       = flag.String("include", "", "Include path")
+	flagWasm          = flag.String("wasm", "", "WebAssembly file generated by Go, or a WebAssembly text file (.wat)")
+	flagNamespace     = flag.String("namespace", "", "Namespace")
+	flagAssets        = flag.String("assets", "", "Directory whose files are embedded as assets")
+	flagCppStd        = flag.String("cpp-std", "c++14", "C++ standard of the generated code (c++14, c++17 or c++20)")
+	flagExport        = flag.String("export-macro", "", "Macro name put on the public classes to build a shared library, e.g. MYLIB_API")
+	flagStyle         = flag.String("style", "", `Formatting style of the generated code, e.g. "{IndentWidth: 4, ColumnLimit: 100}"`)
+	flagCallGraph     = flag.String("callgraph", "", "Output file of the call graph report of the translated functions (.dot or .json)")
+	flagSizeReport    = flag.String("sizereport", "", "Output file of the report of the sizes per Go package (.txt or .json)")
+	flagSchema        = flag.String("schema", "", "JSON file of the schema of the structs passed between Go and C++")
+	flagSchemaGo      = flag.String("schema-go", "", "Output Go file of the structs of the schema")
+	flagMaxLines      = flag.Int("max-function-lines", 0, "Size budget of a generated function in lines. The functions over the budget are reported (0: no budget)")
+	flagStub          = flag.String("stub", "", `Comma-separated names of the functions stubbed out with traps, e.g. "crypto/x509.*,os.Getwd"`)
+	flagStubNop       = flag.String("stub-nop", "", "Comma-separated names of the functions stubbed out with no-ops")
+	flagExports       = flag.String("exports", "", `Comma-separated names of the exports to translate with the functions reachable from them, e.g. "encode,decode"`)
+	flagExportNames   = flag.String("export-names", "", `Comma-separated renames of the exports in the C++ classes, e.g. "event=OnEvent,delete=Delete"`)
+	flagDeterministic = flag.Bool("deterministic", false, "Take the time and the random values from DeterministicSource for deterministic simulations, e.g., lockstep games")
+	flagRecord        = flag.Bool("record", false, "Generate Recorder and Replayer to record and replay the interactions between the Go program and the host")
+	flagWatchdog      = flag.Bool("watchdog", false, "Generate Watchdog and the function markers to report the functions being executed when an update freezes")
+	flagModules       = flag.Bool("modules", false, "Write the interface unit of a C++20 module as an importable facade of the headers (experimental, requires -cpp-std=c++20)")
+	flagEmscripten    = flag.Bool("emscripten", false, "Generate the code to be compiled with Emscripten back to WebAssembly, e.g., to test the translation")
+	flagScaffold      = flag.String("scaffold", "", "Platform of the project written around the generated code (android, ios)")
+	flagCacheDir      = flag.String("cache-dir", "", "Directory to cache the translated functions across runs")
+	flagIntrinsics    = flag.String("intrinsics", "", "JSON file of the native C++ implementations used instead of the translated functions")
+	flagImports       = flag.String("imports", "", `JSON file of the C++ implementations of the functions imported from the modules other than Go's, e.g. "env"`)
+	flagNoIntr        = flag.Bool("no-intrinsics", false, "Translate all the functions without the native C++ implementations, e.g. for conformance testing")
+	flagProfile       = flag.Bool("profile", false, "Take profiles")
+	flagVersion       = flag.Bool("version", false, "Print the version of go2cpp and exit")
 )
 
+func readAssets(dir string) ([]gowasm2cpp.Asset, error) {
+	var assets []gowasm2cpp.Asset
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		assets = append(assets, gowasm2cpp.Asset{
+			Name: filepath.ToSlash(rel),
+			Data: data,
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+func parseNames(names string) []string {
+	var ns []string
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		ns = append(ns, name)
+	}
+	return ns
+}
+
+func parseExportNames(names string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, name := range parseNames(names) {
+		tokens := strings.SplitN(name, "=", 2)
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("invalid export name: %q", name)
+		}
+		m[strings.TrimSpace(tokens[0])] = strings.TrimSpace(tokens[1])
+	}
+	return m, nil
+}
+
+func parseStubs(names string, mode gowasm2cpp.StubMode) []gowasm2cpp.Stub {
+	var stubs []gowasm2cpp.Stub
+	for _, name := range parseNames(names) {
+		stubs = append(stubs, gowasm2cpp.Stub{
+			Name: name,
+			Mode: mode,
+		})
+	}
+	return stubs
+}
+
 func main() {
 	flag.Parse()
+	if *flagVersion {
+		fmt.Println(version.String())
+		return
+	}
 	if *flagProfile {
 		defer profile.Start().Stop()
 	}
 
+	options := gowasm2cpp.Options{
+		CppStd:            *flagCppStd,
+		ExportMacro:       *flagExport,
+		CallGraph:         *flagCallGraph,
+		SizeReport:        *flagSizeReport,
+		SchemaGo:          *flagSchemaGo,
+		MaxFunctionLines:  *flagMaxLines,
+		CacheDir:          *flagCacheDir,
+		Deterministic:     *flagDeterministic,
+		Record:            *flagRecord,
+		Watchdog:          *flagWatchdog,
+		Modules:           *flagModules,
+		Emscripten:        *flagEmscripten,
+		DisableIntrinsics: *flagNoIntr,
+		Scaffold:          *flagScaffold,
+		Warnf:             log.Printf,
+	}
+	style, err := gowasm2cpp.ParseStyle(*flagStyle)
+	if err != nil {
+		log.Fatal(err)
+	}
+	options.Style = style
+	options.Stubs = append(parseStubs(*flagStub, gowasm2cpp.StubTrap), parseStubs(*flagStubNop, gowasm2cpp.StubNop)...)
+	options.Exports = parseNames(*flagExports)
+	exportNames, err := parseExportNames(*flagExportNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	options.ExportNames = exportNames
+	if *flagIntrinsics != "" {
+		data, err := ioutil.ReadFile(*flagIntrinsics)
+		if err != nil {
+			log.Fatal(err)
+		}
+		intrinsics, err := gowasm2cpp.ParseIntrinsics(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options.Intrinsics = intrinsics
+	}
+	if *flagImports != "" {
+		data, err := ioutil.ReadFile(*flagImports)
+		if err != nil {
+			log.Fatal(err)
+		}
+		funcs, err := gowasm2cpp.ParseImportFuncs(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options.ImportFuncs = funcs
+	}
+	if *flagSchema != "" {
+		data, err := ioutil.ReadFile(*flagSchema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		schema, err := gowasm2cpp.ParseSchema(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options.Schema = schema
+	}
+	if *flagAssets != "" {
+		assets, err := readAssets(*flagAssets)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options.Assets = assets
+	}
+
 	if err := os.MkdirAll(*flagOut, 0755); err != nil {
 		log.Fatal(err)
 	}
-	if err := gowasm2cpp.Generate(*flagOut, *flagInclude, *flagWasm, *flagNamespace); err != nil {
+	if err := gowasm2cpp.GenerateWithOptions(*flagOut, *flagInclude, *flagWasm, *flagNamespace, &options); err != nil {
 		log.Fatal(err)
 	}
 }
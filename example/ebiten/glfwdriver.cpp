@@ -72,6 +72,7 @@ std::vector<go2cpp_autogen::Game::Touch> GLFWDriver::GetTouches() {
   touch.id = 0;
   touch.x = static_cast<int>(xpos);
   touch.y = static_cast<int>(ypos);
+  touch.pointer_type = go2cpp_autogen::Game::Touch::PointerType::kMouse;
   return {touch};
 }
 
@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Asset represents a named binary data embedded into the generated code.
+type Asset struct {
+	// Name is the name to look up the asset, e.g. "images/player.png".
+	Name string
+
+	// Data is the content of the asset.
+	Data []byte
+}
+
+// cppStringLiteral returns a C++ string literal of str.
+// Bytes other than printable ASCII characters are escaped in octal so that the following characters are never
+// treated as a part of the escape sequence.
+func cppStringLiteral(str string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '?':
+			// Avoid trigraphs.
+			b.WriteString(`\?`)
+		case 0x20 <= c && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\%03o`, c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func writeAssets(dir string, incpath string, namespace string, exportMacro string, assets []Asset) error {
+	assets = append([]Asset{}, assets...)
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].Name < assets[j].Name
+	})
+
+	{
+		f, err := os.Create(filepath.Join(dir, "assets.h"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := assetsHTmpl.Execute(f, struct {
+			IncludeGuard string
+			VersionCheck string
+			IncludePath  string
+			Namespace    string
+			Export       string
+		}{
+			IncludeGuard: includeGuard(namespace) + "_ASSETS_H",
+			VersionCheck: versionCheck(namespace, "assets.h"),
+			IncludePath:  incpath,
+			Namespace:    namespace,
+			Export:       exportPrefix(exportMacro),
+		}); err != nil {
+			return err
+		}
+	}
+	{
+		f, err := os.Create(filepath.Join(dir, "assets.cpp"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := assetsCppTmpl.Execute(f, struct {
+			IncludePath string
+			Namespace   string
+			Assets      []Asset
+		}{
+			IncludePath: incpath,
+			Namespace:   namespace,
+			Assets:      assets,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var assetsHTmpl = template.Must(template.New("assets.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include "{{.IncludePath}}bytes.h"
+#include "{{.IncludePath}}config.h"
+
+#include <string>
+#include <vector>
+
+{{.VersionCheck}}
+
+namespace {{.Namespace}} {
+
+// Assets provides the binary data embedded at the generation.
+// The Go program can also read them via go2cpp.getAsset in syscall/js.
+class {{.Export}}Assets {
+public:
+  // Get returns the data of the asset. Get returns an empty span when the asset is not found.
+  static BytesSpan Get(const std::string& name);
+  static bool Exists(const std::string& name);
+  static std::vector<std::string> Names();
+};
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
+
+var assetsCppTmpl = template.Must(template.New("assets.cpp").Funcs(template.FuncMap{
+	"needsNewLine": func(x int) bool {
+		return (x+1)%16 == 0
+	},
+	"cppString": cppStringLiteral,
+}).Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}assets.h"
+
+#include <algorithm>
+#include <cstring>
+
+namespace {{.Namespace}} {
+
+namespace {
+
+{{range $index, $asset := .Assets}}{{if $asset.Data}}uint8_t asset{{$index}}_[] = {
+  {{range $index, $value := $asset.Data}}{{$value}}, {{if needsNewLine $index}}
+  {{end}}{{end}}
+};
+
+{{end}}{{end}}struct Entry {
+  const char* name;
+  uint8_t* data;
+  size_t size;
+};
+
+// The entries are sorted by their names.
+const Entry entries_[] = {
+{{range $index, $asset := .Assets}}  { {{- cppString $asset.Name}}, {{if $asset.Data}}asset{{$index}}_{{else}}nullptr{{end}}, {{len $asset.Data}}},
+{{end}}  {nullptr, nullptr, 0},
+};
+
+constexpr size_t kEntryNum = sizeof(entries_) / sizeof(entries_[0]) - 1;
+
+const Entry* FindEntry(const std::string& name) {
+  const Entry* end = entries_ + kEntryNum;
+  const Entry* e = std::lower_bound(entries_, end, name, [](const Entry& e, const std::string& name) {
+    return std::strcmp(e.name, name.c_str()) < 0;
+  });
+  if (e == end || name != e->name) {
+    return nullptr;
+  }
+  return e;
+}
+
+}
+
+BytesSpan Assets::Get(const std::string& name) {
+  const Entry* e = FindEntry(name);
+  if (!e) {
+    return BytesSpan{};
+  }
+  return BytesSpan{e->data, e->size};
+}
+
+bool Assets::Exists(const std::string& name) {
+  return FindEntry(name) != nullptr;
+}
+
+std::vector<std::string> Assets::Names() {
+  std::vector<std::string> names;
+  for (size_t i = 0; i < kEntryNum; i++) {
+    names.push_back(entries_[i].name);
+  }
+  return names;
+}
+
+}
+`))
@@ -18,10 +18,12 @@ func writeBits(dir string, incpath string, namespace string) error {
 
 		if err := bitsHTmpl.Execute(f, struct {
 			IncludeGuard string
+			VersionCheck string
 			IncludePath  string
 			Namespace    string
 		}{
 			IncludeGuard: includeGuard(namespace) + "_BITS_H",
+			VersionCheck: versionCheck(namespace, "bits.h"),
 			IncludePath:  incpath,
 			Namespace:    namespace,
 		}); err != nil {
@@ -53,21 +55,252 @@ var bitsHTmpl = template.Must(template.New("bits.h").Parse(`// Code generated by
 #ifndef {{.IncludeGuard}}
 #define {{.IncludeGuard}}
 
-#include <cmath>
+#include "{{.IncludePath}}config.h"
+
 #include <cstdint>
+#include <cstring>
+#include <functional>
+#include <limits>
+#include <string>
+
+#if GO2CPP_CPP_STD >= 20
+#include <bit>
+#endif
+
+{{.VersionCheck}}
 
 namespace {{.Namespace}} {
 
-class Bits {
+// Trap reports a WebAssembly trap and terminates the program.
+[[noreturn]] void Trap(const std::string& msg);
+
+// TrapHandlerScope sets the function called with the message before a trap or another fatal error on the current
+// thread terminates the program, e.g., to report the crash, while the scope lives. The scopes nest: the handler of the
+// enclosing scope is restored when the scope ends, and a scope with nullptr hides it. A TrapHandlerScope must be
+// destroyed on the thread that created it.
+class TrapHandlerScope {
 public:
-  static uint32_t RotateLeft(uint32_t x, int32_t k);
-  static uint64_t RotateLeft(uint64_t x, int32_t k);
+  explicit TrapHandlerScope(std::function<void(const std::string& msg)> handler);
+  ~TrapHandlerScope();
+
+  TrapHandlerScope(const TrapHandlerScope&) = delete;
+  TrapHandlerScope& operator=(const TrapHandlerScope&) = delete;
+
+private:
+  std::function<void(const std::string& msg)> prev_handler_;
 };
 
-class Math {
+// RunTrapHandler calls the handler of the innermost TrapHandlerScope on the current thread, if any. A trap in the
+// handler doesn't call the handler again.
+void RunTrapHandler(const std::string& msg);
+
+// ReportStubCall reports that the stubbed-out function name is called.
+void ReportStubCall(const std::string& name);
+
+class Bits {
 public:
-  static float Round(float x);
-  static double Round(double x);
+  // The implementation of RotateLeft is copied from the Go standard package math/bits, which is under BSD-style
+  // license. Unlike Go, shifting by n bits is undefined in C++. Mask the count of the right shift so that s == 0 works.
+
+  static inline uint32_t RotateLeft(uint32_t x, int32_t k) {
+    constexpr int32_t n = 32;
+    int32_t s = k & (n - 1);
+    return x<<s | x>>((n-s) & (n - 1));
+  }
+
+  static inline uint64_t RotateLeft(uint64_t x, int32_t k) {
+    constexpr int32_t n = 64;
+    int32_t s = k & (n - 1);
+    return x<<s | x>>((n-s) & (n - 1));
+  }
+
+  // BitCast reinterprets the bits of x as To. Accessing an object via a pointer of a different type is undefined.
+
+  template<typename To, typename From>
+  static inline To BitCast(From x) {
+    static_assert(sizeof(To) == sizeof(From), "the sizes must be the same");
+#if GO2CPP_CPP_STD >= 20
+    return std::bit_cast<To>(x);
+#else
+    To r;
+    std::memcpy(&r, &x, sizeof(To));
+    return r;
+#endif
+  }
+
+  // LeadingZeros and TrailingZeros return the bit width when x is 0, as WebAssembly's clz and ctz do.
+  // The builtin functions of GCC and Clang are undefined for 0.
+
+  static inline int32_t LeadingZeros(uint32_t x) {
+    if (x == 0) {
+      return 32;
+    }
+#if defined(__GNUC__)
+    return __builtin_clz(x);
+#else
+    int32_t n = 0;
+    for (; !(x & 0x80000000u); x <<= 1) {
+      n++;
+    }
+    return n;
+#endif
+  }
+
+  static inline int32_t LeadingZeros(uint64_t x) {
+    if (x == 0) {
+      return 64;
+    }
+#if defined(__GNUC__)
+    return __builtin_clzll(x);
+#else
+    int32_t n = 0;
+    for (; !(x & 0x8000000000000000ull); x <<= 1) {
+      n++;
+    }
+    return n;
+#endif
+  }
+
+  static inline int32_t TrailingZeros(uint32_t x) {
+    if (x == 0) {
+      return 32;
+    }
+#if defined(__GNUC__)
+    return __builtin_ctz(x);
+#else
+    int32_t n = 0;
+    for (; !(x & 1); x >>= 1) {
+      n++;
+    }
+    return n;
+#endif
+  }
+
+  static inline int32_t TrailingZeros(uint64_t x) {
+    if (x == 0) {
+      return 64;
+    }
+#if defined(__GNUC__)
+    return __builtin_ctzll(x);
+#else
+    int32_t n = 0;
+    for (; !(x & 1); x >>= 1) {
+      n++;
+    }
+    return n;
+#endif
+  }
+
+  static inline int32_t OnesCount(uint32_t x) {
+#if defined(__GNUC__)
+    return __builtin_popcount(x);
+#else
+    x = x - ((x >> 1) & 0x55555555u);
+    x = (x & 0x33333333u) + ((x >> 2) & 0x33333333u);
+    x = (x + (x >> 4)) & 0x0f0f0f0fu;
+    return static_cast<int32_t>((x * 0x01010101u) >> 24);
+#endif
+  }
+
+  static inline int32_t OnesCount(uint64_t x) {
+#if defined(__GNUC__)
+    return __builtin_popcountll(x);
+#else
+    x = x - ((x >> 1) & 0x5555555555555555ull);
+    x = (x & 0x3333333333333333ull) + ((x >> 2) & 0x3333333333333333ull);
+    x = (x + (x >> 4)) & 0x0f0f0f0f0f0f0f0full;
+    return static_cast<int32_t>((x * 0x0101010101010101ull) >> 56);
+#endif
+  }
+
+  // The division and remainder functions trap in the same way as WebAssembly.
+  // Define GO2CPP_UNCHECKED_DIVISION to skip the checks and use the raw C++ operators, whose behaviors are
+  // undefined in the trapping cases.
+
+  static inline int32_t DivS(int32_t x, int32_t y) {
+#ifndef GO2CPP_UNCHECKED_DIVISION
+    if (GO2CPP_UNLIKELY(y == 0)) {
+      Trap("integer divide by zero");
+    }
+    if (GO2CPP_UNLIKELY(x == std::numeric_limits<int32_t>::min() && y == -1)) {
+      Trap("integer overflow");
+    }
+#endif
+    return x / y;
+  }
+
+  static inline int64_t DivS(int64_t x, int64_t y) {
+#ifndef GO2CPP_UNCHECKED_DIVISION
+    if (GO2CPP_UNLIKELY(y == 0)) {
+      Trap("integer divide by zero");
+    }
+    if (GO2CPP_UNLIKELY(x == std::numeric_limits<int64_t>::min() && y == -1)) {
+      Trap("integer overflow");
+    }
+#endif
+    return x / y;
+  }
+
+  static inline uint32_t DivU(uint32_t x, uint32_t y) {
+#ifndef GO2CPP_UNCHECKED_DIVISION
+    if (GO2CPP_UNLIKELY(y == 0)) {
+      Trap("integer divide by zero");
+    }
+#endif
+    return x / y;
+  }
+
+  static inline uint64_t DivU(uint64_t x, uint64_t y) {
+#ifndef GO2CPP_UNCHECKED_DIVISION
+    if (GO2CPP_UNLIKELY(y == 0)) {
+      Trap("integer divide by zero");
+    }
+#endif
+    return x / y;
+  }
+
+  static inline int32_t RemS(int32_t x, int32_t y) {
+#ifndef GO2CPP_UNCHECKED_DIVISION
+    if (GO2CPP_UNLIKELY(y == 0)) {
+      Trap("integer divide by zero");
+    }
+    // The result is 0 in WebAssembly, while the C++ operator's behavior is undefined.
+    if (y == -1) {
+      return 0;
+    }
+#endif
+    return x % y;
+  }
+
+  static inline int64_t RemS(int64_t x, int64_t y) {
+#ifndef GO2CPP_UNCHECKED_DIVISION
+    if (GO2CPP_UNLIKELY(y == 0)) {
+      Trap("integer divide by zero");
+    }
+    if (y == -1) {
+      return 0;
+    }
+#endif
+    return x % y;
+  }
+
+  static inline uint32_t RemU(uint32_t x, uint32_t y) {
+#ifndef GO2CPP_UNCHECKED_DIVISION
+    if (GO2CPP_UNLIKELY(y == 0)) {
+      Trap("integer divide by zero");
+    }
+#endif
+    return x % y;
+  }
+
+  static inline uint64_t RemU(uint64_t x, uint64_t y) {
+#ifndef GO2CPP_UNCHECKED_DIVISION
+    if (GO2CPP_UNLIKELY(y == 0)) {
+      Trap("integer divide by zero");
+    }
+#endif
+    return x % y;
+  }
 };
 
 }
@@ -79,37 +312,50 @@ var bitsCppTmpl = template.Must(template.New("bits.cpp").Parse(`// Code generate
 
 #include "{{.IncludePath}}bits.h"
 
+#include "{{.IncludePath}}log.h"
+
+#include <cassert>
+#include <cstdlib>
+#include <utility>
+
 namespace {{.Namespace}} {
 
-// The implementation is copied from the Go standard package math/bits, which is under BSD-style license.
+namespace {
 
-uint32_t Bits::RotateLeft(uint32_t x, int32_t k) {
-  constexpr int32_t n = 32;
-  int32_t s = k & (n - 1);
-  return x<<s | x>>(n-s);
+std::function<void(const std::string& msg)>& TrapHandler() {
+  thread_local std::function<void(const std::string& msg)> handler;
+  return handler;
 }
 
-uint64_t Bits::RotateLeft(uint64_t x, int32_t k) {
-  constexpr int32_t n = 64;
-  int32_t s = k & (n - 1);
-  return x<<s | x>>(n-s);
 }
 
-float Math::Round(float x) {
-  // std::rint is also available, but this requires setting a global state by std::fesetround.
-  float r = std::round(x);
-  if (std::abs(x - r) == 0.5f && std::fmod(r, 2.0f) != 0.0f) {
-    return std::trunc(x);
-  }
-  return r;
+void Trap(const std::string& msg) {
+  Log(LogLevel::kError, "trap: " + msg);
+  RunTrapHandler(msg);
+  assert(false);
+  std::exit(1);
+}
+
+TrapHandlerScope::TrapHandlerScope(std::function<void(const std::string& msg)> handler)
+    : prev_handler_{std::move(TrapHandler())} {
+  TrapHandler() = std::move(handler);
 }
 
-double Math::Round(double x) {
-  double r = std::round(x);
-  if (std::abs(x - r) == 0.5 && std::fmod(r, 2.0) != 0.0) {
-    return std::trunc(x);
+TrapHandlerScope::~TrapHandlerScope() {
+  TrapHandler() = std::move(prev_handler_);
+}
+
+void RunTrapHandler(const std::string& msg) {
+  // Take the handler out so that a trap in the handler doesn't recurse.
+  std::function<void(const std::string& msg)> handler = std::move(TrapHandler());
+  TrapHandler() = nullptr;
+  if (handler) {
+    handler(msg);
   }
-  return r;
+}
+
+void ReportStubCall(const std::string& name) {
+  Log(LogLevel::kWarning, "stub: " + name + " is called");
 }
 
 }
@@ -8,7 +8,7 @@ import (
 	"text/template"
 )
 
-func writeBytes(dir string, incpath string, namespace string) error {
+func writeBytes(dir string, incpath string, namespace string, exportMacro string) error {
 	{
 		f, err := os.Create(filepath.Join(dir, "bytes.h"))
 		if err != nil {
@@ -18,12 +18,16 @@ func writeBytes(dir string, incpath string, namespace string) error {
 
 		if err := bytesHTmpl.Execute(f, struct {
 			IncludeGuard string
+			VersionCheck string
 			IncludePath  string
 			Namespace    string
+			Export       string
 		}{
 			IncludeGuard: includeGuard(namespace) + "_BYTES_H",
+			VersionCheck: versionCheck(namespace, "bytes.h"),
 			IncludePath:  incpath,
 			Namespace:    namespace,
+			Export:       exportPrefix(exportMacro),
 		}); err != nil {
 			return err
 		}
@@ -53,13 +57,26 @@ var bytesHTmpl = template.Must(template.New("bytes.h").Parse(`// Code generated
 #ifndef {{.IncludeGuard}}
 #define {{.IncludeGuard}}
 
+#include "{{.IncludePath}}config.h"
+
 #include <cstdint>
 #include <cstdlib>
 
+#if GO2CPP_CPP_STD >= 20
+#include <span>
+#endif
+
+{{.VersionCheck}}
+
 namespace {{.Namespace}} {
 
-// TODO: Replace this with std::span in the C++20 era.
-class BytesSpan {
+#if GO2CPP_CPP_STD >= 20
+
+using BytesSpan = std::span<uint8_t>;
+
+#else
+
+class {{.Export}}BytesSpan {
 public:
   using size_type = size_t;
   using reference = uint8_t&;
@@ -79,12 +96,15 @@ public:
   const_iterator begin() const;
   iterator end();
   const_iterator end() const;
+  uint8_t* data() const;
 
 private:
   uint8_t* data_ = nullptr;
   size_t size_ = 0;
 };
 
+#endif
+
 }
 
 #endif  // {{.IncludeGuard}}
@@ -96,6 +116,8 @@ var bytesCppTmpl = template.Must(template.New("bytes.cpp").Parse(`// Code genera
 
 namespace {{.Namespace}} {
 
+#if GO2CPP_CPP_STD < 20
+
 BytesSpan::BytesSpan() = default;
 
 BytesSpan::BytesSpan(uint8_t* data, size_t size)
@@ -135,5 +157,11 @@ BytesSpan::const_iterator BytesSpan::end() const {
   return data_ + size_;
 }
 
+uint8_t* BytesSpan::data() const {
+  return data_;
+}
+
+#endif
+
 }
 `))
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+var (
+	generatorHashOnce sync.Once
+	generatorHash     []byte
+	generatorHashErr  error
+)
+
+// generatorID returns the hash of the running executable. The cached translations are invalidated whenever the
+// generator is rebuilt, as it is hard to tell whether the translation is changed.
+func generatorID() ([]byte, error) {
+	generatorHashOnce.Do(func() {
+		exe, err := os.Executable()
+		if err != nil {
+			generatorHashErr = err
+			return
+		}
+		f, err := os.Open(exe)
+		if err != nil {
+			generatorHashErr = err
+			return
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			generatorHashErr = err
+			return
+		}
+		generatorHash = h.Sum(nil)
+	})
+	return generatorHash, generatorHashErr
+}
+
+func writeSig(h hash.Hash, sig wasm.FunctionSig) {
+	fmt.Fprintf(h, "(%v)(%v)", sig.ParamTypes, sig.ReturnTypes)
+}
+
+// bodyCache is an on-disk cache of the translated function bodies.
+//
+// A function body is looked up by the hash of its code, the generator and the module-wide information that the
+// translation depends on, i.e., the names and the signatures of the functions, the types and the globals.
+type bodyCache struct {
+	dir     string
+	context []byte
+}
+
+type cachedBody struct {
+	Locals []string `json:"locals"`
+	Body   []string `json:"body"`
+}
+
+func newBodyCache(dir string, mod *wasm.Module) (*bodyCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	id, err := generatorID()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write(id)
+	for _, t := range mod.Types {
+		writeSig(h, t)
+	}
+	h.Write([]byte{0})
+	for _, e := range mod.Imports {
+		fmt.Fprintf(h, "%q %q %d;", e.ModuleName, e.FieldName, e.Type)
+	}
+	h.Write([]byte{0})
+	for i, t := range mod.Functions {
+		fmt.Fprintf(h, "%q %d;", mod.FunctionNames[uint32(i+len(mod.Imports))], t)
+	}
+	h.Write([]byte{0})
+	for _, g := range mod.Globals {
+		fmt.Fprintf(h, "%d %t;", g.Type, g.Mutable)
+	}
+
+	return &bodyCache{
+		dir:     dir,
+		context: h.Sum(nil),
+	}, nil
+}
+
+func (c *bodyCache) key(f *wasmFunc) string {
+	h := sha256.New()
+	h.Write(c.context)
+	fmt.Fprintf(h, "%q %d;", f.Wasm.Name, f.Index)
+	writeSig(h, *f.Wasm.Sig)
+	for _, l := range f.Wasm.Body.Locals {
+		fmt.Fprintf(h, "%d %d;", l.Count, l.Type)
+	}
+	h.Write(f.Wasm.Body.Code)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// load returns the cached body for key. A broken cache entry is treated as a cache miss.
+func (c *bodyCache) load(key string) (*cachedBody, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, key[:2], key+".json"))
+	if err != nil {
+		return nil, false
+	}
+	var b cachedBody
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, false
+	}
+	return &b, true
+}
+
+// store stores body for key. The file is renamed after written so that a concurrent load never sees a partial entry.
+func (c *bodyCache) store(key string, body *cachedBody) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(c.dir, key[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, key+".json"))
+}
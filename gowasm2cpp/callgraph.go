@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+type callGraphFunc struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Package string `json:"package"`
+
+	// Lines is the number of the lines of the generated C++ function before formatting.
+	Lines int `json:"lines"`
+
+	Import bool `json:"import,omitempty"`
+
+	// Calls are the indices of the functions called directly.
+	Calls []int `json:"calls,omitempty"`
+
+	// IndirectCalls is the number of the call_indirect instructions. Their callees are unknown.
+	IndirectCalls int `json:"indirectCalls,omitempty"`
+
+	// Hash is the hash of the code to match an intrinsic with. See Intrinsic.Hash.
+	Hash string `json:"hash,omitempty"`
+}
+
+type callGraphPackage struct {
+	Name      string `json:"name"`
+	Functions int    `json:"functions"`
+	Lines     int    `json:"lines"`
+}
+
+// callGraph is a report of the translated functions and their calls.
+type callGraph struct {
+	Functions []*callGraphFunc `json:"functions"`
+
+	// Packages are the Go packages in descending order of the lines.
+	Packages []*callGraphPackage `json:"packages"`
+}
+
+// goPackage returns the package path of a Go function name like "fmt.(*pp).doPrintf".
+func goPackage(name string) string {
+	// The type arguments like "[go.shape.int]" might include other package paths.
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i]
+	}
+	i := strings.LastIndex(name, "/") + 1
+	j := strings.Index(name[i:], ".")
+	if j < 0 {
+		return ""
+	}
+	return name[:i+j]
+}
+
+// newCallGraph creates a call graph of funcs. The line counts are valid after the functions are generated.
+func newCallGraph(funcs []*wasmFunc) (*callGraph, error) {
+	c := &callGraph{}
+	pkgs := map[string]*callGraphPackage{}
+	for _, f := range funcs {
+		n := &callGraphFunc{
+			Index:   f.Index,
+			Name:    f.Wasm.Name,
+			Package: goPackage(f.Wasm.Name),
+			Lines:   f.lines,
+			Import:  f.Import,
+		}
+		if f.BodyStr == "" && f.Wasm.Body != nil {
+			instrs, err := f.Wasm.Body.Instrs()
+			if err != nil {
+				return nil, err
+			}
+			calls := map[int]struct{}{}
+			for _, instr := range instrs {
+				switch instr.Op {
+				case wasm.OpCall:
+					calls[int(instr.Immediates[0].(uint32))] = struct{}{}
+				case wasm.OpCallIndirect:
+					n.IndirectCalls++
+				}
+			}
+			for idx := range calls {
+				n.Calls = append(n.Calls, idx)
+			}
+			sort.Ints(n.Calls)
+
+			h, err := codeHash(f.Wasm.Body, func(idx uint32) string {
+				return f.Funcs[idx].Wasm.Name
+			}, func(idx uint32) *wasm.FunctionSig {
+				return f.Types[idx].Sig
+			})
+			if err != nil {
+				return nil, err
+			}
+			n.Hash = h
+		}
+		c.Functions = append(c.Functions, n)
+
+		p, ok := pkgs[n.Package]
+		if !ok {
+			p = &callGraphPackage{
+				Name: n.Package,
+			}
+			pkgs[n.Package] = p
+			c.Packages = append(c.Packages, p)
+		}
+		p.Functions++
+		p.Lines += n.Lines
+	}
+
+	sort.Slice(c.Functions, func(i, j int) bool {
+		return c.Functions[i].Index < c.Functions[j].Index
+	})
+	sort.Slice(c.Packages, func(i, j int) bool {
+		if c.Packages[i].Lines != c.Packages[j].Lines {
+			return c.Packages[i].Lines > c.Packages[j].Lines
+		}
+		return c.Packages[i].Name < c.Packages[j].Name
+	})
+	return c, nil
+}
+
+func (c *callGraph) writeJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(c)
+}
+
+// dotEscape escapes str for a quoted string in DOT.
+func dotEscape(str string) string {
+	str = strings.ReplaceAll(str, `\`, `\\`)
+	return strings.ReplaceAll(str, `"`, `\"`)
+}
+
+func (c *callGraph) writeDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph callgraph {")
+	fmt.Fprintln(bw, "  node [shape=box];")
+	for _, f := range c.Functions {
+		info := fmt.Sprintf("%d lines", f.Lines)
+		if f.Import {
+			info = "import"
+		}
+		fmt.Fprintf(bw, "  f%d [label=\"%s\\n%s\"];\n", f.Index, dotEscape(f.Name), info)
+	}
+	for _, f := range c.Functions {
+		for _, callee := range f.Calls {
+			fmt.Fprintf(bw, "  f%d -> f%d;\n", f.Index, callee)
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// writeCallGraph writes the call graph of funcs to path. The format is JSON if the extension is ".json", and DOT
+// otherwise.
+func writeCallGraph(path string, funcs []*wasmFunc) error {
+	c, err := newCallGraph(funcs)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if filepath.Ext(path) == ".json" {
+		return c.writeJSON(f)
+	}
+	return c.writeDOT(f)
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"fmt"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+const (
+	// maxLocals is the maximum number of the local variables in one function. This is the same as V8's limit.
+	maxLocals = 50000
+
+	// maxTableSize is the maximum number of the elements in one table. This is the same as V8's limit.
+	maxTableSize = 10000000
+
+	// maxPages is the maximum number of the memory pages, that is 4 GiB.
+	maxPages = 65536
+)
+
+// checkModule checks that the generator can translate mod.
+//
+// The checks are for the values that would otherwise make the generator panic or allocate too much memory. The
+// function bodies are checked at the translation.
+func checkModule(mod *wasm.Module) error {
+	if len(mod.Memories) == 0 {
+		return fmt.Errorf("memory section must have an entry")
+	}
+	pages := mod.Memories[0].Limits.Initial
+	if pages > maxPages {
+		return fmt.Errorf("too many memory pages: %d", pages)
+	}
+
+	numTypes := uint32(len(mod.Types))
+	for i, e := range mod.Imports {
+		if e.Kind != wasm.ExternalFunction {
+			return fmt.Errorf("import %d: import kind %d is not implemented", i, e.Kind)
+		}
+		if e.Type >= numTypes {
+			return fmt.Errorf("import %d: type index out of range: %d", i, e.Type)
+		}
+	}
+	numFuncs := uint32(len(mod.Imports) + len(mod.Functions))
+
+	if len(mod.Functions) != len(mod.Codes) {
+		return fmt.Errorf("the numbers of functions and function bodies don't match: %d vs %d", len(mod.Functions), len(mod.Codes))
+	}
+	for i, t := range mod.Functions {
+		if t >= numTypes {
+			return fmt.Errorf("function %d: type index out of range: %d", i, t)
+		}
+		var n uint64
+		for _, e := range mod.Codes[i].Locals {
+			n += uint64(e.Count)
+		}
+		if n > maxLocals {
+			return fmt.Errorf("function %d: too many local variables: %d", i, n)
+		}
+	}
+
+	for _, e := range mod.Exports {
+		if e.Kind == wasm.ExternalFunction && e.Index >= numFuncs {
+			return fmt.Errorf("export %q: function index out of range: %d", e.FieldStr, e.Index)
+		}
+	}
+
+	for i, e := range mod.Elements {
+		if e.Index >= uint32(len(mod.Tables)) {
+			return fmt.Errorf("element %d: table index out of range: %d", i, e.Index)
+		}
+		v, err := mod.ExecInitExpr(e.Offset)
+		if err != nil {
+			return fmt.Errorf("element %d: %v", i, err)
+		}
+		offset, ok := v.(int32)
+		if !ok {
+			return fmt.Errorf("element %d: offset must be i32", i)
+		}
+		if offset < 0 || int64(offset)+int64(len(e.Elems)) > maxTableSize {
+			return fmt.Errorf("element %d: offset out of range: %d", i, offset)
+		}
+		for _, idx := range e.Elems {
+			if idx >= numFuncs {
+				return fmt.Errorf("element %d: function index out of range: %d", i, idx)
+			}
+		}
+	}
+
+	for i, e := range mod.Data {
+		v, err := mod.ExecInitExpr(e.Offset)
+		if err != nil {
+			return fmt.Errorf("data %d: %v", i, err)
+		}
+		offset, ok := v.(int32)
+		if !ok {
+			return fmt.Errorf("data %d: offset must be i32", i)
+		}
+		if offset < 0 || int64(offset)+int64(len(e.Data)) > int64(pages)*64*1024 {
+			return fmt.Errorf("data %d: offset out of range: %d", i, offset)
+		}
+	}
+
+	return nil
+}
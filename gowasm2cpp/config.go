@@ -0,0 +1,196 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/hajimehoshi/go2cpp/version"
+)
+
+// cppStdVersion returns the version number of the C++ standard and the value of __cplusplus for it.
+func cppStdVersion(std string) (int, string, error) {
+	switch std {
+	case "", "c++14":
+		return 14, "201402L", nil
+	case "c++17":
+		return 17, "201703L", nil
+	case "c++20":
+		return 20, "202002L", nil
+	}
+	return 0, "", fmt.Errorf("unsupported C++ standard: %q", std)
+}
+
+// versionMacro returns the name of the macro for the version of go2cpp that generated the code in namespace.
+func versionMacro(namespace string) string {
+	return includeGuard(namespace) + "_GENERATOR_VERSION"
+}
+
+// versionCheck returns the preprocessor directives for the header file that fail when config.h was generated by
+// another version of go2cpp, e.g., when only some of the files were regenerated. The header must include config.h.
+func versionCheck(namespace string, file string) string {
+	m := versionMacro(namespace)
+	return fmt.Sprintf(`#if !defined(%[1]s) || %[1]s != %[2]d
+#  error "%[3]s and config.h were generated by different versions of go2cpp. Regenerate all the files."
+#endif`, m, version.Number(), file)
+}
+
+// exportPrefix returns the export macro followed by a space to be put before class names.
+func exportPrefix(macro string) string {
+	if macro == "" {
+		return ""
+	}
+	return macro + " "
+}
+
+func writeConfig(dir string, incpath string, namespace string, cppStd string, exportMacro string, emscripten bool) error {
+	std, cplusplus, err := cppStdVersion(cppStd)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, "config.h"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := configHTmpl.Execute(f, struct {
+		IncludeGuard  string
+		VersionMacro  string
+		Version       int
+		VersionString string
+		CppStd        int
+		CPlusPlus     string
+		ExportMacro   string
+		Emscripten    bool
+	}{
+		IncludeGuard:  includeGuard(namespace) + "_CONFIG_H",
+		VersionMacro:  versionMacro(namespace),
+		Version:       version.Number(),
+		VersionString: version.String(),
+		CppStd:        std,
+		CPlusPlus:     cplusplus,
+		ExportMacro:   exportMacro,
+		Emscripten:    emscripten,
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+var configHTmpl = template.Must(template.New("config.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+// {{.VersionMacro}} is the version of go2cpp that generated the code ({{.VersionString}}), as
+// MAJOR * 10000 + MINOR * 100 + PATCH. The other headers check this so that the files generated by different versions of
+// go2cpp are not mixed.
+#define {{.VersionMacro}} {{.Version}}
+
+// GO2CPP_CPP_STD is the C++ standard version that the code was generated for.
+#define GO2CPP_CPP_STD {{.CppStd}}
+
+// MSVC reports __cplusplus correctly only with /Zc:__cplusplus.
+#if defined(_MSVC_LANG)
+#  if _MSVC_LANG < {{.CPlusPlus}}
+#    error "The generated code requires C++{{.CppStd}} or later."
+#  endif
+#elif __cplusplus < {{.CPlusPlus}}
+#  error "The generated code requires C++{{.CppStd}} or later."
+#endif
+
+// GO2CPP_CONSTINIT makes sure that a lookup table like the call_indirect table is a constant in the binary, which is
+// initialized at compile time instead of at startup.
+#if GO2CPP_CPP_STD >= 20
+#  define GO2CPP_CONSTINIT constinit
+#else
+#  define GO2CPP_CONSTINIT
+#endif
+
+// GO2CPP_LARGE_FUNCTION_BEGIN and GO2CPP_LARGE_FUNCTION_END enclose the functions over the size budget given to the
+// generator. MSVC's optimizer might fail with such functions. Define GO2CPP_DEOPTIMIZE_LARGE_FUNCTIONS to disable the
+// optimizer for them.
+#if defined(_MSC_VER) && !defined(__clang__) && defined(GO2CPP_DEOPTIMIZE_LARGE_FUNCTIONS)
+#  define GO2CPP_LARGE_FUNCTION_BEGIN __pragma(optimize("", off))
+#  define GO2CPP_LARGE_FUNCTION_END __pragma(optimize("", on))
+#else
+#  define GO2CPP_LARGE_FUNCTION_BEGIN
+#  define GO2CPP_LARGE_FUNCTION_END
+#endif
+
+// Define GO2CPP_COMPUTED_GOTO to dispatch br_table with GCC's computed gotos instead of switch statements. This might
+// improve branch prediction in heavily branchy functions. This is ignored with the compilers without computed gotos like
+// MSVC.
+#if defined(GO2CPP_COMPUTED_GOTO) && (defined(__GNUC__) || defined(__clang__))
+#  define GO2CPP_USE_COMPUTED_GOTO 1
+#else
+#  define GO2CPP_USE_COMPUTED_GOTO 0
+#endif
+
+// GO2CPP_LIKELY and GO2CPP_UNLIKELY tell the optimizer which way a condition usually goes, e.g., for the checks that
+// trap and the stack checks of the Go runtime. They are hints only with the compilers that have __builtin_expect, and
+// are disabled by defining GO2CPP_NO_BRANCH_HINTS.
+#if !defined(GO2CPP_NO_BRANCH_HINTS) && (defined(__GNUC__) || defined(__clang__))
+#  define GO2CPP_LIKELY(x) (__builtin_expect(!!(x), 1))
+#  define GO2CPP_UNLIKELY(x) (__builtin_expect(!!(x), 0))
+#else
+#  define GO2CPP_LIKELY(x) (x)
+#  define GO2CPP_UNLIKELY(x) (x)
+#endif
+
+// GO2CPP_PURE and GO2CPP_CONST mark the translated functions without side effects, so that the optimizer can merge
+// their repeated calls. GO2CPP_PURE is for the functions that read the memory or the globals, and GO2CPP_CONST is for
+// the functions that depend only on their arguments. They are disabled by defining GO2CPP_NO_FUNCTION_ATTRIBUTES, and
+// with GO2CPP_MEM_STATS, which counts the loads.
+#if !defined(GO2CPP_NO_FUNCTION_ATTRIBUTES) && !defined(GO2CPP_MEM_STATS) && (defined(__GNUC__) || defined(__clang__))
+#  define GO2CPP_PURE __attribute__((pure))
+#  define GO2CPP_CONST __attribute__((const))
+#else
+#  define GO2CPP_PURE
+#  define GO2CPP_CONST
+#endif
+
+// GO2CPP_MAX_RESUME_RETRIES and GO2CPP_RESUME_RETRY_TIMEOUT_MS limit the retries to resume the Go program when the Go
+// program doesn't consume its timeout (golang/go#28975). Over either limit, the program is aborted with a diagnostic
+// instead of hanging. 0 means no limit.
+#if !defined(GO2CPP_MAX_RESUME_RETRIES)
+#  define GO2CPP_MAX_RESUME_RETRIES 100000
+#endif
+#if !defined(GO2CPP_RESUME_RETRY_TIMEOUT_MS)
+#  define GO2CPP_RESUME_RETRY_TIMEOUT_MS 10000
+#endif
+
+// GO2CPP_TIMER_RESOLUTION_MS is the minimum interval in milliseconds between the wakeups of the timer thread. The timers
+// expiring within the interval are run together, and might be late by up to the interval.
+#if !defined(GO2CPP_TIMER_RESOLUTION_MS)
+#  define GO2CPP_TIMER_RESOLUTION_MS 1
+#endif
+{{if .Emscripten}}
+// The code was generated to be compiled with Emscripten back to WebAssembly. The timers and the workers run on their
+// own threads, so Emscripten's pthreads are required, e.g., with -pthread -sPROXY_TO_PTHREAD -sPTHREAD_POOL_SIZE=8.
+// The Go program's memory is allocated in the heap, so -sALLOW_MEMORY_GROWTH is also required for most programs.
+#if defined(__EMSCRIPTEN__) && !defined(__EMSCRIPTEN_PTHREADS__)
+#  error "The generated code requires pthreads with Emscripten. Compile it with -pthread."
+#endif
+{{end}}{{if .ExportMacro}}
+// {{.ExportMacro}} is put on the public classes.
+// Define GO2CPP_BUILD_SHARED to build a shared library, and GO2CPP_USE_SHARED to use it on Windows.
+#if defined(GO2CPP_BUILD_SHARED)
+#  if defined(_WIN32)
+#    define {{.ExportMacro}} __declspec(dllexport)
+#  else
+#    define {{.ExportMacro}} __attribute__((visibility("default")))
+#  endif
+#elif defined(GO2CPP_USE_SHARED) && defined(_WIN32)
+#  define {{.ExportMacro}} __declspec(dllimport)
+#else
+#  define {{.ExportMacro}}
+#endif
+{{end}}
+#endif  // {{.IncludeGuard}}
+`))
@@ -0,0 +1,543 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// writeDriver writes driver.h and driver.cpp. driver.h depends only on host.h, so a driver can be compiled without
+// the rest of the generated code, e.g., as a prebuilt library for a platform with its own toolchain.
+func writeDriver(dir string, incpath string, namespace string, exportMacro string) error {
+	{
+		f, err := os.Create(filepath.Join(dir, "driver.h"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := driverHTmpl.Execute(f, struct {
+			IncludeGuard string
+			VersionCheck string
+			IncludePath  string
+			Namespace    string
+			Export       string
+		}{
+			IncludeGuard: includeGuard(namespace) + "_DRIVER_H",
+			VersionCheck: versionCheck(namespace, "driver.h"),
+			IncludePath:  incpath,
+			Namespace:    namespace,
+			Export:       exportPrefix(exportMacro),
+		}); err != nil {
+			return err
+		}
+	}
+	{
+		f, err := os.Create(filepath.Join(dir, "driver.cpp"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := driverCppTmpl.Execute(f, struct {
+			IncludePath string
+			Namespace   string
+		}{
+			IncludePath: incpath,
+			Namespace:   namespace,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var driverHTmpl = template.Must(template.New("driver.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+// This header and the headers it includes, host.h, bytes.h, config.h and log.h, don't depend on the rest of the
+// generated code.
+#include "{{.IncludePath}}config.h"
+#include "{{.IncludePath}}host.h"
+
+#include <chrono>
+#include <cstddef>
+#include <cstdint>
+#include <functional>
+#include <memory>
+#include <mutex>
+#include <string>
+#include <vector>
+
+{{.VersionCheck}}
+
+namespace {{.Namespace}} {
+
+class Game;
+
+struct Touch {
+  // PointerType is the device of a touch like PointerEvent.pointerType.
+  enum class PointerType {
+    kTouch,
+    kPen,
+
+    // kMouse is a touch emulated by a mouse.
+    kMouse,
+  };
+
+  int id;
+  int x;
+  int y;
+
+  // pressure is the normalized pressure in [0, 1]. For a device without pressure, use 0.5 like PointerEvent.
+  float pressure = 0.5f;
+
+  // radius_x and radius_y are the radii of the ellipse of the contact area in the same unit as x and y. 0 means
+  // unknown.
+  float radius_x = 0;
+  float radius_y = 0;
+
+  PointerType pointer_type = PointerType::kTouch;
+};
+
+struct Gamepad {
+  int id;
+  bool standard;
+  int button_count;
+  bool button_pressed[256];
+  float button_values[256];
+  int axis_count;
+  float axes[16];
+
+  // has_vibration reports whether the gamepad has the dual motors for Driver::VibrateGamepad.
+  bool has_vibration = false;
+};
+
+enum class CursorMode {
+  kVisible,
+  kHidden,
+
+  // kCaptured hides the cursor and locks it in the window like the pointer lock.
+  kCaptured,
+};
+
+// CursorShape is the shape of the cursor, which corresponds to the value of the CSS cursor property.
+enum class CursorShape {
+  kDefault,
+  kText,
+  kPointer,
+  kCrosshair,
+  kEWResize,
+  kNSResize,
+  kNESWResize,
+  kNWSEResize,
+  kMove,
+  kNotAllowed,
+};
+
+// Monitor is a display connected to the device.
+struct Monitor {
+  int id = 0;
+  std::string name;
+
+  // x, y, width and height are the bounds of the monitor in device-independent pixels, like Driver::GetScreenWidth.
+  int x = 0;
+  int y = 0;
+  int width = 0;
+  int height = 0;
+
+  double device_pixel_ratio = 1;
+
+  // refresh_rate is the refresh rate in Hz. 0 means unknown.
+  double refresh_rate = 0;
+
+  // current reports whether the game is shown on the monitor.
+  bool current = false;
+};
+
+// File is a file that the user chose in a file dialog or dropped.
+struct File {
+  std::string name;
+  std::vector<uint8_t> data;
+};
+
+// FileDialogOptions is the options of the dialog to open files.
+struct FileDialogOptions {
+  bool multiple = false;
+
+  // accept is the file types like ".png" or "image/png". An empty accept means any type.
+  std::vector<std::string> accept;
+};
+
+// GamepadVibration is the parameters of vibrationActuator.playEffect("dual-rumble", ...).
+struct GamepadVibration {
+  std::chrono::milliseconds start_delay{0};
+  std::chrono::milliseconds duration{0};
+
+  // strong_magnitude and weak_magnitude are the magnitudes of the low-frequency and the high-frequency motors in
+  // [0, 1].
+  double strong_magnitude = 0;
+  double weak_magnitude = 0;
+};
+
+class {{.Export}}AudioPlayer {
+public:
+  virtual ~AudioPlayer();
+  virtual void Close(bool immediately) = 0;
+  virtual double GetVolume() = 0;
+  virtual void SetVolume(double volume) = 0;
+  virtual void Pause() = 0;
+  virtual void Play() = 0;
+  virtual void Write(const uint8_t* data, int length) = 0;
+  virtual size_t GetUnplayedBufferSize() = 0;
+};
+
+// AudioFormat is the format of the PCM samples, which are interleaved and little-endian.
+struct AudioFormat {
+  int sample_rate = 0;
+  int channel_num = 0;
+  int bit_depth_in_bytes = 0;
+};
+
+// AudioCapture records the audio input like a microphone.
+class {{.Export}}AudioCapture {
+public:
+  virtual ~AudioCapture();
+
+  // GetFormat returns the format of the captured samples, which might differ from the requested format.
+  virtual AudioFormat GetFormat() = 0;
+
+  virtual void Start() = 0;
+  virtual void Stop() = 0;
+  virtual void Close() = 0;
+
+  // Read copies up to length bytes of the captured samples to data without blocking, and returns the number of the
+  // copied bytes.
+  virtual int Read(uint8_t* data, int length) = 0;
+
+  // GetBufferedSize returns the number of the bytes that can be read.
+  virtual size_t GetBufferedSize() = 0;
+};
+
+// VideoPlayer plays a video with the platform's decoder.
+class {{.Export}}VideoPlayer {
+public:
+  virtual ~VideoPlayer();
+  virtual void Play() = 0;
+  virtual void Pause() = 0;
+  virtual void Seek(double seconds) = 0;
+  virtual void Close() = 0;
+
+  virtual double GetTime() = 0;
+  virtual double GetDuration() = 0;
+  virtual int GetWidth() = 0;
+  virtual int GetHeight() = 0;
+  virtual bool IsEnded() = 0;
+  virtual double GetVolume() = 0;
+  virtual void SetVolume(double volume) = 0;
+
+  // UpdateTexture uploads the current frame to the GL texture, and returns whether the texture is updated, e.g., false
+  // if no new frame is decoded. UpdateTexture is called in the thread running Game::Run with the GL context.
+  virtual bool UpdateTexture(uint32_t texture) = 0;
+};
+
+// UDPSocket is a UDP socket bound to a local port.
+class {{.Export}}UDPSocket {
+public:
+  virtual ~UDPSocket();
+
+  // SendTo sends a datagram to the host and the port, and returns false if sending fails.
+  virtual bool SendTo(const std::string& host, int port, const uint8_t* data, int length) = 0;
+
+  virtual int GetLocalPort() = 0;
+  virtual void Close() = 0;
+};
+
+// Driver provides graphics, audio and inputs in addition to HostServices.
+class {{.Export}}Driver : public HostServices {
+public:
+  virtual ~Driver();
+  virtual bool Initialize() = 0;
+  virtual bool Finalize() = 0;
+  virtual void Update(std::function<void()> f) = 0;
+  virtual int GetScreenWidth() = 0;
+  virtual int GetScreenHeight() = 0;
+  virtual double GetDevicePixelRatio() = 0;
+  virtual void* GetOpenGLFunction(const char* name) = 0;
+  virtual std::vector<Touch> GetTouches() = 0;
+  virtual std::vector<Gamepad> GetGamepads() = 0;
+  virtual std::string GetDefaultLanguage();
+
+  // SetVsyncEnabled is called with Game::FramePacing::vsync before the first frame. The default implementation does nothing.
+  virtual void SetVsyncEnabled(bool enabled);
+
+  // Announce makes the screen reader read text. The default implementation does nothing.
+  virtual void Announce(const std::string& text);
+
+  // IsHighContrastEnabled reports whether the high-contrast mode of the system is enabled. The default is false.
+  virtual bool IsHighContrastEnabled();
+
+  // GetPreferredFontScale returns the system's preferred scale of the font size. The default is 1.
+  virtual double GetPreferredFontScale();
+
+  // GetMonitors returns the monitors. The default implementation returns one current monitor of the screen size with
+  // GetDevicePixelRatio and GetRefreshRate.
+  virtual std::vector<Monitor> GetMonitors();
+
+  // GetRefreshRate returns the refresh rate of the current monitor in Hz. 0 means unknown. The default is 0.
+  virtual double GetRefreshRate();
+
+  // SetFullscreen requests the fullscreen mode or the windowed mode, and returns false if the mode is not available.
+  // Call OnResize when the screen size changes. The default implementation returns false.
+  virtual bool SetFullscreen(bool fullscreen);
+
+  // IsFullscreen reports whether the game is in the fullscreen mode. The default is false.
+  virtual bool IsFullscreen();
+
+  // OpenFileDialog shows the dialog to open files, and calls callback with the chosen files, or with no files if the
+  // user cancels it. SaveFileDialog shows the dialog to save data as a file named suggested_name by default, and calls
+  // callback with whether the file is saved. callback can be called from any thread, but must be called once while Game
+  // is running. The default implementations call callback as canceled.
+  virtual void OpenFileDialog(const FileDialogOptions& options, std::function<void(std::vector<File> files)> callback);
+  virtual void SaveFileDialog(const std::string& suggested_name, std::vector<uint8_t> data,
+                              std::function<void(bool saved)> callback);
+
+  // SetCursorMode and SetCursorShape set the mode and the shape of the mouse cursor. The default implementations do
+  // nothing.
+  virtual void SetCursorMode(CursorMode mode);
+  virtual void SetCursorShape(CursorShape shape);
+
+  // VibrateGamepad rumbles the gamepad id with vibration, replacing the current rumble. A zero duration stops the
+  // rumble. This is called only for the gamepads with has_vibration. VibrateGamepad returns false if the gamepad cannot
+  // vibrate. The default implementation returns false.
+  virtual bool VibrateGamepad(int id, const GamepadVibration& vibration);
+
+  virtual void OpenAudio(int sample_rate, int channel_num, int bit_depth_in_bytes) = 0;
+  virtual void CloseAudio() = 0;
+  virtual std::unique_ptr<AudioPlayer> CreateAudioPlayer(std::function<void()> on_written) = 0;
+
+  // GetAudioUnderrunCount returns the number of the times that the audio output ran out of the samples since the audio
+  // was opened. This is for the statistics of Game::SetFrameStatsEnabled. The default is 0.
+  virtual uint64_t GetAudioUnderrunCount();
+
+  // CreateVideoPlayer creates a player of the video src, which is a path or a URL that the Go program gives. on_event is
+  // called with "canplay", "ended" or "error" like the events of the video element, and can be called from any thread.
+  // CreateVideoPlayer returns nullptr if video is not supported. The default implementation returns nullptr.
+  virtual std::unique_ptr<VideoPlayer> CreateVideoPlayer(const std::string& src,
+                                                         std::function<void(const std::string& type)> on_event);
+
+  // CreateUDPSocket binds a UDP socket to the local port, or to any port if port is 0. on_received is called with each
+  // received datagram and the address of its sender, and can be called from any thread. CreateUDPSocket returns nullptr
+  // if binding fails or UDP is not supported. The default implementation returns nullptr.
+  virtual std::unique_ptr<UDPSocket> CreateUDPSocket(
+      int port, std::function<void(const std::string& host, int port, std::vector<uint8_t> data)> on_received);
+
+  // OpenAudioCapture opens the audio input with the requested format, or the nearest format available. on_captured is
+  // called when new samples can be read, and can be called from any thread. OpenAudioCapture returns nullptr if there is
+  // no input or the permission is denied. The default implementation returns nullptr.
+  virtual std::unique_ptr<AudioCapture> OpenAudioCapture(const AudioFormat& format, std::function<void()> on_captured);
+
+protected:
+  // OnPause, OnResume and OnLowMemory notify the Go program of the lifecycle events of the application, e.g., when
+  // the application goes to the background on mobiles. Call them from the driver when the platform notifies the
+  // events. They are concurrent-safe and do nothing when Game is not running.
+  void OnPause();
+  void OnResume();
+  void OnLowMemory();
+
+  // OnContextLost and OnContextRestored notify that the GL context is destroyed and recreated, e.g., when the
+  // surface is recreated on Android. The GL functions are resolved again with GetOpenGLFunction when the context is
+  // restored. They are concurrent-safe and do nothing when Game is not running.
+  void OnContextLost();
+  void OnContextRestored();
+
+  // OnFocus, OnBlur, OnResize, OnGamepadConnected and OnGamepadDisconnected notify the events of the window, i.e.,
+  // the global object, like the browser does. id is Gamepad's id. They are concurrent-safe and do nothing when Game is
+  // not running.
+  void OnFocus();
+  void OnBlur();
+  void OnResize();
+  void OnGamepadConnected(int id);
+  void OnGamepadDisconnected(int id);
+
+  // OnFilesDropped notifies that the files are dropped onto the window. It is concurrent-safe and does nothing when Game
+  // is not running.
+  void OnFilesDropped(std::vector<File> files);
+
+private:
+  friend class Game;
+
+  struct Event {
+    std::string type;
+    int gamepad_id = -1;
+    std::vector<File> files;
+  };
+
+  void NotifyEvent(Event event);
+  void SetEventListener(std::function<void(Event)> listener);
+
+  std::mutex event_mutex_;
+  std::function<void(Event)> event_listener_;
+};
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
+
+var driverCppTmpl = template.Must(template.New("driver.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}driver.h"
+
+namespace {{.Namespace}} {
+
+AudioPlayer::~AudioPlayer() = default;
+
+AudioCapture::~AudioCapture() = default;
+
+VideoPlayer::~VideoPlayer() = default;
+
+UDPSocket::~UDPSocket() = default;
+
+Driver::~Driver() = default;
+
+std::string Driver::GetDefaultLanguage() {
+  return "en";
+}
+
+void Driver::SetVsyncEnabled(bool enabled) {
+}
+
+void Driver::Announce(const std::string& text) {
+}
+
+bool Driver::IsHighContrastEnabled() {
+  return false;
+}
+
+double Driver::GetPreferredFontScale() {
+  return 1;
+}
+
+std::vector<Monitor> Driver::GetMonitors() {
+  Monitor monitor;
+  monitor.name = "default";
+  monitor.width = GetScreenWidth();
+  monitor.height = GetScreenHeight();
+  monitor.device_pixel_ratio = GetDevicePixelRatio();
+  monitor.refresh_rate = GetRefreshRate();
+  monitor.current = true;
+  return {monitor};
+}
+
+double Driver::GetRefreshRate() {
+  return 0;
+}
+
+bool Driver::SetFullscreen(bool fullscreen) {
+  return false;
+}
+
+bool Driver::IsFullscreen() {
+  return false;
+}
+
+void Driver::OpenFileDialog(const FileDialogOptions& options, std::function<void(std::vector<File> files)> callback) {
+  callback({});
+}
+
+void Driver::SaveFileDialog(const std::string& suggested_name, std::vector<uint8_t> data,
+                            std::function<void(bool saved)> callback) {
+  callback(false);
+}
+
+uint64_t Driver::GetAudioUnderrunCount() {
+  return 0;
+}
+
+std::unique_ptr<VideoPlayer> Driver::CreateVideoPlayer(const std::string& src,
+                                                       std::function<void(const std::string& type)> on_event) {
+  return nullptr;
+}
+
+std::unique_ptr<UDPSocket> Driver::CreateUDPSocket(
+    int port, std::function<void(const std::string& host, int port, std::vector<uint8_t> data)> on_received) {
+  return nullptr;
+}
+
+std::unique_ptr<AudioCapture> Driver::OpenAudioCapture(const AudioFormat& format, std::function<void()> on_captured) {
+  return nullptr;
+}
+
+void Driver::SetCursorMode(CursorMode mode) {
+}
+
+void Driver::SetCursorShape(CursorShape shape) {
+}
+
+bool Driver::VibrateGamepad(int id, const GamepadVibration& vibration) {
+  return false;
+}
+
+void Driver::OnPause() {
+  NotifyEvent(Event{"pause"});
+}
+
+void Driver::OnResume() {
+  NotifyEvent(Event{"resume"});
+}
+
+void Driver::OnLowMemory() {
+  NotifyEvent(Event{"lowmemory"});
+}
+
+void Driver::OnContextLost() {
+  NotifyEvent(Event{"webglcontextlost"});
+}
+
+void Driver::OnContextRestored() {
+  NotifyEvent(Event{"webglcontextrestored"});
+}
+
+void Driver::OnFocus() {
+  NotifyEvent(Event{"focus"});
+}
+
+void Driver::OnBlur() {
+  NotifyEvent(Event{"blur"});
+}
+
+void Driver::OnResize() {
+  NotifyEvent(Event{"resize"});
+}
+
+void Driver::OnGamepadConnected(int id) {
+  NotifyEvent(Event{"gamepadconnected", id});
+}
+
+void Driver::OnGamepadDisconnected(int id) {
+  NotifyEvent(Event{"gamepaddisconnected", id});
+}
+
+void Driver::OnFilesDropped(std::vector<File> files) {
+  NotifyEvent(Event{"drop", -1, std::move(files)});
+}
+
+void Driver::NotifyEvent(Event event) {
+  std::lock_guard<std::mutex> lock{event_mutex_};
+  if (event_listener_) {
+    event_listener_(std::move(event));
+  }
+}
+
+void Driver::SetEventListener(std::function<void(Event)> listener) {
+  std::lock_guard<std::mutex> lock{event_mutex_};
+  event_listener_ = listener;
+}
+
+}
+`))
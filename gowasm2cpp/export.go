@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// cppKeywords are the keywords and the alternative tokens of C++ up to C++20.
+var cppKeywords = map[string]struct{}{}
+
+func init() {
+	for _, k := range strings.Fields(`alignas alignof and and_eq asm auto bitand bitor bool break case catch char char8_t
+char16_t char32_t class compl concept const consteval constexpr constinit const_cast continue co_await co_return
+co_yield decltype default delete do double dynamic_cast else enum explicit export extern false float for friend goto
+if inline int long mutable namespace new noexcept not not_eq nullptr operator or or_eq private protected public
+register reinterpret_cast requires return short signed sizeof static static_assert static_cast struct switch template
+this thread_local throw true try typedef typeid typename union unsigned using virtual void volatile wchar_t while xor
+xor_eq`) {
+		cppKeywords[k] = struct{}{}
+	}
+}
+
+// exportMemberNames are the names of the members of Inst and Library that the exports must not use.
+var exportMemberNames = map[string]struct{}{
+	// The types used in the declarations
+	"BytesSpan": {},
+	"Import":    {},
+	"Instance":  {},
+	"Mem":       {},
+
+	// Inst
+	"Inst":       {},
+	"GetGlobals": {},
+	"SetGlobals": {},
+	"Func":       {},
+	"kTableSize": {},
+	"mem_":       {},
+	"import_":    {},
+	"funcs_":     {},
+	"kTable":     {},
+
+	// Library
+	"Library":       {},
+	"Exited":        {},
+	"ExitCode":      {},
+	"ImportImpl":    {},
+	"CheckExited":   {},
+	"DebugWrite":    {},
+	"inst_":         {},
+	"exited_":       {},
+	"exit_code_":    {},
+	"debug_buffer_": {},
+}
+
+// generatedMemberRe matches the names of the generated members of Inst like Type0 and global0_.
+var generatedMemberRe = regexp.MustCompile(`^(Type[0-9]+|global[0-9]+_)$`)
+
+func isCppKeyword(name string) bool {
+	_, ok := cppKeywords[name]
+	return ok
+}
+
+// nameExports sets the C++ names of exports. The name of an export is the one in renames, or the export's name if it
+// is a valid identifier. Otherwise, the characters that are not valid are escaped, and a name conflicting with a C++
+// keyword, a member or a function with the same parameters gets underscores as suffixes.
+//
+// The returned error is an *OptionError for renames.
+func nameExports(exports []*wasmExport, funcs []*wasmFunc, renames map[string]string) error {
+	// The translated functions are private members of Inst. The overloads are allowed only with different parameters.
+	funcParams := map[string][]string{}
+	for _, f := range funcs {
+		funcParams[f.Identifier()] = append(funcParams[f.Identifier()], sigString(f.Wasm.Sig))
+	}
+	params := func(sig string) string {
+		return sig[:strings.Index(sig, " -> ")]
+	}
+	conflicts := func(name string, sig string) bool {
+		if isCppKeyword(name) || generatedMemberRe.MatchString(name) {
+			return true
+		}
+		if _, ok := exportMemberNames[name]; ok {
+			return true
+		}
+		for _, s := range funcParams[name] {
+			if params(s) == params(sig) {
+				return true
+			}
+		}
+		return false
+	}
+
+	used := map[string]string{}
+	for _, e := range exports {
+		sig := sigString(e.Funcs[e.Index].Wasm.Sig)
+
+		if n, ok := renames[e.Name]; ok {
+			if e.override {
+				return optionErrorf("ExportNames", "%s: Go calls the export with its name, so the export cannot be renamed", e.Name)
+			}
+			if conflicts(n, sig) {
+				return optionErrorf("ExportNames", "%s: the name %q conflicts with a keyword or a member", e.Name, n)
+			}
+			if other, ok := used[n]; ok {
+				return optionErrorf("ExportNames", "%s: the name %q is already used by %s", e.Name, n, other)
+			}
+			e.cppName = n
+			used[n] = e.Name
+			continue
+		}
+
+		n := e.Name
+		if !identifierRe.MatchString(n) {
+			n = identifierFromString(n)
+			if n == "" || '0' <= n[0] && n[0] <= '9' {
+				n = "_" + n
+			}
+		}
+		if !e.override {
+			for {
+				if _, ok := used[n]; !ok && !conflicts(n, sig) {
+					break
+				}
+				n += "_"
+			}
+		}
+		e.cppName = n
+		used[n] = e.Name
+	}
+	return nil
+}
+
+// validateExportNames checks the names in renames.
+func validateExportNames(renames map[string]string) error {
+	for from, to := range renames {
+		if !identifierRe.MatchString(to) {
+			return fmt.Errorf("%s: invalid identifier: %q", from, to)
+		}
+		if isCppKeyword(to) {
+			return fmt.Errorf("%s: %q is a C++ keyword", from, to)
+		}
+	}
+	return nil
+}
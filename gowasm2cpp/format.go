@@ -0,0 +1,400 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Style represents the formatting style of the generated code.
+// The field names follow clang-format's options.
+type Style struct {
+	// IndentWidth is the number of columns for one indentation level. The default is 2.
+	IndentWidth int
+
+	// UseTab specifies whether tabs are used for indentation instead of spaces.
+	UseTab bool
+
+	// ColumnLimit is the maximum length of a line. Longer lines are broken after commas where possible.
+	// 0 means no limit, and this is the default.
+	ColumnLimit int
+
+	// MaxEmptyLinesToKeep is the maximum number of consecutive empty lines. The default is 1.
+	MaxEmptyLinesToKeep int
+}
+
+// DefaultStyle returns the default style.
+func DefaultStyle() *Style {
+	return &Style{
+		IndentWidth:         2,
+		MaxEmptyLinesToKeep: 1,
+	}
+}
+
+// ParseStyle parses a style in the clang-format's inline form, e.g. "{IndentWidth: 4, ColumnLimit: 100}".
+// The fields that are not specified have the default values.
+// "" and "default" mean the default style.
+func ParseStyle(str string) (*Style, error) {
+	s := DefaultStyle()
+
+	str = strings.TrimSpace(str)
+	if str == "" || str == "default" {
+		return s, nil
+	}
+	if !strings.HasPrefix(str, "{") || !strings.HasSuffix(str, "}") {
+		return nil, fmt.Errorf("style must be enclosed in braces: %q", str)
+	}
+	str = strings.TrimSpace(str[1 : len(str)-1])
+	if str == "" {
+		return s, nil
+	}
+
+	for _, kv := range strings.Split(str, ",") {
+		tokens := strings.SplitN(kv, ":", 2)
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("invalid style option: %q", strings.TrimSpace(kv))
+		}
+		key := strings.TrimSpace(tokens[0])
+		value := strings.TrimSpace(tokens[1])
+		switch key {
+		case "IndentWidth", "ColumnLimit", "MaxEmptyLinesToKeep":
+			v, err := strconv.Atoi(value)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid value for %s: %q", key, value)
+			}
+			switch key {
+			case "IndentWidth":
+				if v == 0 {
+					return nil, fmt.Errorf("invalid value for %s: %q", key, value)
+				}
+				s.IndentWidth = v
+			case "ColumnLimit":
+				s.ColumnLimit = v
+			case "MaxEmptyLinesToKeep":
+				s.MaxEmptyLinesToKeep = v
+			}
+		case "UseTab":
+			switch value {
+			case "true", "Always":
+				s.UseTab = true
+			case "false", "Never":
+				s.UseTab = false
+			default:
+				return nil, fmt.Errorf("invalid value for %s: %q", key, value)
+			}
+		default:
+			return nil, fmt.Errorf("unknown style option: %q", key)
+		}
+	}
+	return s, nil
+}
+
+// generatedFileHeader is the first line of the files that go2cpp generates.
+const generatedFileHeader = "// Code generated by go2cpp. DO NOT EDIT."
+
+// formatFile formats the file at path in place. If nestedNamespace is not empty, the definitions of the namespace are
+// split for C++14 by splitNamespaces.
+// Files that are not generated by go2cpp are left as they are.
+func formatFile(path string, style *Style, nestedNamespace string) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(src, []byte(generatedFileHeader)) {
+		return nil
+	}
+	if nestedNamespace != "" {
+		src, err = splitNamespaces(src, nestedNamespace)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := formatCpp(w, src, style); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// splitNamespaces rewrites the nested namespace definitions of namespace in src, like "namespace a::b {", into the
+// definitions of each namespace, like "namespace a { namespace b {", and their closing braces into "}}". Nested
+// namespace definitions are available as of C++17.
+//
+// The braces are counted outside the comments and the string and character literals. The generated code has neither
+// multi-line string literals nor braces in the preprocessor directives.
+func splitNamespaces(src []byte, namespace string) ([]byte, error) {
+	names := strings.Split(namespace, "::")
+	def := []byte("namespace " + namespace + " {")
+	split := []byte("namespace " + strings.Join(names, " { namespace ") + " {")
+	closing := []byte(strings.Repeat("}", len(names)))
+
+	var (
+		dst       []byte
+		depth     int
+		inComment bool
+		// The depths inside the nested namespace definitions.
+		nsDepths []int
+	)
+	for len(src) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			line, src = src[:i+1], src[i+1:]
+		} else {
+			line, src = src, nil
+		}
+
+		if !inComment && bytes.Equal(bytes.TrimRight(line, "\r\n"), def) {
+			dst = append(dst, split...)
+			dst = append(dst, line[len(def):]...)
+			depth++
+			nsDepths = append(nsDepths, depth)
+			continue
+		}
+		if !inComment && bytes.HasPrefix(bytes.TrimLeft(line, " \t"), []byte("#")) {
+			dst = append(dst, line...)
+			continue
+		}
+
+		var quote byte
+	scan:
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case inComment:
+				if c == '*' && i+1 < len(line) && line[i+1] == '/' {
+					inComment = false
+					i++
+				}
+			case quote != 0:
+				if c == '\\' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+			case c == '/' && i+1 < len(line) && line[i+1] == '/':
+				break scan
+			case c == '/' && i+1 < len(line) && line[i+1] == '*':
+				inComment = true
+				i++
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '{':
+				depth++
+			case c == '}':
+				if len(nsDepths) > 0 && nsDepths[len(nsDepths)-1] == depth {
+					nsDepths = nsDepths[:len(nsDepths)-1]
+					dst = append(dst, line[:i]...)
+					dst = append(dst, closing...)
+					line = line[i+1:]
+					i = -1
+				}
+				depth--
+			}
+		}
+		dst = append(dst, line...)
+	}
+	if len(nsDepths) > 0 {
+		return nil, fmt.Errorf("namespace %s is not closed", namespace)
+	}
+	return dst, nil
+}
+
+// generatedFiles returns the paths of the C++ files in dir.
+func generatedFiles(dir string) ([]string, error) {
+	var paths []string
+	for _, pattern := range []string{"*.h", "*.cpp"} {
+		ps, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, ps...)
+	}
+	return paths, nil
+}
+
+// formatCpp formats the generated C++ code src and writes the result to w.
+//
+// formatCpp is not a general C++ formatter: this relies on the generated code being indented with 2 spaces per level
+// and having no multi-line string literals.
+func formatCpp(w *bufio.Writer, src []byte, style *Style) error {
+	var (
+		emptyLines    int
+		afterBlock    bool
+		inComment     bool
+		inMacro       bool
+		wroteAnyLines bool
+	)
+
+	for len(src) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			line, src = src[:i], src[i+1:]
+		} else {
+			line, src = src, nil
+		}
+		line = bytes.TrimRight(line, " \t\r")
+
+		if len(line) == 0 {
+			emptyLines++
+			continue
+		}
+
+		// Remove empty lines at the start of a block, except for namespaces.
+		if !afterBlock && wroteAnyLines {
+			n := emptyLines
+			if n > style.MaxEmptyLinesToKeep {
+				n = style.MaxEmptyLinesToKeep
+			}
+			for i := 0; i < n; i++ {
+				if err := w.WriteByte('\n'); err != nil {
+					return err
+				}
+			}
+		}
+		emptyLines = 0
+		wroteAnyLines = true
+
+		content := bytes.TrimLeft(line, " ")
+		spaces := len(line) - len(content)
+
+		// Preprocessor directives, comments and macro definitions are not broken.
+		breakable := !inComment && !inMacro && content[0] != '#' && !bytes.HasPrefix(content, []byte("//"))
+
+		inMacro = (inMacro || content[0] == '#') && bytes.HasSuffix(content, []byte(`\`))
+		if inComment {
+			inComment = !bytes.Contains(content, []byte("*/"))
+		} else if i := bytes.LastIndex(content, []byte("/*")); i >= 0 && !bytes.Contains(content[i:], []byte("*/")) {
+			inComment = true
+		}
+		afterBlock = bytes.HasSuffix(content, []byte("{")) && !bytes.HasPrefix(content, []byte("namespace")) &&
+			!inComment && !inMacro
+
+		indent := style.indent(spaces/2, spaces%2)
+		if !breakable || style.ColumnLimit == 0 {
+			if err := writeLine(w, indent, content); err != nil {
+				return err
+			}
+			continue
+		}
+
+		contIndent := style.indent(spaces/2+2, spaces%2)
+		for {
+			pos := lineBreakPos(content, style.ColumnLimit-textWidth(indent, style))
+			if pos < 0 {
+				break
+			}
+			if err := writeLine(w, indent, bytes.TrimRight(content[:pos], " ")); err != nil {
+				return err
+			}
+			content = bytes.TrimLeft(content[pos:], " ")
+			indent = contIndent
+		}
+		if err := writeLine(w, indent, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLine(w *bufio.Writer, indent string, content []byte) error {
+	if _, err := w.WriteString(indent); err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+// indent returns the indentation string for the given indentation level and the extra spaces.
+func (s *Style) indent(level int, extra int) string {
+	if s.UseTab {
+		return strings.Repeat("\t", level) + strings.Repeat(" ", extra)
+	}
+	return strings.Repeat(" ", level*s.IndentWidth+extra)
+}
+
+// textWidth returns the number of columns of the indentation s.
+func textWidth(indent string, style *Style) int {
+	n := 0
+	for _, c := range indent {
+		if c == '\t' {
+			n += style.IndentWidth
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// lineBreakPos returns the position to break content so that the first part fits in limit columns.
+// The position is just after a comma in parentheses or braces, and out of string and character literals.
+// If content already fits, or there is no such position, lineBreakPos returns -1.
+func lineBreakPos(content []byte, limit int) int {
+	if len(content) <= limit {
+		return -1
+	}
+
+	var (
+		depth int
+		quote byte
+		last  = -1
+		first = -1
+	)
+loop:
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if quote != 0 {
+			switch c {
+			case '\\':
+				i++
+			case quote:
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case '/':
+			if i+1 < len(content) && content[i+1] == '/' {
+				break loop
+			}
+		case ',':
+			if depth <= 0 || i+1 >= len(content) || content[i+1] != ' ' {
+				continue
+			}
+			// Do not break just before a trailing comment.
+			if bytes.HasPrefix(bytes.TrimLeft(content[i+1:], " "), []byte("//")) {
+				continue
+			}
+			if i+1 <= limit {
+				last = i + 1
+			} else if first < 0 {
+				first = i + 1
+			}
+		}
+	}
+	if last > 0 {
+		return last
+	}
+	return first
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestFormatCpp(t *testing.T) {
+	const src = `namespace foo {
+
+int F(int a, int b) {
+
+  if (a) {
+    return G("a, b, c", a, b);
+  }
+
+
+  return 0;
+}
+
+}
+`
+	cases := []struct {
+		Style string
+		Out   string
+	}{
+		{
+			Style: "",
+			Out: `namespace foo {
+
+int F(int a, int b) {
+  if (a) {
+    return G("a, b, c", a, b);
+  }
+
+  return 0;
+}
+
+}
+`,
+		},
+		{
+			Style: "{IndentWidth: 4, ColumnLimit: 24, MaxEmptyLinesToKeep: 0}",
+			Out: `namespace foo {
+int F(int a, int b) {
+    if (a) {
+        return G("a, b, c",
+                a, b);
+    }
+    return 0;
+}
+}
+`,
+		},
+		{
+			Style: "{UseTab: true}",
+			Out:   "namespace foo {\n\nint F(int a, int b) {\n\tif (a) {\n\t\treturn G(\"a, b, c\", a, b);\n\t}\n\n\treturn 0;\n}\n\n}\n",
+		},
+	}
+	for _, c := range cases {
+		style, err := ParseStyle(c.Style)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		if err := formatCpp(w, []byte(src), style); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != c.Out {
+			t.Errorf("style %q:\ngot:\n%s\nwant:\n%s", c.Style, got, c.Out)
+		}
+	}
+}
+
+func TestSplitNamespaces(t *testing.T) {
+	const src = `namespace a::b {
+
+// F returns '{'.
+char F() {
+  return '{';
+}
+
+/* } */
+const char* G() {
+  return "}\"}";
+}
+
+}
+`
+	const want = `namespace a { namespace b {
+
+// F returns '{'.
+char F() {
+  return '{';
+}
+
+/* } */
+const char* G() {
+  return "}\"}";
+}
+
+}}
+`
+	got, err := splitNamespaces([]byte(src), "a::b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := splitNamespaces([]byte("namespace a::b {\n"), "a::b"); err == nil {
+		t.Errorf("splitNamespaces must return an error for an unclosed namespace")
+	}
+}
+
+func TestParseStyleError(t *testing.T) {
+	for _, str := range []string{
+		"IndentWidth: 4",
+		"{IndentWidth: 0}",
+		"{IndentWidth 4}",
+		"{ColumnLimit: -1}",
+		"{UseTab: maybe}",
+		"{Foo: 1}",
+	} {
+		if _, err := ParseStyle(str); err == nil {
+			t.Errorf("ParseStyle(%q) must return an error", str)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// +build gofuzz
+
+package gowasm2cpp
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// Fuzz is the entry point for go-fuzz and libFuzzer via go-fuzz-build. data is used as a WebAssembly module.
+//
+// The crashes that the fuzzer finds are the panics that the generator does not convert to errors, and resource
+// exhaustion. The modules in testdata/ops are good seeds.
+func Fuzz(data []byte) int {
+	dir, err := ioutil.TempDir("", "gowasm2cpp-fuzz-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := generate(dir, "", data, "go2cpp_fuzz", nil); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -8,7 +8,7 @@ import (
 	"text/template"
 )
 
-func writeGame(dir string, incpath string, namespace string) error {
+func writeGame(dir string, incpath string, namespace string, exportMacro string, watchdog bool, deterministic bool) error {
 	{
 		f, err := os.Create(filepath.Join(dir, "game.h"))
 		if err != nil {
@@ -17,13 +17,21 @@ func writeGame(dir string, incpath string, namespace string) error {
 		defer f.Close()
 
 		if err := gameHTmpl.Execute(f, struct {
-			IncludeGuard string
-			IncludePath  string
-			Namespace    string
+			IncludeGuard  string
+			VersionCheck  string
+			IncludePath   string
+			Namespace     string
+			Export        string
+			Watchdog      bool
+			Deterministic bool
 		}{
-			IncludeGuard: includeGuard(namespace) + "_GAME_H",
-			IncludePath:  incpath,
-			Namespace:    namespace,
+			IncludeGuard:  includeGuard(namespace) + "_GAME_H",
+			VersionCheck:  versionCheck(namespace, "game.h"),
+			IncludePath:   incpath,
+			Namespace:     namespace,
+			Export:        exportPrefix(exportMacro),
+			Watchdog:      watchdog,
+			Deterministic: deterministic,
 		}); err != nil {
 			return err
 		}
@@ -36,11 +44,15 @@ func writeGame(dir string, incpath string, namespace string) error {
 		defer f.Close()
 
 		if err := gameCppTmpl.Execute(f, struct {
-			IncludePath string
-			Namespace   string
+			IncludePath   string
+			Namespace     string
+			Watchdog      bool
+			Deterministic bool
 		}{
-			IncludePath: incpath,
-			Namespace:   namespace,
+			IncludePath:   incpath,
+			Namespace:     namespace,
+			Watchdog:      watchdog,
+			Deterministic: deterministic,
 		}); err != nil {
 			return err
 		}
@@ -53,78 +65,68 @@ var gameHTmpl = template.Must(template.New("game.h").Parse(`// Code generated by
 #ifndef {{.IncludeGuard}}
 #define {{.IncludeGuard}}
 
+#include "{{.IncludePath}}config.h"
+#include "{{.IncludePath}}driver.h"
 #include "{{.IncludePath}}go.h"
 
+#include <chrono>
 #include <cstdint>
 #include <functional>
+#include <map>
 #include <memory>
+#include <mutex>
 #include <string>
 #include <vector>
 
-namespace {{.Namespace}} {
-
-class Game {
-public:
-  struct Touch {
-    int id;
-    int x;
-    int y;
-  };
+{{.VersionCheck}}
 
-  struct Gamepad {
-    int id;
-    bool standard;
-    int button_count;
-    bool button_pressed[256];
-    float button_values[256];
-    int axis_count;
-    float axes[16];
-  };
+namespace {{.Namespace}} {
 
-  class AudioPlayer {
-  public:
-    virtual ~AudioPlayer();
-    virtual void Close(bool immediately) = 0;
-    virtual double GetVolume() = 0;
-    virtual void SetVolume(double volume) = 0;
-    virtual void Pause() = 0;
-    virtual void Play() = 0;
-    virtual void Write(const uint8_t* data, int length) = 0;
-    virtual size_t GetUnplayedBufferSize() = 0;
-  };
+class GL;
 
-  class Driver {
-  public:
-    virtual ~Driver();
-    virtual void DebugWrite(const std::vector<uint8_t>& bytes);
-    virtual bool Initialize() = 0;
-    virtual bool Finalize() = 0;
-    virtual void Update(std::function<void()> f) = 0;
-    virtual int GetScreenWidth() = 0;
-    virtual int GetScreenHeight() = 0;
-    virtual double GetDevicePixelRatio() = 0;
-    virtual void* GetOpenGLFunction(const char* name) = 0;
-    virtual std::vector<Touch> GetTouches() = 0;
-    virtual std::vector<Gamepad> GetGamepads() = 0;
-    virtual std::string GetLocalStorageItem(const std::string& key) = 0;
-    virtual void SetLocalStorageItem(const std::string& key, const std::string& value) = 0;
-    virtual std::string GetDefaultLanguage();
-
-    virtual void OpenAudio(int sample_rate, int channel_num, int bit_depth_in_bytes) = 0;
-    virtual void CloseAudio() = 0;
-    virtual std::unique_ptr<AudioPlayer> CreateAudioPlayer(std::function<void()> on_written) = 0;
-
-  private:
-    std::unique_ptr<Writer> default_debug_writer_;
+class {{.Export}}Game {
+public:
+  // The types for the drivers are defined in driver.h.
+  using Touch = ::{{.Namespace}}::Touch;
+  using Gamepad = ::{{.Namespace}}::Gamepad;
+  using GamepadVibration = ::{{.Namespace}}::GamepadVibration;
+  using Monitor = ::{{.Namespace}}::Monitor;
+  using CursorMode = ::{{.Namespace}}::CursorMode;
+  using CursorShape = ::{{.Namespace}}::CursorShape;
+  using File = ::{{.Namespace}}::File;
+  using FileDialogOptions = ::{{.Namespace}}::FileDialogOptions;
+  using AudioPlayer = ::{{.Namespace}}::AudioPlayer;
+  using AudioCapture = ::{{.Namespace}}::AudioCapture;
+  using AudioFormat = ::{{.Namespace}}::AudioFormat;
+  using VideoPlayer = ::{{.Namespace}}::VideoPlayer;
+  using UDPSocket = ::{{.Namespace}}::UDPSocket;
+  using Driver = ::{{.Namespace}}::Driver;
+
+  // FramePacing controls when the frames requested by requestAnimationFrame are run.
+  struct FramePacing {
+    // max_fps caps the frame rate. 0 means no cap, i.e., the frame rate only depends on Driver::Update.
+    double max_fps = 0;
+
+    // vsync is passed to Driver::SetVsyncEnabled.
+    bool vsync = true;
+
+    // If fixed_timestep is positive, the frames are run at fixed_timestep intervals, and the timestamp given to the
+    // callbacks advances by fixed_timestep for each frame regardless of the actual time. When the frames are delayed,
+    // up to max_catch_up_frames frames are run without waiting, and the remaining delay is dropped.
+    std::chrono::microseconds fixed_timestep{0};
+    int max_catch_up_frames = 5;
   };
 
-  class Binding {
+  class {{.Export}}Binding {
   public:
     virtual ~Binding();
     virtual std::vector<uint8_t> Get(const std::string& key) = 0;
     virtual void Set(const std::string& key, const uint8_t* data, int length) = 0;
   };
 
+  // BindingFunc is a function that Go calls as a method of go2cpp.binding, e.g. binding.Call("name", args...).
+  using BindingFunc = std::function<Value (std::vector<Value> args)>;
+
   explicit Game(std::unique_ptr<Driver> driver);
   Game(std::unique_ptr<Driver> driver, std::unique_ptr<Binding> binding);
 
@@ -132,14 +134,86 @@ public:
   int Run(int argc, char *argv[]);
   int Run(const std::vector<std::string>& args);
 
+  // SetFramePacing must be called before Run.
+  void SetFramePacing(const FramePacing& frame_pacing);
+
+  // SetTaskPolicy must be called before Run.
+  void SetTaskPolicy(const Go::TaskPolicy& task_policy);
+
+  // SetFrameStatsEnabled enables go2cpp.stats, an object updated before every frame for the debug overlays of the Go
+  // program. The object has cpuFrameTime, the milliseconds that the previous frame took to run the frame callback, and
+  // taskCount, finalizedValueCount and audioUnderrunCount, the numbers of the tasks run, the host values finalized and
+  // the audio underruns reported by Driver::GetAudioUnderrunCount since the previous frame.
+  // SetFrameStatsEnabled must be called before Run.
+  void SetFrameStatsEnabled(bool enabled);
+{{if .Watchdog}}
+  // SetWatchdog is passed to Go::SetWatchdog. A frozen frame is reported after timeout. SetWatchdog must be called
+  // before Run.
+  void SetWatchdog(std::chrono::milliseconds timeout, std::function<void(const Watchdog::Report& report)> on_frozen);
+{{end}}
+  // RegisterFunc registers fn as the method name of go2cpp.binding, which is resolved when Go calls the method. A
+  // registered function takes precedence over Binding::Get. fn is called in the thread running Run.
+  // RegisterFunc and UnregisterFunc are concurrent-safe and can be called while the Go program is running.
+  void RegisterFunc(const std::string& name, BindingFunc fn);
+  void UnregisterFunc(const std::string& name);
+
+  // GetWorkerPool returns the pool of the threads for the jobs that Go submits with go2cpp.submitJob(name, payload),
+  // which returns a promise of the result as a Uint8Array. Register the jobs with WorkerPool::RegisterJob. Run waits
+  // for the submitted jobs before returning.
+  WorkerPool* GetWorkerPool();
+
+  // GetFrameArena returns the arena reset at every frame and at every dispatch of the events between frames, e.g., for the
+  // values that the functions registered by RegisterFunc return. The values are reused after the reset, so use the
+  // arena only for the values that the Go program never keeps beyond the frame. Game itself doesn't use the arena for
+  // the values given to the Go program, like the event objects and the gamepad states. Use the arena in the thread
+  // running Run.
+  ValueArena* GetFrameArena();
+
 private:
-  void Update(Value f);
+  BindingFunc FindFunc(const std::string& name);
+
+  void RequestAnimationFrame(Go* go, Value f);
+  void Update(Go* go, Value f, double timestamp);
+  void UpdateFrameStats(Go* go);
+
+  void DispatchEvent(const Driver::Event& e);
+
+  // NewGamepadValue returns the Gamepad object for JavaScript.
+  Value NewGamepadValue(const Gamepad& gamepad);
+
+  // NewFilesValue returns an array of the objects with "name" and "data" as a Uint8Array.
+  Value NewFilesValue(const std::vector<File>& files);
 
   std::unique_ptr<Driver> driver_;
   std::vector<Touch> touches_;
   std::vector<Gamepad> gamepads_;
+  std::map<int, Value> vibration_actuators_;
   std::unique_ptr<Binding> binding_;
+  std::map<std::string, BindingFunc> binding_funcs_;
+  std::mutex binding_funcs_mutex_;
   bool is_audio_opened_ = false;
+  std::shared_ptr<GL> gl_;
+
+  FramePacing frame_pacing_;
+  Go::TaskPolicy task_policy_;
+  bool frame_stats_enabled_ = false;
+  std::shared_ptr<DictionaryValues> frame_stats_;
+  std::chrono::steady_clock::duration frame_cpu_time_{0};
+  uint64_t last_task_count_ = 0;
+  uint64_t last_finalized_value_count_ = 0;
+  uint64_t last_audio_underrun_count_ = 0;
+{{if .Watchdog}}  std::chrono::milliseconds watchdog_timeout_{0};
+  std::function<void(const Watchdog::Report& report)> on_frozen_;
+{{end}}  std::chrono::steady_clock::time_point start_time_;
+  std::chrono::steady_clock::time_point next_frame_time_;
+{{if .Deterministic}}  int64_t next_virtual_frame_time_ = 0;
+{{end}}  int64_t frame_count_ = 0;
+  std::unique_ptr<Timer> frame_timer_;
+
+  WorkerPool worker_pool_;
+
+  // frame_arena_ is the arena for the host's values valid during a frame.
+  ValueArena frame_arena_;
 };
 
 }
@@ -152,7 +226,9 @@ var gameCppTmpl = template.Must(template.New("game.cpp").Parse(`// Code generate
 #include "{{.IncludePath}}game.h"
 
 #include "{{.IncludePath}}gl.h"
+#include "{{.IncludePath}}log.h"
 
+#include <algorithm>
 #include <cstring>
 #include <thread>
 
@@ -162,38 +238,40 @@ namespace {
 
 // TODO: This is duplicated with js.go. Unify them?
 void Panic(const std::string& msg) {
-  std::cerr << msg << std::endl;
+  Log(LogLevel::kError, msg);
   __builtin_unreachable();
 }
 
-class DriverDebugWriter : public Writer {
-public:
-  DriverDebugWriter(Game::Driver* driver)
-      : driver_{driver} {
-  }
-
-  void Write(const std::vector<uint8_t>& bytes) override {
-    driver_->DebugWrite(bytes);
-  }
-
-private:
-  Game::Driver* driver_;
-};
-
 class BindingObject : public Object {
 public:
-  explicit BindingObject(Game::Binding* binding)
-      : binding_{binding} {
+  using FindFunc = std::function<Game::BindingFunc (const std::string& name)>;
+
+  // binding can be null when the host has only the functions registered by Game::RegisterFunc.
+  BindingObject(Game::Binding* binding, FindFunc find_func)
+      : binding_{binding},
+        find_func_{std::move(find_func)} {
   }
 
   Value Get(const std::string& key) override {
+    if (Game::BindingFunc fn = find_func_(key)) {
+      return Value{std::make_shared<Function>(
+        [fn](Value self, std::vector<Value> args) -> Value {
+          return fn(std::move(args));
+        })};
+    }
+    if (!binding_) {
+      return Value{};
+    }
     auto bytes = binding_->Get(key);
     auto u8 = std::make_shared<Uint8Array>(bytes.size());
-    std::memcpy(u8->ToBytes().begin(), &(*bytes.begin()), bytes.size());
+    std::memcpy(u8->ToBytes().data(), &(*bytes.begin()), bytes.size());
     return Value{u8};
   }
 
   void Set(const std::string& key, Value value) override {
+    if (!binding_) {
+      Panic("BindingObject::Set: no Binding is given to Game");
+    }
     if (value.IsString()) {
       auto str = value.ToString();
       binding_->Set(key, reinterpret_cast<const uint8_t*>(&(*str.begin())), str.size());
@@ -213,56 +291,94 @@ public:
 
 private:
   Game::Binding* binding_;
+  FindFunc find_func_;
 };
 
-class LocalStorage : public Object {
+class GamepadVibrationActuator : public Object {
 public:
-  explicit LocalStorage(Game::Driver* driver)
-      : driver_{driver} {
+  GamepadVibrationActuator(Game::Driver* driver, int id)
+      : driver_{driver},
+        id_{id} {
   }
 
   Value Get(const std::string& key) override {
-    if (key == "setItem") {
-      if (!func_set_item_.IsFunction()) {
-        func_set_item_ = Value{std::make_shared<Function>(
+    if (key == "type") {
+      return Value{"dual-rumble"};
+    }
+    if (key == "effects") {
+      return Value{std::vector<Value>{Value{"dual-rumble"}}};
+    }
+    if (key == "playEffect") {
+      if (!func_play_effect_.IsFunction()) {
+        func_play_effect_ = Value{std::make_shared<Function>(
           [this](Value self, std::vector<Value> args) -> Value {
-            const std::string& key = args[0].ToString();
-            const std::string& value = args[1].ToString();
-            driver_->SetLocalStorageItem(key, value);
-            return Value{};
+            if (args.empty() || !args[0].IsString() || args[0].ToString() != "dual-rumble") {
+              return Settle(false, "NotSupportedError: the effect is not supported");
+            }
+            Game::GamepadVibration vibration;
+            if (args.size() > 1 && args[1].IsObject()) {
+              auto& params = args[1].ToObject();
+              vibration.start_delay = std::chrono::milliseconds{static_cast<int64_t>(NumberParam(params, "startDelay"))};
+              vibration.duration = std::chrono::milliseconds{static_cast<int64_t>(NumberParam(params, "duration"))};
+              vibration.strong_magnitude = std::min(std::max(NumberParam(params, "strongMagnitude"), 0.0), 1.0);
+              vibration.weak_magnitude = std::min(std::max(NumberParam(params, "weakMagnitude"), 0.0), 1.0);
+            }
+            return Settle(driver_->VibrateGamepad(id_, vibration), "InvalidStateError: the gamepad cannot vibrate");
           })};
       }
-      return func_set_item_;
+      return func_play_effect_;
     }
-    if (key == "getItem") {
-      if (!func_get_item_.IsFunction()) {
-        func_get_item_ = Value{std::make_shared<Function>(
+    if (key == "reset") {
+      if (!func_reset_.IsFunction()) {
+        func_reset_ = Value{std::make_shared<Function>(
           [this](Value self, std::vector<Value> args) -> Value {
-            const std::string& key = args[0].ToString();
-            const std::string& value = driver_->GetLocalStorageItem(key);
-            return Value{value};
+            return Settle(driver_->VibrateGamepad(id_, Game::GamepadVibration{}), "InvalidStateError: the gamepad cannot vibrate");
           })};
       }
-      return func_get_item_;
+      return func_reset_;
     }
     return Value{};
   }
 
   std::string ToString() const override {
-    return "LocalStorage";
+    return "GamepadHapticActuator";
   }
 
 private:
+  // NumberParam returns the number of the property key, or 0 if the property is not a number.
+  static double NumberParam(Object& params, const std::string& key) {
+    Value v = params.Get(key);
+    if (!v.IsNumber()) {
+      return 0;
+    }
+    return v.ToNumber();
+  }
+
+  // Settle returns a promise fulfilled with "complete" if ok, or rejected with an Error of message otherwise.
+  static Value Settle(bool ok, const std::string& message) {
+    auto p = std::make_shared<Promise>();
+    if (ok) {
+      p->Resolve(Value{"complete"});
+    } else {
+      p->Reject(Value{std::make_shared<Error>(message)});
+    }
+    return Value{p};
+  }
+
   Game::Driver* driver_;
+  int id_;
 
-  Value func_set_item_;
-  Value func_get_item_;
+  Value func_play_effect_;
+  Value func_reset_;
 };
 
 class Navigator : public Object {
 public:
-  explicit Navigator(Game::Driver* driver)
-      : driver_{driver} {
+  using NewGamepadValue = std::function<Value (const Game::Gamepad& gamepad)>;
+
+  Navigator(Game::Driver* driver, NewGamepadValue new_gamepad_value)
+      : driver_{driver},
+        new_gamepad_value_{std::move(new_gamepad_value)} {
   }
 
   Value Get(const std::string& key) override {
@@ -274,49 +390,11 @@ public:
         func_get_gamepads_ = Value{std::make_shared<Function>(
           [this](Value self, std::vector<Value> args) -> Value {
             const std::vector<Game::Gamepad>& gamepads = driver_->GetGamepads();
-            static Value gamepad_values_value{std::vector<Value>{}};
-            auto& gamepad_values = gamepad_values_value.ToArray();
-            if (gamepads.size() != gamepad_values.size()) {
-              gamepad_values.resize(gamepads.size());
-            }
-
+            Value gamepad_values{std::vector<Value>(gamepads.size())};
             for (size_t i = 0; i < gamepads.size(); i++) {
-              if (!gamepad_values[i].IsObject()) {
-                gamepad_values[i] = Value{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
-                  {"index", Value{0.0}},
-                  {"id", Value{""}},
-                  {"mapping", Value{""}},
-                  {"axes", Value{std::vector<Value>{}}},
-                  {"buttons", Value{std::vector<Value>{}}},
-                })};
-              }
-
-              auto& obj = gamepad_values[i].ToObject();
-              obj.Set("index", Value{static_cast<double>(gamepads[i].id)});
-              obj.Set("id", Value{"go2cpp gamepad " + std::to_string(gamepads[i].id)});
-              obj.Set("mapping", Value{gamepads[i].standard ? "standard" : ""});
-
-              auto& axes = obj.Get("axes").ToArray();
-              axes.resize(gamepads[i].axis_count);
-              for (size_t j = 0; j < axes.size(); j++) {
-                axes[j] = Value{gamepads[i].axes[j]};
-              }
-
-              auto& buttons = obj.Get("buttons").ToArray();
-              buttons.resize(gamepads[i].button_count);
-              for (size_t j = 0; j < buttons.size(); j++) {
-                if (!buttons[j].IsObject()) {
-                  buttons[j] = Value{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
-                    {"pressed", Value{false}},
-                    {"value", Value{0.0}},
-                  })};
-                }
-                auto& button_obj = buttons[j].ToObject();
-                button_obj.Set("pressed", Value{gamepads[i].button_pressed[j]});
-                button_obj.Set("value", Value{gamepads[i].button_values[j]});
-              }
+              gamepad_values.ToArray()[i] = new_gamepad_value_(gamepads[i]);
             }
-            return gamepad_values_value;
+            return gamepad_values;
           })};
       }
       return func_get_gamepads_;
@@ -330,13 +408,14 @@ public:
 
 private:
   Game::Driver* driver_;
+  NewGamepadValue new_gamepad_value_;
 
   Value func_get_gamepads_;
 };
 
-class AudioPlayer : public Object {
+class AudioPlayerObject : public Object {
 public:
-  explicit AudioPlayer(Game::Driver* driver)
+  explicit AudioPlayerObject(Game::Driver* driver)
       : driver_{driver} {
   }
 
@@ -396,7 +475,7 @@ public:
           [this](Value self, std::vector<Value> args) -> Value {
             BytesSpan buf = args[0].ToBytes();
             int size = static_cast<int>(args[1].ToNumber());
-            player_->Write(buf.begin(), size);
+            player_->Write(buf.data(), size);
             return Value{};
           })};
       }
@@ -434,6 +513,272 @@ private:
   std::mutex mutex_;
 };
 
+class AudioCaptureObject : public Object {
+public:
+  explicit AudioCaptureObject(Value on_captured)
+      : on_captured_{on_captured} {
+  }
+
+  void SetCapture(std::unique_ptr<Game::AudioCapture> capture) {
+    capture_ = std::move(capture);
+    format_ = capture_->GetFormat();
+  }
+
+  void InvokeOnCapturedCallback() {
+    if (closed_ || !on_captured_.IsFunction()) {
+      return;
+    }
+    on_captured_.ToObject().Invoke({}, {});
+  }
+
+  Value Get(const std::string& key) override {
+    if (key == "sampleRate") {
+      return Value{static_cast<double>(format_.sample_rate)};
+    }
+    if (key == "channelNum") {
+      return Value{static_cast<double>(format_.channel_num)};
+    }
+    if (key == "bitDepthInBytes") {
+      return Value{static_cast<double>(format_.bit_depth_in_bytes)};
+    }
+    if (key == "start") {
+      if (!func_start_.IsFunction()) {
+        func_start_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            capture_->Start();
+            return Value{};
+          })};
+      }
+      return func_start_;
+    }
+    if (key == "stop") {
+      if (!func_stop_.IsFunction()) {
+        func_stop_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            capture_->Stop();
+            return Value{};
+          })};
+      }
+      return func_stop_;
+    }
+    if (key == "close") {
+      if (!func_close_.IsFunction()) {
+        func_close_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            closed_ = true;
+            capture_->Close();
+            return Value{};
+          })};
+      }
+      return func_close_;
+    }
+    if (key == "read") {
+      if (!func_read_.IsFunction()) {
+        func_read_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            BytesSpan buf = args[0].ToBytes();
+            return Value{static_cast<double>(capture_->Read(buf.data(), static_cast<int>(buf.size())))};
+          })};
+      }
+      return func_read_;
+    }
+    if (key == "bufferedSize") {
+      return Value{static_cast<double>(capture_->GetBufferedSize())};
+    }
+    return Value{};
+  }
+
+  std::string ToString() const override {
+    return "AudioCapture";
+  }
+
+private:
+  std::unique_ptr<Game::AudioCapture> capture_;
+  Game::AudioFormat format_;
+  Value on_captured_;
+  bool closed_ = false;
+
+  Value func_start_;
+  Value func_stop_;
+  Value func_close_;
+  Value func_read_;
+};
+
+class VideoObject : public Object {
+public:
+  explicit VideoObject(Value on_event)
+      : on_event_{on_event} {
+  }
+
+  void SetPlayer(std::unique_ptr<Game::VideoPlayer> player) {
+    player_ = std::move(player);
+  }
+
+  void InvokeOnEventCallback(const std::string& type) {
+    if (closed_ || !on_event_.IsFunction()) {
+      return;
+    }
+    on_event_.ToObject().Invoke({}, {Value{type}});
+  }
+
+  Value Get(const std::string& key) override {
+    if (key == "currentTime") {
+      return Value{player_->GetTime()};
+    }
+    if (key == "duration") {
+      return Value{player_->GetDuration()};
+    }
+    if (key == "videoWidth") {
+      return Value{static_cast<double>(player_->GetWidth())};
+    }
+    if (key == "videoHeight") {
+      return Value{static_cast<double>(player_->GetHeight())};
+    }
+    if (key == "ended") {
+      return Value{player_->IsEnded()};
+    }
+    if (key == "volume") {
+      return Value{player_->GetVolume()};
+    }
+    if (key == "play") {
+      if (!func_play_.IsFunction()) {
+        func_play_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            player_->Play();
+            return Value{};
+          })};
+      }
+      return func_play_;
+    }
+    if (key == "pause") {
+      if (!func_pause_.IsFunction()) {
+        func_pause_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            player_->Pause();
+            return Value{};
+          })};
+      }
+      return func_pause_;
+    }
+    if (key == "close") {
+      if (!func_close_.IsFunction()) {
+        func_close_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            closed_ = true;
+            player_->Close();
+            return Value{};
+          })};
+      }
+      return func_close_;
+    }
+    // updateTexture takes a texture of go2cpp.gl, e.g., made by createTexture.
+    if (key == "updateTexture") {
+      if (!func_update_texture_.IsFunction()) {
+        func_update_texture_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            return Value{player_->UpdateTexture(static_cast<uint32_t>(args[0].ToNumber()))};
+          })};
+      }
+      return func_update_texture_;
+    }
+    return Value{};
+  }
+
+  void Set(const std::string& key, Value value) override {
+    if (key == "currentTime") {
+      player_->Seek(value.ToNumber());
+      return;
+    }
+    if (key == "volume") {
+      player_->SetVolume(value.ToNumber());
+      return;
+    }
+  }
+
+  std::string ToString() const override {
+    return "Video";
+  }
+
+private:
+  std::unique_ptr<Game::VideoPlayer> player_;
+  Value on_event_;
+  bool closed_ = false;
+
+  Value func_play_;
+  Value func_pause_;
+  Value func_close_;
+  Value func_update_texture_;
+};
+
+class UDPSocketObject : public Object {
+public:
+  explicit UDPSocketObject(Value on_received)
+      : on_received_{on_received} {
+  }
+
+  void SetSocket(std::unique_ptr<Game::UDPSocket> socket) {
+    socket_ = std::move(socket);
+  }
+
+  void InvokeOnReceivedCallback(const std::string& host, int port, const std::vector<uint8_t>& data) {
+    if (closed_ || !on_received_.IsFunction()) {
+      return;
+    }
+    auto u8 = std::make_shared<Uint8Array>(data.size());
+    if (!data.empty()) {
+      std::memcpy(u8->ToBytes().data(), data.data(), data.size());
+    }
+    on_received_.ToObject().Invoke({}, {Value{host}, Value{static_cast<double>(port)}, Value{u8}});
+  }
+
+  Value Get(const std::string& key) override {
+    if (key == "localPort") {
+      return Value{static_cast<double>(socket_->GetLocalPort())};
+    }
+    if (key == "sendTo") {
+      if (!func_send_to_.IsFunction()) {
+        func_send_to_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            if (closed_) {
+              return Value{false};
+            }
+            const std::string& host = args[0].ToString();
+            int port = static_cast<int>(args[1].ToNumber());
+            BytesSpan buf = args[2].ToBytes();
+            return Value{socket_->SendTo(host, port, buf.data(), static_cast<int>(buf.size()))};
+          })};
+      }
+      return func_send_to_;
+    }
+    if (key == "close") {
+      if (!func_close_.IsFunction()) {
+        func_close_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            if (!closed_) {
+              closed_ = true;
+              socket_->Close();
+            }
+            return Value{};
+          })};
+      }
+      return func_close_;
+    }
+    return Value{};
+  }
+
+  std::string ToString() const override {
+    return "UDPSocket";
+  }
+
+private:
+  std::unique_ptr<Game::UDPSocket> socket_;
+  Value on_received_;
+  bool closed_ = false;
+
+  Value func_send_to_;
+  Value func_close_;
+};
+
 class Audio : public Object {
 public:
   Audio(Go* go, Game::Driver* driver)
@@ -446,20 +791,53 @@ public:
       if (!func_create_player_.IsFunction()) {
         func_create_player_ = Value{std::make_shared<Function>(
           [this](Value self, std::vector<Value> args) -> Value {
-            auto p = std::make_shared<AudioPlayer>(driver_);
-            // Capture the shared pointer and use it in the lambda.
-            // The AudioPlayer must exist when invoking.
-            p->SetOnWrittenCallback(args[0], [this, p]() {
+            auto p = std::make_shared<AudioPlayerObject>(driver_);
+            // Capture a weak pointer in the lambda. As the AudioPlayerObject owns the driver's player that owns the
+            // callback, capturing the shared pointer would make a reference cycle and the AudioPlayerObject would never be
+            // destroyed.
+            std::weak_ptr<AudioPlayerObject> weak = p;
+            p->SetOnWrittenCallback(args[0], [this, weak]() {
               // This callback can be invoked from a different thread. Use EnqueueTask here.
-              go_->EnqueueTask([p]() {
-                p->InvokeOnWrittenCallback();
-              });
+              go_->EnqueueTask([weak]() {
+                if (std::shared_ptr<AudioPlayerObject> p = weak.lock()) {
+                  p->InvokeOnWrittenCallback();
+                }
+              }, TaskQueue::Priority::kAudio);
             });
             return Value{p};
           })};
       }
       return func_create_player_;
     }
+    // createCapture takes the requested sample rate, the number of the channels, the bit depth in bytes and the
+    // function called when new samples can be read, and returns null if the audio input is not available.
+    if (key == "createCapture") {
+      if (!func_create_capture_.IsFunction()) {
+        func_create_capture_ = Value{std::make_shared<Function>(
+          [this](Value self, std::vector<Value> args) -> Value {
+            Game::AudioFormat format;
+            format.sample_rate = static_cast<int>(args[0].ToNumber());
+            format.channel_num = static_cast<int>(args[1].ToNumber());
+            format.bit_depth_in_bytes = static_cast<int>(args[2].ToNumber());
+            auto c = std::make_shared<AudioCaptureObject>(args[3]);
+            // Capture a weak pointer for the same reason as createPlayer.
+            std::weak_ptr<AudioCaptureObject> weak = c;
+            auto capture = driver_->OpenAudioCapture(format, [this, weak]() {
+              go_->EnqueueTask([weak]() {
+                if (std::shared_ptr<AudioCaptureObject> c = weak.lock()) {
+                  c->InvokeOnCapturedCallback();
+                }
+              }, TaskQueue::Priority::kAudio);
+            });
+            if (!capture) {
+              return Value::Null();
+            }
+            c->SetCapture(std::move(capture));
+            return Value{c};
+          })};
+      }
+      return func_create_capture_;
+    }
     return Value{};
   }
 
@@ -472,25 +850,11 @@ private:
   Game::Driver* driver_;
 
   Value func_create_player_;
+  Value func_create_capture_;
 };
 
 } // namespace
 
-Game::AudioPlayer::~AudioPlayer() = default;
-
-Game::Driver::~Driver() = default;
-
-void Game::Driver::DebugWrite(const std::vector<uint8_t>& bytes) {
-  if (!default_debug_writer_) {
-    default_debug_writer_ = std::make_unique<StreamWriter>(std::cerr);
-  }
-  default_debug_writer_->Write(bytes);
-}
-
-std::string Game::Driver::GetDefaultLanguage() {
-  return "en";
-}
-
 Game::Game(std::unique_ptr<Driver> driver)
   : Game(std::move(driver), nullptr) {
 }
@@ -515,16 +879,18 @@ int Game::Run(const std::vector<std::string>& args) {
   }
 
   auto& global = Value::Global().ToObject();
-  global.Set("localStorage", Value{std::make_shared<LocalStorage>(driver_.get())});
-  global.Set("navigator", Value{std::make_shared<Navigator>(driver_.get())});
+  global.Set("navigator", Value{std::make_shared<Navigator>(driver_.get(),
+      [this](const Gamepad& gamepad) -> Value {
+        return NewGamepadValue(gamepad);
+      })});
 
-  auto go2cpp = std::make_shared<DictionaryValues>();
-  global.Set("go2cpp", Value{go2cpp});
+  // go2cpp is already created in the js world.
+  Object* go2cpp = &global.Get("go2cpp").ToObject();
 
-  auto gl = std::make_shared<GL>([this](const char* name) -> void* {
+  gl_ = std::make_shared<GL>([this](const char* name) -> void* {
     return driver_->GetOpenGLFunction(name);
   });
-  go2cpp->Set("gl", Value{gl});
+  go2cpp->Set("gl", Value{gl_});
 
   go2cpp->Set("screenWidth",
       Value{static_cast<double>(driver_->GetScreenWidth())});
@@ -548,8 +914,145 @@ int Game::Run(const std::vector<std::string>& args) {
       int idx = static_cast<int>(args[0].ToNumber());
       return Value{static_cast<double>(touches_[idx].y)};
     })});
+  go2cpp->Set("getTouchPressure", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      int idx = static_cast<int>(args[0].ToNumber());
+      return Value{static_cast<double>(touches_[idx].pressure)};
+    })});
+  go2cpp->Set("getTouchRadiusX", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      int idx = static_cast<int>(args[0].ToNumber());
+      return Value{static_cast<double>(touches_[idx].radius_x)};
+    })});
+  go2cpp->Set("getTouchRadiusY", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      int idx = static_cast<int>(args[0].ToNumber());
+      return Value{static_cast<double>(touches_[idx].radius_y)};
+    })});
+  // getTouchPointerType returns "touch", "pen" or "mouse" like PointerEvent.pointerType.
+  go2cpp->Set("getTouchPointerType", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      int idx = static_cast<int>(args[0].ToNumber());
+      switch (touches_[idx].pointer_type) {
+      case Touch::PointerType::kPen:
+        return Value{"pen"};
+      case Touch::PointerType::kMouse:
+        return Value{"mouse"};
+      default:
+        return Value{"touch"};
+      }
+    })});
+
+  // The monitors and the fullscreen mode can be changed while running. These are functions to query the current values.
+  go2cpp->Set("getMonitors", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      std::vector<Monitor> monitors = driver_->GetMonitors();
+      Value values{std::vector<Value>(monitors.size())};
+      for (size_t i = 0; i < monitors.size(); i++) {
+        const Monitor& m = monitors[i];
+        Value value{std::make_shared<DictionaryValues>()};
+        auto& obj = value.ToObject();
+        obj.Set("id", Value{static_cast<double>(m.id)});
+        obj.Set("name", Value{m.name});
+        obj.Set("x", Value{static_cast<double>(m.x)});
+        obj.Set("y", Value{static_cast<double>(m.y)});
+        obj.Set("width", Value{static_cast<double>(m.width)});
+        obj.Set("height", Value{static_cast<double>(m.height)});
+        obj.Set("devicePixelRatio", Value{m.device_pixel_ratio});
+        obj.Set("refreshRate", Value{m.refresh_rate});
+        obj.Set("current", Value{m.current});
+        values.ToArray()[i] = value;
+      }
+      return values;
+    })});
+  go2cpp->Set("getRefreshRate", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      return Value{driver_->GetRefreshRate()};
+    })});
+  go2cpp->Set("setFullscreen", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      return Value{driver_->SetFullscreen(!args.empty() && args[0].ToBool())};
+    })});
+  go2cpp->Set("isFullscreen", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      return Value{driver_->IsFullscreen()};
+    })});
+
+  // setCursorMode takes "visible", "hidden" or "captured", and setCursorShape takes a value of the CSS cursor property.
+  // The unknown values are ignored.
+  go2cpp->Set("setCursorMode", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      static const std::map<std::string, CursorMode> modes = {
+        {"visible", CursorMode::kVisible},
+        {"hidden", CursorMode::kHidden},
+        {"captured", CursorMode::kCaptured},
+      };
+      if (!args.empty() && args[0].IsString()) {
+        auto it = modes.find(args[0].ToString());
+        if (it != modes.end()) {
+          driver_->SetCursorMode(it->second);
+        }
+      }
+      return Value{};
+    })});
+  go2cpp->Set("setCursorShape", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      static const std::map<std::string, CursorShape> shapes = {
+        {"default", CursorShape::kDefault},
+        {"text", CursorShape::kText},
+        {"pointer", CursorShape::kPointer},
+        {"crosshair", CursorShape::kCrosshair},
+        {"ew-resize", CursorShape::kEWResize},
+        {"ns-resize", CursorShape::kNSResize},
+        {"nesw-resize", CursorShape::kNESWResize},
+        {"nwse-resize", CursorShape::kNWSEResize},
+        {"move", CursorShape::kMove},
+        {"not-allowed", CursorShape::kNotAllowed},
+      };
+      if (!args.empty() && args[0].IsString()) {
+        auto it = shapes.find(args[0].ToString());
+        if (it != shapes.end()) {
+          driver_->SetCursorShape(it->second);
+        }
+      }
+      return Value{};
+    })});
 
-  Go go{std::make_unique<DriverDebugWriter>(driver_.get())};
+  // The accessibility settings can be changed while running. These are functions to query the current values.
+  go2cpp->Set("announce", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      driver_->Announce(args[0].ToString());
+      return Value{};
+    })});
+  go2cpp->Set("isHighContrastEnabled", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      return Value{driver_->IsHighContrastEnabled()};
+    })});
+  go2cpp->Set("getPreferredFontScale", Value{std::make_shared<Function>(
+    [this](Value self, std::vector<Value> args) -> Value {
+      return Value{driver_->GetPreferredFontScale()};
+    })});
+
+  // go2cpp.addEventListener and go2cpp.removeEventListener register listeners for the events: "pause", "resume",
+  // "lowmemory", "webglcontextlost", "webglcontextrestored" and "drop". The global object, i.e., window, has the
+  // events: "focus", "blur", "resize", "gamepadconnected" and "gamepaddisconnected". A listener is called with an event
+  // object that has "type". The gamepad events also have "gamepad" with "index". The drop event also has "files", an
+  // array of the objects with "name" and "data" as a Uint8Array.
+  // go2cpp.hidden is true while the application is paused, like document.hidden.
+  go2cpp->Set("hidden", Value{false});
+
+  Go go{driver_.get()};
+  go.SetTaskPolicy(task_policy_);
+{{if .Watchdog}}  go.SetWatchdog(watchdog_timeout_, on_frozen_);
+{{end}}
+  driver_->SetEventListener([this, &go](Driver::Event event) {
+    go.EnqueueTask([this, event]() {
+      // The events between the frames are reset like a frame, so that the arena doesn't grow while no frame comes,
+      // e.g., while the app is paused.
+      frame_arena_.Reset();
+      DispatchEvent(event);
+    });
+  });
 
   go2cpp->Set("createAudio", Value{std::make_shared<Function>(
     [this, &go](Value self, std::vector<Value> args) -> Value {
@@ -562,23 +1065,176 @@ int Game::Run(const std::vector<std::string>& args) {
       return Value{std::make_shared<Audio>(&go, driver_.get())};
     })});
 
-  if (binding_) {
-    go2cpp->Set("binding", Value{std::make_shared<BindingObject>(binding_.get())});
+  // createVideo takes the source of the video and the function called with the type of the events, and returns null
+  // if video is not supported.
+  go2cpp->Set("createVideo", Value{std::make_shared<Function>(
+    [this, &go](Value self, std::vector<Value> args) -> Value {
+      auto v = std::make_shared<VideoObject>(args[1]);
+      // Capture a weak pointer in the lambda, as the VideoObject owns the player that owns the callback.
+      std::weak_ptr<VideoObject> weak = v;
+      auto player = driver_->CreateVideoPlayer(args[0].ToString(), [&go, weak](const std::string& type) {
+        go.EnqueueTask([weak, type]() {
+          if (std::shared_ptr<VideoObject> v = weak.lock()) {
+            v->InvokeOnEventCallback(type);
+          }
+        });
+      });
+      if (!player) {
+        return Value::Null();
+      }
+      v->SetPlayer(std::move(player));
+      return Value{v};
+    })});
+
+  // createUDPSocket takes the local port and the function called with the host, the port and the data as a Uint8Array
+  // of each received datagram, and returns null if the socket is not available.
+  go2cpp->Set("createUDPSocket", Value{std::make_shared<Function>(
+    [this, &go](Value self, std::vector<Value> args) -> Value {
+      auto s = std::make_shared<UDPSocketObject>(args[1]);
+      // Capture a weak pointer in the lambda, as the UDPSocketObject owns the socket that owns the callback.
+      std::weak_ptr<UDPSocketObject> weak = s;
+      auto socket = driver_->CreateUDPSocket(static_cast<int>(args[0].ToNumber()),
+        [&go, weak](const std::string& host, int port, std::vector<uint8_t> data) {
+          auto shared_data = std::make_shared<std::vector<uint8_t>>(std::move(data));
+          go.EnqueueTask([weak, host, port, shared_data]() {
+            if (std::shared_ptr<UDPSocketObject> s = weak.lock()) {
+              s->InvokeOnReceivedCallback(host, port, *shared_data);
+            }
+          });
+        });
+      if (!socket) {
+        return Value::Null();
+      }
+      s->SetSocket(std::move(socket));
+      return Value{s};
+    })});
+
+  go2cpp->Set("submitJob", Value{std::make_shared<Function>(
+    [this, &go](Value self, std::vector<Value> args) -> Value {
+      auto promise = std::make_shared<Promise>();
+      if (args.empty() || !args[0].IsString()) {
+        promise->Reject(Value{std::make_shared<Error>("TypeError", "submitJob: the job name must be a string")});
+        return Value{promise};
+      }
+      std::vector<uint8_t> payload;
+      if (args.size() > 1 && !args[1].IsUndefined()) {
+        if (!args[1].IsBytes()) {
+          promise->Reject(Value{std::make_shared<Error>("TypeError", "submitJob: the payload must be a Uint8Array")});
+          return Value{promise};
+        }
+        BytesSpan bytes = args[1].ToBytes();
+        payload.assign(bytes.begin(), bytes.end());
+      }
+      const std::string& name = args[0].ToString();
+      bool ok = worker_pool_.Submit(name, std::move(payload),
+        [&go, promise](std::string error, std::vector<uint8_t> result) {
+          auto shared_result = std::make_shared<std::vector<uint8_t>>(std::move(result));
+          go.EnqueueTask([promise, error, shared_result]() {
+            if (!error.empty()) {
+              promise->Reject(Value{std::make_shared<Error>(error)});
+              return;
+            }
+            auto u8 = std::make_shared<Uint8Array>(shared_result->size());
+            if (!shared_result->empty()) {
+              std::memcpy(u8->ToBytes().data(), shared_result->data(), shared_result->size());
+            }
+            promise->Resolve(Value{u8});
+          });
+        });
+      if (!ok) {
+        promise->Reject(Value{std::make_shared<Error>("submitJob: no such job: " + name)});
+      }
+      return Value{promise};
+    })});
+
+  // showOpenFilePicker takes an object with "multiple" and "accept", and returns a promise of an array of the objects
+  // with "name" and "data" as a Uint8Array. The array is empty when the user cancels the dialog. showSaveFilePicker
+  // takes the suggested name and the data as a Uint8Array, and returns a promise of whether the file is saved.
+  go2cpp->Set("showOpenFilePicker", Value{std::make_shared<Function>(
+    [this, &go](Value self, std::vector<Value> args) -> Value {
+      FileDialogOptions options;
+      if (!args.empty() && args[0].IsObject()) {
+        auto& obj = args[0].ToObject();
+        Value multiple = obj.Get("multiple");
+        if (multiple.IsBool()) {
+          options.multiple = multiple.ToBool();
+        }
+        Value accept = obj.Get("accept");
+        if (accept.IsArray()) {
+          for (auto& a : accept.ToArray()) {
+            if (a.IsString()) {
+              options.accept.push_back(a.ToString());
+            }
+          }
+        }
+      }
+      auto promise = std::make_shared<Promise>();
+      driver_->OpenFileDialog(options, [this, &go, promise](std::vector<File> files) {
+        auto shared_files = std::make_shared<std::vector<File>>(std::move(files));
+        go.EnqueueTask([this, promise, shared_files]() {
+          promise->Resolve(NewFilesValue(*shared_files));
+        });
+      });
+      return Value{promise};
+    })});
+  go2cpp->Set("showSaveFilePicker", Value{std::make_shared<Function>(
+    [this, &go](Value self, std::vector<Value> args) -> Value {
+      std::string name;
+      if (!args.empty() && args[0].IsString()) {
+        name = args[0].ToString();
+      }
+      std::vector<uint8_t> data;
+      if (args.size() > 1 && args[1].IsBytes()) {
+        BytesSpan bytes = args[1].ToBytes();
+        data.assign(bytes.begin(), bytes.end());
+      }
+      auto promise = std::make_shared<Promise>();
+      driver_->SaveFileDialog(name, std::move(data), [&go, promise](bool saved) {
+        go.EnqueueTask([promise, saved]() {
+          promise->Resolve(Value{saved});
+        });
+      });
+      return Value{promise};
+    })});
+
+  go2cpp->Set("binding", Value{std::make_shared<BindingObject>(binding_.get(),
+    [this](const std::string& name) -> BindingFunc {
+      return FindFunc(name);
+    })});
+
+  if (frame_stats_enabled_) {
+    frame_stats_ = std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+      {"cpuFrameTime", Value{0.0}},
+      {"taskCount", Value{0.0}},
+      {"finalizedValueCount", Value{0.0}},
+      {"audioUnderrunCount", Value{0.0}},
+    });
+    go2cpp->Set("stats", Value{frame_stats_});
+    frame_cpu_time_ = std::chrono::steady_clock::duration{0};
+    last_task_count_ = 0;
+    last_finalized_value_count_ = 0;
+    last_audio_underrun_count_ = driver_->GetAudioUnderrunCount();
   }
 
+  driver_->SetVsyncEnabled(frame_pacing_.vsync);
+  start_time_ = std::chrono::steady_clock::now();
+  next_frame_time_ = start_time_;
+  frame_count_ = 0;
+
   global.Set("requestAnimationFrame",
              Value{std::make_shared<Function>(
                  [this, &go](Value self, std::vector<Value> args) -> Value {
-                   Value f = args[0];
-                   go.EnqueueTask([this, f]() {
-                     driver_->Update([this, f]() mutable {
-                       Update(f);
-                     });
-                   });
+                   RequestAnimationFrame(&go, args[0]);
                    return Value{};
                  })});
 
   int code = go.Run(args);
+  // The jobs refer to go.
+  worker_pool_.Wait();
+  frame_timer_.reset();
+  driver_->SetEventListener(nullptr);
+  dynamic_cast<EventTarget&>(global).ClearEventListeners();
+  dynamic_cast<EventTarget&>(*go2cpp).ClearEventListeners();
   if (is_audio_opened_) {
     driver_->CloseAudio();
   }
@@ -591,14 +1247,254 @@ int Game::Run(const std::vector<std::string>& args) {
   return code;
 }
 
-void Game::Update(Value f) {
+void Game::SetFramePacing(const FramePacing& frame_pacing) {
+  frame_pacing_ = frame_pacing;
+}
+
+void Game::SetTaskPolicy(const Go::TaskPolicy& task_policy) {
+  task_policy_ = task_policy;
+}
+
+void Game::SetFrameStatsEnabled(bool enabled) {
+  frame_stats_enabled_ = enabled;
+}
+{{if .Watchdog}}
+void Game::SetWatchdog(std::chrono::milliseconds timeout, std::function<void(const Watchdog::Report& report)> on_frozen) {
+  watchdog_timeout_ = timeout;
+  on_frozen_ = std::move(on_frozen);
+}
+{{end}}
+void Game::RegisterFunc(const std::string& name, BindingFunc fn) {
+  std::lock_guard<std::mutex> lock{binding_funcs_mutex_};
+  binding_funcs_[name] = std::move(fn);
+}
+
+void Game::UnregisterFunc(const std::string& name) {
+  std::lock_guard<std::mutex> lock{binding_funcs_mutex_};
+  binding_funcs_.erase(name);
+}
+
+WorkerPool* Game::GetWorkerPool() {
+  return &worker_pool_;
+}
+
+ValueArena* Game::GetFrameArena() {
+  return &frame_arena_;
+}
+
+Game::BindingFunc Game::FindFunc(const std::string& name) {
+  std::lock_guard<std::mutex> lock{binding_funcs_mutex_};
+  auto it = binding_funcs_.find(name);
+  if (it == binding_funcs_.end()) {
+    return nullptr;
+  }
+  return it->second;
+}
+
+void Game::RequestAnimationFrame(Go* go, Value f) {
+  using namespace std::chrono;
+
+  steady_clock::duration interval{0};
+  bool fixed = frame_pacing_.fixed_timestep.count() > 0;
+  if (fixed) {
+    interval = frame_pacing_.fixed_timestep;
+  } else if (frame_pacing_.max_fps > 0) {
+    interval = duration_cast<steady_clock::duration>(duration<double>(1.0 / frame_pacing_.max_fps));
+  }
+{{if .Deterministic}}
+  // In the deterministic mode, the frames run on the virtual clock of Go instead of the real time, so a frame is never
+  // delayed, and the timestamp is the virtual time.
+  int64_t frame_time = std::max(go->GetVirtualTime(), next_virtual_frame_time_);
+  next_virtual_frame_time_ = frame_time + duration_cast<nanoseconds>(interval).count();
+
+  double timestamp;
+  if (fixed) {
+    timestamp = duration<double, std::milli>(interval * frame_count_).count();
+  } else {
+    timestamp = static_cast<double>(frame_time) / 1e6;
+  }
+  frame_count_++;
+
+  go->EnqueueTaskAt(frame_time, [this, go, f, timestamp]() {
+    driver_->Update([this, go, f, timestamp]() mutable {
+      Update(go, f, timestamp);
+    });
+  }, TaskQueue::Priority::kFrame);
+{{else}}
+  steady_clock::time_point now = steady_clock::now();
+  steady_clock::time_point frame_time = now;
+  if (interval.count() > 0) {
+    if (next_frame_time_ < now) {
+      // The frame is delayed. Only the fixed-timestep mode catches up.
+      if (!fixed || now - next_frame_time_ > interval * frame_pacing_.max_catch_up_frames) {
+        next_frame_time_ = now;
+      }
+    } else {
+      frame_time = next_frame_time_;
+    }
+    next_frame_time_ += interval;
+  }
+
+  double timestamp;
+  if (fixed) {
+    timestamp = duration<double, std::milli>(interval * frame_count_).count();
+  } else {
+    timestamp = duration<double, std::milli>(frame_time - start_time_).count();
+  }
+  frame_count_++;
+
+  auto task = [this, go, f, timestamp]() {
+    driver_->Update([this, go, f, timestamp]() mutable {
+      Update(go, f, timestamp);
+    });
+  };
+  if (frame_time <= now) {
+    go->EnqueueTask(task, TaskQueue::Priority::kFrame);
+    return;
+  }
+  double delay = duration<double, std::milli>(frame_time - now).count();
+  frame_timer_ = std::make_unique<Timer>([go, task]() {
+    go->EnqueueTask(task, TaskQueue::Priority::kFrame);
+  }, delay);
+{{end}}}
+
+void Game::Update(Go* go, Value f, double timestamp) {
   auto& global = Value::Global().ToObject();
   auto& go2cpp = global.Get("go2cpp").ToObject();
 
+  frame_arena_.Reset();
+
   touches_ = driver_->GetTouches();
   go2cpp.Set("touchCount", Value{static_cast<double>(touches_.size())});
 
-  f.ToObject().Invoke(Value{}, {});
+  if (!frame_stats_) {
+    f.ToObject().Invoke(Value{}, {Value{timestamp}});
+    return;
+  }
+
+  UpdateFrameStats(go);
+  auto start = std::chrono::steady_clock::now();
+  f.ToObject().Invoke(Value{}, {Value{timestamp}});
+  frame_cpu_time_ = std::chrono::steady_clock::now() - start;
+}
+
+void Game::UpdateFrameStats(Go* go) {
+  Go::Stats stats = go->GetStats();
+  uint64_t underruns = driver_->GetAudioUnderrunCount();
+
+  frame_stats_->Set("cpuFrameTime", Value{std::chrono::duration<double, std::milli>(frame_cpu_time_).count()});
+  frame_stats_->Set("taskCount", Value{static_cast<double>(stats.task_count - last_task_count_)});
+  frame_stats_->Set("finalizedValueCount",
+                    Value{static_cast<double>(stats.finalized_value_count - last_finalized_value_count_)});
+  frame_stats_->Set("audioUnderrunCount", Value{static_cast<double>(underruns - last_audio_underrun_count_)});
+
+  last_task_count_ = stats.task_count;
+  last_finalized_value_count_ = stats.finalized_value_count;
+  last_audio_underrun_count_ = underruns;
+}
+
+void Game::DispatchEvent(const Driver::Event& e) {
+  const std::string& type = e.type;
+  int gamepad_id = e.gamepad_id;
+
+  auto& global = Value::Global().ToObject();
+  auto& go2cpp = global.Get("go2cpp").ToObject();
+
+  if (type == "pause") {
+    go2cpp.Set("hidden", Value{true});
+  } else if (type == "resume") {
+    go2cpp.Set("hidden", Value{false});
+  } else if (type == "webglcontextlost") {
+    gl_->SetContextLost(true);
+  } else if (type == "webglcontextrestored") {
+    gl_->LoadFunctions();
+    gl_->SetContextLost(false);
+  } else if (type == "resize") {
+    go2cpp.Set("screenWidth", Value{static_cast<double>(driver_->GetScreenWidth())});
+    go2cpp.Set("screenHeight", Value{static_cast<double>(driver_->GetScreenHeight())});
+    go2cpp.Set("devicePixelRatio", Value{driver_->GetDevicePixelRatio()});
+  }
+
+  Value event{std::make_shared<DictionaryValues>()};
+  event.ToObject().Set("type", Value{type});
+  if (type == "gamepadconnected" || type == "gamepaddisconnected") {
+    Value gamepad;
+    if (type == "gamepadconnected") {
+      for (auto& g : driver_->GetGamepads()) {
+        if (g.id == gamepad_id) {
+          gamepad = NewGamepadValue(g);
+          break;
+        }
+      }
+    }
+    // The driver might not report the gamepad, e.g., after it is disconnected.
+    if (gamepad.IsUndefined()) {
+      gamepad = Value{std::make_shared<DictionaryValues>()};
+      gamepad.ToObject().Set("index", Value{static_cast<double>(gamepad_id)});
+      gamepad.ToObject().Set("connected", Value{type == "gamepadconnected"});
+    }
+    event.ToObject().Set("gamepad", gamepad);
+  }
+  if (type == "drop") {
+    event.ToObject().Set("files", NewFilesValue(e.files));
+  }
+
+  if (type == "focus" || type == "blur" || type == "resize" || type == "gamepadconnected" ||
+      type == "gamepaddisconnected") {
+    dynamic_cast<EventTarget&>(global).DispatchEvent(type, event);
+    return;
+  }
+  dynamic_cast<EventTarget&>(go2cpp).DispatchEvent(type, event);
+}
+
+Value Game::NewGamepadValue(const Gamepad& gamepad) {
+  Value value{std::make_shared<DictionaryValues>()};
+  auto& obj = value.ToObject();
+  obj.Set("index", Value{static_cast<double>(gamepad.id)});
+  obj.Set("id", Value{"go2cpp gamepad " + std::to_string(gamepad.id)});
+  obj.Set("mapping", Value{gamepad.standard ? "standard" : ""});
+  obj.Set("connected", Value{true});
+
+  Value axes{std::vector<Value>(gamepad.axis_count)};
+  for (size_t i = 0; i < axes.ToArray().size(); i++) {
+    axes.ToArray()[i] = Value{gamepad.axes[i]};
+  }
+  obj.Set("axes", axes);
+
+  Value buttons{std::vector<Value>(gamepad.button_count)};
+  for (size_t i = 0; i < buttons.ToArray().size(); i++) {
+    Value button{std::make_shared<DictionaryValues>()};
+    button.ToObject().Set("pressed", Value{gamepad.button_pressed[i]});
+    button.ToObject().Set("value", Value{gamepad.button_values[i]});
+    buttons.ToArray()[i] = button;
+  }
+  obj.Set("buttons", buttons);
+
+  if (gamepad.has_vibration) {
+    Value& actuator = vibration_actuators_[gamepad.id];
+    if (!actuator.IsObject()) {
+      actuator = Value{std::make_shared<GamepadVibrationActuator>(driver_.get(), gamepad.id)};
+    }
+    obj.Set("vibrationActuator", actuator);
+  } else {
+    obj.Set("vibrationActuator", Value::Null());
+  }
+  return value;
+}
+
+Value Game::NewFilesValue(const std::vector<File>& files) {
+  std::vector<Value> values;
+  for (auto& f : files) {
+    auto data = std::make_shared<Uint8Array>(f.data.size());
+    if (!f.data.empty()) {
+      std::memcpy(data->ToBytes().data(), f.data.data(), f.data.size());
+    }
+    values.push_back(Value{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+      {"name", Value{f.name}},
+      {"data", Value{data}},
+    })});
+  }
+  return Value{values};
 }
 
 Game::Binding::~Binding() = default;
@@ -5,16 +5,23 @@ package gowasm2cpp
 import (
 	"bytes"
 	"fmt"
+	"hash/fnv"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 
-	"github.com/go-interpreter/wagon/wasm"
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+	"github.com/hajimehoshi/go2cpp/internal/wat"
 	"golang.org/x/sync/errgroup"
 )
 
+// maxIdentifierLength is the maximum length of an identifier made by identifierFromString.
+const maxIdentifierLength = 512
+
 func identifierFromString(str string) string {
 	var ident string
 	for _, r := range []rune(str) {
@@ -35,14 +42,19 @@ func identifierFromString(str string) string {
 		}
 		ident += fmt.Sprintf("_%02x", r)
 	}
-	if len(ident) > 512 {
-		ident = ident[:511]
+	// Too long identifiers are not portable, and the instantiations of generics can have very long names. Truncate
+	// such a name and add the hash of the original name so that names with the same prefix don't collide.
+	if len(ident) > maxIdentifierLength {
+		h := fnv.New64a()
+		h.Write([]byte(str))
+		suffix := fmt.Sprintf("_%016x", h.Sum64())
+		ident = ident[:maxIdentifierLength-len(suffix)] + suffix
 	}
 	return ident
 }
 
 func includeGuard(str string) string {
-	return strings.ToUpper(str)
+	return strings.ToUpper(strings.ReplaceAll(str, "::", "_"))
 }
 
 type wasmFunc struct {
@@ -55,6 +67,23 @@ type wasmFunc struct {
 	Index   int
 	Import  bool
 	BodyStr string
+
+	// lines and size are the number of the lines and the bytes of the generated function before formatting. These
+	// are set by CppImpl.
+	lines int
+	size  int
+
+	// maxLines is the size budget of the generated function. If maxLines is 0, there is no budget.
+	maxLines int
+
+	// cache is the cache of the translated bodies. If cache is nil, no cache is used.
+	cache *bodyCache
+
+	// marker reports whether GO2CPP_FUNCTION_MARKER is put at the beginning of the function for Watchdog.
+	marker bool
+
+	// importModule is the module name of the import like "gojs". This is used only for the imports.
+	importModule string
 }
 
 func (f *wasmFunc) Identifier() string {
@@ -63,12 +92,13 @@ func (f *wasmFunc) Identifier() string {
 
 var funcDeclTmpl = template.Must(template.New("funcDecl").Parse(`// OriginalName: {{.OriginalName}}
 // Index:        {{.Index}}
-{{if .Abstract}}virtual {{end}}{{.ReturnType}} {{.Name}}({{.Args}}){{if .Abstract}} = 0{{end}}{{if .Override}} override{{end}};`))
+{{if .Abstract}}virtual {{end}}{{with .Attr}}{{.}} {{end}}{{.ReturnType}} {{.Name}}({{.Args}}){{if .Abstract}} = 0{{end}}{{if .Override}} override{{end}};`))
 
 var funcImplTmpl = template.Must(template.New("func").Parse(`// OriginalName: {{.OriginalName}}
 // Index:        {{.Index}}
 {{.ReturnType}} {{.Class}}::{{.Name}}({{.Args}}) {
-{{range .Locals}}  {{.}}
+{{if .Marker}}  GO2CPP_FUNCTION_MARKER({{.Index}});
+{{end}}{{range .Locals}}  {{.}}
 {{end}}{{if .Locals}}
 {{end}}{{range .Body}}{{.}}
 {{end}}}`))
@@ -104,11 +134,17 @@ func (f *wasmFunc) CppDecl(indent string, abstract bool, override bool) (string,
 		args = append(args, fmt.Sprintf("%s local%d_", wasmTypeToReturnType(t).Cpp(), i))
 	}
 
+	p, err := f.purity()
+	if err != nil {
+		return "", err
+	}
+
 	var buf bytes.Buffer
 	if err := funcDeclTmpl.Execute(&buf, struct {
 		OriginalName string
 		Name         string
 		Index        int
+		Attr         string
 		ReturnType   string
 		Args         string
 		Abstract     bool
@@ -117,6 +153,7 @@ func (f *wasmFunc) CppDecl(indent string, abstract bool, override bool) (string,
 		OriginalName: f.Wasm.Name,
 		Name:         identifierFromString(f.Wasm.Name),
 		Index:        f.Index,
+		Attr:         p.Cpp(),
 		ReturnType:   retType.Cpp(),
 		Args:         strings.Join(args, ", "),
 		Abstract:     abstract,
@@ -154,25 +191,15 @@ func (f *wasmFunc) CppImpl(className string, indent string) (string, error) {
 	if f.BodyStr != "" {
 		body = strings.Split(f.BodyStr, "\n")
 	} else if f.Wasm.Body != nil {
-		idx := len(f.Wasm.Sig.ParamTypes)
-		for _, e := range f.Wasm.Body.Locals {
-			for i := 0; i < int(e.Count); i++ {
-				locals = append(locals, fmt.Sprintf("%s local%d_ = 0;", wasmTypeToReturnType(e.Type).Cpp(), idx))
-				idx++
-			}
-		}
 		var err error
-		body, err = f.bodyToCpp()
+		locals, body, err = f.translateBody()
 		if err != nil {
 			return "", err
 		}
-		locals = removeUnusedLocalVariables(locals, body)
 	} else {
-		// TODO: Use error function.
-		ident := identifierFromString(f.Wasm.Name)
 		body = []string{
-			fmt.Sprintf(`  std::cerr << "%s not implemented" << std::endl;`, ident),
-			"  std::exit(1);"}
+			fmt.Sprintf(`  Trap(%s);`, cppStringLiteral(f.Wasm.Name+" not implemented")),
+		}
 	}
 
 	var buf bytes.Buffer
@@ -185,6 +212,7 @@ func (f *wasmFunc) CppImpl(className string, indent string) (string, error) {
 		Args         string
 		Locals       []string
 		Body         []string
+		Marker       bool
 	}{
 		OriginalName: f.Wasm.Name,
 		Name:         identifierFromString(f.Wasm.Name),
@@ -194,6 +222,7 @@ func (f *wasmFunc) CppImpl(className string, indent string) (string, error) {
 		Args:         strings.Join(args, ", "),
 		Locals:       locals,
 		Body:         body,
+		Marker:       f.marker,
 	}); err != nil {
 		return "", err
 	}
@@ -203,7 +232,49 @@ func (f *wasmFunc) CppImpl(className string, indent string) (string, error) {
 	for _, line := range strings.Split(buf.String(), "\n") {
 		lines = append(lines, indent+line)
 	}
-	return strings.Join(lines, "\n") + "\n", nil
+	impl := strings.Join(lines, "\n") + "\n"
+	f.lines = len(lines)
+	f.size = len(impl)
+	if f.maxLines > 0 && f.lines > f.maxLines {
+		return "GO2CPP_LARGE_FUNCTION_BEGIN\n" + impl + "GO2CPP_LARGE_FUNCTION_END\n", nil
+	}
+	return impl, nil
+}
+
+// translateBody returns the local variable declarations and the body of the function. The result is reused if it is
+// in the cache.
+func (f *wasmFunc) translateBody() ([]string, []string, error) {
+	var key string
+	if f.cache != nil {
+		key = f.cache.key(f)
+		if b, ok := f.cache.load(key); ok {
+			return b.Locals, b.Body, nil
+		}
+	}
+
+	var locals []string
+	idx := len(f.Wasm.Sig.ParamTypes)
+	for _, e := range f.Wasm.Body.Locals {
+		for i := 0; i < int(e.Count); i++ {
+			locals = append(locals, fmt.Sprintf("%s local%d_ = 0;", wasmTypeToReturnType(e.Type).Cpp(), idx))
+			idx++
+		}
+	}
+	body, err := f.bodyToCpp()
+	if err != nil {
+		return nil, nil, err
+	}
+	locals = removeUnusedLocalVariables(locals, body)
+
+	if f.cache != nil {
+		if err := f.cache.store(key, &cachedBody{
+			Locals: locals,
+			Body:   body,
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return locals, body, nil
 }
 
 var (
@@ -245,6 +316,12 @@ type wasmExport struct {
 	Funcs []*wasmFunc
 	Index int
 	Name  string
+
+	// cppName is the name of the function in Inst and Library. See nameExports.
+	cppName string
+
+	// override is true when the export overrides a function of Instance.
+	override bool
 }
 
 func (e *wasmExport) CppDecl(indent string) (string, error) {
@@ -265,7 +342,11 @@ func (e *wasmExport) CppDecl(indent string) (string, error) {
 		args = append(args, fmt.Sprintf("%s arg%d", wasmTypeToReturnType(t).Cpp(), i))
 	}
 
-	str := fmt.Sprintf(`%s %s(%s);`, retType.Cpp(), e.Name, strings.Join(args, ", "))
+	var override string
+	if e.override {
+		override = " override"
+	}
+	str := fmt.Sprintf(`%s %s(%s)%s;`, retType.Cpp(), e.cppName, strings.Join(args, ", "), override)
 
 	lines := strings.Split(str, "\n")
 	for i := range lines {
@@ -299,7 +380,7 @@ func (e *wasmExport) CppImpl(indent string) (string, error) {
 	str := fmt.Sprintf(`%s Inst::%s(%s) {
   %s%s(%s);
 }
-`, retType.Cpp(), e.Name, strings.Join(args, ", "), ret, identifierFromString(f.Wasm.Name), strings.Join(argsToPass, ", "))
+`, retType.Cpp(), e.cppName, strings.Join(args, ", "), ret, identifierFromString(f.Wasm.Name), strings.Join(argsToPass, ", "))
 
 	lines := strings.Split(str, "\n")
 	for i := range lines {
@@ -341,76 +422,341 @@ func (t *wasmType) Cpp() (string, error) {
 	return fmt.Sprintf("%s (Inst::*)(%s)", retType.Cpp(), strings.Join(args, ", ")), nil
 }
 
+// Options represents optional settings for GenerateWithOptions.
+//
+// The options are validated against each other and the module. An invalid option is reported as an *OptionError.
+type Options struct {
+	// Assets are binary data embedded into the generated code.
+	Assets []Asset
+
+	// CppStd is the C++ standard that the generated code targets: "c++14", "c++17" or "c++20".
+	// The default is "c++14". With "c++20", std::span and std::bit_cast are used.
+	CppStd string
+
+	// ExportMacro is the name of a macro put on the public classes like Go and Game, e.g. "MYLIB_API".
+	// The macro is defined in config.h: it is dllexport or the default visibility when GO2CPP_BUILD_SHARED is
+	// defined, dllimport on Windows when GO2CPP_USE_SHARED is defined, and empty otherwise.
+	// If ExportMacro is empty, no macro is used.
+	ExportMacro string
+
+	// Style is the formatting style of the generated code. If Style is nil, DefaultStyle() is used.
+	Style *Style
+
+	// CallGraph is the path of the report of the translated functions with their Go names, the lines of the
+	// generated code and the called functions. The format is JSON if the extension is ".json", and DOT otherwise.
+	// If CallGraph is empty, no report is written.
+	CallGraph string
+
+	// SizeReport is the path of the report of the sizes per Go package: the number of the functions, the bytes of the
+	// WebAssembly code, and the bytes and the lines of the generated code. The data segments have no Go names, so only
+	// their total size is reported. The format is JSON if the extension is ".json", and a text table otherwise.
+	// If SizeReport is empty, no report is written.
+	SizeReport string
+
+	// MaxFunctionLines is the size budget of a generated function in lines. The functions over the budget are
+	// reported with Warnf. With GO2CPP_DEOPTIMIZE_LARGE_FUNCTIONS, they are not optimized by MSVC as its optimizer
+	// might fail. If MaxFunctionLines is 0, there is no budget.
+	MaxFunctionLines int
+
+	// Stubs are the functions stubbed out instead of being translated. If a function matches multiple stubs, the
+	// first one is used.
+	Stubs []Stub
+
+	// Scaffold is the platform of the project written around the generated code: "android" for a Gradle project
+	// with NativeActivity, or "ios" for a CMake project generating an Xcode project with UIKit. The generated code is put in the project's source directory, and the project files are
+	// written only when they don't exist so that they can be edited. If Scaffold is empty, no project is written.
+	Scaffold string
+
+	// CacheDir is the directory to cache the translated function bodies across runs. A cached body is reused when
+	// the function, the module-wide information like the function names and the generator are not changed. The
+	// directory can be removed at any time. If CacheDir is empty, no cache is used.
+	CacheDir string
+
+	// Exports are the names of the exports to translate, e.g. for a library used without the Go runtime loop. Only the
+	// functions reachable from them are translated. A call_indirect can reach all the functions in the table with the
+	// same signature, so a Go program's exports reach most of the runtime. If Inst doesn't implement Instance with the
+	// remaining exports or they don't reach syscall/js, only Inst, Mem and their dependencies are generated, and the
+	// reachable imports are implemented by a subclass of Import unless Library implements them. If Exports is empty,
+	// all the exports are translated.
+	Exports []string
+
+	// ExportNames maps the names of exports to the names of the functions in Inst and Library, e.g. {"event":
+	// "OnEvent"}. Without a mapping, an export has its own name with the invalid characters escaped, and an underscore
+	// is appended while the name conflicts with a C++ keyword like "delete", a member of Inst or Library, or another
+	// export. The exports that Instance declares cannot be renamed. A mapped name must not conflict.
+	ExportNames map[string]string
+
+	// Deterministic makes the Go program take the time and the random values from DeterministicSource in host.h
+	// instead of HostServices, and runs the timeouts and the frames on its virtual clock. The floating-point operations
+	// of WebAssembly are deterministic as long as the C++ compiler keeps IEEE 754 semantics: go.cpp fails to compile
+	// with -ffast-math or with the x87 instructions, and the translated functions are compiled without FMA contraction.
+	Deterministic bool
+
+	// Record makes Go call Hooks::on_import at every import call and Hooks::on_event at every event from the host, and
+	// generates Recorder and Replayer in record.h to record the interactions between the Go program and the host into a
+	// file and to replay them, e.g., to reproduce a bug without the original platform or device.
+	Record bool
+
+	// Intrinsics are the native C++ implementations used instead of the translations of the functions, in addition to
+	// the builtin ones like runtime.memmove. An intrinsic precedes the builtin ones with the same name. A stub
+	// precedes an intrinsic.
+	Intrinsics []Intrinsic
+
+	// ImportFuncs are the C++ implementations of the functions imported from the modules other than Go's, e.g., "env".
+	// Such an import is named like "env.foo" in the generated code and in Stubs, and an import without an
+	// implementation exits the program when it is called.
+	ImportFuncs []ImportFunc
+
+	// DisableIntrinsics disables all the intrinsics including the builtin ones, so that all the functions are
+	// translated. This is useful for conformance testing.
+	DisableIntrinsics bool
+
+	// Schema describes the structs passed between Go and C++. If Schema is not nil, schema.h and schema.cpp are
+	// generated with the C++ structs and the views reading them in place.
+	Schema *Schema
+
+	// SchemaGo is the path of the Go file with the Go structs of Schema, which encode and decode the same bytes as
+	// the C++ code. If SchemaGo is empty, no Go file is written.
+	SchemaGo string
+
+	// Watchdog generates Watchdog in watchdog.h to report the functions being executed when an update doesn't end in
+	// time, e.g., by an infinite loop. A marker is put at the beginning of every translated function to record the
+	// function being executed, and is enabled without NDEBUG or with GO2CPP_FUNCTION_MARKERS. Go::SetWatchdog checks
+	// the tasks of the Go program including the frames.
+	Watchdog bool
+
+	// Modules writes module.cppm, the interface unit of a C++20 module that is a facade of the headers: it exports the
+	// namespace-scope names declared in the public headers, so that the code using the generated code can import the
+	// module instead of including the headers. The generated code itself is not split into modules and is compiled as
+	// before. The module name is the namespace with "::" replaced with ".". Modules requires "c++20" as CppStd. This is
+	// experimental.
+	Modules bool
+
+	// Emscripten makes the generated code for Emscripten, e.g., to compile it back to WebAssembly and compare its
+	// behavior with the original module to test the translation. config.h fails to compile with Emscripten without
+	// pthreads, which the timers require. Emscripten cannot be used with Scaffold.
+	Emscripten bool
+
+	// Warnf is called with warnings like the functions over MaxFunctionLines. If Warnf is nil, the warnings are
+	// ignored.
+	Warnf func(format string, args ...interface{})
+}
+
+// Generate generates C++ files from wasmFile into outDir.
+//
+// wasmFile is a WebAssembly binary file, or a WebAssembly text file if the extension is ".wat".
 func Generate(outDir string, include string, wasmFile string, namespace string) error {
-	f, err := os.Open(wasmFile)
+	return GenerateWithOptions(outDir, include, wasmFile, namespace, nil)
+}
+
+func GenerateWithOptions(outDir string, include string, wasmFile string, namespace string, options *Options) error {
+	wasmBytes, err := readModule(wasmFile)
+	if err != nil {
+		return err
+	}
+	return generate(outDir, include, wasmBytes, namespace, options)
+}
+
+// readModule reads the WebAssembly binary of the file at path. A text file is assembled into the binary.
+func readModule(path string) ([]byte, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(path) != ".wat" {
+		return src, nil
+	}
+	b, err := wat.Assemble(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return b, nil
+}
+
+// warnLargeFunctions reports the functions over the size budget maxLines in descending order of the lines.
+func warnLargeFunctions(funcs []*wasmFunc, maxLines int, warnf func(format string, args ...interface{})) {
+	var large []*wasmFunc
+	for _, f := range funcs {
+		if f.lines > maxLines {
+			large = append(large, f)
+		}
+	}
+	sort.Slice(large, func(i, j int) bool {
+		if large[i].lines != large[j].lines {
+			return large[i].lines > large[j].lines
+		}
+		return large[i].Wasm.Name < large[j].Wasm.Name
+	})
+	for _, f := range large {
+		warnf("%s: the generated function has %d lines, which is over the budget %d", f.Wasm.Name, f.lines, maxLines)
+	}
+}
+
+// group is an errgroup.Group that converts panics in the functions to errors.
+type group struct {
+	errgroup.Group
+}
+
+func (g *group) Go(f func() error) {
+	g.Group.Go(func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("failed to translate the module: %v", r)
+			}
+		}()
+		return f()
+	})
+}
+
+// generate generates C++ files from the WebAssembly module wasmBytes.
+//
+// The module might be malformed or adversarial. generate returns an error instead of panicking in this case.
+func generate(outDir string, include string, wasmBytes []byte, namespace string, options *Options) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to translate the module: %v", r)
+		}
+	}()
+
+	if options == nil {
+		options = &Options{}
+	}
+	if err := validateOptions(options, namespace); err != nil {
+		return err
+	}
+
+	mod, err := wasm.Decode(wasmBytes)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	if err := checkModule(mod); err != nil {
+		return err
+	}
+	if err := validateOptionsWithModule(options, mod); err != nil {
+		return err
+	}
 
-	mod, err := wasm.DecodeModule(f)
+	h := fnv.New64a()
+	h.Write(wasmBytes)
+	info, err := newBuildInfo(mod, h.Sum64())
 	if err != nil {
 		return err
 	}
 
+	// types is indexed by the type indices of the module, and the types with the same signature share one wasmType.
+	// As call_indirect checks the signature structurally, a function must be found in the table via any of
+	// the identical types.
 	var types []*wasmType
-	for i, e := range mod.Types.Entries {
+	var uniqueTypes []*wasmType
+	typesBySig := map[string]*wasmType{}
+	for i, e := range mod.Types {
 		e := e
-		types = append(types, &wasmType{
+		sig := sigString(&e)
+		if t, ok := typesBySig[sig]; ok {
+			types = append(types, t)
+			continue
+		}
+		t := &wasmType{
 			Sig:   &e,
 			Index: i,
-		})
+		}
+		typesBySig[sig] = t
+		types = append(types, t)
+		uniqueTypes = append(uniqueTypes, t)
 	}
 
 	var globals []*wasmGlobal
-	for i, e := range mod.Global.Globals {
+	for i, e := range mod.Globals {
 		// TODO: Consider mutability.
-		// TODO: Use e.Type.Init.
+		// TODO: Use e.Init.
 		globals = append(globals, &wasmGlobal{
-			Type:  e.Type.Type,
+			Type:  e.Type,
 			Index: i,
 			Init:  0,
 		})
 	}
 
+	usedStubs := map[int]struct{}{}
+	usedImportFuncs := map[int]struct{}{}
+
 	var ifs []*wasmFunc
-	for i, e := range mod.Import.Entries {
-		name := e.FieldName
+	for i, e := range mod.Imports {
+		name := importName(e.ModuleName, e.FieldName)
+		var bodyStr string
+		if isGoImportModule(e.ModuleName) {
+			bodyStr = importFuncBodies[name]
+		} else if j, ok := findImportFunc(options.ImportFuncs, e.ModuleName, e.FieldName); ok {
+			usedImportFuncs[j] = struct{}{}
+			bodyStr = options.ImportFuncs[j].Body
+		}
+		if stub, ok := findStub(options.Stubs, name, usedStubs); ok {
+			bodyStr = stubBody(stub, name, types[e.Type].Sig)
+		} else if bodyStr == "" && options.Warnf != nil {
+			options.Warnf("import %s is not implemented: calling it exits the program", name)
+		} else if bodyStr != "" && strings.HasPrefix(name, "syscall/js.") {
+			if _, ok := reentrantImports[name]; ok {
+				bodyStr = scopedSPBody(bodyStr)
+			}
+			bodyStr = refCheckBody(name, bodyStr)
+		}
+		// Only the imports by Go take their arguments on the Go stack.
+		if bodyStr != "" && options.Record && isGoImportModule(e.ModuleName) {
+			b, err := recordBody(name, bodyStr)
+			if err != nil {
+				return fmt.Errorf("gowasm2cpp: %v", err)
+			}
+			bodyStr = b
+		}
 		ifs = append(ifs, &wasmFunc{
-			Type: types[e.Type.(wasm.FuncImport).Type],
+			Type: types[e.Type],
 			Wasm: wasm.Function{
-				Sig:  types[e.Type.(wasm.FuncImport).Type].Sig,
+				Sig:  types[e.Type].Sig,
 				Name: name,
 			},
-			Globals: globals,
-			Index:   i,
-			Import:  true,
-			BodyStr: importFuncBodies[name],
+			Globals:      globals,
+			Index:        i,
+			Import:       true,
+			BodyStr:      bodyStr,
+			importModule: e.ModuleName,
 		})
 	}
 
-	// There is a bug that signature and body are shifted (go-interpreter/wagon#190).
-	var names wasm.NameMap
-	if c := mod.Custom(wasm.CustomSectionName); c != nil {
-		var nsec wasm.NameSection
-		if err := nsec.UnmarshalWASM(bytes.NewReader(c.Data)); err != nil {
-			return err
+	intrinsicsByName := intrinsics(options.Intrinsics)
+	usedIntrinsics := map[string]struct{}{}
+	funcName := func(idx uint32) string {
+		if int(idx) < len(mod.Imports) {
+			return importName(mod.Imports[idx].ModuleName, mod.Imports[idx].FieldName)
 		}
-		if len(nsec.Types[wasm.NameFunction]) > 0 {
-			sub, err := nsec.Decode(wasm.NameFunction)
+		return mod.FunctionNames[idx]
+	}
+
+	var fs []*wasmFunc
+	for i, t := range mod.Functions {
+		name := mod.FunctionNames[uint32(i+len(mod.Imports))]
+		var bodyStr string
+		var ok bool
+		if candidates, found := intrinsicsByName[name]; found && !options.DisableIntrinsics {
+			usedIntrinsics[name] = struct{}{}
+			in, found, err := findIntrinsic(candidates, types[t].Sig, func() (string, error) {
+				return codeHash(&mod.Codes[i], funcName, func(idx uint32) *wasm.FunctionSig {
+					return types[idx].Sig
+				})
+			})
 			if err != nil {
-				return err
+				return fmt.Errorf("gowasm2cpp: %s: %v", name, err)
+			}
+			if found {
+				bodyStr, ok = in.Body, true
+			} else if options.Warnf != nil {
+				options.Warnf("intrinsic %s is not used: no intrinsic matches the signature %s and the code", name, sigString(types[t].Sig))
 			}
-			names = sub.(*wasm.FunctionNames).Names
 		}
-	}
-	var fs []*wasmFunc
-	for i, t := range mod.Function.Types {
-		name := names[uint32(i+len(mod.Import.Entries))]
-		bodyStr, ok := specialFunctionBodies[name]
+		if stub, found := findStub(options.Stubs, name, usedStubs); found {
+			bodyStr, ok = stubBody(stub, name, types[t].Sig), true
+		}
 		var body *wasm.FunctionBody
 		if !ok {
-			body = &mod.Code.Bodies[i]
+			body = &mod.Codes[i]
 		}
 		fs = append(fs, &wasmFunc{
 			Type: types[t],
@@ -420,13 +766,31 @@ func Generate(outDir string, include string, wasmFile string, namespace string)
 				Name: name,
 			},
 			Globals: globals,
-			Index:   i + len(mod.Import.Entries),
+			Index:   i + len(mod.Imports),
 			BodyStr: bodyStr,
 		})
 	}
 
+	if options.Warnf != nil {
+		for i, s := range options.Stubs {
+			if _, ok := usedStubs[i]; !ok {
+				options.Warnf("stub %s matches no functions", s.Name)
+			}
+		}
+		for _, in := range options.Intrinsics {
+			if _, ok := usedIntrinsics[in.Name]; !ok && !options.DisableIntrinsics {
+				options.Warnf("intrinsic %s matches no functions", in.Name)
+			}
+		}
+		for i, f := range options.ImportFuncs {
+			if _, ok := usedImportFuncs[i]; !ok {
+				options.Warnf("import function %s matches no imports", importName(f.Module, f.Name))
+			}
+		}
+	}
+
 	var exports []*wasmExport
-	for _, e := range mod.Export.Entries {
+	for _, e := range mod.Exports {
 		switch e.Kind {
 		case wasm.ExternalFunction:
 			exports = append(exports, &wasmExport{
@@ -449,18 +813,29 @@ func Generate(outDir string, include string, wasmFile string, namespace string)
 		f.Funcs = allfs
 		f.Types = types
 	}
+	var cache *bodyCache
+	if options.CacheDir != "" {
+		c, err := newBodyCache(options.CacheDir, mod)
+		if err != nil {
+			return err
+		}
+		cache = c
+	}
 	for _, f := range fs {
 		f.Mod = mod
 		f.Funcs = allfs
 		f.Types = types
+		f.maxLines = options.MaxFunctionLines
+		f.cache = cache
+		f.marker = options.Watchdog
 	}
 
 	if mod.Start != nil {
 		return fmt.Errorf("start section must be nil but not")
 	}
 
-	tables := make([][]uint32, len(mod.Table.Entries))
-	for _, e := range mod.Elements.Entries {
+	tables := make([][]uint32, len(mod.Tables))
+	for _, e := range mod.Elements {
 		v, err := mod.ExecInitExpr(e.Offset)
 		if err != nil {
 			return err
@@ -472,8 +847,40 @@ func Generate(outDir string, include string, wasmFile string, namespace string)
 		copy(tables[e.Index][offset:], e.Elems)
 	}
 
+	var pruned []int
+	runtime := true
+	if len(options.Exports) > 0 {
+		p, err := pruneModule(ifs, fs, exports, tables, options.Exports)
+		if err != nil {
+			return err
+		}
+		ifs, fs, exports, pruned = p.importFuncs, p.funcs, p.exports, p.pruned
+		runtime = p.js
+	}
+	instance := markInstanceExports(exports)
+	if err := nameExports(exports, fs, options.ExportNames); err != nil {
+		return err
+	}
+	if len(options.Exports) > 0 {
+		// Go and the other classes for the Go runtime like Game require Inst to implement Instance. Without them, only
+		// Inst and its dependencies are generated.
+		runtime = runtime && instance
+	}
+	// A module that is not a Go program and imports only the functions Library implements runs with Library instead of
+	// the Go runtime.
+	libraryIfs, library := libraryImports(ifs)
+	library = library && !(runtime && instance)
+	runtime = runtime && !library
+	if !runtime && len(options.Assets) > 0 {
+		return optionErrorf("Assets", "the module doesn't run with the Go runtime, so the Go program cannot read the assets")
+	}
+	if !runtime && options.Scaffold != "" {
+		return optionErrorf("Scaffold", "the module doesn't run with the Go runtime, which the project requires")
+	}
+	translated := append(append([]*wasmFunc{}, ifs...), fs...)
+
 	var data []wasmData
-	for _, e := range mod.Data.Entries {
+	for _, e := range mod.Data {
 		offset, err := mod.ExecInitExpr(e.Offset)
 		if err != nil {
 			return err
@@ -492,79 +899,229 @@ func Generate(outDir string, include string, wasmFile string, namespace string)
 		}
 	}
 
-	var g errgroup.Group
-	g.Go(func() error {
-		{
-			out, err := os.Create(filepath.Join(outDir, "go.h"))
-			if err != nil {
-				return err
-			}
-			defer out.Close()
-
-			if err := goHTmpl.Execute(out, struct {
-				IncludeGuard string
-				IncludePath  string
-				Namespace    string
-				ImportFuncs  []*wasmFunc
-			}{
-				IncludeGuard: includeGuard(namespace) + "_GO_H",
-				IncludePath:  incpath,
-				Namespace:    namespace,
-				ImportFuncs:  ifs,
-			}); err != nil {
-				return err
-			}
+	if options.Scaffold != "" {
+		if err := writeScaffold(outDir, options.Scaffold, include, namespace, options.CppStd); err != nil {
+			return err
 		}
-		{
-			out, err := os.Create(filepath.Join(outDir, "go.cpp"))
-			if err != nil {
-				return err
-			}
-			defer out.Close()
-
-			if err := goCppTmpl.Execute(out, struct {
-				IncludePath string
-				Namespace   string
-				ImportFuncs []*wasmFunc
-			}{
-				IncludePath: incpath,
-				Namespace:   namespace,
-				ImportFuncs: ifs,
-			}); err != nil {
-				return err
-			}
+		outDir = filepath.Join(outDir, scaffoldSourceDir(options.Scaffold), filepath.FromSlash(include))
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return err
 		}
-		return nil
-	})
+	}
+
+	var g group
 	g.Go(func() error {
 		return writeBits(outDir, incpath, namespace)
 	})
 	g.Go(func() error {
-		return writeGame(outDir, incpath, namespace)
+		return writeConfig(outDir, incpath, namespace, options.CppStd, options.ExportMacro, options.Emscripten)
+	})
+	g.Go(func() error {
+		return writeMath(outDir, incpath, namespace)
 	})
 	g.Go(func() error {
-		return writeGL(outDir, incpath, namespace)
+		return writeLog(outDir, incpath, namespace, options.ExportMacro)
 	})
 	g.Go(func() error {
-		return writeJS(outDir, incpath, namespace)
+		return writeVersion(outDir, incpath, namespace, info)
 	})
 	g.Go(func() error {
-		return writeTaskQueue(outDir, incpath, namespace)
+		return writeSelfTest(outDir, incpath, namespace, options.ExportMacro)
 	})
 	g.Go(func() error {
-		return writeBytes(outDir, incpath, namespace)
+		return writeBytes(outDir, incpath, namespace, options.ExportMacro)
 	})
 	g.Go(func() error {
-		return writeInst(outDir, incpath, namespace, ifs, fs, exports, globals, types, tables)
+		return writeInst(outDir, incpath, namespace, ifs, fs, exports, globals, uniqueTypes, tables, instance, pruned, options.Watchdog, options.Deterministic)
 	})
+	if options.Watchdog {
+		g.Go(func() error {
+			return writeWatchdog(outDir, incpath, namespace, options.ExportMacro, translated, len(translated)+len(pruned))
+		})
+	}
 	g.Go(func() error {
-		return writeMem(outDir, incpath, namespace, int(mod.Memory.Entries[0].Limits.Initial), data)
+		return writeMem(outDir, incpath, namespace, int(mod.Memories[0].Limits.Initial), data)
 	})
+	if options.Schema != nil {
+		g.Go(func() error {
+			return writeSchema(outDir, incpath, namespace, options.ExportMacro, options.Schema)
+		})
+	}
+	if library {
+		// writeInst sorts exports concurrently.
+		exports := append([]*wasmExport{}, exports...)
+		g.Go(func() error {
+			return writeLibrary(outDir, incpath, namespace, options.ExportMacro, libraryIfs, exports)
+		})
+	}
+	// Go and the classes for the Go runtime.
+	if runtime {
+		g.Go(func() error {
+			{
+				out, err := os.Create(filepath.Join(outDir, "go.h"))
+				if err != nil {
+					return err
+				}
+				defer out.Close()
+
+				if err := goHTmpl.Execute(out, struct {
+					IncludeGuard  string
+					VersionCheck  string
+					IncludePath   string
+					Namespace     string
+					Export        string
+					ImportFuncs   []*wasmFunc
+					Deterministic bool
+					Record        bool
+					Watchdog      bool
+				}{
+					IncludeGuard:  includeGuard(namespace) + "_GO_H",
+					VersionCheck:  versionCheck(namespace, "go.h"),
+					IncludePath:   incpath,
+					Namespace:     namespace,
+					Export:        exportPrefix(options.ExportMacro),
+					ImportFuncs:   ifs,
+					Deterministic: options.Deterministic,
+					Record:        options.Record,
+					Watchdog:      options.Watchdog,
+				}); err != nil {
+					return err
+				}
+			}
+			{
+				out, err := os.Create(filepath.Join(outDir, "go.cpp"))
+				if err != nil {
+					return err
+				}
+				defer out.Close()
+
+				// CatchHostError is used only by the imports calling the host functions, e.g., syscall/js.valueCall.
+				var catchHostError bool
+				for _, f := range ifs {
+					if strings.Contains(f.BodyStr, "CatchHostError(") {
+						catchHostError = true
+						break
+					}
+				}
+				if err := goCppTmpl.Execute(out, struct {
+					IncludePath    string
+					Namespace      string
+					ImportFuncs    []*wasmFunc
+					CatchHostError bool
+					Deterministic  bool
+					Record         bool
+					Watchdog       bool
+				}{
+					IncludePath:    incpath,
+					Namespace:      namespace,
+					ImportFuncs:    ifs,
+					CatchHostError: catchHostError,
+					Deterministic:  options.Deterministic,
+					Record:         options.Record,
+					Watchdog:       options.Watchdog,
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		g.Go(func() error {
+			return writeAssets(outDir, incpath, namespace, options.ExportMacro, options.Assets)
+		})
+		g.Go(func() error {
+			return writeGame(outDir, incpath, namespace, options.ExportMacro, options.Watchdog, options.Deterministic)
+		})
+		g.Go(func() error {
+			return writeDriver(outDir, incpath, namespace, options.ExportMacro)
+		})
+		g.Go(func() error {
+			return writeGL(outDir, incpath, namespace)
+		})
+		g.Go(func() error {
+			return writeHost(outDir, incpath, namespace, options.ExportMacro, options.Deterministic)
+		})
+		g.Go(func() error {
+			return writeJS(outDir, incpath, namespace, options.ExportMacro)
+		})
+		g.Go(func() error {
+			return writeTaskQueue(outDir, incpath, namespace, options.ExportMacro)
+		})
+		if options.Record {
+			g.Go(func() error {
+				return writeRecord(outDir, incpath, namespace, options.ExportMacro)
+			})
+		}
+	}
 
 	if err := g.Wait(); err != nil {
 		return err
 	}
 
+	if options.Modules {
+		headers := []string{"bytes.h", "log.h", "selftest.h", "inst.h", "mem.h"}
+		if library {
+			headers = append(headers, "library.h")
+		}
+		if runtime {
+			headers = append(headers, "host.h", "js.h", "taskqueue.h", "go.h", "driver.h", "game.h", "assets.h")
+			if options.Record {
+				headers = append(headers, "record.h")
+			}
+		}
+		if options.Watchdog {
+			headers = append(headers, "watchdog.h")
+		}
+		if options.Schema != nil {
+			headers = append(headers, "schema.h")
+		}
+		// The exports are taken from the headers, so the module is written after them.
+		if err := writeModule(outDir, incpath, namespace, headers); err != nil {
+			return err
+		}
+	}
+
+	if options.CallGraph != "" {
+		if err := writeCallGraph(options.CallGraph, translated); err != nil {
+			return err
+		}
+	}
+	if options.SchemaGo != "" {
+		if err := writeSchemaGo(options.SchemaGo, options.Schema); err != nil {
+			return err
+		}
+	}
+	if options.SizeReport != "" {
+		if err := writeSizeReport(options.SizeReport, translated, data); err != nil {
+			return err
+		}
+	}
+	if options.MaxFunctionLines > 0 && options.Warnf != nil {
+		warnLargeFunctions(fs, options.MaxFunctionLines, options.Warnf)
+	}
+
+	style := options.Style
+	if style == nil {
+		style = DefaultStyle()
+	}
+	var nestedNamespace string
+	if v, _, _ := cppStdVersion(options.CppStd); v < 17 && strings.Contains(namespace, "::") {
+		nestedNamespace = namespace
+	}
+	paths, err := generatedFiles(outDir)
+	if err != nil {
+		return err
+	}
+	var fg group
+	for _, path := range paths {
+		path := path
+		fg.Go(func() error {
+			return formatFile(path, style, nestedNamespace)
+		})
+	}
+	if err := fg.Wait(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -573,39 +1130,272 @@ var goHTmpl = template.Must(template.New("go.h").Parse(`// Code generated by go2
 #ifndef {{.IncludeGuard}}
 #define {{.IncludeGuard}}
 
+#include "{{.IncludePath}}bits.h"
 #include "{{.IncludePath}}bytes.h"
+#include "{{.IncludePath}}config.h"
+#include "{{.IncludePath}}host.h"
 #include "{{.IncludePath}}js.h"
 #include "{{.IncludePath}}inst.h"
 #include "{{.IncludePath}}mem.h"
 #include "{{.IncludePath}}taskqueue.h"
-
+{{if .Watchdog}}#include "{{.IncludePath}}watchdog.h"
+{{end}}
 #include <algorithm>
 #include <cstdint>
 #include <chrono>
+#include <deque>
 #include <functional>
+#include <initializer_list>
 #include <map>
 #include <memory>
+#include <ostream>
 #include <stack>
 #include <string>
 #include <unordered_map>
 #include <unordered_set>
 #include <vector>
 
+{{.VersionCheck}}
+
 namespace {{.Namespace}} {
 
 class Mem;
 
-class Go {
+class {{.Export}}Go {
 public:
+  struct Stats {
+    // The size of the linear memory in bytes.
+    size_t memory_size;
+
+    // The number of host values that have IDs for Go, including the predefined values.
+    size_t value_count;
+
+    // The number of host values that Go no longer refers to and that are not finalized yet.
+    size_t finalizing_value_count;
+
+    size_t scheduled_timeout_count;
+    size_t task_queue_length;
+
+    // The numbers of the tasks run and the host values finalized since Run began.
+    uint64_t task_count;
+    uint64_t finalized_value_count;
+  };
+
+  // TaskPolicy controls how the tasks are run. The tasks are run in the order of TaskQueue::Priority, i.e., the audio
+  // callbacks first, the timeouts, the other tasks and the frames.
+  struct TaskPolicy {
+    // max_tasks_per_pump is the maximum number of tasks run in a row without waiting. The garbage collection of
+    // GCPolicy runs after each pump. 0 is treated as 1.
+    size_t max_tasks_per_pump = 1;
+  };
+
+  // GCPolicy controls how many host values that Go no longer refers to are finalized after each pump of tasks.
+  struct GCPolicy {
+    // The number of values finalized after each task is the maximum of batch_size_min and the number of pending values
+    // divided by batch_size_divisor. If batch_size_divisor is 0, only batch_size_min is used.
+    size_t batch_size_min = 64;
+    size_t batch_size_divisor = 16;
+
+    // The maximum time for finalizing after each task. 0 means no limit.
+    std::chrono::microseconds time_budget{0};
+
+    // If collect_on_idle is true, the pending values are finalized within idle_time_budget when the task queue is
+    // empty. 0 means no limit.
+    bool collect_on_idle = false;
+    std::chrono::microseconds idle_time_budget{0};
+  };
+
   Go();
   Go(std::unique_ptr<Writer> debug_writer);
+
+  // host must outlive Go.
+  explicit Go(HostServices* host);
+
+  // The destructor is defined out of line so that the internal classes are not required to be exported.
+  ~Go();
+
   int Run();
   int Run(int argc, char** argv);
   int Run(const std::vector<std::string>& args);
 
   // EnqueuTask is concurrent-safe.
-  void EnqueueTask(std::function<void()> task);
+  void EnqueueTask(std::function<void()> task, TaskQueue::Priority priority = TaskQueue::Priority::kDefault);
+{{if .Deterministic}}
+  // EnqueueTaskAt enqueues task when the virtual time of the DeterministicSource reaches time in nanoseconds. While no
+  // task is pending, the virtual time advances to the earliest of these times instead of waiting for the real time.
+  // The timeouts of the Go program and the frames of Game run in this way. The tasks with the same time are enqueued
+  // in the order they were added. EnqueueTaskAt is not concurrent-safe. Call this in the thread running Run.
+  void EnqueueTaskAt(int64_t time, std::function<void()> task,
+                     TaskQueue::Priority priority = TaskQueue::Priority::kDefault);
+
+  // GetVirtualTime returns the virtual time of the DeterministicSource in nanoseconds. GetVirtualTime is not
+  // concurrent-safe. Call this in the thread running Run.
+  int64_t GetVirtualTime();
+{{end}}
+  // BuildInfo represents the information about the Go program and the generation.
+  struct BuildInfo {
+    struct Producer {
+      std::string field;
+      std::string name;
+      std::string version;
+    };
+
+    // The Go version, e.g. "go1.21.0". This is empty when the WebAssembly module does not have the information.
+    std::string go_version;
+    std::string go_build_id;
+
+    // The hash of the WebAssembly module. A snapshot is valid only with the same hash.
+    uint64_t module_hash;
+
+    // The module version of go2cpp that generated the code, e.g. "v0.1.0" or "(devel)". generator_version is the
+    // version that the package github.com/hajimehoshi/go2cpp/version provides, e.g. "0.1.0".
+    std::string go2cpp_version;
+    std::string generator_version;
+
+    // The time given by SOURCE_DATE_EPOCH when the code was generated, in RFC 3339. This is empty if
+    // SOURCE_DATE_EPOCH was not set, so that the generated code is reproducible.
+    std::string build_time;
+
+    // The content of the "producers" custom section.
+    std::vector<Producer> producers;
+  };
+
+  // GetBuildInfo is concurrent-safe.
+  static BuildInfo GetBuildInfo();
+
+  // GetStats is not concurrent-safe. Call this in the thread running Run, e.g., in a task by EnqueueTask.
+  Stats GetStats();
+
+  // DumpValues writes the values Go refers to with their IDs, reference counts, types and short descriptions, for
+  // debugging leaks and interop. DumpValues is not concurrent-safe. Call this in the thread running Run.
+  void DumpValues(std::ostream& out);
+
+  // SetTaskPolicy is not concurrent-safe. Call this before Run or in the thread running Run.
+  void SetTaskPolicy(const TaskPolicy& policy);
+
+  // GetMemoryView returns the linear memory of the Go program, e.g., to upload a texture or audio from a buffer owned by
+  // Go without copying. The view is invalidated when the memory grows, which Hooks::on_memory_grow notifies.
+  // GetMemoryView returns an empty span before Run. GetMemoryView is not concurrent-safe. Call this in the thread
+  // running Run.
+  BytesSpan GetMemoryView();
+
+  // SetGCPolicy and CollectGarbage are not concurrent-safe. Call them in the thread running Run.
+  void SetGCPolicy(const GCPolicy& policy);
+{{if .Watchdog}}
+  // SetWatchdog makes a Watchdog check the start of the Go program and its tasks including the frames. When one of them
+  // doesn't end within timeout, on_frozen is called in the watchdog's thread with the functions being executed. If
+  // timeout is 0, no Watchdog is used. SetWatchdog must be called before Run.
+  void SetWatchdog(std::chrono::milliseconds timeout, std::function<void(const Watchdog::Report& report)> on_frozen);
+{{end}}
+  // CollectGarbage finalizes the host values that Go no longer refers to within budget, and returns the number of the
+  // finalized values. 0 budget means no limit.
+  size_t CollectGarbage(std::chrono::microseconds budget);
+
+  // SetSnapshotHandler sets a function to be called with a snapshot of the Go runtime state.
+  // The snapshot is taken when the first run of the Go program returns, i.e., when the runtime initialization finishes
+  // and the program starts to wait for events. The snapshot can be given to SetSnapshot at the next launch.
+  //
+  // A snapshot cannot be taken when Go has a reference to a JavaScript object that cannot be reconstructed, e.g., a
+  // result of a function call. In this case, the handler is not called.
+  void SetSnapshotHandler(std::function<void(const std::vector<uint8_t>&)> handler);
+
+  // SetSnapshot sets a snapshot to restore at Run instead of initializing the Go runtime.
+  // The arguments given to Run are ignored when the snapshot is restored.
+  // If the snapshot is invalid, e.g., the snapshot was taken with a different program, Run initializes the Go runtime
+  // as usual.
+  void SetSnapshot(std::vector<uint8_t> snapshot);
+
+  // InstanceFactory creates an Instance running the Go program with the memory and the imports.
+  using InstanceFactory = std::function<std::unique_ptr<Instance>(Mem* mem, Import* import)>;
+
+  // SetInstanceFactory sets the factory of the Instance, e.g., to run the program with an interpreter instead of the
+  // translated module. If factory is nullptr, Inst is used. SetInstanceFactory must be called before Run.
+  void SetInstanceFactory(InstanceFactory factory);
+
+  // CrashReport is the information about a crash of the Go program for Hooks::on_crash.
+  struct CrashReport {
+    // kMaxDebugLines is the maximum number of the lines in debug_lines.
+    static constexpr size_t kMaxDebugLines = 256;
+
+    // exit_code is the exit code of the Go program, e.g., 2 for a panic, or 1 for a trap.
+    int32_t exit_code = 0;
+
+    // reason is the first line of the panic or the fatal error that the Go runtime printed, or the message of the trap.
+    std::string reason;
+
+    // debug_lines is the last lines of the debug output of the Go runtime. At a panic, this includes the stacks of the
+    // goroutines that the Go runtime printed before exiting. At a trap, this doesn't include the stacks: the Go runtime
+    // cannot run after the trap to print them, so debug_lines has only what the Go runtime printed before.
+    std::vector<std::string> debug_lines;
+  };
+
+  // Hooks are the functions called at the points of the Go program's lifecycle, to extend the behavior without editing
+  // the generated code. The hooks that are nullptr are not called.
+  struct Hooks {
+    // on_before_run is called in Run after the instance is created and before the Go program starts or is restored.
+    std::function<void()> on_before_run;
+
+    // on_exit is called with the exit code when the Go program exits. Run returns after on_exit returns.
+    std::function<void(int32_t code)> on_exit;
+
+    // on_debug_write is called with the debug output of the Go runtime, e.g., panic messages, instead of
+    // HostServices::DebugWrite. bytes is valid only during the call.
+    std::function<void(BytesSpan bytes)> on_debug_write;
+
+    // on_crash is called with the report when the Go program exits with a nonzero code, e.g., by a panic, or traps,
+    // e.g., by an unreachable instruction. on_crash is called before on_exit, or before the process terminates at a
+    // trap. on_crash must not call the Go program.
+    std::function<void(const CrashReport& report)> on_crash;
+
+    // on_memory_grow is called with the new view of the linear memory after the memory grows or is restored from a
+    // snapshot. The views taken before are invalid, so replace them with memory here.
+    std::function<void(BytesSpan memory)> on_memory_grow;
+{{if .Record}}
+    // on_import is called with the name of an import and the bytes of its arguments on the stack when the Go program
+    // calls the import. args is valid only during the call. Call Recorder::OnImport or Replayer::OnImport here.
+    std::function<void(const char* name, BytesSpan args)> on_import;
+
+    // on_event is called with the description of an event when the host resumes the Go program with it: "func" with
+    // the ID and the arguments of a function made by js.FuncOf, or "timeout" with the ID of a timeout. Call
+    // Recorder::OnEvent or Replayer::OnEvent here.
+    std::function<void(const std::string& event)> on_event;
+{{end}}  };
+
+  // SetHooks is not concurrent-safe. Call this before Run or in the thread running Run.
+  void SetHooks(Hooks hooks);
+
+  // Capabilities are the groups of the host functionality that the Go program can use, e.g., to confine an untrusted
+  // program. All of them are enabled by default. A disabled operation fails like a JavaScript error, which the Go
+  // program can handle, instead of crashing.
+  struct Capabilities {
+    // filesystem is fs for os and syscall. The functions of the disabled fs fail with EPERM, except for writing to the
+    // standard output and error.
+    bool filesystem = true;
+
+    // network is fetch for net/http. The disabled fetch returns a rejected promise.
+    bool network = true;
+
+    // random is crypto.getRandomValues for crypto/rand. The disabled getRandomValues throws an Error. The seeds of the
+    // Go runtime are not affected.
+    bool random = true;
+
+    // storage is localStorage. The disabled getItem and setItem throw an Error.
+    bool storage = true;
+  };
 
+  // SetCapabilities must be called before Run.
+  void SetCapabilities(const Capabilities& capabilities);
+
+  // SetGlobalOverride replaces or adds the property key of the global object, e.g., "fetch" or "localStorage", with
+  // value when Run starts, after the properties by go2cpp are set. If value is undefined, the property is deleted
+  // instead. The global object is shared by all the Go instances, and the overrides remain after Run.
+  // SetGlobalOverride must be called before Run.
+  void SetGlobalOverride(const std::string& key, Value value);
+{{if .Deterministic}}
+  // SetDeterministicSource sets the source of the time and the random values. source must outlive Go. If source is
+  // nullptr, a DeterministicSource with the seed 0 is used. SetDeterministicSource must be called before Run.
+  void SetDeterministicSource(DeterministicSource* source);
+{{end}}
 private:
   class ImportImpl : public Import {
   public:
@@ -618,6 +1408,12 @@ private:
     Go* go_;
   };
 
+  // ValueOrigin represents how a JavaScript object was obtained by Go: the keys of properties from the root value.
+  struct ValueOrigin {
+    int32_t root_id;
+    std::vector<std::string> keys;
+  };
+
   class GoObject : public Object {
   public:
     explicit GoObject(Go* go);
@@ -631,86 +1427,509 @@ private:
     Value func_make_func_wrapper_;
   };
 
-  Value LoadValue(int32_t addr);
-  void StoreValue(int32_t addr, Value v);
-  std::vector<Value> LoadSliceOfValues(int32_t addr);
-  void Exit(int32_t code);
-  void Resume();
-  Value MakeFuncWrapper(int32_t id);
-  void DebugWrite(BytesSpan bytes);
-  int64_t PreciseNowInNanoseconds();
-  double UnixNowInMilliseconds();
-  int32_t SetTimeout(double interval);
-  void ClearTimeout(int32_t id);
-  void GetRandomBytes(BytesSpan bytes);
-  int32_t GetIdFromValue(const Value& value);
-  void GC();
+  // ScopedSP holds sp, the stack pointer given to an import function. An import function that might call back into Go
+  // must call Reload after the call and before accessing the stack, as Go might move its stack. With GO2CPP_DEBUG_SP,
+  // ScopedSP checks that sp is the current stack pointer when the import function is called and returns.
+  class ScopedSP {
+  public:
+    ScopedSP(Go* go, int32_t* sp);
+    ~ScopedSP();
 
-  ImportImpl import_;
-  std::unique_ptr<Writer> debug_writer_;
-  // A TaskQueue must be destructed after the timers are destructed.
-  TaskQueue task_queue_;
+    void Reload();
 
-  Value pending_event_{Value::Null()};
-  std::unordered_map<int32_t, std::unique_ptr<Timer>> scheduled_timeouts_;
-  int32_t next_callback_timeout_id_ = 1;
+  private:
+    Go* go_;
+    int32_t* sp_;
+  };
 
-  std::unique_ptr<Inst> inst_;
-  std::unique_ptr<Mem> mem_;
-  std::unordered_map<int32_t, Value> values_;
-  std::unordered_map<int32_t, double> go_ref_counts_;
-  std::unordered_map<Value, int32_t, Value::Hash> ids_;
-  std::unordered_set<int32_t> id_pool_;
-  int32_t next_id_;
+#if defined(GO2CPP_DEBUG_REFS)
+  // RefCheckScope validates the references between Go and the host values when an import function returns.
+  class RefCheckScope {
+  public:
+    RefCheckScope(Go* go, const char* op);
+    ~RefCheckScope();
 
-  // A set of Value IDs to be finalized later.
-  // Note that the ID is added to this set when Go no longer has this value,
-  // but the Value might still be alive on C++ side, and might be reused on Go side later.
-  // Value is a ref-counted object and even if a Value is removed from values_, the value might be alive.
-  std::unordered_set<int32_t> finalizing_ids_;
+  private:
+    Go* go_;
+    const char* op_;
+    const char* prev_op_;
+  };
 
-  bool exited_ = false;
-  int32_t exit_code_ = 0;
+  void CheckRefs(const char* op);
+#endif
 
-  std::chrono::high_resolution_clock::time_point start_time_point_ = std::chrono::high_resolution_clock::now();
-};
+  // IdMap is a map from a value ID to T. As IDs are small and reused, IdMap is a dense array indexed by ID instead of
+  // a hash map. IdMap has the subset of std::unordered_map's interface used here, and iterates in the order of IDs.
+  template <typename T>
+  class IdMap {
+  public:
+    using value_type = std::pair<int32_t, T>;
+
+    class iterator {
+    public:
+      iterator(std::vector<value_type>* slots, size_t index)
+          : slots_{slots},
+            index_{index} {
+        Skip();
+      }
 
-}
+      value_type& operator*() const { return (*slots_)[index_]; }
+      value_type* operator->() const { return &(*slots_)[index_]; }
+      bool operator==(const iterator& rhs) const { return index_ == rhs.index_; }
+      bool operator!=(const iterator& rhs) const { return index_ != rhs.index_; }
 
-#endif  // {{.IncludeGuard}}
+      iterator& operator++() {
+        index_++;
+        Skip();
+        return *this;
+      }
 
-`))
+    private:
+      friend class IdMap;
 
-var goCppTmpl = template.Must(template.New("go.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+      void Skip() {
+        while (index_ < slots_->size() && (*slots_)[index_].first < 0) {
+          index_++;
+        }
+      }
 
-#include "{{.IncludePath}}go.h"
+      std::vector<value_type>* slots_;
+      size_t index_;
+    };
 
-#include <cassert>
+    IdMap() = default;
+
+    IdMap(std::initializer_list<value_type> items) {
+      for (const value_type& item : items) {
+        (*this)[item.first] = item.second;
+      }
+    }
+
+    T& operator[](int32_t id) {
+      // A negative ID, e.g., a broken reference in the memory, would index out of the slots.
+      if (id < 0) {
+        Trap("invalid value ID: " + std::to_string(id));
+      }
+      if (static_cast<size_t>(id) >= slots_.size()) {
+        slots_.resize(id + 1, value_type{-1, T{}});
+      }
+      value_type& slot = slots_[id];
+      if (slot.first < 0) {
+        slot.first = id;
+        size_++;
+      }
+      return slot.second;
+    }
+
+    iterator find(int32_t id) {
+      if (id < 0 || static_cast<size_t>(id) >= slots_.size() || slots_[id].first < 0) {
+        return end();
+      }
+      return iterator{&slots_, static_cast<size_t>(id)};
+    }
+
+    size_t erase(int32_t id) {
+      if (find(id) == end()) {
+        return 0;
+      }
+      slots_[id] = value_type{-1, T{}};
+      size_--;
+      return 1;
+    }
+
+    iterator erase(iterator it) {
+      erase(it->first);
+      return ++it;
+    }
+
+    iterator begin() { return iterator{&slots_, 0}; }
+    iterator end() { return iterator{&slots_, slots_.size()}; }
+    size_t size() const { return size_; }
+    bool empty() const { return size_ == 0; }
+
+  private:
+    std::vector<value_type> slots_;
+    size_t size_ = 0;
+  };
+
+  // KeyTable interns the property names given by Go so that valueGet and the like don't allocate a string for each
+  // call. The table is bounded, and the keys are never removed.
+  class KeyTable {
+  public:
+    // Intern returns the interned key for the bytes, or nullptr when the key is too long or the table is full.
+    const std::string* Intern(const uint8_t* data, size_t size);
+
+  private:
+    static constexpr size_t kMaxKeyLength = 64;
+    static constexpr size_t kMaxKeys = 4096;
+
+    // The number of the slots is a power of two, and twice the maximum number of the keys for open addressing.
+    static constexpr size_t kSlotCount = kMaxKeys * 2;
+
+    std::vector<std::unique_ptr<std::string>> slots_;
+    std::vector<uint32_t> hashes_;
+    size_t count_ = 0;
+  };
+
+  // LoadKey returns the Go string at addr as a property name. The result refers to the interned key, or *storage
+  // when the key is not interned.
+  const std::string& LoadKey(int32_t addr, std::string* storage);
+
+  Value LoadValue(int32_t addr);
+  void StoreValue(int32_t addr, Value v);
+  std::vector<Value> LoadSliceOfValues(int32_t addr);
+  void Exit(int32_t code);
+  void Resume();
+  Value MakeFuncWrapper(int32_t id);
+  void DebugWrite(BytesSpan bytes);
+  void Crash(int32_t code, std::string reason);
+{{if .Record}}  void OnImport(const char* name, int32_t sp, int32_t args_size);
+{{end}}  int64_t PreciseNowInNanoseconds();
+  double UnixNowInMilliseconds();
+  int32_t SetTimeout(double interval);
+  void ScheduleTimeout(int32_t id, double interval);
+  void ClearTimeout(int32_t id);
+{{if .Deterministic}}  // EnqueueVirtualTasks enqueues the tasks of EnqueueTaskAt whose time has come. If no task is pending, the virtual
+  // time advances to the earliest time first.
+  void EnqueueVirtualTasks();
+{{end}}  void GetRandomBytes(BytesSpan bytes);
+  int32_t GetIdFromValue(const Value& value);
+  void GC();
+  size_t FinalizeValues(size_t max_num, std::chrono::microseconds budget);
+  void RecordValueOrigin(const Value& target, const std::string& key, const Value& result);
+  bool TakeSnapshot(std::vector<uint8_t>* snapshot, std::string* error);
+  bool RestoreSnapshot(const std::vector<uint8_t>& snapshot, std::string* error);
+
+  void BindHostServices();
+  void UnbindHostServices();
+  void ApplyGlobalOverrides();
+
+  ImportImpl import_;
+  std::unique_ptr<HostServices> default_host_;
+  HostServices* host_;
+  // A TaskQueue must be destructed after the timers are destructed.
+  TaskQueue task_queue_;
+
+  Value pending_event_{Value::Null()};
+
+  // func_wrapper_event_ represents the next function to be called when resuming.
+  // As resuming never happens recursively, this value should be reusable.
+  Value func_wrapper_event_{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+    {"id", Value{0.0}},
+    {"this", Value{}},
+    {"args", Value{}},
+  })};
+
+  // empty_args_ is a Value of an empty array for arguments.
+  // This assumes that the argment array is never modified in the callbacks.
+  // By using the same Value, this can avoid being finalized at syscall/js.finalizeRef.
+  Value empty_args_{std::vector<Value>()};
+  std::unordered_map<int32_t, std::unique_ptr<Timer>> scheduled_timeouts_;
+  int32_t next_callback_timeout_id_ = 1;
+
+  KeyTable key_table_;
+
+  std::unique_ptr<Instance> inst_;
+  std::unique_ptr<Mem> mem_;
+  IdMap<Value> values_;
+  IdMap<double> go_ref_counts_;
+  std::unordered_map<Value, int32_t, Value::Hash> ids_;
+  // The IDs to reuse. Like _idPool in wasm_exec.js, the last finalized ID is reused first.
+  std::vector<int32_t> id_pool_;
+  int32_t next_id_;
+
+  // A set of Value IDs to be finalized later.
+  // Note that the ID is added to this set when Go no longer has this value,
+  // but the Value might still be alive on C++ side, and might be reused on Go side later.
+  // Value is a ref-counted object and even if a Value is removed from values_, the value might be alive.
+  std::unordered_set<int32_t> finalizing_ids_;
+
+  // The IDs in finalizing_ids_ in the order of finalizeRef, so that the IDs are reused in the same order on every run.
+  // An ID that is no longer in finalizing_ids_ is skipped.
+  std::deque<int32_t> finalizing_queue_;
+  uint64_t task_count_ = 0;
+  uint64_t finalized_value_count_ = 0;
+  TaskPolicy task_policy_;
+  GCPolicy gc_policy_;
+
+  bool exited_ = false;
+  int32_t exit_code_ = 0;
+
+{{if .Watchdog}}  std::chrono::milliseconds watchdog_timeout_{0};
+  std::function<void(const Watchdog::Report& report)> on_frozen_;
+  std::unique_ptr<Watchdog> watchdog_;
+
+{{end}}  // The last lines of the debug output for Hooks::on_crash, and the line being written.
+  std::deque<std::string> debug_lines_;
+  std::string debug_line_;
+  bool crashed_ = false;
+
+  // The origin of the monotonic clock in nanoseconds.
+  int64_t start_time_ = 0;
+
+  std::function<void(const std::vector<uint8_t>&)> snapshot_handler_;
+  InstanceFactory instance_factory_;
+  Hooks hooks_;
+  Capabilities capabilities_;
+  std::map<std::string, Value> global_overrides_;
+
+  // The properties of the global object that BindHostServices set, with the previous values. The properties refer to
+  // this Go and its HostServices, so they are restored at the destructor.
+  struct BoundGlobal {
+    std::string key;
+    Value previous;
+    Value bound;
+  };
+  std::vector<BoundGlobal> bound_globals_;
+{{if .Deterministic}}
+  struct VirtualTask {
+    std::function<void()> task;
+    TaskQueue::Priority priority;
+  };
+
+  DeterministicSource default_deterministic_source_{0};
+  DeterministicSource* deterministic_source_ = &default_deterministic_source_;
+
+  // virtual_tasks_ is the tasks of EnqueueTaskAt by their times. A multimap keeps the order of the same times.
+  std::multimap<int64_t, VirtualTask> virtual_tasks_;
+{{end}}  std::vector<uint8_t> snapshot_;
+
+  // The origins of values and the function wrappers are recorded only until a snapshot is taken.
+  bool recording_value_origins_ = false;
+  std::unordered_map<int32_t, ValueOrigin> value_origins_;
+  std::unordered_map<Value, int32_t, Value::Hash> func_wrappers_;
+
+#if defined(GO2CPP_DEBUG_REFS)
+  // The import function running now, and the import functions that gave each ID to Go and finalized it last.
+  const char* current_ref_op_ = "";
+  std::unordered_map<int32_t, std::string> ref_givers_;
+  std::unordered_map<int32_t, std::string> ref_finalizers_;
+#endif
+};
+
+}
+
+#endif  // {{.IncludeGuard}}
+
+`))
+
+var goCppTmpl = template.Must(template.New("go.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}go.h"
+
+#include "{{.IncludePath}}bits.h"
+#include "{{.IncludePath}}log.h"
+#include "{{.IncludePath}}selftest.h"
+#include "{{.IncludePath}}version.h"
+
+#include <cassert>
+#include <cfloat>
 #include <cmath>
 #include <cstring>
 #include <iostream>
 #include <limits>
-#include <random>
+#include <tuple>
 
-namespace {{.Namespace}} {
+{{if .Deterministic}}// The floating-point operations must keep IEEE 754 semantics for the determinism.
+#if defined(__FAST_MATH__)
+#error "the deterministic mode cannot be used with -ffast-math"
+#endif
+#if defined(FLT_EVAL_METHOD) && FLT_EVAL_METHOD != 0
+#error "the deterministic mode cannot be used with the x87 instructions: use SSE2, e.g., -msse2 -mfpmath=sse"
+#endif
+
+{{end}}namespace {{.Namespace}} {
 
 namespace {
 
 void error(const std::string& msg) {
-  std::cerr << msg << std::endl;
+  Log(LogLevel::kError, msg);
+  RunTrapHandler(msg);
   assert(false);
   std::exit(1);
 }
 
+{{if .CatchHostError}}// CatchHostError calls f and returns true. If f throws an Exception or a std::exception, CatchHostError sets the error
+// to *err and returns false, like try-catch in wasm_exec.js.
+bool CatchHostError(const std::function<void()>& f, Value* err) {
+  try {
+    f();
+    return true;
+  } catch (const Exception& e) {
+    *err = e.GetValue();
+  } catch (const std::exception& e) {
+    *err = Value{std::make_shared<Error>(e.what())};
+  }
+  return false;
+}
+
+{{end}}constexpr char kSnapshotMagic[] = "go2cpp snapshot";
+constexpr uint32_t kSnapshotVersion = 1;
+
+enum class SnapshotValueKind : uint8_t {
+  kString,
+  kOrigin,
+  kFuncWrapper,
+  kFuncWrapperEvent,
+  kEmptyArgs,
+};
+
+// The snapshot is written in the native byte order, as the snapshot is assumed to be used on the same machine.
+
+class WriterHostServices : public HostServices {
+public:
+  explicit WriterHostServices(std::unique_ptr<Writer> debug_writer)
+      : debug_writer_{std::move(debug_writer)} {
+  }
+
+  void DebugWrite(const std::vector<uint8_t>& bytes) override {
+    debug_writer_->Write(bytes);
+  }
+
+private:
+  std::unique_ptr<Writer> debug_writer_;
+};
+
+// DisabledFS is fs for the disabled filesystem. The functions call the callbacks with EPERM, except for writing to the
+// standard output and error. The constants are the ones of base, as syscall reads them at the initialization.
+class DisabledFS : public Object {
+public:
+  explicit DisabledFS(Value base)
+      : base_{base} {
+  }
+
+  Value Get(const std::string& key) override {
+    if (key == "constants") {
+      return Value::ReflectGet(base_, key);
+    }
+    return Value{std::make_shared<Function>(
+      [base = base_, key](Value self, std::vector<Value> args) -> Value {
+        if (key == "write" && !args.empty() && args[0].IsNumber()) {
+          double fd = args[0].ToNumber();
+          if (fd == 1 || fd == 2) {
+            return Value::ReflectApply(Value::ReflectGet(base, key), base, args);
+          }
+        }
+        Value err{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+          {"code", Value{"EPERM"}},
+          {"message", Value{"fs." + key + " is disabled by Go::Capabilities"}},
+        })};
+        if (!args.empty() && args.back().IsFunction()) {
+          Value::ReflectApply(args.back(), Value{}, {err});
+        }
+        return Value{};
+      })};
+  }
+
+  std::string ToString() const override {
+    return "fs";
+  }
+
+private:
+  Value base_;
+};
+
+class SnapshotWriter {
+public:
+  template<typename T>
+  void Write(T v) {
+    const uint8_t* p = reinterpret_cast<const uint8_t*>(&v);
+    bytes_.insert(bytes_.end(), p, p + sizeof(T));
+  }
+
+  void WriteBytes(const uint8_t* data, size_t size) {
+    Write<uint64_t>(size);
+    bytes_.insert(bytes_.end(), data, data + size);
+  }
+
+  void WriteString(const std::string& str) {
+    WriteBytes(reinterpret_cast<const uint8_t*>(str.data()), str.size());
+  }
+
+  std::vector<uint8_t>& bytes() {
+    return bytes_;
+  }
+
+private:
+  std::vector<uint8_t> bytes_;
+};
+
+class SnapshotReader {
+public:
+  explicit SnapshotReader(const std::vector<uint8_t>& bytes)
+      : bytes_{bytes} {
+  }
+
+  template<typename T>
+  bool Read(T* v) {
+    if (bytes_.size() - pos_ < sizeof(T)) {
+      return false;
+    }
+    std::memcpy(v, bytes_.data() + pos_, sizeof(T));
+    pos_ += sizeof(T);
+    return true;
+  }
+
+  bool ReadBytes(std::vector<uint8_t>* bytes) {
+    uint64_t size = 0;
+    if (!Read(&size)) {
+      return false;
+    }
+    if (bytes_.size() - pos_ < size) {
+      return false;
+    }
+    bytes->assign(bytes_.begin() + pos_, bytes_.begin() + pos_ + size);
+    pos_ += size;
+    return true;
+  }
+
+  bool ReadString(std::string* str) {
+    std::vector<uint8_t> bytes;
+    if (!ReadBytes(&bytes)) {
+      return false;
+    }
+    str->assign(bytes.begin(), bytes.end());
+    return true;
+  }
+
+  bool AtEnd() const {
+    return pos_ == bytes_.size();
+  }
+
+  // CanRead reports whether num items of size bytes remain. Use this before allocating for a count in the snapshot.
+  bool CanRead(uint64_t num, size_t size) const {
+    return num <= (bytes_.size() - pos_) / size;
+  }
+
+private:
+  const std::vector<uint8_t>& bytes_;
+  size_t pos_ = 0;
+};
+
 }
 
 Go::Go()
-    : Go(std::make_unique<StreamWriter>(std::cerr)) {
+    : import_{this},
+      default_host_{std::make_unique<HostServices>()},
+      host_{default_host_.get()},
+      start_time_{host_->GetMonotonicTime()} {
 }
 
 Go::Go(std::unique_ptr<Writer> debug_writer)
     : import_{this},
-      debug_writer_{std::move(debug_writer)} {
+      default_host_{std::make_unique<WriterHostServices>(std::move(debug_writer))},
+      host_{default_host_.get()},
+      start_time_{host_->GetMonotonicTime()} {
+}
+
+Go::Go(HostServices* host)
+    : import_{this},
+      host_{host},
+      start_time_{host_->GetMonotonicTime()} {
+}
+
+Go::~Go() {
+  UnbindHostServices();
 }
 
 int Go::Run() {
@@ -723,8 +1942,34 @@ int Go::Run(int argc, char** argv) {
 }
 
 int Go::Run(const std::vector<std::string>& args) {
+#ifdef GO2CPP_SELF_TEST
+  {
+    std::string message;
+    if (!SelfTest(&message)) {
+      error("self test failed:\n" + message);
+    }
+  }
+#endif
+
+  BindHostServices();
+  ApplyGlobalOverrides();
+
+  // The reactions of the promises are run as tasks, like microtasks in JavaScript.
+  Promise::Scheduler prev_promise_scheduler = Promise::SetScheduler([this](std::function<void()> task) {
+    task_queue_.Enqueue(std::move(task));
+  });
+
   mem_ = std::make_unique<Mem>();
-  inst_ = std::make_unique<Inst>(mem_.get(), &import_);
+  mem_->SetResizeCallback([this]() {
+    if (hooks_.on_memory_grow) {
+      hooks_.on_memory_grow(mem_->GetView());
+    }
+  });
+  if (instance_factory_) {
+    inst_ = instance_factory_(mem_.get(), &import_);
+  } else {
+    inst_ = std::make_unique<Inst>(mem_.get(), &import_);
+  }
 
   values_ = {
     {0, Value{std::nan("")}},
@@ -756,53 +2001,134 @@ int Go::Run(const std::vector<std::string>& args) {
   };
 
   id_pool_ = {};
+  finalizing_ids_ = {};
+  finalizing_queue_ = {};
+  task_count_ = 0;
+  finalized_value_count_ = 0;
   exited_ = false;
   exit_code_ = 0;
-
-  int32_t offset = 4096;
-  auto str_ptr = [this, &offset](const std::string& str) -> int32_t {
-    int32_t ptr = offset;
-    std::vector<uint8_t> bytes(str.begin(), str.end());
-    bytes.push_back('\0');
-    mem_->StoreBytes(offset, bytes);
-    offset += bytes.size();
-    if (offset % 8 != 0) {
-      offset += 8 - (offset % 8);
-    }
-    return ptr;
-  };
-
-  // 'js' is requried as the first argument.
-  std::vector<std::string> margs = args;
-  if (margs.size() == 0) {
-    margs.push_back("js");
-  } else {
-    margs[0] = "js";
+  debug_lines_.clear();
+  debug_line_.clear();
+  crashed_ = false;
+  // The handler is only for this Go. Even without on_crash, the scope hides the handler of another Go running this Go,
+  // e.g., in a host function.
+  std::function<void(const std::string& msg)> trap_handler;
+  if (hooks_.on_crash) {
+    trap_handler = [this](const std::string& msg) {
+      Crash(1, msg);
+    };
   }
-  int argc = margs.size();
-  std::vector<int32_t> argv_ptrs;
-  for (const std::string& arg : margs) {
-    argv_ptrs.push_back(str_ptr(arg));
+  TrapHandlerScope trap_handler_scope{std::move(trap_handler)};
+{{if .Watchdog}}  if (watchdog_timeout_.count() > 0) {
+    watchdog_ = std::make_unique<Watchdog>(watchdog_timeout_, on_frozen_);
+  }
+{{end}}
+  if (hooks_.on_before_run) {
+    hooks_.on_before_run();
   }
-  argv_ptrs.push_back(0);
-  // TODO: Add environment variables.
-  argv_ptrs.push_back(0);
 
-  int32_t argv = offset;
-  for (int32_t ptr : argv_ptrs) {
-    mem_->StoreInt32(offset, ptr);
-    mem_->StoreInt32(offset + 4, 0);
-    offset += 8;
+  bool restored = false;
+  if (!snapshot_.empty()) {
+    std::string err;
+    restored = RestoreSnapshot(snapshot_, &err);
+    if (!restored) {
+      Log(LogLevel::kError, "restoring the snapshot failed: " + err);
+    }
+    snapshot_.clear();
   }
 
-  inst_->run(argc, argv);
+  if (!restored) {
+    recording_value_origins_ = !!snapshot_handler_;
+
+    int32_t offset = 4096;
+    auto str_ptr = [this, &offset](const std::string& str) -> int32_t {
+      int32_t ptr = offset;
+      std::vector<uint8_t> bytes(str.begin(), str.end());
+      bytes.push_back('\0');
+      mem_->StoreBytes(offset, bytes);
+      offset += bytes.size();
+      if (offset % 8 != 0) {
+        offset += 8 - (offset % 8);
+      }
+      return ptr;
+    };
+
+    // 'js' is requried as the first argument.
+    std::vector<std::string> margs = args;
+    if (margs.size() == 0) {
+      margs.push_back("js");
+    } else {
+      margs[0] = "js";
+    }
+    int argc = margs.size();
+    std::vector<int32_t> argv_ptrs;
+    for (const std::string& arg : margs) {
+      argv_ptrs.push_back(str_ptr(arg));
+    }
+    argv_ptrs.push_back(0);
+    // TODO: Add environment variables.
+    argv_ptrs.push_back(0);
+
+    int32_t argv = offset;
+    for (int32_t ptr : argv_ptrs) {
+      mem_->StoreInt32(offset, ptr);
+      mem_->StoreInt32(offset + 4, 0);
+      offset += 8;
+    }
+
+{{if .Watchdog}}    if (watchdog_) {
+      watchdog_->Begin();
+    }
+{{end}}    inst_->run(argc, argv);
+{{if .Watchdog}}    if (watchdog_) {
+      watchdog_->End();
+    }
+{{end}}
+    if (snapshot_handler_ && !exited_) {
+      std::vector<uint8_t> snapshot;
+      std::string err;
+      if (TakeSnapshot(&snapshot, &err)) {
+        snapshot_handler_(snapshot);
+      } else {
+        Log(LogLevel::kError, "taking a snapshot failed: " + err);
+      }
+    }
+    recording_value_origins_ = false;
+    value_origins_.clear();
+    func_wrappers_.clear();
+  }
 
   while (!exited_) {
-    TaskQueue::Task task = task_queue_.Dequeue();
-    task();
+{{if .Deterministic}}    EnqueueVirtualTasks();
+{{end}}    TaskQueue::Task task = task_queue_.Dequeue();
+{{if .Watchdog}}    if (watchdog_) {
+      watchdog_->Begin();
+    }
+{{end}}    task();
+    task_count_++;
+    for (size_t i = 1; i < task_policy_.max_tasks_per_pump && !exited_; i++) {
+      if (!task_queue_.TryDequeue(&task)) {
+        break;
+      }
+{{if .Watchdog}}      if (watchdog_) {
+        watchdog_->Begin();
+      }
+{{end}}      task();
+      task_count_++;
+    }
+{{if .Watchdog}}    if (watchdog_) {
+      watchdog_->End();
+    }
+{{end}}
     GC();
+    if (gc_policy_.collect_on_idle && task_queue_.Size() == 0) {
+      CollectGarbage(gc_policy_.idle_time_budget);
+    }
   }
 
+  Promise::SetScheduler(std::move(prev_promise_scheduler));
+{{if .Watchdog}}  watchdog_.reset();
+{{end}}
   return static_cast<int>(exit_code_);
 }
 
@@ -876,6 +2202,9 @@ void Go::StoreValue(int32_t addr, Value v) {
 
   int32_t id = GetIdFromValue(v);
   go_ref_counts_[id]++;
+#if defined(GO2CPP_DEBUG_REFS)
+  ref_givers_[id] = current_ref_op_;
+#endif
 
   int32_t type_flag = 0;
   if (v.IsString()) {
@@ -902,6 +2231,12 @@ std::vector<Value> Go::LoadSliceOfValues(int32_t addr) {
 
 void Go::Exit(int32_t code) {
   exit_code_ = code;
+  if (code != 0) {
+    Crash(code, "");
+  }
+  if (hooks_.on_exit) {
+    hooks_.on_exit(code);
+  }
 }
 
 void Go::Resume() {
@@ -914,95 +2249,461 @@ void Go::Resume() {
 }
 
 Value Go::MakeFuncWrapper(int32_t id) {
-  // empty_args is a Value of an empty array for arguments.
-  // This assumes that the argment array is never modified in the callbacks.
-  // By using the same Value, this can avoid being finalized at syscall/js.finalizeRef.
-  static Value empty_args = Value{std::vector<Value>()};
-
   static constexpr double inf = std::numeric_limits<double>::infinity();
-  go_ref_counts_[GetIdFromValue(empty_args)] = inf;
-
-  // evt represents the next function to be called when resuming.
-  // As resuming never happens recursively, this value should be reusable.
-  static Value evt = Value{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
-    {"id", Value{0.0}},
-    {"this", Value{}},
-    {"args", Value{}},
-  })};
-  go_ref_counts_[GetIdFromValue(evt)] = inf;
+  go_ref_counts_[GetIdFromValue(empty_args_)] = inf;
+  go_ref_counts_[GetIdFromValue(func_wrapper_event_)] = inf;
 
-  return Value{std::make_shared<Function>(
+  Value f{std::make_shared<Function>(
     [this, id](Value self, std::vector<Value> args) -> Value {
       Value argsv;
 
       if (args.size()) {
         argsv = Value{args};
       } else {
-        argsv = empty_args;
+        argsv = empty_args_;
       }
 
+      Value evt = func_wrapper_event_;
       evt.ToObject().Set("id", Value{static_cast<double>(id)});
       evt.ToObject().Set("this", self);
       evt.ToObject().Set("args", argsv);
       pending_event_ = evt;
-
+{{if .Record}}
+      if (hooks_.on_event) {
+        hooks_.on_event("func " + std::to_string(id) + " " + argsv.Inspect());
+      }
+{{end}}
       Resume();
       // After Resume is called, pending_event_ should be null.
 
-      return Value::ReflectGet(evt, "result");
+      Value result = Value::ReflectGet(evt, "result");
+
+      // Clear the event so that the arguments and the result are not kept alive until the next call.
+      evt.ToObject().Set("this", Value{});
+      evt.ToObject().Set("args", Value{});
+      evt.ToObject().Set("result", Value{});
+
+      return result;
     }
   )};
+  if (recording_value_origins_) {
+    func_wrappers_[f] = id;
+  }
+  return f;
 }
 
 void Go::DebugWrite(BytesSpan bytes) {
-  debug_writer_->Write(std::vector<uint8_t>(bytes.begin(), bytes.end()));
+  if (hooks_.on_crash) {
+    for (uint8_t b : bytes) {
+      if (b != '\n') {
+        debug_line_.push_back(static_cast<char>(b));
+        continue;
+      }
+      debug_lines_.push_back(std::move(debug_line_));
+      debug_line_.clear();
+      if (debug_lines_.size() > CrashReport::kMaxDebugLines) {
+        debug_lines_.pop_front();
+      }
+    }
+  }
+  if (hooks_.on_debug_write) {
+    hooks_.on_debug_write(bytes);
+    return;
+  }
+  host_->DebugWrite(std::vector<uint8_t>(bytes.begin(), bytes.end()));
 }
 
-int64_t Go::PreciseNowInNanoseconds() {
-  std::chrono::nanoseconds duration = std::chrono::high_resolution_clock::now() - start_time_point_;
-  return duration.count();
+void Go::Crash(int32_t code, std::string reason) {
+  if (!hooks_.on_crash || crashed_) {
+    return;
+  }
+  crashed_ = true;
+
+  CrashReport report;
+  report.exit_code = code;
+  report.debug_lines.assign(debug_lines_.begin(), debug_lines_.end());
+  if (!debug_line_.empty()) {
+    report.debug_lines.push_back(debug_line_);
+  }
+  if (reason.empty()) {
+    for (const std::string& line : report.debug_lines) {
+      if (line.rfind("panic: ", 0) == 0 || line.rfind("fatal error: ", 0) == 0) {
+        reason = line;
+        break;
+      }
+    }
+  }
+  if (reason.empty()) {
+    reason = "exit code " + std::to_string(code);
+  }
+  report.reason = std::move(reason);
+  hooks_.on_crash(report);
 }
 
-double Go::UnixNowInMilliseconds() {
-  std::chrono::milliseconds now =
-      std::chrono::duration_cast<std::chrono::milliseconds>(std::chrono::system_clock::now().time_since_epoch());
-  return now.count();
+{{if .Record}}void Go::OnImport(const char* name, int32_t sp, int32_t args_size) {
+  if (hooks_.on_import) {
+    hooks_.on_import(name, mem_->LoadSliceDirectly(sp + 8, args_size));
+  }
 }
 
+{{end}}int64_t Go::PreciseNowInNanoseconds() {
+{{if .Deterministic}}  return deterministic_source_->GetTime();
+{{else}}  return host_->GetMonotonicTime() - start_time_;
+{{end}}}
+
+double Go::UnixNowInMilliseconds() {
+{{if .Deterministic}}  return static_cast<double>(deterministic_source_->GetTime() / 1000000);
+{{else}}  return static_cast<double>(host_->GetUnixTime() / 1000000);
+{{end}}}
+
 int32_t Go::SetTimeout(double interval) {
   int32_t id = next_callback_timeout_id_;
   next_callback_timeout_id_++;
-
-  std::unique_ptr<Timer> timer = std::make_unique<Timer>(
-    [this, id] {
-      task_queue_.Enqueue([this, id]{
-        Resume();
-        while (scheduled_timeouts_.find(id) != scheduled_timeouts_.end()) {
-          // for some reason Go failed to register the timeout event, log and try again
-          // (temporary workaround for https://github.com/golang/go/issues/28975)
-          Resume();
-        }
-      });
-    }, interval);
-  scheduled_timeouts_[id] = std::move(timer);
+  ScheduleTimeout(id, interval);
   return id;
 }
 
+void Go::ScheduleTimeout(int32_t id, double interval) {
+  auto task = [this, id]{
+{{if .Deterministic}}    if (scheduled_timeouts_.find(id) == scheduled_timeouts_.end()) {
+      // The timeout was cleared.
+      return;
+    }
+{{end}}{{if .Record}}    if (hooks_.on_event) {
+      hooks_.on_event("timeout " + std::to_string(id));
+    }
+{{end}}    Resume();
+    int retries = 0;
+    auto start = std::chrono::steady_clock::now();
+    while (scheduled_timeouts_.find(id) != scheduled_timeouts_.end()) {
+      // for some reason Go failed to register the timeout event, log and try again
+      // (temporary workaround for https://github.com/golang/go/issues/28975)
+      double elapsed = std::chrono::duration<double, std::milli>(std::chrono::steady_clock::now() - start).count();
+      if ((GO2CPP_MAX_RESUME_RETRIES > 0 && retries >= GO2CPP_MAX_RESUME_RETRIES) ||
+          (GO2CPP_RESUME_RETRY_TIMEOUT_MS > 0 && elapsed >= GO2CPP_RESUME_RETRY_TIMEOUT_MS)) {
+        error("the timeout " + std::to_string(id) + " is still scheduled after " + std::to_string(retries) +
+              " retries of resuming the Go program in " + std::to_string(static_cast<int64_t>(elapsed)) +
+              " ms (" + std::to_string(scheduled_timeouts_.size()) + " scheduled timeouts, " +
+              std::to_string(task_queue_.Size()) + " pending tasks): the Go program might have livelocked");
+      }
+      retries++;
+      Resume();
+    }
+  };
+{{if .Deterministic}}  // The timeout runs on the virtual clock, and no Timer is used.
+  scheduled_timeouts_[id] = nullptr;
+  EnqueueTaskAt(deterministic_source_->GetTime() + static_cast<int64_t>(interval * 1e6), task,
+                TaskQueue::Priority::kTimer);
+{{else}}  scheduled_timeouts_[id] = std::make_unique<Timer>([this, task] {
+    task_queue_.Enqueue(task, TaskQueue::Priority::kTimer);
+  }, interval);
+{{end}}}
+
 void Go::ClearTimeout(int32_t id) {
   scheduled_timeouts_.erase(id);
 }
-
+{{if .Deterministic}}
+void Go::EnqueueVirtualTasks() {
+  if (virtual_tasks_.empty()) {
+    return;
+  }
+  if (task_queue_.Size() == 0) {
+    deterministic_source_->AdvanceTime(virtual_tasks_.begin()->first);
+  }
+  int64_t now = deterministic_source_->GetTime();
+  while (!virtual_tasks_.empty() && virtual_tasks_.begin()->first <= now) {
+    auto it = virtual_tasks_.begin();
+    task_queue_.Enqueue(std::move(it->second.task), it->second.priority);
+    virtual_tasks_.erase(it);
+  }
+}
+{{end}}
 void Go::GetRandomBytes(BytesSpan bytes) {
-  // TODO: Use cryptographically strong random values instead of std::random_device.
-  static std::random_device rd;
-  std::uniform_int_distribution<uint8_t> dist(0, 255);
-  for (int i = 0; i < bytes.size(); i++) {
-    bytes[i] = dist(rd);
+{{if .Deterministic}}  deterministic_source_->GetRandomBytes(bytes);
+{{else}}  host_->GetRandomBytes(bytes);
+{{end}}}
+
+void Go::BindHostServices() {
+  HostServices* host = host_;
+  auto& global = Value::Global().ToObject();
+  UnbindHostServices();
+  auto bind = [this, &global](const std::string& key, Value value) {
+    bound_globals_.push_back(BoundGlobal{key, global.Get(key), value});
+    global.Set(key, value);
+  };
+
+  auto log = [host](HostServices::LogLevel level) -> Value {
+    return Value{std::make_shared<Function>(
+      [host, level](Value self, std::vector<Value> args) -> Value {
+        host->Log(level, FormatConsoleMessage(args));
+        return Value{};
+      })};
+  };
+  bind("console", Value{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+    {"error", log(HostServices::LogLevel::kError)},
+    {"debug", log(HostServices::LogLevel::kDebug)},
+    {"info", log(HostServices::LogLevel::kInfo)},
+    {"log", log(HostServices::LogLevel::kInfo)},
+    {"trace", log(HostServices::LogLevel::kDebug)},
+    {"warn", log(HostServices::LogLevel::kWarning)},
+  })});
+
+  bind("crypto", Value{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+    {"getRandomValues", Value{std::make_shared<Function>(
+      [this](Value self, std::vector<Value> args) -> Value {
+        if (!capabilities_.random) {
+          throw Exception{"Error", "crypto.getRandomValues is disabled by Go::Capabilities"};
+        }
+        GetRandomBytes(args[0].ToBytes());
+        return Value{};
+      })}},
+  })});
+
+  // performance.now is relative to the start of Go like runtime.nanotime, and timeOrigin is the Unix time of the
+  // start in milliseconds.
+  double now = static_cast<double>(PreciseNowInNanoseconds()) / 1e6;
+{{if .Deterministic}}  double time_origin = static_cast<double>(deterministic_source_->GetTime()) / 1e6 - now;
+{{else}}  double time_origin = static_cast<double>(host->GetUnixTime()) / 1e6 - now;
+{{end}}
+  bind("performance", Value{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+    {"now", Value{std::make_shared<Function>(
+      [this](Value self, std::vector<Value> args) -> Value {
+        return Value{static_cast<double>(PreciseNowInNanoseconds()) / 1e6};
+      })}},
+    {"timeOrigin", Value{time_origin}},
+  })});
+
+  bind("localStorage", Value{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+    {"getItem", Value{std::make_shared<Function>(
+      [this, host](Value self, std::vector<Value> args) -> Value {
+        if (!capabilities_.storage) {
+          throw Exception{"Error", "localStorage.getItem is disabled by Go::Capabilities"};
+        }
+        return Value{host->GetLocalStorageItem(args[0].ToString())};
+      })}},
+    {"setItem", Value{std::make_shared<Function>(
+      [this, host](Value self, std::vector<Value> args) -> Value {
+        if (!capabilities_.storage) {
+          throw Exception{"Error", "localStorage.setItem is disabled by Go::Capabilities"};
+        }
+        host->SetLocalStorageItem(args[0].ToString(), args[1].ToString());
+        return Value{};
+      })}},
+  })});
+
+  // The global object is shared. The original values are kept so that another Go can enable them again.
+  static Value fs = global.Get("fs");
+  static Value fetch = global.Get("fetch");
+  bind("fs", capabilities_.filesystem ? fs : Value{std::make_shared<DisabledFS>(fs)});
+  if (capabilities_.network) {
+    bind("fetch", fetch);
+  } else {
+    bind("fetch", Value{std::make_shared<Function>(
+      [](Value self, std::vector<Value> args) -> Value {
+        auto promise = std::make_shared<Promise>();
+        promise->Reject(Exception{"TypeError", "fetch is disabled by Go::Capabilities"}.GetValue());
+        return Value{promise};
+      })});
+  }
+}
+
+void Go::UnbindHostServices() {
+  auto& global = Value::Global().ToObject();
+  // Restore in the reverse order. A property that another Go has already replaced is left as it is.
+  for (auto it = bound_globals_.rbegin(); it != bound_globals_.rend(); ++it) {
+    if (!(global.Get(it->key) == it->bound)) {
+      continue;
+    }
+    if (it->previous.IsUndefined()) {
+      global.Delete(it->key);
+    } else {
+      global.Set(it->key, it->previous);
+    }
+  }
+  bound_globals_.clear();
+}
+
+void Go::EnqueueTask(std::function<void()> task, TaskQueue::Priority priority) {
+  task_queue_.Enqueue(std::move(task), priority);
+}
+{{if .Deterministic}}
+void Go::EnqueueTaskAt(int64_t time, std::function<void()> task, TaskQueue::Priority priority) {
+  virtual_tasks_.emplace(time, VirtualTask{std::move(task), priority});
+}
+
+int64_t Go::GetVirtualTime() {
+  return deterministic_source_->GetTime();
+}
+{{end}}
+Go::BuildInfo Go::GetBuildInfo() {
+  BuildInfo info;
+  info.go_version = kGoVersion;
+  info.go_build_id = kGoBuildID;
+  info.module_hash = kModuleHash;
+  info.go2cpp_version = kGo2CppVersion;
+  info.generator_version = kGeneratorVersion;
+  info.build_time = kBuildTime;
+  for (const ProducerEntry* p = kProducers; p->field; p++) {
+    info.producers.push_back(BuildInfo::Producer{p->field, p->name, p->version});
+  }
+  return info;
+}
+
+BytesSpan Go::GetMemoryView() {
+  if (!mem_) {
+    return BytesSpan{};
+  }
+  return mem_->GetView();
+}
+
+Go::Stats Go::GetStats() {
+  Stats stats;
+  stats.memory_size = mem_ ? static_cast<size_t>(mem_->GetSize()) * Mem::kPageSize : 0;
+  stats.value_count = values_.size();
+  stats.finalizing_value_count = finalizing_ids_.size();
+  stats.scheduled_timeout_count = scheduled_timeouts_.size();
+  stats.task_queue_length = task_queue_.Size();
+  stats.task_count = task_count_;
+  stats.finalized_value_count = finalized_value_count_;
+  return stats;
+}
+
+void Go::DumpValues(std::ostream& out) {
+  constexpr size_t kMaxDescriptionLength = 80;
+
+  std::vector<int32_t> ids;
+  ids.reserve(values_.size());
+  for (auto& kv : values_) {
+    ids.push_back(kv.first);
+  }
+  std::sort(ids.begin(), ids.end());
+
+  out << "id\trefs\ttype\tdescription\n";
+  for (int32_t id : ids) {
+    const Value& v = values_[id];
+    std::string type;
+    if (v.IsUndefined()) {
+      type = "undefined";
+    } else if (v.IsNull()) {
+      type = "null";
+    } else if (v.IsBool()) {
+      type = "boolean";
+    } else if (v.IsNumber()) {
+      type = "number";
+    } else if (v.IsString()) {
+      type = "string";
+    } else if (v.IsArray()) {
+      type = "array";
+    } else if (v.IsObject()) {
+      type = const_cast<Value&>(v).ToObject().ToString();
+    }
+
+    std::string desc = v.IsString() ? "\"" + v.ToString() + "\"" : v.Inspect();
+    if (desc.size() > kMaxDescriptionLength) {
+      desc = desc.substr(0, kMaxDescriptionLength) + "...";
+    }
+    for (char& c : desc) {
+      if (c == '\n' || c == '\t') {
+        c = ' ';
+      }
+    }
+
+    auto count = go_ref_counts_.find(id);
+    out << id << '\t' << (count != go_ref_counts_.end() ? NumberToString(count->second) : "-") << '\t' << type << '\t'
+        << desc;
+    if (finalizing_ids_.count(id)) {
+      out << " (finalizing)";
+    }
+#if defined(GO2CPP_DEBUG_REFS)
+    auto giver = ref_givers_.find(id);
+    if (giver != ref_givers_.end()) {
+      out << " (given by " << giver->second << ")";
+    }
+#endif
+    out << '\n';
   }
+  out << values_.size() << " values, " << finalizing_ids_.size() << " finalizing\n";
+}
+
+void Go::SetSnapshotHandler(std::function<void(const std::vector<uint8_t>&)> handler) {
+  snapshot_handler_ = handler;
 }
 
-void Go::EnqueueTask(std::function<void()> task) {
-  task_queue_.Enqueue(task);
+void Go::SetInstanceFactory(InstanceFactory factory) {
+  instance_factory_ = std::move(factory);
+}
+
+void Go::SetHooks(Hooks hooks) {
+  hooks_ = std::move(hooks);
+}
+
+void Go::SetCapabilities(const Capabilities& capabilities) {
+  capabilities_ = capabilities;
+}
+
+void Go::SetGlobalOverride(const std::string& key, Value value) {
+  global_overrides_[key] = value;
+}
+
+void Go::ApplyGlobalOverrides() {
+  auto& global = Value::Global().ToObject();
+  for (auto& kv : global_overrides_) {
+    if (kv.second.IsUndefined()) {
+      global.Delete(kv.first);
+      continue;
+    }
+    global.Set(kv.first, kv.second);
+  }
+}
+{{if .Deterministic}}
+void Go::SetDeterministicSource(DeterministicSource* source) {
+  deterministic_source_ = source ? source : &default_deterministic_source_;
+}
+{{end}}
+void Go::SetSnapshot(std::vector<uint8_t> snapshot) {
+  snapshot_ = std::move(snapshot);
+}
+
+const std::string* Go::KeyTable::Intern(const uint8_t* data, size_t size) {
+  if (size > kMaxKeyLength) {
+    return nullptr;
+  }
+
+  // FNV-1a
+  uint32_t hash = 2166136261u;
+  for (size_t i = 0; i < size; i++) {
+    hash ^= data[i];
+    hash *= 16777619u;
+  }
+
+  if (slots_.empty()) {
+    slots_.resize(kSlotCount);
+    hashes_.resize(kSlotCount);
+  }
+  for (size_t i = hash & (kSlotCount - 1);; i = (i + 1) & (kSlotCount - 1)) {
+    const std::unique_ptr<std::string>& slot = slots_[i];
+    if (!slot) {
+      if (count_ >= kMaxKeys) {
+        return nullptr;
+      }
+      slots_[i] = std::make_unique<std::string>(reinterpret_cast<const char*>(data), size);
+      hashes_[i] = hash;
+      count_++;
+      return slots_[i].get();
+    }
+    if (hashes_[i] == hash && slot->size() == size && std::memcmp(slot->data(), data, size) == 0) {
+      return slot.get();
+    }
+  }
+}
+
+const std::string& Go::LoadKey(int32_t addr, std::string* storage) {
+  BytesSpan bytes = mem_->LoadSlice(addr);
+  if (const std::string* key = key_table_.Intern(bytes.data(), bytes.size())) {
+    return *key;
+  }
+  storage->assign(reinterpret_cast<const char*>(bytes.data()), bytes.size());
+  return *storage;
 }
 
 int32_t Go::GetIdFromValue(const Value& value) {
@@ -1015,9 +2716,13 @@ int32_t Go::GetIdFromValue(const Value& value) {
 
   int32_t id = 0;
   if (id_pool_.size()) {
-    id = *id_pool_.begin();
-    id_pool_.erase(id);
+    id = id_pool_.back();
+    id_pool_.pop_back();
   } else {
+    // A ref has a 32-bit ID, and the IDs are kept non-negative as int32_t.
+    if (next_id_ == std::numeric_limits<int32_t>::max()) {
+      error("too many values for Go: the IDs of the values exceed 2^31");
+    }
     id = next_id_;
     next_id_++;
   }
@@ -1028,26 +2733,506 @@ int32_t Go::GetIdFromValue(const Value& value) {
   return id;
 }
 
+Go::ScopedSP::ScopedSP(Go* go, int32_t* sp)
+    : go_{go},
+      sp_{sp} {
+#if defined(GO2CPP_DEBUG_SP)
+  if (*sp_ != go_->inst_->getsp()) {
+    error("sp mismatch at an import call: " + std::to_string(*sp_) + " (given) vs " +
+          std::to_string(go_->inst_->getsp()) + " (current)");
+  }
+#endif
+}
+
+Go::ScopedSP::~ScopedSP() {
+#if defined(GO2CPP_DEBUG_SP)
+  if (*sp_ != go_->inst_->getsp()) {
+    error("sp mismatch at the end of an import call: " + std::to_string(*sp_) + " (used) vs " +
+          std::to_string(go_->inst_->getsp()) + " (current); the stack pointer is not reloaded after calling Go");
+  }
+#endif
+}
+
+void Go::ScopedSP::Reload() {
+  *sp_ = go_->inst_->getsp();
+}
+
+#if defined(GO2CPP_DEBUG_REFS)
+Go::RefCheckScope::RefCheckScope(Go* go, const char* op)
+    : go_{go},
+      op_{op},
+      prev_op_{go->current_ref_op_} {
+  go_->current_ref_op_ = op;
+}
+
+Go::RefCheckScope::~RefCheckScope() {
+  go_->CheckRefs(op_);
+  go_->current_ref_op_ = prev_op_;
+}
+
+void Go::CheckRefs(const char* op) {
+  auto fail = [this, op](int32_t id, const std::string& msg) {
+    std::string giver = "none";
+    auto giver_it = ref_givers_.find(id);
+    if (giver_it != ref_givers_.end()) {
+      giver = giver_it->second;
+    }
+    std::string finalizer = "none";
+    auto finalizer_it = ref_finalizers_.find(id);
+    if (finalizer_it != ref_finalizers_.end()) {
+      finalizer = finalizer_it->second;
+    }
+    error(std::string("ref check failed after ") + op + ": id " + std::to_string(id) + ": " + msg +
+          " (given to Go by: " + giver + ", finalized by: " + finalizer + ")");
+  };
+
+  for (auto& kv : values_) {
+    int32_t id = kv.first;
+    auto it = ids_.find(kv.second);
+    // NaN, the predefined ID 0, is not mapped, as NaN is not equal to itself.
+    if (id != 0 && (it == ids_.end() || it->second != id)) {
+      fail(id, "the value is not mapped to the ID");
+    }
+    if (go_ref_counts_.find(id) == go_ref_counts_.end()) {
+      fail(id, "the value has no ref count");
+    }
+    if (std::find(id_pool_.begin(), id_pool_.end(), id) != id_pool_.end()) {
+      fail(id, "the ID is in use but pooled");
+    }
+  }
+  for (auto& kv : ids_) {
+    if (values_.find(kv.second) == values_.end()) {
+      fail(kv.second, "the ID has no value");
+    }
+  }
+  for (auto& kv : go_ref_counts_) {
+    if (kv.second < 0) {
+      fail(kv.first, "the ref count is negative (" + std::to_string(kv.second) + "), i.e., finalized more than given");
+    }
+  }
+  for (int32_t id : finalizing_ids_) {
+    if (values_.find(id) == values_.end()) {
+      fail(id, "the finalizing ID has no value");
+    }
+    if (go_ref_counts_[id] != 0) {
+      fail(id, "the finalizing value is still referred to by Go (" + std::to_string(go_ref_counts_[id]) + ")");
+    }
+  }
+}
+#endif
+
 void Go::GC() {
-  size_t num = std::min(finalizing_ids_.size(), std::max(finalizing_ids_.size() / 16, 64ul));
-  for (size_t i = 0; i < num; i++) {
-    auto it = finalizing_ids_.begin();
-    int32_t id = *it;
+  size_t num = gc_policy_.batch_size_min;
+  if (gc_policy_.batch_size_divisor) {
+    num = std::max(finalizing_ids_.size() / gc_policy_.batch_size_divisor, num);
+  }
+  FinalizeValues(num, gc_policy_.time_budget);
+}
+
+void Go::SetTaskPolicy(const TaskPolicy& policy) {
+  task_policy_ = policy;
+}
+
+void Go::SetGCPolicy(const GCPolicy& policy) {
+  gc_policy_ = policy;
+}
+{{if .Watchdog}}
+void Go::SetWatchdog(std::chrono::milliseconds timeout, std::function<void(const Watchdog::Report& report)> on_frozen) {
+  watchdog_timeout_ = timeout;
+  on_frozen_ = std::move(on_frozen);
+}
+{{end}}
+size_t Go::CollectGarbage(std::chrono::microseconds budget) {
+  return FinalizeValues(std::numeric_limits<size_t>::max(), budget);
+}
+
+size_t Go::FinalizeValues(size_t max_num, std::chrono::microseconds budget) {
+  auto start = std::chrono::steady_clock::now();
+  size_t num = 0;
+  while (num < max_num && !finalizing_queue_.empty()) {
+    if (budget.count() > 0 && std::chrono::steady_clock::now() - start >= budget) {
+      break;
+    }
+    int32_t id = finalizing_queue_.front();
+    finalizing_queue_.pop_front();
+    auto it = finalizing_ids_.find(id);
+    if (it == finalizing_ids_.end()) {
+      // Go got the value again before the value was finalized.
+      continue;
+    }
     Value v = values_[id];
     values_.erase(id);
     ids_.erase(v);
-    id_pool_.insert(id);
+    // Forget the finalized value for the snapshot, so that a released js.Func is not kept alive and a reused ID doesn't
+    // have a stale origin.
+    func_wrappers_.erase(v);
+    value_origins_.erase(id);
+    id_pool_.push_back(id);
     finalizing_ids_.erase(it);
+    num++;
   }
+  finalized_value_count_ += num;
+  return num;
 }
 
+void Go::RecordValueOrigin(const Value& target, const std::string& key, const Value& result) {
+  if (!recording_value_origins_) {
+    return;
+  }
+  if (!result.IsObject()) {
+    return;
+  }
+  auto target_it = ids_.find(target);
+  if (target_it == ids_.end()) {
+    return;
+  }
+  auto result_it = ids_.find(result);
+  if (result_it == ids_.end()) {
+    return;
+  }
+  if (value_origins_.find(result_it->second) != value_origins_.end()) {
+    return;
+  }
+
+  ValueOrigin origin;
+  int32_t target_id = target_it->second;
+  if (target_id == 5 || target_id == 6) {
+    origin.root_id = target_id;
+  } else {
+    auto origin_it = value_origins_.find(target_id);
+    if (origin_it == value_origins_.end()) {
+      return;
+    }
+    origin = origin_it->second;
+  }
+  origin.keys.push_back(key);
+  value_origins_[result_it->second] = origin;
+}
+
+bool Go::TakeSnapshot(std::vector<uint8_t>* snapshot, std::string* error) {
+  if (!pending_event_.IsNull()) {
+    *error = "an event is pending";
+    return false;
+  }
+
+  // Values that Go no longer has must not be included.
+  CollectGarbage(std::chrono::microseconds{0});
+
+  SnapshotWriter w;
+  w.WriteString(kSnapshotMagic);
+  w.Write<uint32_t>(kSnapshotVersion);
+  w.Write<uint64_t>(kModuleHash);
+
+  w.Write<int64_t>(PreciseNowInNanoseconds());
+
+  std::vector<uint8_t> mem = mem_->Save();
+  w.WriteBytes(mem.data(), mem.size());
+  std::vector<uint64_t> globals = inst_->GetGlobals();
+  w.Write<uint64_t>(globals.size());
+  for (uint64_t g : globals) {
+    w.Write<uint64_t>(g);
+  }
+
+  w.Write<int32_t>(next_id_);
+  w.Write<uint64_t>(id_pool_.size());
+  for (int32_t id : id_pool_) {
+    w.Write<int32_t>(id);
+  }
+
+  // The predefined values (0-6) are created at Run.
+  w.Write<uint64_t>(values_.size() - 7);
+  for (auto& kv : values_) {
+    int32_t id = kv.first;
+    if (id <= 6) {
+      continue;
+    }
+    Value& v = kv.second;
+    w.Write<int32_t>(id);
+    w.Write<double>(go_ref_counts_[id]);
+    if (v.IsString()) {
+      w.Write(SnapshotValueKind::kString);
+      w.WriteString(v.ToString());
+      continue;
+    }
+    if (v == func_wrapper_event_) {
+      w.Write(SnapshotValueKind::kFuncWrapperEvent);
+      continue;
+    }
+    if (v == empty_args_) {
+      w.Write(SnapshotValueKind::kEmptyArgs);
+      continue;
+    }
+    auto fit = func_wrappers_.find(v);
+    if (fit != func_wrappers_.end()) {
+      w.Write(SnapshotValueKind::kFuncWrapper);
+      w.Write<int32_t>(fit->second);
+      continue;
+    }
+    auto oit = value_origins_.find(id);
+    if (oit != value_origins_.end()) {
+      w.Write(SnapshotValueKind::kOrigin);
+      w.Write<int32_t>(oit->second.root_id);
+      w.Write<uint64_t>(oit->second.keys.size());
+      for (const std::string& key : oit->second.keys) {
+        w.WriteString(key);
+      }
+      continue;
+    }
+    *error = "value " + std::to_string(id) + " cannot be reconstructed: " + v.Inspect();
+    return false;
+  }
+
+  // The remaining time of the timers is not recorded. The timers are fired immediately after restoring, and the Go
+  // runtime schedules the timers again if needed.
+  w.Write<int32_t>(next_callback_timeout_id_);
+  w.Write<uint64_t>(scheduled_timeouts_.size());
+  for (auto& kv : scheduled_timeouts_) {
+    w.Write<int32_t>(kv.first);
+  }
+
+  *snapshot = std::move(w.bytes());
+  return true;
 }
-`))
 
-var specialFunctionBodies = map[string]string{
-	"memcmp":           `  return static_cast<int32_t>(mem_->Memcmp(local0_, local1_, local2_));`,
-	"memeqbody":        `  return static_cast<int64_t>(mem_->Memcmp(local0_, local1_, local2_) == 0);`,
-	"memchr":           `  return static_cast<int32_t>(mem_->Memchr(local0_, local1_, local2_));`,
-	"runtime.wasmMove": `  mem_->Memmove(local0_, local1_, local2_ * 8);`,
-	"runtime.wasmZero": `  mem_->Memset(local0_, 0, local1_ * 8);`,
+bool Go::RestoreSnapshot(const std::vector<uint8_t>& snapshot, std::string* error) {
+  SnapshotReader r{snapshot};
+
+  std::string magic;
+  if (!r.ReadString(&magic) || magic != kSnapshotMagic) {
+    *error = "invalid format";
+    return false;
+  }
+  uint32_t version = 0;
+  if (!r.Read(&version) || version != kSnapshotVersion) {
+    *error = "unsupported version";
+    return false;
+  }
+  uint64_t module_hash = 0;
+  if (!r.Read(&module_hash) || module_hash != kModuleHash) {
+    *error = "the snapshot was taken with a different program";
+    return false;
+  }
+
+  // Read all the data before modifying the state so that Run can continue without the snapshot on failure.
+  int64_t elapsed = 0;
+  std::vector<uint8_t> mem;
+  uint64_t globals_num = 0;
+  if (!r.Read(&elapsed) || !r.ReadBytes(&mem) || !r.Read(&globals_num)) {
+    *error = "unexpected end of the snapshot";
+    return false;
+  }
+  if (mem.size() % Mem::kPageSize != 0) {
+    *error = "invalid memory size";
+    return false;
+  }
+  if (globals_num != inst_->GetGlobals().size()) {
+    *error = "invalid number of globals";
+    return false;
+  }
+  if (!r.CanRead(globals_num, sizeof(uint64_t))) {
+    *error = "unexpected end of the snapshot";
+    return false;
+  }
+  std::vector<uint64_t> globals(globals_num);
+  for (uint64_t& g : globals) {
+    if (!r.Read(&g)) {
+      *error = "unexpected end of the snapshot";
+      return false;
+    }
+  }
+
+  int32_t next_id = 0;
+  uint64_t id_pool_num = 0;
+  if (!r.Read(&next_id) || !r.Read(&id_pool_num)) {
+    *error = "unexpected end of the snapshot";
+    return false;
+  }
+  if (next_id <= 6) {
+    *error = "invalid next ID " + std::to_string(next_id);
+    return false;
+  }
+  if (!r.CanRead(id_pool_num, sizeof(int32_t))) {
+    *error = "unexpected end of the snapshot";
+    return false;
+  }
+  // The IDs must be consistent, or an ID would be given to two values.
+  std::vector<int32_t> id_pool;
+  std::unordered_set<int32_t> pooled_ids;
+  for (uint64_t i = 0; i < id_pool_num; i++) {
+    int32_t id = 0;
+    if (!r.Read(&id)) {
+      *error = "unexpected end of the snapshot";
+      return false;
+    }
+    if (id <= 6 || id >= next_id || !pooled_ids.insert(id).second) {
+      *error = "invalid pooled ID " + std::to_string(id);
+      return false;
+    }
+    id_pool.push_back(id);
+  }
+
+  uint64_t values_num = 0;
+  if (!r.Read(&values_num)) {
+    *error = "unexpected end of the snapshot";
+    return false;
+  }
+  // Each value has at least an ID, a reference count and a kind.
+  if (!r.CanRead(values_num, sizeof(int32_t) + sizeof(double) + sizeof(SnapshotValueKind))) {
+    *error = "unexpected end of the snapshot";
+    return false;
+  }
+  // The function wrappers are made after the whole snapshot is read, as MakeFuncWrapper modifies the state. A value
+  // with a function ID of -1 is not a function wrapper.
+  std::vector<std::tuple<int32_t, Value, double, int32_t>> values;
+  std::unordered_set<int32_t> value_ids;
+  std::unordered_set<Value, Value::Hash> value_set;
+  std::unordered_set<int32_t> func_ids;
+  for (uint64_t i = 0; i < values_num; i++) {
+    int32_t id = 0;
+    double ref_count = 0;
+    SnapshotValueKind kind;
+    if (!r.Read(&id) || !r.Read(&ref_count) || !r.Read(&kind)) {
+      *error = "unexpected end of the snapshot";
+      return false;
+    }
+    if (id <= 6 || id >= next_id) {
+      *error = "invalid value ID " + std::to_string(id);
+      return false;
+    }
+    if (!value_ids.insert(id).second) {
+      *error = "duplicate value ID " + std::to_string(id);
+      return false;
+    }
+    if (pooled_ids.count(id)) {
+      *error = "value ID " + std::to_string(id) + " is also pooled";
+      return false;
+    }
+    Value v;
+    int32_t func_id = -1;
+    switch (kind) {
+    case SnapshotValueKind::kString: {
+      std::string str;
+      if (!r.ReadString(&str)) {
+        *error = "unexpected end of the snapshot";
+        return false;
+      }
+      v = Value{str};
+      break;
+    }
+    case SnapshotValueKind::kOrigin: {
+      int32_t root_id = 0;
+      uint64_t keys_num = 0;
+      if (!r.Read(&root_id) || !r.Read(&keys_num) || (root_id != 5 && root_id != 6)) {
+        *error = "invalid value origin";
+        return false;
+      }
+      v = values_[root_id];
+      for (uint64_t j = 0; j < keys_num; j++) {
+        std::string key;
+        if (!r.ReadString(&key)) {
+          *error = "unexpected end of the snapshot";
+          return false;
+        }
+        if (!v.IsObject()) {
+          *error = "value " + std::to_string(id) + " is not found at " + key;
+          return false;
+        }
+        v = Value::ReflectGet(v, key);
+      }
+      if (!v.IsObject()) {
+        *error = "value " + std::to_string(id) + " is not an object";
+        return false;
+      }
+      break;
+    }
+    case SnapshotValueKind::kFuncWrapper:
+      if (!r.Read(&func_id) || func_id < 0 || !func_ids.insert(func_id).second) {
+        *error = "invalid function wrapper";
+        return false;
+      }
+      break;
+    case SnapshotValueKind::kFuncWrapperEvent:
+      v = func_wrapper_event_;
+      break;
+    case SnapshotValueKind::kEmptyArgs:
+      v = empty_args_;
+      break;
+    default:
+      *error = "invalid value kind";
+      return false;
+    }
+    // A value has only one ID.
+    if (func_id < 0 && !value_set.insert(v).second) {
+      *error = "value " + std::to_string(id) + " is duplicated";
+      return false;
+    }
+    values.emplace_back(id, v, ref_count, func_id);
+  }
+
+  int32_t next_callback_timeout_id = 0;
+  uint64_t timeouts_num = 0;
+  if (!r.Read(&next_callback_timeout_id) || !r.Read(&timeouts_num)) {
+    *error = "unexpected end of the snapshot";
+    return false;
+  }
+  if (!r.CanRead(timeouts_num, sizeof(int32_t))) {
+    *error = "unexpected end of the snapshot";
+    return false;
+  }
+  std::vector<int32_t> timeouts(timeouts_num);
+  for (int32_t& id : timeouts) {
+    if (!r.Read(&id)) {
+      *error = "unexpected end of the snapshot";
+      return false;
+    }
+  }
+  if (!r.AtEnd()) {
+    *error = "unexpected data at the end of the snapshot";
+    return false;
+  }
+
+  // The snapshot is valid. Modify the state from here.
+  for (auto& v : values) {
+    if (std::get<3>(v) >= 0) {
+      std::get<1>(v) = MakeFuncWrapper(std::get<3>(v));
+    }
+  }
+
+  // MakeFuncWrapper registers empty_args_ and func_wrapper_event_ with new IDs. Remove the values other than the
+  // predefined values before restoring them.
+  for (auto it = values_.begin(); it != values_.end();) {
+    if (it->first <= 6) {
+      ++it;
+      continue;
+    }
+    ids_.erase(it->second);
+    go_ref_counts_.erase(it->first);
+    it = values_.erase(it);
+  }
+  for (auto& v : values) {
+    int32_t id = std::get<0>(v);
+    values_[id] = std::get<1>(v);
+    ids_[std::get<1>(v)] = id;
+    go_ref_counts_[id] = std::get<2>(v);
+  }
+  next_id_ = next_id;
+  id_pool_ = std::move(id_pool);
+  finalizing_ids_.clear();
+  finalizing_queue_.clear();
+
+  mem_->Restore(mem);
+  inst_->SetGlobals(globals);
+
+  // Keep the monotonic clock continuous from the snapshot.
+{{if .Deterministic}}  deterministic_source_->AdvanceTime(elapsed);
+{{else}}  start_time_ = host_->GetMonotonicTime() - elapsed;
+{{end}}
+  next_callback_timeout_id_ = next_callback_timeout_id;
+  for (int32_t id : timeouts) {
+    ScheduleTimeout(id, 0);
+  }
+  return true;
 }
+
+}
+`))
@@ -0,0 +1,1123 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/go2cpp/version"
+)
+
+func TestGenerateMalformedModule(t *testing.T) {
+	src, err := readModule(filepath.Join("testdata", "ops", "control.wat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	// A truncated module lacks the name section at least. This must be an error.
+	for i := 0; i < len(src); i++ {
+		if err := generate(dir, "", src[:i], "go2cpp_test", nil); err == nil {
+			t.Errorf("generate with the first %d bytes must return an error", i)
+		}
+	}
+
+	// A broken module must not make the generator crash.
+	for i := 8; i < len(src); i++ {
+		data := make([]byte, len(src))
+		copy(data, src)
+		data[i] ^= 0xff
+		_ = generate(dir, "", data, "go2cpp_test", nil)
+	}
+}
+
+func TestCallGraph(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "callgraph.json")
+	if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ops", "control.wat"), "go2cpp_test", &Options{
+		CallGraph: path,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var c callGraph
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	funcs := map[string]*callGraphFunc{}
+	for _, f := range c.Functions {
+		funcs[f.Name] = f
+	}
+	debug, ok := funcs["debug"]
+	if !ok {
+		t.Fatal("debug is not found")
+	}
+	if !debug.Import {
+		t.Errorf("debug must be an import")
+	}
+	call, ok := funcs["call"]
+	if !ok {
+		t.Fatal("call is not found")
+	}
+	if got, want := call.Calls, []int{debug.Index}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls: got: %v, want: %v", got, want)
+	}
+	if call.Lines == 0 {
+		t.Errorf("lines must not be 0")
+	}
+	if got, want := funcs["call_indirect"].IndirectCalls, 1; got != want {
+		t.Errorf("indirect calls: got: %d, want: %d", got, want)
+	}
+}
+
+func TestSizeReport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "size.json")
+	if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ops", "control.wat"), "go2cpp_test", &Options{
+		SizeReport: path,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r sizeReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	// The functions in the test module have no Go packages.
+	if got, want := len(r.Packages), 1; got != want {
+		t.Fatalf("packages: got: %d, want: %d", got, want)
+	}
+	if got, want := r.Total, *r.Packages[0]; got != want {
+		t.Errorf("total: got: %+v, want: %+v", got, want)
+	}
+	if r.Total.WasmSize == 0 || r.Total.CppSize == 0 || r.Total.Lines == 0 {
+		t.Errorf("sizes must not be 0: %+v", r.Total)
+	}
+}
+
+func TestExports(t *testing.T) {
+	for _, tc := range []struct {
+		exports []string
+		funcs   []string
+		imports []string
+	}{
+		{
+			exports: []string{"test_block"},
+			funcs:   []string{"block"},
+		},
+		{
+			exports: []string{"test_call", "test_loop"},
+			funcs:   []string{"loop", "call"},
+			imports: []string{"debug"},
+		},
+		{
+			// The table has debug.
+			exports: []string{"test_call_indirect"},
+			funcs:   []string{"call_indirect"},
+			imports: []string{"debug"},
+		},
+	} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "callgraph.json")
+		if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ops", "control.wat"), "go2cpp_test", &Options{
+			CallGraph: path,
+			Exports:   tc.exports,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var c callGraph
+		if err := json.Unmarshal(b, &c); err != nil {
+			t.Fatal(err)
+		}
+		var funcs, imports []string
+		for _, f := range c.Functions {
+			if f.Import {
+				imports = append(imports, f.Name)
+				continue
+			}
+			funcs = append(funcs, f.Name)
+		}
+		if !reflect.DeepEqual(funcs, tc.funcs) {
+			t.Errorf("%v: functions: got: %v, want: %v", tc.exports, funcs, tc.funcs)
+		}
+		if !reflect.DeepEqual(imports, tc.imports) {
+			t.Errorf("%v: imports: got: %v, want: %v", tc.exports, imports, tc.imports)
+		}
+
+		// control.wat is not a Go program, so the Go runtime is not generated.
+		if _, err := os.Stat(filepath.Join(dir, "go.h")); !os.IsNotExist(err) {
+			t.Errorf("%v: go.h must not exist: %v", tc.exports, err)
+		}
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	for _, exports := range [][]string{nil, {"Add"}} {
+		dir := t.TempDir()
+		if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "library.wat"), "go2cpp_test", &Options{
+			Exports: exports,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.h")); !os.IsNotExist(err) {
+			t.Errorf("%v: go.h must not exist: %v", exports, err)
+		}
+		src, err := ioutil.ReadFile(filepath.Join(dir, "library.h"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(src), "int32_t Add(int32_t arg0, int32_t arg1);") {
+			t.Errorf("%v: library.h doesn't have Add:\n%s", exports, src)
+		}
+		if got, want := strings.Contains(string(src), "runtime_2ewasmWrite"), exports == nil; got != want {
+			t.Errorf("%v: library.h has runtime.wasmWrite: got: %t, want: %t", exports, got, want)
+		}
+	}
+}
+
+func TestExportNames(t *testing.T) {
+	dir := t.TempDir()
+	if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "library.wat"), "go2cpp_test", &Options{
+		ExportNames: map[string]string{"Hello": "SayHello"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.ReadFile(filepath.Join(dir, "library.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range []string{
+		"void SayHello();",
+		"int32_t add_(int32_t arg0, int32_t arg1);",
+		"void delete_(int32_t arg0);",
+		"void go_2equit(int32_t arg0);",
+	} {
+		if !strings.Contains(string(src), decl) {
+			t.Errorf("library.h doesn't have %q:\n%s", decl, src)
+		}
+	}
+
+	// A renamed export must not conflict.
+	err = GenerateWithOptions(t.TempDir(), "", filepath.Join("testdata", "library.wat"), "go2cpp_test", &Options{
+		ExportNames: map[string]string{"Hello": "Add"},
+	})
+	var oerr *OptionError
+	if !errors.As(err, &oerr) || oerr.Option != "ExportNames" {
+		t.Errorf("*OptionError for ExportNames expected but %v", err)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	for _, deterministic := range []bool{false, true} {
+		dir := t.TempDir()
+		if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ops", "control.wat"), "go2cpp_test", &Options{
+			Deterministic: deterministic,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range []string{"go.cpp", "host.h"} {
+			src, err := ioutil.ReadFile(filepath.Join(dir, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.Contains(string(src), "DeterministicSource"), deterministic; got != want {
+				t.Errorf("%s with Deterministic %t: DeterministicSource is used: got: %t, want: %t", file, deterministic, got, want)
+			}
+		}
+
+		// The frames run on the virtual clock.
+		src, err := ioutil.ReadFile(filepath.Join(dir, "game.cpp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Contains(string(src), "EnqueueTaskAt"), deterministic; got != want {
+			t.Errorf("game.cpp with Deterministic %t: EnqueueTaskAt is used: got: %t, want: %t", deterministic, got, want)
+		}
+
+		// The FMA contraction is turned off in the translated functions.
+		paths, err := filepath.Glob(filepath.Join(dir, "inst.funcs.*.cpp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range paths {
+			src, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.Contains(string(src), `#pragma GCC optimize("fp-contract=off")`), deterministic; got != want {
+				t.Errorf("%s with Deterministic %t: fp-contract is turned off: got: %t, want: %t", filepath.Base(path), deterministic, got, want)
+			}
+		}
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	for _, watchdog := range []bool{false, true} {
+		dir := t.TempDir()
+		if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ops", "control.wat"), "go2cpp_test", &Options{
+			Watchdog: watchdog,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "watchdog.h")); (err == nil) != watchdog {
+			t.Errorf("watchdog.h with Watchdog %t: got: %v", watchdog, err)
+		}
+		files, err := filepath.Glob(filepath.Join(dir, "inst.funcs.*.cpp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var markers bool
+		for _, file := range files {
+			src, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(src), "GO2CPP_FUNCTION_MARKER(") {
+				markers = true
+			}
+		}
+		if markers != watchdog {
+			t.Errorf("inst.funcs.*.cpp with Watchdog %t: markers are put: got: %t, want: %t", watchdog, markers, watchdog)
+		}
+
+		// The markers are side effects, so the functions are not marked as pure with them.
+		inst, err := ioutil.ReadFile(filepath.Join(dir, "inst.h"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Contains(string(inst), "GO2CPP_CONST "), !watchdog; got != want {
+			t.Errorf("inst.h with Watchdog %t: GO2CPP_CONST is put: got: %t, want: %t", watchdog, got, want)
+		}
+	}
+}
+
+func TestVersionCheck(t *testing.T) {
+	dir := t.TempDir()
+	if err := Generate(dir, "", filepath.Join("testdata", "ops", "control.wat"), "go2cpp_test"); err != nil {
+		t.Fatal(err)
+	}
+	headers, err := filepath.Glob(filepath.Join(dir, "*.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range headers {
+		name := filepath.Base(h)
+		if name == "config.h" || name == "version.h" {
+			continue
+		}
+		src, err := ioutil.ReadFile(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(src), versionCheck("go2cpp_test", name)) {
+			t.Errorf("%s doesn't check the version", name)
+		}
+	}
+
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		return
+	}
+	// Imitate config.h generated by another version.
+	config, err := ioutil.ReadFile(filepath.Join(dir, "config.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = []byte(strings.Replace(string(config), fmt.Sprintf("#define %s %d", versionMacro("go2cpp_test"), version.Number()), fmt.Sprintf("#define %s 999999", versionMacro("go2cpp_test")), 1))
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.h"), config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.cpp"), []byte(`#include "log.h"`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(cxx, "-std=c++14", "-fsyntax-only", "test.cpp")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatal("compiling with config.h of another version must fail")
+	}
+	if !strings.Contains(string(out), "generated by different versions of go2cpp") {
+		t.Errorf("unexpected error: %s", out)
+	}
+}
+
+func TestImportArgsSize(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		size int
+	}{
+		{"runtime.wasmWrite", 24},
+		{"runtime.getRandomData", 24},
+		{"syscall/js.valueSet", 32},
+		{"runtime.nanotime1", 0},
+	} {
+		got, err := importArgsSize(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tc.size; got != want {
+			t.Errorf("importArgsSize(%s): got: %d, want: %d", tc.name, got, want)
+		}
+	}
+
+	// Every import by Go has its parameters.
+	for name := range importFuncBodies {
+		if _, err := importArgsSize(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := importArgsSize("runtime.unknown"); err == nil {
+		t.Error("importArgsSize for an unknown import must fail")
+	}
+}
+
+func TestLongNames(t *testing.T) {
+	dir := t.TempDir()
+	if err := Generate(dir, "", filepath.Join("testdata", "generics.wat"), "go2cpp_test"); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.ReadFile(filepath.Join(dir, "inst.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The types $int and $float64 are identical.
+	if got, want := strings.Count(string(src), "  using Type"), 1; got != want {
+		t.Errorf("the number of the types: got: %d, want: %d", got, want)
+	}
+
+	var names []string
+	for _, line := range strings.Split(string(src), "\n") {
+		// The functions might have the attributes for purity.
+		line = strings.Replace(line, "GO2CPP_CONST ", "", 1)
+		line = strings.Replace(line, "GO2CPP_PURE ", "", 1)
+		if strings.HasPrefix(line, "  int32_t main_2eReduce") {
+			names = append(names, line[len("  int32_t "):strings.Index(line, "(")])
+		}
+	}
+	if len(names) != 2 {
+		t.Fatalf("the number of the functions: got: %d, want: 2", len(names))
+	}
+	if names[0] == names[1] {
+		t.Errorf("the names of the functions must be different: %s", names[0])
+	}
+	for _, n := range names {
+		if got, want := len(n), maxIdentifierLength; got != want {
+			t.Errorf("len(%s): got: %d, want: %d", n, got, want)
+		}
+	}
+
+	if got, want := identifierFromString("main.Map[go.shape.int]"), "main_2eMap_5bgo_2eshape_2eint_5d"; got != want {
+		t.Errorf("identifierFromString: got: %s, want: %s", got, want)
+	}
+}
+
+func TestGoPackage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		pkg  string
+	}{
+		{"main.main", "main"},
+		{"fmt.(*pp).doPrintf", "fmt"},
+		{"syscall/js.valueGet", "syscall/js"},
+		{"github.com/hajimehoshi/ebiten/v2.(*Image).DrawImage", "github.com/hajimehoshi/ebiten/v2"},
+		{"sort.Slice[go.shape.[]github.com/foo/bar.T]", "sort"},
+		{"debug", ""},
+	} {
+		if got, want := goPackage(tc.name), tc.pkg; got != want {
+			t.Errorf("goPackage(%q): got: %q, want: %q", tc.name, got, want)
+		}
+	}
+}
+
+func TestReentrantImports(t *testing.T) {
+	for name, body := range importFuncBodies {
+		if strings.Contains(body, "getsp()") {
+			t.Errorf("%s: reload the stack pointer with ScopedSP::Reload instead of getsp", name)
+		}
+		_, reentrant := reentrantImports[name]
+		if got, want := strings.Contains(body, "scoped_sp."), reentrant; got != want {
+			t.Errorf("%s: uses scoped_sp: got: %t, want: %t", name, got, want)
+		}
+	}
+	for name := range reentrantImports {
+		if _, ok := importFuncBodies[name]; !ok {
+			t.Errorf("%s: no body", name)
+		}
+	}
+}
+
+func TestMaxFunctionLines(t *testing.T) {
+	dir := t.TempDir()
+	var warnings []string
+	if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ops", "variable.wat"), "go2cpp_test", &Options{
+		MaxFunctionLines: 1,
+		Warnf: func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) == 0 {
+		t.Fatal("no warnings")
+	}
+	for _, w := range warnings {
+		if !strings.Contains(w, "over the budget 1") {
+			t.Errorf("unexpected warning: %s", w)
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "inst.funcs.*.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, p := range paths {
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += strings.Count(string(src), "GO2CPP_LARGE_FUNCTION_BEGIN")
+	}
+	if got, want := n, len(warnings); got != want {
+		t.Errorf("the number of the large functions: got: %d, want: %d", got, want)
+	}
+}
+
+func TestOptionError(t *testing.T) {
+	for _, tc := range []struct {
+		namespace string
+		options   *Options
+		option    string
+	}{
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{CppStd: "c++11"},
+			option:    "CppStd",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{ExportMacro: "MY-API"},
+			option:    "ExportMacro",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{Assets: []Asset{{Name: "a"}, {Name: "a"}}},
+			option:    "Assets",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{Scaffold: "dreamcast"},
+			option:    "Scaffold",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{Emscripten: true, Scaffold: "android"},
+			option:    "Scaffold",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{Modules: true},
+			option:    "Modules",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{MaxFunctionLines: -1},
+			option:    "MaxFunctionLines",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{Intrinsics: []Intrinsic{{Name: "block", Params: []string{"int"}, Body: "  return 0;"}}},
+			option:    "Intrinsics",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{ImportFuncs: []ImportFunc{{Module: "go", Name: "debug", Body: "  return;"}}},
+			option:    "ImportFuncs",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{Exports: []string{"test_none"}},
+			option:    "Exports",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{ExportNames: map[string]string{"test_block": "delete"}},
+			option:    "ExportNames",
+		},
+		{
+			namespace: "go2cpp_test",
+			options:   &Options{ExportNames: map[string]string{"test_none": "None"}},
+			option:    "ExportNames",
+		},
+		{
+			// control.wat doesn't import syscall/js.
+			namespace: "go2cpp_test",
+			options:   &Options{Assets: []Asset{{Name: "a"}}},
+			option:    "Assets",
+		},
+	} {
+		err := GenerateWithOptions(t.TempDir(), "", filepath.Join("testdata", "ops", "control.wat"), tc.namespace, tc.options)
+		var oerr *OptionError
+		if !errors.As(err, &oerr) {
+			t.Errorf("%+v: *OptionError expected but %v", tc.options, err)
+			continue
+		}
+		if got, want := oerr.Option, tc.option; got != want {
+			t.Errorf("%+v: got: %s, want: %s", tc.options, got, want)
+		}
+	}
+}
+
+// TestNestedNamespace checks that a nested namespace is defined namespace by namespace for C++14.
+func TestNestedNamespace(t *testing.T) {
+	dir := t.TempDir()
+	if err := Generate(dir, "", filepath.Join("testdata", "ids.wat"), "go2cpp::test"); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.ReadFile(filepath.Join(dir, "go.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), "namespace go2cpp { namespace test {") {
+		t.Errorf("go.h doesn't define the namespaces for C++14")
+	}
+
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		return
+	}
+	srcs, err := filepath.Glob(filepath.Join(dir, "*.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(cxx, append([]string{"-std=c++14", "-fsyntax-only"}, srcs...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("compiling failed: %v\n%s", err, out)
+	}
+}
+
+// TestReproducible checks that the same input generates the same code.
+func TestReproducible(t *testing.T) {
+	var dirs [2]string
+	for i := range dirs {
+		dirs[i] = t.TempDir()
+		if err := Generate(dirs[i], "", filepath.Join("testdata", "ops", "control.wat"), "go2cpp_test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths, err := filepath.Glob(filepath.Join(dirs[0], "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range paths {
+		name := filepath.Base(p)
+		src0, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src1, err := ioutil.ReadFile(filepath.Join(dirs[1], name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(src0) != string(src1) {
+			t.Errorf("%s differs between the generations", name)
+		}
+	}
+}
+
+// TestCatchHostError checks that CatchHostError, which is in an anonymous namespace, is defined only when it is used,
+// against -Wunused-function.
+func TestCatchHostError(t *testing.T) {
+	// ids.wat imports valueInvoke for the tests of the runtime. Remove it.
+	wat, err := ioutil.ReadFile(filepath.Join("testdata", "ids.wat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, l := range strings.Split(string(wat), "\n") {
+		if !strings.Contains(l, `"syscall/js.valueInvoke"`) {
+			lines = append(lines, l)
+		}
+	}
+	dir := t.TempDir()
+	wasmFile := filepath.Join(dir, "ids.wat")
+	if err := ioutil.WriteFile(wasmFile, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Generate(dir, "", wasmFile, "go2cpp_test"); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.ReadFile(filepath.Join(dir, "go.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(src), "CatchHostError") {
+		t.Errorf("go.cpp must not have CatchHostError without syscall/js.valueCall, valueInvoke or valueNew")
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	cacheDir := t.TempDir()
+	wasmFile := filepath.Join("testdata", "ops", "control.wat")
+	if err := GenerateWithOptions(t.TempDir(), "", wasmFile, "go2cpp_test", &Options{CacheDir: cacheDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mark the cached bodies to confirm they are reused.
+	paths, err := filepath.Glob(filepath.Join(cacheDir, "*", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no cache entries")
+	}
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b cachedBody
+		if err := json.Unmarshal(data, &b); err != nil {
+			t.Fatal(err)
+		}
+		b.Body = append(b.Body, "  // cached")
+		data, err = json.Marshal(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := GenerateWithOptions(dir, "", wasmFile, "go2cpp_test", &Options{CacheDir: cacheDir}); err != nil {
+		t.Fatal(err)
+	}
+	srcs, err := filepath.Glob(filepath.Join(dir, "inst.funcs.*.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, p := range srcs {
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += strings.Count(string(src), "// cached")
+	}
+	if got, want := n, len(paths); got != want {
+		t.Errorf("the number of the reused bodies: got: %d, want: %d", got, want)
+	}
+}
+
+func TestStubs(t *testing.T) {
+	dir := t.TempDir()
+	var warnings []string
+	if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ops", "control.wat"), "go2cpp_test", &Options{
+		Stubs: []Stub{
+			{Name: "debug"},
+			{Name: "br_table", Mode: StubNop},
+			{Name: "foo.*"},
+		},
+		Warnf: func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := ioutil.ReadFile(filepath.Join(dir, "go.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), `Trap(std::string("debug") + " is stubbed out");`) {
+		t.Errorf("the import debug is not stubbed out")
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "inst.funcs.*.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, p := range paths {
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += strings.Count(string(src), `ReportStubCall("br_table");`)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("the number of the stubbed-out functions: got: %d, want: %d", got, want)
+	}
+
+	if got, want := warnings, []string{"stub foo.* matches no functions"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings: got: %v, want: %v", got, want)
+	}
+}
+
+func TestImportFuncs(t *testing.T) {
+	dir := t.TempDir()
+	funcs, err := ParseImportFuncs([]byte(`[
+  {"module": "env", "name": "add", "body": "  return local0_ + local1_;"},
+  {"module": "env", "name": "sub", "body": "  return local0_ - local1_;"}
+]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warnings []string
+	if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "env.wat"), "go2cpp_test", &Options{
+		ImportFuncs: funcs,
+		Warnf: func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := ioutil.ReadFile(filepath.Join(dir, "go.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The imports of "env" don't conflict with the imports of Go with the same names.
+	for _, s := range []string{
+		"Go::ImportImpl::env_2eadd(int32_t local0_, int32_t local1_) {\n  return local0_ + local1_;",
+		`Go::ImportImpl::debug(int32_t local0_) {`,
+		`Trap("env.debug not implemented");`,
+	} {
+		if !strings.Contains(string(src), s) {
+			t.Errorf("go.cpp doesn't have %q", s)
+		}
+	}
+
+	inst, err := ioutil.ReadFile(filepath.Join(dir, "inst.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"class ImportEnv {",
+		"class Import : public ImportGo, public ImportEnv {",
+		"Inst(Mem* mem, ImportGo* import_go, ImportEnv* import_env);",
+	} {
+		if !strings.Contains(string(inst), s) {
+			t.Errorf("inst.h doesn't have %q", s)
+		}
+	}
+
+	want := []string{
+		"import env.debug is not implemented: calling it exits the program",
+		"import function env.sub matches no imports",
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings: got: %v, want: %v", warnings, want)
+	}
+}
+
+func TestIntrinsics(t *testing.T) {
+	dir := t.TempDir()
+	generate := func(options *Options) (string, []string) {
+		var warnings []string
+		options.Warnf = func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		}
+		if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ops", "control.wat"), "go2cpp_test", options); err != nil {
+			t.Fatal(err)
+		}
+		paths, err := filepath.Glob(filepath.Join(dir, "inst.funcs.*.cpp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var src string
+		for _, p := range paths {
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src += string(b)
+		}
+		return src, warnings
+	}
+
+	intrinsics, err := ParseIntrinsics([]byte(`[
+  {"name": "block", "params": ["i32"], "results": ["i32"], "body": "  return local0_ + 100;"},
+  {"name": "loop", "params": ["i64"], "results": ["i32"], "body": "  return 0;"},
+  {"name": "foo", "body": "  return;"}
+]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, warnings := generate(&Options{
+		Intrinsics: intrinsics,
+		CallGraph:  filepath.Join(dir, "callgraph.json"),
+	})
+	if got, want := strings.Count(src, "return local0_ + 100;"), 1; got != want {
+		t.Errorf("the number of the intrinsics used: got: %d, want: %d", got, want)
+	}
+	want := []string{
+		"intrinsic loop is not used: no intrinsic matches the signature (i32) -> (i32) and the code",
+		"intrinsic foo matches no functions",
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings: got: %v, want: %v", warnings, want)
+	}
+
+	// An intrinsic with a hash is used only when the code matches.
+	b, err := ioutil.ReadFile(filepath.Join(dir, "callgraph.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c callGraph
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	var hash string
+	for _, f := range c.Functions {
+		if f.Name == "loop" {
+			hash = f.Hash
+		}
+	}
+	if hash == "" {
+		t.Fatal("the hash of loop is not reported")
+	}
+	src, warnings = generate(&Options{
+		Intrinsics: []Intrinsic{
+			{Name: "loop", Params: []string{"i32"}, Results: []string{"i32"}, Hash: hash, Body: "  return local0_ + 200;"},
+			{Name: "early_return", Params: []string{"i32"}, Results: []string{"i32"}, Hash: hash, Body: "  return local0_ + 300;"},
+		},
+	})
+	if !strings.Contains(src, "return local0_ + 200;") {
+		t.Errorf("the intrinsic with the matching hash is not used")
+	}
+	if strings.Contains(src, "return local0_ + 300;") {
+		t.Errorf("the intrinsic with the different hash is used")
+	}
+	if got, want := len(warnings), 1; got != want {
+		t.Errorf("the number of the warnings: got: %d, want: %d", got, want)
+	}
+
+	src, warnings = generate(&Options{
+		Intrinsics:        intrinsics,
+		DisableIntrinsics: true,
+	})
+	if strings.Contains(src, "return local0_ + 100;") {
+		t.Errorf("the intrinsic is used with DisableIntrinsics")
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings: got: %v, want: none", warnings)
+	}
+}
+
+func TestScaffold(t *testing.T) {
+	dir := t.TempDir()
+	wasmFile := filepath.Join("testdata", "ops", "control.wat")
+	options := &Options{
+		Scaffold: "android",
+	}
+	if err := GenerateWithOptions(dir, "autogen", wasmFile, "go2cpp_test", options); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		"settings.gradle",
+		"app/src/main/cpp/CMakeLists.txt",
+		"app/src/main/cpp/androiddriver.cpp",
+		"app/src/main/cpp/autogen/game.h",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The project files are not overwritten.
+	main := filepath.Join(dir, "app", "src", "main", "cpp", "main.cpp")
+	if err := ioutil.WriteFile(main, []byte("// edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateWithOptions(dir, "autogen", wasmFile, "go2cpp_test", options); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.ReadFile(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(src), "// edited\n"; got != want {
+		t.Errorf("main.cpp: got: %q, want: %q", got, want)
+	}
+}
+
+func TestScaffoldIOS(t *testing.T) {
+	dir := t.TempDir()
+	wasmFile := filepath.Join("testdata", "ops", "control.wat")
+	options := &Options{
+		Scaffold: "ios",
+		CppStd:   "c++17",
+	}
+	if err := GenerateWithOptions(dir, "autogen", wasmFile, "go2cpp_test", options); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		"CMakeLists.txt",
+		"Info.plist",
+		"src/iosdriver.mm",
+		"src/autogen/game.h",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	cmake, err := ioutil.ReadFile(filepath.Join(dir, "CMakeLists.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Underscores are not allowed in the bundle identifier.
+	if !strings.Contains(string(cmake), "com.example.go2cpp-test") {
+		t.Errorf("CMakeLists.txt doesn't have the bundle identifier:\n%s", cmake)
+	}
+}
+
+func TestModules(t *testing.T) {
+	schema, err := ParseSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ids.wat"), "go2cpp_test", &Options{
+		CppStd:        "c++20",
+		ExportMacro:   "GO2CPP_TEST_API",
+		Deterministic: true,
+		Record:        true,
+		Watchdog:      true,
+		Modules:       true,
+		Schema:        schema,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.ReadFile(filepath.Join(dir, "module.cppm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), "export module go2cpp_test;") {
+		t.Errorf("module.cppm doesn't declare the module go2cpp_test")
+	}
+
+	// All the classes with the export macro must be exported from the module, and the implementation details in the
+	// other headers must not.
+	re := regexp.MustCompile(`(?m)^class GO2CPP_TEST_API (\w+)`)
+	headers, err := filepath.Glob(filepath.Join(dir, "*.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range headers {
+		hsrc, err := ioutil.ReadFile(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ns := "go2cpp_test"
+		if filepath.Base(h) == "schema.h" {
+			ns += "::msg"
+		}
+		for _, m := range re.FindAllStringSubmatch(string(hsrc), -1) {
+			if !strings.Contains(string(src), "using ::"+ns+"::"+m[1]+";") {
+				t.Errorf("%s in %s is not exported from the module", m[1], filepath.Base(h))
+			}
+		}
+	}
+	for _, name := range []string{"go2cpp_test::FormatConsoleMessage", "go2cpp_test::LogLevel", "go2cpp_test::DeterministicSource", "go2cpp_test::msg::PointView"} {
+		if !strings.Contains(string(src), "using ::"+name+";") {
+			t.Errorf("%s is not exported from the module", name)
+		}
+	}
+	for _, name := range []string{"go2cpp_test::Bits", "go2cpp_test::Trap", "go2cpp_test::GL"} {
+		if strings.Contains(string(src), "using ::"+name+";") {
+			t.Errorf("%s must not be exported from the module", name)
+		}
+	}
+
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("C++ compiler not found")
+	}
+	compile := func(dir string, args ...string) error {
+		cmd := exec.Command(cxx, append([]string{"-std=c++20", "-fmodules-ts"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v\n%s", err, out)
+		}
+		return nil
+	}
+
+	// Check whether the compiler supports the modules with the flags of GCC.
+	probe := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(probe, "probe.h"), []byte("namespace probe { struct S {}; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(probe, "probe.cppm"), []byte(`module;
+#include "probe.h"
+export module probe;
+export namespace probe { using ::probe::S; }
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(probe, "main.cpp"), []byte("import probe;\nprobe::S s;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := compile(probe, "-x", "c++", "-c", "probe.cppm"); err != nil {
+		t.Skipf("the compiler doesn't support the modules: %v", err)
+	}
+
+	if err := compile(dir, "-x", "c++", "-c", "module.cppm"); err != nil {
+		t.Fatalf("compiling module.cppm failed: %v", err)
+	}
+
+	// GCC 12 compiles the unit, but the names exported with the using-declarations of the global module fragment are
+	// not visible to the importers.
+	if err := compile(probe, "-c", "main.cpp"); err != nil {
+		t.Logf("skipped importing the module: the compiler doesn't support exporting the using-declarations: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.cpp"), []byte(`#include <cstdint>
+#include <vector>
+
+import go2cpp_test;
+
+int main() {
+  go2cpp_test::Go go;
+  std::vector<uint8_t> bytes;
+  go2cpp_test::msg::Marshal(go2cpp_test::msg::Point{}, &bytes);
+  return go.Run();
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := compile(dir, "-c", "main.cpp"); err != nil {
+		t.Errorf("compiling the importer failed: %v", err)
+	}
+}
@@ -18,10 +18,12 @@ func writeGL(dir string, incpath string, namespace string) error {
 
 		if err := glHTmpl.Execute(f, struct {
 			IncludeGuard string
+			VersionCheck string
 			IncludePath  string
 			Namespace    string
 		}{
 			IncludeGuard: includeGuard(namespace) + "_GL_H",
+			VersionCheck: versionCheck(namespace, "gl.h"),
 			IncludePath:  incpath,
 			Namespace:    namespace,
 		}); err != nil {
@@ -53,11 +55,14 @@ var glHTmpl = template.Must(template.New("gl.h").Parse(`// Code generated by go2
 #ifndef {{.IncludeGuard}}
 #define {{.IncludeGuard}}
 
+#include "{{.IncludePath}}config.h"
 #include "{{.IncludePath}}js.h"
 
 #include <cstdint>
 #include <functional>
 
+{{.VersionCheck}}
+
 namespace {{.Namespace}} {
 
 // GL emulates WebGLRenderingContext by OpenGL (not ES).
@@ -67,7 +72,19 @@ public:
   Value Get(const std::string &key) override;
   std::string ToString() const override;
 
+  // LoadFunctions resolves the GL functions again. Call this when the GL context is recreated.
+  void LoadFunctions();
+
+  // While the context is lost, the GL functions do nothing and return null, as WebGL's do.
+  void SetContextLost(bool lost);
+  bool IsContextLost() const;
+
 private:
+  Value GetImpl(const std::string &key);
+
+  std::function<void*(const char*)> get_proc_address_;
+  bool context_lost_ = false;
+
   // TODO: Now this covers GL 1.x functions.
   // Get the proc addresses for all the GL functions?
   void *glActiveTexture_;
@@ -165,83 +182,113 @@ var glCppTmpl = template.Must(template.New("gl.cpp").Parse(`// Code generated by
 
 namespace {{.Namespace}} {
 
-GL::GL(std::function<void*(const char*)> get_proc_address) {
-  glActiveTexture_ = get_proc_address("glActiveTexture");
-  glAttachShader_ = get_proc_address("glAttachShader");
-  glBindAttribLocation_ = get_proc_address("glBindAttribLocation");
-  glBindBuffer_ = get_proc_address("glBindBuffer");
-  glBindFramebuffer_ = get_proc_address("glBindFramebuffer");
-  glBindRenderbuffer_ = get_proc_address("glBindRenderbuffer");
-  glBindTexture_ = get_proc_address("glBindTexture");
-  glBlendFunc_ = get_proc_address("glBlendFunc");
-  glBufferData_ = get_proc_address("glBufferData");
-  glBufferSubData_ = get_proc_address("glBufferSubData");
-  glCheckFramebufferStatus_ = get_proc_address("glCheckFramebufferStatus");
-  glClear_ = get_proc_address("glClear");
-  glColorMask_ = get_proc_address("glColorMask");
-  glCompileShader_ = get_proc_address("glCompileShader");
-  glCreateProgram_ = get_proc_address("glCreateProgram");
-  glCreateShader_ = get_proc_address("glCreateShader");
-  glDeleteBuffers_ = get_proc_address("glDeleteBuffers");
-  glDeleteFramebuffers_ = get_proc_address("glDeleteFramebuffers");
-  glDeleteProgram_ = get_proc_address("glDeleteProgram");
-  glDeleteRenderbuffers_ = get_proc_address("glDeleteRenderbuffers");
-  glDeleteShader_ = get_proc_address("glDeleteShader");
-  glDeleteTextures_ = get_proc_address("glDeleteTextures");
-  glDisable_ = get_proc_address("glDisable");
-  glDisableVertexAttribArray_ = get_proc_address("glDisableVertexAttribArray");
-  glDrawElements_ = get_proc_address("glDrawElements");
-  glEnable_ = get_proc_address("glEnable");
-  glEnableVertexAttribArray_ = get_proc_address("glEnableVertexAttribArray");
-  glFlush_ = get_proc_address("glFlush");
-  glFramebufferRenderbuffer_ = get_proc_address("glFramebufferRenderbuffer");
-  glFramebufferTexture2D_ = get_proc_address("glFramebufferTexture2D");
-  glGenBuffers_ = get_proc_address("glGenBuffers");
-  glGenFramebuffers_ = get_proc_address("glGenFramebuffers");
-  glGenRenderbuffers_ = get_proc_address("glGenRenderbuffers");
-  glGenTextures_ = get_proc_address("glGenTextures");
-  glGetBufferSubData_ = get_proc_address("glGetBufferSubData");
-  glGetError_ = get_proc_address("glGetError");
-  glGetIntegerv_ = get_proc_address("glGetIntegerv");
-  glGetProgramInfoLog_ = get_proc_address("glGetProgramInfoLog");
-  glGetProgramiv_ = get_proc_address("glGetProgramiv");
-  glGetShaderInfoLog_ = get_proc_address("glGetShaderInfoLog");
-  glGetShaderiv_ = get_proc_address("glGetShaderiv");
-  glGetUniformLocation_ = get_proc_address("glGetUniformLocation");
-  glIsFramebuffer_ = get_proc_address("glIsFramebuffer");
-  glIsProgram_ = get_proc_address("glIsProgram");
-  glIsRenderbuffer_ = get_proc_address("glIsRenderbuffer");
-  glIsTexture_ = get_proc_address("glIsTexture");
-  glLinkProgram_ = get_proc_address("glLinkProgram");
-  glPixelStorei_ = get_proc_address("glPixelStorei");
-  glReadPixels_ = get_proc_address("glReadPixels");
-  glRenderbufferStorage_ = get_proc_address("glRenderbufferStorage");
-  glScissor_ = get_proc_address("glScissor");
-  glShaderSource_ = get_proc_address("glShaderSource");
-  glStencilFunc_ = get_proc_address("glStencilFunc");
-  glStencilMask_ = get_proc_address("glStencilMask");
-  glStencilOp_ = get_proc_address("glStencilOp");
-  glTexImage2D_ = get_proc_address("glTexImage2D");
-  glTexParameteri_ = get_proc_address("glTexParameteri");
-  glTexSubImage2D_ = get_proc_address("glTexSubImage2D");
-  glUniform1f_ = get_proc_address("glUniform1f");
-  glUniform1fv_ = get_proc_address("glUniform1fv");
-  glUniform1i_ = get_proc_address("glUniform1i");
-  glUniform2f_ = get_proc_address("glUniform2f");
-  glUniform2fv_ = get_proc_address("glUniform2fv");
-  glUniform3f_ = get_proc_address("glUniform3f");
-  glUniform3fv_ = get_proc_address("glUniform3fv");
-  glUniform4f_ = get_proc_address("glUniform4f");
-  glUniform4fv_ = get_proc_address("glUniform4fv");
-  glUniformMatrix2fv_ = get_proc_address("glUniformMatrix2fv");
-  glUniformMatrix3fv_ = get_proc_address("glUniformMatrix3fv");
-  glUniformMatrix4fv_ = get_proc_address("glUniformMatrix4fv");
-  glUseProgram_ = get_proc_address("glUseProgram");
-  glVertexAttribPointer_ = get_proc_address("glVertexAttribPointer");
-  glViewport_ = get_proc_address("glViewport");
+GL::GL(std::function<void*(const char*)> get_proc_address)
+    : get_proc_address_{get_proc_address} {
+  LoadFunctions();
+}
+
+void GL::LoadFunctions() {
+  glActiveTexture_ = get_proc_address_("glActiveTexture");
+  glAttachShader_ = get_proc_address_("glAttachShader");
+  glBindAttribLocation_ = get_proc_address_("glBindAttribLocation");
+  glBindBuffer_ = get_proc_address_("glBindBuffer");
+  glBindFramebuffer_ = get_proc_address_("glBindFramebuffer");
+  glBindRenderbuffer_ = get_proc_address_("glBindRenderbuffer");
+  glBindTexture_ = get_proc_address_("glBindTexture");
+  glBlendFunc_ = get_proc_address_("glBlendFunc");
+  glBufferData_ = get_proc_address_("glBufferData");
+  glBufferSubData_ = get_proc_address_("glBufferSubData");
+  glCheckFramebufferStatus_ = get_proc_address_("glCheckFramebufferStatus");
+  glClear_ = get_proc_address_("glClear");
+  glColorMask_ = get_proc_address_("glColorMask");
+  glCompileShader_ = get_proc_address_("glCompileShader");
+  glCreateProgram_ = get_proc_address_("glCreateProgram");
+  glCreateShader_ = get_proc_address_("glCreateShader");
+  glDeleteBuffers_ = get_proc_address_("glDeleteBuffers");
+  glDeleteFramebuffers_ = get_proc_address_("glDeleteFramebuffers");
+  glDeleteProgram_ = get_proc_address_("glDeleteProgram");
+  glDeleteRenderbuffers_ = get_proc_address_("glDeleteRenderbuffers");
+  glDeleteShader_ = get_proc_address_("glDeleteShader");
+  glDeleteTextures_ = get_proc_address_("glDeleteTextures");
+  glDisable_ = get_proc_address_("glDisable");
+  glDisableVertexAttribArray_ = get_proc_address_("glDisableVertexAttribArray");
+  glDrawElements_ = get_proc_address_("glDrawElements");
+  glEnable_ = get_proc_address_("glEnable");
+  glEnableVertexAttribArray_ = get_proc_address_("glEnableVertexAttribArray");
+  glFlush_ = get_proc_address_("glFlush");
+  glFramebufferRenderbuffer_ = get_proc_address_("glFramebufferRenderbuffer");
+  glFramebufferTexture2D_ = get_proc_address_("glFramebufferTexture2D");
+  glGenBuffers_ = get_proc_address_("glGenBuffers");
+  glGenFramebuffers_ = get_proc_address_("glGenFramebuffers");
+  glGenRenderbuffers_ = get_proc_address_("glGenRenderbuffers");
+  glGenTextures_ = get_proc_address_("glGenTextures");
+  glGetBufferSubData_ = get_proc_address_("glGetBufferSubData");
+  glGetError_ = get_proc_address_("glGetError");
+  glGetIntegerv_ = get_proc_address_("glGetIntegerv");
+  glGetProgramInfoLog_ = get_proc_address_("glGetProgramInfoLog");
+  glGetProgramiv_ = get_proc_address_("glGetProgramiv");
+  glGetShaderInfoLog_ = get_proc_address_("glGetShaderInfoLog");
+  glGetShaderiv_ = get_proc_address_("glGetShaderiv");
+  glGetUniformLocation_ = get_proc_address_("glGetUniformLocation");
+  glIsFramebuffer_ = get_proc_address_("glIsFramebuffer");
+  glIsProgram_ = get_proc_address_("glIsProgram");
+  glIsRenderbuffer_ = get_proc_address_("glIsRenderbuffer");
+  glIsTexture_ = get_proc_address_("glIsTexture");
+  glLinkProgram_ = get_proc_address_("glLinkProgram");
+  glPixelStorei_ = get_proc_address_("glPixelStorei");
+  glReadPixels_ = get_proc_address_("glReadPixels");
+  glRenderbufferStorage_ = get_proc_address_("glRenderbufferStorage");
+  glScissor_ = get_proc_address_("glScissor");
+  glShaderSource_ = get_proc_address_("glShaderSource");
+  glStencilFunc_ = get_proc_address_("glStencilFunc");
+  glStencilMask_ = get_proc_address_("glStencilMask");
+  glStencilOp_ = get_proc_address_("glStencilOp");
+  glTexImage2D_ = get_proc_address_("glTexImage2D");
+  glTexParameteri_ = get_proc_address_("glTexParameteri");
+  glTexSubImage2D_ = get_proc_address_("glTexSubImage2D");
+  glUniform1f_ = get_proc_address_("glUniform1f");
+  glUniform1fv_ = get_proc_address_("glUniform1fv");
+  glUniform1i_ = get_proc_address_("glUniform1i");
+  glUniform2f_ = get_proc_address_("glUniform2f");
+  glUniform2fv_ = get_proc_address_("glUniform2fv");
+  glUniform3f_ = get_proc_address_("glUniform3f");
+  glUniform3fv_ = get_proc_address_("glUniform3fv");
+  glUniform4f_ = get_proc_address_("glUniform4f");
+  glUniform4fv_ = get_proc_address_("glUniform4fv");
+  glUniformMatrix2fv_ = get_proc_address_("glUniformMatrix2fv");
+  glUniformMatrix3fv_ = get_proc_address_("glUniformMatrix3fv");
+  glUniformMatrix4fv_ = get_proc_address_("glUniformMatrix4fv");
+  glUseProgram_ = get_proc_address_("glUseProgram");
+  glVertexAttribPointer_ = get_proc_address_("glVertexAttribPointer");
+  glViewport_ = get_proc_address_("glViewport");
+}
+
+void GL::SetContextLost(bool lost) {
+  context_lost_ = lost;
+}
+
+bool GL::IsContextLost() const {
+  return context_lost_;
 }
 
 Value GL::Get(const std::string &key) {
+  if (key == "isContextLost") {
+    return Value{std::make_shared<Function>(
+        [this](Value self, std::vector<Value> args) -> Value {
+          return Value{context_lost_};
+        })};
+  }
+  Value v = GetImpl(key);
+  if (context_lost_ && v.IsFunction()) {
+    return Value{std::make_shared<Function>(
+        [](Value self, std::vector<Value> args) -> Value {
+          return Value::Null();
+        })};
+  }
+  return v;
+}
+
+Value GL::GetImpl(const std::string &key) {
   if (key == "activeTexture") {
     return Value{std::make_shared<Function>(
         [this](Value self, std::vector<Value> args) -> Value {
@@ -353,7 +400,7 @@ Value GL::Get(const std::string &key) {
             size = static_cast<GLsizeiptr>(args[4].ToNumber());
           }
           using f = void(*)(GLenum, GLintptr, GLsizeiptr, const void*);
-          reinterpret_cast<f>(glBufferSubData_)(target, offset, size, data.begin() + src_offset);
+          reinterpret_cast<f>(glBufferSubData_)(target, offset, size, data.data() + src_offset);
           return Value{};
         })};
   }
@@ -533,7 +580,7 @@ Value GL::Get(const std::string &key) {
                 static_cast<uintptr_t>(args[3].ToNumber()));
           }
           if (args[3].IsBytes()) {
-            indices = args[3].ToBytes().begin();
+            indices = args[3].ToBytes().data();
           }
           using f = void(*)(GLenum, GLsizei, GLenum, const void*);
           reinterpret_cast<f>(glDrawElements_)(mode, count, type, indices);
@@ -603,7 +650,7 @@ Value GL::Get(const std::string &key) {
           GLsizeiptr size = static_cast<GLsizeiptr>(args[4].ToNumber());
           using f = void(*)(GLenum, GLintptr, GLsizeiptr, void*);
           reinterpret_cast<f>(glGetBufferSubData_)(
-              target, offset, size, data.begin() + dst_offset);
+              target, offset, size, data.data() + dst_offset);
           return Value{};
         })};
   }
@@ -806,7 +853,7 @@ Value GL::Get(const std::string &key) {
                 static_cast<uintptr_t>(args[6].ToNumber()));
           }
           if (args[6].IsBytes()) {
-            data = args[6].ToBytes().begin();
+            data = args[6].ToBytes().data();
           }
           using f = void(*)(GLint, GLint, GLsizei, GLsizei, GLenum, GLenum, void*);
           reinterpret_cast<f>(glReadPixels_)(x, y, width, height, format, type, data);
@@ -892,7 +939,7 @@ Value GL::Get(const std::string &key) {
           GLenum type = static_cast<GLenum>(args[7].ToNumber());
           void *data = nullptr;
           if (args[8].IsBytes()) {
-            data = args[8].ToBytes().begin();
+            data = args[8].ToBytes().data();
           }
           using f = void(*)(GLenum, GLint, GLint, GLsizei, GLsizei, GLint, GLenum, GLenum, const void*);
           reinterpret_cast<f>(glTexImage2D_)(
@@ -927,10 +974,10 @@ Value GL::Get(const std::string &key) {
             data = reinterpret_cast<void*>(static_cast<uintptr_t>(args[8].ToNumber()));
           }
           if (args[8].IsBytes()) {
-            data = args[8].ToBytes().begin();
+            data = args[8].ToBytes().data();
             if (args.size() > 9) {
               int offset = static_cast<int>(args[9].ToNumber());
-              data = args[8].ToBytes().begin() + offset;
+              data = args[8].ToBytes().data() + offset;
             }
           }
           using f = void(*)(GLenum, GLint, GLint, GLint, GLsizei, GLsizei, GLenum, GLenum, const void*);
@@ -962,7 +1009,7 @@ Value GL::Get(const std::string &key) {
           if (args.size() > 3) {
             count = static_cast<GLsizei>(args[3].ToNumber());
           }
-          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.begin());
+          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.data());
           using f = void(*)(GLint, GLsizei, GLfloat*);
           reinterpret_cast<f>(glUniform1fv_)(location, count, value + offset);
           return Value{};
@@ -1002,7 +1049,7 @@ Value GL::Get(const std::string &key) {
           if (args.size() > 3) {
             count = static_cast<GLsizei>(args[3].ToNumber()) / 2;
           }
-          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.begin());
+          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.data());
           using f = void(*)(GLint, GLsizei, GLfloat*);
           reinterpret_cast<f>(glUniform2fv_)(location, count, value + offset);
           return Value{};
@@ -1033,7 +1080,7 @@ Value GL::Get(const std::string &key) {
           if (args.size() > 3) {
             count = static_cast<GLsizei>(args[3].ToNumber()) / 3;
           }
-          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.begin());
+          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.data());
           using f = void(*)(GLint, GLsizei, GLfloat*);
           reinterpret_cast<f>(glUniform3fv_)(location, count, value + offset);
           return Value{};
@@ -1065,7 +1112,7 @@ Value GL::Get(const std::string &key) {
           if (args.size() > 3) {
             count = static_cast<GLsizei>(args[3].ToNumber()) / 4;
           }
-          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.begin());
+          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.data());
           using f = void(*)(GLint, GLsizei, GLfloat*);
           reinterpret_cast<f>(glUniform4fv_)(location, count, value + offset);
           return Value{};
@@ -1085,7 +1132,7 @@ Value GL::Get(const std::string &key) {
           if (args.size() > 4) {
             count = static_cast<GLsizei>(args[4].ToNumber()) / 4;
           }
-          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.begin());
+          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.data());
           using f = void(*)(GLint, GLsizei, GLboolean, GLfloat*);
           reinterpret_cast<f>(glUniformMatrix2fv_)(location, count, transpose, value + offset);
           return Value{};
@@ -1105,7 +1152,7 @@ Value GL::Get(const std::string &key) {
           if (args.size() > 4) {
             count = static_cast<GLsizei>(args[4].ToNumber()) / 9;
           }
-          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.begin());
+          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.data());
           using f = void(*)(GLint, GLsizei, GLboolean, GLfloat*);
           reinterpret_cast<f>(glUniformMatrix3fv_)(location, count, transpose, value + offset);
           return Value{};
@@ -1125,7 +1172,7 @@ Value GL::Get(const std::string &key) {
           if (args.size() > 4) {
             count = static_cast<GLsizei>(args[4].ToNumber()) / 16;
           }
-          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.begin());
+          GLfloat *value = reinterpret_cast<GLfloat *>(bytes.data());
           using f = void(*)(GLint, GLsizei, GLboolean, GLfloat*);
           reinterpret_cast<f>(glUniformMatrix4fv_)(location, count, transpose, value + offset);
           return Value{};
@@ -1158,7 +1205,7 @@ Value GL::Get(const std::string &key) {
                 static_cast<uintptr_t>(args[5].ToNumber()));
           }
           if (args[5].IsBytes()) {
-            pointer = args[5].ToBytes().begin();
+            pointer = args[5].ToBytes().data();
           }
           using f = void(*)(GLuint, GLint, GLenum, GLboolean, GLsizei, const void*);
           reinterpret_cast<f>(glVertexAttribPointer_)(
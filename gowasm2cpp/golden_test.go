@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// goldenFiles returns the paths of the generated files that are compared with the golden files.
+// The files that don't depend on the instructions, like js.cpp, are not included.
+func goldenFiles(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "inst.*"))
+	if err != nil {
+		return nil, err
+	}
+	// filepath.Glob returns the paths in lexical order.
+	return paths, nil
+}
+
+// TestOpsGolden generates C++ code from the modules in testdata/ops and compares it with the golden files.
+// Each module exercises one instruction family.
+//
+// Run `go test -run TestOpsGolden -update` to update the golden files.
+func TestOpsGolden(t *testing.T) {
+	wats, err := filepath.Glob(filepath.Join("testdata", "ops", "*.wat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wats) == 0 {
+		t.Fatal("no test modules")
+	}
+
+	for _, wat := range wats {
+		wat := wat
+		name := strings.TrimSuffix(filepath.Base(wat), ".wat")
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := GenerateWithOptions(dir, "", wat, "go2cpp_ops", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			paths, err := goldenFiles(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			for _, p := range paths {
+				src, err := ioutil.ReadFile(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got.WriteString("//// " + filepath.Base(p) + "\n")
+				got.Write(src)
+			}
+
+			golden := strings.TrimSuffix(wat, ".wat") + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("the generated code doesn't match with %s; run `go test -run TestOpsGolden -update` if the change is intended:\n%s", golden, firstDiff(string(want), got.String()))
+			}
+		})
+	}
+}
+
+// firstDiff returns a description of the first line that differs between want and got.
+func firstDiff(want, got string) string {
+	wl := strings.Split(want, "\n")
+	gl := strings.Split(got, "\n")
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if w != g {
+			return "line " + strconv.Itoa(i+1) + ":\n-" + w + "\n+" + g
+		}
+	}
+	return ""
+}
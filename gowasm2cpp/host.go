@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+func writeHost(dir string, incpath string, namespace string, exportMacro string, deterministic bool) error {
+	{
+		f, err := os.Create(filepath.Join(dir, "host.h"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := hostHTmpl.Execute(f, struct {
+			IncludeGuard  string
+			VersionCheck  string
+			IncludePath   string
+			Namespace     string
+			Export        string
+			Deterministic bool
+		}{
+			IncludeGuard:  includeGuard(namespace) + "_HOST_H",
+			VersionCheck:  versionCheck(namespace, "host.h"),
+			IncludePath:   incpath,
+			Namespace:     namespace,
+			Export:        exportPrefix(exportMacro),
+			Deterministic: deterministic,
+		}); err != nil {
+			return err
+		}
+	}
+	{
+		f, err := os.Create(filepath.Join(dir, "host.cpp"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := hostCppTmpl.Execute(f, struct {
+			IncludePath   string
+			Namespace     string
+			Deterministic bool
+		}{
+			IncludePath:   incpath,
+			Namespace:     namespace,
+			Deterministic: deterministic,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var hostHTmpl = template.Must(template.New("host.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include "{{.IncludePath}}bytes.h"
+#include "{{.IncludePath}}config.h"
+#include "{{.IncludePath}}log.h"
+
+#include <cstdint>
+#include <map>
+#include <mutex>
+#include <string>
+#include <vector>
+
+{{.VersionCheck}}
+
+namespace {{.Namespace}} {
+
+// HostServices is the interface of the platform services that the Go program uses.
+// Every function has a default implementation with the C++ standard library. Override the functions to port the
+// program to a new platform.
+//
+// Graphics, audio and inputs are provided by Driver in driver.h, which is also a HostServices.
+class {{.Export}}HostServices {
+public:
+  using LogLevel = ::{{.Namespace}}::LogLevel;
+
+  virtual ~HostServices();
+
+  // GetMonotonicTime returns the time of a monotonic clock in nanoseconds. The origin is arbitrary.
+  virtual int64_t GetMonotonicTime();
+
+  // GetUnixTime returns the wall-clock time in nanoseconds since the Unix epoch.
+  virtual int64_t GetUnixTime();
+
+  // GetRandomBytes fills bytes with random values. This is used for crypto/rand.
+  virtual void GetRandomBytes(BytesSpan bytes);
+
+  // DebugWrite is called with the debug output of the Go runtime, e.g., panic messages.
+  // The default implementation writes the lines with Log in log.h.
+  virtual void DebugWrite(const std::vector<uint8_t>& bytes);
+
+  // Log is called with the messages of JavaScript's console like console.log.
+  // The default implementation calls Log in log.h.
+  virtual void Log(LogLevel level, const std::string& message);
+
+  // GetLocalStorageItem and SetLocalStorageItem are used for JavaScript's localStorage.
+  // The default implementation keeps the items in memory.
+  virtual std::string GetLocalStorageItem(const std::string& key);
+  virtual void SetLocalStorageItem(const std::string& key, const std::string& value);
+
+private:
+  std::vector<uint8_t> debug_buffer_;
+
+  std::mutex local_storage_mutex_;
+  std::map<std::string, std::string> local_storage_;
+};
+{{if .Deterministic}}
+// DeterministicSource provides the time and the random values to the Go program instead of HostServices, so that the
+// program behaves identically on every run and platform with the same inputs, e.g., for lockstep multiplayer games.
+// The default implementation has a virtual clock starting at 0 and a pseudo-random generator with seed.
+class {{.Export}}DeterministicSource {
+public:
+  explicit DeterministicSource(uint64_t seed);
+  virtual ~DeterministicSource();
+
+  // GetTime returns the virtual time in nanoseconds. The time is both the monotonic time and the Unix time.
+  virtual int64_t GetTime();
+
+  // AdvanceTime advances the virtual time to time. The time never goes back. Go calls AdvanceTime with the deadline
+  // of a timeout of the Go program before running it, so that time.Sleep and the timers take the virtual time. The
+  // host can also call AdvanceTime, e.g., at each frame.
+  virtual void AdvanceTime(int64_t time);
+
+  // GetRandomBytes fills bytes with pseudo-random values. The values are not cryptographically strong.
+  virtual void GetRandomBytes(BytesSpan bytes);
+
+private:
+  int64_t time_ = 0;
+  uint64_t state_;
+};
+{{end}}
+}
+
+#endif  // {{.IncludeGuard}}
+`))
+
+var hostCppTmpl = template.Must(template.New("host.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}host.h"
+
+#include <algorithm>
+#include <chrono>
+#include <random>
+
+namespace {{.Namespace}} {
+
+HostServices::~HostServices() = default;
+
+int64_t HostServices::GetMonotonicTime() {
+  return std::chrono::duration_cast<std::chrono::nanoseconds>(
+      std::chrono::steady_clock::now().time_since_epoch()).count();
+}
+
+int64_t HostServices::GetUnixTime() {
+  return std::chrono::duration_cast<std::chrono::nanoseconds>(
+      std::chrono::system_clock::now().time_since_epoch()).count();
+}
+
+void HostServices::GetRandomBytes(BytesSpan bytes) {
+  // TODO: Use cryptographically strong random values instead of std::random_device.
+  static std::random_device rd;
+  std::uniform_int_distribution<int> dist(0, 255);
+  for (size_t i = 0; i < bytes.size(); i++) {
+    bytes[i] = static_cast<uint8_t>(dist(rd));
+  }
+}
+
+void HostServices::DebugWrite(const std::vector<uint8_t>& bytes) {
+  debug_buffer_.insert(debug_buffer_.end(), bytes.begin(), bytes.end());
+  for (;;) {
+    auto it = std::find(debug_buffer_.begin(), debug_buffer_.end(), '\n');
+    if (it == debug_buffer_.end()) {
+      break;
+    }
+    ::{{.Namespace}}::Log(LogLevel::kDebug, std::string(debug_buffer_.begin(), it));
+    debug_buffer_.erase(debug_buffer_.begin(), it + 1);
+  }
+}
+
+void HostServices::Log(LogLevel level, const std::string& message) {
+  ::{{.Namespace}}::Log(level, message);
+}
+
+std::string HostServices::GetLocalStorageItem(const std::string& key) {
+  std::lock_guard<std::mutex> lock{local_storage_mutex_};
+  auto it = local_storage_.find(key);
+  if (it == local_storage_.end()) {
+    return "";
+  }
+  return it->second;
+}
+
+void HostServices::SetLocalStorageItem(const std::string& key, const std::string& value) {
+  std::lock_guard<std::mutex> lock{local_storage_mutex_};
+  local_storage_[key] = value;
+}
+{{if .Deterministic}}
+DeterministicSource::DeterministicSource(uint64_t seed)
+    : state_{seed} {
+}
+
+DeterministicSource::~DeterministicSource() = default;
+
+int64_t DeterministicSource::GetTime() {
+  return time_;
+}
+
+void DeterministicSource::AdvanceTime(int64_t time) {
+  time_ = std::max(time_, time);
+}
+
+void DeterministicSource::GetRandomBytes(BytesSpan bytes) {
+  // SplitMix64 depends only on the integer arithmetic, unlike the distributions of <random>.
+  for (size_t i = 0; i < bytes.size(); i += 8) {
+    state_ += 0x9e3779b97f4a7c15ull;
+    uint64_t z = state_;
+    z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9ull;
+    z = (z ^ (z >> 27)) * 0x94d049bb133111ebull;
+    z ^= z >> 31;
+    for (size_t j = 0; j < 8 && i + j < bytes.size(); j++) {
+      bytes[i + j] = static_cast<uint8_t>(z >> (j * 8));
+    }
+  }
+}
+{{end}}
+}
+`))
@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const idsMainCpp = `#include "go.h"
+
+#include <iostream>
+#include <memory>
+
+int main() {
+  go2cpp_test::Go go{std::make_unique<go2cpp_test::StreamWriter>(std::cout)};
+  return go.Run();
+}
+`
+
+// TestValueIDs runs testdata/ids.wat and checks that the IDs of the finalized values are reused like wasm_exec.js, i.e.,
+// the last finalized ID first.
+func TestValueIDs(t *testing.T) {
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("C++ compiler not found")
+	}
+
+	dir := t.TempDir()
+	if err := Generate(dir, "", filepath.Join("testdata", "ids.wat"), "go2cpp_test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.cpp"), []byte(idsMainCpp), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srcs, err := filepath.Glob(filepath.Join(dir, "*.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bin := filepath.Join(dir, "test")
+	cmd := exec.Command(cxx, append([]string{"-std=c++14", "-pthread", "-o", bin}, srcs...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("compiling failed: %v\n%s", err, out)
+	}
+	out, err := exec.Command(bin).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	// 7 and 8 are finalized in this order, so 8 is reused first.
+	if got, want := string(out), "07 08 09 08 07 10 \n"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
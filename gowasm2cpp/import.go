@@ -2,6 +2,72 @@
 
 package gowasm2cpp
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ImportFunc is a C++ implementation of a function that the WebAssembly module imports from a module other than Go's
+// "go" and "gojs", e.g., a function of "env" that C code linked into the module calls.
+//
+// Body is the C++ body of the function. The parameters are local0_, local1_ and so on as the WebAssembly parameters,
+// go_ is the Go running the module, and go_->mem_ is the memory.
+type ImportFunc struct {
+	// Module and Name are the module name and the field name of the import, e.g., "env" and "emscripten_get_now".
+	Module string `json:"module"`
+	Name   string `json:"name"`
+
+	// Body is the C++ body of the function.
+	Body string `json:"body"`
+}
+
+// ParseImportFuncs parses a JSON array of import functions, e.g. the content of a file given by -imports.
+func ParseImportFuncs(data []byte) ([]ImportFunc, error) {
+	var funcs []ImportFunc
+	if err := json.Unmarshal(data, &funcs); err != nil {
+		return nil, fmt.Errorf("gowasm2cpp: invalid import functions: %v", err)
+	}
+	return funcs, nil
+}
+
+func (i *ImportFunc) validate() error {
+	if i.Module == "" || i.Name == "" {
+		return fmt.Errorf("import module and name must not be empty")
+	}
+	if isGoImportModule(i.Module) {
+		return fmt.Errorf("%s.%s: the imports of %q are implemented by go2cpp", i.Module, i.Name, i.Module)
+	}
+	if strings.TrimSpace(i.Body) == "" {
+		return fmt.Errorf("%s.%s: body must not be empty", i.Module, i.Name)
+	}
+	return nil
+}
+
+// isGoImportModule reports whether module is a module name of the imports by Go.
+func isGoImportModule(module string) bool {
+	return module == "go" || module == "gojs"
+}
+
+// importName returns the name of the import function. The imports by Go are named by their field names like
+// "runtime.wasmExit", and the other imports are named like "env.foo" so that they don't conflict with them.
+func importName(module, field string) string {
+	if isGoImportModule(module) {
+		return field
+	}
+	return module + "." + field
+}
+
+// findImportFunc returns the index of the implementation of the import module.field in funcs.
+func findImportFunc(funcs []ImportFunc, module, field string) (int, bool) {
+	for i, f := range funcs {
+		if f.Module == module && f.Name == field {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
 var importFuncBodies = map[string]string{
 	// func wasmExit(code int32)
 	"runtime.wasmExit": `  int32_t code = go_->mem_->LoadInt32(local0_ + 8);
@@ -49,73 +115,103 @@ var importFuncBodies = map[string]string{
 	// func finalizeRef(v ref)
 	"syscall/js.finalizeRef": `  int32_t id = static_cast<int32_t>(go_->mem_->LoadUint32(local0_ + 8));
   go_->go_ref_counts_[id]--;
+#if defined(GO2CPP_DEBUG_REFS)
+  go_->ref_finalizers_[id] = "syscall/js.finalizeRef";
+#endif
   if (go_->go_ref_counts_[id] == 0) {
     go_->finalizing_ids_.insert(id);
+    go_->finalizing_queue_.push_back(id);
   }`,
 
 	// func stringVal(value string) ref
-	"syscall/js.stringVal": `  go_->StoreValue(local0_ + 24, Value{go_->mem_->LoadString(local0_ + 8)});`,
+	"syscall/js.stringVal": `  go_->StoreValue(local0_ + 24, Value{ToValidUTF8(go_->mem_->LoadString(local0_ + 8))});`,
 
 	// func valueGet(v ref, p string) ref
-	"syscall/js.valueGet": `  Value result = Value::ReflectGet(go_->LoadValue(local0_ + 8), go_->mem_->LoadString(local0_ + 16));
-  local0_ = go_->inst_->getsp();
-  go_->StoreValue(local0_ + 32, result);`,
+	"syscall/js.valueGet": `  Value target = go_->LoadValue(local0_ + 8);
+  std::string key_storage;
+  const std::string& key = go_->LoadKey(local0_ + 16, &key_storage);
+  Value result = Value::ReflectGet(target, key);
+  scoped_sp.Reload();
+  go_->StoreValue(local0_ + 32, result);
+  go_->RecordValueOrigin(target, key, result);`,
 
 	// func valueSet(v ref, p string, x ref)
-	"syscall/js.valueSet": `  Value::ReflectSet(go_->LoadValue(local0_ + 8), go_->mem_->LoadString(local0_ + 16), go_->LoadValue(local0_ + 32));`,
+	"syscall/js.valueSet": `  std::string key_storage;
+  Value::ReflectSet(go_->LoadValue(local0_ + 8), go_->LoadKey(local0_ + 16, &key_storage), go_->LoadValue(local0_ + 32));`,
 
 	// func valueDelete(v ref, p string)
-	"syscall/js.valueDelete": `  Value::ReflectDelete(go_->LoadValue(local0_ + 8), go_->mem_->LoadString(local0_ + 16));`,
+	"syscall/js.valueDelete": `  std::string key_storage;
+  Value::ReflectDelete(go_->LoadValue(local0_ + 8), go_->LoadKey(local0_ + 16, &key_storage));`,
 
 	// func valueIndex(v ref, i int) ref
-	"syscall/js.valueIndex": `  go_->StoreValue(local0_ + 24, Value::ReflectGet(go_->LoadValue(local0_ + 8), std::to_string(go_->mem_->LoadInt64(local0_ + 16))));`,
+	"syscall/js.valueIndex": `  Value result = Value::ReflectGet(go_->LoadValue(local0_ + 8), std::to_string(go_->mem_->LoadInt64(local0_ + 16)));
+  scoped_sp.Reload();
+  go_->StoreValue(local0_ + 24, result);`,
 
 	// valueSetIndex(v ref, i int, x ref)
 	"syscall/js.valueSetIndex": `  Value::ReflectSet(go_->LoadValue(local0_ + 8), std::to_string(go_->mem_->LoadInt64(local0_ + 16)), go_->LoadValue(local0_ + 24));`,
 
 	// func valueCall(v ref, m string, args []ref) (ref, bool)
 	"syscall/js.valueCall": `  Value v = go_->LoadValue(local0_ + 8);
-  Value m = Value::ReflectGet(v, go_->mem_->LoadString(local0_ + 16));
+  std::string name_storage;
+  const std::string& name = go_->LoadKey(local0_ + 16, &name_storage);
   std::vector<Value> args = go_->LoadSliceOfValues(local0_ + 32);
-  Value result = Value::ReflectApply(m, v, args);
-  local0_ = go_->inst_->getsp();
+  Value result;
+  bool ok = CatchHostError([&]() {
+    Value m = Value::ReflectGet(v, name);
+    result = Value::ReflectApply(m, v, args);
+  }, &result);
+  scoped_sp.Reload();
   go_->StoreValue(local0_ + 56, result);
-  go_->mem_->StoreInt8(local0_ + 64, 1);`,
+  go_->mem_->StoreInt8(local0_ + 64, ok ? 1 : 0);`,
 
 	// func valueInvoke(v ref, args []ref) (ref, bool)
 	"syscall/js.valueInvoke": `  Value v = go_->LoadValue(local0_ + 8);
   std::vector<Value> args = go_->LoadSliceOfValues(local0_ + 16);
-  Value result = Value::ReflectApply(v, Value{}, args);
-  local0_ = go_->inst_->getsp();
+  Value result;
+  bool ok = CatchHostError([&]() {
+    result = Value::ReflectApply(v, Value{}, args);
+  }, &result);
+  scoped_sp.Reload();
   go_->StoreValue(local0_ + 40, result);
-  go_->mem_->StoreInt8(local0_ + 48, 1);`,
+  go_->mem_->StoreInt8(local0_ + 48, ok ? 1 : 0);`,
 
 	// func valueNew(v ref, args []ref) (ref, bool)
 	"syscall/js.valueNew": `  Value v = go_->LoadValue(local0_ + 8);
   std::vector<Value> args = go_->LoadSliceOfValues(local0_ + 16);
-  Value result = Value::ReflectConstruct(v, args);
-  if (!result.IsUndefined()) {
-    local0_ = go_->inst_->getsp();
-    go_->StoreValue(local0_ + 40, result);
-    go_->mem_->StoreInt8(local0_ + 48, 1);
-  } else {
-    go_->StoreValue(local0_ + 40, Value{});
-    go_->mem_->StoreInt8(local0_ + 48, 0);
-  }`,
+  Value result;
+  bool ok = CatchHostError([&]() {
+    result = Value::ReflectConstruct(v, args);
+  }, &result);
+  scoped_sp.Reload();
+  if (ok && result.IsUndefined()) {
+    // The constructor is not implemented.
+    ok = false;
+  }
+  go_->StoreValue(local0_ + 40, result);
+  go_->mem_->StoreInt8(local0_ + 48, ok ? 1 : 0);`,
 
 	// func valueLength(v ref) int
-	"syscall/js.valueLength": `  go_->mem_->StoreInt64(local0_ + 16, static_cast<int64_t>(go_->LoadValue(local0_ + 8).ToArray().size()));`,
+	"syscall/js.valueLength": `  Value v = go_->LoadValue(local0_ + 8);
+  int64_t len = 0;
+  if (v.IsArray()) {
+    len = static_cast<int64_t>(v.ToArray().size());
+  } else {
+    len = static_cast<int64_t>(Value::ReflectGet(v, "length").ToNumber());
+    scoped_sp.Reload();
+  }
+  go_->mem_->StoreInt64(local0_ + 16, len);`,
 
 	// valuePrepareString(v ref) (ref, int)
-	"syscall/js.valuePrepareString": `  std::string str = go_->LoadValue(local0_ + 8).ToString();
+	"syscall/js.valuePrepareString": `  std::string str = ToValidUTF8(go_->LoadValue(local0_ + 8).ToString());
   go_->StoreValue(local0_ + 16, Value{str});
   go_->mem_->StoreInt64(local0_ + 24, static_cast<int64_t>(str.size()));`,
 
 	// valueLoadString(v ref, b []byte)
-	"syscall/js.valueLoadString": `  std::string src = go_->LoadValue(local0_ + 8).ToString();
+	"syscall/js.valueLoadString": `  std::string src = ToValidUTF8(go_->LoadValue(local0_ + 8).ToString());
   BytesSpan dst = go_->mem_->LoadSlice(local0_ + 16);
   int len = std::min(dst.size(), src.size());
-  std::memcpy(dst.begin(), &(*src.begin()), len);`,
+  std::memcpy(dst.data(), &(*src.begin()), len);`,
 
 	/*// func valueInstanceOf(v ref, t ref) bool
 	"syscall/js.valueInstanceOf": (sp) => {
@@ -130,7 +226,7 @@ var importFuncBodies = map[string]string{
     return;
   }
   BytesSpan srcbs = src.ToBytes();
-  std::memcpy(dst.begin(), srcbs.begin(), std::min(srcbs.size(), dst.size()));
+  std::memcpy(dst.data(), srcbs.data(), std::min(srcbs.size(), dst.size()));
   go_->mem_->StoreInt64(local0_ + 40, static_cast<int64_t>(dst.size()));
   go_->mem_->StoreInt8(local0_ + 48, 1);`,
 
@@ -142,11 +238,36 @@ var importFuncBodies = map[string]string{
     return;
   }
   BytesSpan dstbs = dst.ToBytes();
-  std::memcpy(dstbs.begin(), src.begin(), std::min(src.size(), dstbs.size()));
+  std::memcpy(dstbs.data(), src.data(), std::min(src.size(), dstbs.size()));
   go_->mem_->StoreInt64(local0_ + 40, static_cast<int64_t>(dstbs.size()));
   go_->mem_->StoreInt8(local0_ + 48, 1);`,
 
-	"debug": `  std::cout << local0_ << std::endl;`,
+	"debug": `  Log(LogLevel::kInfo, std::to_string(local0_));`,
+}
+
+// reentrantImports is the imports that access the stack after a call that might call back into Go, e.g., via a getter
+// or a function made by js.FuncOf. Go might move its stack during the call, so the bodies reload the stack pointer with
+// ScopedSP::Reload before storing the results.
+var reentrantImports = map[string]struct{}{
+	"syscall/js.valueGet":    {},
+	"syscall/js.valueIndex":  {},
+	"syscall/js.valueCall":   {},
+	"syscall/js.valueInvoke": {},
+	"syscall/js.valueNew":    {},
+	"syscall/js.valueLength": {},
+}
+
+// scopedSPBody wraps the body of a reentrant import with ScopedSP.
+func scopedSPBody(body string) string {
+	return "  ScopedSP scoped_sp{go_, &local0_};\n" + body
+}
+
+// refCheckBody wraps the body of the import name with the validation of the references of GO2CPP_DEBUG_REFS.
+func refCheckBody(name string, body string) string {
+	return fmt.Sprintf(`#if defined(GO2CPP_DEBUG_REFS)
+  RefCheckScope ref_check_scope{go_, %s};
+#endif
+%s`, cppStringLiteral(name), body)
 }
 
 func init() {
@@ -7,9 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"text/template"
-
-	"golang.org/x/sync/errgroup"
 )
 
 func min(a, b int) int {
@@ -19,7 +18,102 @@ func min(a, b int) int {
 	return b
 }
 
-func writeInst(dir string, incpath string, namespace string, importFuncs, funcs []*wasmFunc, exports []*wasmExport, globals []*wasmGlobal, types []*wasmType, tables [][]uint32) error {
+// instanceExports are the exports of a Go program that Instance declares, with their signatures.
+var instanceExports = map[string]string{
+	"run":    "(i32, i32) -> ()",
+	"resume": "() -> ()",
+	"getsp":  "() -> (i32)",
+}
+
+// markInstanceExports marks the exports overriding Instance's functions, and reports whether the module has all of
+// them, i.e., Inst implements Instance.
+func markInstanceExports(exports []*wasmExport) bool {
+	var n int
+	for _, e := range exports {
+		if sig, ok := instanceExports[e.Name]; ok && sigString(e.Funcs[e.Index].Wasm.Sig) == sig {
+			n++
+		}
+	}
+	if n != len(instanceExports) {
+		return false
+	}
+	for _, e := range exports {
+		if _, ok := instanceExports[e.Name]; ok {
+			e.override = true
+		}
+	}
+	return true
+}
+
+// importModule is the imports from one module, which Inst calls via the abstract class of the module.
+type importModule struct {
+	Name   string
+	Class  string
+	Member string
+	Param  string
+	Funcs  []*wasmFunc
+}
+
+// importModules groups importFuncs by their modules in the order of their first appearances.
+func importModules(importFuncs []*wasmFunc) []*importModule {
+	var ms []*importModule
+	byName := map[string]*importModule{}
+	classes := map[string]struct{}{}
+	for _, f := range importFuncs {
+		m, ok := byName[f.importModule]
+		if !ok {
+			class := importClassName(f.importModule)
+			// Different module names like "a_b" and "a-b" might have the same class name.
+			for i := 2; ; i++ {
+				if _, ok := classes[class]; !ok && class != "Import" {
+					break
+				}
+				class = fmt.Sprintf("%s%d", importClassName(f.importModule), i)
+			}
+			classes[class] = struct{}{}
+			m = &importModule{
+				Name:   f.importModule,
+				Class:  class,
+				Member: importMember(f.importModule),
+				Param:  strings.TrimSuffix(importMember(f.importModule), "_"),
+			}
+			byName[f.importModule] = m
+			ms = append(ms, m)
+		}
+		m.Funcs = append(m.Funcs, f)
+	}
+	return ms
+}
+
+// importClassName returns the name of the abstract class of the imports from module, e.g., "ImportGojs" for "gojs"
+// and "ImportWasiSnapshotPreview1" for "wasi_snapshot_preview1".
+func importClassName(module string) string {
+	var b strings.Builder
+	b.WriteString("Import")
+	upper := true
+	for _, r := range module {
+		isAlnum := '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+		if !isAlnum {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		b.WriteRune(r)
+		upper = false
+	}
+	return b.String()
+}
+
+// importMember returns the member of Inst pointing to the implementation of the imports from module.
+func importMember(module string) string {
+	return "import_" + identifierFromString(module) + "_"
+}
+
+// writeInst writes Inst. instance reports whether Inst implements Instance, pruned are the indices of the functions
+// that are not translated, and watchdog reports whether the functions have the markers for Watchdog.
+func writeInst(dir string, incpath string, namespace string, importFuncs, funcs []*wasmFunc, exports []*wasmExport, globals []*wasmGlobal, types []*wasmType, tables [][]uint32, instance bool, pruned []int, watchdog bool, deterministic bool) error {
 	const groupSize = 64
 
 	sort.Slice(funcs, func(a, b int) bool {
@@ -29,7 +123,7 @@ func writeInst(dir string, incpath string, namespace string, importFuncs, funcs
 		return exports[a].Name < exports[b].Name
 	})
 
-	var g errgroup.Group
+	var g group
 	g.Go(func() error {
 		f, err := os.Create(filepath.Join(dir, "inst.h"))
 		if err != nil {
@@ -45,9 +139,10 @@ func writeInst(dir string, incpath string, namespace string, importFuncs, funcs
 		}
 		if err := instHTmpl.Execute(f, struct {
 			IncludeGuard        string
+			VersionCheck        string
 			IncludePath         string
 			Namespace           string
-			ImportFuncs         []*wasmFunc
+			ImportModules       []*importModule
 			Exports             []*wasmExport
 			Funcs               []*wasmFunc
 			Types               []*wasmType
@@ -55,18 +150,21 @@ func writeInst(dir string, incpath string, namespace string, importFuncs, funcs
 			NumFuncs            int
 			NumTable            int
 			NumMaxTableElements int
+			Instance            bool
 		}{
 			IncludeGuard:        includeGuard(namespace) + "_INST_H",
+			VersionCheck:        versionCheck(namespace, "inst.h"),
 			IncludePath:         incpath,
 			Namespace:           namespace,
-			ImportFuncs:         importFuncs,
+			ImportModules:       importModules(importFuncs),
 			Exports:             exports,
 			Funcs:               funcs,
 			Types:               types,
 			Globals:             globals,
-			NumFuncs:            len(importFuncs) + len(funcs),
+			NumFuncs:            len(importFuncs) + len(funcs) + len(pruned),
 			NumTable:            len(tables),
 			NumMaxTableElements: m,
+			Instance:            instance,
 		}); err != nil {
 			return err
 		}
@@ -91,13 +189,17 @@ func writeInst(dir string, incpath string, namespace string, importFuncs, funcs
 			defer f.Close()
 
 			if err := instFuncCppTmpl.Execute(f, struct {
-				IncludePath string
-				Namespace   string
-				Funcs       []*wasmFunc
+				IncludePath   string
+				Namespace     string
+				Funcs         []*wasmFunc
+				Watchdog      bool
+				Deterministic bool
 			}{
-				IncludePath: incpath,
-				Namespace:   namespace,
-				Funcs:       fs,
+				IncludePath:   incpath,
+				Namespace:     namespace,
+				Funcs:         fs,
+				Watchdog:      watchdog,
+				Deterministic: deterministic,
 			}); err != nil {
 				return err
 			}
@@ -136,21 +238,25 @@ func writeInst(dir string, incpath string, namespace string, importFuncs, funcs
 		defer f.Close()
 
 		if err := instInitCppTmpl.Execute(f, struct {
-			IncludePath string
-			Namespace   string
-			ImportFuncs []*wasmFunc
-			Funcs       []*wasmFunc
-			Types       []*wasmType
-			Tables      [][]uint32
-			Globals     []*wasmGlobal
+			IncludePath   string
+			Namespace     string
+			ImportModules []*importModule
+			ImportFuncs   []*wasmFunc
+			Funcs         []*wasmFunc
+			Pruned        []int
+			Types         []*wasmType
+			Tables        [][]uint32
+			Globals       []*wasmGlobal
 		}{
-			IncludePath: incpath,
-			Namespace:   namespace,
-			ImportFuncs: importFuncs,
-			Funcs:       funcs,
-			Types:       types,
-			Tables:      tables,
-			Globals:     globals,
+			IncludePath:   incpath,
+			Namespace:     namespace,
+			ImportModules: importModules(importFuncs),
+			ImportFuncs:   importFuncs,
+			Funcs:         funcs,
+			Pruned:        pruned,
+			Types:         types,
+			Tables:        tables,
+			Globals:       globals,
 		}); err != nil {
 			return err
 		}
@@ -167,24 +273,67 @@ var instHTmpl = template.Must(template.New("inst.h").Parse(`// Code generated by
 #ifndef {{.IncludeGuard}}
 #define {{.IncludeGuard}}
 
+#include "{{.IncludePath}}config.h"
+
 #include <cstdint>
+#include <vector>
+
+{{.VersionCheck}}
 
 namespace {{.Namespace}} {
 
 class Mem;
+{{range $m := .ImportModules}}
+// {{$m.Class}} is the interface of the functions imported from the module "{{$m.Name}}".
+class {{$m.Class}} {
+public:
+  virtual ~{{$m.Class}}();
 
-class Import {
+{{range $value := $m.Funcs}}{{$value.CppDecl "  " true false}}
+
+{{end -}} };
+{{end}}
+// Import is the interface of all the imported functions.
+class Import{{range $i, $m := .ImportModules}}{{if $i}},{{else}} :{{end}} public {{$m.Class}}{{end}} {
 public:
   virtual ~Import();
+};
+
+// Instance is the interface of a WebAssembly instance that Go runs the program with. Inst, the translated module,
+// implements Instance when the module is a Go program. An alternative implementation like an interpreter can be used
+// instead with Go::SetInstanceFactory.
+class Instance {
+public:
+  virtual ~Instance();
 
-{{range $value := .ImportFuncs}}{{$value.CppDecl "  " true false}}
+  // run runs the Go program with the arguments in the memory. run returns when the program exits or waits for an
+  // event.
+  virtual void run(int32_t argc, int32_t argv) = 0;
 
-{{end -}} };
+  // resume resumes the program to handle the pending event. resume returns when the program exits or waits for an
+  // event again.
+  virtual void resume() = 0;
+
+  // getsp returns the current stack pointer of the program. The stack might be moved while the program runs.
+  virtual int32_t getsp() = 0;
+
+  // GetGlobals and SetGlobals are used to take and restore a snapshot. Each global is stored in its bit pattern.
+  virtual std::vector<uint64_t> GetGlobals() const = 0;
+  virtual void SetGlobals(const std::vector<uint64_t>& globals) = 0;
+};
 
-class Inst {
+class Inst{{if .Instance}} : public Instance{{end}} {
 public:
   Inst(Mem* mem, Import* import);
 
+  // The second constructor takes the implementations of the imports for each module, so that the modules can be
+  // implemented separately, e.g., the ones linked from C code by another toolchain.
+  Inst(Mem* mem{{range .ImportModules}}, {{.Class}}* {{.Param}}{{end}});
+
+  // GetGlobals and SetGlobals are used to take and restore a snapshot. Each global is stored in its bit pattern.
+  std::vector<uint64_t> GetGlobals() const{{if .Instance}} override{{end}};
+  void SetGlobals(const std::vector<uint64_t>& globals){{if .Instance}} override{{end}};
+
 {{range $value := .Exports}}{{$value.CppDecl "  "}}
 {{end}}
 private:
@@ -196,13 +345,20 @@ private:
 
 {{range $value := .Funcs}}{{$value.CppDecl "  " false false}}
 
-{{end}}  Mem* mem_;
-  Import* import_;
-  Func funcs_[{{.NumFuncs}}];
-  uint32_t table_[{{.NumTable}}][{{.NumMaxTableElements}}];
+{{end}}  static constexpr uint32_t kTableSize = {{.NumMaxTableElements}};
 
+  // The hot members that most of the functions touch, i.e., the memory and the globals like the stack pointer, come
+  // first so that they share cache lines. The large tables for call_indirect follow them.
+  Mem* mem_;
 {{range $value := .Globals}}  {{$value.Cpp}}
-{{end}}};
+{{end}}{{range .ImportModules}}  {{.Class}}* {{.Member}};
+{{end}}
+  Func funcs_[{{.NumFuncs}}];
+
+  // kTable is the table for call_indirect. The table is never modified, as Go doesn't use table.set, and is shared by
+  // all the instances.
+  static const uint32_t kTable[{{.NumTable}}][kTableSize];
+};
 
 }
 
@@ -210,14 +366,28 @@ private:
 `))
 
 var instFuncCppTmpl = template.Must(template.New("inst.funcs.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
-
+{{if .Deterministic}}
+// The deterministic mode doesn't allow the compilers to fuse a multiplication and an addition into an FMA instruction,
+// which rounds differently. This precedes the includes so that the inline functions are also compiled in this way.
+#if defined(__clang__)
+#pragma STDC FP_CONTRACT OFF
+#elif defined(__GNUC__)
+#pragma GCC optimize("fp-contract=off")
+#elif defined(_MSC_VER)
+#pragma fp_contract(off)
+#endif
+{{end}}
 #include "{{.IncludePath}}inst.h"
 
 #include "{{.IncludePath}}bits.h"
+#include "{{.IncludePath}}config.h"
+#include "{{.IncludePath}}math.h"
 #include "{{.IncludePath}}mem.h"
-
+{{if .Watchdog}}#include "{{.IncludePath}}watchdog.h"
+{{end}}
 #include <cassert>
 #include <cmath>
+#include <string>
 
 namespace {{.Namespace}} {
 
@@ -239,19 +409,43 @@ var instInitCppTmpl = template.Must(template.New("inst.init.cpp").Parse(`// Code
 
 #include "{{.IncludePath}}inst.h"
 
+#include <cassert>
+#include <cstring>
+
 namespace {{.Namespace}} {
 
-Import::~Import() = default;
+{{range .ImportModules}}{{.Class}}::~{{.Class}}() = default;
+
+{{end}}Import::~Import() = default;
+
+Instance::~Instance() = default;
+
+GO2CPP_CONSTINIT const uint32_t Inst::kTable[{{len .Tables}}][Inst::kTableSize] = {
+{{range $value := .Tables}}  { {{- range $value2 := $value}}{{$value2}}, {{end}} },
+{{end}}};
 
 Inst::Inst(Mem* mem, Import* import)
-    : mem_{mem},
-      import_{import},
-      table_{
-{{range $value := .Tables}}        { {{- range $value2 := $value}}{{$value2}}, {{end}} },
-{{end}}      } {
+    : Inst{mem{{range .ImportModules}}, static_cast<{{.Class}}*>(import){{end}}} {
+}
+
+Inst::Inst(Mem* mem{{range .ImportModules}}, {{.Class}}* {{.Param}}{{end}})
+    : mem_{mem}{{range .ImportModules}},
+      {{.Member}}{ {{- .Param}}}{{end}} {
 {{range $value := .ImportFuncs}}  funcs_[{{.Index}}].type0_ = nullptr;
+{{end}}{{range $value := .Pruned}}  funcs_[{{.}}].type0_ = nullptr;
 {{end}}{{range $value := .Funcs}}  funcs_[{{.Index}}].type{{.Type.Index}}_ = &Inst::{{.Identifier}};
 {{end}}}
 
+std::vector<uint64_t> Inst::GetGlobals() const {
+  std::vector<uint64_t> globals({{len .Globals}});
+{{range $value := .Globals}}  std::memcpy(&globals[{{.Index}}], &global{{.Index}}_, sizeof(global{{.Index}}_));
+{{end}}  return globals;
+}
+
+void Inst::SetGlobals(const std::vector<uint64_t>& globals) {
+  assert(globals.size() == {{len .Globals}});
+{{range $value := .Globals}}  std::memcpy(&global{{.Index}}_, &globals[{{.Index}}], sizeof(global{{.Index}}_));
+{{end}}}
+
 }
 `))
@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+// Intrinsic is a native C++ implementation used instead of the translation of a function.
+//
+// Body is the C++ body of the function. The parameters are local0_, local1_ and so on, the globals are global0_,
+// global1_ and so on, and the memory is mem_. A Go function takes one i32 parameter and returns an i32 with its
+// arguments and results on the Go stack: the arguments start at global0_ + 8 as global0_ points to the return address,
+// and the function must add 8 to global0_ and return 0 unless it unwinds.
+type Intrinsic struct {
+	// Name is the name of the function like "runtime.memmove".
+	Name string `json:"name"`
+
+	// Params and Results are the value types of the function's signature like "i32". If the signature of the function
+	// doesn't match, the intrinsic is not used and a warning is reported.
+	Params  []string `json:"params"`
+	Results []string `json:"results"`
+
+	// Hash is the hash of the function's code reported in the call graph. If Hash is not empty, the intrinsic is used
+	// only when the code matches, e.g., for a function compiled from Go, whose code might change with the Go version.
+	Hash string `json:"hash,omitempty"`
+
+	// Body is the C++ body of the function.
+	Body string `json:"body"`
+}
+
+// ParseIntrinsics parses a JSON array of intrinsics, e.g. the content of a file given by -intrinsics.
+func ParseIntrinsics(data []byte) ([]Intrinsic, error) {
+	var intrinsics []Intrinsic
+	if err := json.Unmarshal(data, &intrinsics); err != nil {
+		return nil, fmt.Errorf("gowasm2cpp: invalid intrinsics: %v", err)
+	}
+	return intrinsics, nil
+}
+
+func (i *Intrinsic) validate() error {
+	if i.Name == "" {
+		return fmt.Errorf("intrinsic name must not be empty")
+	}
+	if strings.TrimSpace(i.Body) == "" {
+		return fmt.Errorf("%s: body must not be empty", i.Name)
+	}
+	for _, t := range append(append([]string{}, i.Params...), i.Results...) {
+		if _, ok := valueTypeByName(t); !ok {
+			return fmt.Errorf("%s: invalid value type: %q", i.Name, t)
+		}
+	}
+	return nil
+}
+
+func valueTypeByName(name string) (wasm.ValueType, bool) {
+	for _, t := range []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64} {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+func (i *Intrinsic) match(sig *wasm.FunctionSig) bool {
+	if len(i.Params) != len(sig.ParamTypes) || len(i.Results) != len(sig.ReturnTypes) {
+		return false
+	}
+	for j, t := range sig.ParamTypes {
+		if i.Params[j] != t.String() {
+			return false
+		}
+	}
+	for j, t := range sig.ReturnTypes {
+		if i.Results[j] != t.String() {
+			return false
+		}
+	}
+	return true
+}
+
+// sigString returns sig in the form of "(i32, i32) -> (i32)".
+func sigString(sig *wasm.FunctionSig) string {
+	var params, results []string
+	for _, t := range sig.ParamTypes {
+		params = append(params, t.String())
+	}
+	for _, t := range sig.ReturnTypes {
+		results = append(results, t.String())
+	}
+	return fmt.Sprintf("(%s) -> (%s)", strings.Join(params, ", "), strings.Join(results, ", "))
+}
+
+// goFuncParams and goFuncResults are the signature of the Go functions.
+var (
+	goFuncParams  = []string{"i32"}
+	goFuncResults = []string{"i32"}
+)
+
+// builtinIntrinsics are the intrinsics used by default. memcmp, memeqbody, cmpbody and memchr are the internal
+// functions of the Go assembly with their arguments as WebAssembly parameters.
+var builtinIntrinsics = []Intrinsic{
+	{
+		Name:    "memcmp",
+		Params:  []string{"i32", "i32", "i32"},
+		Results: []string{"i32"},
+		Body:    `  return static_cast<int32_t>(mem_->Memcmp(local0_, local1_, local2_));`,
+	},
+	{
+		Name:    "memeqbody",
+		Params:  []string{"i32", "i32", "i32"},
+		Results: []string{"i64"},
+		Body:    `  return static_cast<int64_t>(mem_->Memcmp(local0_, local1_, local2_) == 0);`,
+	},
+	{
+		Name:    "memeqbody",
+		Params:  []string{"i64", "i64", "i64"},
+		Results: []string{"i64"},
+		Body:    `  return static_cast<int64_t>(mem_->Memcmp(static_cast<int32_t>(local0_), static_cast<int32_t>(local1_), static_cast<int32_t>(local2_)) == 0);`,
+	},
+	{
+		Name:    "cmpbody",
+		Params:  []string{"i64", "i64", "i64", "i64"},
+		Results: []string{"i64"},
+		Body: `  int32_t len = static_cast<int32_t>(local1_ < local3_ ? local1_ : local3_);
+  int result = len > 0 ? mem_->Memcmp(static_cast<int32_t>(local0_), static_cast<int32_t>(local2_), len) : 0;
+  if (result) {
+    return result < 0 ? -1 : 1;
+  }
+  if (local1_ == local3_) {
+    return 0;
+  }
+  return local1_ < local3_ ? -1 : 1;`,
+	},
+	{
+		Name:    "memchr",
+		Params:  []string{"i32", "i32", "i32"},
+		Results: []string{"i32"},
+		Body:    `  return static_cast<int32_t>(mem_->Memchr(local0_, local1_, local2_));`,
+	},
+	{
+		Name:   "runtime.wasmMove",
+		Params: []string{"i32", "i32", "i32"},
+		Body:   `  mem_->Memmove(local0_, local1_, local2_ * 8);`,
+	},
+	{
+		Name:   "runtime.wasmZero",
+		Params: []string{"i32", "i32"},
+		Body:   `  mem_->Memset(local0_, 0, local1_ * 8);`,
+	},
+
+	// func memmove(to, from unsafe.Pointer, n uintptr)
+	{
+		Name:    "runtime.memmove",
+		Params:  goFuncParams,
+		Results: goFuncResults,
+		Body: `  int32_t sp = global0_;
+  mem_->Memmove(static_cast<int32_t>(mem_->LoadInt64(sp + 8)), static_cast<int32_t>(mem_->LoadInt64(sp + 16)), static_cast<int32_t>(mem_->LoadInt64(sp + 24)));
+  global0_ = sp + 8;
+  return 0;`,
+	},
+
+	// func memclrNoHeapPointers(ptr unsafe.Pointer, n uintptr)
+	{
+		Name:    "runtime.memclrNoHeapPointers",
+		Params:  goFuncParams,
+		Results: goFuncResults,
+		Body: `  int32_t sp = global0_;
+  mem_->Memset(static_cast<int32_t>(mem_->LoadInt64(sp + 8)), 0, static_cast<int32_t>(mem_->LoadInt64(sp + 16)));
+  global0_ = sp + 8;
+  return 0;`,
+	},
+
+	// func memequal(a, b unsafe.Pointer, size uintptr) bool
+	{
+		Name:    "runtime.memequal",
+		Params:  goFuncParams,
+		Results: goFuncResults,
+		Body: `  int32_t sp = global0_;
+  int32_t a = static_cast<int32_t>(mem_->LoadInt64(sp + 8));
+  int32_t b = static_cast<int32_t>(mem_->LoadInt64(sp + 16));
+  int32_t size = static_cast<int32_t>(mem_->LoadInt64(sp + 24));
+  mem_->StoreInt8(sp + 32, a == b || mem_->Memcmp(a, b, size) == 0 ? 1 : 0);
+  global0_ = sp + 8;
+  return 0;`,
+	},
+
+	// func cmpstring(a, b string) int
+	{
+		Name:    "runtime.cmpstring",
+		Params:  goFuncParams,
+		Results: goFuncResults,
+		Body: `  int32_t sp = global0_;
+  mem_->StoreInt64(sp + 40, mem_->CompareBytes(sp + 8, sp + 24));
+  global0_ = sp + 8;
+  return 0;`,
+	},
+
+	// func Compare(a, b []byte) int
+	{
+		Name:    "internal/bytealg.Compare",
+		Params:  goFuncParams,
+		Results: goFuncResults,
+		Body: `  int32_t sp = global0_;
+  mem_->StoreInt64(sp + 56, mem_->CompareBytes(sp + 8, sp + 32));
+  global0_ = sp + 8;
+  return 0;`,
+	},
+
+	// func IndexByte(b []byte, c byte) int
+	{
+		Name:    "internal/bytealg.IndexByte",
+		Params:  goFuncParams,
+		Results: goFuncResults,
+		Body: `  int32_t sp = global0_;
+  mem_->StoreInt64(sp + 40, mem_->IndexByte(sp + 8, mem_->LoadUint8(sp + 32)));
+  global0_ = sp + 8;
+  return 0;`,
+	},
+
+	// func IndexByteString(s string, c byte) int
+	{
+		Name:    "internal/bytealg.IndexByteString",
+		Params:  goFuncParams,
+		Results: goFuncResults,
+		Body: `  int32_t sp = global0_;
+  mem_->StoreInt64(sp + 32, mem_->IndexByte(sp + 8, mem_->LoadUint8(sp + 24)));
+  global0_ = sp + 8;
+  return 0;`,
+	},
+}
+
+// intrinsics returns the additional intrinsics and the builtin ones by name. A name can have multiple intrinsics with
+// different signatures, as the signatures of the internal functions vary among the Go versions. The additional
+// intrinsics precede the builtin ones.
+func intrinsics(additional []Intrinsic) map[string][]*Intrinsic {
+	m := map[string][]*Intrinsic{}
+	for i := range additional {
+		m[additional[i].Name] = append(m[additional[i].Name], &additional[i])
+	}
+	for i := range builtinIntrinsics {
+		m[builtinIntrinsics[i].Name] = append(m[builtinIntrinsics[i].Name], &builtinIntrinsics[i])
+	}
+	return m
+}
+
+// findIntrinsic returns the first intrinsic in candidates matching sig and the hash of the code. hash is called only
+// when an intrinsic has Hash.
+func findIntrinsic(candidates []*Intrinsic, sig *wasm.FunctionSig, hash func() (string, error)) (*Intrinsic, bool, error) {
+	var h string
+	for _, i := range candidates {
+		if !i.match(sig) {
+			continue
+		}
+		if i.Hash != "" {
+			if h == "" {
+				var err error
+				h, err = hash()
+				if err != nil {
+					return nil, false, err
+				}
+			}
+			if i.Hash != h {
+				continue
+			}
+		}
+		return i, true, nil
+	}
+	return nil, false, nil
+}
+
+// codeHash returns the hash of the function body. The callees are identified by their names and the types of
+// call_indirect by their signatures instead of their indices, so that the hash doesn't change when the other functions
+// of the module are changed.
+func codeHash(body *wasm.FunctionBody, funcName func(idx uint32) string, typeSig func(idx uint32) *wasm.FunctionSig) (string, error) {
+	instrs, err := body.Instrs()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, l := range body.Locals {
+		fmt.Fprintf(h, "%d %s;", l.Count, l.Type)
+	}
+	h.Write([]byte{0})
+	for _, instr := range instrs {
+		switch instr.Op {
+		case wasm.OpCall:
+			fmt.Fprintf(h, "%d %q;", instr.Op, funcName(instr.Immediates[0].(uint32)))
+		case wasm.OpCallIndirect:
+			fmt.Fprintf(h, "%d %s;", instr.Op, sigString(typeSig(instr.Immediates[0].(uint32))))
+		default:
+			fmt.Fprintf(h, "%d %v;", instr.Op, instr.Immediates)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
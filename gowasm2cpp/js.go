@@ -8,7 +8,7 @@ import (
 	"text/template"
 )
 
-func writeJS(dir string, incpath string, namespace string) error {
+func writeJS(dir string, incpath string, namespace string, exportMacro string) error {
 	{
 		f, err := os.Create(filepath.Join(dir, "js.h"))
 		if err != nil {
@@ -18,12 +18,16 @@ func writeJS(dir string, incpath string, namespace string) error {
 
 		if err := jsHTmpl.Execute(f, struct {
 			IncludeGuard string
+			VersionCheck string
 			IncludePath  string
 			Namespace    string
+			Export       string
 		}{
 			IncludeGuard: includeGuard(namespace) + "_JS_H",
+			VersionCheck: versionCheck(namespace, "js.h"),
 			IncludePath:  incpath,
 			Namespace:    namespace,
+			Export:       exportPrefix(exportMacro),
 		}); err != nil {
 			return err
 		}
@@ -54,9 +58,11 @@ var jsHTmpl = template.Must(template.New("js.h").Parse(`// Code generated by go2
 #define {{.IncludeGuard}}
 
 #include "{{.IncludePath}}bytes.h"
+#include "{{.IncludePath}}config.h"
 
 #include <deque>
 #include <dirent.h>
+#include <exception>
 #include <functional>
 #include <iostream>
 #include <map>
@@ -64,17 +70,19 @@ var jsHTmpl = template.Must(template.New("js.h").Parse(`// Code generated by go2
 #include <string>
 #include <vector>
 
+{{.VersionCheck}}
+
 namespace {{.Namespace}} {
 
 class Object;
 
-class Writer {
+class {{.Export}}Writer {
 public:
   virtual ~Writer();
   virtual void Write(const std::vector<uint8_t>& bytes) = 0;
 };
 
-class StreamWriter : public Writer {
+class {{.Export}}StreamWriter : public Writer {
 public:
   explicit StreamWriter(std::ostream& out);
   void Write(const std::vector<uint8_t>& bytes) override;
@@ -87,7 +95,7 @@ private:
 
 class ArrayBuffer;
 
-class Value {
+class {{.Export}}Value {
 public:
   using Type = uint8_t;
   static constexpr Type kUndefined = 0;
@@ -97,7 +105,7 @@ public:
   static constexpr Type kString = 1 << 3;
   static constexpr Type kObject = 1 << 4;
 
-  class Hash {
+  class {{.Export}}Hash {
   public:
     std::size_t operator()(const Value& value) const;
   };
@@ -134,10 +142,13 @@ public:
 
   bool ToBool() const;
   double ToNumber() const;
-  std::string ToString() const;
+  const std::string& ToString() const;
   BytesSpan ToBytes();
   Object& ToObject();
   const Object& ToObject() const;
+  // ToSharedObject returns the object that the value shares. This is useful to keep a weak reference to the object
+  // with std::weak_ptr.
+  std::shared_ptr<Object> ToSharedObject();
   std::vector<Value>& ToArray();
   std::shared_ptr<ArrayBuffer> ToArrayBuffer();
 
@@ -151,12 +162,27 @@ private:
 
   Type type_ = kUndefined;
   double num_value_ = 0;
-  std::string str_value_;
+  // str_value_ is shared so that copying a string value doesn't copy the string. The common strings like "length" are
+  // shared from a static table without allocations.
+  std::shared_ptr<const std::string> str_value_;
   std::shared_ptr<Object> object_value_;
   std::shared_ptr<std::vector<Value>> array_value_;
 };
 
-class Object {
+// ToValidUTF8 returns the bytes with each invalid UTF-8 sequence replaced with U+FFFD, in the same way as TextDecoder.
+// If valid is not nullptr, *valid reports whether the bytes were valid UTF-8.
+{{.Export}}std::string ToValidUTF8(const uint8_t* data, size_t size, bool* valid = nullptr);
+{{.Export}}std::string ToValidUTF8(const std::string& str, bool* valid = nullptr);
+
+// NumberToString formats a number in the same way as JavaScript's String(num), e.g., "1", "0.5" and "NaN".
+{{.Export}}std::string NumberToString(double num);
+
+// FormatConsoleMessage formats the arguments of console methods like console.log. The arguments are joined with
+// spaces. If the first argument is a string, substitutions like %s, %d, %f and %o in it are replaced with the
+// following arguments.
+{{.Export}}std::string FormatConsoleMessage(const std::vector<Value>& args);
+
+class {{.Export}}Object {
 public:
   using Func = std::function<Value (Value, std::vector<Value>)>;
 
@@ -177,7 +203,7 @@ public:
   virtual std::string Inspect() const;
 };
 
-class ArrayBuffer : public Object {
+class {{.Export}}ArrayBuffer : public Object {
 public:
   explicit ArrayBuffer(size_t size);
 
@@ -186,29 +212,62 @@ public:
   bool IsBytes() const override;
   BytesSpan ToBytes() override;
   std::string ToString() const override;
+  std::string Inspect() const override;
 
 private:
   std::vector<uint8_t> data_;
 };
 
-class TypedArray : public Object {
+class {{.Export}}TypedArray : public Object {
 public:
+  // Kind is the type of the elements like Int32Array's and Float64Array's.
+  enum class Kind {
+    kInt8,
+    kUint8,
+    kUint8Clamped,
+    kInt16,
+    kUint16,
+    kInt32,
+    kUint32,
+    kFloat32,
+    kFloat64,
+  };
+
+  static size_t ElementSize(Kind kind);
+  static std::string KindName(Kind kind);
+
+  // These constructors make a Uint8Array. size, offset and length are in bytes.
   explicit TypedArray(size_t size);
   TypedArray(std::shared_ptr<ArrayBuffer> arrayBuffer, size_t offset, size_t length);
 
+  // length is the number of the elements, and byte_offset is in bytes.
+  TypedArray(Kind kind, size_t length);
+  TypedArray(Kind kind, std::shared_ptr<ArrayBuffer> arrayBuffer, size_t byte_offset, size_t length);
+
+  Kind GetKind() const;
+
+  // Length returns the number of the elements.
+  size_t Length() const;
+  double GetElement(size_t index);
+  void SetElement(size_t index, double value);
+
   Value Get(const std::string& key) override;
   void Set(const std::string& key, Value value) override;
   bool IsBytes() const override;
   BytesSpan ToBytes() override;
   std::string ToString() const override;
+  std::string Inspect() const override;
 
 private:
+  Kind kind_ = Kind::kUint8;
   std::shared_ptr<ArrayBuffer> array_buffer_;
   size_t offset_ = 0;
+
+  // length_ is in bytes.
   size_t length_ = 0;
 };
 
-class Uint8Array : public TypedArray {
+class {{.Export}}Uint8Array : public TypedArray {
 public:
   explicit Uint8Array(size_t size);
   Uint8Array(std::shared_ptr<ArrayBuffer> arrayBuffer, size_t offset, size_t length);
@@ -216,15 +275,36 @@ public:
   std::string ToString() const override;
 };
 
-class Float32Array : public TypedArray {
+class {{.Export}}Float32Array : public TypedArray {
 public:
+  // size, offset and length are the numbers of the elements.
   explicit Float32Array(size_t size);
   Float32Array(std::shared_ptr<ArrayBuffer> arrayBuffer, size_t offset, size_t length);
 
   std::string ToString() const override;
 };
 
-class DictionaryValues : public Object {
+// DataView is a view to read and write numbers of any types at any byte offsets in an ArrayBuffer.
+class {{.Export}}DataView : public Object {
+public:
+  DataView(std::shared_ptr<ArrayBuffer> arrayBuffer, size_t byte_offset, size_t byte_length);
+
+  Value Get(const std::string& key) override;
+  bool IsBytes() const override;
+  BytesSpan ToBytes() override;
+  std::string ToString() const override;
+  std::string Inspect() const override;
+
+private:
+  Value Load(TypedArray::Kind kind, const std::vector<Value>& args);
+  void Store(TypedArray::Kind kind, const std::vector<Value>& args);
+
+  std::shared_ptr<ArrayBuffer> array_buffer_;
+  size_t offset_ = 0;
+  size_t length_ = 0;
+};
+
+class {{.Export}}DictionaryValues : public Object {
 public:
   DictionaryValues();
   explicit DictionaryValues(const std::map<std::string, Value>& dict);
@@ -235,11 +315,81 @@ public:
   std::string ToString() const override;
   std::string Inspect() const override;
 
+  // Keys returns the keys in the lexicographical order.
+  std::vector<std::string> Keys() const;
+
+  // Clear removes all the properties.
+  void Clear();
+
 private:
   std::map<std::string, Value> dict_;
 };
 
-class Function : public Object {
+// ValueArena reuses the dictionaries and the arrays for the short-lived values, which are otherwise created and
+// finalized for every frame. A reused object keeps its ID for Go, so Go doesn't have to register and finalize it again.
+//
+// The values are reused after Reset with the same objects, so a value that Go keeps after Reset would show the contents
+// of the next value. Use the arena only for the values that are never kept beyond Reset, and not for the objects that
+// Go might keep like events. ValueArena is not concurrent-safe. Use this in the thread running Go.
+class {{.Export}}ValueArena {
+public:
+  // NewDictionary returns a DictionaryValues without properties.
+  Value NewDictionary();
+
+  // NewArray returns an array of n undefined values. The capacity of the array is kept across Reset.
+  Value NewArray(size_t n);
+
+  // Reset makes all the values available again.
+  void Reset();
+
+private:
+  std::vector<Value> dictionaries_;
+  size_t dictionary_index_ = 0;
+  std::vector<Value> arrays_;
+  size_t array_index_ = 0;
+};
+
+// EventTarget is a mixin for objects with addEventListener, removeEventListener and dispatchEvent.
+// A class inheriting EventTarget should return GetEventTargetMethod(key) from its Get for these keys.
+class {{.Export}}EventTarget {
+public:
+  virtual ~EventTarget();
+
+  // AddEventListener adds a listener for type unless the same listener is already added.
+  // If once is true, the listener is removed before it is called first.
+  void AddEventListener(const std::string& type, Value listener, bool once = false);
+  void RemoveEventListener(const std::string& type, Value listener);
+
+  // DispatchEvent calls the listeners for type with event in the order they were added. DispatchEvent must be called
+  // on the thread running Go, e.g., in a task enqueued by Go::EnqueueTask.
+  void DispatchEvent(const std::string& type, Value event);
+
+  void ClearEventListeners();
+
+protected:
+  // GetEventTargetMethod returns a function for "addEventListener", "removeEventListener" or "dispatchEvent", or
+  // undefined for the other keys.
+  Value GetEventTargetMethod(const std::string& key);
+
+private:
+  struct Listener {
+    Value func;
+    bool once;
+  };
+
+  std::map<std::string, std::vector<Listener>> listeners_;
+};
+
+// EventTargetDictionaryValues is a DictionaryValues that is also an EventTarget, like the global object.
+class {{.Export}}EventTargetDictionaryValues : public DictionaryValues, public EventTarget {
+public:
+  EventTargetDictionaryValues();
+  explicit EventTargetDictionaryValues(const std::map<std::string, Value>& dict);
+
+  Value Get(const std::string& key) override;
+};
+
+class {{.Export}}Function : public Object {
 public:
   explicit Function(Object::Func fn);
   Function(Object::Func fn, Value self);
@@ -249,13 +399,14 @@ public:
   bool IsConstructor() const override { return false; }
   Value Invoke(Value self, std::vector<Value> args) override;
   std::string ToString() const override { return "(function)"; }
+  std::string Inspect() const override { return "[Function]"; }
 
 private:
   Object::Func fn_;
   Value self_;
 };
 
-class Constructor : public Object {
+class {{.Export}}Constructor : public Object {
 public:
   Constructor(const std::string& name, Object::Func fn);
 
@@ -263,12 +414,95 @@ public:
   bool IsConstructor() const override { return true; }
   Value New(std::vector<Value> args) override;
   std::string ToString() const override;
+  std::string Inspect() const override;
 
 private:
   std::string name_;
   Object::Func fn_;
 };
 
+// Error is a JavaScript error object like Error and TypeError.
+class {{.Export}}Error : public Object {
+public:
+  explicit Error(const std::string& message);
+  Error(const std::string& name, const std::string& message);
+
+  Value Get(const std::string& key) override;
+  void Set(const std::string& key, Value value) override;
+  std::string ToString() const override;
+  std::string Inspect() const override;
+
+private:
+  std::string name_;
+  std::string message_;
+  std::map<std::string, Value> props_;
+};
+
+// Exception is thrown by the host functions like a JavaScript exception. When a function called by Go via valueCall,
+// valueInvoke or valueNew throws an Exception, Go receives its value as a js.Error. A std::exception is also converted
+// to an Error with what().
+class {{.Export}}Exception : public std::exception {
+public:
+  explicit Exception(Value value);
+  Exception(const std::string& name, const std::string& message);
+
+  const Value& GetValue() const;
+  const char* what() const noexcept override;
+
+private:
+  Value value_;
+  std::string what_;
+};
+
+// Promise is a JavaScript Promise. The reactions registered by then, catch and finally are run asynchronously with the
+// scheduler of the thread, which Go sets to its task queue while running.
+//
+// Promise is not concurrent-safe. Settle a promise on the thread running Go, e.g., in a task by Go::EnqueueTask.
+class {{.Export}}Promise : public Object, public std::enable_shared_from_this<Promise> {
+public:
+  using Scheduler = std::function<void(std::function<void()>)>;
+
+  // SetScheduler sets the function to run the reactions settled on the current thread, and returns the previous one.
+  // Each thread has its own scheduler, so Go instances on different threads don't share it. If scheduler is null, the
+  // reactions are run synchronously.
+  static Scheduler SetScheduler(Scheduler scheduler);
+
+  // Resolve fulfills the promise with value. If value is a Promise, this promise follows it.
+  void Resolve(Value value);
+  void Reject(Value reason);
+
+  Value Then(Value on_fulfilled, Value on_rejected);
+
+  Value Get(const std::string& key) override;
+  std::string ToString() const override;
+  std::string Inspect() const override;
+
+private:
+  enum class State {
+    kPending,
+    kFulfilled,
+    kRejected,
+  };
+
+  struct Reaction {
+    Value on_fulfilled;
+    Value on_rejected;
+    std::shared_ptr<Promise> result;
+  };
+
+  void Settle(State state, Value value);
+  void ScheduleReactions();
+  void RunReaction(const Reaction& reaction);
+
+  State state_ = State::kPending;
+  Value value_;
+
+  // locked_ is true when the promise follows another promise.
+  bool locked_ = false;
+
+  std::vector<Reaction> reactions_;
+};
+
 }
 
 #endif  // {{.IncludeGuard}}
@@ -278,14 +512,19 @@ var jsCppTmpl = template.Must(template.New("js.cpp").Parse(`// Code generated by
 
 #include "{{.IncludePath}}js.h"
 
+#include "{{.IncludePath}}assets.h"
+#include "{{.IncludePath}}log.h"
+
 #include <algorithm>
 #include <cassert>
+#include <cctype>
+#include <cmath>
 #include <cstring>
 #include <cstdlib>
 #include <ctime>
 #include <fcntl.h>
 #include <iomanip>
-#include <random>
+#include <limits>
 #include <sstream>
 #include <sys/stat.h>
 #include <tuple>
@@ -296,8 +535,82 @@ namespace {{.Namespace}} {
 
 namespace {
 
+Value FunctionArg(const std::vector<Value>& args, size_t i) {
+  if (i < args.size()) {
+    return args[i];
+  }
+  return Value{};
+}
+
+// SharedString returns a shared string for str. The common strings like property names used by syscall/js are
+// returned from a table without allocations.
+std::shared_ptr<const std::string> SharedString(const std::string& str) {
+  // The table is leaked so that values can be destroyed at exit.
+  static const std::vector<std::shared_ptr<const std::string>>& common = *[]() {
+    const char* strs[] = {
+      "",
+      "args",
+      "buffer",
+      "byteLength",
+      "byteOffset",
+      "code",
+      "id",
+      "length",
+      "message",
+      "name",
+      "result",
+      "this",
+      "type",
+      "value",
+    };
+    auto v = new std::vector<std::shared_ptr<const std::string>>();
+    for (const char* str : strs) {
+      v->push_back(std::make_shared<const std::string>(str));
+    }
+    return v;
+  }();
+
+  if (str.size() <= 10) {
+    for (const auto& c : common) {
+      if (*c == str) {
+        return c;
+      }
+    }
+  }
+  return std::make_shared<const std::string>(str);
+}
+
+// InspectScope limits the depth of the nested values in Inspect, e.g., against reference cycles.
+class InspectScope {
+public:
+  InspectScope() {
+    depth_++;
+  }
+
+  ~InspectScope() {
+    depth_--;
+  }
+
+  bool IsTooDeep() const {
+    return depth_ > 4;
+  }
+
+private:
+  static thread_local int depth_;
+};
+
+thread_local int InspectScope::depth_ = 0;
+
+// InspectElement returns the description of a value in an array or an object. A string is quoted.
+std::string InspectElement(const Value& value) {
+  if (value.IsString()) {
+    return "'" + value.ToString() + "'";
+  }
+  return value.Inspect();
+}
+
 void Panic(const std::string& msg) {
-  std::cerr << msg << std::endl;
+  Log(LogLevel::kError, msg);
   __builtin_unreachable();
 }
 
@@ -312,17 +625,6 @@ std::string JoinObjects(const std::vector<Value>& objs) {
   return str;
 }
 
-void WriteObjects(std::ostream& out, const std::vector<Value>& objs) {
-  std::vector<std::string> inspects(objs.size());
-  for (int i = 0; i < objs.size(); i++) {
-    out << objs[i].Inspect();
-    if (i < objs.size() - 1) {
-      out << ", ";
-    }
-  }
-  out << std::endl;
-}
-
 const char* ToErrorCodeName(int errno_) {
   switch(errno_) {
 #ifdef E2BIG
@@ -1006,6 +1308,10 @@ public:
     return std::strerror(errno_);
   }
 
+  std::string Inspect() const override {
+    return std::string{"Errno { code: '"} + ToErrorCodeName(errno_) + "', message: '" + ToString() + "' }";
+  }
+
 private:
   const int errno_;
 };
@@ -1063,9 +1369,9 @@ public:
           Value callback = args[5];
           size_t n;
           if (position.IsNumber()) {
-            n = pwrite(fd, buf.begin() + offset, length, static_cast<off_t>(position.ToNumber()));
+            n = pwrite(fd, buf.data() + offset, length, static_cast<off_t>(position.ToNumber()));
           } else {
-            n = write(fd, buf.begin() + offset, length);
+            n = write(fd, buf.data() + offset, length);
           }
           Value errval = Value::Null();
           if (n == -1) {
@@ -1174,9 +1480,9 @@ public:
           Value callback = args[5];
           size_t n;
           if (position.IsNumber()) {
-            n = pread(fd, buf.begin() + offset, length, static_cast<off_t>(position.ToNumber()));
+            n = pread(fd, buf.data() + offset, length, static_cast<off_t>(position.ToNumber()));
           } else {
-            n = read(fd, buf.begin() + offset, length);
+            n = read(fd, buf.data() + offset, length);
           }
           Value errval = Value::Null();
           if (n == -1) {
@@ -1378,113 +1684,1252 @@ public:
   }
 };
 
-class Date : public Object {
+// UTF16Length returns the length of the UTF-8 string str in UTF-16 code units.
+size_t UTF16Length(const std::string& str) {
+  size_t n = 0;
+  for (unsigned char c : str) {
+    if ((c & 0xc0) == 0x80) {
+      continue;
+    }
+    // A 4-byte sequence is a surrogate pair in UTF-16.
+    n += c >= 0xf0 ? 2 : 1;
+  }
+  return n;
+}
+
+class TextEncoder : public Object {
 public:
   Value Get(const std::string& key) override {
-    if (key == "getTimezoneOffset") {
+    if (key == "encoding") {
+      return Value{"utf-8"};
+    }
+    if (key == "encode") {
       return Value{std::make_shared<Function>(
         [](Value self, std::vector<Value> args) -> Value {
-          std::time_t time = std::time(nullptr);
-          std::tm tm = *std::localtime(&time);
-          std::ostringstream os;
-          os << std::put_time(&tm, "%z");
-          std::string str = os.str();
-          int h = std::stoi(str.substr(0, 3), nullptr, 10);
-          int m = std::stoi(str[0]+str.substr(3), nullptr, 10);
-          return Value{static_cast<double>(h*60 + m) * -1};
+          std::string str;
+          Value v = FunctionArg(args, 0);
+          if (!v.IsUndefined()) {
+            str = ToValidUTF8(v.ToString());
+          }
+          auto u8 = std::make_shared<Uint8Array>(str.size());
+          std::copy(str.begin(), str.end(), u8->ToBytes().begin());
+          return Value{u8};
+        })};
+    }
+    if (key == "encodeInto") {
+      return Value{std::make_shared<Function>(
+        [](Value self, std::vector<Value> args) -> Value {
+          std::string str = ToValidUTF8(FunctionArg(args, 0).ToString());
+          BytesSpan dst = FunctionArg(args, 1).ToBytes();
+
+          // Write only whole characters.
+          size_t written = 0;
+          while (written < str.size()) {
+            size_t n = 1;
+            while (written + n < str.size() && (static_cast<unsigned char>(str[written + n]) & 0xc0) == 0x80) {
+              n++;
+            }
+            if (written + n > dst.size()) {
+              break;
+            }
+            written += n;
+          }
+          std::copy(str.begin(), str.begin() + written, dst.begin());
+          size_t read = UTF16Length(str.substr(0, written));
+          return Value{std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+            {"read", Value{static_cast<double>(read)}},
+            {"written", Value{static_cast<double>(written)}},
+          })};
         })};
     }
-    Panic(key + " on Date is not implemented");
     return Value{};
   }
 
   std::string ToString() const override {
-    return "Date";
+    return "TextEncoder";
   }
 };
 
-}  // namespace
-
-Writer::~Writer() = default;
-
-StreamWriter::StreamWriter(std::ostream& out)
-    : out_{out} {
-}
+// TextDecoder is a TextDecoder only for UTF-8. The stream option is not supported.
+class TextDecoder : public Object {
+public:
+  TextDecoder(bool fatal, bool ignore_bom)
+      : fatal_{fatal},
+        ignore_bom_{ignore_bom} {
+  }
 
-void StreamWriter::Write(const std::vector<uint8_t>& bytes) {
-  buf_.insert(buf_.end(), bytes.begin(), bytes.end());
-  for (;;) {
-    auto it = std::find(buf_.begin(), buf_.end(), '\n');
-    if (it == buf_.end()) {
-      break;
+  Value Get(const std::string& key) override {
+    if (key == "encoding") {
+      return Value{"utf-8"};
     }
-    std::string str(buf_.begin(), it);
-    out_ << str << std::endl;
-    ++it;
-    buf_.erase(buf_.begin(), it);
+    if (key == "fatal") {
+      return Value{fatal_};
+    }
+    if (key == "ignoreBOM") {
+      return Value{ignore_bom_};
+    }
+    if (key == "decode") {
+      return Value{std::make_shared<Function>(
+        [](Value self, std::vector<Value> args) -> Value {
+          TextDecoder* d = self.IsObject() ? dynamic_cast<TextDecoder*>(&self.ToObject()) : nullptr;
+          if (!d) {
+            throw Exception{"TypeError", "TextDecoder.prototype.decode called on " + self.Inspect()};
+          }
+          Value input = FunctionArg(args, 0);
+          if (input.IsUndefined()) {
+            return Value{""};
+          }
+          if (!input.IsBytes()) {
+            throw Exception{"TypeError", "The provided value is not of type '(ArrayBuffer or ArrayBufferView)'"};
+          }
+          BytesSpan src = input.ToBytes();
+          const uint8_t* data = src.data();
+          size_t size = src.size();
+          if (!d->ignore_bom_ && size >= 3 && data[0] == 0xef && data[1] == 0xbb && data[2] == 0xbf) {
+            data += 3;
+            size -= 3;
+          }
+          bool valid = true;
+          std::string str = ToValidUTF8(data, size, &valid);
+          if (!valid && d->fatal_) {
+            throw Exception{"TypeError", "The encoded data was not valid for encoding utf-8"};
+          }
+          return Value{str};
+        })};
+    }
+    return Value{};
   }
-}
 
-std::size_t Value::Hash::operator()(const Value& value) const {
-  size_t h = 17;
-  h = h * 31 + std::hash<decltype(value.type_)>()(value.type_);
-  h = h * 31 + std::hash<decltype(value.num_value_)>()(value.num_value_);
-  h = h * 31 + std::hash<decltype(value.str_value_)>()(value.str_value_);
-  h = h * 31 + std::hash<decltype(value.object_value_)>()(value.object_value_);
-  h = h * 31 + std::hash<decltype(value.array_value_)>()(value.array_value_);
-  return h;
-}
+  std::string ToString() const override {
+    return "TextDecoder";
+  }
 
-Value Value::Null() {
-  return Value{kNull};
-}
+private:
+  bool fatal_ = false;
+  bool ignore_bom_ = false;
+};
 
-Value::Value() = default;
+// JSONParser parses a JSON text into values. An object is parsed as a DictionaryValues.
+class JSONParser {
+public:
+  explicit JSONParser(const std::string& text)
+      : text_{text} {
+  }
 
-Value::Value(bool b)
-    : type_{kBool},
-      num_value_{static_cast<double>(b)}{
-}
+  Value Parse() {
+    Value v = ParseValue();
+    SkipSpaces();
+    if (pos_ < text_.size()) {
+      Fail();
+    }
+    return v;
+  }
 
-Value::Value(double num)
-    : type_{kNumber},
-      num_value_{num} {
-}
+private:
+  [[noreturn]] void Fail() {
+    if (pos_ >= text_.size()) {
+      throw Exception{"SyntaxError", "Unexpected end of JSON input"};
+    }
+    throw Exception{"SyntaxError", std::string("Unexpected token ") + text_[pos_] + " in JSON at position " + std::to_string(pos_)};
+  }
 
-Value::Value(const char* str)
-    : Value{std::string(str)} {
-}
+  void SkipSpaces() {
+    while (pos_ < text_.size()) {
+      char c = text_[pos_];
+      if (c != ' ' && c != '\t' && c != '\n' && c != '\r') {
+        break;
+      }
+      pos_++;
+    }
+  }
 
-Value::Value(const std::string& str)
-    : type_{kString},
-      str_value_{str} {
-}
+  void Expect(char c) {
+    if (pos_ >= text_.size() || text_[pos_] != c) {
+      Fail();
+    }
+    pos_++;
+  }
 
-Value::Value(std::shared_ptr<Object> object)
-    : type_{kObject},
-      object_value_{object} {
-}
+  void ExpectWord(const char* word) {
+    for (const char* p = word; *p; p++) {
+      Expect(*p);
+    }
+  }
 
-Value::Value(const std::vector<Value>& array)
-    : type_{kObject},
-      array_value_{std::make_shared<std::vector<Value>>(array.begin(), array.end())} {
-}
+  Value ParseValue() {
+    SkipSpaces();
+    if (pos_ >= text_.size()) {
+      Fail();
+    }
+    switch (text_[pos_]) {
+    case '{':
+      return ParseObject();
+    case '[':
+      return ParseArray();
+    case '"':
+      return Value{ParseString()};
+    case 't':
+      ExpectWord("true");
+      return Value{true};
+    case 'f':
+      ExpectWord("false");
+      return Value{false};
+    case 'n':
+      ExpectWord("null");
+      return Value::Null();
+    default:
+      return ParseNumber();
+    }
+  }
 
-Value::Value(const Value& rhs) = default;
+  Value ParseObject() {
+    Expect('{');
+    auto dict = std::make_shared<DictionaryValues>();
+    SkipSpaces();
+    if (pos_ < text_.size() && text_[pos_] == '}') {
+      pos_++;
+      return Value{dict};
+    }
+    for (;;) {
+      SkipSpaces();
+      if (pos_ >= text_.size() || text_[pos_] != '"') {
+        Fail();
+      }
+      std::string key = ParseString();
+      SkipSpaces();
+      Expect(':');
+      dict->Set(key, ParseValue());
+      SkipSpaces();
+      if (pos_ < text_.size() && text_[pos_] == ',') {
+        pos_++;
+        continue;
+      }
+      Expect('}');
+      return Value{dict};
+    }
+  }
 
-Value& Value::operator=(const Value& rhs) = default;
+  Value ParseArray() {
+    Expect('[');
+    std::vector<Value> arr;
+    SkipSpaces();
+    if (pos_ < text_.size() && text_[pos_] == ']') {
+      pos_++;
+      return Value{arr};
+    }
+    for (;;) {
+      arr.push_back(ParseValue());
+      SkipSpaces();
+      if (pos_ < text_.size() && text_[pos_] == ',') {
+        pos_++;
+        continue;
+      }
+      Expect(']');
+      return Value{arr};
+    }
+  }
 
-bool Value::operator==(const Value& rhs) const {
-  return type_ == rhs.type_ &&
-      num_value_ == rhs.num_value_ &&
-      str_value_ == rhs.str_value_ &&
-      object_value_ == rhs.object_value_ &&
-      array_value_ == rhs.array_value_;
-}
+  uint32_t ParseHex4() {
+    uint32_t v = 0;
+    for (int i = 0; i < 4; i++) {
+      if (pos_ >= text_.size() || !std::isxdigit(static_cast<unsigned char>(text_[pos_]))) {
+        Fail();
+      }
+      char c = text_[pos_++];
+      v = v * 16 + (std::isdigit(static_cast<unsigned char>(c)) ? c - '0' : std::tolower(c) - 'a' + 10);
+    }
+    return v;
+  }
 
-Value::Value(Type type)
-    : type_{type} {
-}
+  static void AppendUTF8(std::string* str, uint32_t c) {
+    if (c < 0x80) {
+      str->push_back(static_cast<char>(c));
+    } else if (c < 0x800) {
+      str->push_back(static_cast<char>(0xc0 | (c >> 6)));
+      str->push_back(static_cast<char>(0x80 | (c & 0x3f)));
+    } else if (c < 0x10000) {
+      str->push_back(static_cast<char>(0xe0 | (c >> 12)));
+      str->push_back(static_cast<char>(0x80 | ((c >> 6) & 0x3f)));
+      str->push_back(static_cast<char>(0x80 | (c & 0x3f)));
+    } else {
+      str->push_back(static_cast<char>(0xf0 | (c >> 18)));
+      str->push_back(static_cast<char>(0x80 | ((c >> 12) & 0x3f)));
+      str->push_back(static_cast<char>(0x80 | ((c >> 6) & 0x3f)));
+      str->push_back(static_cast<char>(0x80 | (c & 0x3f)));
+    }
+  }
+
+  std::string ParseString() {
+    Expect('"');
+    std::string str;
+    for (;;) {
+      if (pos_ >= text_.size()) {
+        Fail();
+      }
+      char c = text_[pos_];
+      if (c == '"') {
+        pos_++;
+        return str;
+      }
+      if (static_cast<unsigned char>(c) < 0x20) {
+        Fail();
+      }
+      if (c != '\\') {
+        str.push_back(c);
+        pos_++;
+        continue;
+      }
+      pos_++;
+      if (pos_ >= text_.size()) {
+        Fail();
+      }
+      c = text_[pos_++];
+      switch (c) {
+      case '"':
+      case '\\':
+      case '/':
+        str.push_back(c);
+        break;
+      case 'b':
+        str.push_back('\b');
+        break;
+      case 'f':
+        str.push_back('\f');
+        break;
+      case 'n':
+        str.push_back('\n');
+        break;
+      case 'r':
+        str.push_back('\r');
+        break;
+      case 't':
+        str.push_back('\t');
+        break;
+      case 'u': {
+        uint32_t u = ParseHex4();
+        if (0xd800 <= u && u < 0xdc00 && text_.compare(pos_, 2, "\\u") == 0) {
+          size_t pos = pos_;
+          pos_ += 2;
+          uint32_t l = ParseHex4();
+          if (0xdc00 <= l && l < 0xe000) {
+            u = 0x10000 + ((u - 0xd800) << 10) + (l - 0xdc00);
+          } else {
+            pos_ = pos;
+          }
+        }
+        // A lone surrogate cannot be represented in UTF-8.
+        if (0xd800 <= u && u < 0xe000) {
+          u = 0xfffd;
+        }
+        AppendUTF8(&str, u);
+        break;
+      }
+      default:
+        pos_--;
+        Fail();
+      }
+    }
+  }
+
+  Value ParseNumber() {
+    size_t start = pos_;
+    if (pos_ < text_.size() && text_[pos_] == '-') {
+      pos_++;
+    }
+    if (pos_ >= text_.size() || !std::isdigit(static_cast<unsigned char>(text_[pos_]))) {
+      Fail();
+    }
+    if (text_[pos_] == '0') {
+      pos_++;
+    } else {
+      SkipDigits();
+    }
+    if (pos_ < text_.size() && text_[pos_] == '.') {
+      pos_++;
+      if (pos_ >= text_.size() || !std::isdigit(static_cast<unsigned char>(text_[pos_]))) {
+        Fail();
+      }
+      SkipDigits();
+    }
+    if (pos_ < text_.size() && (text_[pos_] == 'e' || text_[pos_] == 'E')) {
+      pos_++;
+      if (pos_ < text_.size() && (text_[pos_] == '+' || text_[pos_] == '-')) {
+        pos_++;
+      }
+      if (pos_ >= text_.size() || !std::isdigit(static_cast<unsigned char>(text_[pos_]))) {
+        Fail();
+      }
+      SkipDigits();
+    }
+    std::istringstream ss{text_.substr(start, pos_ - start)};
+    ss.imbue(std::locale::classic());
+    double v = 0;
+    ss >> v;
+    if (ss.fail()) {
+      // The syntax is already checked, so the number is out of range: an infinity or zero like JavaScript.
+      bool negative = text_[start] == '-';
+      double r = IsOverflow(start) ? std::numeric_limits<double>::infinity() : 0.0;
+      return Value{negative ? -r : r};
+    }
+    return Value{v};
+  }
+
+  // IsOverflow reports whether the absolute value of the valid number from start to pos_ is 1 or more, i.e., the
+  // number overflows rather than underflows when it is out of range.
+  bool IsOverflow(size_t start) const {
+    size_t i = start;
+    if (text_[i] == '-') {
+      i++;
+    }
+    // The exponent of the first non-zero digit, e.g., 0 for "1.5" and -2 for "0.012".
+    long digits_exp = -1;
+    bool found = false;
+    for (; i < pos_ && std::isdigit(static_cast<unsigned char>(text_[i])); i++) {
+      if (text_[i] != '0') {
+        found = true;
+      }
+      if (found) {
+        digits_exp++;
+      }
+    }
+    if (i < pos_ && text_[i] == '.') {
+      i++;
+      for (long k = 1; i < pos_ && std::isdigit(static_cast<unsigned char>(text_[i])); i++, k++) {
+        if (!found && text_[i] != '0') {
+          digits_exp = -k;
+          found = true;
+        }
+      }
+    }
+    if (!found) {
+      return false;
+    }
+    long exp = 0;
+    if (i < pos_ && (text_[i] == 'e' || text_[i] == 'E')) {
+      i++;
+      bool negative = false;
+      if (text_[i] == '+' || text_[i] == '-') {
+        negative = text_[i] == '-';
+        i++;
+      }
+      // Clamp the exponent, which is far out of the range of double anyway.
+      for (; i < pos_; i++) {
+        exp = std::min(exp * 10 + (text_[i] - '0'), 100000L);
+      }
+      if (negative) {
+        exp = -exp;
+      }
+    }
+    return digits_exp + exp >= 0;
+  }
+
+  void SkipDigits() {
+    while (pos_ < text_.size() && std::isdigit(static_cast<unsigned char>(text_[pos_]))) {
+      pos_++;
+    }
+  }
+
+  const std::string& text_;
+  size_t pos_ = 0;
+};
+
+// JSONStringifier converts a value into a JSON text. The keys of a DictionaryValues are in the lexicographical order
+// unlike JavaScript's insertion order.
+class JSONStringifier {
+public:
+  explicit JSONStringifier(const std::string& indent)
+      : indent_{indent} {
+  }
+
+  // Stringify returns false when value is not serializable like undefined and functions.
+  bool Stringify(const Value& value, std::string* out) {
+    return Write(value, "", out);
+  }
+
+  static std::string Quote(const std::string& str) {
+    static const char kHex[] = "0123456789abcdef";
+    std::string result = "\"";
+    for (unsigned char c : str) {
+      switch (c) {
+      case '"':
+        result += "\\\"";
+        break;
+      case '\\':
+        result += "\\\\";
+        break;
+      case '\b':
+        result += "\\b";
+        break;
+      case '\f':
+        result += "\\f";
+        break;
+      case '\n':
+        result += "\\n";
+        break;
+      case '\r':
+        result += "\\r";
+        break;
+      case '\t':
+        result += "\\t";
+        break;
+      default:
+        if (c < 0x20) {
+          result += "\\u00";
+          result.push_back(kHex[c >> 4]);
+          result.push_back(kHex[c & 0xf]);
+        } else {
+          result.push_back(static_cast<char>(c));
+        }
+        break;
+      }
+    }
+    result += "\"";
+    return result;
+  }
+
+  static std::string Number(double v) {
+    if (!std::isfinite(v)) {
+      return "null";
+    }
+    return NumberToString(v);
+  }
+
+private:
+  bool Write(Value value, const std::string& indent, std::string* out) {
+    if (value.IsNull()) {
+      *out += "null";
+      return true;
+    }
+    if (value.IsBool()) {
+      *out += value.ToBool() ? "true" : "false";
+      return true;
+    }
+    if (value.IsNumber()) {
+      *out += Number(value.ToNumber());
+      return true;
+    }
+    if (value.IsString()) {
+      *out += Quote(value.ToString());
+      return true;
+    }
+    if (value.IsArray()) {
+      const void* key = &value.ToArray();
+      Enter(key);
+      std::vector<std::string> items;
+      for (const Value& v : value.ToArray()) {
+        std::string item;
+        if (!Write(v, indent + indent_, &item)) {
+          item = "null";
+        }
+        items.push_back(item);
+      }
+      Leave();
+      WriteList('[', ']', items, indent, out);
+      return true;
+    }
+    if (value.IsObject()) {
+      Object& obj = value.ToObject();
+      if (obj.IsFunction()) {
+        return false;
+      }
+      Enter(&obj);
+      std::vector<std::string> items;
+      std::string sep = indent_.empty() ? ":" : ": ";
+      if (DictionaryValues* dict = dynamic_cast<DictionaryValues*>(&obj)) {
+        for (const std::string& k : dict->Keys()) {
+          std::string item;
+          if (Write(dict->Get(k), indent + indent_, &item)) {
+            items.push_back(Quote(k) + sep + item);
+          }
+        }
+      } else if (TypedArray* t = dynamic_cast<TypedArray*>(&obj)) {
+        for (size_t i = 0; i < t->Length(); i++) {
+          items.push_back(Quote(std::to_string(i)) + sep + Number(t->GetElement(i)));
+        }
+      }
+      Leave();
+      WriteList('{', '}', items, indent, out);
+      return true;
+    }
+    return false;
+  }
+
+  void WriteList(char open, char close, const std::vector<std::string>& items, const std::string& indent, std::string* out) {
+    out->push_back(open);
+    for (size_t i = 0; i < items.size(); i++) {
+      if (i > 0) {
+        out->push_back(',');
+      }
+      if (!indent_.empty()) {
+        *out += "\n" + indent + indent_;
+      }
+      *out += items[i];
+    }
+    if (!indent_.empty() && !items.empty()) {
+      *out += "\n" + indent;
+    }
+    out->push_back(close);
+  }
+
+  void Enter(const void* key) {
+    if (std::find(stack_.begin(), stack_.end(), key) != stack_.end()) {
+      throw Exception{"TypeError", "Converting circular structure to JSON"};
+    }
+    stack_.push_back(key);
+  }
+
+  void Leave() {
+    stack_.pop_back();
+  }
+
+  std::string indent_;
+  std::vector<const void*> stack_;
+};
+
+class JSON : public Object {
+public:
+  Value Get(const std::string& key) override {
+    if (key == "parse") {
+      return Value{std::make_shared<Function>(
+        [](Value self, std::vector<Value> args) -> Value {
+          Value text = FunctionArg(args, 0);
+          // A reviver that is not a function is ignored.
+          if (FunctionArg(args, 1).IsFunction()) {
+            throw Exception{"TypeError", "JSON.parse with a reviver is not implemented"};
+          }
+          std::string str = text.IsString() ? text.ToString() : text.Inspect();
+          return JSONParser{str}.Parse();
+        })};
+    }
+    if (key == "stringify") {
+      return Value{std::make_shared<Function>(
+        [](Value self, std::vector<Value> args) -> Value {
+          Value replacer = FunctionArg(args, 1);
+          // A replacer that is neither a function nor an array is ignored.
+          if (replacer.IsFunction() || replacer.IsArray()) {
+            throw Exception{"TypeError", "JSON.stringify with a replacer is not implemented"};
+          }
+          std::string indent;
+          Value space = FunctionArg(args, 2);
+          if (space.IsNumber()) {
+            // NaN is 0 like ToIntegerOrInfinity.
+            double n = std::isnan(space.ToNumber()) ? 0 : std::min(10.0, std::trunc(space.ToNumber()));
+            if (n > 0) {
+              indent = std::string(static_cast<size_t>(n), ' ');
+            }
+          } else if (space.IsString()) {
+            indent = space.ToString().substr(0, 10);
+          }
+          std::string out;
+          if (!JSONStringifier{indent}.Stringify(FunctionArg(args, 0), &out)) {
+            return Value{};
+          }
+          return Value{out};
+        })};
+    }
+    return Value{};
+  }
+
+  std::string ToString() const override {
+    return "JSON";
+  }
+};
+
+class Date : public Object {
+public:
+  Value Get(const std::string& key) override {
+    if (key == "getTimezoneOffset") {
+      return Value{std::make_shared<Function>(
+        [](Value self, std::vector<Value> args) -> Value {
+          std::time_t time = std::time(nullptr);
+          std::tm tm = *std::localtime(&time);
+          std::ostringstream os;
+          os << std::put_time(&tm, "%z");
+          std::string str = os.str();
+          int h = std::stoi(str.substr(0, 3), nullptr, 10);
+          int m = std::stoi(str[0]+str.substr(3), nullptr, 10);
+          return Value{static_cast<double>(h*60 + m) * -1};
+        })};
+    }
+    Panic(key + " on Date is not implemented");
+    return Value{};
+  }
+
+  std::string ToString() const override {
+    return "Date";
+  }
+};
+
+template <typename T>
+double LoadElement(const uint8_t* p) {
+  T v;
+  std::memcpy(&v, p, sizeof(T));
+  return static_cast<double>(v);
+}
+
+template <typename T>
+void StoreElement(uint8_t* p, T v) {
+  std::memcpy(p, &v, sizeof(T));
+}
+
+// ToUint32 converts value to an integer modulo 2^32 like JavaScript's ToUint32.
+uint32_t ToUint32(double value) {
+  if (!std::isfinite(value)) {
+    return 0;
+  }
+  double m = std::fmod(std::trunc(value), 4294967296.0);
+  if (m < 0) {
+    m += 4294967296.0;
+  }
+  return static_cast<uint32_t>(m);
+}
+
+// ToUint8Clamped converts value like JavaScript's ToUint8Clamp, which rounds half to even.
+uint8_t ToUint8Clamped(double value) {
+  if (std::isnan(value) || value <= 0) {
+    return 0;
+  }
+  if (value >= 255) {
+    return 255;
+  }
+  return static_cast<uint8_t>(std::nearbyint(value));
+}
+
+double LoadNumber(TypedArray::Kind kind, const uint8_t* p) {
+  using Kind = TypedArray::Kind;
+  switch (kind) {
+  case Kind::kInt8:
+    return LoadElement<int8_t>(p);
+  case Kind::kUint8:
+  case Kind::kUint8Clamped:
+    return LoadElement<uint8_t>(p);
+  case Kind::kInt16:
+    return LoadElement<int16_t>(p);
+  case Kind::kUint16:
+    return LoadElement<uint16_t>(p);
+  case Kind::kInt32:
+    return LoadElement<int32_t>(p);
+  case Kind::kUint32:
+    return LoadElement<uint32_t>(p);
+  case Kind::kFloat32:
+    return LoadElement<float>(p);
+  case Kind::kFloat64:
+    return LoadElement<double>(p);
+  }
+  return 0;
+}
+
+void StoreNumber(TypedArray::Kind kind, uint8_t* p, double value) {
+  using Kind = TypedArray::Kind;
+  switch (kind) {
+  case Kind::kInt8:
+    StoreElement(p, static_cast<int8_t>(static_cast<uint8_t>(ToUint32(value))));
+    return;
+  case Kind::kUint8:
+    StoreElement(p, static_cast<uint8_t>(ToUint32(value)));
+    return;
+  case Kind::kUint8Clamped:
+    StoreElement(p, ToUint8Clamped(value));
+    return;
+  case Kind::kInt16:
+    StoreElement(p, static_cast<int16_t>(static_cast<uint16_t>(ToUint32(value))));
+    return;
+  case Kind::kUint16:
+    StoreElement(p, static_cast<uint16_t>(ToUint32(value)));
+    return;
+  case Kind::kInt32:
+    StoreElement(p, static_cast<int32_t>(ToUint32(value)));
+    return;
+  case Kind::kUint32:
+    StoreElement(p, ToUint32(value));
+    return;
+  case Kind::kFloat32:
+    StoreElement(p, static_cast<float>(value));
+    return;
+  case Kind::kFloat64:
+    StoreElement(p, value);
+    return;
+  }
+}
+
+// RelativeIndex converts an index argument like slice's begin and end to an index in [0, length]. A negative index
+// counts from the end. If value is undefined, def is returned.
+size_t RelativeIndex(const Value& value, size_t length, size_t def) {
+  if (value.IsUndefined()) {
+    return def;
+  }
+  double v = std::trunc(value.ToNumber());
+  if (std::isnan(v)) {
+    return 0;
+  }
+  if (v < 0) {
+    v += static_cast<double>(length);
+    if (v < 0) {
+      return 0;
+    }
+  }
+  if (v > static_cast<double>(length)) {
+    return length;
+  }
+  return static_cast<size_t>(v);
+}
+
+bool IsLittleEndianHost() {
+  uint16_t v = 1;
+  uint8_t b;
+  std::memcpy(&b, &v, 1);
+  return b == 1;
+}
+
+// DataViewKind returns the kind for a DataView's method suffix like "Int32" for getInt32.
+bool DataViewKind(const std::string& name, TypedArray::Kind* kind) {
+  static const std::map<std::string, TypedArray::Kind> kinds = {
+    {"Int8", TypedArray::Kind::kInt8},
+    {"Uint8", TypedArray::Kind::kUint8},
+    {"Int16", TypedArray::Kind::kInt16},
+    {"Uint16", TypedArray::Kind::kUint16},
+    {"Int32", TypedArray::Kind::kInt32},
+    {"Uint32", TypedArray::Kind::kUint32},
+    {"Float32", TypedArray::Kind::kFloat32},
+    {"Float64", TypedArray::Kind::kFloat64},
+  };
+  auto it = kinds.find(name);
+  if (it == kinds.end()) {
+    return false;
+  }
+  *kind = it->second;
+  return true;
+}
+
+// ParseArrayIndex reports whether key is a canonical array index like "0" and "42".
+bool ParseArrayIndex(const std::string& key, size_t* index) {
+  if (key.empty() || key.size() > 15) {
+    return false;
+  }
+  if (key.size() > 1 && key[0] == '0') {
+    return false;
+  }
+  size_t v = 0;
+  for (char c : key) {
+    if (c < '0' || '9' < c) {
+      return false;
+    }
+    v = v * 10 + (c - '0');
+  }
+  *index = v;
+  return true;
+}
+
+Promise::Scheduler& CurrentPromiseScheduler() {
+  thread_local Promise::Scheduler scheduler;
+  return scheduler;
+}
+
+TypedArray& ThisTypedArray(Value self, const std::string& method) {
+  TypedArray* t = self.IsObject() ? dynamic_cast<TypedArray*>(&self.ToObject()) : nullptr;
+  if (!t) {
+    throw Exception{"TypeError", "TypedArray.prototype." + method + " called on " + self.Inspect()};
+  }
+  return *t;
+}
+
+bool IsFunctionValue(const Value& value) {
+  return value.IsObject() && value.ToObject().IsFunction();
+}
+
+class PromiseConstructor : public Object {
+public:
+  bool IsFunction() const override { return true; }
+  bool IsConstructor() const override { return true; }
+
+  Value Get(const std::string& key) override {
+    if (key == "resolve") {
+      return Value{std::make_shared<Function>(
+        [](Value self, std::vector<Value> args) -> Value {
+          Value value = FunctionArg(args, 0);
+          if (value.IsObject() && dynamic_cast<Promise*>(&value.ToObject())) {
+            return value;
+          }
+          auto p = std::make_shared<Promise>();
+          p->Resolve(value);
+          return Value{p};
+        })};
+    }
+    if (key == "reject") {
+      return Value{std::make_shared<Function>(
+        [](Value self, std::vector<Value> args) -> Value {
+          auto p = std::make_shared<Promise>();
+          p->Reject(FunctionArg(args, 0));
+          return Value{p};
+        })};
+    }
+    return Value{};
+  }
+
+  Value New(std::vector<Value> args) override {
+    Value executor = FunctionArg(args, 0);
+    if (!IsFunctionValue(executor)) {
+      throw Exception{"TypeError", "Promise resolver " + executor.Inspect() + " is not a function"};
+    }
+    auto p = std::make_shared<Promise>();
+    Value resolve{std::make_shared<Function>(
+      [p](Value self, std::vector<Value> args) -> Value {
+        p->Resolve(FunctionArg(args, 0));
+        return Value{};
+      })};
+    Value reject{std::make_shared<Function>(
+      [p](Value self, std::vector<Value> args) -> Value {
+        p->Reject(FunctionArg(args, 0));
+        return Value{};
+      })};
+    try {
+      Value::ReflectApply(executor, Value{}, {resolve, reject});
+    } catch (const Exception& e) {
+      p->Reject(e.GetValue());
+    }
+    return Value{p};
+  }
+
+  std::string ToString() const override {
+    return "Promise";
+  }
+};
+
+// MakeTypedArrayConstructor returns a constructor for typed arrays of kind, like Int32Array and Float64Array.
+std::shared_ptr<Constructor> MakeTypedArrayConstructor(TypedArray::Kind kind) {
+  std::string name = TypedArray::KindName(kind);
+  return std::make_shared<Constructor>(name,
+    [kind, name](Value self, std::vector<Value> args) -> Value {
+      size_t size = TypedArray::ElementSize(kind);
+      if (args.size() == 0 || args[0].IsUndefined()) {
+        return Value{std::make_shared<TypedArray>(kind, 0)};
+      }
+      if (args[0].IsNumber()) {
+        double len = args[0].ToNumber();
+        if (std::isnan(len) || len < 0 || len != std::trunc(len)) {
+          throw Exception{"RangeError", "Invalid typed array length: " + args[0].Inspect()};
+        }
+        return Value{std::make_shared<TypedArray>(kind, static_cast<size_t>(len))};
+      }
+      if (args[0].IsObject() && dynamic_cast<ArrayBuffer*>(&args[0].ToObject())) {
+        std::shared_ptr<ArrayBuffer> ab = args[0].ToArrayBuffer();
+        size_t byte_offset = 0;
+        if (args.size() > 1 && !args[1].IsUndefined()) {
+          double v = args[1].ToNumber();
+          if (std::isnan(v) || v < 0 || v != std::trunc(v)) {
+            throw Exception{"RangeError", "invalid offset " + args[1].Inspect() + " for " + name};
+          }
+          byte_offset = static_cast<size_t>(v);
+        }
+        if (byte_offset % size != 0) {
+          throw Exception{"RangeError", "start offset of " + name + " should be a multiple of " + std::to_string(size)};
+        }
+        if (byte_offset > ab->ByteLength()) {
+          throw Exception{"RangeError", "start offset " + std::to_string(byte_offset) + " is outside the bounds of the buffer"};
+        }
+        size_t length = 0;
+        if (args.size() > 2 && !args[2].IsUndefined()) {
+          double v = args[2].ToNumber();
+          if (std::isnan(v) || v < 0 || v != std::trunc(v)) {
+            throw Exception{"RangeError", "Invalid typed array length: " + args[2].Inspect()};
+          }
+          length = static_cast<size_t>(v);
+          if (byte_offset + length * size > ab->ByteLength()) {
+            throw Exception{"RangeError", "Invalid typed array length: " + std::to_string(length)};
+          }
+        } else {
+          if ((ab->ByteLength() - byte_offset) % size != 0) {
+            throw Exception{"RangeError", "byte length of " + name + " should be a multiple of " + std::to_string(size)};
+          }
+          length = (ab->ByteLength() - byte_offset) / size;
+        }
+        return Value{std::make_shared<TypedArray>(kind, ab, byte_offset, length)};
+      }
+      if (args[0].IsObject()) {
+        if (TypedArray* src = dynamic_cast<TypedArray*>(&args[0].ToObject())) {
+          auto dst = std::make_shared<TypedArray>(kind, src->Length());
+          for (size_t i = 0; i < src->Length(); i++) {
+            dst->SetElement(i, src->GetElement(i));
+          }
+          return Value{dst};
+        }
+      }
+      if (args[0].IsArray()) {
+        std::vector<Value>& src = args[0].ToArray();
+        auto dst = std::make_shared<TypedArray>(kind, src.size());
+        for (size_t i = 0; i < src.size(); i++) {
+          dst->SetElement(i, src[i].ToNumber());
+        }
+        return Value{dst};
+      }
+      Panic("new " + name + "(" + args[0].Inspect() + ") is not implemented");
+      return Value{};
+    });
+}
+
+}  // namespace
+
+std::string ToValidUTF8(const uint8_t* data, size_t size, bool* valid) {
+  static const char kReplacement[] = "\xef\xbf\xbd";
+
+  std::string result;
+  result.reserve(size);
+  bool ok = true;
+  size_t i = 0;
+  while (i < size) {
+    uint8_t b = data[i];
+    if (b < 0x80) {
+      result.push_back(static_cast<char>(b));
+      i++;
+      continue;
+    }
+
+    // Find the number of the continuation bytes and the range of the first one.
+    size_t n = 0;
+    uint8_t lower = 0x80;
+    uint8_t upper = 0xbf;
+    if (0xc2 <= b && b <= 0xdf) {
+      n = 1;
+    } else if (0xe0 <= b && b <= 0xef) {
+      n = 2;
+      if (b == 0xe0) {
+        lower = 0xa0;
+      } else if (b == 0xed) {
+        upper = 0x9f;
+      }
+    } else if (0xf0 <= b && b <= 0xf4) {
+      n = 3;
+      if (b == 0xf0) {
+        lower = 0x90;
+      } else if (b == 0xf4) {
+        upper = 0x8f;
+      }
+    } else {
+      result += kReplacement;
+      ok = false;
+      i++;
+      continue;
+    }
+
+    // A maximal subpart of an invalid sequence is replaced with one U+FFFD.
+    size_t j = 1;
+    for (; j <= n; j++) {
+      if (i + j >= size) {
+        break;
+      }
+      uint8_t c = data[i + j];
+      if (c < lower || upper < c) {
+        break;
+      }
+      lower = 0x80;
+      upper = 0xbf;
+    }
+    if (j <= n) {
+      result += kReplacement;
+      ok = false;
+      i += j;
+      continue;
+    }
+    result.append(reinterpret_cast<const char*>(data + i), n + 1);
+    i += n + 1;
+  }
+  if (valid) {
+    *valid = ok;
+  }
+  return result;
+}
+
+std::string ToValidUTF8(const std::string& str, bool* valid) {
+  return ToValidUTF8(reinterpret_cast<const uint8_t*>(str.data()), str.size(), valid);
+}
+
+std::string NumberToString(double num) {
+  if (std::isnan(num)) {
+    return "NaN";
+  }
+  if (std::isinf(num)) {
+    return num > 0 ? "Infinity" : "-Infinity";
+  }
+  if (num == 0) {
+    return "0";
+  }
+  char buf[32];
+  if (num == std::trunc(num) && std::abs(num) < 1e21) {
+    std::snprintf(buf, sizeof(buf), "%.0f", num);
+    return buf;
+  }
+  // Find the shortest representation that round-trips.
+  for (int precision = 1; precision <= 17; precision++) {
+    std::snprintf(buf, sizeof(buf), "%.*g", precision, num);
+    if (std::strtod(buf, nullptr) == num) {
+      break;
+    }
+  }
+  // JavaScript doesn't pad the exponent like "1e-7".
+  std::string str = buf;
+  size_t e = str.find('e');
+  if (e != std::string::npos) {
+    size_t digits = e + 2;
+    while (digits + 1 < str.size() && str[digits] == '0') {
+      str.erase(digits, 1);
+    }
+  }
+  return str;
+}
+
+namespace {
+
+std::string FormatConsoleArg(const Value& arg) {
+  if (arg.IsString()) {
+    return arg.ToString();
+  }
+  if (arg.IsNumber()) {
+    return NumberToString(arg.ToNumber());
+  }
+  return arg.Inspect();
+}
+
+}  // namespace
+
+std::string FormatConsoleMessage(const std::vector<Value>& args) {
+  std::string msg;
+  size_t next = 0;
+  if (!args.empty() && args[0].IsString()) {
+    const std::string& format = args[0].ToString();
+    next = 1;
+    for (size_t i = 0; i < format.size(); i++) {
+      char c = format[i];
+      if (c != '%' || i + 1 >= format.size()) {
+        msg.push_back(c);
+        continue;
+      }
+      char verb = format[i + 1];
+      if (verb == '%') {
+        msg.push_back('%');
+        i++;
+        continue;
+      }
+      if (std::string{"sdifoOc"}.find(verb) == std::string::npos || next >= args.size()) {
+        msg.push_back(c);
+        continue;
+      }
+      const Value& arg = args[next++];
+      i++;
+      switch (verb) {
+      case 's':
+        msg += FormatConsoleArg(arg);
+        break;
+      case 'd':
+      case 'i':
+        msg += arg.IsNumber() ? NumberToString(std::trunc(arg.ToNumber())) : "NaN";
+        break;
+      case 'f':
+        msg += arg.IsNumber() ? NumberToString(arg.ToNumber()) : "NaN";
+        break;
+      case 'o':
+      case 'O':
+        msg += arg.Inspect();
+        break;
+      case 'c':
+        // CSS is ignored.
+        break;
+      }
+    }
+  }
+  for (size_t i = next; i < args.size(); i++) {
+    if (!msg.empty() || i > 0) {
+      msg.push_back(' ');
+    }
+    msg += FormatConsoleArg(args[i]);
+  }
+  return msg;
+}
+
+Writer::~Writer() = default;
+
+StreamWriter::StreamWriter(std::ostream& out)
+    : out_{out} {
+}
+
+void StreamWriter::Write(const std::vector<uint8_t>& bytes) {
+  buf_.insert(buf_.end(), bytes.begin(), bytes.end());
+  for (;;) {
+    auto it = std::find(buf_.begin(), buf_.end(), '\n');
+    if (it == buf_.end()) {
+      break;
+    }
+    std::string str(buf_.begin(), it);
+    out_ << str << std::endl;
+    ++it;
+    buf_.erase(buf_.begin(), it);
+  }
+}
+
+std::size_t Value::Hash::operator()(const Value& value) const {
+  // Hash only the field that identifies the value of the type. The objects and the arrays are hashed by the pointers.
+  size_t h = value.type_;
+  if (value.object_value_) {
+    return h * 31 + std::hash<Object*>()(value.object_value_.get());
+  }
+  if (value.array_value_) {
+    return h * 31 + std::hash<std::vector<Value>*>()(value.array_value_.get());
+  }
+  if (value.str_value_) {
+    return h * 31 + std::hash<std::string>()(*value.str_value_);
+  }
+  return h * 31 + std::hash<double>()(value.num_value_);
+}
+
+Value Value::Null() {
+  return Value{kNull};
+}
+
+Value::Value() = default;
+
+Value::Value(bool b)
+    : type_{kBool},
+      num_value_{static_cast<double>(b)}{
+}
+
+Value::Value(double num)
+    : type_{kNumber},
+      num_value_{num} {
+}
+
+Value::Value(const char* str)
+    : Value{std::string(str)} {
+}
+
+Value::Value(const std::string& str)
+    : type_{kString},
+      str_value_{SharedString(str)} {
+}
+
+Value::Value(std::shared_ptr<Object> object)
+    : type_{kObject},
+      object_value_{object} {
+}
+
+Value::Value(const std::vector<Value>& array)
+    : type_{kObject},
+      array_value_{std::make_shared<std::vector<Value>>(array.begin(), array.end())} {
+}
+
+Value::Value(const Value& rhs) = default;
+
+Value& Value::operator=(const Value& rhs) = default;
+
+bool Value::operator==(const Value& rhs) const {
+  return type_ == rhs.type_ &&
+      num_value_ == rhs.num_value_ &&
+      (str_value_ == rhs.str_value_ || (str_value_ && rhs.str_value_ && *str_value_ == *rhs.str_value_)) &&
+      object_value_ == rhs.object_value_ &&
+      array_value_ == rhs.array_value_;
+}
+
+Value::Value(Type type)
+    : type_{type} {
+}
 
 Value::Value(Type type, double num)
     : type_{type},
@@ -1541,11 +2986,11 @@ double Value::ToNumber() const {
   return num_value_;
 }
 
-std::string Value::ToString() const {
+const std::string& Value::ToString() const {
   if (!(type_ & kString)) {
     Panic("Value::ToString: the type must be kString but not: " + Inspect());
   }
-  return str_value_;
+  return *str_value_;
 }
 
 BytesSpan Value::ToBytes() {
@@ -1581,6 +3026,16 @@ const Object& Value::ToObject() const {
   return *object_value_;
 }
 
+std::shared_ptr<Object> Value::ToSharedObject() {
+  if (!(type_ & kObject)) {
+    Panic("Value::ToSharedObject: the type must be kObject but not: " + Inspect());
+  }
+  if (!object_value_) {
+    Panic("Value::ToSharedObject: object_value_ must not be null");
+  }
+  return object_value_;
+}
+
 std::vector<Value>& Value::ToArray() {
   if (!(type_ & kObject)) {
     Panic("Value::ToArray: the type must be Type::Object but not: " + Inspect());
@@ -1598,7 +3053,11 @@ std::shared_ptr<ArrayBuffer> Value::ToArrayBuffer() {
   if (!object_value_) {
     Panic("Value::ToArrayBuffer: object_value_ must not be null");
   }
-  return std::static_pointer_cast<ArrayBuffer>(object_value_);
+  std::shared_ptr<ArrayBuffer> ab = std::dynamic_pointer_cast<ArrayBuffer>(object_value_);
+  if (!ab) {
+    Panic("Value::ToArrayBuffer: the object must be an ArrayBuffer but not: " + Inspect());
+  }
+  return ab;
 }
 
 std::string Value::Inspect() const {
@@ -1612,19 +3071,23 @@ std::string Value::Inspect() const {
     return ToBool() ? "true" : "false";
   }
   if (type_ & kNumber) {
-    return std::to_string(ToNumber());
+    return NumberToString(ToNumber());
   }
   if (type_ & kString) {
     return ToString();
   }
   if (type_ & kObject) {
     if (IsArray()) {
-      std::string str = "[";
-      for (auto& v : *array_value_) {
-        str += v.Inspect() + " ";
+      InspectScope scope;
+      if (scope.IsTooDeep()) {
+        return "[Array]";
       }
-      if (array_value_->size()) {
-        str.resize(str.size()-1);
+      std::string str = "[";
+      for (size_t i = 0; i < array_value_->size(); i++) {
+        if (i > 0) {
+          str += ", ";
+        }
+        str += InspectElement((*array_value_)[i]);
       }
       str += "]";
       return str;
@@ -1686,6 +3149,21 @@ Value ArrayBuffer::Get(const std::string& key) {
   if (key == "byteLength") {
     return Value{static_cast<double>(ByteLength())};
   }
+  if (key == "slice") {
+    return Value{std::make_shared<Function>(
+      [](Value self, std::vector<Value> args) -> Value {
+        ArrayBuffer* ab = self.IsObject() ? dynamic_cast<ArrayBuffer*>(&self.ToObject()) : nullptr;
+        if (!ab) {
+          throw Exception{"TypeError", "ArrayBuffer.prototype.slice called on " + self.Inspect()};
+        }
+        size_t len = ab->ByteLength();
+        size_t begin = RelativeIndex(FunctionArg(args, 0), len, 0);
+        size_t end = std::max(begin, RelativeIndex(FunctionArg(args, 1), len, len));
+        auto dst = std::make_shared<ArrayBuffer>(end - begin);
+        std::copy(ab->data_.begin() + begin, ab->data_.begin() + end, dst->data_.begin());
+        return Value{dst};
+      })};
+  }
   return Value{};
 }
 
@@ -1701,6 +3179,53 @@ std::string ArrayBuffer::ToString() const {
   return "ArrayBuffer";
 }
 
+std::string ArrayBuffer::Inspect() const {
+  return "ArrayBuffer { byteLength: " + std::to_string(data_.size()) + " }";
+}
+
+size_t TypedArray::ElementSize(Kind kind) {
+  switch (kind) {
+  case Kind::kInt8:
+  case Kind::kUint8:
+  case Kind::kUint8Clamped:
+    return 1;
+  case Kind::kInt16:
+  case Kind::kUint16:
+    return 2;
+  case Kind::kInt32:
+  case Kind::kUint32:
+  case Kind::kFloat32:
+    return 4;
+  case Kind::kFloat64:
+    return 8;
+  }
+  return 1;
+}
+
+std::string TypedArray::KindName(Kind kind) {
+  switch (kind) {
+  case Kind::kInt8:
+    return "Int8Array";
+  case Kind::kUint8:
+    return "Uint8Array";
+  case Kind::kUint8Clamped:
+    return "Uint8ClampedArray";
+  case Kind::kInt16:
+    return "Int16Array";
+  case Kind::kUint16:
+    return "Uint16Array";
+  case Kind::kInt32:
+    return "Int32Array";
+  case Kind::kUint32:
+    return "Uint32Array";
+  case Kind::kFloat32:
+    return "Float32Array";
+  case Kind::kFloat64:
+    return "Float64Array";
+  }
+  return "TypedArray";
+}
+
 TypedArray::TypedArray(size_t size)
     : array_buffer_{std::make_shared<ArrayBuffer>(size)},
       length_{size} {
@@ -1712,7 +3237,49 @@ TypedArray::TypedArray(std::shared_ptr<ArrayBuffer> arrayBuffer, size_t offset,
       length_{length} {
 }
 
+TypedArray::TypedArray(Kind kind, size_t length)
+    : kind_{kind},
+      array_buffer_{std::make_shared<ArrayBuffer>(length * ElementSize(kind))},
+      length_{length * ElementSize(kind)} {
+}
+
+TypedArray::TypedArray(Kind kind, std::shared_ptr<ArrayBuffer> arrayBuffer, size_t byte_offset, size_t length)
+    : kind_{kind},
+      array_buffer_{arrayBuffer},
+      offset_{byte_offset},
+      length_{length * ElementSize(kind)} {
+}
+
+TypedArray::Kind TypedArray::GetKind() const {
+  return kind_;
+}
+
+size_t TypedArray::Length() const {
+  return length_ / ElementSize(kind_);
+}
+
+double TypedArray::GetElement(size_t index) {
+  return LoadNumber(kind_, ToBytes().data() + index * ElementSize(kind_));
+}
+
+void TypedArray::SetElement(size_t index, double value) {
+  StoreNumber(kind_, ToBytes().data() + index * ElementSize(kind_), value);
+}
+
 Value TypedArray::Get(const std::string& key) {
+  size_t index = 0;
+  if (ParseArrayIndex(key, &index)) {
+    if (index >= Length()) {
+      return Value{};
+    }
+    return Value{GetElement(index)};
+  }
+  if (key == "length") {
+    return Value{static_cast<double>(Length())};
+  }
+  if (key == "BYTES_PER_ELEMENT") {
+    return Value{static_cast<double>(ElementSize(kind_))};
+  }
   if (key == "byteLength") {
     return Value{static_cast<double>(length_)};
   }
@@ -1722,10 +3289,78 @@ Value TypedArray::Get(const std::string& key) {
   if (key == "buffer") {
     return Value{array_buffer_};
   }
+  if (key == "subarray") {
+    return Value{std::make_shared<Function>(
+      [](Value self, std::vector<Value> args) -> Value {
+        TypedArray& t = ThisTypedArray(self, "subarray");
+        size_t len = t.Length();
+        size_t begin = RelativeIndex(FunctionArg(args, 0), len, 0);
+        size_t end = std::max(begin, RelativeIndex(FunctionArg(args, 1), len, len));
+        return Value{std::make_shared<TypedArray>(t.kind_, t.array_buffer_, t.offset_ + begin * ElementSize(t.kind_), end - begin)};
+      })};
+  }
+  if (key == "slice") {
+    return Value{std::make_shared<Function>(
+      [](Value self, std::vector<Value> args) -> Value {
+        TypedArray& t = ThisTypedArray(self, "slice");
+        size_t len = t.Length();
+        size_t begin = RelativeIndex(FunctionArg(args, 0), len, 0);
+        size_t end = std::max(begin, RelativeIndex(FunctionArg(args, 1), len, len));
+        auto dst = std::make_shared<TypedArray>(t.kind_, end - begin);
+        BytesSpan src = t.ToBytes();
+        size_t size = ElementSize(t.kind_);
+        std::copy(src.begin() + begin * size, src.begin() + end * size, dst->ToBytes().begin());
+        return Value{dst};
+      })};
+  }
+  if (key == "set") {
+    return Value{std::make_shared<Function>(
+      [](Value self, std::vector<Value> args) -> Value {
+        TypedArray& t = ThisTypedArray(self, "set");
+        Value src = FunctionArg(args, 0);
+        double offset = 0;
+        if (args.size() > 1 && !args[1].IsUndefined()) {
+          offset = std::trunc(args[1].ToNumber());
+        }
+        if (std::isnan(offset) || offset < 0) {
+          throw Exception{"RangeError", "offset is out of bounds"};
+        }
+
+        // Read all the source elements first as the source might share the buffer.
+        std::vector<double> values;
+        if (src.IsArray()) {
+          for (const Value& v : src.ToArray()) {
+            values.push_back(v.ToNumber());
+          }
+        } else if (TypedArray* s = src.IsObject() ? dynamic_cast<TypedArray*>(&src.ToObject()) : nullptr) {
+          for (size_t i = 0; i < s->Length(); i++) {
+            values.push_back(s->GetElement(i));
+          }
+        } else {
+          Panic(t.ToString() + ".set(" + src.Inspect() + ") is not implemented");
+        }
+        if (offset + static_cast<double>(values.size()) > static_cast<double>(t.Length())) {
+          throw Exception{"RangeError", "offset is out of bounds"};
+        }
+        size_t o = static_cast<size_t>(offset);
+        for (size_t i = 0; i < values.size(); i++) {
+          t.SetElement(o + i, values[i]);
+        }
+        return Value{};
+      })};
+  }
   return Value{};
 }
 
 void TypedArray::Set(const std::string& key, Value value) {
+  size_t index = 0;
+  if (ParseArrayIndex(key, &index)) {
+    // Out-of-range writes are ignored in JavaScript.
+    if (index < Length()) {
+      SetElement(index, value.ToNumber());
+    }
+    return;
+  }
   if (key == "byteLength") {
     length_ = static_cast<size_t>(value.ToNumber());
     return;
@@ -1747,19 +3382,39 @@ bool TypedArray::IsBytes() const {
 
 BytesSpan TypedArray::ToBytes() {
   auto bs = array_buffer_->ToBytes();
-  return BytesSpan{bs.begin() + offset_, length_};
+  return BytesSpan{bs.data() + offset_, length_};
 }
 
 std::string TypedArray::ToString() const {
-  return "TypedArray";
+  return KindName(kind_);
+}
+
+std::string TypedArray::Inspect() const {
+  constexpr size_t kMaxElements = 16;
+
+  size_t size = ElementSize(kind_);
+  size_t length = length_ / size;
+  const uint8_t* data = array_buffer_->ToBytes().data() + offset_;
+  std::string str = KindName(kind_) + "(" + std::to_string(length) + ") [";
+  for (size_t i = 0; i < std::min(length, kMaxElements); i++) {
+    if (i > 0) {
+      str += ", ";
+    }
+    str += NumberToString(LoadNumber(kind_, data + i * size));
+  }
+  if (length > kMaxElements) {
+    str += ", ... " + std::to_string(length - kMaxElements) + " more items";
+  }
+  str += "]";
+  return str;
 }
 
 Uint8Array::Uint8Array(size_t size)
-    : TypedArray(size) {
+    : TypedArray(Kind::kUint8, size) {
 }
 
 Uint8Array::Uint8Array(std::shared_ptr<ArrayBuffer> arrayBuffer, size_t offset, size_t length)
-    : TypedArray(arrayBuffer, offset, length) {
+    : TypedArray(Kind::kUint8, arrayBuffer, offset, length) {
 }
 
 std::string Uint8Array::ToString() const {
@@ -1767,17 +3422,110 @@ std::string Uint8Array::ToString() const {
 }
 
 Float32Array::Float32Array(size_t size)
-    : TypedArray(size*4) {
+    : TypedArray(Kind::kFloat32, size) {
 }
 
 Float32Array::Float32Array(std::shared_ptr<ArrayBuffer> arrayBuffer, size_t offset, size_t length)
-    : TypedArray(arrayBuffer, offset*4, length*4) {
+    : TypedArray(Kind::kFloat32, arrayBuffer, offset * 4, length) {
 }
 
 std::string Float32Array::ToString() const {
   return "Float32Array";
 }
 
+DataView::DataView(std::shared_ptr<ArrayBuffer> arrayBuffer, size_t byte_offset, size_t byte_length)
+    : array_buffer_{arrayBuffer},
+      offset_{byte_offset},
+      length_{byte_length} {
+}
+
+Value DataView::Get(const std::string& key) {
+  if (key == "byteLength") {
+    return Value{static_cast<double>(length_)};
+  }
+  if (key == "byteOffset") {
+    return Value{static_cast<double>(offset_)};
+  }
+  if (key == "buffer") {
+    return Value{array_buffer_};
+  }
+  // Methods like getInt32 and setFloat64.
+  TypedArray::Kind kind;
+  if (key.size() > 3 && DataViewKind(key.substr(3), &kind)) {
+    if (key.compare(0, 3, "get") == 0) {
+      return Value{std::make_shared<Function>(
+        [kind, key](Value self, std::vector<Value> args) -> Value {
+          DataView* d = self.IsObject() ? dynamic_cast<DataView*>(&self.ToObject()) : nullptr;
+          if (!d) {
+            throw Exception{"TypeError", "DataView.prototype." + key + " called on " + self.Inspect()};
+          }
+          return d->Load(kind, args);
+        })};
+    }
+    if (key.compare(0, 3, "set") == 0) {
+      return Value{std::make_shared<Function>(
+        [kind, key](Value self, std::vector<Value> args) -> Value {
+          DataView* d = self.IsObject() ? dynamic_cast<DataView*>(&self.ToObject()) : nullptr;
+          if (!d) {
+            throw Exception{"TypeError", "DataView.prototype." + key + " called on " + self.Inspect()};
+          }
+          d->Store(kind, args);
+          return Value{};
+        })};
+    }
+  }
+  return Value{};
+}
+
+Value DataView::Load(TypedArray::Kind kind, const std::vector<Value>& args) {
+  double offset = std::trunc(FunctionArg(args, 0).ToNumber());
+  size_t size = TypedArray::ElementSize(kind);
+  if (std::isnan(offset) || offset < 0 || offset + static_cast<double>(size) > static_cast<double>(length_)) {
+    throw Exception{"RangeError", "Offset is outside the bounds of the DataView"};
+  }
+  Value le = FunctionArg(args, 1);
+  bool little_endian = le.IsBool() && le.ToBool();
+  uint8_t buf[8];
+  std::copy_n(ToBytes().data() + static_cast<size_t>(offset), size, buf);
+  if (little_endian != IsLittleEndianHost()) {
+    std::reverse(buf, buf + size);
+  }
+  return Value{LoadNumber(kind, buf)};
+}
+
+void DataView::Store(TypedArray::Kind kind, const std::vector<Value>& args) {
+  double offset = std::trunc(FunctionArg(args, 0).ToNumber());
+  size_t size = TypedArray::ElementSize(kind);
+  if (std::isnan(offset) || offset < 0 || offset + static_cast<double>(size) > static_cast<double>(length_)) {
+    throw Exception{"RangeError", "Offset is outside the bounds of the DataView"};
+  }
+  Value le = FunctionArg(args, 2);
+  bool little_endian = le.IsBool() && le.ToBool();
+  uint8_t buf[8];
+  StoreNumber(kind, buf, FunctionArg(args, 1).ToNumber());
+  if (little_endian != IsLittleEndianHost()) {
+    std::reverse(buf, buf + size);
+  }
+  std::copy_n(buf, size, ToBytes().data() + static_cast<size_t>(offset));
+}
+
+bool DataView::IsBytes() const {
+  return true;
+}
+
+BytesSpan DataView::ToBytes() {
+  auto bs = array_buffer_->ToBytes();
+  return BytesSpan{bs.data() + offset_, length_};
+}
+
+std::string DataView::ToString() const {
+  return "DataView";
+}
+
+std::string DataView::Inspect() const {
+  return "DataView { byteLength: " + std::to_string(length_) + ", byteOffset: " + std::to_string(offset_) + " }";
+}
+
 DictionaryValues::DictionaryValues() {
 }
 
@@ -1805,15 +3553,152 @@ std::string DictionaryValues::ToString() const {
   return "DictionaryValues";
 }
 
-std::string DictionaryValues::Inspect() const {
-  std::string str = "{";
+void DictionaryValues::Clear() {
+  dict_.clear();
+}
+
+Value ValueArena::NewDictionary() {
+  if (dictionary_index_ == dictionaries_.size()) {
+    dictionaries_.push_back(Value{std::make_shared<DictionaryValues>()});
+  }
+  Value v = dictionaries_[dictionary_index_++];
+  static_cast<DictionaryValues&>(v.ToObject()).Clear();
+  return v;
+}
+
+Value ValueArena::NewArray(size_t n) {
+  if (array_index_ == arrays_.size()) {
+    arrays_.push_back(Value{std::vector<Value>{}});
+  }
+  Value v = arrays_[array_index_++];
+  auto& array = v.ToArray();
+  array.clear();
+  array.resize(n);
+  return v;
+}
+
+void ValueArena::Reset() {
+  dictionary_index_ = 0;
+  array_index_ = 0;
+}
+
+EventTarget::~EventTarget() = default;
+
+void EventTarget::AddEventListener(const std::string& type, Value listener, bool once) {
+  std::vector<Listener>& listeners = listeners_[type];
+  for (const Listener& l : listeners) {
+    if (l.func == listener) {
+      return;
+    }
+  }
+  listeners.push_back(Listener{listener, once});
+}
+
+void EventTarget::RemoveEventListener(const std::string& type, Value listener) {
+  auto it = listeners_.find(type);
+  if (it == listeners_.end()) {
+    return;
+  }
+  std::vector<Listener>& listeners = it->second;
+  listeners.erase(std::remove_if(listeners.begin(), listeners.end(), [&listener](const Listener& l) {
+    return l.func == listener;
+  }), listeners.end());
+}
+
+void EventTarget::DispatchEvent(const std::string& type, Value event) {
+  auto it = listeners_.find(type);
+  if (it == listeners_.end()) {
+    return;
+  }
+  // Copy the listeners as a listener might add or remove listeners.
+  std::vector<Listener> listeners = it->second;
+  for (const Listener& l : listeners) {
+    if (l.once) {
+      RemoveEventListener(type, l.func);
+    }
+    Value::ReflectApply(l.func, Value{}, {event});
+  }
+}
+
+void EventTarget::ClearEventListeners() {
+  listeners_.clear();
+}
+
+Value EventTarget::GetEventTargetMethod(const std::string& key) {
+  if (key == "addEventListener") {
+    return Value{std::make_shared<Function>(
+      [this](Value self, std::vector<Value> args) -> Value {
+        Value listener = FunctionArg(args, 1);
+        if (!listener.IsObject() || !listener.ToObject().IsFunction()) {
+          return Value{};
+        }
+        bool once = false;
+        Value options = FunctionArg(args, 2);
+        if (options.IsObject()) {
+          Value v = options.ToObject().Get("once");
+          once = v.IsBool() && v.ToBool();
+        }
+        AddEventListener(FunctionArg(args, 0).ToString(), listener, once);
+        return Value{};
+      })};
+  }
+  if (key == "removeEventListener") {
+    return Value{std::make_shared<Function>(
+      [this](Value self, std::vector<Value> args) -> Value {
+        RemoveEventListener(FunctionArg(args, 0).ToString(), FunctionArg(args, 1));
+        return Value{};
+      })};
+  }
+  if (key == "dispatchEvent") {
+    return Value{std::make_shared<Function>(
+      [this](Value self, std::vector<Value> args) -> Value {
+        Value event = FunctionArg(args, 0);
+        DispatchEvent(Value::ReflectGet(event, "type").ToString(), event);
+        return Value{true};
+      })};
+  }
+  return Value{};
+}
+
+EventTargetDictionaryValues::EventTargetDictionaryValues() = default;
+
+EventTargetDictionaryValues::EventTargetDictionaryValues(const std::map<std::string, Value>& dict)
+    : DictionaryValues(dict) {
+}
+
+Value EventTargetDictionaryValues::Get(const std::string& key) {
+  Value v = DictionaryValues::Get(key);
+  if (!v.IsUndefined()) {
+    return v;
+  }
+  return GetEventTargetMethod(key);
+}
+
+std::vector<std::string> DictionaryValues::Keys() const {
+  std::vector<std::string> keys;
+  keys.reserve(dict_.size());
   for (auto& kv : dict_) {
-    str += kv.first + ":" + kv.second.Inspect() + " ";
+    keys.push_back(kv.first);
+  }
+  return keys;
+}
+
+std::string DictionaryValues::Inspect() const {
+  if (dict_.empty()) {
+    return "{}";
   }
-  if (dict_.size()) {
-    str.resize(str.size()-1);
+  InspectScope scope;
+  if (scope.IsTooDeep()) {
+    return "[Object]";
   }
-  str += "}";
+  std::string str = "{ ";
+  for (auto it = dict_.begin(); it != dict_.end(); ++it) {
+    if (it != dict_.begin()) {
+      str += ", ";
+    }
+    str += it->first + ": " + InspectElement(it->second);
+  }
+  str += " }";
   return str;
 }
 
@@ -1837,6 +3722,10 @@ Value Function::Get(const std::string& key) {
 }
 
 Value Function::Invoke(Value self, std::vector<Value> args) {
+  // A function not bound by bind takes the receiver of the call as this.
+  if (self_.IsUndefined()) {
+    return fn_(self, args);
+  }
   return fn_(self_, args);
 }
 
@@ -1876,114 +3765,69 @@ Value Value::MakeGlobal() {
       return Value{};
     });
 
-  std::shared_ptr<Constructor> u8 = std::make_shared<Constructor>("Uint8Array",
+  std::shared_ptr<Constructor> dataView = std::make_shared<Constructor>("DataView",
     [](Value self, std::vector<Value> args) -> Value {
-      if (args.size() == 0) {
-        return Value{std::make_shared<Uint8Array>(0)};
+      Value buffer = FunctionArg(args, 0);
+      if (!buffer.IsObject() || !dynamic_cast<ArrayBuffer*>(&buffer.ToObject())) {
+        throw Exception{"TypeError", "First argument to DataView constructor must be an ArrayBuffer"};
       }
-      if (args.size() == 1) {
-        if (args[0].IsNumber()) {
-          size_t len = static_cast<size_t>(args[0].ToNumber());
-          return Value{std::make_shared<Uint8Array>(len)};
-        }
-        if (args[0].IsObject()) {
-          std::shared_ptr<ArrayBuffer> ab = args[0].ToArrayBuffer();
-          auto u8 = std::make_shared<Uint8Array>(ab, 0, ab->ByteLength());
-          return Value{u8};
-        }
-        Panic("new Uint8Array(" + args[0].Inspect() + ") is not implemented");
-        return Value{};
+      std::shared_ptr<ArrayBuffer> ab = buffer.ToArrayBuffer();
+      size_t byte_offset = RelativeIndex(FunctionArg(args, 1), ab->ByteLength(), 0);
+      if (args.size() > 1 && !args[1].IsUndefined() &&
+          (args[1].ToNumber() < 0 || args[1].ToNumber() > static_cast<double>(ab->ByteLength()))) {
+        throw Exception{"RangeError", "Start offset " + args[1].Inspect() + " is outside the bounds of the buffer"};
       }
-      if (args.size() == 3) {
-        if (!args[0].IsObject()) {
-          Panic("new Uint8Array's first argument must be an ArrayBuffer but " + args[0].Inspect());
-        }
-        if (!args[1].IsNumber()) {
-          Panic("new Uint8Array's second argument must be a number but " + args[1].Inspect());
-        }
-        if (!args[2].IsNumber()) {
-          Panic("new Uint8Array's third argument must be a number but " + args[2].Inspect());
+      size_t byte_length = ab->ByteLength() - byte_offset;
+      if (args.size() > 2 && !args[2].IsUndefined()) {
+        double v = std::trunc(args[2].ToNumber());
+        if (std::isnan(v) || v < 0 || static_cast<double>(byte_offset) + v > static_cast<double>(ab->ByteLength())) {
+          throw Exception{"RangeError", "Invalid DataView length " + args[2].Inspect()};
         }
-        std::shared_ptr<ArrayBuffer> ab = args[0].ToArrayBuffer();
-        size_t offset = static_cast<size_t>(args[1].ToNumber());
-        size_t length = static_cast<size_t>(args[2].ToNumber());
-        auto u8 = std::make_shared<Uint8Array>(ab, offset, length);
-        return Value{u8};
+        byte_length = static_cast<size_t>(v);
       }
-      Panic("new Uint8Array with " + std::to_string(args.size()) + " args is not implemented");
-      return Value{};
+      return Value{std::make_shared<DataView>(ab, byte_offset, byte_length)};
     });
 
-  std::shared_ptr<Constructor> f32 = std::make_shared<Constructor>("Float32Array",
+  std::shared_ptr<Constructor> textEncoder = std::make_shared<Constructor>("TextEncoder",
     [](Value self, std::vector<Value> args) -> Value {
-      if (args.size() == 0) {
-        return Value{std::make_shared<Float32Array>(0)};
-      }
-      if (args.size() == 1) {
-        if (!args[0].IsObject()) {
-          Panic("new Float32Array's first argument must be an ArrayBuffer but " + args[0].Inspect());
-        }
-        std::shared_ptr<ArrayBuffer> ab = args[0].ToArrayBuffer();
-        auto f32 = std::make_shared<Float32Array>(ab, 0, ab->ByteLength()/4);
-        return Value{f32};
-      }
-      if (args.size() == 3) {
-        if (!args[0].IsObject()) {
-          Panic("new Float32Array's first argument must be an ArrayBuffer but " + args[0].Inspect());
-        }
-        if (!args[1].IsNumber()) {
-          Panic("new Float32Array's second argument must be a number but " + args[1].Inspect());
-        }
-        if (!args[2].IsNumber()) {
-          Panic("new Float32Array's third argument must be a number but " + args[2].Inspect());
-        }
-        std::shared_ptr<ArrayBuffer> ab = args[0].ToArrayBuffer();
-        size_t offset = static_cast<size_t>(args[1].ToNumber());
-        size_t length = static_cast<size_t>(args[2].ToNumber());
-        auto f32 = std::make_shared<Float32Array>(ab, offset, length);
-        return Value{f32};
-      }
-      Panic("new Float32Array with " + std::to_string(args.size()) + " args is not implemented");
-      return Value{};
+      return Value{std::make_shared<TextEncoder>()};
     });
 
-  std::shared_ptr<Constructor> date = std::make_shared<Constructor>("Date",
+  std::shared_ptr<Constructor> textDecoder = std::make_shared<Constructor>("TextDecoder",
     [](Value self, std::vector<Value> args) -> Value {
-      return Value{std::make_shared<Date>()};
+      Value label = FunctionArg(args, 0);
+      if (!label.IsUndefined()) {
+        std::string l = label.ToString();
+        std::transform(l.begin(), l.end(), l.begin(), [](unsigned char c) { return std::tolower(c); });
+        if (l != "utf-8" && l != "utf8" && l != "unicode-1-1-utf-8") {
+          throw Exception{"RangeError", "The encoding label provided ('" + label.ToString() + "') is invalid or not supported"};
+        }
+      }
+      bool fatal = false;
+      bool ignore_bom = false;
+      Value options = FunctionArg(args, 1);
+      if (options.IsObject()) {
+        Value v = options.ToObject().Get("fatal");
+        fatal = v.IsBool() && v.ToBool();
+        v = options.ToObject().Get("ignoreBOM");
+        ignore_bom = v.IsBool() && v.ToBool();
+      }
+      return Value{std::make_shared<TextDecoder>(fatal, ignore_bom)};
     });
 
-  Value getRandomValues{std::make_shared<Function>(
+  std::shared_ptr<Constructor> error = std::make_shared<Constructor>("Error",
     [](Value self, std::vector<Value> args) -> Value {
-      BytesSpan bs = args[0].ToBytes();
-      // TODO: Use cryptographically strong random values instead of std::random_device.
-      static std::random_device rd;
-      std::uniform_int_distribution<uint8_t> dist(0, 255);
-      for (size_t i = 0; i < bs.size(); i++) {
-        bs[i] = dist(rd);
+      std::string message;
+      if (args.size() > 0 && !args[0].IsUndefined()) {
+        message = args[0].IsString() ? args[0].ToString() : args[0].Inspect();
       }
-      return Value{};
-    })};
-  std::shared_ptr<DictionaryValues> crypto = std::make_shared<DictionaryValues>(std::map<std::string, Value>{
-    {"getRandomValues", getRandomValues},
-  });
+      return Value{std::make_shared<Error>(message)};
+    });
 
-  static Value& writeObjectsToStdout = *new Value(std::make_shared<Function>(
-    [](Value self, std::vector<Value> args) -> Value {
-      WriteObjects(std::cout, args);
-      return Value{};
-    }));
-  static Value& writeObjectsToStderr = *new Value(std::make_shared<Function>(
+  std::shared_ptr<Constructor> date = std::make_shared<Constructor>("Date",
     [](Value self, std::vector<Value> args) -> Value {
-      WriteObjects(std::cerr, args);
-      return Value{};
-    }));
-  std::shared_ptr<DictionaryValues> console = std::make_shared<DictionaryValues>(std::map<std::string, Value>{
-    {"error", writeObjectsToStderr},
-    {"debug", writeObjectsToStderr},
-    {"info", writeObjectsToStdout},
-    {"log", writeObjectsToStdout},
-    {"warm", writeObjectsToStderr},
-  });
+      return Value{std::make_shared<Date>()};
+    });
 
   std::shared_ptr<Function> fetch = std::make_shared<Function>(
     [](Value self, std::vector<Value> args) -> Value {
@@ -1991,20 +3835,58 @@ Value Value::MakeGlobal() {
       return Value{};
     });
 
+  std::shared_ptr<DictionaryValues> go2cpp = std::make_shared<EventTargetDictionaryValues>(std::map<std::string, Value>{
+    {"getAsset", Value{std::make_shared<Function>(
+      [](Value self, std::vector<Value> args) -> Value {
+        if (args.size() == 0 || !args[0].IsString()) {
+          Panic("go2cpp.getAsset's first argument must be a string");
+        }
+        std::string name = args[0].ToString();
+        if (!Assets::Exists(name)) {
+          return Value::Null();
+        }
+        BytesSpan src = Assets::Get(name);
+        Value u8{std::make_shared<Uint8Array>(src.size())};
+        BytesSpan dst = u8.ToBytes();
+        std::copy(src.begin(), src.end(), dst.begin());
+        return u8;
+      })}},
+    {"getAssetNames", Value{std::make_shared<Function>(
+      [](Value self, std::vector<Value> args) -> Value {
+        std::vector<Value> names;
+        for (const std::string& name : Assets::Names()) {
+          names.push_back(Value{name});
+        }
+        return Value{names};
+      })}},
+  });
+
   static std::shared_ptr<FS> fs = std::make_shared<FS>();
   static std::shared_ptr<Process> process = std::make_shared<Process>();
 
-  std::shared_ptr<DictionaryValues> global = std::make_shared<DictionaryValues>(std::map<std::string, Value>{
+  std::shared_ptr<DictionaryValues> global = std::make_shared<EventTargetDictionaryValues>(std::map<std::string, Value>{
     {"Array", Value{arr}},
     {"Object", Value{obj}},
     {"ArrayBuffer", Value{arrayBuffer}},
-    {"Uint8Array", Value{u8}},
-    {"Float32Array", Value{f32}},
+    {"DataView", Value{dataView}},
+    {"Int8Array", Value{MakeTypedArrayConstructor(TypedArray::Kind::kInt8)}},
+    {"Uint8Array", Value{MakeTypedArrayConstructor(TypedArray::Kind::kUint8)}},
+    {"Uint8ClampedArray", Value{MakeTypedArrayConstructor(TypedArray::Kind::kUint8Clamped)}},
+    {"Int16Array", Value{MakeTypedArrayConstructor(TypedArray::Kind::kInt16)}},
+    {"Uint16Array", Value{MakeTypedArrayConstructor(TypedArray::Kind::kUint16)}},
+    {"Int32Array", Value{MakeTypedArrayConstructor(TypedArray::Kind::kInt32)}},
+    {"Uint32Array", Value{MakeTypedArrayConstructor(TypedArray::Kind::kUint32)}},
+    {"Float32Array", Value{MakeTypedArrayConstructor(TypedArray::Kind::kFloat32)}},
+    {"Float64Array", Value{MakeTypedArrayConstructor(TypedArray::Kind::kFloat64)}},
     {"Date", Value{date}},
-    {"console", Value{console}},
-    {"crypto", Value{crypto}},
+    {"Error", Value{error}},
+    {"JSON", Value{std::make_shared<JSON>()}},
+    {"Promise", Value{std::make_shared<PromiseConstructor>()}},
+    {"TextDecoder", Value{textDecoder}},
+    {"TextEncoder", Value{textEncoder}},
     {"fetch", Value{fetch}},
     {"fs", Value{fs}},
+    {"go2cpp", Value{go2cpp}},
     {"process", Value{process}},
   });
 
@@ -2066,44 +3948,36 @@ void Value::ReflectDelete(Value target, const std::string& key) {
 
 Value Value::ReflectConstruct(Value target, std::vector<Value> args) {
   if (target.IsUndefined()) {
-    Panic("new on undefined is forbidden");
-    return Value{};
+    throw Exception{"TypeError", "new on undefined is forbidden"};
   }
   if (target.IsNull()) {
-    Panic("new on null is forbidden");
-    return Value{};
+    throw Exception{"TypeError", "new on null is forbidden"};
   }
   if (target.IsObject()) {
     Object& t = target.ToObject();
     if (!t.IsConstructor()) {
-      Panic(t.ToString() + " is not a constructor");
-      return Value{};
+      throw Exception{"TypeError", t.ToString() + " is not a constructor"};
     }
     return t.New(args);
   }
-  Panic("new " + target.Inspect() + "(" + JoinObjects(args) + ") cannot be called");
-  return Value{};
+  throw Exception{"TypeError", "new " + target.Inspect() + "(" + JoinObjects(args) + ") cannot be called"};
 }
 
 Value Value::ReflectApply(Value target, Value self, std::vector<Value> args) {
   if (target.IsUndefined()) {
-    Panic("apply on undefined is forbidden");
-    return Value{};
+    throw Exception{"TypeError", "apply on undefined is forbidden"};
   }
   if (target.IsNull()) {
-    Panic("apply on null is forbidden");
-    return Value{};
+    throw Exception{"TypeError", "apply on null is forbidden"};
   }
   if (target.IsObject()) {
     Object& t = target.ToObject();
     if (t.IsConstructor()) {
-      Panic(t.ToString() + " is a constructor");
-      return Value{};
+      throw Exception{"TypeError", t.ToString() + " is a constructor"};
     }
     return t.Invoke(self, args);
   }
-  Panic(target.Inspect() + "(" + JoinObjects(args) + ") cannot be called");
-  return Value{};
+  throw Exception{"TypeError", target.Inspect() + "(" + JoinObjects(args) + ") cannot be called"};
 }
 
 Constructor::Constructor(const std::string& name, Object::Func fn)
@@ -2119,5 +3993,237 @@ std::string Constructor::ToString() const {
   return name_;
 }
 
+std::string Constructor::Inspect() const {
+  return "[Function: " + name_ + "]";
+}
+
+Error::Error(const std::string& message)
+    : Error{"Error", message} {
+}
+
+Error::Error(const std::string& name, const std::string& message)
+    : name_{name},
+      message_{message} {
+}
+
+Value Error::Get(const std::string& key) {
+  if (key == "name") {
+    return Value{name_};
+  }
+  if (key == "message") {
+    return Value{message_};
+  }
+  auto it = props_.find(key);
+  if (it != props_.end()) {
+    return it->second;
+  }
+  return Value{};
+}
+
+void Error::Set(const std::string& key, Value value) {
+  if (key == "name") {
+    name_ = value.ToString();
+    return;
+  }
+  if (key == "message") {
+    message_ = value.ToString();
+    return;
+  }
+  props_[key] = value;
+}
+
+std::string Error::ToString() const {
+  if (message_.empty()) {
+    return name_;
+  }
+  return name_ + ": " + message_;
+}
+
+std::string Error::Inspect() const {
+  std::string str = ToString();
+  if (props_.empty()) {
+    return str;
+  }
+  InspectScope scope;
+  if (scope.IsTooDeep()) {
+    return str;
+  }
+  str += " { ";
+  for (auto it = props_.begin(); it != props_.end(); ++it) {
+    if (it != props_.begin()) {
+      str += ", ";
+    }
+    str += it->first + ": " + InspectElement(it->second);
+  }
+  str += " }";
+  return str;
+}
+
+Promise::Scheduler Promise::SetScheduler(Scheduler scheduler) {
+  Scheduler prev = std::move(CurrentPromiseScheduler());
+  CurrentPromiseScheduler() = std::move(scheduler);
+  return prev;
+}
+
+void Promise::Resolve(Value value) {
+  if (state_ != State::kPending || locked_) {
+    return;
+  }
+  if (value.IsObject()) {
+    if (&value.ToObject() == this) {
+      Settle(State::kRejected, Value{std::make_shared<Error>("TypeError", "chaining cycle detected for promise")});
+      return;
+    }
+    if (Promise* other = dynamic_cast<Promise*>(&value.ToObject())) {
+      locked_ = true;
+      std::shared_ptr<Promise> self = shared_from_this();
+      other->Then(
+        Value{std::make_shared<Function>(
+          [self](Value, std::vector<Value> args) -> Value {
+            self->Settle(State::kFulfilled, FunctionArg(args, 0));
+            return Value{};
+          })},
+        Value{std::make_shared<Function>(
+          [self](Value, std::vector<Value> args) -> Value {
+            self->Settle(State::kRejected, FunctionArg(args, 0));
+            return Value{};
+          })});
+      return;
+    }
+  }
+  Settle(State::kFulfilled, value);
+}
+
+void Promise::Reject(Value reason) {
+  if (state_ != State::kPending || locked_) {
+    return;
+  }
+  Settle(State::kRejected, reason);
+}
+
+Value Promise::Then(Value on_fulfilled, Value on_rejected) {
+  auto result = std::make_shared<Promise>();
+  reactions_.push_back(Reaction{on_fulfilled, on_rejected, result});
+  if (state_ != State::kPending) {
+    ScheduleReactions();
+  }
+  return Value{result};
+}
+
+Value Promise::Get(const std::string& key) {
+  std::shared_ptr<Promise> self = shared_from_this();
+  if (key == "then") {
+    return Value{std::make_shared<Function>(
+      [self](Value, std::vector<Value> args) -> Value {
+        return self->Then(FunctionArg(args, 0), FunctionArg(args, 1));
+      })};
+  }
+  if (key == "catch") {
+    return Value{std::make_shared<Function>(
+      [self](Value, std::vector<Value> args) -> Value {
+        return self->Then(Value{}, FunctionArg(args, 0));
+      })};
+  }
+  if (key == "finally") {
+    return Value{std::make_shared<Function>(
+      [self](Value, std::vector<Value> args) -> Value {
+        Value f = FunctionArg(args, 0);
+        if (!IsFunctionValue(f)) {
+          return self->Then(f, f);
+        }
+        return self->Then(
+          Value{std::make_shared<Function>(
+            [f](Value, std::vector<Value> args) -> Value {
+              Value::ReflectApply(f, Value{}, {});
+              return FunctionArg(args, 0);
+            })},
+          Value{std::make_shared<Function>(
+            [f](Value, std::vector<Value> args) -> Value {
+              Value::ReflectApply(f, Value{}, {});
+              throw Exception{FunctionArg(args, 0)};
+            })});
+      })};
+  }
+  return Value{};
+}
+
+std::string Promise::ToString() const {
+  return "[object Promise]";
+}
+
+std::string Promise::Inspect() const {
+  switch (state_) {
+  case State::kPending:
+    return "Promise { <pending> }";
+  case State::kFulfilled:
+    return "Promise { " + InspectElement(value_) + " }";
+  case State::kRejected:
+    return "Promise { <rejected> " + InspectElement(value_) + " }";
+  }
+  return ToString();
+}
+
+void Promise::Settle(State state, Value value) {
+  if (state_ != State::kPending) {
+    return;
+  }
+  state_ = state;
+  value_ = value;
+  ScheduleReactions();
+}
+
+void Promise::ScheduleReactions() {
+  std::vector<Reaction> reactions;
+  reactions.swap(reactions_);
+  std::shared_ptr<Promise> self = shared_from_this();
+  for (const Reaction& reaction : reactions) {
+    std::function<void()> task = [self, reaction]() {
+      self->RunReaction(reaction);
+    };
+    if (Scheduler& scheduler = CurrentPromiseScheduler()) {
+      scheduler(std::move(task));
+    } else {
+      task();
+    }
+  }
+}
+
+void Promise::RunReaction(const Reaction& reaction) {
+  Value handler = state_ == State::kFulfilled ? reaction.on_fulfilled : reaction.on_rejected;
+  if (!IsFunctionValue(handler)) {
+    if (state_ == State::kFulfilled) {
+      reaction.result->Resolve(value_);
+    } else {
+      reaction.result->Reject(value_);
+    }
+    return;
+  }
+  Value result;
+  try {
+    result = Value::ReflectApply(handler, Value{}, {value_});
+  } catch (const Exception& e) {
+    reaction.result->Reject(e.GetValue());
+    return;
+  }
+  reaction.result->Resolve(result);
+}
+
+Exception::Exception(Value value)
+    : value_{value},
+      what_{value.IsObject() ? value.ToObject().ToString() : value.Inspect()} {
+}
+
+Exception::Exception(const std::string& name, const std::string& message)
+    : Exception{Value{std::make_shared<Error>(name, message)}} {
+}
+
+const Value& Exception::GetValue() const {
+  return value_;
+}
+
+const char* Exception::what() const noexcept {
+  return what_.c_str();
+}
+
 }
 `))
@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// libraryImportFuncBodies are the imports that Library implements. The functions take the stack pointer in Go's ABI.
+var libraryImportFuncBodies = map[string]string{
+	// func wasmExit(code int32)
+	"runtime.wasmExit": `  library_->exit_code_ = library_->mem_->LoadInt32(local0_ + 8);
+  library_->exited_ = true;`,
+
+	// func wasmWrite(fd uintptr, p unsafe.Pointer, n int32)
+	"runtime.wasmWrite": `  int64_t fd = library_->mem_->LoadInt64(local0_ + 8);
+  if (fd != 1 && fd != 2) {
+    Trap("fd for runtime.wasmWrite must be 1 or 2 but " + std::to_string(fd));
+  }
+  int64_t p = library_->mem_->LoadInt64(local0_ + 16);
+  int32_t n = library_->mem_->LoadInt32(local0_ + 24);
+  library_->DebugWrite(library_->mem_->LoadSliceDirectly(p, n));`,
+}
+
+// libraryImports returns the imports with the bodies for Library, and reports whether Library implements all of
+// importFuncs.
+func libraryImports(importFuncs []*wasmFunc) ([]*wasmFunc, bool) {
+	var fs []*wasmFunc
+	for _, f := range importFuncs {
+		body, ok := libraryImportFuncBodies[f.Wasm.Name]
+		if !ok || sigString(f.Wasm.Sig) != "(i32) -> ()" {
+			return nil, false
+		}
+		lf := *f
+		lf.BodyStr = body
+		fs = append(fs, &lf)
+	}
+	return fs, true
+}
+
+// libraryExport is a function of Library calling an export of Inst.
+type libraryExport struct {
+	Name       string
+	ReturnType string
+	Return     string
+	Params     string
+	Args       string
+}
+
+func newLibraryExport(e *wasmExport) (*libraryExport, error) {
+	f := e.Funcs[e.Index]
+
+	l := &libraryExport{
+		Name: e.cppName,
+	}
+	switch ts := f.Wasm.Sig.ReturnTypes; len(ts) {
+	case 0:
+		l.ReturnType = returnTypeVoid.Cpp()
+	case 1:
+		l.ReturnType = wasmTypeToReturnType(ts[0]).Cpp()
+		l.Return = "return "
+	default:
+		return nil, fmt.Errorf("the number of return values must be 0 or 1 but %d", len(ts))
+	}
+
+	var params, args []string
+	for i, t := range f.Wasm.Sig.ParamTypes {
+		params = append(params, fmt.Sprintf("%s arg%d", wasmTypeToReturnType(t).Cpp(), i))
+		args = append(args, fmt.Sprintf("arg%d", i))
+	}
+	l.Params = strings.Join(params, ", ")
+	l.Args = strings.Join(args, ", ")
+	return l, nil
+}
+
+func writeLibrary(dir string, incpath string, namespace string, exportMacro string, importFuncs []*wasmFunc, exports []*wasmExport) error {
+	sort.Slice(exports, func(a, b int) bool {
+		return exports[a].cppName < exports[b].cppName
+	})
+	var les []*libraryExport
+	for _, e := range exports {
+		l, err := newLibraryExport(e)
+		if err != nil {
+			return err
+		}
+		les = append(les, l)
+	}
+
+	{
+		f, err := os.Create(filepath.Join(dir, "library.h"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := libraryHTmpl.Execute(f, struct {
+			IncludeGuard string
+			VersionCheck string
+			IncludePath  string
+			Namespace    string
+			Export       string
+			ImportFuncs  []*wasmFunc
+			Exports      []*libraryExport
+		}{
+			IncludeGuard: includeGuard(namespace) + "_LIBRARY_H",
+			VersionCheck: versionCheck(namespace, "library.h"),
+			IncludePath:  incpath,
+			Namespace:    namespace,
+			Export:       exportPrefix(exportMacro),
+			ImportFuncs:  importFuncs,
+			Exports:      les,
+		}); err != nil {
+			return err
+		}
+	}
+	{
+		f, err := os.Create(filepath.Join(dir, "library.cpp"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := libraryCppTmpl.Execute(f, struct {
+			IncludePath string
+			Namespace   string
+			ImportFuncs []*wasmFunc
+			Exports     []*libraryExport
+		}{
+			IncludePath: incpath,
+			Namespace:   namespace,
+			ImportFuncs: importFuncs,
+			Exports:     les,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var libraryHTmpl = template.Must(template.New("library.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include "{{.IncludePath}}bytes.h"
+#include "{{.IncludePath}}config.h"
+#include "{{.IncludePath}}inst.h"
+
+#include <cstdint>
+#include <memory>
+#include <vector>
+
+{{.VersionCheck}}
+
+namespace {{.Namespace}} {
+
+class Mem;
+
+// Library runs a module without the Go runtime, e.g., a TinyGo program built with -scheduler=none, and calls its
+// exports as functions. There are no JavaScript values, task queues or timers: the module can import only
+// runtime.wasmExit and runtime.wasmWrite, and the exports run on the caller's thread.
+class {{.Export}}Library {
+public:
+  Library();
+  ~Library();
+
+  Library(const Library&) = delete;
+  Library& operator=(const Library&) = delete;
+
+{{range $value := .Exports}}  {{.ReturnType}} {{.Name}}({{.Params}});
+{{end}}
+  // Exited reports whether the module called runtime.wasmExit. After that, the exports must not be called.
+  bool Exited() const;
+
+  // ExitCode returns the code given to runtime.wasmExit.
+  int32_t ExitCode() const;
+
+private:
+  class ImportImpl : public Import {
+  public:
+    explicit ImportImpl(Library* library);
+
+{{range $value := .ImportFuncs}}{{$value.CppDecl "    " false true}}
+
+{{end}}
+  private:
+    Library* library_;
+  };
+
+  void CheckExited() const;
+
+  // DebugWrite writes the lines of the debug output with Log in log.h.
+  void DebugWrite(BytesSpan bytes);
+
+  std::unique_ptr<Mem> mem_;
+  ImportImpl import_;
+  std::unique_ptr<Inst> inst_;
+  bool exited_ = false;
+  int32_t exit_code_ = 0;
+  std::vector<uint8_t> debug_buffer_;
+};
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
+
+var libraryCppTmpl = template.Must(template.New("library.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}library.h"
+
+#include "{{.IncludePath}}bits.h"
+#include "{{.IncludePath}}log.h"
+#include "{{.IncludePath}}mem.h"
+
+#include <algorithm>
+#include <string>
+
+namespace {{.Namespace}} {
+
+Library::Library()
+    : mem_{std::make_unique<Mem>()},
+      import_{this},
+      inst_{std::make_unique<Inst>(mem_.get(), &import_)} {
+}
+
+Library::~Library() = default;
+
+{{range $value := .Exports}}{{.ReturnType}} Library::{{.Name}}({{.Params}}) {
+  CheckExited();
+  {{.Return}}inst_->{{.Name}}({{.Args}});
+}
+
+{{end}}bool Library::Exited() const {
+  return exited_;
+}
+
+int32_t Library::ExitCode() const {
+  return exit_code_;
+}
+
+void Library::CheckExited() const {
+  if (exited_) {
+    Trap("the module has already exited with code " + std::to_string(exit_code_));
+  }
+}
+
+void Library::DebugWrite(BytesSpan bytes) {
+  debug_buffer_.insert(debug_buffer_.end(), bytes.begin(), bytes.end());
+  for (;;) {
+    auto it = std::find(debug_buffer_.begin(), debug_buffer_.end(), '\n');
+    if (it == debug_buffer_.end()) {
+      break;
+    }
+    Log(LogLevel::kDebug, std::string(debug_buffer_.begin(), it));
+    debug_buffer_.erase(debug_buffer_.begin(), it + 1);
+  }
+}
+
+Library::ImportImpl::ImportImpl(Library* library)
+    : library_{library} {
+}
+
+{{range $value := .ImportFuncs}}{{$value.CppImpl "Library::ImportImpl" ""}}
+{{end}}}
+`))
@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+func writeLog(dir string, incpath string, namespace string, exportMacro string) error {
+	{
+		f, err := os.Create(filepath.Join(dir, "log.h"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := logHTmpl.Execute(f, struct {
+			IncludeGuard string
+			VersionCheck string
+			IncludePath  string
+			Namespace    string
+			Export       string
+		}{
+			IncludeGuard: includeGuard(namespace) + "_LOG_H",
+			VersionCheck: versionCheck(namespace, "log.h"),
+			IncludePath:  incpath,
+			Namespace:    namespace,
+			Export:       exportPrefix(exportMacro),
+		}); err != nil {
+			return err
+		}
+	}
+	{
+		f, err := os.Create(filepath.Join(dir, "log.cpp"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := logCppTmpl.Execute(f, struct {
+			IncludePath string
+			Namespace   string
+		}{
+			IncludePath: incpath,
+			Namespace:   namespace,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var logHTmpl = template.Must(template.New("log.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include "{{.IncludePath}}config.h"
+
+#include <string>
+
+{{.VersionCheck}}
+
+namespace {{.Namespace}} {
+
+enum class LogLevel {
+  kDebug,
+  kInfo,
+  kWarning,
+  kError,
+};
+
+// Logger is the destination of all the messages of the generated code, e.g., errors, traps, the debug output of the
+// Go runtime and JavaScript's console.
+class {{.Export}}Logger {
+public:
+  virtual ~Logger();
+  virtual void Log(LogLevel level, const std::string& message) = 0;
+};
+
+// DefaultLogger writes the messages with the platform's logging: __android_log_print on Android, os_log on iOS,
+// OutputDebugString and the standard error on Windows, and the standard streams otherwise.
+// On Android, liblog is required.
+class {{.Export}}DefaultLogger : public Logger {
+public:
+  void Log(LogLevel level, const std::string& message) override;
+};
+
+// SetLogger sets the logger of the generated code. If logger is nullptr, a DefaultLogger is used.
+// logger must be alive until another logger is set.
+{{.Export}}void SetLogger(Logger* logger);
+
+// Log writes message with the current logger.
+{{.Export}}void Log(LogLevel level, const std::string& message);
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
+
+var logCppTmpl = template.Must(template.New("log.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}log.h"
+
+#include <atomic>
+#include <iostream>
+
+#if defined(__ANDROID__)
+#include <android/log.h>
+#elif defined(__APPLE__)
+#include <TargetConditionals.h>
+#if TARGET_OS_IPHONE
+#include <os/log.h>
+#endif
+#elif defined(_WIN32)
+#include <windows.h>
+#endif
+
+namespace {{.Namespace}} {
+
+namespace {
+
+std::atomic<Logger*> current_logger{nullptr};
+
+}
+
+Logger::~Logger() = default;
+
+void DefaultLogger::Log(LogLevel level, const std::string& message) {
+#if defined(__ANDROID__)
+  int prio = ANDROID_LOG_INFO;
+  switch (level) {
+  case LogLevel::kDebug:
+    prio = ANDROID_LOG_DEBUG;
+    break;
+  case LogLevel::kInfo:
+    prio = ANDROID_LOG_INFO;
+    break;
+  case LogLevel::kWarning:
+    prio = ANDROID_LOG_WARN;
+    break;
+  case LogLevel::kError:
+    prio = ANDROID_LOG_ERROR;
+    break;
+  }
+  __android_log_print(prio, "go2cpp", "%s", message.c_str());
+#elif defined(__APPLE__) && TARGET_OS_IPHONE
+  os_log_type_t type = OS_LOG_TYPE_DEFAULT;
+  switch (level) {
+  case LogLevel::kDebug:
+    type = OS_LOG_TYPE_DEBUG;
+    break;
+  case LogLevel::kInfo:
+    type = OS_LOG_TYPE_INFO;
+    break;
+  case LogLevel::kWarning:
+    type = OS_LOG_TYPE_DEFAULT;
+    break;
+  case LogLevel::kError:
+    type = OS_LOG_TYPE_ERROR;
+    break;
+  }
+  os_log_with_type(OS_LOG_DEFAULT, type, "%{public}s", message.c_str());
+#else
+#if defined(_WIN32)
+  // The standard error is invisible in GUI applications.
+  OutputDebugStringA((message + "\n").c_str());
+#endif
+  switch (level) {
+  case LogLevel::kDebug:
+  case LogLevel::kWarning:
+  case LogLevel::kError:
+    std::cerr << message << std::endl;
+    break;
+  case LogLevel::kInfo:
+    std::cout << message << std::endl;
+    break;
+  }
+#endif
+}
+
+void SetLogger(Logger* logger) {
+  current_logger = logger;
+}
+
+void Log(LogLevel level, const std::string& message) {
+  if (Logger* logger = current_logger) {
+    logger->Log(level, message);
+    return;
+  }
+  static DefaultLogger default_logger;
+  default_logger.Log(level, message);
+}
+
+}
+`))
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// writeMath writes math.h. There is no math.cpp: the functions of Math are defined in math.h so that they are inlined
+// into the translated functions.
+func writeMath(dir string, incpath string, namespace string) error {
+	f, err := os.Create(filepath.Join(dir, "math.h"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := mathHTmpl.Execute(f, struct {
+		IncludeGuard string
+		VersionCheck string
+		IncludePath  string
+		Namespace    string
+	}{
+		IncludeGuard: includeGuard(namespace) + "_MATH_H",
+		VersionCheck: versionCheck(namespace, "math.h"),
+		IncludePath:  incpath,
+		Namespace:    namespace,
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+var mathHTmpl = template.Must(template.New("math.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include "{{.IncludePath}}bits.h"
+#include "{{.IncludePath}}config.h"
+
+#include <cmath>
+#include <cstdint>
+#include <limits>
+
+{{.VersionCheck}}
+
+namespace {{.Namespace}} {
+
+class Math {
+public:
+  // Round rounds x to the nearest integer, rounding half to even, as WebAssembly's nearest does.
+  // The sign of zero and NaN are kept as they are.
+
+  static inline float Round(float x) {
+    // std::rint is also available, but this requires setting a global state by std::fesetround.
+    float r = std::round(x);
+    if (std::abs(x - r) == 0.5f && std::fmod(r, 2.0f) != 0.0f) {
+      return std::trunc(x);
+    }
+    return r;
+  }
+
+  static inline double Round(double x) {
+    double r = std::round(x);
+    if (std::abs(x - r) == 0.5 && std::fmod(r, 2.0) != 0.0) {
+      return std::trunc(x);
+    }
+    return r;
+  }
+
+  // Min and Max follow WebAssembly's f32.min, f32.max, f64.min and f64.max: if either operand is NaN, the result is
+  // the canonical NaN, and -0 is treated as less than +0. std::min and std::max return either operand in these cases.
+
+  static inline float Min(float x, float y) {
+    if (std::isnan(x) || std::isnan(y)) {
+      return std::numeric_limits<float>::quiet_NaN();
+    }
+    if (x == y) {
+      return std::signbit(x) ? x : y;
+    }
+    return x < y ? x : y;
+  }
+
+  static inline double Min(double x, double y) {
+    if (std::isnan(x) || std::isnan(y)) {
+      return std::numeric_limits<double>::quiet_NaN();
+    }
+    if (x == y) {
+      return std::signbit(x) ? x : y;
+    }
+    return x < y ? x : y;
+  }
+
+  static inline float Max(float x, float y) {
+    if (std::isnan(x) || std::isnan(y)) {
+      return std::numeric_limits<float>::quiet_NaN();
+    }
+    if (x == y) {
+      return std::signbit(x) ? y : x;
+    }
+    return x > y ? x : y;
+  }
+
+  static inline double Max(double x, double y) {
+    if (std::isnan(x) || std::isnan(y)) {
+      return std::numeric_limits<double>::quiet_NaN();
+    }
+    if (x == y) {
+      return std::signbit(x) ? y : x;
+    }
+    return x > y ? x : y;
+  }
+
+  // Copysign only modifies the sign bit, even when x or y is NaN.
+
+  static inline float Copysign(float x, float y) {
+    return std::copysign(x, y);
+  }
+
+  static inline double Copysign(double x, double y) {
+    return std::copysign(x, y);
+  }
+
+  // The truncation functions trap in the same way as WebAssembly when x is NaN or out of the range of the result type.
+  // static_cast truncates x toward zero.
+  // Define GO2CPP_UNCHECKED_TRUNCATION to skip the checks, whose results are undefined in the trapping cases.
+
+  static inline int32_t TruncToInt32(float x) {
+#ifndef GO2CPP_UNCHECKED_TRUNCATION
+    if (GO2CPP_UNLIKELY(std::isnan(x))) {
+      Trap("invalid conversion to integer");
+    }
+    if (GO2CPP_UNLIKELY(!(x >= -2147483648.0f && x < 2147483648.0f))) {
+      Trap("integer overflow");
+    }
+#endif
+    return static_cast<int32_t>(x);
+  }
+
+  static inline int32_t TruncToInt32(double x) {
+#ifndef GO2CPP_UNCHECKED_TRUNCATION
+    if (GO2CPP_UNLIKELY(std::isnan(x))) {
+      Trap("invalid conversion to integer");
+    }
+    if (GO2CPP_UNLIKELY(!(x > -2147483649.0 && x < 2147483648.0))) {
+      Trap("integer overflow");
+    }
+#endif
+    return static_cast<int32_t>(x);
+  }
+
+  static inline uint32_t TruncToUint32(float x) {
+#ifndef GO2CPP_UNCHECKED_TRUNCATION
+    if (GO2CPP_UNLIKELY(std::isnan(x))) {
+      Trap("invalid conversion to integer");
+    }
+    if (GO2CPP_UNLIKELY(!(x > -1.0f && x < 4294967296.0f))) {
+      Trap("integer overflow");
+    }
+#endif
+    return static_cast<uint32_t>(x);
+  }
+
+  static inline uint32_t TruncToUint32(double x) {
+#ifndef GO2CPP_UNCHECKED_TRUNCATION
+    if (GO2CPP_UNLIKELY(std::isnan(x))) {
+      Trap("invalid conversion to integer");
+    }
+    if (GO2CPP_UNLIKELY(!(x > -1.0 && x < 4294967296.0))) {
+      Trap("integer overflow");
+    }
+#endif
+    return static_cast<uint32_t>(x);
+  }
+
+  static inline int64_t TruncToInt64(float x) {
+#ifndef GO2CPP_UNCHECKED_TRUNCATION
+    if (GO2CPP_UNLIKELY(std::isnan(x))) {
+      Trap("invalid conversion to integer");
+    }
+    if (GO2CPP_UNLIKELY(!(x >= -9223372036854775808.0f && x < 9223372036854775808.0f))) {
+      Trap("integer overflow");
+    }
+#endif
+    return static_cast<int64_t>(x);
+  }
+
+  static inline int64_t TruncToInt64(double x) {
+#ifndef GO2CPP_UNCHECKED_TRUNCATION
+    if (GO2CPP_UNLIKELY(std::isnan(x))) {
+      Trap("invalid conversion to integer");
+    }
+    if (GO2CPP_UNLIKELY(!(x >= -9223372036854775808.0 && x < 9223372036854775808.0))) {
+      Trap("integer overflow");
+    }
+#endif
+    return static_cast<int64_t>(x);
+  }
+
+  static inline uint64_t TruncToUint64(float x) {
+#ifndef GO2CPP_UNCHECKED_TRUNCATION
+    if (GO2CPP_UNLIKELY(std::isnan(x))) {
+      Trap("invalid conversion to integer");
+    }
+    if (GO2CPP_UNLIKELY(!(x > -1.0f && x < 18446744073709551616.0f))) {
+      Trap("integer overflow");
+    }
+#endif
+    return static_cast<uint64_t>(x);
+  }
+
+  static inline uint64_t TruncToUint64(double x) {
+#ifndef GO2CPP_UNCHECKED_TRUNCATION
+    if (GO2CPP_UNLIKELY(std::isnan(x))) {
+      Trap("invalid conversion to integer");
+    }
+    if (GO2CPP_UNLIKELY(!(x > -1.0 && x < 18446744073709551616.0))) {
+      Trap("integer overflow");
+    }
+#endif
+    return static_cast<uint64_t>(x);
+  }
+};
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
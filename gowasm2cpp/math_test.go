@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const mathTestCpp = `#include "bits.h"
+#include "math.h"
+
+#include <cmath>
+#include <cstdio>
+#include <cstring>
+
+using namespace go2cpp_test;
+
+namespace {
+
+int failures = 0;
+
+void Check(bool ok, const char* expr, int line) {
+  if (!ok) {
+    std::printf("line %d: %s\n", line, expr);
+    failures++;
+  }
+}
+
+template<typename T>
+bool SameBits(T x, T y) {
+  return std::memcmp(&x, &y, sizeof(T)) == 0;
+}
+
+}
+
+#define CHECK(x) Check((x), #x, __LINE__)
+
+int main() {
+  CHECK(Bits::LeadingZeros(static_cast<uint32_t>(0)) == 32);
+  CHECK(Bits::LeadingZeros(static_cast<uint32_t>(1)) == 31);
+  CHECK(Bits::LeadingZeros(static_cast<uint32_t>(0x80000000u)) == 0);
+  CHECK(Bits::LeadingZeros(static_cast<uint64_t>(0)) == 64);
+  CHECK(Bits::LeadingZeros(static_cast<uint64_t>(1)) == 63);
+  CHECK(Bits::TrailingZeros(static_cast<uint32_t>(0)) == 32);
+  CHECK(Bits::TrailingZeros(static_cast<uint32_t>(0x80000000u)) == 31);
+  CHECK(Bits::TrailingZeros(static_cast<uint64_t>(0)) == 64);
+  CHECK(Bits::TrailingZeros(static_cast<uint64_t>(0x8000000000000000ull)) == 63);
+  CHECK(Bits::OnesCount(static_cast<uint32_t>(0xffffffffu)) == 32);
+  CHECK(Bits::OnesCount(static_cast<uint64_t>(0xf0f0f0f0f0f0f0f0ull)) == 32);
+
+  CHECK(Bits::RotateLeft(static_cast<uint32_t>(0x80000001u), 0) == 0x80000001u);
+  CHECK(Bits::RotateLeft(static_cast<uint32_t>(0x80000001u), 1) == 3u);
+  CHECK(Bits::RotateLeft(static_cast<uint32_t>(0x80000001u), -1) == 0xc0000000u);
+  CHECK(Bits::RotateLeft(static_cast<uint64_t>(1), 64) == 1ull);
+
+  CHECK(Bits::BitCast<uint32_t>(1.0f) == 0x3f800000u);
+  CHECK(SameBits(Bits::BitCast<double>(static_cast<uint64_t>(0x8000000000000000ull)), -0.0));
+
+  CHECK(Bits::DivS(static_cast<int32_t>(-7), static_cast<int32_t>(2)) == -3);
+  CHECK(Bits::RemS(static_cast<int32_t>(-7), static_cast<int32_t>(2)) == -1);
+  CHECK(Bits::RemS(std::numeric_limits<int64_t>::min(), static_cast<int64_t>(-1)) == 0);
+
+  CHECK(SameBits(Math::Round(0.5), 0.0));
+  CHECK(SameBits(Math::Round(1.5), 2.0));
+  CHECK(SameBits(Math::Round(2.5), 2.0));
+  CHECK(SameBits(Math::Round(-0.5), -0.0));
+  CHECK(SameBits(Math::Round(-2.5), -2.0));
+  CHECK(SameBits(Math::Round(-0.0), -0.0));
+  CHECK(SameBits(Math::Round(2.5f), 2.0f));
+  CHECK(SameBits(Math::Round(-3.5f), -4.0f));
+  CHECK(std::isnan(Math::Round(std::nan(""))));
+
+  CHECK(SameBits(Math::Min(0.0, -0.0), -0.0));
+  CHECK(SameBits(Math::Min(-0.0, 0.0), -0.0));
+  CHECK(SameBits(Math::Max(0.0, -0.0), 0.0));
+  CHECK(SameBits(Math::Max(-0.0, 0.0), 0.0));
+  CHECK(SameBits(Math::Min(-0.0f, 0.0f), -0.0f));
+  CHECK(SameBits(Math::Max(-0.0f, 0.0f), 0.0f));
+  CHECK(std::isnan(Math::Min(1.0, std::nan(""))));
+  CHECK(std::isnan(Math::Max(std::nan(""), 1.0)));
+  CHECK(std::isnan(Math::Min(std::nanf(""), 1.0f)));
+  CHECK(Math::Min(1.0, 2.0) == 1.0);
+  CHECK(Math::Max(1.0f, 2.0f) == 2.0f);
+  CHECK(SameBits(Math::Copysign(1.0, -0.0), -1.0));
+  CHECK(std::signbit(Math::Copysign(std::nan(""), -1.0)));
+
+  CHECK(Math::TruncToInt32(-2147483648.9) == std::numeric_limits<int32_t>::min());
+  CHECK(Math::TruncToUint32(-0.9) == 0u);
+  CHECK(Math::TruncToUint64(18446744073709549568.0) == 18446744073709549568ull);
+
+  return failures == 0 ? 0 : 1;
+}
+`
+
+// TestMathHelpers compiles the generated Bits and Math helpers with a C++ compiler and checks that they follow
+// WebAssembly's semantics.
+func TestMathHelpers(t *testing.T) {
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("C++ compiler not found")
+	}
+
+	for _, std := range []string{"c++14", "c++20"} {
+		std := std
+		t.Run(std, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := writeConfig(dir, "", "go2cpp_test", std, "", false); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeBits(dir, "", "go2cpp_test"); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeMath(dir, "", "go2cpp_test"); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeLog(dir, "", "go2cpp_test", ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "test.cpp"), []byte(mathTestCpp), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			bin := filepath.Join(dir, "test")
+			cmd := exec.Command(cxx, "-std="+std, "-o", bin, "test.cpp", "bits.cpp", "log.cpp")
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("compiling failed: %v\n%s", err, out)
+			}
+			if out, err := exec.Command(bin).CombinedOutput(); err != nil {
+				t.Errorf("%v\n%s", err, out)
+			}
+		})
+	}
+}
@@ -25,11 +25,13 @@ func writeMem(dir string, incpath string, namespace string, initPageNum int, dat
 
 		if err := memHTmpl.Execute(f, struct {
 			IncludeGuard string
+			VersionCheck string
 			IncludePath  string
 			Namespace    string
 			PageSize     int
 		}{
 			IncludeGuard: includeGuard(namespace) + "_MEM_H",
+			VersionCheck: versionCheck(namespace, "mem.h"),
 			IncludePath:  incpath,
 			Namespace:    namespace,
 			PageSize:     pageSize,
@@ -74,80 +76,126 @@ var memHTmpl = template.Must(template.New("mem.h").Parse(`// Code generated by g
 #define {{.IncludeGuard}}
 
 #include "{{.IncludePath}}bytes.h"
+#include "{{.IncludePath}}config.h"
 
 #include <cstdint>
+#include <functional>
 #include <string>
 #include <vector>
 
+#if defined(GO2CPP_MEM_STATS)
+#  define GO2CPP_MEM_RECORD(kind, addr) RecordAccess(AccessKind::kind, addr)
+#else
+#  define GO2CPP_MEM_RECORD(kind, addr)
+#endif
+
+{{.VersionCheck}}
+
 namespace {{.Namespace}} {
 
 class Mem {
 public:
   static constexpr int32_t kPageSize = {{.PageSize}};
 
+  enum class AccessKind {
+    kLoad8,
+    kLoad16,
+    kLoad32,
+    kLoad64,
+    kStore8,
+    kStore16,
+    kStore32,
+    kStore64,
+    kNum,
+  };
+
   Mem();
   ~Mem();
 
   int32_t GetSize() const;
   int32_t Grow(int32_t delta);
 
+  // Save and Restore are used to take and restore a snapshot.
+  std::vector<uint8_t> Save() const;
+  void Restore(const std::vector<uint8_t>& bytes);
+
+  // GetView returns all the bytes of the memory. The view is invalidated when the size changes.
+  BytesSpan GetView();
+
+  // SetResizeCallback sets a function called after the size changes by Grow or Restore.
+  void SetResizeCallback(std::function<void()> callback);
   inline int8_t LoadInt8(int32_t addr) const {
+    GO2CPP_MEM_RECORD(kLoad8, addr);
     return static_cast<int8_t>(*(bytes_ + addr));
   }
 
   inline uint8_t LoadUint8(int32_t addr) const {
+    GO2CPP_MEM_RECORD(kLoad8, addr);
     return *(bytes_ + addr);
   }
 
   inline int16_t LoadInt16(int32_t addr) const {
+    GO2CPP_MEM_RECORD(kLoad16, addr);
     return *(reinterpret_cast<const int16_t*>(bytes_ + addr));
   }
 
   inline uint16_t LoadUint16(int32_t addr) const {
+    GO2CPP_MEM_RECORD(kLoad16, addr);
     return *(reinterpret_cast<const uint16_t*>(bytes_ + addr));
   }
 
   inline int32_t LoadInt32(int32_t addr) const {
+    GO2CPP_MEM_RECORD(kLoad32, addr);
     return *(reinterpret_cast<const int32_t*>(bytes_ + addr));
   }
 
   inline uint32_t LoadUint32(int32_t addr) const {
+    GO2CPP_MEM_RECORD(kLoad32, addr);
     return *(reinterpret_cast<const uint32_t*>(bytes_ + addr));
   }
 
   inline int64_t LoadInt64(int32_t addr) const {
+    GO2CPP_MEM_RECORD(kLoad64, addr);
     return *(reinterpret_cast<const int64_t*>(bytes_ + addr));
   }
 
   inline float LoadFloat32(int32_t addr) const {
+    GO2CPP_MEM_RECORD(kLoad32, addr);
     return *(reinterpret_cast<const float*>(bytes_ + addr));
   }
 
   inline double LoadFloat64(int32_t addr) const {
+    GO2CPP_MEM_RECORD(kLoad64, addr);
     return *(reinterpret_cast<const double*>(bytes_ + addr));
   }
 
   inline void StoreInt8(int32_t addr, int8_t val) {
+    GO2CPP_MEM_RECORD(kStore8, addr);
     *(bytes_ + addr) = static_cast<uint8_t>(val);
   }
 
   inline void StoreInt16(int32_t addr, int16_t val) {
+    GO2CPP_MEM_RECORD(kStore16, addr);
     *(reinterpret_cast<int16_t*>(bytes_ + addr)) = val;
   }
 
   inline void StoreInt32(int32_t addr, int32_t val) {
+    GO2CPP_MEM_RECORD(kStore32, addr);
     *(reinterpret_cast<int32_t*>(bytes_ + addr)) = val;
   }
 
   inline void StoreInt64(int32_t addr, int64_t val) {
+    GO2CPP_MEM_RECORD(kStore64, addr);
     *(reinterpret_cast<int64_t*>(bytes_ + addr)) = val;
   }
 
   inline void StoreFloat32(int32_t addr, float val) {
+    GO2CPP_MEM_RECORD(kStore32, addr);
     *(reinterpret_cast<float*>(bytes_ + addr)) = val;
   }
 
   inline void StoreFloat64(int32_t addr, double val) {
+    GO2CPP_MEM_RECORD(kStore64, addr);
     *(reinterpret_cast<double*>(bytes_ + addr)) = val;
   }
 
@@ -162,12 +210,41 @@ public:
   void Memmove(int32_t dst, int32_t src, int32_t count);
   void Memset(int32_t dst, uint8_t ch, int32_t count);
 
+  // CompareBytes compares the bytes of the strings or the slices whose headers are at a and b like bytes.Compare.
+  int64_t CompareBytes(int32_t a, int32_t b);
+
+  // IndexByte returns the index of the first c in the string or the slice whose header is at addr, or -1.
+  int64_t IndexByte(int32_t addr, uint8_t c);
+
+#if defined(GO2CPP_MEM_STATS)
+  // FormatAccessStats returns the numbers of the loads and the stores for each size, the bytes accessed by the bulk
+  // operations like Memmove, and the most accessed pages. With GO2CPP_MEM_STATS, the stats are logged when Mem is
+  // destructed.
+  std::string FormatAccessStats() const;
+#endif
+
 private:
   Mem(const Mem&) = delete;
   Mem& operator=(const Mem&) = delete;
 
+#if defined(GO2CPP_MEM_STATS)
+  inline void RecordAccess(AccessKind kind, int32_t addr) const {
+    access_counts_[static_cast<int>(kind)]++;
+    page_access_counts_[static_cast<uint32_t>(addr) / kPageSize]++;
+  }
+
+  void RecordBulkAccess(int32_t addr, int32_t count, bool store) const;
+
+  // The counters are mutable as the loads are const.
+  mutable uint64_t access_counts_[static_cast<int>(AccessKind::kNum)] = {};
+  mutable uint64_t bulk_loaded_bytes_ = 0;
+  mutable uint64_t bulk_stored_bytes_ = 0;
+  mutable std::vector<uint64_t> page_access_counts_;
+#endif
+
   uint8_t* bytes_;
   size_t size_ = 0;
+  std::function<void()> resize_callback_;
 };
 
 }
@@ -186,6 +263,13 @@ var memCppTmpl = template.Must(template.New("mem.cpp").Funcs(template.FuncMap{
 #include <algorithm>
 #include <cstring>
 
+#if defined(GO2CPP_MEM_STATS)
+#include "{{.IncludePath}}log.h"
+
+#include <iomanip>
+#include <sstream>
+#endif
+
 namespace {{.Namespace}} {
 
 namespace {
@@ -213,6 +297,10 @@ const WasmData initial_data_info_[] = {
 Mem::Mem()
     : size_({{.InitPageNum}} * kPageSize) {
   bytes_ = reinterpret_cast<uint8_t*>(std::calloc(1, kMaxMemorySize));
+#if defined(GO2CPP_MEM_STATS)
+  // An address is an unsigned 32-bit integer.
+  page_access_counts_.resize((1ull << 32) / kPageSize);
+#endif
   constexpr int32_t info_size = sizeof(initial_data_info_) / sizeof(initial_data_info_[0]);
   int32_t src_offset = 0;
   for (int32_t i = 0; i < info_size; i++) {
@@ -223,6 +311,9 @@ Mem::Mem()
 }
 
 Mem::~Mem() {
+#if defined(GO2CPP_MEM_STATS)
+  Log(LogLevel::kInfo, FormatAccessStats());
+#endif
   std::free(bytes_);
 }
 
@@ -233,10 +324,40 @@ int32_t Mem::GetSize() const {
 int32_t Mem::Grow(int32_t delta) {
   int prev_page_num = GetSize();
   size_ = std::min(static_cast<size_t>((prev_page_num + delta) * kPageSize), kMaxMemorySize);
+  if (resize_callback_ && GetSize() != prev_page_num) {
+    resize_callback_();
+  }
   return prev_page_num;
 }
 
+std::vector<uint8_t> Mem::Save() const {
+  return std::vector<uint8_t>(bytes_, bytes_ + size_);
+}
+
+void Mem::Restore(const std::vector<uint8_t>& bytes) {
+  size_t prev_size = size_;
+  size_ = std::min(bytes.size(), kMaxMemorySize);
+  std::memcpy(bytes_, bytes.data(), size_);
+  if (size_ < prev_size) {
+    std::memset(bytes_ + size_, 0, prev_size - size_);
+  }
+  if (resize_callback_ && size_ != prev_size) {
+    resize_callback_();
+  }
+}
+
+BytesSpan Mem::GetView() {
+  return BytesSpan{bytes_, size_};
+}
+
+void Mem::SetResizeCallback(std::function<void()> callback) {
+  resize_callback_ = std::move(callback);
+}
+
 void Mem::StoreBytes(int32_t addr, const std::vector<uint8_t>& src) {
+#if defined(GO2CPP_MEM_STATS)
+  RecordBulkAccess(addr, static_cast<int32_t>(src.size()), true);
+#endif
   std::memcpy(bytes_ + addr, &(*src.begin()), src.size());
 }
 
@@ -257,10 +378,17 @@ std::string Mem::LoadString(int32_t addr) const {
 }
 
 int Mem::Memcmp(int32_t a, int32_t b, int32_t len) {
+#if defined(GO2CPP_MEM_STATS)
+  RecordBulkAccess(a, len, false);
+  RecordBulkAccess(b, len, false);
+#endif
   return std::memcmp(bytes_ + a, bytes_ + b, len);
 }
 
 int32_t Mem::Memchr(int32_t ptr, int32_t ch, int32_t count) {
+#if defined(GO2CPP_MEM_STATS)
+  RecordBulkAccess(ptr, count, false);
+#endif
   void* result = std::memchr(bytes_ + ptr, ch, count);
   if (!result) {
     return 0;
@@ -269,12 +397,122 @@ int32_t Mem::Memchr(int32_t ptr, int32_t ch, int32_t count) {
 }
 
 void Mem::Memmove(int32_t dst, int32_t src, int32_t count) {
+#if defined(GO2CPP_MEM_STATS)
+  RecordBulkAccess(src, count, false);
+  RecordBulkAccess(dst, count, true);
+#endif
   std::memmove(bytes_ + dst, bytes_ + src, count);
 }
 
 void Mem::Memset(int32_t dst, uint8_t ch, int32_t count) {
+#if defined(GO2CPP_MEM_STATS)
+  RecordBulkAccess(dst, count, true);
+#endif
   std::memset(bytes_ + dst, ch, count);
 }
 
+int64_t Mem::CompareBytes(int32_t a, int32_t b) {
+  int32_t a_array = static_cast<int32_t>(LoadInt64(a));
+  int64_t a_len = LoadInt64(a + 8);
+  int32_t b_array = static_cast<int32_t>(LoadInt64(b));
+  int64_t b_len = LoadInt64(b + 8);
+  int32_t len = static_cast<int32_t>(std::min(a_len, b_len));
+  if (len > 0) {
+    int result = Memcmp(a_array, b_array, len);
+    if (result) {
+      return result < 0 ? -1 : 1;
+    }
+  }
+  if (a_len == b_len) {
+    return 0;
+  }
+  return a_len < b_len ? -1 : 1;
+}
+
+int64_t Mem::IndexByte(int32_t addr, uint8_t c) {
+  int32_t array = static_cast<int32_t>(LoadInt64(addr));
+  int32_t len = static_cast<int32_t>(LoadInt64(addr + 8));
+  if (len <= 0) {
+    return -1;
+  }
+  int32_t found = Memchr(array, c, len);
+  if (!found) {
+    return -1;
+  }
+  return found - array;
+}
+
+#if defined(GO2CPP_MEM_STATS)
+void Mem::RecordBulkAccess(int32_t addr, int32_t count, bool store) const {
+  if (count <= 0) {
+    return;
+  }
+  if (store) {
+    bulk_stored_bytes_ += count;
+  } else {
+    bulk_loaded_bytes_ += count;
+  }
+  // A bulk access counts as one access for each page it touches.
+  uint32_t first = static_cast<uint32_t>(addr) / kPageSize;
+  uint32_t last = (static_cast<uint32_t>(addr) + static_cast<uint32_t>(count) - 1) / kPageSize;
+  for (uint32_t page = first; page <= last && page < page_access_counts_.size(); page++) {
+    page_access_counts_[page]++;
+  }
+}
+
+std::string Mem::FormatAccessStats() const {
+  static const char* const kNames[] = {
+    "load8", "load16", "load32", "load64", "store8", "store16", "store32", "store64",
+  };
+  constexpr int kBarWidth = 40;
+  constexpr size_t kMaxHotPages = 16;
+
+  uint64_t total = 0;
+  uint64_t max = 0;
+  for (uint64_t c : access_counts_) {
+    total += c;
+    max = std::max(max, c);
+  }
+
+  std::ostringstream out;
+  out << "memory access stats: " << total << " loads and stores";
+  for (int i = 0; i < static_cast<int>(AccessKind::kNum); i++) {
+    uint64_t c = access_counts_[i];
+    out << "\n  " << std::left << std::setw(8) << kNames[i] << std::right << std::setw(14) << c << " "
+        << std::fixed << std::setprecision(1) << std::setw(5) << (total ? 100.0 * c / total : 0.0) << "%";
+    if (c) {
+      out << " " << std::string(static_cast<size_t>(kBarWidth * c / max), '#');
+    }
+  }
+  out << "\n  bulk: " << bulk_loaded_bytes_ << " bytes loaded, " << bulk_stored_bytes_ << " bytes stored";
+
+  std::vector<std::pair<uint64_t, uint32_t>> pages;
+  uint64_t page_total = 0;
+  for (size_t i = 0; i < page_access_counts_.size(); i++) {
+    if (page_access_counts_[i]) {
+      pages.emplace_back(page_access_counts_[i], static_cast<uint32_t>(i));
+      page_total += page_access_counts_[i];
+    }
+  }
+  size_t n = std::min(pages.size(), kMaxHotPages);
+  std::partial_sort(pages.begin(), pages.begin() + n, pages.end(),
+                    [](const std::pair<uint64_t, uint32_t>& a, const std::pair<uint64_t, uint32_t>& b) {
+                      if (a.first != b.first) {
+                        return a.first > b.first;
+                      }
+                      return a.second < b.second;
+                    });
+  out << "\n  hot pages (" << kPageSize / 1024 << " KiB each, " << pages.size() << " accessed):";
+  for (size_t i = 0; i < n; i++) {
+    uint64_t begin = static_cast<uint64_t>(pages[i].second) * kPageSize;
+    out << "\n    0x" << std::hex << std::setfill('0') << std::setw(8) << begin << "-0x" << std::setw(8)
+        << begin + kPageSize - 1 << std::dec << std::setfill(' ') << std::setw(14) << pages[i].first << " "
+        << std::fixed << std::setprecision(1) << std::setw(5) << 100.0 * pages[i].first / page_total << "% "
+        << std::string(static_cast<size_t>(kBarWidth * pages[i].first / pages[0].first), '#');
+  }
+  return out.str();
+}
+#endif
+
 }
 `))
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// moduleName returns the name of the C++ module for namespace, e.g., "a.b" for "a::b".
+func moduleName(namespace string) string {
+	return strings.ReplaceAll(namespace, "::", ".")
+}
+
+type moduleExport struct {
+	Header    string
+	Namespace string
+	Names     []string
+}
+
+var (
+	moduleNamespaceRe = regexp.MustCompile(`^namespace (\w+(?:::\w+)*) \{`)
+	moduleDeclRes     = []*regexp.Regexp{
+		// A definition of a class, a struct or an enum, optionally with the export macro. A forward declaration ends
+		// with ";".
+		regexp.MustCompile(`^(?:class|struct|enum class) (?:[A-Z][A-Z0-9_]* )?(\w+)\b[^;]*$`),
+		regexp.MustCompile(`^using (\w+) =`),
+		// A function, optionally with an attribute and the export macro.
+		regexp.MustCompile(`^(?:\[\[\w+\]\] )?(?:[A-Z][A-Z0-9_]* )?[\w:<>]+[ *&]+(\w+)\(`),
+	}
+)
+
+// namespaceScopeNames returns the names declared at the scope of namespace and its nested namespaces in the generated
+// header src, grouped by the namespaces, e.g., the classes, the type aliases and the functions. Only the declarations
+// starting at the beginning of the lines are found, which is how the generated headers are written.
+func namespaceScopeNames(src string, namespace string) (namespaces []string, names map[string][]string) {
+	names = map[string][]string{}
+	found := map[string]struct{}{}
+
+	// scopes has the namespace for each open brace, or "" for the other blocks like a class.
+	var scopes []string
+	for _, line := range strings.Split(src, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+
+		var opened string
+		if m := moduleNamespaceRe.FindStringSubmatch(line); m != nil {
+			opened = m[1]
+			if len(scopes) > 0 && scopes[len(scopes)-1] != "" {
+				opened = scopes[len(scopes)-1] + "::" + opened
+			}
+		} else if len(scopes) > 0 && (scopes[len(scopes)-1] == namespace || strings.HasPrefix(scopes[len(scopes)-1], namespace+"::")) {
+			ns := scopes[len(scopes)-1]
+			for _, re := range moduleDeclRes {
+				m := re.FindStringSubmatch(line)
+				if m == nil {
+					continue
+				}
+				if _, ok := found[ns+"::"+m[1]]; ok {
+					break
+				}
+				found[ns+"::"+m[1]] = struct{}{}
+				if _, ok := names[ns]; !ok {
+					namespaces = append(namespaces, ns)
+				}
+				names[ns] = append(names[ns], m[1])
+				break
+			}
+		}
+
+		for _, c := range line {
+			switch c {
+			case '{':
+				scopes = append(scopes, opened)
+				// Only the first brace of the line opens the namespace.
+				opened = ""
+			case '}':
+				if len(scopes) > 0 {
+					scopes = scopes[:len(scopes)-1]
+				}
+			}
+		}
+	}
+	return namespaces, names
+}
+
+// writeModule writes the module interface unit exporting the namespace-scope names declared in headers, which must be
+// written in dir.
+func writeModule(dir string, incpath string, namespace string, headers []string) error {
+	var exports []moduleExport
+	for _, h := range headers {
+		src, err := ioutil.ReadFile(filepath.Join(dir, h))
+		if err != nil {
+			return err
+		}
+		namespaces, names := namespaceScopeNames(string(src), namespace)
+		for _, ns := range namespaces {
+			exports = append(exports, moduleExport{
+				Header:    h,
+				Namespace: ns,
+				Names:     names[ns],
+			})
+		}
+	}
+
+	f, err := os.Create(filepath.Join(dir, "module.cppm"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := moduleTmpl.Execute(f, struct {
+		IncludePath string
+		Module      string
+		Headers     []string
+		Exports     []moduleExport
+	}{
+		IncludePath: incpath,
+		Module:      moduleName(namespace),
+		Headers:     headers,
+		Exports:     exports,
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+var moduleTmpl = template.Must(template.New("module.cppm").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+// The interface unit of the C++ module {{.Module}}, a facade of the headers that exports their namespace-scope names
+// with using-declarations like the standard library modules. This is experimental.
+//
+// The headers are included in the global module fragment, so the macros like GO2CPP_BUILD_SHARED must be defined when
+// compiling this unit. The generated code is not in the module, and the other files are compiled as before.
+
+module;
+
+{{range .Headers}}#include "{{$.IncludePath}}{{.}}"
+{{end}}
+export module {{.Module}};
+{{range $e := .Exports}}
+// {{$e.Header}}
+export namespace {{$e.Namespace}} {
+{{range $e.Names}}  using ::{{$e.Namespace}}::{{.}};
+{{end}}}
+{{end}}`))
@@ -5,18 +5,13 @@ package gowasm2cpp
 import (
 	"fmt"
 	"math"
-	"os"
 	"regexp"
-	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/go-interpreter/wagon/disasm"
-	"github.com/go-interpreter/wagon/wasm"
-	"github.com/go-interpreter/wagon/wasm/operators"
-
 	"github.com/hajimehoshi/go2cpp/internal/stackvar"
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
 )
 
 type returnType int
@@ -280,12 +275,11 @@ func (f *wasmFunc) localVariableType(idx int) returnType {
 	return wasmTypeToReturnType(wt)
 }
 
-func (f *wasmFunc) bodyToCpp() ([]string, error) {
+func (f *wasmFunc) bodyToCpp() (_ []string, err error) {
+	// A malformed function body can make the translation panic.
 	defer func() {
-		if err := recover(); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			debug.PrintStack()
-			panic(err)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", f.Wasm.Name, r)
 		}
 	}()
 
@@ -293,7 +287,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 	funcs := f.Funcs
 	types := f.Types
 
-	dis, err := disasm.NewDisassembly(f.Wasm, f.Mod)
+	instrs, err := f.Wasm.Body.Instrs()
 	if err != nil {
 		return nil, err
 	}
@@ -333,34 +327,39 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 	// Some stack variables must not be merged when they are used across multiple blocks.
 	nomerge := map[string]struct{}{}
 
-	for _, instr := range dis.Code {
-		switch instr.Op.Code {
-		case operators.Unreachable:
-			appendBody(`assert(((void)("not reached"), false));`)
-		case operators.Nop:
+	for i, instr := range instrs {
+		switch instr.Op {
+		case wasm.OpUnreachable:
+			appendBody(`Trap("unreachable");`)
+		case wasm.OpNop:
 			// Do nothing
-		case operators.Block:
+		case wasm.OpBlock:
 			var ret string
 			if t := instr.Immediates[0]; t != wasm.BlockTypeEmpty {
 				return nil, fmt.Errorf("br with a returning value is not implemented yet")
 			}
 			blockStack.PushBlock(blockTypeBlock, ret)
-		case operators.Loop:
+		case wasm.OpLoop:
 			var ret string
 			if t := instr.Immediates[0]; t != wasm.BlockTypeEmpty {
 				return nil, fmt.Errorf("br with a returning value is not implemented yet")
 			}
 			l := blockStack.PushBlock(blockTypeLoop, ret)
 			appendBody("label%d:;", l)
-		case operators.If:
+		case wasm.OpIf:
 			cond, _ := blockStack.PopExpr()
 			var ret string
 			if t := instr.Immediates[0]; t != wasm.BlockTypeEmpty {
 				return nil, fmt.Errorf("br with a returning value is not implemented yet")
 			}
-			appendBody("if (%s) {", optimizeCondition(cond))
+			if callsMoreStack(instrs[i+1:], funcs) {
+				// The stack rarely grows.
+				appendBody("if (GO2CPP_UNLIKELY(%s)) {", optimizeCondition(cond))
+			} else {
+				appendBody("if (%s) {", optimizeCondition(cond))
+			}
 			blockStack.PushBlock(blockTypeIf, ret)
-		case operators.Else:
+		case wasm.OpElse:
 			if _, _, ret := blockStack.PeepBlock(); ret != "" {
 				return nil, fmt.Errorf("br with a returning value is not implemented yet")
 			}
@@ -368,7 +367,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			// TODO: Treat the stack correctly especially when 'if' returns some values.
 			appendBody("} else {")
 			blockStack.IndentTemporarily()
-		case operators.End:
+		case wasm.OpEnd:
 			if _, _, ret := blockStack.PeepBlock(); ret != "" {
 				return nil, fmt.Errorf("br with a returning value is not implemented yet")
 			}
@@ -379,13 +378,13 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			if btype != blockTypeLoop {
 				appendBody("label%d:;", idx)
 			}
-		case operators.Br:
+		case wasm.OpBr:
 			if _, _, ret := blockStack.PeepBlock(); ret != "" {
 				return nil, fmt.Errorf("br with a returning value is not implemented yet")
 			}
 			level := instr.Immediates[0].(uint32)
 			appendBody(gotoOrReturn(int(level)))
-		case operators.BrIf:
+		case wasm.OpBrIf:
 			if _, _, ret := blockStack.PeepBlock(); ret != "" {
 				return nil, fmt.Errorf("br_if with a returning value is not implemented yet")
 			}
@@ -396,7 +395,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			appendBody(gotoOrReturn(int(level)))
 			blockStack.UnindentTemporarily()
 			appendBody("}")
-		case operators.BrTable:
+		case wasm.OpBrTable:
 			if _, _, ret := blockStack.PeepBlock(); ret != "" {
 				return nil, fmt.Errorf("br_table with a returning value is not implemented yet")
 			}
@@ -411,7 +410,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			level := int(instr.Immediates[len+1].(uint32))
 			appendBody("default: %s", gotoOrReturn(int(level)))
 			appendBody("}")
-		case operators.Return:
+		case wasm.OpReturn:
 			switch len(sig.ReturnTypes) {
 			case 0:
 				appendBody("return;")
@@ -420,7 +419,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 				appendBody("return %s;", expr)
 			}
 
-		case operators.Call:
+		case wasm.OpCall:
 			f := funcs[instr.Immediates[0].(uint32)]
 
 			args := make([]string, len(f.Wasm.Sig.ParamTypes))
@@ -440,10 +439,10 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 
 			var imp string
 			if f.Import {
-				imp = "import_->"
+				imp = importMember(f.importModule) + "->"
 			}
 			appendBody("%s%s%s(%s);", ret, imp, identifierFromString(f.Wasm.Name), strings.Join(args, ", "))
-		case operators.CallIndirect:
+		case wasm.OpCallIndirect:
 			idx, _ := blockStack.PopExpr()
 			typeid := instr.Immediates[0].(uint32)
 			t := types[typeid]
@@ -463,23 +462,38 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 				ret = fmt.Sprintf("%s %s = ", t.Cpp(), blockStack.PushLhs(t.stackVarType()))
 			}
 
-			appendBody("Type%d stack0_%d_ = funcs_[table_[0][%s]].type%d_;", typeid, tmpidx, idx, typeid)
-			appendBody("%s(this->*stack0_%d_)(%s);", ret, tmpidx, strings.Join(args, ", "))
-			tmpidx++
+			// Table slots that are never filled are 0, and the function 0 is an import whose entry is nullptr.
+			// Check the entry explicitly instead of calling a null member function pointer.
+			entry := tmpidx
+			fn := tmpidx + 1
+			tmpidx += 2
+			appendBody("uint32_t stack0_%d_ = static_cast<uint32_t>(%s);", entry, idx)
+			appendBody("if (GO2CPP_UNLIKELY(stack0_%d_ >= kTableSize)) {", entry)
+			blockStack.IndentTemporarily()
+			appendBody(`Trap("undefined table element " + std::to_string(stack0_%d_));`, entry)
+			blockStack.UnindentTemporarily()
+			appendBody("}")
+			appendBody("Type%d stack0_%d_ = funcs_[kTable[0][stack0_%d_]].type%d_;", t.Index, fn, entry, t.Index)
+			appendBody("if (GO2CPP_UNLIKELY(!stack0_%d_)) {", fn)
+			blockStack.IndentTemporarily()
+			appendBody(`Trap("uninitialized table entry " + std::to_string(stack0_%d_));`, entry)
+			blockStack.UnindentTemporarily()
+			appendBody("}")
+			appendBody("%s(this->*stack0_%d_)(%s);", ret, fn, strings.Join(args, ", "))
 
-		case operators.Drop:
+		case wasm.OpDrop:
 			blockStack.PopExpr()
-		case operators.Select:
+		case wasm.OpSelect:
 			cond, _ := blockStack.PopExpr()
 			arg1, _ := blockStack.PopExpr()
 			arg0, t := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) ? (%s) : (%s)", optimizeCondition(cond), arg0, arg1), t)
 
-		case operators.GetLocal:
+		case wasm.OpGetLocal:
 			t := f.localVariableType(int(instr.Immediates[0].(uint32)))
 			expr := fmt.Sprintf("local%d_", instr.Immediates[0])
 			blockStack.PushExpr(expr, t.stackVarType())
-		case operators.SetLocal:
+		case wasm.OpSetLocal:
 			lhs := fmt.Sprintf("local%d_", instr.Immediates[0])
 			for _, expr := range blockStack.FlushExprsIfNeeded(lhs) {
 				appendBody(expr)
@@ -488,7 +502,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			if lhs != v {
 				appendBody("%s = %s;", lhs, v)
 			}
-		case operators.TeeLocal:
+		case wasm.OpTeeLocal:
 			lhs := fmt.Sprintf("local%d_", instr.Immediates[0])
 			for _, expr := range blockStack.FlushExprsIfNeeded(lhs) {
 				appendBody(expr)
@@ -500,12 +514,12 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			if lhs != v {
 				appendBody("%s = %s;", lhs, v)
 			}
-		case operators.GetGlobal:
+		case wasm.OpGetGlobal:
 			g := f.Globals[instr.Immediates[0].(uint32)]
 			t := wasmTypeToReturnType(g.Type)
 			expr := fmt.Sprintf("global%d_", instr.Immediates[0])
 			blockStack.PushExpr(expr, t.stackVarType())
-		case operators.SetGlobal:
+		case wasm.OpSetGlobal:
 			lhs := fmt.Sprintf("global%d_", instr.Immediates[0])
 			for _, expr := range blockStack.FlushExprsIfNeeded(lhs) {
 				appendBody(expr)
@@ -513,7 +527,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			expr, _ := blockStack.PopExpr()
 			appendBody("%s = %s;", lhs, expr)
 
-		case operators.I32Load:
+		case wasm.OpI32Load:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -522,7 +536,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("mem_->LoadInt32((%s)%s)", addr, off)
 			blockStack.PushExpr(expr, stackvar.I32)
-		case operators.I64Load:
+		case wasm.OpI64Load:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -531,7 +545,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("mem_->LoadInt64((%s)%s)", addr, off)
 			blockStack.PushExpr(expr, stackvar.I64)
-		case operators.F32Load:
+		case wasm.OpF32Load:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -540,7 +554,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("mem_->LoadFloat32((%s)%s)", addr, off)
 			blockStack.PushExpr(expr, stackvar.F32)
-		case operators.F64Load:
+		case wasm.OpF64Load:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -549,7 +563,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("mem_->LoadFloat64((%s)%s)", addr, off)
 			blockStack.PushExpr(expr, stackvar.F64)
-		case operators.I32Load8s:
+		case wasm.OpI32Load8s:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -558,7 +572,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("static_cast<int32_t>(mem_->LoadInt8((%s)%s))", addr, off)
 			blockStack.PushExpr(expr, stackvar.I32)
-		case operators.I32Load8u:
+		case wasm.OpI32Load8u:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -567,7 +581,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("static_cast<int32_t>(mem_->LoadUint8((%s)%s))", addr, off)
 			blockStack.PushExpr(expr, stackvar.I32)
-		case operators.I32Load16s:
+		case wasm.OpI32Load16s:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -576,7 +590,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("static_cast<int32_t>(mem_->LoadInt16((%s)%s))", addr, off)
 			blockStack.PushExpr(expr, stackvar.I32)
-		case operators.I32Load16u:
+		case wasm.OpI32Load16u:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -585,7 +599,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("static_cast<int32_t>(mem_->LoadUint16((%s)%s))", addr, off)
 			blockStack.PushExpr(expr, stackvar.I32)
-		case operators.I64Load8s:
+		case wasm.OpI64Load8s:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -594,7 +608,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("static_cast<int64_t>(mem_->LoadInt8((%s)%s))", addr, off)
 			blockStack.PushExpr(expr, stackvar.I64)
-		case operators.I64Load8u:
+		case wasm.OpI64Load8u:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -603,7 +617,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("static_cast<int64_t>(mem_->LoadUint8((%s)%s))", addr, off)
 			blockStack.PushExpr(expr, stackvar.I64)
-		case operators.I64Load16s:
+		case wasm.OpI64Load16s:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -612,7 +626,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("static_cast<int64_t>(mem_->LoadInt16((%s)%s))", addr, off)
 			blockStack.PushExpr(expr, stackvar.I64)
-		case operators.I64Load16u:
+		case wasm.OpI64Load16u:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -621,7 +635,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("static_cast<int64_t>(mem_->LoadUint16((%s)%s))", addr, off)
 			blockStack.PushExpr(expr, stackvar.I64)
-		case operators.I64Load32s:
+		case wasm.OpI64Load32s:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			var off string
@@ -630,13 +644,13 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			expr := fmt.Sprintf("static_cast<int64_t>(mem_->LoadInt32((%s)%s))", addr, off)
 			blockStack.PushExpr(expr, stackvar.I64)
-		case operators.I64Load32u:
+		case wasm.OpI64Load32u:
 			offset := instr.Immediates[1].(uint32)
 			addr, _ := blockStack.PopExpr()
 			expr := fmt.Sprintf("static_cast<int64_t>(mem_->LoadUint32((%s) + %d))", addr, offset)
 			blockStack.PushExpr(expr, stackvar.I64)
 
-		case operators.I32Store:
+		case wasm.OpI32Store:
 			for _, expr := range blockStack.FlushExprsIfNeeded("mem_->") {
 				appendBody(expr)
 			}
@@ -648,7 +662,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 				off = fmt.Sprintf(" + %d", offset)
 			}
 			appendBody("mem_->StoreInt32((%s)%s, %s);", addr, off, idx)
-		case operators.I64Store:
+		case wasm.OpI64Store:
 			for _, expr := range blockStack.FlushExprsIfNeeded("mem_->") {
 				appendBody(expr)
 			}
@@ -660,7 +674,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 				off = fmt.Sprintf(" + %d", offset)
 			}
 			appendBody("mem_->StoreInt64((%s)%s, %s);", addr, off, idx)
-		case operators.F32Store:
+		case wasm.OpF32Store:
 			for _, expr := range blockStack.FlushExprsIfNeeded("mem_->") {
 				appendBody(expr)
 			}
@@ -672,7 +686,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 				off = fmt.Sprintf(" + %d", offset)
 			}
 			appendBody("mem_->StoreFloat32((%s)%s, %s);", addr, off, idx)
-		case operators.F64Store:
+		case wasm.OpF64Store:
 			for _, expr := range blockStack.FlushExprsIfNeeded("mem_->") {
 				appendBody(expr)
 			}
@@ -684,7 +698,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 				off = fmt.Sprintf(" + %d", offset)
 			}
 			appendBody("mem_->StoreFloat64((%s)%s, %s);", addr, off, idx)
-		case operators.I32Store8:
+		case wasm.OpI32Store8:
 			for _, expr := range blockStack.FlushExprsIfNeeded("mem_->") {
 				appendBody(expr)
 			}
@@ -697,7 +711,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			idx = optimizeStaticCasts(fmt.Sprintf("static_cast<int8_t>(%s)", idx))
 			appendBody("mem_->StoreInt8((%s)%s, %s);", addr, off, idx)
-		case operators.I32Store16:
+		case wasm.OpI32Store16:
 			for _, expr := range blockStack.FlushExprsIfNeeded("mem_->") {
 				appendBody(expr)
 			}
@@ -710,7 +724,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			idx = optimizeStaticCasts(fmt.Sprintf("static_cast<int16_t>(%s)", idx))
 			appendBody("mem_->StoreInt16((%s)%s, %s);", addr, off, idx)
-		case operators.I64Store8:
+		case wasm.OpI64Store8:
 			for _, expr := range blockStack.FlushExprsIfNeeded("mem_->") {
 				appendBody(expr)
 			}
@@ -723,7 +737,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			idx = optimizeStaticCasts(fmt.Sprintf("static_cast<int8_t>(%s)", idx))
 			appendBody("mem_->StoreInt8((%s)%s, %s);", addr, off, idx)
-		case operators.I64Store16:
+		case wasm.OpI64Store16:
 			offset := instr.Immediates[1].(uint32)
 			idx, _ := blockStack.PopExpr()
 			addr, _ := blockStack.PopExpr()
@@ -733,7 +747,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			}
 			idx = optimizeStaticCasts(fmt.Sprintf("static_cast<int16_t>(%s)", idx))
 			appendBody("mem_->StoreInt16((%s)%s, %s);", addr, off, idx)
-		case operators.I64Store32:
+		case wasm.OpI64Store32:
 			for _, expr := range blockStack.FlushExprsIfNeeded("mem_->") {
 				appendBody(expr)
 			}
@@ -747,564 +761,581 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 			idx = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", idx))
 			appendBody("mem_->StoreInt32((%s)%s, %s);", addr, off, idx)
 
-		case operators.CurrentMemory:
+		case wasm.OpCurrentMemory:
 			blockStack.PushExpr("mem_->GetSize()", stackvar.I32)
-		case operators.GrowMemory:
+		case wasm.OpGrowMemory:
 			delta, _ := blockStack.PopExpr()
 			// As Grow has side effects, call PushLhs instead of PushExpr.
 			v := blockStack.PushLhs(stackvar.I32)
 			appendBody("int32_t %s = mem_->Grow(%s);", v, delta)
 
-		case operators.I32Const:
+		case wasm.OpI32Const:
 			blockStack.PushExpr(fmt.Sprintf("%d", instr.Immediates[0]), stackvar.I32)
-		case operators.I64Const:
+		case wasm.OpI64Const:
 			if i := instr.Immediates[0].(int64); i == -9223372036854775808 {
 				// C++ cannot represent this value as an integer literal.
 				blockStack.PushExpr(fmt.Sprintf("%dLL - 1LL", i+1), stackvar.I64)
 			} else {
 				blockStack.PushExpr(fmt.Sprintf("%dLL", i), stackvar.I64)
 			}
-		case operators.F32Const:
-			if v := instr.Immediates[0].(float32); v == 0 {
+		case wasm.OpF32Const:
+			// Zero must not match -0, whose sign must be kept.
+			if v := instr.Immediates[0].(float32); v == 0 && !math.Signbit(float64(v)) {
 				blockStack.PushExpr("0.0f", stackvar.F32)
 			} else {
 				va := blockStack.PushLhs(stackvar.F32)
 				bits := math.Float32bits(v)
-				appendBody("uint32_t stack0_%d_ = %d; // %f", tmpidx, bits, v)
-				appendBody("float %s = *reinterpret_cast<float*>(&stack0_%d_);", va, tmpidx)
-				tmpidx++
+				appendBody("float %s = Bits::BitCast<float>(static_cast<uint32_t>(%dU)); // %f", va, bits, v)
 			}
-		case operators.F64Const:
-			if v := instr.Immediates[0].(float64); v == 0 {
-				blockStack.PushExpr("0.0", stackvar.I64)
+		case wasm.OpF64Const:
+			if v := instr.Immediates[0].(float64); v == 0 && !math.Signbit(v) {
+				blockStack.PushExpr("0.0", stackvar.F64)
 			} else {
 				va := blockStack.PushLhs(stackvar.F64)
 				bits := math.Float64bits(v)
-				appendBody("uint64_t stack0_%d_ = %dULL; // %f", tmpidx, bits, v)
-				appendBody("double %s = *reinterpret_cast<double*>(&stack0_%d_);", va, tmpidx)
-				tmpidx++
+				appendBody("double %s = Bits::BitCast<double>(static_cast<uint64_t>(%dULL)); // %f", va, bits, v)
 			}
 
-		case operators.I32Eqz:
+		case wasm.OpI32Eqz:
 			arg, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) == 0", arg), stackvar.I32)
-		case operators.I32Eq:
+		case wasm.OpI32Eq:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) == (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32Ne:
+		case wasm.OpI32Ne:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) != (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32LtS:
+		case wasm.OpI32LtS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) < (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32LtU:
+		case wasm.OpI32LtU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("(%s) < (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32GtS:
+		case wasm.OpI32GtS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) > (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32GtU:
+		case wasm.OpI32GtU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("(%s) > (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32LeS:
+		case wasm.OpI32LeS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) <= (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32LeU:
+		case wasm.OpI32LeU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("(%s) <= (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32GeS:
+		case wasm.OpI32GeS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) >= (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32GeU:
+		case wasm.OpI32GeU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("(%s) >= (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64Eqz:
+		case wasm.OpI64Eqz:
 			arg, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) == 0", arg), stackvar.I32)
-		case operators.I64Eq:
+		case wasm.OpI64Eq:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) == (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64Ne:
+		case wasm.OpI64Ne:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) != (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64LtS:
+		case wasm.OpI64LtS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) < (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64LtU:
+		case wasm.OpI64LtU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("(%s) < (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64GtS:
+		case wasm.OpI64GtS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) > (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64GtU:
+		case wasm.OpI64GtU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("(%s) > (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64LeS:
+		case wasm.OpI64LeS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) <= (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64LeU:
+		case wasm.OpI64LeU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("(%s) <= (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64GeS:
+		case wasm.OpI64GeS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) >= (%s)", arg0, arg1), stackvar.I32)
-		case operators.I64GeU:
+		case wasm.OpI64GeU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("(%s) >= (%s)", arg0, arg1), stackvar.I32)
-		case operators.F32Eq:
+		case wasm.OpF32Eq:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) == (%s)", arg0, arg1), stackvar.I32)
-		case operators.F32Ne:
+		case wasm.OpF32Ne:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) != (%s)", arg0, arg1), stackvar.I32)
-		case operators.F32Lt:
+		case wasm.OpF32Lt:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) < (%s)", arg0, arg1), stackvar.I32)
-		case operators.F32Gt:
+		case wasm.OpF32Gt:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) > (%s)", arg0, arg1), stackvar.I32)
-		case operators.F32Le:
+		case wasm.OpF32Le:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) <= (%s)", arg0, arg1), stackvar.I32)
-		case operators.F32Ge:
+		case wasm.OpF32Ge:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) >= (%s)", arg0, arg1), stackvar.I32)
-		case operators.F64Eq:
+		case wasm.OpF64Eq:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) == (%s)", arg0, arg1), stackvar.I32)
-		case operators.F64Ne:
+		case wasm.OpF64Ne:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) != (%s)", arg0, arg1), stackvar.I32)
-		case operators.F64Lt:
+		case wasm.OpF64Lt:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) < (%s)", arg0, arg1), stackvar.I32)
-		case operators.F64Gt:
+		case wasm.OpF64Gt:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) > (%s)", arg0, arg1), stackvar.I32)
-		case operators.F64Le:
+		case wasm.OpF64Le:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) <= (%s)", arg0, arg1), stackvar.I32)
-		case operators.F64Ge:
+		case wasm.OpF64Ge:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) >= (%s)", arg0, arg1), stackvar.I32)
 
-		case operators.I32Clz:
+		case wasm.OpI32Clz:
 			arg, _ := blockStack.PopExpr()
-			v := fmt.Sprintf("stack0_%d_", tmpidx)
-			tmpidx++
 			arg = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg))
-			appendBody("uint32_t %s = %s;", v, arg)
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(%s ? __builtin_clzl(%s) : 32)", v, v), stackvar.I32)
-		case operators.I32Ctz:
+			blockStack.PushExpr(fmt.Sprintf("Bits::LeadingZeros(%s)", arg), stackvar.I32)
+		case wasm.OpI32Ctz:
 			arg, _ := blockStack.PopExpr()
-			v := fmt.Sprintf("stack0_%d_", tmpidx)
-			tmpidx++
 			arg = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg))
-			appendBody("uint32_t %s = %s;", v, arg)
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(%s ? __builtin_ctzl(%s) : 32)", v, v), stackvar.I32)
-		case operators.I32Popcnt:
+			blockStack.PushExpr(fmt.Sprintf("Bits::TrailingZeros(%s)", arg), stackvar.I32)
+		case wasm.OpI32Popcnt:
 			arg, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(__builtin_popcountl(static_cast<uint32_t>(%s)))", arg), stackvar.I32)
-		case operators.I32Add:
+			arg = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg))
+			blockStack.PushExpr(fmt.Sprintf("Bits::OnesCount(%s)", arg), stackvar.I32)
+		case wasm.OpI32Add:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			// Cast to unsigned types to avoid undefined signed overflow.
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>((%s) + (%s))", arg0, arg1), stackvar.I32)
-		case operators.I32Sub:
+		case wasm.OpI32Sub:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>((%s) - (%s))", arg0, arg1), stackvar.I32)
-		case operators.I32Mul:
+		case wasm.OpI32Mul:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>((%s) * (%s))", arg0, arg1), stackvar.I32)
-		case operators.I32DivS:
+		case wasm.OpI32DivS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("(%s) / (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32DivU:
+			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg0))
+			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg1))
+			blockStack.PushExpr(fmt.Sprintf("Bits::DivS(%s, %s)", arg0, arg1), stackvar.I32)
+		case wasm.OpI32DivU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg1))
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>((%s) / (%s))", arg0, arg1), stackvar.I32)
-		case operators.I32RemS:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(Bits::DivU(%s, %s))", arg0, arg1), stackvar.I32)
+		case wasm.OpI32RemS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("(%s) %% (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32RemU:
+			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg0))
+			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg1))
+			blockStack.PushExpr(fmt.Sprintf("Bits::RemS(%s, %s)", arg0, arg1), stackvar.I32)
+		case wasm.OpI32RemU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg1))
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>((%s) %% (%s))", arg0, arg1), stackvar.I32)
-		case operators.I32And:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(Bits::RemU(%s, %s))", arg0, arg1), stackvar.I32)
+		case wasm.OpI32And:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) & (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32Or:
+		case wasm.OpI32Or:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) | (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32Xor:
+		case wasm.OpI32Xor:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) ^ (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32Shl:
+		case wasm.OpI32Shl:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
+			arg1 = maskShiftCount(arg1, 32)
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>((%s) << (%s))", arg0, arg1), stackvar.I32)
-		case operators.I32ShrS:
+		case wasm.OpI32ShrS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
+			arg1 = maskShiftCount(arg1, 32)
 			blockStack.PushExpr(fmt.Sprintf("(%s) >> (%s)", arg0, arg1), stackvar.I32)
-		case operators.I32ShrU:
+		case wasm.OpI32ShrU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
+			arg1 = maskShiftCount(arg1, 32)
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>((%s) >> (%s))", arg0, arg1), stackvar.I32)
-		case operators.I32Rotl:
+		case wasm.OpI32Rotl:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(Bits::RotateLeft(%s, %s))", arg0, arg1), stackvar.I32)
-		case operators.I32Rotr:
+		case wasm.OpI32Rotr:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(Bits::RotateLeft(%s, -(%s)))", arg0, arg1), stackvar.I32)
-		case operators.I64Clz:
+		case wasm.OpI64Clz:
 			arg, _ := blockStack.PopExpr()
-			v := fmt.Sprintf("stack0_%d_", tmpidx)
-			tmpidx++
 			arg = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg))
-			appendBody("uint64_t %s = %s;", v, arg)
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(%s ? __builtin_clzll(%s) : 64)", v, v), stackvar.I64)
-		case operators.I64Ctz:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(Bits::LeadingZeros(%s))", arg), stackvar.I64)
+		case wasm.OpI64Ctz:
 			arg, _ := blockStack.PopExpr()
-			v := fmt.Sprintf("stack0_%d_", tmpidx)
-			tmpidx++
 			arg = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg))
-			appendBody("uint64_t %s = %s;", v, arg)
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(%s ? __builtin_ctzll(%s) : 64)", v, v), stackvar.I64)
-		case operators.I64Popcnt:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(Bits::TrailingZeros(%s))", arg), stackvar.I64)
+		case wasm.OpI64Popcnt:
 			arg, _ := blockStack.PopExpr()
 			arg = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg))
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(__builtin_popcountll(%s))", arg), stackvar.I64)
-		case operators.I64Add:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(Bits::OnesCount(%s))", arg), stackvar.I64)
+		case wasm.OpI64Add:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			// Cast to unsigned types to avoid undefined signed overflow.
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>((%s) + (%s))", arg0, arg1), stackvar.I64)
-		case operators.I64Sub:
+		case wasm.OpI64Sub:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>((%s) - (%s))", arg0, arg1), stackvar.I64)
-		case operators.I64Mul:
+		case wasm.OpI64Mul:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>((%s) * (%s))", arg0, arg1), stackvar.I64)
-		case operators.I64DivS:
+		case wasm.OpI64DivS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("(%s) / (%s)", arg0, arg1), stackvar.I64)
-		case operators.I64DivU:
+			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<int64_t>(%s)", arg0))
+			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int64_t>(%s)", arg1))
+			blockStack.PushExpr(fmt.Sprintf("Bits::DivS(%s, %s)", arg0, arg1), stackvar.I64)
+		case wasm.OpI64DivU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg1))
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>((%s) / (%s))", arg0, arg1), stackvar.I64)
-		case operators.I64RemS:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(Bits::DivU(%s, %s))", arg0, arg1), stackvar.I64)
+		case wasm.OpI64RemS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("(%s) %% (%s)", arg0, arg1), stackvar.I64)
-		case operators.I64RemU:
+			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<int64_t>(%s)", arg0))
+			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int64_t>(%s)", arg1))
+			blockStack.PushExpr(fmt.Sprintf("Bits::RemS(%s, %s)", arg0, arg1), stackvar.I64)
+		case wasm.OpI64RemU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg1))
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>((%s) %% (%s))", arg0, arg1), stackvar.I64)
-		case operators.I64And:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(Bits::RemU(%s, %s))", arg0, arg1), stackvar.I64)
+		case wasm.OpI64And:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) & (%s)", arg0, arg1), stackvar.I64)
-		case operators.I64Or:
+		case wasm.OpI64Or:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) | (%s)", arg0, arg1), stackvar.I64)
-		case operators.I64Xor:
+		case wasm.OpI64Xor:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) ^ (%s)", arg0, arg1), stackvar.I64)
-		case operators.I64Shl:
+		case wasm.OpI64Shl:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
-			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg1))
+			arg1 = maskShiftCount(arg1, 64)
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>((%s) << (%s))", arg0, arg1), stackvar.I64)
-		case operators.I64ShrS:
+		case wasm.OpI64ShrS:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg1))
+			arg1 = maskShiftCount(arg1, 64)
 			blockStack.PushExpr(fmt.Sprintf("(%s) >> (%s)", arg0, arg1), stackvar.I64)
-		case operators.I64ShrU:
+		case wasm.OpI64ShrU:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
-			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg1))
+			arg1 = maskShiftCount(arg1, 64)
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>((%s) >> (%s))", arg0, arg1), stackvar.I64)
-		case operators.I64Rotl:
+		case wasm.OpI64Rotl:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(Bits::RotateLeft((%s), (%s)))", arg0, arg1), stackvar.I64)
-		case operators.I64Rotr:
+		case wasm.OpI64Rotr:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			arg0 = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", arg0))
 			arg1 = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", arg1))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(Bits::RotateLeft((%s), -(%s)))", arg0, arg1), stackvar.I64)
-		case operators.F32Abs:
+		case wasm.OpF32Abs:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::abs(%s)", expr), stackvar.F32)
-		case operators.F32Neg:
+		case wasm.OpF32Neg:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("-(%s)", expr), stackvar.F32)
-		case operators.F32Ceil:
+		case wasm.OpF32Ceil:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::ceil(%s)", expr), stackvar.F32)
-		case operators.F32Floor:
+		case wasm.OpF32Floor:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::floor(%s)", expr), stackvar.F32)
-		case operators.F32Trunc:
+		case wasm.OpF32Trunc:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::trunc(%s)", expr), stackvar.F32)
-		case operators.F32Nearest:
+		case wasm.OpF32Nearest:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("Math::Round(%s)", expr), stackvar.F32)
-		case operators.F32Sqrt:
+		case wasm.OpF32Sqrt:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::sqrt(%s)", expr), stackvar.F32)
-		case operators.F32Add:
+		case wasm.OpF32Add:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) + (%s)", arg0, arg1), stackvar.F32)
-		case operators.F32Sub:
+		case wasm.OpF32Sub:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) - (%s)", arg0, arg1), stackvar.F32)
-		case operators.F32Mul:
+		case wasm.OpF32Mul:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) * (%s)", arg0, arg1), stackvar.F32)
-		case operators.F32Div:
+		case wasm.OpF32Div:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) / (%s)", arg0, arg1), stackvar.F32)
-		case operators.F32Min:
+		case wasm.OpF32Min:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("std::min((%s), (%s))", arg0, arg1), stackvar.F32)
-		case operators.F32Max:
+			blockStack.PushExpr(fmt.Sprintf("Math::Min(%s, %s)", arg0, arg1), stackvar.F32)
+		case wasm.OpF32Max:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("std::max((%s), (%s))", arg0, arg1), stackvar.F32)
-		case operators.F32Copysign:
+			blockStack.PushExpr(fmt.Sprintf("Math::Max(%s, %s)", arg0, arg1), stackvar.F32)
+		case wasm.OpF32Copysign:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("std::copysign((%s), (%s))", arg0, arg1), stackvar.F32)
-		case operators.F64Abs:
+			blockStack.PushExpr(fmt.Sprintf("Math::Copysign(%s, %s)", arg0, arg1), stackvar.F32)
+		case wasm.OpF64Abs:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::abs(%s)", expr), stackvar.F64)
-		case operators.F64Neg:
+		case wasm.OpF64Neg:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("-(%s)", expr), stackvar.F64)
-		case operators.F64Ceil:
+		case wasm.OpF64Ceil:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::ceil(%s)", expr), stackvar.F64)
-		case operators.F64Floor:
+		case wasm.OpF64Floor:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::floor(%s)", expr), stackvar.F64)
-		case operators.F64Trunc:
+		case wasm.OpF64Trunc:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::trunc(%s)", expr), stackvar.F64)
-		case operators.F64Nearest:
+		case wasm.OpF64Nearest:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("Math::Round(%s)", expr), stackvar.F64)
-		case operators.F64Sqrt:
+		case wasm.OpF64Sqrt:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("std::sqrt(%s)", expr), stackvar.F64)
-		case operators.F64Add:
+		case wasm.OpF64Add:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) + (%s)", arg0, arg1), stackvar.F64)
-		case operators.F64Sub:
+		case wasm.OpF64Sub:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) - (%s)", arg0, arg1), stackvar.F64)
-		case operators.F64Mul:
+		case wasm.OpF64Mul:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) * (%s)", arg0, arg1), stackvar.F64)
-		case operators.F64Div:
+		case wasm.OpF64Div:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("(%s) / (%s)", arg0, arg1), stackvar.F64)
-		case operators.F64Min:
+		case wasm.OpF64Min:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("std::min((%s), (%s))", arg0, arg1), stackvar.F64)
-		case operators.F64Max:
+			blockStack.PushExpr(fmt.Sprintf("Math::Min(%s, %s)", arg0, arg1), stackvar.F64)
+		case wasm.OpF64Max:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("std::max((%s), (%s))", arg0, arg1), stackvar.F64)
-		case operators.F64Copysign:
+			blockStack.PushExpr(fmt.Sprintf("Math::Max(%s, %s)", arg0, arg1), stackvar.F64)
+		case wasm.OpF64Copysign:
 			arg1, _ := blockStack.PopExpr()
 			arg0, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("std::copysign((%s), (%s))", arg0, arg1), stackvar.F64)
+			blockStack.PushExpr(fmt.Sprintf("Math::Copysign(%s, %s)", arg0, arg1), stackvar.F64)
 
-		case operators.I32WrapI64:
+		case wasm.OpI32WrapI64:
 			expr, _ := blockStack.PopExpr()
 			expr = optimizeStaticCasts(fmt.Sprintf("static_cast<int32_t>(%s)", expr))
 			blockStack.PushExpr(fmt.Sprintf("(%s)", expr), stackvar.I32)
-		case operators.I32TruncSF32:
+		case wasm.OpI32TruncSF32:
 			expr, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(std::trunc(%s))", expr), stackvar.I32)
-		case operators.I32TruncUF32:
+			blockStack.PushExpr(fmt.Sprintf("Math::TruncToInt32(%s)", expr), stackvar.I32)
+		case wasm.OpI32TruncUF32:
 			expr, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(static_cast<uint32_t>(std::trunc(%s)))", expr), stackvar.I32)
-		case operators.I32TruncSF64:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(Math::TruncToUint32(%s))", expr), stackvar.I32)
+		case wasm.OpI32TruncSF64:
 			expr, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(std::trunc(%s))", expr), stackvar.I32)
-		case operators.I32TruncUF64:
+			blockStack.PushExpr(fmt.Sprintf("Math::TruncToInt32(%s)", expr), stackvar.I32)
+		case wasm.OpI32TruncUF64:
 			expr, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(static_cast<uint32_t>(std::trunc(%s)))", expr), stackvar.I32)
-		case operators.I64ExtendSI32:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(Math::TruncToUint32(%s))", expr), stackvar.I32)
+		case wasm.OpI64ExtendSI32:
 			expr, _ := blockStack.PopExpr()
 			expr = optimizeStaticCasts(fmt.Sprintf("static_cast<int64_t>(%s)", expr))
 			blockStack.PushExpr(fmt.Sprintf("(%s)", expr), stackvar.I64)
-		case operators.I64ExtendUI32:
+		case wasm.OpI64ExtendUI32:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(static_cast<uint32_t>(%s))", expr), stackvar.I64)
-		case operators.I64TruncSF32:
+		case wasm.OpI64TruncSF32:
 			expr, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(std::trunc(%s))", expr), stackvar.I64)
-		case operators.I64TruncUF32:
+			blockStack.PushExpr(fmt.Sprintf("Math::TruncToInt64(%s)", expr), stackvar.I64)
+		case wasm.OpI64TruncUF32:
 			expr, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(static_cast<uint64_t>(std::trunc(%s)))", expr), stackvar.I64)
-		case operators.I64TruncSF64:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(Math::TruncToUint64(%s))", expr), stackvar.I64)
+		case wasm.OpI64TruncSF64:
 			expr, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(std::trunc(%s))", expr), stackvar.I64)
-		case operators.I64TruncUF64:
+			blockStack.PushExpr(fmt.Sprintf("Math::TruncToInt64(%s)", expr), stackvar.I64)
+		case wasm.OpI64TruncUF64:
 			expr, _ := blockStack.PopExpr()
-			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(static_cast<uint64_t>(std::trunc(%s)))", expr), stackvar.I64)
-		case operators.F32ConvertSI32:
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(Math::TruncToUint64(%s))", expr), stackvar.I64)
+		case wasm.OpF32ConvertSI32:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("static_cast<float>(%s)", expr), stackvar.F32)
-		case operators.F32ConvertUI32:
+		case wasm.OpF32ConvertUI32:
 			expr, _ := blockStack.PopExpr()
 			expr = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", expr))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<float>(%s)", expr), stackvar.F32)
-		case operators.F32ConvertSI64:
+		case wasm.OpF32ConvertSI64:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("static_cast<float>(%s)", expr), stackvar.F32)
-		case operators.F32ConvertUI64:
+		case wasm.OpF32ConvertUI64:
 			expr, _ := blockStack.PopExpr()
 			expr = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", expr))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<float>(%s)", expr), stackvar.F32)
-		case operators.F32DemoteF64:
+		case wasm.OpF32DemoteF64:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("static_cast<float>(%s)", expr), stackvar.F32)
-		case operators.F64ConvertSI32:
+		case wasm.OpF64ConvertSI32:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("static_cast<double>(%s)", expr), stackvar.F64)
-		case operators.F64ConvertUI32:
+		case wasm.OpF64ConvertUI32:
 			expr, _ := blockStack.PopExpr()
 			expr = optimizeStaticCasts(fmt.Sprintf("static_cast<uint32_t>(%s)", expr))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<double>(%s)", expr), stackvar.F64)
-		case operators.F64ConvertSI64:
+		case wasm.OpF64ConvertSI64:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("static_cast<double>(%s)", expr), stackvar.F64)
-		case operators.F64ConvertUI64:
+		case wasm.OpF64ConvertUI64:
 			expr, _ := blockStack.PopExpr()
 			expr = optimizeStaticCasts(fmt.Sprintf("static_cast<uint64_t>(%s)", expr))
 			blockStack.PushExpr(fmt.Sprintf("static_cast<double>(%s)", expr), stackvar.F64)
-		case operators.F64PromoteF32:
+		case wasm.OpF64PromoteF32:
 			expr, _ := blockStack.PopExpr()
 			blockStack.PushExpr(fmt.Sprintf("static_cast<double>(%s)", expr), stackvar.F64)
 
-		case operators.I32ReinterpretF32:
-			return nil, fmt.Errorf("I32ReinterpretF32 is not implemented yet")
-		case operators.I64ReinterpretF64:
-			return nil, fmt.Errorf("I64ReinterpretF64 is not implemented yet")
-		case operators.F32ReinterpretI32:
-			return nil, fmt.Errorf("F32ReinterpretI32 is not implemented yet")
-		case operators.F64ReinterpretI64:
-			return nil, fmt.Errorf("F64ReinterpretI64 is not implemented yet")
+		case wasm.OpI32ReinterpretF32:
+			expr, _ := blockStack.PopExpr()
+			blockStack.PushExpr(fmt.Sprintf("Bits::BitCast<int32_t>(static_cast<float>(%s))", expr), stackvar.I32)
+		case wasm.OpI64ReinterpretF64:
+			expr, _ := blockStack.PopExpr()
+			blockStack.PushExpr(fmt.Sprintf("Bits::BitCast<int64_t>(static_cast<double>(%s))", expr), stackvar.I64)
+		case wasm.OpF32ReinterpretI32:
+			expr, _ := blockStack.PopExpr()
+			blockStack.PushExpr(fmt.Sprintf("Bits::BitCast<float>(static_cast<int32_t>(%s))", expr), stackvar.F32)
+		case wasm.OpF64ReinterpretI64:
+			expr, _ := blockStack.PopExpr()
+			blockStack.PushExpr(fmt.Sprintf("Bits::BitCast<double>(static_cast<int64_t>(%s))", expr), stackvar.F64)
+
+		case wasm.OpI32Extend8S:
+			expr, _ := blockStack.PopExpr()
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(static_cast<int8_t>(%s))", expr), stackvar.I32)
+		case wasm.OpI32Extend16S:
+			expr, _ := blockStack.PopExpr()
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int32_t>(static_cast<int16_t>(%s))", expr), stackvar.I32)
+		case wasm.OpI64Extend8S:
+			expr, _ := blockStack.PopExpr()
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(static_cast<int8_t>(%s))", expr), stackvar.I64)
+		case wasm.OpI64Extend16S:
+			expr, _ := blockStack.PopExpr()
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(static_cast<int16_t>(%s))", expr), stackvar.I64)
+		case wasm.OpI64Extend32S:
+			expr, _ := blockStack.PopExpr()
+			blockStack.PushExpr(fmt.Sprintf("static_cast<int64_t>(static_cast<int32_t>(%s))", expr), stackvar.I64)
 
 		default:
 			return nil, fmt.Errorf("unexpected operator: %v", instr.Op)
@@ -1315,7 +1346,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 	case 0:
 		// Do nothing.
 	case 1:
-		if !blockStack.IsStackVarEmpty() && dis.Code[len(dis.Code)-1].Op.Code != operators.Unreachable {
+		if !blockStack.IsStackVarEmpty() && instrs[len(instrs)-1].Op != wasm.OpUnreachable {
 			if len(body) == 0 || !strings.HasPrefix(strings.TrimSpace(body[len(body)-1]), "return ") {
 				expr, _ := blockStack.PopExpr()
 				appendBody(`return %s;`, expr)
@@ -1332,6 +1363,7 @@ func (f *wasmFunc) bodyToCpp() ([]string, error) {
 	body = aggregateStackVars(body, nomerge)
 	body = optimizeGoto(body)
 	body = removeUnusedLabels(body)
+	body = computedGoto(body)
 
 	return body, nil
 }
@@ -1473,7 +1505,7 @@ func optimizeGoto(body []string) []string {
 			}
 			idt += string(r)
 		}
-		body[i] = idt + ret + ";"
+		body[i] = idt + m[1] + ret + ";"
 	}
 
 	var brtableLocal string
@@ -1583,6 +1615,63 @@ func removeUnusedLabels(body []string) []string {
 	return r
 }
 
+var switchBeginRe = regexp.MustCompile(`^(\s*)switch \((.+)\) {$`)
+
+// computedGoto adds GCC's computed gotos for the br_table switches whose cases are all gotos. The switches are kept
+// for the compilers without computed gotos, and GO2CPP_USE_COMPUTED_GOTO in config.h selects either.
+func computedGoto(body []string) []string {
+	var r []string
+	for i := 0; i < len(body); i++ {
+		m := switchBeginRe.FindStringSubmatch(body[i])
+		if m == nil {
+			r = append(r, body[i])
+			continue
+		}
+
+		var dsts []string
+		var defaultDst string
+		j := i + 1
+		for ; j < len(body); j++ {
+			mc := caseGotoRe.FindStringSubmatch(body[j])
+			if mc == nil {
+				break
+			}
+			if mc[1] == "default" {
+				defaultDst = mc[3]
+				break
+			}
+			if mc[2] != strconv.Itoa(len(dsts)) {
+				break
+			}
+			dsts = append(dsts, mc[3])
+		}
+		if defaultDst == "" || len(dsts) == 0 || j+1 >= len(body) || strings.TrimSpace(body[j+1]) != "}" {
+			r = append(r, body[i])
+			continue
+		}
+
+		idt := m[1]
+		var addrs []string
+		for _, d := range dsts {
+			addrs = append(addrs, "&&"+d)
+		}
+		r = append(r, "#if GO2CPP_USE_COMPUTED_GOTO")
+		r = append(r, idt+"{")
+		r = append(r, fmt.Sprintf("%s  static void* const targets[] = {%s};", idt, strings.Join(addrs, ", ")))
+		r = append(r, fmt.Sprintf("%s  uint32_t index = static_cast<uint32_t>(%s);", idt, m[2]))
+		r = append(r, fmt.Sprintf("%s  if (index < %d) {", idt, len(dsts)))
+		r = append(r, idt+"    goto *targets[index];")
+		r = append(r, idt+"  }")
+		r = append(r, idt+"  goto "+defaultDst+";")
+		r = append(r, idt+"}")
+		r = append(r, "#else")
+		r = append(r, body[i:j+2]...)
+		r = append(r, "#endif")
+		i = j + 1
+	}
+	return r
+}
+
 func hasOuterParens(str string, n int) bool {
 	type phase int
 	const (
@@ -1693,6 +1782,41 @@ loop:
 	return str
 }
 
+// maskShiftCount returns the shift count masked by the bit width, as WebAssembly does.
+// Shifting by a count that is equal to or greater than the width is undefined in C++.
+func maskShiftCount(count string, bits int) string {
+	if v, err := strconv.ParseInt(strings.TrimSuffix(count, "LL"), 10, 64); err == nil {
+		return fmt.Sprintf("%d", v&int64(bits-1))
+	}
+	return fmt.Sprintf("(%s) & %d", count, bits-1)
+}
+
+// callsMoreStack reports whether the then-branch of the if, which instrs follow, calls runtime.morestack, i.e., the
+// branch is the stack check of the Go runtime at the beginning of a function.
+func callsMoreStack(instrs []wasm.Instr, funcs []*wasmFunc) bool {
+	var depth int
+	for _, instr := range instrs {
+		switch instr.Op {
+		case wasm.OpBlock, wasm.OpLoop, wasm.OpIf:
+			depth++
+		case wasm.OpElse:
+			if depth == 0 {
+				return false
+			}
+		case wasm.OpEnd:
+			if depth == 0 {
+				return false
+			}
+			depth--
+		case wasm.OpCall:
+			if strings.HasPrefix(funcs[instr.Immediates[0].(uint32)].Wasm.Name, "runtime.morestack") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func optimizeCondition(cond string) string {
 	for {
 		const (
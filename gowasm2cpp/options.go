@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+// OptionError is an error in Options. The option is invalid by itself, or conflicts with the other arguments or the
+// module.
+type OptionError struct {
+	// Option is the name of the field of Options like "CppStd".
+	Option string
+
+	// Err is the reason.
+	Err error
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("option %s: %v", e.Option, e.Err)
+}
+
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+func optionErrorf(option string, format string, args ...interface{}) error {
+	return &OptionError{
+		Option: option,
+		Err:    fmt.Errorf(format, args...),
+	}
+}
+
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateOptions checks options and their consistency with namespace. The returned error is an *OptionError.
+func validateOptions(options *Options, namespace string) error {
+	v, _, err := cppStdVersion(options.CppStd)
+	if err != nil {
+		return &OptionError{Option: "CppStd", Err: err}
+	}
+	if options.Modules && v < 20 {
+		return optionErrorf("Modules", "C++ modules require C++20 or later")
+	}
+
+	if options.ExportMacro != "" && !identifierRe.MatchString(options.ExportMacro) {
+		return optionErrorf("ExportMacro", "invalid macro name: %q", options.ExportMacro)
+	}
+
+	names := map[string]struct{}{}
+	for _, a := range options.Assets {
+		if strings.IndexByte(a.Name, 0) >= 0 {
+			return optionErrorf("Assets", "asset name must not include NUL: %q", a.Name)
+		}
+		if _, ok := names[a.Name]; ok {
+			return optionErrorf("Assets", "duplicated asset name: %q", a.Name)
+		}
+		names[a.Name] = struct{}{}
+	}
+
+	for _, s := range options.Stubs {
+		if s.Name == "" || s.Name == ".*" {
+			return optionErrorf("Stubs", "stub name must not be empty")
+		}
+		if s.Mode != StubTrap && s.Mode != StubNop {
+			return optionErrorf("Stubs", "%s: invalid stub mode: %d", s.Name, s.Mode)
+		}
+	}
+
+	if _, ok := scaffolds[options.Scaffold]; options.Scaffold != "" && !ok {
+		return optionErrorf("Scaffold", "unsupported platform: %q", options.Scaffold)
+	}
+
+	if options.Emscripten && options.Scaffold != "" {
+		return optionErrorf("Scaffold", "the project for %s cannot be built with Emscripten", options.Scaffold)
+	}
+
+	for i := range options.Intrinsics {
+		if err := options.Intrinsics[i].validate(); err != nil {
+			return &OptionError{Option: "Intrinsics", Err: err}
+		}
+	}
+
+	for i := range options.ImportFuncs {
+		if err := options.ImportFuncs[i].validate(); err != nil {
+			return &OptionError{Option: "ImportFuncs", Err: err}
+		}
+	}
+
+	if options.Schema != nil {
+		if err := options.Schema.validate(); err != nil {
+			return &OptionError{Option: "Schema", Err: err}
+		}
+	} else if options.SchemaGo != "" {
+		return optionErrorf("SchemaGo", "no schema for %s", options.SchemaGo)
+	}
+
+	if err := validateExportNames(options.ExportNames); err != nil {
+		return &OptionError{Option: "ExportNames", Err: err}
+	}
+
+	if options.MaxFunctionLines < 0 {
+		return optionErrorf("MaxFunctionLines", "must not be negative but %d", options.MaxFunctionLines)
+	}
+
+	return nil
+}
+
+// validateOptionsWithModule checks that options are consistent with mod. The returned error is an *OptionError.
+func validateOptionsWithModule(options *Options, mod *wasm.Module) error {
+	// The Go program reads the assets via go2cpp.getAsset, which requires syscall/js.
+	if len(options.Assets) > 0 {
+		var js bool
+		for _, e := range mod.Imports {
+			if strings.HasPrefix(e.FieldName, "syscall/js.") {
+				js = true
+				break
+			}
+		}
+		if !js {
+			return optionErrorf("Assets", "the module doesn't import syscall/js, so the Go program cannot read the assets")
+		}
+	}
+
+	for _, name := range options.Exports {
+		var found bool
+		for _, e := range mod.Exports {
+			if e.Kind == wasm.ExternalFunction && e.FieldStr == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return optionErrorf("Exports", "no such exported function: %q", name)
+		}
+	}
+
+	for name := range options.ExportNames {
+		var found bool
+		for _, e := range mod.Exports {
+			if e.Kind == wasm.ExternalFunction && e.FieldStr == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return optionErrorf("ExportNames", "no such exported function: %q", name)
+		}
+	}
+
+	return nil
+}
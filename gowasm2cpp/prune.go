@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+// reachableFuncs returns the indices of the functions reachable from roots in funcs.
+//
+// A call_indirect might call any function in the tables with the same signature, so all of them are reachable. The
+// bodies of the intrinsics and the stubs are not analyzed: they must not call other functions.
+func reachableFuncs(funcs []*wasmFunc, roots []int, tables [][]uint32) (map[int]struct{}, error) {
+	// The table elements by the signatures.
+	elems := map[string][]int{}
+	for _, t := range tables {
+		for _, idx := range t {
+			sig := sigString(funcs[idx].Wasm.Sig)
+			elems[sig] = append(elems[sig], int(idx))
+		}
+	}
+
+	reachable := map[int]struct{}{}
+	queue := append([]int{}, roots...)
+	for len(queue) > 0 {
+		idx := queue[0]
+		queue = queue[1:]
+		if _, ok := reachable[idx]; ok {
+			continue
+		}
+		reachable[idx] = struct{}{}
+
+		f := funcs[idx]
+		if f.Import || f.Wasm.Body == nil {
+			continue
+		}
+		instrs, err := f.Wasm.Body.Instrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, instr := range instrs {
+			switch instr.Op {
+			case wasm.OpCall:
+				queue = append(queue, int(instr.Immediates[0].(uint32)))
+			case wasm.OpCallIndirect:
+				sig := sigString(f.Types[instr.Immediates[0].(uint32)].Sig)
+				queue = append(queue, elems[sig]...)
+				// The elements are enqueued once per signature.
+				delete(elems, sig)
+			}
+		}
+	}
+	return reachable, nil
+}
+
+// prunedModule is the part of a module reachable from the exports.
+type prunedModule struct {
+	importFuncs []*wasmFunc
+	funcs       []*wasmFunc
+	exports     []*wasmExport
+
+	// pruned are the indices of the removed functions.
+	pruned []int
+
+	// js reports whether the reachable functions import syscall/js.
+	js bool
+}
+
+// pruneModule removes the exports not in names and the functions unreachable from the remaining exports.
+func pruneModule(importFuncs, funcs []*wasmFunc, exports []*wasmExport, tables [][]uint32, names []string) (*prunedModule, error) {
+	kept := map[string]struct{}{}
+	for _, n := range names {
+		kept[n] = struct{}{}
+	}
+
+	p := &prunedModule{}
+	var roots []int
+	for _, e := range exports {
+		if _, ok := kept[e.Name]; !ok {
+			continue
+		}
+		p.exports = append(p.exports, e)
+		roots = append(roots, e.Index)
+	}
+
+	allfs := append(append([]*wasmFunc{}, importFuncs...), funcs...)
+	reachable, err := reachableFuncs(allfs, roots, tables)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range importFuncs {
+		if _, ok := reachable[f.Index]; !ok {
+			p.pruned = append(p.pruned, f.Index)
+			continue
+		}
+		p.importFuncs = append(p.importFuncs, f)
+		if strings.HasPrefix(f.Wasm.Name, "syscall/js.") {
+			p.js = true
+		}
+	}
+	for _, f := range funcs {
+		if _, ok := reachable[f.Index]; !ok {
+			p.pruned = append(p.pruned, f.Index)
+			continue
+		}
+		p.funcs = append(p.funcs, f)
+	}
+	sort.Ints(p.pruned)
+	return p, nil
+}
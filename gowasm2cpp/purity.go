@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+// purity is how much a translated function depends on the state of the instance.
+type purity int
+
+const (
+	// purityNone is for the functions that might have side effects.
+	purityNone purity = iota
+
+	// purityPure is for the functions that read the memory or the globals but change nothing.
+	purityPure
+
+	// purityConst is for the functions that depend only on their arguments.
+	purityConst
+)
+
+// Cpp returns the macro of the function attribute for p.
+func (p purity) Cpp() string {
+	switch p {
+	case purityPure:
+		return "GO2CPP_PURE"
+	case purityConst:
+		return "GO2CPP_CONST"
+	default:
+		return ""
+	}
+}
+
+// purity reports the purity of the function body.
+//
+// The functions with calls, stores, global.set, loops or the instructions that might trap are not pure. A loop might
+// not end, and the compilers assume that pure functions return. The functions returning nothing are not pure either,
+// as there is nothing to merge.
+func (f *wasmFunc) purity() (purity, error) {
+	if f.Import || f.Wasm.Body == nil || f.BodyStr != "" || f.marker {
+		return purityNone, nil
+	}
+	if len(f.Wasm.Sig.ReturnTypes) == 0 {
+		return purityNone, nil
+	}
+	instrs, err := f.Wasm.Body.Instrs()
+	if err != nil {
+		return purityNone, err
+	}
+
+	p := purityConst
+	for _, instr := range instrs {
+		switch op := instr.Op; {
+		case op == wasm.OpGetGlobal, op == wasm.OpCurrentMemory:
+			p = purityPure
+		case wasm.OpI32Load <= op && op <= wasm.OpI64Load32u:
+			p = purityPure
+		case op == wasm.OpI32DivS, op == wasm.OpI32DivU, op == wasm.OpI32RemS, op == wasm.OpI32RemU,
+			op == wasm.OpI64DivS, op == wasm.OpI64DivU, op == wasm.OpI64RemS, op == wasm.OpI64RemU:
+			return purityNone, nil
+		case op == wasm.OpI32TruncSF32, op == wasm.OpI32TruncUF32, op == wasm.OpI32TruncSF64, op == wasm.OpI32TruncUF64,
+			op == wasm.OpI64TruncSF32, op == wasm.OpI64TruncUF32, op == wasm.OpI64TruncSF64, op == wasm.OpI64TruncUF64:
+			return purityNone, nil
+		case op == wasm.OpNop, op == wasm.OpBlock, op == wasm.OpIf, op == wasm.OpElse, op == wasm.OpEnd,
+			op == wasm.OpBr, op == wasm.OpBrIf, op == wasm.OpBrTable, op == wasm.OpReturn, op == wasm.OpDrop,
+			op == wasm.OpSelect, op == wasm.OpGetLocal, op == wasm.OpSetLocal, op == wasm.OpTeeLocal:
+		case wasm.OpI32Const <= op && op <= wasm.OpI64Extend32S:
+			// The constants, the comparisons, the arithmetic and the conversions.
+		default:
+			return purityNone, nil
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,456 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// importParams is the types of the parameters of the imports by Go, as in their signatures in importFuncBodies. The
+// parameters are on the stack after the stack pointer's slot. debug takes its parameter as a WebAssembly parameter.
+var importParams = map[string][]string{
+	"runtime.wasmExit":              {"int32"},
+	"runtime.wasmWrite":             {"uintptr", "unsafe.Pointer", "int32"},
+	"runtime.resetMemoryDataView":   {},
+	"runtime.nanotime1":             {},
+	"runtime.walltime":              {},
+	"runtime.walltime1":             {},
+	"runtime.scheduleTimeoutEvent":  {"int64"},
+	"runtime.clearTimeoutEvent":     {"int32"},
+	"runtime.getRandomData":         {"[]byte"},
+	"syscall/js.finalizeRef":        {"ref"},
+	"syscall/js.stringVal":          {"string"},
+	"syscall/js.valueGet":           {"ref", "string"},
+	"syscall/js.valueSet":           {"ref", "string", "ref"},
+	"syscall/js.valueDelete":        {"ref", "string"},
+	"syscall/js.valueIndex":         {"ref", "int"},
+	"syscall/js.valueSetIndex":      {"ref", "int", "ref"},
+	"syscall/js.valueCall":          {"ref", "string", "[]ref"},
+	"syscall/js.valueInvoke":        {"ref", "[]ref"},
+	"syscall/js.valueNew":           {"ref", "[]ref"},
+	"syscall/js.valueLength":        {"ref"},
+	"syscall/js.valuePrepareString": {"ref"},
+	"syscall/js.valueLoadString":    {"ref", "[]byte"},
+	"syscall/js.valueInstanceOf":    {"ref", "ref"},
+	"syscall/js.copyBytesToGo":      {"[]byte", "ref"},
+	"syscall/js.copyBytesToJS":      {"ref", "[]byte"},
+	"debug":                         {},
+}
+
+// importArgsSize returns the size of the arguments that the import name takes on the stack after the stack pointer's
+// slot.
+func importArgsSize(name string) (int, error) {
+	params, ok := importParams[name]
+	if !ok {
+		return 0, fmt.Errorf("the parameters of import %s are unknown", name)
+	}
+	var size int
+	for _, p := range params {
+		// Each parameter is aligned to 8 bytes. A slice is a pointer, a length and a capacity, and a string is a pointer
+		// and a length.
+		switch {
+		case strings.HasPrefix(p, "[]"):
+			size += 24
+		case p == "string":
+			size += 16
+		default:
+			size += 8
+		}
+	}
+	return size, nil
+}
+
+// recordBody wraps the body of the import name with the notification to Hooks::on_import.
+func recordBody(name string, body string) (string, error) {
+	size, err := importArgsSize(name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("  go_->OnImport(%s, local0_, %d);\n%s", cppStringLiteral(name), size, body), nil
+}
+
+func writeRecord(dir string, incpath string, namespace string, exportMacro string) error {
+	{
+		f, err := os.Create(filepath.Join(dir, "record.h"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := recordHTmpl.Execute(f, struct {
+			IncludeGuard string
+			VersionCheck string
+			IncludePath  string
+			Namespace    string
+			Export       string
+		}{
+			IncludeGuard: includeGuard(namespace) + "_RECORD_H",
+			VersionCheck: versionCheck(namespace, "record.h"),
+			IncludePath:  incpath,
+			Namespace:    namespace,
+			Export:       exportPrefix(exportMacro),
+		}); err != nil {
+			return err
+		}
+	}
+	{
+		f, err := os.Create(filepath.Join(dir, "record.cpp"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := recordCppTmpl.Execute(f, struct {
+			IncludePath string
+			Namespace   string
+		}{
+			IncludePath: incpath,
+			Namespace:   namespace,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var recordHTmpl = template.Must(template.New("record.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include "{{.IncludePath}}bytes.h"
+#include "{{.IncludePath}}config.h"
+#include "{{.IncludePath}}host.h"
+
+#include <cstdint>
+#include <istream>
+#include <ostream>
+#include <string>
+#include <vector>
+
+{{.VersionCheck}}
+
+namespace {{.Namespace}} {
+
+// Recorder records the interactions between the Go program and the host: the import calls with the bytes of their
+// arguments on the stack, the events from the host, and the values from HostServices like the time and the random
+// values. Give Recorder to Go as its HostServices, and call OnImport and OnEvent from Go::Hooks::on_import and
+// Go::Hooks::on_event.
+//
+// The arguments are recorded as they are, i.e., the IDs of the values and the addresses, not the contents they refer
+// to. The output of HostServices like DebugWrite is forwarded to the base without being recorded.
+class {{.Export}}Recorder : public HostServices {
+public:
+  // base provides the actual services. base and out must outlive Recorder.
+  Recorder(HostServices* base, std::ostream* out);
+
+  void OnImport(const char* name, BytesSpan args);
+  void OnEvent(const std::string& event);
+
+  int64_t GetMonotonicTime() override;
+  int64_t GetUnixTime() override;
+  void GetRandomBytes(BytesSpan bytes) override;
+  void DebugWrite(const std::vector<uint8_t>& bytes) override;
+  void Log(LogLevel level, const std::string& message) override;
+  std::string GetLocalStorageItem(const std::string& key) override;
+  void SetLocalStorageItem(const std::string& key, const std::string& value) override;
+
+private:
+  HostServices* base_;
+  std::ostream* out_;
+};
+
+// Replayer replays a recording of Recorder. The values from HostServices are the recorded ones, OnImport checks that
+// the Go program calls the same imports with the same arguments, and OnEvent checks that the host feeds the same
+// events. At the first difference, the recording is regarded as diverged: the difference is logged, and the base
+// provides the values after that.
+//
+// Replayer doesn't make the events: the host must feed the same events to the Go program in the same order, e.g.,
+// with the deterministic mode and Game::FramePacing::fixed_timestep, and OnEvent reports the first one that differs.
+class {{.Export}}Replayer : public HostServices {
+public:
+  // base provides the services not recorded and the values after the divergence. base and in must outlive Replayer.
+  Replayer(HostServices* base, std::istream* in);
+
+  void OnImport(const char* name, BytesSpan args);
+  void OnEvent(const std::string& event);
+
+  // Diverged reports whether the Go program diverged from the recording. Divergence returns the description with the
+  // 1-based index of the record where the recording diverged.
+  bool Diverged() const;
+  const std::string& Divergence() const;
+
+  int64_t GetMonotonicTime() override;
+  int64_t GetUnixTime() override;
+  void GetRandomBytes(BytesSpan bytes) override;
+  void DebugWrite(const std::vector<uint8_t>& bytes) override;
+  void Log(LogLevel level, const std::string& message) override;
+  std::string GetLocalStorageItem(const std::string& key) override;
+  void SetLocalStorageItem(const std::string& key, const std::string& value) override;
+
+private:
+  // Next reads the next record of kind with the expected payload want, and reports whether the record matches. If
+  // want is null, any payload is accepted.
+  bool Next(char kind, const std::vector<uint8_t>* want, std::vector<uint8_t>* payload);
+  void Diverge(const std::string& message);
+
+  HostServices* base_;
+  std::istream* in_;
+  std::string divergence_;
+
+  // The number of the records read, to tell where the recording diverged.
+  uint64_t record_count_ = 0;
+};
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
+
+var recordCppTmpl = template.Must(template.New("record.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}record.h"
+
+#include <algorithm>
+#include <cstring>
+
+namespace {{.Namespace}} {
+
+namespace {
+
+// A record is a kind, the little-endian 32-bit size of the payload and the payload.
+constexpr char kImport = 'I';
+constexpr char kEvent = 'E';
+constexpr char kMonotonicTime = 'M';
+constexpr char kUnixTime = 'U';
+constexpr char kRandomBytes = 'R';
+constexpr char kLocalStorageItem = 'S';
+
+void WriteRecord(std::ostream* out, char kind, const uint8_t* data, size_t size) {
+  uint8_t header[5] = {
+    static_cast<uint8_t>(kind),
+    static_cast<uint8_t>(size),
+    static_cast<uint8_t>(size >> 8),
+    static_cast<uint8_t>(size >> 16),
+    static_cast<uint8_t>(size >> 24),
+  };
+  out->write(reinterpret_cast<const char*>(header), sizeof(header));
+  out->write(reinterpret_cast<const char*>(data), size);
+}
+
+bool ReadRecord(std::istream* in, char* kind, std::vector<uint8_t>* payload) {
+  uint8_t header[5];
+  if (!in->read(reinterpret_cast<char*>(header), sizeof(header))) {
+    return false;
+  }
+  *kind = static_cast<char>(header[0]);
+  size_t size = static_cast<size_t>(header[1]) | static_cast<size_t>(header[2]) << 8 |
+      static_cast<size_t>(header[3]) << 16 | static_cast<size_t>(header[4]) << 24;
+  payload->resize(size);
+  return size == 0 || !!in->read(reinterpret_cast<char*>(payload->data()), size);
+}
+
+std::vector<uint8_t> ImportPayload(const char* name, BytesSpan args) {
+  std::vector<uint8_t> payload(name, name + std::strlen(name) + 1);
+  payload.insert(payload.end(), args.begin(), args.end());
+  return payload;
+}
+
+std::vector<uint8_t> Int64Payload(int64_t v) {
+  std::vector<uint8_t> payload(8);
+  for (int i = 0; i < 8; i++) {
+    payload[i] = static_cast<uint8_t>(static_cast<uint64_t>(v) >> (i * 8));
+  }
+  return payload;
+}
+
+int64_t Int64FromPayload(const std::vector<uint8_t>& payload) {
+  uint64_t v = 0;
+  for (size_t i = 0; i < 8 && i < payload.size(); i++) {
+    v |= static_cast<uint64_t>(payload[i]) << (i * 8);
+  }
+  return static_cast<int64_t>(v);
+}
+
+const char* KindName(char kind) {
+  switch (kind) {
+  case kImport:
+    return "import";
+  case kEvent:
+    return "event";
+  case kMonotonicTime:
+    return "GetMonotonicTime";
+  case kUnixTime:
+    return "GetUnixTime";
+  case kRandomBytes:
+    return "GetRandomBytes";
+  case kLocalStorageItem:
+    return "GetLocalStorageItem";
+  }
+  return "unknown";
+}
+
+}
+
+Recorder::Recorder(HostServices* base, std::ostream* out)
+    : base_{base},
+      out_{out} {
+}
+
+void Recorder::OnImport(const char* name, BytesSpan args) {
+  std::vector<uint8_t> payload = ImportPayload(name, args);
+  WriteRecord(out_, kImport, payload.data(), payload.size());
+}
+
+void Recorder::OnEvent(const std::string& event) {
+  WriteRecord(out_, kEvent, reinterpret_cast<const uint8_t*>(event.data()), event.size());
+}
+
+int64_t Recorder::GetMonotonicTime() {
+  int64_t t = base_->GetMonotonicTime();
+  std::vector<uint8_t> payload = Int64Payload(t);
+  WriteRecord(out_, kMonotonicTime, payload.data(), payload.size());
+  return t;
+}
+
+int64_t Recorder::GetUnixTime() {
+  int64_t t = base_->GetUnixTime();
+  std::vector<uint8_t> payload = Int64Payload(t);
+  WriteRecord(out_, kUnixTime, payload.data(), payload.size());
+  return t;
+}
+
+void Recorder::GetRandomBytes(BytesSpan bytes) {
+  base_->GetRandomBytes(bytes);
+  WriteRecord(out_, kRandomBytes, bytes.data(), bytes.size());
+}
+
+void Recorder::DebugWrite(const std::vector<uint8_t>& bytes) {
+  base_->DebugWrite(bytes);
+}
+
+void Recorder::Log(LogLevel level, const std::string& message) {
+  base_->Log(level, message);
+}
+
+std::string Recorder::GetLocalStorageItem(const std::string& key) {
+  std::string value = base_->GetLocalStorageItem(key);
+  WriteRecord(out_, kLocalStorageItem, reinterpret_cast<const uint8_t*>(value.data()), value.size());
+  return value;
+}
+
+void Recorder::SetLocalStorageItem(const std::string& key, const std::string& value) {
+  base_->SetLocalStorageItem(key, value);
+}
+
+Replayer::Replayer(HostServices* base, std::istream* in)
+    : base_{base},
+      in_{in} {
+}
+
+void Replayer::OnImport(const char* name, BytesSpan args) {
+  std::vector<uint8_t> want = ImportPayload(name, args);
+  std::vector<uint8_t> payload;
+  Next(kImport, &want, &payload);
+}
+
+void Replayer::OnEvent(const std::string& event) {
+  std::vector<uint8_t> want(event.begin(), event.end());
+  std::vector<uint8_t> payload;
+  Next(kEvent, &want, &payload);
+}
+
+bool Replayer::Diverged() const {
+  return !divergence_.empty();
+}
+
+const std::string& Replayer::Divergence() const {
+  return divergence_;
+}
+
+int64_t Replayer::GetMonotonicTime() {
+  std::vector<uint8_t> payload;
+  if (!Next(kMonotonicTime, nullptr, &payload)) {
+    return base_->GetMonotonicTime();
+  }
+  return Int64FromPayload(payload);
+}
+
+int64_t Replayer::GetUnixTime() {
+  std::vector<uint8_t> payload;
+  if (!Next(kUnixTime, nullptr, &payload)) {
+    return base_->GetUnixTime();
+  }
+  return Int64FromPayload(payload);
+}
+
+void Replayer::GetRandomBytes(BytesSpan bytes) {
+  std::vector<uint8_t> payload;
+  if (!Next(kRandomBytes, nullptr, &payload) || payload.size() != bytes.size()) {
+    if (!Diverged()) {
+      Diverge("GetRandomBytes: the size differs from the recording");
+    }
+    base_->GetRandomBytes(bytes);
+    return;
+  }
+  std::copy(payload.begin(), payload.end(), bytes.begin());
+}
+
+void Replayer::DebugWrite(const std::vector<uint8_t>& bytes) {
+  base_->DebugWrite(bytes);
+}
+
+void Replayer::Log(LogLevel level, const std::string& message) {
+  base_->Log(level, message);
+}
+
+std::string Replayer::GetLocalStorageItem(const std::string& key) {
+  std::vector<uint8_t> payload;
+  if (!Next(kLocalStorageItem, nullptr, &payload)) {
+    return base_->GetLocalStorageItem(key);
+  }
+  return std::string(payload.begin(), payload.end());
+}
+
+void Replayer::SetLocalStorageItem(const std::string& key, const std::string& value) {
+  base_->SetLocalStorageItem(key, value);
+}
+
+bool Replayer::Next(char kind, const std::vector<uint8_t>* want, std::vector<uint8_t>* payload) {
+  if (Diverged()) {
+    return false;
+  }
+  char got_kind;
+  if (!ReadRecord(in_, &got_kind, payload)) {
+    Diverge(std::string(KindName(kind)) + ": the recording ended");
+    return false;
+  }
+  record_count_++;
+  if (got_kind != kind) {
+    Diverge(std::string(KindName(kind)) + ": the recording has " + KindName(got_kind) + " instead");
+    return false;
+  }
+  if (want && *want != *payload) {
+    if (kind == kEvent) {
+      Diverge("event " + std::string(want->begin(), want->end()) + ": the recording has " +
+              std::string(payload->begin(), payload->end()) + " instead");
+      return false;
+    }
+    std::string name(reinterpret_cast<const char*>(want->data()));
+    Diverge("import " + name + ": the name or the arguments differ from the recording");
+    return false;
+  }
+  return true;
+}
+
+void Replayer::Diverge(const std::string& message) {
+  divergence_ = "record " + std::to_string(record_count_) + ": " + message;
+  base_->Log(LogLevel::kError, "the Go program diverged from the recording at " + divergence_);
+}
+
+}
+`))
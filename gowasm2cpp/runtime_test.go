@@ -0,0 +1,1535 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// runtimeBuild is the generated code of testdata/ids.wat with options, compiled into object files.
+type runtimeBuild struct {
+	once sync.Once
+	dir  string
+	objs []string
+	err  string
+}
+
+var (
+	runtimeBuildsM sync.Mutex
+	// runtimeBuilds is keyed by the formatted options.
+	runtimeBuilds = map[string]*runtimeBuild{}
+)
+
+// buildRuntime generates the code for testdata/ids.wat with options and compiles it into object files once for each
+// options, so that the tests of the runtime can link them with their own main functions.
+func buildRuntime(t *testing.T, cxx string, options Options) (string, []string) {
+	key := fmt.Sprintf("%+v", options)
+	runtimeBuildsM.Lock()
+	b, ok := runtimeBuilds[key]
+	if !ok {
+		b = &runtimeBuild{}
+		runtimeBuilds[key] = b
+	}
+	runtimeBuildsM.Unlock()
+
+	b.once.Do(func() {
+		dir, err := ioutil.TempDir("", "go2cpp-runtime")
+		if err != nil {
+			b.err = err.Error()
+			return
+		}
+		// The directory is removed at TestMain even if the build fails.
+		b.dir = dir
+		if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ids.wat"), "go2cpp_test", &options); err != nil {
+			b.err = err.Error()
+			return
+		}
+		srcs, err := filepath.Glob(filepath.Join(dir, "*.cpp"))
+		if err != nil {
+			b.err = err.Error()
+			return
+		}
+		cmd := exec.Command(cxx, append([]string{"-std=c++14", "-pthread", "-c"}, srcs...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			b.err = "compiling failed: " + err.Error() + "\n" + string(out)
+			return
+		}
+		for _, src := range srcs {
+			b.objs = append(b.objs, strings.TrimSuffix(src, ".cpp")+".o")
+		}
+	})
+	if b.err != "" {
+		t.Fatal(b.err)
+	}
+	return b.dir, b.objs
+}
+
+// runRuntime links the generated runtime with mainCpp and returns the output of the program.
+func runRuntime(t *testing.T, mainCpp string) string {
+	return runRuntimeWithOptions(t, Options{}, mainCpp)
+}
+
+// runRuntimeWithOptions links the runtime generated with options with mainCpp and returns the output of the program.
+func runRuntimeWithOptions(t *testing.T, options Options, mainCpp string) string {
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("C++ compiler not found")
+	}
+	dir, objs := buildRuntime(t, cxx, options)
+	return runMain(t, cxx, mainCpp, append([]string{"-I" + dir}, objs...))
+}
+
+// runRuntimeSources compiles mainCpp with the generated files srcs and the flags instead of linking the compiled
+// runtime, e.g., to configure the macros, and returns the output of the program. If srcs is nil, all the generated
+// files are compiled.
+func runRuntimeSources(t *testing.T, mainCpp string, srcs []string, flags ...string) string {
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("C++ compiler not found")
+	}
+	dir, objs := buildRuntime(t, cxx, Options{})
+	if srcs == nil {
+		for _, obj := range objs {
+			srcs = append(srcs, strings.TrimSuffix(filepath.Base(obj), ".o")+".cpp")
+		}
+	}
+	args := append([]string{"-I" + dir}, flags...)
+	for _, src := range srcs {
+		args = append(args, filepath.Join(dir, src))
+	}
+	return runMain(t, cxx, mainCpp, args)
+}
+
+func runMain(t *testing.T, cxx string, mainCpp string, args []string) string {
+	tmp := t.TempDir()
+	main := filepath.Join(tmp, "main.cpp")
+	if err := ioutil.WriteFile(main, []byte(mainCpp), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "test")
+	cmd := exec.Command(cxx, append([]string{"-std=c++14", "-pthread", "-o", bin, main}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("compiling failed: %v\n%s", err, out)
+	}
+	out, err := exec.Command(bin).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	return string(out)
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	for _, b := range runtimeBuilds {
+		if b.dir != "" {
+			os.RemoveAll(b.dir)
+		}
+	}
+	os.Exit(code)
+}
+
+// TestUnbindHostServices checks that the properties of the global object referring to Go are restored when Go is
+// destroyed.
+func TestUnbindHostServices(t *testing.T) {
+	const mainCpp = `#include "go.h"
+
+#include <iostream>
+#include <memory>
+
+int main() {
+  auto& global = go2cpp_test::Value::Global().ToObject();
+  go2cpp_test::Value fetch = global.Get("fetch");
+  {
+    go2cpp_test::Go go;
+    go2cpp_test::Go::Capabilities c;
+    c.network = false;
+    go.SetCapabilities(c);
+    go.Run();
+    std::cout << global.Get("console").IsUndefined() << " " << (global.Get("fetch") == fetch) << std::endl;
+  }
+  std::cout << global.Get("console").IsUndefined() << " " << global.Get("localStorage").IsUndefined() << " "
+            << (global.Get("fetch") == fetch) << std::endl;
+  return 0;
+}
+`
+	out := runRuntime(t, mainCpp)
+	if !strings.HasSuffix(out, "0 0\n1 1 1\n") {
+		t.Errorf("got: %q", out)
+	}
+}
+
+// TestTaskQueueStarvation checks that a task with a lower priority is dequeued after at most kMaxSkips tasks with
+// higher priorities.
+func TestTaskQueueStarvation(t *testing.T) {
+	const mainCpp = `#include "taskqueue.h"
+
+#include <iostream>
+
+int main() {
+  using TaskQueue = go2cpp_test::TaskQueue;
+  TaskQueue queue;
+  int n = 0;
+  int frame = -1;
+  int audio = -1;
+  queue.Enqueue([&]() { frame = n; }, TaskQueue::Priority::kFrame);
+  for (int i = 0; i < 100; i++) {
+    queue.Enqueue([&]() {}, TaskQueue::Priority::kDefault);
+  }
+  queue.Enqueue([&]() { audio = n; }, TaskQueue::Priority::kAudio);
+  TaskQueue::Task task;
+  while (queue.TryDequeue(&task)) {
+    task();
+    n++;
+  }
+  std::cout << audio << " " << frame << " " << n << std::endl;
+  return 0;
+}
+`
+	// The audio task comes first, and the frame task comes after kMaxSkips (32) tasks.
+	if got, want := runRuntime(t, mainCpp), "0 32 102\n"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// TestPromise checks the order of the reactions of the promises, and that each thread has its own scheduler.
+func TestPromise(t *testing.T) {
+	const mainCpp = `#include "js.h"
+
+#include <deque>
+#include <functional>
+#include <iostream>
+#include <memory>
+#include <string>
+#include <thread>
+
+using go2cpp_test::Function;
+using go2cpp_test::Promise;
+using go2cpp_test::Value;
+
+Value Log(std::string* log, const std::string& name) {
+  return Value{std::make_shared<Function>([log, name](Value self, std::vector<Value> args) -> Value {
+    *log += name + "(" + (args.empty() ? "" : args[0].Inspect()) + ") ";
+    return args.empty() ? Value{} : Value{args[0].ToNumber() + 1};
+  })};
+}
+
+std::string Run() {
+  std::deque<std::function<void()>> tasks;
+  Promise::SetScheduler([&tasks](std::function<void()> task) {
+    tasks.push_back(std::move(task));
+  });
+
+  std::string log;
+  auto p1 = std::make_shared<Promise>();
+  auto p2 = std::make_shared<Promise>();
+  Value chained = p1->Then(Log(&log, "a"), Value{});
+  chained.ToObject().Get("then").ToObject().Invoke(chained, {Log(&log, "c"), Value{}});
+  p1->Then(Log(&log, "b"), Value{});
+  p2->Then(Value{}, Log(&log, "rejected"));
+  // A promise resolved with another promise follows it.
+  auto p3 = std::make_shared<Promise>();
+  p3->Resolve(Value{p1});
+  p3->Then(Log(&log, "d"), Value{});
+
+  p2->Reject(Value{10.0});
+  p1->Resolve(Value{1.0});
+  log += "| ";
+  while (!tasks.empty()) {
+    auto task = std::move(tasks.front());
+    tasks.pop_front();
+    task();
+  }
+  Promise::SetScheduler(nullptr);
+  return log;
+}
+
+int main() {
+  std::string log0 = Run();
+
+  // Another thread's scheduler doesn't affect this thread's.
+  size_t task_num = 0;
+  Promise::SetScheduler([&task_num](std::function<void()> task) {
+    task_num++;
+  });
+  std::string log1;
+  std::thread th{[&log1]() {
+    log1 = Run();
+  }};
+  th.join();
+  auto p0 = std::make_shared<Promise>();
+  p0->Then(Value{}, Value{});
+  p0->Resolve(Value{});
+  Promise::SetScheduler(nullptr);
+
+  std::cout << log0 << std::endl;
+  std::cout << (log0 == log1) << " " << task_num << std::endl;
+
+  // Without a scheduler, the reactions run synchronously.
+  std::string log;
+  auto p = std::make_shared<Promise>();
+  p->Then(Log(&log, "sync"), Value{});
+  p->Resolve(Value{2.0});
+  std::cout << log << std::endl;
+  return 0;
+}
+`
+	// The reactions run only when the scheduler runs the tasks, in the order of the settlements and the registrations.
+	const want = "| rejected(10) a(1) b(1) c(2) d(1) \n1 1\nsync(2) \n"
+	if got := runRuntime(t, mainCpp); got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// TestJSONParseNumber checks that JSON.parse parses the numbers like JavaScript, including the numbers out of range.
+func TestJSONParseNumber(t *testing.T) {
+	const mainCpp = `#include "js.h"
+
+#include <iostream>
+
+using go2cpp_test::Value;
+
+int main() {
+  Value json = go2cpp_test::Value::Global().ToObject().Get("JSON");
+  Value parse = json.ToObject().Get("parse");
+  for (const char* text : {"0", "-0", "0.5", "-12.5e-1", "123456789012345678901234567890", "1.7976931348623157e308",
+                           "1.8e308", "1e400", "-1e400", "5e-324", "1e-400", "-1e-400", "0.0001e-320", "0e99999",
+                           "0.00012e310", "0.00012e313"}) {
+    double v = parse.ToObject().Invoke(json, {Value{text}}).ToNumber();
+    // 1 / v tells the sign of zero.
+    std::cout << text << ": " << go2cpp_test::NumberToString(v) << (v == 0 ? (1 / v > 0 ? " +" : " -") : "")
+              << std::endl;
+  }
+  return 0;
+}
+`
+	const want = `0: 0 +
+-0: 0 -
+0.5: 0.5
+-12.5e-1: -1.25
+123456789012345678901234567890: 1.2345678901234568e+29
+1.7976931348623157e308: 1.7976931348623157e+308
+1.8e308: Infinity
+1e400: Infinity
+-1e400: -Infinity
+5e-324: 5e-324
+1e-400: 0 +
+-1e-400: 0 -
+0.0001e-320: 0 +
+0e99999: 0 +
+0.00012e310: 1.2e+306
+0.00012e313: Infinity
+`
+	if got := runRuntime(t, mainCpp); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestJSONStringifySpace checks that JSON.stringify indents with the space argument like JavaScript, and that the
+// unimplemented reviver and replacer throw a TypeError, which Go would receive as a js.Error.
+func TestJSONStringifySpace(t *testing.T) {
+	const mainCpp = `#include "js.h"
+
+#include <cmath>
+#include <iostream>
+#include <limits>
+#include <memory>
+
+using go2cpp_test::Exception;
+using go2cpp_test::Function;
+using go2cpp_test::Value;
+
+Value Call(const std::string& name, std::vector<Value> args) {
+  Value json = Value::Global().ToObject().Get("JSON");
+  try {
+    return json.ToObject().Get(name).ToObject().Invoke(json, args);
+  } catch (const Exception& e) {
+    return Value{e.GetValue().ToObject().ToString()};
+  }
+}
+
+int main() {
+  Value array{std::vector<Value>{Value{1.0}}};
+  for (Value space : {Value{}, Value{2.0}, Value{2.9}, Value{-1.0}, Value{20.0}, Value{std::nan("")},
+                      Value{std::numeric_limits<double>::infinity()}, Value{"--"}, Value{"0123456789abc"}}) {
+    std::cout << space.Inspect() << ": " << Call("stringify", {array, Value{}, space}).ToString() << std::endl;
+  }
+
+  Value f{std::make_shared<Function>([](Value self, std::vector<Value> args) -> Value {
+    return Value{};
+  })};
+  std::cout << Call("parse", {Value{"1"}, f}).ToString() << std::endl;
+  std::cout << Call("stringify", {array, f}).ToString() << std::endl;
+  std::cout << Call("stringify", {array, array}).ToString() << std::endl;
+  // A reviver or a replacer that is not a function is ignored.
+  std::cout << Call("parse", {Value{"1"}, Value{1.0}}).Inspect() << std::endl;
+  std::cout << Call("stringify", {array, Value{"x"}}).ToString() << std::endl;
+  return 0;
+}
+`
+	const want = `undefined: [1]
+2: [
+  1
+]
+2.9: [
+  1
+]
+-1: [1]
+20: [
+          1
+]
+NaN: [1]
+Infinity: [
+          1
+]
+--: [
+--1
+]
+0123456789abc: [
+01234567891
+]
+TypeError: JSON.parse with a reviver is not implemented
+TypeError: JSON.stringify with a replacer is not implemented
+TypeError: JSON.stringify with a replacer is not implemented
+1
+[1]
+`
+	if got := runRuntime(t, mainCpp); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestDisabledCapabilities checks that the disabled operations fail like JavaScript errors: the fs functions call the
+// callbacks with EPERM, fetch returns a rejected promise, and the others throw.
+func TestDisabledCapabilities(t *testing.T) {
+	const mainCpp = `#include "go.h"
+
+#include <iostream>
+#include <memory>
+#include <string>
+
+using go2cpp_test::Exception;
+using go2cpp_test::Function;
+using go2cpp_test::Go;
+using go2cpp_test::Uint8Array;
+using go2cpp_test::Value;
+
+std::string output;
+
+// Call calls the method of the property object of the global object, or of the global object if object is empty.
+Value Call(const std::string& object, const std::string& method, std::vector<Value> args) {
+  Value o = object.empty() ? Value::Global() : Value::Global().ToObject().Get(object);
+  return o.ToObject().Get(method).ToObject().Invoke(o, args);
+}
+
+Value Log(const std::string& name) {
+  return Value{std::make_shared<Function>([name](Value self, std::vector<Value> args) -> Value {
+    std::string arg = "null";
+    if (!args.empty() && args[0].IsObject()) {
+      Value code = args[0].ToObject().Get("code");
+      arg = code.IsUndefined() ? args[0].ToObject().ToString() : code.ToString();
+    }
+    output += name + ": " + arg + "\n";
+    return Value{};
+  })};
+}
+
+void Throws(const std::string& name, std::function<void()> f) {
+  try {
+    f();
+    output += name + ": no exception\n";
+  } catch (const Exception& e) {
+    output += name + ": " + e.GetValue().ToObject().ToString() + "\n";
+  }
+}
+
+int main() {
+  Go go;
+  Go::Capabilities c;
+  c.filesystem = false;
+  c.network = false;
+  c.random = false;
+  c.storage = false;
+  go.SetCapabilities(c);
+
+  Go::Hooks hooks;
+  hooks.on_before_run = []() {
+    Value fs = Value::Global().ToObject().Get("fs");
+    output += "constants: " + std::to_string(fs.ToObject().Get("constants").IsObject()) + "\n";
+    Call("fs", "open", {Value{"file"}, Value{0.0}, Value{0.0}, Log("fs.open")});
+    auto buf = std::make_shared<Uint8Array>(1);
+    Call("fs", "write", {Value{3.0}, Value{buf}, Value{0.0}, Value{1.0}, Value::Null(), Log("fs.write 3")});
+
+    Value promise = Call("", "fetch", {Value{"https://example.com/"}});
+    promise.ToObject().Get("then").ToObject().Invoke(promise, {Log("fetch resolved"), Log("fetch rejected")});
+
+    Throws("crypto.getRandomValues", []() {
+      Call("crypto", "getRandomValues", {Value{std::make_shared<Uint8Array>(8)}});
+    });
+    Throws("localStorage.getItem", []() {
+      Call("localStorage", "getItem", {Value{"key"}});
+    });
+    Throws("localStorage.setItem", []() {
+      Call("localStorage", "setItem", {Value{"key"}, Value{"value"}});
+    });
+  };
+  go.SetHooks(hooks);
+  go.Run();
+  std::cout << output;
+  return 0;
+}
+`
+	const want = `constants: 1
+fs.open: EPERM
+fs.write 3: EPERM
+crypto.getRandomValues: Error: crypto.getRandomValues is disabled by Go::Capabilities
+localStorage.getItem: Error: localStorage.getItem is disabled by Go::Capabilities
+localStorage.setItem: Error: localStorage.setItem is disabled by Go::Capabilities
+fetch rejected: TypeError: fetch is disabled by Go::Capabilities
+`
+	if got := runRuntime(t, mainCpp); !strings.HasSuffix(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestGlobalOverrideDeletion checks that SetGlobalOverride with undefined deletes the property of the global object,
+// including the one that go2cpp sets.
+func TestGlobalOverrideDeletion(t *testing.T) {
+	const mainCpp = `#include "go.h"
+
+#include <iostream>
+
+using go2cpp_test::Go;
+using go2cpp_test::Value;
+
+int main() {
+  auto& global = Value::Global().ToObject();
+  global.Set("extra", Value{1.0});
+
+  Go go;
+  go.SetGlobalOverride("extra", Value{});
+  go.SetGlobalOverride("localStorage", Value{});
+  go.SetGlobalOverride("answer", Value{42.0});
+  Go::Hooks hooks;
+  hooks.on_before_run = [&global]() {
+    std::cout << global.Get("extra").IsUndefined() << " " << global.Get("localStorage").IsUndefined() << " "
+              << global.Get("console").IsUndefined() << " " << global.Get("answer").Inspect() << std::endl;
+  };
+  go.SetHooks(hooks);
+  go.Run();
+  return 0;
+}
+`
+	out := runRuntime(t, mainCpp)
+	if !strings.HasPrefix(out, "1 1 0 42\n") {
+		t.Errorf("got: %q", out)
+	}
+}
+
+// testDriverCpp defines TestDriver, a Game::Driver without a screen or audio. TestDriver calls onStart, which the test
+// defines, after the event listener and go2cpp are set and before the Go program starts.
+const testDriverCpp = `class TestDriver : public go2cpp_test::Game::Driver {
+public:
+  using Game = go2cpp_test::Game;
+
+  friend void onStart(TestDriver* driver);
+
+  bool Initialize() override { return true; }
+  bool Finalize() override { return true; }
+  void Update(std::function<void()> f) override { f(); }
+  int GetScreenWidth() override { return 320; }
+  int GetScreenHeight() override { return 240; }
+  double GetDevicePixelRatio() override { return 1; }
+  void* GetOpenGLFunction(const char* name) override { return nullptr; }
+  std::vector<Game::Touch> GetTouches() override { return {}; }
+  std::vector<Game::Gamepad> GetGamepads() override { return {}; }
+  void OpenAudio(int sample_rate, int channel_num, int bit_depth_in_bytes) override {}
+  void CloseAudio() override {}
+  std::unique_ptr<Game::AudioPlayer> CreateAudioPlayer(std::function<void()> on_written) override { return nullptr; }
+
+  // SetVsyncEnabled is called after the event listener is set and before the Go program starts.
+  void SetVsyncEnabled(bool enabled) override { onStart(this); }
+};
+`
+
+// TestGameEvents checks that the event objects that a listener keeps are not reused for the later events.
+func TestGameEvents(t *testing.T) {
+	const mainCpp = `#include "game.h"
+
+#include <iostream>
+#include <memory>
+
+using go2cpp_test::EventTarget;
+using go2cpp_test::Function;
+using go2cpp_test::Game;
+using go2cpp_test::Value;
+
+class TestDriver;
+void onStart(TestDriver* driver);
+` + testDriverCpp + `
+void onStart(TestDriver* driver) {
+  driver->OnGamepadConnected(1);
+  driver->OnGamepadConnected(2);
+}
+
+int main() {
+  std::vector<Value> events;
+  auto& global = Value::Global().ToObject();
+  dynamic_cast<EventTarget&>(global).AddEventListener("gamepadconnected", Value{std::make_shared<Function>(
+    [&events](Value self, std::vector<Value> args) -> Value {
+      events.push_back(args[0]);
+      return Value{};
+    })});
+
+  Game game{std::make_unique<TestDriver>()};
+  game.Run();
+  for (auto& e : events) {
+    std::cout << e.ToObject().Get("gamepad").ToObject().Get("index").Inspect() << " ";
+  }
+  std::cout << std::endl;
+  return 0;
+}
+`
+	out := runRuntime(t, mainCpp)
+	if !strings.HasSuffix(out, "1 2 \n") {
+		t.Errorf("got: %q", out)
+	}
+}
+
+// TestFrameArena checks that the frame arena hands out the same objects, cleared, after every reset, i.e., at every
+// dispatch of the events, and different objects within a reset.
+func TestFrameArena(t *testing.T) {
+	const mainCpp = `#include "game.h"
+
+#include <iostream>
+#include <memory>
+#include <sstream>
+
+using go2cpp_test::EventTarget;
+using go2cpp_test::Function;
+using go2cpp_test::Game;
+using go2cpp_test::Object;
+using go2cpp_test::Value;
+
+class TestDriver;
+void onStart(TestDriver* driver);
+` + testDriverCpp + `
+void onStart(TestDriver* driver) {
+  driver->OnGamepadConnected(1);
+  driver->OnGamepadConnected(2);
+}
+
+Game* current_game = nullptr;
+
+int main() {
+  std::ostringstream out;
+  Object* first_dict = nullptr;
+  std::vector<Value>* first_array = nullptr;
+  auto& global = Value::Global().ToObject();
+  dynamic_cast<EventTarget&>(global).AddEventListener("gamepadconnected", Value{std::make_shared<Function>(
+    [&](Value self, std::vector<Value> args) -> Value {
+      auto arena = current_game->GetFrameArena();
+      Value dict1 = arena->NewDictionary();
+      Value dict2 = arena->NewDictionary();
+      Value array = arena->NewArray(args[0].ToObject().Get("gamepad").ToObject().Get("index").ToNumber());
+
+      out << dict1.ToObject().Get("foo").Inspect() << " ";
+      out << (&dict1.ToObject() == first_dict) << (&dict1.ToObject() != &dict2.ToObject()) << " ";
+      out << (&array.ToArray() == first_array) << array.ToArray().size() << array.ToArray()[0].IsUndefined() << " ";
+
+      if (!first_dict) {
+        first_dict = &dict1.ToObject();
+        first_array = &array.ToArray();
+      }
+      dict1.ToObject().Set("foo", Value{1.0});
+      array.ToArray()[0] = Value{1.0};
+      return Value{};
+    })});
+
+  Game game{std::make_unique<TestDriver>()};
+  current_game = &game;
+  game.Run();
+  std::cout << out.str() << std::endl;
+  return 0;
+}
+`
+	out := runRuntime(t, mainCpp)
+	if want := "undefined 01 011 undefined 11 121 \n"; !strings.HasSuffix(out, want) {
+		t.Errorf("got: %q, want: %q", out, want)
+	}
+}
+
+// TestFrameStats checks that go2cpp.stats has the CPU time of the previous frame and the numbers since the previous
+// frame.
+func TestFrameStats(t *testing.T) {
+	const mainCpp = `#include "game.h"
+
+#include <chrono>
+#include <iostream>
+#include <memory>
+#include <sstream>
+#include <thread>
+
+using go2cpp_test::EventTarget;
+using go2cpp_test::Function;
+using go2cpp_test::Game;
+using go2cpp_test::Value;
+
+class TestDriver;
+void onStart(TestDriver* driver);
+` + testDriverCpp + `
+uint64_t underruns = 0;
+
+class StatsDriver : public TestDriver {
+public:
+  uint64_t GetAudioUnderrunCount() override { return underruns; }
+};
+
+std::ostringstream out;
+int frames = 0;
+Value frame;
+
+void onStart(TestDriver* driver) {
+  // requestAnimationFrame is not set yet, so request the first frame at the event.
+  driver->OnGamepadConnected(1);
+}
+
+int main() {
+  frame = Value{std::make_shared<Function>([](Value self, std::vector<Value> args) -> Value {
+    auto& global = Value::Global().ToObject();
+    auto& stats = global.Get("go2cpp").ToObject().Get("stats").ToObject();
+    double cpu_frame_time = stats.Get("cpuFrameTime").ToNumber();
+    // Only the first frame sleeps.
+    out << (cpu_frame_time >= 10) << " ";
+    out << (stats.Get("taskCount").ToNumber() >= 1) << " ";
+    out << stats.Get("audioUnderrunCount").Inspect() << std::endl;
+
+    frames++;
+    if (frames == 1) {
+      std::this_thread::sleep_for(std::chrono::milliseconds{10});
+      underruns = 3;
+    }
+    if (frames < 3) {
+      global.Get("requestAnimationFrame").ToObject().Invoke(Value{}, {frame});
+    }
+    return Value{};
+  })};
+  auto& global = Value::Global().ToObject();
+  dynamic_cast<EventTarget&>(global).AddEventListener("gamepadconnected", Value{std::make_shared<Function>(
+    [](Value self, std::vector<Value> args) -> Value {
+      Value::Global().ToObject().Get("requestAnimationFrame").ToObject().Invoke(Value{}, {frame});
+      return Value{};
+    })});
+
+  Game game{std::make_unique<StatsDriver>()};
+  game.SetFrameStatsEnabled(true);
+  game.Run();
+  std::cout << out.str();
+  return 0;
+}
+`
+	const want = "0 1 0\n1 1 3\n0 1 0\n"
+	if got := runRuntime(t, mainCpp); !strings.HasSuffix(got, want) {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// TestSubmitJob checks that go2cpp.submitJob resolves the promise with the result of the job, and rejects it when the
+// job fails or the arguments are wrong.
+func TestSubmitJob(t *testing.T) {
+	const mainCpp = `#include "game.h"
+
+#include <iostream>
+#include <map>
+#include <memory>
+#include <stdexcept>
+#include <string>
+
+using go2cpp_test::Function;
+using go2cpp_test::Game;
+using go2cpp_test::Uint8Array;
+using go2cpp_test::Value;
+
+std::map<std::string, std::string> results;
+
+Value Log(const std::string& name, const std::string& state) {
+  return Value{std::make_shared<Function>([name, state](Value self, std::vector<Value> args) -> Value {
+    std::string result = state;
+    if (args[0].IsBytes()) {
+      for (uint8_t b : args[0].ToBytes()) {
+        result += " " + std::to_string(b);
+      }
+    } else {
+      result += " " + args[0].ToObject().ToString();
+    }
+    results[name] = result;
+    return Value{};
+  })};
+}
+
+void Submit(const std::string& name, std::vector<Value> args) {
+  Value submit = Value::Global().ToObject().Get("go2cpp").ToObject().Get("submitJob");
+  Value promise = submit.ToObject().Invoke(Value{}, args);
+  promise.ToObject().Get("then").ToObject().Invoke(promise, {Log(name, "resolved"), Log(name, "rejected")});
+}
+
+class TestDriver;
+void onStart(TestDriver* driver);
+` + testDriverCpp + `
+void onStart(TestDriver* driver) {
+  auto payload = std::make_shared<Uint8Array>(3);
+  for (int i = 0; i < 3; i++) {
+    payload->ToBytes()[i] = i + 1;
+  }
+  Submit("reverse", {Value{"reverse"}, Value{payload}});
+  Submit("no payload", {Value{"reverse"}});
+  Submit("std::exception", {Value{"throw"}});
+  Submit("other exception", {Value{"throw int"}});
+  Submit("unknown", {Value{"unknown"}});
+  Submit("no name", {});
+  Submit("wrong name", {Value{1.0}});
+  Submit("wrong payload", {Value{"reverse"}, Value{"payload"}});
+}
+
+int main() {
+  Game game{std::make_unique<TestDriver>()};
+  auto pool = game.GetWorkerPool();
+  pool->RegisterJob("reverse", [](std::vector<uint8_t> payload) -> std::vector<uint8_t> {
+    return std::vector<uint8_t>(payload.rbegin(), payload.rend());
+  });
+  pool->RegisterJob("throw", [](std::vector<uint8_t> payload) -> std::vector<uint8_t> {
+    throw std::runtime_error("broken");
+  });
+  pool->RegisterJob("throw int", [](std::vector<uint8_t> payload) -> std::vector<uint8_t> {
+    throw 1;
+  });
+  game.Run();
+  for (auto& r : results) {
+    std::cout << r.first << ": " << r.second << std::endl;
+  }
+  return 0;
+}
+`
+	const want = `no name: rejected TypeError: submitJob: the job name must be a string
+no payload: resolved
+other exception: rejected Error: job failed
+reverse: resolved 3 2 1
+std::exception: rejected Error: broken
+unknown: rejected Error: submitJob: no such job: unknown
+wrong name: rejected TypeError: submitJob: the job name must be a string
+wrong payload: rejected TypeError: submitJob: the payload must be a Uint8Array
+`
+	out := runRuntime(t, mainCpp)
+	if !strings.HasSuffix(out, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+// TestCrashReport checks that on_crash is called at a trap with the report of the Go running on the thread, even after
+// another Go runs in it.
+func TestCrashReport(t *testing.T) {
+	const mainCpp = `#include "go.h"
+
+#include <cstdlib>
+#include <iostream>
+
+using go2cpp_test::Go;
+
+void PrintReport(const std::string& name, const Go::CrashReport& report) {
+  std::cout << name << ": " << report.exit_code << " " << report.reason << std::endl;
+}
+
+int main() {
+  Go a;
+  Go::Hooks hooks;
+  hooks.on_before_run = []() {
+    Go b;
+    Go::Hooks hooks;
+    hooks.on_crash = [](const Go::CrashReport& report) {
+      PrintReport("b", report);
+    };
+    b.SetHooks(hooks);
+    int code = b.Run();
+    std::cout << "b exited: " << code << std::endl;
+    go2cpp_test::Trap("boom");
+  };
+  hooks.on_crash = [](const Go::CrashReport& report) {
+    PrintReport("a", report);
+    // The process would terminate with 1 after on_crash.
+    std::_Exit(0);
+  };
+  a.SetHooks(hooks);
+  a.Run();
+  std::cout << "a exited" << std::endl;
+  return 0;
+}
+`
+	out := runRuntime(t, mainCpp)
+	if !strings.Contains(out, "\nb exited: 0\n") || strings.Contains(out, "b: ") || !strings.HasSuffix(out, "\na: 1 boom\n") {
+		t.Errorf("got: %q", out)
+	}
+}
+
+// TestSnapshot checks that a Go restored from a snapshot continues in the same state, and that an invalid snapshot is
+// rejected before the state is modified.
+func TestSnapshot(t *testing.T) {
+	const mainCpp = `#include "go.h"
+
+#include <cstdint>
+#include <cstring>
+#include <iostream>
+#include <string>
+#include <vector>
+
+using go2cpp_test::Go;
+using go2cpp_test::LogLevel;
+
+// TestLogger writes all the messages, including the output of Go, to the standard output.
+class TestLogger : public go2cpp_test::Logger {
+public:
+  void Log(LogLevel level, const std::string& message) override {
+    std::cout << message;
+  }
+};
+
+template <typename T>
+T Read(const std::vector<uint8_t>& bytes, size_t* offset) {
+  T v;
+  std::memcpy(&v, &bytes[*offset], sizeof(T));
+  *offset += sizeof(T);
+  return v;
+}
+
+void SkipString(const std::vector<uint8_t>& bytes, size_t* offset) {
+  *offset += Read<uint64_t>(bytes, offset);
+}
+
+// DuplicateID modifies the ID pool of the snapshot. If pool is true, the second pooled ID is replaced with the first
+// one. Otherwise, the first pooled ID is replaced with the ID of the first value.
+std::vector<uint8_t> DuplicateID(std::vector<uint8_t> bytes, bool pool) {
+  size_t offset = 0;
+  SkipString(bytes, &offset);  // The magic string
+  offset += sizeof(uint32_t) + sizeof(uint64_t) + sizeof(int64_t);
+  SkipString(bytes, &offset);  // The memory
+  offset += Read<uint64_t>(bytes, &offset) * sizeof(uint64_t);
+  offset += sizeof(int32_t);
+  uint64_t pool_num = Read<uint64_t>(bytes, &offset);
+  size_t pool_offset = offset;
+  offset += pool_num * sizeof(int32_t);
+  if (pool_num < 2 || Read<uint64_t>(bytes, &offset) < 1) {
+    return {};
+  }
+  int32_t id = 0;
+  if (pool) {
+    id = Read<int32_t>(bytes, &pool_offset);
+  } else {
+    id = Read<int32_t>(bytes, &offset);
+  }
+  std::memcpy(&bytes[pool_offset], &id, sizeof(id));
+  return bytes;
+}
+
+void Run(const std::string& name, std::vector<uint8_t> snapshot) {
+  std::cout << name << ": ";
+  Go go;
+  go.SetSnapshot(snapshot);
+  int code = go.Run();
+  std::cout << " " << code << std::endl;
+}
+
+int main() {
+  TestLogger logger;
+  go2cpp_test::SetLogger(&logger);
+
+  std::vector<uint8_t> snapshot;
+  {
+    Go go;
+    go.SetSnapshotHandler([&snapshot](const std::vector<uint8_t>& s) {
+      snapshot = s;
+    });
+    go.Run();
+    std::cout << std::endl;
+  }
+
+  Run("restored", snapshot);
+
+  std::vector<uint8_t> truncated = snapshot;
+  truncated.resize(truncated.size() - 1);
+  Run("truncated", truncated);
+
+  std::vector<uint8_t> magic = snapshot;
+  magic[8] ^= 1;
+  Run("magic", magic);
+
+  Run("duplicate pooled ID", DuplicateID(snapshot, true));
+  Run("pooled value ID", DuplicateID(snapshot, false));
+
+  go2cpp_test::SetLogger(nullptr);
+  return 0;
+}
+`
+	// A rejected snapshot is logged, and Go is initialized as usual.
+	const ids = "07 08 09 08 07 10 "
+	const want = ids + "\n" +
+		"restored: " + ids + " 0\n" +
+		"truncated: restoring the snapshot failed: unexpected end of the snapshot" + ids + " 0\n" +
+		"magic: restoring the snapshot failed: invalid format" + ids + " 0\n" +
+		"duplicate pooled ID: restoring the snapshot failed: invalid pooled ID 7" + ids + " 0\n" +
+		"pooled value ID: restoring the snapshot failed: value ID 9 is also pooled" + ids + " 0\n"
+	if got := runRuntime(t, mainCpp); got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// testProgramCpp defines TestProgram, an Instance that calls the imports of testdata/ids.wat directly instead of the
+// translated module, so that a test can run a Go program written in C++. The stack is at 1024, and the arguments are
+// at 1032. A value is referred to by its ID.
+const testProgramCpp = `class TestProgram : public go2cpp_test::Instance {
+public:
+  TestProgram(go2cpp_test::Mem* mem, go2cpp_test::Import* import)
+      : mem_{mem}, import_{import} {
+  }
+
+  void resume() override {}
+  int32_t getsp() override { return 1024; }
+  std::vector<uint64_t> GetGlobals() const override { return {}; }
+  void SetGlobals(const std::vector<uint64_t>& globals) override {}
+
+protected:
+  // The predefined ID 5 is the global object, and 6 is the Go object.
+  static constexpr int32_t kGlobal = 5;
+  static constexpr int32_t kGo = 6;
+
+  int32_t Get(int32_t id, const std::string& key) {
+    mem_->StoreInt64(1032, Ref(id));
+    mem_->StoreBytes(3072, std::vector<uint8_t>(key.begin(), key.end()));
+    mem_->StoreInt64(1040, 3072);
+    mem_->StoreInt64(1048, key.size());
+    import_->syscall_2fjs_2evalueGet(1024);
+    return mem_->LoadInt32(1056);
+  }
+
+  // Invoke calls the function with the arguments, each of which is a value ID or a number.
+  int32_t Invoke(int32_t id, const std::vector<int64_t>& args) {
+    for (size_t i = 0; i < args.size(); i++) {
+      mem_->StoreInt64(2048 + i * 8, args[i]);
+    }
+    mem_->StoreInt64(1032, Ref(id));
+    mem_->StoreInt64(1040, 2048);
+    mem_->StoreInt64(1048, args.size());
+    import_->syscall_2fjs_2evalueInvoke(1024);
+    return mem_->LoadInt32(1064);
+  }
+
+  void Finalize(int32_t id) {
+    mem_->StoreInt32(1032, id);
+    import_->syscall_2fjs_2efinalizeRef(1024);
+  }
+
+  int32_t ScheduleTimeout(int64_t delay) {
+    mem_->StoreInt64(1032, delay);
+    import_->runtime_2escheduleTimeoutEvent(1024);
+    return mem_->LoadInt32(1040);
+  }
+
+  void Exit(int32_t code) {
+    mem_->StoreInt32(1032, code);
+    import_->runtime_2ewasmExit(1024);
+  }
+
+  static int64_t Ref(int32_t id) { return 0x7ff8000100000000 | id; }
+
+  static int64_t Number(double v) {
+    int64_t bits;
+    std::memcpy(&bits, &v, sizeof(v));
+    return bits;
+  }
+
+  go2cpp_test::Mem* mem_;
+  go2cpp_test::Import* import_;
+};
+
+template <typename T>
+go2cpp_test::Go::InstanceFactory TestProgramFactory() {
+  return [](go2cpp_test::Mem* mem, go2cpp_test::Import* import) {
+    return std::unique_ptr<go2cpp_test::Instance>{new T{mem, import}};
+  };
+}
+`
+
+// testProgramIncludes are the headers that testProgramCpp requires.
+const testProgramIncludes = `#include "go.h"
+#include "inst.h"
+#include "mem.h"
+
+#include <cstdint>
+#include <cstring>
+#include <memory>
+#include <string>
+#include <vector>
+`
+
+// TestFuncWrapperFinalization checks that a function wrapper is destroyed when Go finalizes it and the host drops it,
+// even while the values are recorded for a snapshot.
+func TestFuncWrapperFinalization(t *testing.T) {
+	const mainCpp = testProgramIncludes + `
+#include <iostream>
+
+using go2cpp_test::Function;
+using go2cpp_test::Go;
+using go2cpp_test::Object;
+using go2cpp_test::Value;
+
+` + testProgramCpp + `
+Go* go = nullptr;
+Value kept;
+std::weak_ptr<Object> weak;
+
+class Program : public TestProgram {
+public:
+  using TestProgram::TestProgram;
+
+  void run(int32_t argc, int32_t argv) override {
+    // The argument is the js.Func ID 1.
+    int32_t f = Invoke(Get(kGo, "_makeFuncWrapper"), {Number(1)});
+    // Pass the wrapper to the host.
+    Invoke(Get(kGlobal, "keep"), {Ref(f)});
+
+    Finalize(f);
+    go->CollectGarbage(std::chrono::microseconds{0});
+    std::cout << "kept: " << !weak.expired() << std::endl;
+    kept = Value{};
+    std::cout << "dropped: " << !weak.expired() << std::endl;
+    Exit(0);
+  }
+};
+
+int main() {
+  Value::Global().ToObject().Set("keep", Value{std::make_shared<Function>(
+    [](Value self, std::vector<Value> args) -> Value {
+      kept = args[0];
+      weak = kept.ToSharedObject();
+      return Value{};
+    })});
+
+  Go g;
+  go = &g;
+  g.SetInstanceFactory(TestProgramFactory<Program>());
+  // The function wrappers are recorded for a snapshot.
+  g.SetSnapshotHandler([](const std::vector<uint8_t>& snapshot) {});
+  g.Run();
+  return 0;
+}
+`
+	if got, want := runRuntime(t, mainCpp), "kept: 1\ndropped: 0\n"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// TestResumeRetries checks that Go aborts with a diagnostic instead of hanging when the Go program doesn't clear the
+// timeout at resuming.
+func TestResumeRetries(t *testing.T) {
+	const mainCpp = testProgramIncludes + `
+#include <cstdlib>
+#include <iostream>
+
+using go2cpp_test::Go;
+
+` + testProgramCpp + `
+int resumes = 0;
+
+class Program : public TestProgram {
+public:
+  using TestProgram::TestProgram;
+
+  void run(int32_t argc, int32_t argv) override {
+    ScheduleTimeout(0);
+  }
+
+  void resume() override {
+    resumes++;
+  }
+};
+
+int main() {
+  Go go;
+  go.SetInstanceFactory(TestProgramFactory<Program>());
+  Go::Hooks hooks;
+  hooks.on_crash = [](const Go::CrashReport& report) {
+    std::cout << report.reason.substr(0, report.reason.find(" in ")) << std::endl;
+    std::cout << resumes << std::endl;
+    std::_Exit(0);
+  };
+  go.SetHooks(hooks);
+  go.Run();
+  return 0;
+}
+`
+	// The first resume and GO2CPP_MAX_RESUME_RETRIES retries.
+	const want = "the timeout 1 is still scheduled after 100000 retries of resuming the Go program\n100001\n"
+	out := runRuntime(t, mainCpp)
+	if !strings.HasSuffix(out, want) {
+		t.Errorf("got: %q, want: %q", out, want)
+	}
+}
+
+// TestMemoryGrow checks that Hooks::on_memory_grow is called with the new view of the memory when the Go program grows
+// the memory, and that Go::GetMemoryView returns the same view.
+func TestMemoryGrow(t *testing.T) {
+	const mainCpp = testProgramIncludes + `
+#include <iostream>
+
+using go2cpp_test::Go;
+
+` + testProgramCpp + `
+Go* current_go = nullptr;
+
+class Program : public TestProgram {
+public:
+  using TestProgram::TestProgram;
+
+  void run(int32_t argc, int32_t argv) override {
+    mem_->StoreInt8(4096, 42);
+    int32_t prev = mem_->Grow(2);
+    std::cout << "grown from " << prev << " pages" << std::endl;
+    mem_->StoreInt8(mem_->GetSize() * go2cpp_test::Mem::kPageSize - 1, 43);
+    go2cpp_test::BytesSpan view = current_go->GetMemoryView();
+    std::cout << static_cast<int>(view[view.size() - 1]) << std::endl;
+    Exit(0);
+  }
+};
+
+int main() {
+  Go go;
+  current_go = &go;
+  std::cout << go.GetMemoryView().size() << std::endl;
+  go.SetInstanceFactory(TestProgramFactory<Program>());
+  Go::Hooks hooks;
+  hooks.on_memory_grow = [&go](go2cpp_test::BytesSpan memory) {
+    std::cout << memory.size() / go2cpp_test::Mem::kPageSize << " pages" << std::endl;
+    std::cout << static_cast<int>(memory[4096]) << std::endl;
+    std::cout << (go.GetMemoryView().data() == memory.data() && go.GetMemoryView().size() == memory.size()) << std::endl;
+  };
+  go.SetHooks(hooks);
+  go.Run();
+  return 0;
+}
+`
+	// testdata/ids.wat has 1 page.
+	const want = "0\n3 pages\n42\n1\ngrown from 1 pages\n43\n"
+	if got := runRuntime(t, mainCpp); got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// TestWatchdogReport checks that Go::SetWatchdog reports the functions being executed once when the start of the Go program
+// doesn't end within the timeout.
+func TestWatchdogReport(t *testing.T) {
+	const mainCpp = testProgramIncludes + `#include "watchdog.h"
+
+#include <chrono>
+#include <iostream>
+#include <mutex>
+#include <thread>
+
+using go2cpp_test::FunctionMarkers;
+using go2cpp_test::Go;
+using go2cpp_test::Watchdog;
+
+` + testProgramCpp + `
+class Program : public TestProgram {
+public:
+  using TestProgram::TestProgram;
+
+  void run(int32_t argc, int32_t argv) override {
+    // TestProgram has no translated functions, so push the markers of main.run and get in testdata/ids.wat.
+    FunctionMarkers::Scope run{12};
+    {
+      FunctionMarkers::Scope get{8};
+      std::this_thread::sleep_for(std::chrono::milliseconds{300});
+    }
+    Exit(0);
+  }
+};
+
+int main() {
+  std::mutex mutex;
+  std::vector<Watchdog::Report> reports;
+
+  Go go;
+  go.SetInstanceFactory(TestProgramFactory<Program>());
+  go.SetWatchdog(std::chrono::milliseconds{50}, [&](const Watchdog::Report& report) {
+    std::lock_guard<std::mutex> lock{mutex};
+    reports.push_back(report);
+  });
+  go.Run();
+
+  std::lock_guard<std::mutex> lock{mutex};
+  std::cout << reports.size() << std::endl;
+  for (const auto& report : reports) {
+    std::cout << (report.elapsed >= std::chrono::milliseconds{50}) << " " << report.depth << std::endl;
+    for (int32_t f : report.functions) {
+      std::cout << FunctionMarkers::GetName(f) << std::endl;
+    }
+  }
+  return 0;
+}
+`
+	const want = "1\n1 2\nmain.run\nget\n"
+	if got := runRuntimeWithOptions(t, Options{Watchdog: true}, mainCpp); got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// TestDebugRefs checks that GO2CPP_DEBUG_REFS aborts at a finalizeRef more than the references given to Go, with the
+// imports that gave the ID and finalized it.
+func TestDebugRefs(t *testing.T) {
+	const mainCpp = testProgramIncludes + `
+#include <cstdlib>
+#include <iostream>
+
+using go2cpp_test::Go;
+
+` + testProgramCpp + `
+class Program : public TestProgram {
+public:
+  using TestProgram::TestProgram;
+
+  void run(int32_t argc, int32_t argv) override {
+    int32_t id = Get(kGlobal, "Array");
+    Finalize(id);
+    std::cout << "finalized" << std::endl;
+    Finalize(id);
+    std::cout << "finalized again" << std::endl;
+    Exit(0);
+  }
+};
+
+int main() {
+  Go go;
+  go.SetInstanceFactory(TestProgramFactory<Program>());
+  Go::Hooks hooks;
+  hooks.on_crash = [](const Go::CrashReport& report) {
+    std::cout << report.reason << std::endl;
+    std::_Exit(0);
+  };
+  go.SetHooks(hooks);
+  go.Run();
+  return 0;
+}
+`
+	const msg = "ref check failed after syscall/js.finalizeRef: id 7: the ref count is negative (-1.000000), " +
+		"i.e., finalized more than given (given to Go by: syscall/js.valueGet, finalized by: syscall/js.finalizeRef)"
+	// The error is logged, and then reported to on_crash.
+	const want = "finalized\n" + msg + "\n" + msg + "\n"
+	out := runRuntimeSources(t, mainCpp, nil, "-DGO2CPP_DEBUG_REFS")
+	if !strings.HasSuffix(out, want) {
+		t.Errorf("got: %q, want: %q", out, want)
+	}
+}
+
+// TestMemStats checks that GO2CPP_MEM_STATS counts the accesses for each size and page, and logs the stats when the
+// memory is destroyed.
+func TestMemStats(t *testing.T) {
+	const mainCpp = `#include "log.h"
+#include "mem.h"
+
+#include <iostream>
+#include <string>
+
+using go2cpp_test::LogLevel;
+using go2cpp_test::Mem;
+
+class TestLogger : public go2cpp_test::Logger {
+public:
+  void Log(LogLevel level, const std::string& message) override {
+    std::cout << "logged: " << message.substr(0, message.find('\n')) << std::endl;
+  }
+};
+
+int main() {
+  TestLogger logger;
+  go2cpp_test::SetLogger(&logger);
+  {
+    Mem mem;
+    mem.StoreInt32(0, 1);
+    mem.LoadInt32(0);
+    mem.LoadInt32(4);
+    mem.StoreInt64(Mem::kPageSize, 2);
+    mem.LoadInt8(Mem::kPageSize * 3);
+    mem.Memmove(32, 0, 16);
+    std::cout << mem.FormatAccessStats() << std::endl;
+  }
+  go2cpp_test::SetLogger(nullptr);
+  return 0;
+}
+`
+	out := runRuntimeSources(t, mainCpp, []string{"mem.cpp", "bytes.cpp", "log.cpp", "bits.cpp"}, "-DGO2CPP_MEM_STATS")
+	for _, want := range []string{
+		"memory access stats: 5 loads and stores\n",
+		"\n  load8                1  20.0% ####################\n",
+		"\n  load32               2  40.0% ########################################\n",
+		"\n  store32              1  20.0% ####################\n",
+		"\n  store64              1  20.0% ####################\n",
+		"\n  bulk: 16 bytes loaded, 16 bytes stored\n",
+		// A bulk operation is counted once for each page it accesses, but not for the sizes.
+		"\n  hot pages (64 KiB each, 3 accessed):\n" +
+			"    0x00000000-0x0000ffff             5  71.4% ########################################\n" +
+			"    0x00010000-0x0001ffff             1  14.3% ########\n" +
+			"    0x00030000-0x0003ffff             1  14.3% ########\n",
+		"\nlogged: memory access stats: 5 loads and stores\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q not found in the output:\n%s", want, out)
+		}
+	}
+}
+
+// TestReplayDivergence checks that Replayer reports the first import call that differs from the recording of Recorder.
+func TestReplayDivergence(t *testing.T) {
+	const mainCpp = testProgramIncludes + `
+#include "host.h"
+#include "record.h"
+
+#include <iostream>
+#include <sstream>
+
+using go2cpp_test::Go;
+using go2cpp_test::HostServices;
+
+` + testProgramCpp + `
+// keys are the properties of the global object that Program gets in order.
+std::vector<std::string> keys;
+
+class Program : public TestProgram {
+public:
+  using TestProgram::TestProgram;
+
+  void run(int32_t argc, int32_t argv) override {
+    for (const std::string& key : keys) {
+      Get(kGlobal, key);
+    }
+    Exit(0);
+  }
+};
+
+void Run(HostServices* host, std::function<void(const char* name, go2cpp_test::BytesSpan args)> on_import) {
+  Go go{host};
+  go.SetInstanceFactory(TestProgramFactory<Program>());
+  Go::Hooks hooks;
+  hooks.on_import = on_import;
+  go.SetHooks(hooks);
+  go.Run();
+}
+
+int main() {
+  HostServices base;
+  std::stringstream recording;
+  {
+    go2cpp_test::Recorder recorder{&base, &recording};
+    keys = {"Array", "Date", "Error"};
+    Run(&recorder, [&recorder](const char* name, go2cpp_test::BytesSpan args) {
+      recorder.OnImport(name, args);
+    });
+  }
+
+  for (auto& k : std::vector<std::vector<std::string>>{{"Array", "Date", "Error"}, {"Array", "Date", "JSON"},
+                                                        {"Array", "Object", "JSON"}}) {
+    std::stringstream in{recording.str()};
+    go2cpp_test::Replayer replayer{&base, &in};
+    keys = k;
+    Run(&replayer, [&replayer](const char* name, go2cpp_test::BytesSpan args) {
+      replayer.OnImport(name, args);
+    });
+    std::cout << replayer.Diverged() << " " << replayer.Divergence() << std::endl;
+  }
+  return 0;
+}
+`
+	// The lengths of "Object" and "JSON" differ from "Date" and "Error", so the arguments of valueGet differ. The first
+	// valueGet is the 4th record.
+	const want = "0 \n" +
+		"the Go program diverged from the recording at record 6: import syscall/js.valueGet: the name or the arguments differ from the recording\n" +
+		"1 record 6: import syscall/js.valueGet: the name or the arguments differ from the recording\n" +
+		"the Go program diverged from the recording at record 5: import syscall/js.valueGet: the name or the arguments differ from the recording\n" +
+		"1 record 5: import syscall/js.valueGet: the name or the arguments differ from the recording\n"
+	out := runRuntimeWithOptions(t, Options{Record: true}, mainCpp)
+	if !strings.HasSuffix(out, want) {
+		t.Errorf("got: %q, want: %q", out, want)
+	}
+}
+
+// TestTimerThread checks that many short timers fire in the order of their deadlines on the timer thread, and that
+// the timers expiring within GO2CPP_TIMER_RESOLUTION_MS are run together in one wakeup.
+func TestTimerThread(t *testing.T) {
+	const mainCpp = `#include "taskqueue.h"
+
+#include <chrono>
+#include <condition_variable>
+#include <iostream>
+#include <mutex>
+#include <set>
+#include <thread>
+#include <vector>
+
+using go2cpp_test::TimerThread;
+
+int main() {
+  constexpr int kTimerNum = 100;
+
+  std::mutex mutex;
+  std::condition_variable cond;
+  std::vector<int> order;
+  std::vector<std::chrono::steady_clock::time_point> times;
+  std::set<std::thread::id> threads;
+
+  // The later timers expire earlier.
+  for (int i = kTimerNum - 1; i >= 0; i--) {
+    TimerThread::Get().Add([&, i]() {
+      std::lock_guard<std::mutex> lock{mutex};
+      order.push_back(i);
+      times.push_back(std::chrono::steady_clock::now());
+      threads.insert(std::this_thread::get_id());
+      cond.notify_one();
+    }, i * 2);
+  }
+
+  std::unique_lock<std::mutex> lock{mutex};
+  cond.wait(lock, [&]() { return order.size() == kTimerNum; });
+
+  bool ordered = true;
+  for (int i = 0; i < kTimerNum; i++) {
+    if (order[i] != i) {
+      ordered = false;
+    }
+  }
+  // The timers run in one wakeup are called back to back within 1 ms, and the wakeups are at least 50 ms apart.
+  int wakeups = 1;
+  bool apart = true;
+  for (int i = 1; i < kTimerNum; i++) {
+    auto d = times[i] - times[i - 1];
+    if (d < std::chrono::milliseconds{1}) {
+      continue;
+    }
+    wakeups++;
+    if (d < std::chrono::milliseconds{45}) {
+      apart = false;
+    }
+  }
+  std::cout << ordered << " " << threads.size() << " " << (threads.count(std::this_thread::get_id()) == 0) << " "
+            << apart << " " << (wakeups <= 5) << std::endl;
+  return 0;
+}
+`
+	// The timers of 0-198 ms run in up to 5 wakeups at 0, 50, 100, 150 and 200 ms.
+	if got, want := runRuntimeSources(t, mainCpp, []string{"taskqueue.cpp"}, "-DGO2CPP_TIMER_RESOLUTION_MS=50"), "1 1 1 1 1\n"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,1338 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+type scaffoldFile struct {
+	path string
+	tmpl *template.Template
+}
+
+// scaffolds are the project files for each platform. The paths are slash-separated and relative to the output
+// directory.
+var scaffolds = map[string][]scaffoldFile{
+	"android": {
+		{"settings.gradle", androidSettingsGradleTmpl},
+		{"build.gradle", androidBuildGradleTmpl},
+		{"app/build.gradle", androidAppBuildGradleTmpl},
+		{"app/src/main/AndroidManifest.xml", androidManifestTmpl},
+		{"app/src/main/cpp/CMakeLists.txt", androidCMakeListsTmpl},
+		{"app/src/main/cpp/main.cpp", androidMainCppTmpl},
+		{"app/src/main/cpp/androiddriver.h", androidDriverHTmpl},
+		{"app/src/main/cpp/androiddriver.cpp", androidDriverCppTmpl},
+	},
+	"ios": {
+		{"CMakeLists.txt", iosCMakeListsTmpl},
+		{"Info.plist", iosInfoPlistTmpl},
+		{"src/main.mm", iosMainMmTmpl},
+		{"src/iosdriver.h", iosDriverHTmpl},
+		{"src/iosdriver.mm", iosDriverMmTmpl},
+	},
+}
+
+// scaffoldSourceDir returns the directory of the generated sources in the project, relative to the output directory.
+func scaffoldSourceDir(scaffold string) string {
+	switch scaffold {
+	case "android":
+		return filepath.Join("app", "src", "main", "cpp")
+	case "ios":
+		return "src"
+	}
+	return ""
+}
+
+// writeScaffold writes the project files for the platform scaffold into dir.
+//
+// The existing files are not overwritten, as the project files are supposed to be edited by the user.
+func writeScaffold(dir string, scaffold string, incpath string, namespace string, cppStd string) error {
+	std, _, err := cppStdVersion(cppStd)
+	if err != nil {
+		return err
+	}
+	name := strings.ReplaceAll(namespace, "::", "_")
+
+	for _, s := range scaffolds[scaffold] {
+		path := filepath.Join(dir, filepath.FromSlash(s.path))
+		if _, err := os.Stat(path); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := writeScaffoldFile(path, s.tmpl, struct {
+			IncludePath string
+			Namespace   string
+			Name        string
+			BundleID    string
+			CppStd      int
+		}{
+			IncludePath: incpath,
+			Namespace:   namespace,
+			Name:        name,
+			// Underscores are not allowed in Apple's bundle identifiers.
+			BundleID: "com.example." + strings.ReplaceAll(name, "_", "-"),
+			CppStd:   std,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeScaffoldFile(path string, tmpl *template.Template, data interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return tmpl.Execute(f, data)
+}
+
+var androidSettingsGradleTmpl = template.Must(template.New("settings.gradle").Parse(`pluginManagement {
+    repositories {
+        google()
+        mavenCentral()
+        gradlePluginPortal()
+    }
+}
+
+dependencyResolutionManagement {
+    repositories {
+        google()
+        mavenCentral()
+    }
+}
+
+rootProject.name = '{{.Name}}'
+include ':app'
+`))
+
+var androidBuildGradleTmpl = template.Must(template.New("build.gradle").Parse(`plugins {
+    id 'com.android.application' version '8.2.2' apply false
+}
+`))
+
+var androidAppBuildGradleTmpl = template.Must(template.New("app/build.gradle").Parse(`plugins {
+    id 'com.android.application'
+}
+
+android {
+    namespace 'com.example.{{.Name}}'
+    compileSdk 34
+
+    defaultConfig {
+        applicationId 'com.example.{{.Name}}'
+        // AAudio requires API level 26.
+        minSdk 26
+        targetSdk 34
+        versionCode 1
+        versionName '1.0'
+
+        ndk {
+            abiFilters 'arm64-v8a', 'x86_64'
+        }
+    }
+
+    externalNativeBuild {
+        cmake {
+            path 'src/main/cpp/CMakeLists.txt'
+            version '3.22.1'
+        }
+    }
+}
+`))
+
+var androidManifestTmpl = template.Must(template.New("AndroidManifest.xml").Parse(`<?xml version="1.0" encoding="utf-8"?>
+<manifest xmlns:android="http://schemas.android.com/apk/res/android">
+  <application android:label="{{.Name}}" android:hasCode="false">
+    <activity
+        android:name="android.app.NativeActivity"
+        android:configChanges="orientation|screenSize|screenLayout|keyboardHidden"
+        android:exported="true">
+      <meta-data android:name="android.app.lib_name" android:value="game" />
+      <intent-filter>
+        <action android:name="android.intent.action.MAIN" />
+        <category android:name="android.intent.category.LAUNCHER" />
+      </intent-filter>
+    </activity>
+  </application>
+</manifest>
+`))
+
+var androidCMakeListsTmpl = template.Must(template.New("CMakeLists.txt").Parse(`cmake_minimum_required(VERSION 3.22.1)
+
+project({{.Name}} C CXX)
+
+set(CMAKE_CXX_STANDARD {{.CppStd}})
+set(CMAKE_CXX_STANDARD_REQUIRED ON)
+
+add_library(native_app_glue STATIC
+    ${ANDROID_NDK}/sources/android/native_app_glue/android_native_app_glue.c)
+target_include_directories(native_app_glue PUBLIC
+    ${ANDROID_NDK}/sources/android/native_app_glue)
+
+# The sources include the generated sources.
+file(GLOB_RECURSE SOURCES CONFIGURE_DEPENDS ${CMAKE_CURRENT_SOURCE_DIR}/*.cpp)
+add_library(game SHARED ${SOURCES})
+target_include_directories(game PRIVATE ${CMAKE_CURRENT_SOURCE_DIR})
+
+# Keep ANativeActivity_onCreate, which is called by NativeActivity.
+set(CMAKE_SHARED_LINKER_FLAGS "${CMAKE_SHARED_LINKER_FLAGS} -u ANativeActivity_onCreate")
+
+target_link_libraries(game android native_app_glue EGL GLESv2 aaudio log)
+`))
+
+var androidMainCppTmpl = template.Must(template.New("main.cpp").Parse(`// Generated by gowasm2cpp as a scaffold. This file is not overwritten, and can be edited.
+
+#include "{{.IncludePath}}game.h"
+
+#include "androiddriver.h"
+
+#include <android_native_app_glue.h>
+
+#include <memory>
+
+void android_main(android_app* app) {
+  {{.Namespace}}::Game game(std::make_unique<AndroidDriver>(app));
+  game.Run();
+  ANativeActivity_finish(app->activity);
+}
+`))
+
+var androidDriverHTmpl = template.Must(template.New("androiddriver.h").Parse(`// Generated by gowasm2cpp as a scaffold. This file is not overwritten, and can be edited.
+
+#ifndef ANDROIDDRIVER_H
+#define ANDROIDDRIVER_H
+
+#include "{{.IncludePath}}game.h"
+
+#include <EGL/egl.h>
+
+#include <functional>
+#include <memory>
+#include <vector>
+
+struct AInputEvent;
+struct android_app;
+
+// AndroidDriver is a Game::Driver with NativeActivity, EGL and AAudio.
+class AndroidDriver : public {{.Namespace}}::Game::Driver {
+public:
+  explicit AndroidDriver(android_app* app);
+
+  bool Initialize() override;
+  bool Finalize() override;
+  void Update(std::function<void()> f) override;
+  int GetScreenWidth() override;
+  int GetScreenHeight() override;
+  double GetDevicePixelRatio() override;
+  void* GetOpenGLFunction(const char* name) override;
+  std::vector<{{.Namespace}}::Game::Touch> GetTouches() override;
+  std::vector<{{.Namespace}}::Game::Gamepad> GetGamepads() override;
+  void OpenAudio(int sample_rate, int channel_num, int bit_depth_in_bytes) override;
+  void CloseAudio() override;
+  std::unique_ptr<{{.Namespace}}::Game::AudioPlayer> CreateAudioPlayer(std::function<void()> on_written) override;
+
+private:
+  class AudioPlayer;
+
+  static void HandleCommand(android_app* app, int32_t cmd);
+  static int32_t HandleInput(android_app* app, AInputEvent* event);
+
+  // PollEvents processes the pending events. If block is true, PollEvents waits for one event at least.
+  void PollEvents(bool block);
+
+  bool CreateContext();
+  bool CreateSurface();
+  void DestroySurface();
+
+  android_app* app_;
+  EGLDisplay display_ = EGL_NO_DISPLAY;
+  EGLConfig config_ = nullptr;
+  EGLContext context_ = EGL_NO_CONTEXT;
+  EGLSurface surface_ = EGL_NO_SURFACE;
+  void* gles_ = nullptr;
+
+  // The sizes are in pixels.
+  int width_ = 0;
+  int height_ = 0;
+  double device_pixel_ratio_ = 1;
+
+  std::vector<{{.Namespace}}::Game::Touch> touches_;
+
+  int sample_rate_ = 0;
+  int channel_num_ = 0;
+  int bit_depth_in_bytes_ = 0;
+};
+
+#endif  // ANDROIDDRIVER_H
+`))
+
+var androidDriverCppTmpl = template.Must(template.New("androiddriver.cpp").Parse(`// Generated by gowasm2cpp as a scaffold. This file is not overwritten, and can be edited.
+
+#include "androiddriver.h"
+
+#include "{{.IncludePath}}log.h"
+
+#include <aaudio/AAudio.h>
+#include <android/configuration.h>
+#include <android/input.h>
+#include <android/native_window.h>
+#include <android_native_app_glue.h>
+#include <dlfcn.h>
+
+#include <algorithm>
+#include <atomic>
+#include <cstdlib>
+#include <string>
+
+class AndroidDriver::AudioPlayer : public {{.Namespace}}::Game::AudioPlayer {
+public:
+  AudioPlayer(int sample_rate, int channel_num, int bit_depth_in_bytes, std::function<void()> on_written)
+      : channel_num_{channel_num},
+        bytes_per_frame_{channel_num * bit_depth_in_bytes},
+        on_written_{std::move(on_written)} {
+    if (bit_depth_in_bytes != 2) {
+      {{.Namespace}}::Log({{.Namespace}}::LogLevel::kError,
+          "the bit depth " + std::to_string(bit_depth_in_bytes * 8) + " is not supported");
+      return;
+    }
+
+    AAudioStreamBuilder* builder = nullptr;
+    if (AAudio_createStreamBuilder(&builder) != AAUDIO_OK) {
+      return;
+    }
+    AAudioStreamBuilder_setSampleRate(builder, sample_rate);
+    AAudioStreamBuilder_setChannelCount(builder, channel_num);
+    AAudioStreamBuilder_setFormat(builder, AAUDIO_FORMAT_PCM_I16);
+    AAudioStreamBuilder_setPerformanceMode(builder, AAUDIO_PERFORMANCE_MODE_LOW_LATENCY);
+    if (AAudioStreamBuilder_openStream(builder, &stream_) != AAUDIO_OK) {
+      stream_ = nullptr;
+    }
+    AAudioStreamBuilder_delete(builder);
+    if (!stream_) {
+      {{.Namespace}}::Log({{.Namespace}}::LogLevel::kError, "opening an audio stream failed");
+      return;
+    }
+    AAudioStream_requestStart(stream_);
+  }
+
+  ~AudioPlayer() override {
+    if (stream_) {
+      AAudioStream_close(stream_);
+    }
+  }
+
+  void Close(bool immediately) override {
+    closed_ = true;
+    if (stream_) {
+      AAudioStream_requestStop(stream_);
+    }
+  }
+
+  double GetVolume() override {
+    return volume_;
+  }
+
+  void SetVolume(double volume) override {
+    volume_ = volume;
+  }
+
+  void Pause() override {
+    if (stream_ && !closed_) {
+      AAudioStream_requestPause(stream_);
+    }
+  }
+
+  void Play() override {
+    if (stream_ && !closed_) {
+      AAudioStream_requestStart(stream_);
+    }
+  }
+
+  void Write(const uint8_t* data, int length) override {
+    if (stream_) {
+      const int16_t* src = reinterpret_cast<const int16_t*>(data);
+      buf_.resize(length / 2);
+      double volume = volume_;
+      for (size_t i = 0; i < buf_.size(); i++) {
+        buf_[i] = static_cast<int16_t>(src[i] * volume);
+      }
+
+      // AAudioStream_write blocks while the buffer is full, e.g., while the stream is paused.
+      constexpr int64_t kTimeoutInNanoseconds = 100 * 1000 * 1000;
+      int32_t frames = length / bytes_per_frame_;
+      int32_t written = 0;
+      while (written < frames && !closed_) {
+        aaudio_result_t result = AAudioStream_write(
+            stream_, buf_.data() + written * channel_num_, frames - written, kTimeoutInNanoseconds);
+        if (result < 0) {
+          break;
+        }
+        written += result;
+      }
+    }
+    on_written_();
+  }
+
+  size_t GetUnplayedBufferSize() override {
+    if (!stream_) {
+      return 0;
+    }
+    int64_t frames = AAudioStream_getFramesWritten(stream_) - AAudioStream_getFramesRead(stream_);
+    return static_cast<size_t>(std::max<int64_t>(frames, 0) * bytes_per_frame_);
+  }
+
+private:
+  const int channel_num_;
+  const int bytes_per_frame_;
+  std::function<void()> on_written_;
+  AAudioStream* stream_ = nullptr;
+  std::atomic<double> volume_{1};
+  std::atomic<bool> closed_{false};
+  std::vector<int16_t> buf_;
+};
+
+AndroidDriver::AndroidDriver(android_app* app)
+    : app_{app} {
+}
+
+bool AndroidDriver::Initialize() {
+  app_->userData = this;
+  app_->onAppCmd = HandleCommand;
+  app_->onInputEvent = HandleInput;
+
+  // Wait for the window.
+  while (!app_->window) {
+    PollEvents(true);
+  }
+
+  display_ = eglGetDisplay(EGL_DEFAULT_DISPLAY);
+  if (!eglInitialize(display_, nullptr, nullptr)) {
+    return false;
+  }
+  const EGLint attribs[] = {
+    EGL_RENDERABLE_TYPE, EGL_OPENGL_ES2_BIT,
+    EGL_SURFACE_TYPE, EGL_WINDOW_BIT,
+    EGL_RED_SIZE, 8,
+    EGL_GREEN_SIZE, 8,
+    EGL_BLUE_SIZE, 8,
+    EGL_ALPHA_SIZE, 8,
+    EGL_STENCIL_SIZE, 8,
+    EGL_NONE,
+  };
+  EGLint num_configs = 0;
+  if (!eglChooseConfig(display_, attribs, &config_, 1, &num_configs) || num_configs == 0) {
+    return false;
+  }
+  if (!CreateContext() || !CreateSurface()) {
+    return false;
+  }
+
+  gles_ = dlopen("libGLESv2.so", RTLD_NOW | RTLD_LOCAL);
+
+  int32_t density = AConfiguration_getDensity(app_->config);
+  if (density > 0 && density != ACONFIGURATION_DENSITY_ANY && density != ACONFIGURATION_DENSITY_NONE) {
+    device_pixel_ratio_ = static_cast<double>(density) / ACONFIGURATION_DENSITY_MEDIUM;
+  }
+  return true;
+}
+
+bool AndroidDriver::Finalize() {
+  DestroySurface();
+  if (context_ != EGL_NO_CONTEXT) {
+    eglDestroyContext(display_, context_);
+    context_ = EGL_NO_CONTEXT;
+  }
+  if (display_ != EGL_NO_DISPLAY) {
+    eglTerminate(display_);
+    display_ = EGL_NO_DISPLAY;
+  }
+  if (gles_) {
+    dlclose(gles_);
+    gles_ = nullptr;
+  }
+  return true;
+}
+
+void AndroidDriver::Update(std::function<void()> f) {
+  PollEvents(false);
+
+  // The window is not available while the application is in the background.
+  while (surface_ == EGL_NO_SURFACE) {
+    PollEvents(true);
+  }
+  eglQuerySurface(display_, surface_, EGL_WIDTH, &width_);
+  eglQuerySurface(display_, surface_, EGL_HEIGHT, &height_);
+
+  f();
+
+  if (!eglSwapBuffers(display_, surface_) && eglGetError() == EGL_CONTEXT_LOST) {
+    OnContextLost();
+    DestroySurface();
+    eglDestroyContext(display_, context_);
+    context_ = EGL_NO_CONTEXT;
+    if (!CreateContext() || !CreateSurface()) {
+      {{.Namespace}}::Log({{.Namespace}}::LogLevel::kError, "recreating the GL context failed");
+      std::exit(1);
+    }
+    OnContextRestored();
+  }
+}
+
+int AndroidDriver::GetScreenWidth() {
+  return static_cast<int>(width_ / device_pixel_ratio_);
+}
+
+int AndroidDriver::GetScreenHeight() {
+  return static_cast<int>(height_ / device_pixel_ratio_);
+}
+
+double AndroidDriver::GetDevicePixelRatio() {
+  return device_pixel_ratio_;
+}
+
+void* AndroidDriver::GetOpenGLFunction(const char* name) {
+  if (gles_) {
+    if (void* f = dlsym(gles_, name)) {
+      return f;
+    }
+  }
+  return reinterpret_cast<void*>(eglGetProcAddress(name));
+}
+
+std::vector<{{.Namespace}}::Game::Touch> AndroidDriver::GetTouches() {
+  return touches_;
+}
+
+std::vector<{{.Namespace}}::Game::Gamepad> AndroidDriver::GetGamepads() {
+  return {};
+}
+
+void AndroidDriver::OpenAudio(int sample_rate, int channel_num, int bit_depth_in_bytes) {
+  sample_rate_ = sample_rate;
+  channel_num_ = channel_num;
+  bit_depth_in_bytes_ = bit_depth_in_bytes;
+}
+
+void AndroidDriver::CloseAudio() {
+}
+
+std::unique_ptr<{{.Namespace}}::Game::AudioPlayer> AndroidDriver::CreateAudioPlayer(std::function<void()> on_written) {
+  return std::make_unique<AudioPlayer>(sample_rate_, channel_num_, bit_depth_in_bytes_, std::move(on_written));
+}
+
+void AndroidDriver::HandleCommand(android_app* app, int32_t cmd) {
+  AndroidDriver* driver = static_cast<AndroidDriver*>(app->userData);
+  switch (cmd) {
+  case APP_CMD_INIT_WINDOW:
+    // At the first time, the surface is created in Initialize.
+    if (driver->context_ != EGL_NO_CONTEXT) {
+      driver->CreateSurface();
+    }
+    break;
+  case APP_CMD_TERM_WINDOW:
+    driver->DestroySurface();
+    break;
+  case APP_CMD_PAUSE:
+    driver->OnPause();
+    break;
+  case APP_CMD_RESUME:
+    driver->OnResume();
+    break;
+  case APP_CMD_LOW_MEMORY:
+    driver->OnLowMemory();
+    break;
+  }
+}
+
+int32_t AndroidDriver::HandleInput(android_app* app, AInputEvent* event) {
+  AndroidDriver* driver = static_cast<AndroidDriver*>(app->userData);
+  if (AInputEvent_getType(event) != AINPUT_EVENT_TYPE_MOTION) {
+    return 0;
+  }
+
+  int32_t action = AMotionEvent_getAction(event);
+  int32_t masked = action & AMOTION_EVENT_ACTION_MASK;
+  size_t action_index = static_cast<size_t>(
+      (action & AMOTION_EVENT_ACTION_POINTER_INDEX_MASK) >> AMOTION_EVENT_ACTION_POINTER_INDEX_SHIFT);
+
+  driver->touches_.clear();
+  if (masked == AMOTION_EVENT_ACTION_UP || masked == AMOTION_EVENT_ACTION_CANCEL) {
+    return 1;
+  }
+  size_t count = AMotionEvent_getPointerCount(event);
+  for (size_t i = 0; i < count; i++) {
+    // The pointer leaving the screen is not a touch anymore.
+    if (masked == AMOTION_EVENT_ACTION_POINTER_UP && i == action_index) {
+      continue;
+    }
+    {{.Namespace}}::Game::Touch touch;
+    touch.id = AMotionEvent_getPointerId(event, i);
+    touch.x = static_cast<int>(AMotionEvent_getX(event, i) / driver->device_pixel_ratio_);
+    touch.y = static_cast<int>(AMotionEvent_getY(event, i) / driver->device_pixel_ratio_);
+    // The pressure can exceed 1 depending on the calibration.
+    touch.pressure = std::min(AMotionEvent_getPressure(event, i), 1.0f);
+    touch.radius_x = AMotionEvent_getTouchMajor(event, i) / 2 / driver->device_pixel_ratio_;
+    touch.radius_y = AMotionEvent_getTouchMinor(event, i) / 2 / driver->device_pixel_ratio_;
+    switch (AMotionEvent_getToolType(event, i)) {
+    case AMOTION_EVENT_TOOL_TYPE_STYLUS:
+    case AMOTION_EVENT_TOOL_TYPE_ERASER:
+      touch.pointer_type = {{.Namespace}}::Game::Touch::PointerType::kPen;
+      break;
+    case AMOTION_EVENT_TOOL_TYPE_MOUSE:
+      touch.pointer_type = {{.Namespace}}::Game::Touch::PointerType::kMouse;
+      break;
+    }
+    driver->touches_.push_back(touch);
+  }
+  return 1;
+}
+
+void AndroidDriver::PollEvents(bool block) {
+  int timeout = block ? -1 : 0;
+  for (;;) {
+    android_poll_source* source = nullptr;
+    if (ALooper_pollOnce(timeout, nullptr, nullptr, reinterpret_cast<void**>(&source)) < 0) {
+      return;
+    }
+    if (source) {
+      source->process(app_, source);
+    }
+    if (app_->destroyRequested) {
+      // Game::Run doesn't return while the Go program is running. Terminate the process here.
+      Finalize();
+      std::exit(0);
+    }
+    // Process the rest of the events without blocking.
+    timeout = 0;
+  }
+}
+
+bool AndroidDriver::CreateContext() {
+  const EGLint attribs[] = {
+    EGL_CONTEXT_CLIENT_VERSION, 2,
+    EGL_NONE,
+  };
+  context_ = eglCreateContext(display_, config_, EGL_NO_CONTEXT, attribs);
+  return context_ != EGL_NO_CONTEXT;
+}
+
+bool AndroidDriver::CreateSurface() {
+  EGLint format = 0;
+  eglGetConfigAttrib(display_, config_, EGL_NATIVE_VISUAL_ID, &format);
+  ANativeWindow_setBuffersGeometry(app_->window, 0, 0, format);
+
+  surface_ = eglCreateWindowSurface(display_, config_, app_->window, nullptr);
+  if (surface_ == EGL_NO_SURFACE) {
+    return false;
+  }
+  if (!eglMakeCurrent(display_, surface_, surface_, context_)) {
+    return false;
+  }
+  eglQuerySurface(display_, surface_, EGL_WIDTH, &width_);
+  eglQuerySurface(display_, surface_, EGL_HEIGHT, &height_);
+  return true;
+}
+
+void AndroidDriver::DestroySurface() {
+  if (surface_ == EGL_NO_SURFACE) {
+    return;
+  }
+  eglMakeCurrent(display_, EGL_NO_SURFACE, EGL_NO_SURFACE, EGL_NO_CONTEXT);
+  eglDestroySurface(display_, surface_);
+  surface_ = EGL_NO_SURFACE;
+}
+`))
+
+var iosCMakeListsTmpl = template.Must(template.New("CMakeLists.txt").Parse(`# Generate an Xcode project with:
+#
+#   cmake -G Xcode -DCMAKE_SYSTEM_NAME=iOS -B build
+cmake_minimum_required(VERSION 3.22)
+
+set(CMAKE_OSX_DEPLOYMENT_TARGET 14.0)
+
+project({{.Name}} C CXX OBJCXX)
+
+set(CMAKE_CXX_STANDARD {{.CppStd}})
+set(CMAKE_CXX_STANDARD_REQUIRED ON)
+set(CMAKE_OBJCXX_STANDARD {{.CppStd}})
+set(CMAKE_OBJCXX_STANDARD_REQUIRED ON)
+
+# The sources include the generated sources.
+file(GLOB_RECURSE SOURCES CONFIGURE_DEPENDS
+    ${CMAKE_CURRENT_SOURCE_DIR}/src/*.cpp
+    ${CMAKE_CURRENT_SOURCE_DIR}/src/*.mm)
+add_executable(game MACOSX_BUNDLE ${SOURCES})
+target_include_directories(game PRIVATE ${CMAKE_CURRENT_SOURCE_DIR}/src)
+target_compile_options(game PRIVATE $<$<COMPILE_LANGUAGE:OBJCXX>:-fobjc-arc>)
+target_compile_definitions(game PRIVATE GLES_SILENCE_DEPRECATION)
+
+set_target_properties(game PROPERTIES
+    OUTPUT_NAME {{.Name}}
+    MACOSX_BUNDLE_INFO_PLIST ${CMAKE_CURRENT_SOURCE_DIR}/Info.plist
+    XCODE_ATTRIBUTE_PRODUCT_BUNDLE_IDENTIFIER {{.BundleID}}
+    XCODE_ATTRIBUTE_TARGETED_DEVICE_FAMILY "1,2")
+
+target_link_libraries(game
+    "-framework AVFoundation"
+    "-framework Foundation"
+    "-framework OpenGLES"
+    "-framework QuartzCore"
+    "-framework UIKit")
+`))
+
+var iosInfoPlistTmpl = template.Must(template.New("Info.plist").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+  <key>CFBundleDevelopmentRegion</key>
+  <string>en</string>
+  <key>CFBundleExecutable</key>
+  <string>$(EXECUTABLE_NAME)</string>
+  <key>CFBundleIdentifier</key>
+  <string>$(PRODUCT_BUNDLE_IDENTIFIER)</string>
+  <key>CFBundleInfoDictionaryVersion</key>
+  <string>6.0</string>
+  <key>CFBundleName</key>
+  <string>{{.Name}}</string>
+  <key>CFBundlePackageType</key>
+  <string>APPL</string>
+  <key>CFBundleShortVersionString</key>
+  <string>1.0</string>
+  <key>CFBundleVersion</key>
+  <string>1</string>
+  <key>LSRequiresIPhoneOS</key>
+  <true/>
+  <key>UILaunchScreen</key>
+  <dict/>
+  <key>UIRequiresFullScreen</key>
+  <true/>
+  <key>UIStatusBarHidden</key>
+  <true/>
+</dict>
+</plist>
+`))
+
+var iosMainMmTmpl = template.Must(template.New("main.mm").Parse(`// Generated by gowasm2cpp as a scaffold. This file is not overwritten, and can be edited.
+
+#include "{{.IncludePath}}game.h"
+
+#include "iosdriver.h"
+
+#import <UIKit/UIKit.h>
+
+#include <cstdlib>
+#include <memory>
+#include <thread>
+
+@interface GameViewController : UIViewController
+@end
+
+@implementation GameViewController {
+  BOOL started_;
+}
+
+- (void)loadView {
+  self.view = [[GameView alloc] initWithFrame:UIScreen.mainScreen.bounds];
+}
+
+- (void)viewDidAppear:(BOOL)animated {
+  [super viewDidAppear:animated];
+  if (started_) {
+    return;
+  }
+  started_ = YES;
+
+  // Game::Run blocks until the Go program exits. Run it on another thread so that the main thread keeps handling the
+  // events.
+  GameView* view = (GameView*)self.view;
+  std::thread([view]() {
+    int code = 0;
+    {
+      {{.Namespace}}::Game game(std::make_unique<IOSDriver>(view));
+      code = game.Run();
+    }
+    std::exit(code);
+  }).detach();
+}
+
+- (BOOL)prefersStatusBarHidden {
+  return YES;
+}
+
+- (BOOL)prefersHomeIndicatorAutoHidden {
+  return YES;
+}
+
+@end
+
+@interface AppDelegate : UIResponder <UIApplicationDelegate>
+@property(nonatomic, strong) UIWindow* window;
+@end
+
+@implementation AppDelegate
+
+- (BOOL)application:(UIApplication*)application didFinishLaunchingWithOptions:(NSDictionary*)options {
+  self.window = [[UIWindow alloc] initWithFrame:UIScreen.mainScreen.bounds];
+  self.window.rootViewController = [[GameViewController alloc] init];
+  [self.window makeKeyAndVisible];
+  return YES;
+}
+
+@end
+
+int main(int argc, char* argv[]) {
+  @autoreleasepool {
+    return UIApplicationMain(argc, argv, nil, NSStringFromClass([AppDelegate class]));
+  }
+}
+`))
+
+var iosDriverHTmpl = template.Must(template.New("iosdriver.h").Parse(`// Generated by gowasm2cpp as a scaffold. This file is not overwritten, and can be edited.
+
+#ifndef IOSDRIVER_H
+#define IOSDRIVER_H
+
+#include "{{.IncludePath}}game.h"
+
+#import <UIKit/UIKit.h>
+#include <OpenGLES/ES2/gl.h>
+
+#include <condition_variable>
+#include <functional>
+#include <memory>
+#include <mutex>
+#include <vector>
+
+@class AVAudioEngine;
+@class EAGLContext;
+
+// GameView is a view with an OpenGL ES layer. The methods are available from any thread.
+@interface GameView : UIView
+- (std::vector<{{.Namespace}}::Game::Touch>)currentTouches;
+
+// drawableWidth and drawableHeight are in pixels.
+- (int)drawableWidth;
+- (int)drawableHeight;
+- (double)scale;
+@end
+
+// IOSDriver is a Game::Driver with UIKit, OpenGL ES and AVAudioEngine.
+//
+// OpenGL ES is deprecated on iOS. As the GL functions are resolved by their names, ANGLE with the Metal backend can
+// replace OpenGLES.framework by linking ANGLE's libEGL and libGLESv2 and creating an EGL surface for the view's
+// CAMetalLayer instead of the EAGL context.
+class IOSDriver : public {{.Namespace}}::Game::Driver {
+public:
+  // IOSDriver is created and used on the game thread, not on the main thread.
+  explicit IOSDriver(GameView* view);
+
+  bool Initialize() override;
+  bool Finalize() override;
+  void Update(std::function<void()> f) override;
+  int GetScreenWidth() override;
+  int GetScreenHeight() override;
+  double GetDevicePixelRatio() override;
+  void* GetOpenGLFunction(const char* name) override;
+  std::vector<{{.Namespace}}::Game::Touch> GetTouches() override;
+  std::vector<{{.Namespace}}::Game::Gamepad> GetGamepads() override;
+  std::string GetDefaultLanguage() override;
+  void OpenAudio(int sample_rate, int channel_num, int bit_depth_in_bytes) override;
+  void CloseAudio() override;
+  std::unique_ptr<{{.Namespace}}::Game::AudioPlayer> CreateAudioPlayer(std::function<void()> on_written) override;
+
+private:
+  class AudioPlayer;
+
+  // ResizeFramebuffer reallocates the renderbuffers when the size of the view is changed.
+  bool ResizeFramebuffer();
+
+  void SetActive(bool active);
+
+  GameView* view_;
+  EAGLContext* context_ = nil;
+
+  // There is no default framebuffer with OpenGL ES on iOS. The Go program renders to framebuffer_ when it binds the
+  // framebuffer 0.
+  GLuint framebuffer_ = 0;
+  GLuint color_renderbuffer_ = 0;
+  GLuint depth_stencil_renderbuffer_ = 0;
+  int renderbuffer_width_ = 0;
+  int renderbuffer_height_ = 0;
+
+  std::vector<id> observers_;
+  std::mutex mutex_;
+  std::condition_variable cond_;
+  bool active_ = true;
+
+  AVAudioEngine* audio_engine_ = nil;
+  int sample_rate_ = 0;
+  int channel_num_ = 0;
+  int bit_depth_in_bytes_ = 0;
+};
+
+#endif  // IOSDRIVER_H
+`))
+
+var iosDriverMmTmpl = template.Must(template.New("iosdriver.mm").Parse(`// Generated by gowasm2cpp as a scaffold. This file is not overwritten, and can be edited.
+
+#include "iosdriver.h"
+
+#include "{{.IncludePath}}log.h"
+
+#import <AVFoundation/AVFoundation.h>
+#import <OpenGLES/EAGL.h>
+#import <OpenGLES/EAGLDrawable.h>
+#include <OpenGLES/ES2/glext.h>
+#import <QuartzCore/QuartzCore.h>
+#include <dlfcn.h>
+
+#include <algorithm>
+#include <atomic>
+#include <cstring>
+#include <map>
+#include <string>
+
+@implementation GameView {
+  std::mutex mutex_;
+  std::map<void*, {{.Namespace}}::Game::Touch> touches_;
+  int next_touch_id_;
+  std::atomic<int> drawable_width_;
+  std::atomic<int> drawable_height_;
+  std::atomic<double> scale_;
+}
+
++ (Class)layerClass {
+  return [CAEAGLLayer class];
+}
+
+- (instancetype)initWithFrame:(CGRect)frame {
+  self = [super initWithFrame:frame];
+  if (self) {
+    self.multipleTouchEnabled = YES;
+    self.contentScaleFactor = UIScreen.mainScreen.nativeScale;
+
+    CAEAGLLayer* layer = (CAEAGLLayer*)self.layer;
+    layer.opaque = YES;
+    layer.drawableProperties = @{
+      kEAGLDrawablePropertyRetainedBacking : @NO,
+      kEAGLDrawablePropertyColorFormat : kEAGLColorFormatRGBA8,
+    };
+
+    next_touch_id_ = 0;
+    [self updateDrawableSize];
+  }
+  return self;
+}
+
+- (void)layoutSubviews {
+  [super layoutSubviews];
+  [self updateDrawableSize];
+}
+
+- (void)updateDrawableSize {
+  double scale = self.contentScaleFactor;
+  scale_ = scale;
+  drawable_width_ = static_cast<int>(self.bounds.size.width * scale);
+  drawable_height_ = static_cast<int>(self.bounds.size.height * scale);
+}
+
+- (void)updateTouches:(NSSet<UITouch*>*)touches ended:(BOOL)ended {
+  std::lock_guard<std::mutex> lock{mutex_};
+  for (UITouch* touch in touches) {
+    // A UITouch object is kept during a multi-touch sequence.
+    void* key = (__bridge void*)touch;
+    if (ended) {
+      touches_.erase(key);
+      continue;
+    }
+    auto it = touches_.find(key);
+    if (it == touches_.end()) {
+      {{.Namespace}}::Game::Touch t;
+      t.id = next_touch_id_++;
+      it = touches_.emplace(key, t).first;
+    }
+    // The positions are in points, which correspond to CSS pixels.
+    CGPoint p = [touch locationInView:self];
+    it->second.x = static_cast<int>(p.x);
+    it->second.y = static_cast<int>(p.y);
+    if (touch.maximumPossibleForce > 0) {
+      it->second.pressure = static_cast<float>(touch.force / touch.maximumPossibleForce);
+    }
+    it->second.radius_x = static_cast<float>(touch.majorRadius);
+    it->second.radius_y = static_cast<float>(touch.majorRadius);
+    if (touch.type == UITouchTypePencil) {
+      it->second.pointer_type = {{.Namespace}}::Game::Touch::PointerType::kPen;
+    }
+  }
+}
+
+- (void)touchesBegan:(NSSet<UITouch*>*)touches withEvent:(UIEvent*)event {
+  [self updateTouches:touches ended:NO];
+}
+
+- (void)touchesMoved:(NSSet<UITouch*>*)touches withEvent:(UIEvent*)event {
+  [self updateTouches:touches ended:NO];
+}
+
+- (void)touchesEnded:(NSSet<UITouch*>*)touches withEvent:(UIEvent*)event {
+  [self updateTouches:touches ended:YES];
+}
+
+- (void)touchesCancelled:(NSSet<UITouch*>*)touches withEvent:(UIEvent*)event {
+  [self updateTouches:touches ended:YES];
+}
+
+- (std::vector<{{.Namespace}}::Game::Touch>)currentTouches {
+  std::lock_guard<std::mutex> lock{mutex_};
+  std::vector<{{.Namespace}}::Game::Touch> touches;
+  for (const auto& kv : touches_) {
+    touches.push_back(kv.second);
+  }
+  return touches;
+}
+
+- (int)drawableWidth {
+  return drawable_width_;
+}
+
+- (int)drawableHeight {
+  return drawable_height_;
+}
+
+- (double)scale {
+  return scale_;
+}
+
+@end
+
+namespace {
+
+std::atomic<GLuint> default_framebuffer{0};
+
+// BindFramebuffer replaces glBindFramebuffer for the Go program and maps the framebuffer 0 to the driver's framebuffer.
+void BindFramebuffer(GLenum target, GLuint framebuffer) {
+  glBindFramebuffer(target, framebuffer ? framebuffer : default_framebuffer.load());
+}
+
+}
+
+class IOSDriver::AudioPlayer : public {{.Namespace}}::Game::AudioPlayer {
+public:
+  AudioPlayer(AVAudioEngine* engine, int sample_rate, int channel_num, int bit_depth_in_bytes,
+              std::function<void()> on_written)
+      : engine_{engine},
+        channel_num_{channel_num},
+        // Write blocks while 0.25 seconds of samples are queued.
+        max_pending_bytes_{static_cast<size_t>(sample_rate * channel_num * 2 / 4)},
+        state_{std::make_shared<State>()} {
+    state_->on_written = std::move(on_written);
+    if (bit_depth_in_bytes != 2) {
+      {{.Namespace}}::Log({{.Namespace}}::LogLevel::kError,
+          "the bit depth " + std::to_string(bit_depth_in_bytes * 8) + " is not supported");
+      return;
+    }
+    if (!engine_) {
+      return;
+    }
+
+    format_ = [[AVAudioFormat alloc] initWithCommonFormat:AVAudioPCMFormatFloat32
+                                               sampleRate:sample_rate
+                                                 channels:channel_num
+                                              interleaved:NO];
+    node_ = [[AVAudioPlayerNode alloc] init];
+    [engine_ attachNode:node_];
+    [engine_ connect:node_ to:engine_.mainMixerNode format:format_];
+    if (!engine_.running) {
+      NSError* error = nil;
+      if (![engine_ startAndReturnError:&error]) {
+        {{.Namespace}}::Log({{.Namespace}}::LogLevel::kError,
+            std::string("starting the audio engine failed: ") + error.localizedDescription.UTF8String);
+      }
+    }
+    [node_ play];
+  }
+
+  ~AudioPlayer() override {
+    if (node_) {
+      [node_ stop];
+      [engine_ detachNode:node_];
+    }
+  }
+
+  void Close(bool immediately) override {
+    {
+      std::lock_guard<std::mutex> lock{state_->mutex};
+      state_->closed = true;
+    }
+    state_->cond.notify_all();
+    if (node_) {
+      [node_ stop];
+    }
+  }
+
+  double GetVolume() override {
+    return node_ ? node_.volume : 1;
+  }
+
+  void SetVolume(double volume) override {
+    if (node_) {
+      node_.volume = static_cast<float>(volume);
+    }
+  }
+
+  void Pause() override {
+    if (node_) {
+      [node_ pause];
+    }
+  }
+
+  void Play() override {
+    if (node_) {
+      [node_ play];
+    }
+  }
+
+  void Write(const uint8_t* data, int length) override {
+    if (!node_) {
+      state_->on_written();
+      return;
+    }
+
+    {
+      // The completion handlers are not called while the player is paused, so Write blocks until the player is
+      // resumed or closed.
+      std::unique_lock<std::mutex> lock{state_->mutex};
+      state_->cond.wait(lock, [this] { return state_->pending_bytes < max_pending_bytes_ || state_->closed; });
+      if (state_->closed) {
+        return;
+      }
+      state_->pending_bytes += length;
+    }
+
+    AVAudioFrameCount frames = static_cast<AVAudioFrameCount>(length / (2 * channel_num_));
+    AVAudioPCMBuffer* buffer = [[AVAudioPCMBuffer alloc] initWithPCMFormat:format_ frameCapacity:frames];
+    buffer.frameLength = frames;
+    const int16_t* src = reinterpret_cast<const int16_t*>(data);
+    for (int ch = 0; ch < channel_num_; ch++) {
+      float* dst = buffer.floatChannelData[ch];
+      for (AVAudioFrameCount i = 0; i < frames; i++) {
+        dst[i] = src[i * channel_num_ + ch] / 32768.0f;
+      }
+    }
+
+    // The handler is called on an audio thread, possibly after this player is destroyed.
+    std::shared_ptr<State> state = state_;
+    size_t bytes = static_cast<size_t>(length);
+    [node_ scheduleBuffer:buffer completionHandler:^{
+      bool closed = false;
+      {
+        std::lock_guard<std::mutex> lock{state->mutex};
+        state->pending_bytes -= std::min(state->pending_bytes, bytes);
+        closed = state->closed;
+      }
+      state->cond.notify_all();
+      if (!closed) {
+        state->on_written();
+      }
+    }];
+  }
+
+  size_t GetUnplayedBufferSize() override {
+    std::lock_guard<std::mutex> lock{state_->mutex};
+    return state_->pending_bytes;
+  }
+
+private:
+  struct State {
+    std::mutex mutex;
+    std::condition_variable cond;
+    size_t pending_bytes = 0;
+    bool closed = false;
+    std::function<void()> on_written;
+  };
+
+  AVAudioEngine* engine_;
+  AVAudioFormat* format_ = nil;
+  AVAudioPlayerNode* node_ = nil;
+  const int channel_num_;
+  const size_t max_pending_bytes_;
+  std::shared_ptr<State> state_;
+};
+
+IOSDriver::IOSDriver(GameView* view)
+    : view_{view} {
+}
+
+bool IOSDriver::Initialize() {
+  context_ = [[EAGLContext alloc] initWithAPI:kEAGLRenderingAPIOpenGLES2];
+  if (!context_ || ![EAGLContext setCurrentContext:context_]) {
+    return false;
+  }
+  glGenFramebuffers(1, &framebuffer_);
+  glGenRenderbuffers(1, &color_renderbuffer_);
+  glGenRenderbuffers(1, &depth_stencil_renderbuffer_);
+  default_framebuffer = framebuffer_;
+  if (!ResizeFramebuffer()) {
+    return false;
+  }
+
+  IOSDriver* driver = this;
+  NSNotificationCenter* center = [NSNotificationCenter defaultCenter];
+  observers_.push_back([center addObserverForName:UIApplicationWillResignActiveNotification
+                                           object:nil
+                                            queue:nil
+                                       usingBlock:^(NSNotification* note) {
+                                         driver->SetActive(false);
+                                         driver->OnPause();
+                                       }]);
+  observers_.push_back([center addObserverForName:UIApplicationDidBecomeActiveNotification
+                                           object:nil
+                                            queue:nil
+                                       usingBlock:^(NSNotification* note) {
+                                         driver->SetActive(true);
+                                         driver->OnResume();
+                                       }]);
+  observers_.push_back([center addObserverForName:UIApplicationDidReceiveMemoryWarningNotification
+                                           object:nil
+                                            queue:nil
+                                       usingBlock:^(NSNotification* note) {
+                                         driver->OnLowMemory();
+                                       }]);
+  return true;
+}
+
+bool IOSDriver::Finalize() {
+  NSNotificationCenter* center = [NSNotificationCenter defaultCenter];
+  for (id observer : observers_) {
+    [center removeObserver:observer];
+  }
+  observers_.clear();
+
+  if (context_) {
+    glDeleteFramebuffers(1, &framebuffer_);
+    glDeleteRenderbuffers(1, &color_renderbuffer_);
+    glDeleteRenderbuffers(1, &depth_stencil_renderbuffer_);
+    default_framebuffer = 0;
+    [EAGLContext setCurrentContext:nil];
+    context_ = nil;
+  }
+  return true;
+}
+
+void IOSDriver::Update(std::function<void()> f) {
+  {
+    // iOS terminates the application that uses the GPU in the background.
+    std::unique_lock<std::mutex> lock{mutex_};
+    cond_.wait(lock, [this] { return active_; });
+  }
+  if (!ResizeFramebuffer()) {
+    {{.Namespace}}::Log({{.Namespace}}::LogLevel::kError, "resizing the framebuffer failed");
+  }
+
+  glBindFramebuffer(GL_FRAMEBUFFER, framebuffer_);
+  f();
+
+  glBindRenderbuffer(GL_RENDERBUFFER, color_renderbuffer_);
+  [context_ presentRenderbuffer:GL_RENDERBUFFER];
+}
+
+int IOSDriver::GetScreenWidth() {
+  return static_cast<int>([view_ drawableWidth] / [view_ scale]);
+}
+
+int IOSDriver::GetScreenHeight() {
+  return static_cast<int>([view_ drawableHeight] / [view_ scale]);
+}
+
+double IOSDriver::GetDevicePixelRatio() {
+  return [view_ scale];
+}
+
+void* IOSDriver::GetOpenGLFunction(const char* name) {
+  if (std::strcmp(name, "glBindFramebuffer") == 0) {
+    return reinterpret_cast<void*>(&BindFramebuffer);
+  }
+  return dlsym(RTLD_DEFAULT, name);
+}
+
+std::vector<{{.Namespace}}::Game::Touch> IOSDriver::GetTouches() {
+  return [view_ currentTouches];
+}
+
+std::vector<{{.Namespace}}::Game::Gamepad> IOSDriver::GetGamepads() {
+  return {};
+}
+
+std::string IOSDriver::GetDefaultLanguage() {
+  NSString* lang = NSLocale.preferredLanguages.firstObject;
+  if (!lang) {
+    return {{.Namespace}}::Game::Driver::GetDefaultLanguage();
+  }
+  return lang.UTF8String;
+}
+
+void IOSDriver::OpenAudio(int sample_rate, int channel_num, int bit_depth_in_bytes) {
+  sample_rate_ = sample_rate;
+  channel_num_ = channel_num;
+  bit_depth_in_bytes_ = bit_depth_in_bytes;
+
+  // The ambient category respects the silent switch and mixes with the other applications' audio.
+  AVAudioSession* session = [AVAudioSession sharedInstance];
+  [session setCategory:AVAudioSessionCategoryAmbient error:nil];
+  [session setActive:YES error:nil];
+  audio_engine_ = [[AVAudioEngine alloc] init];
+}
+
+void IOSDriver::CloseAudio() {
+  [audio_engine_ stop];
+  audio_engine_ = nil;
+}
+
+std::unique_ptr<{{.Namespace}}::Game::AudioPlayer> IOSDriver::CreateAudioPlayer(std::function<void()> on_written) {
+  return std::make_unique<AudioPlayer>(
+      audio_engine_, sample_rate_, channel_num_, bit_depth_in_bytes_, std::move(on_written));
+}
+
+bool IOSDriver::ResizeFramebuffer() {
+  int width = [view_ drawableWidth];
+  int height = [view_ drawableHeight];
+  if (width == renderbuffer_width_ && height == renderbuffer_height_) {
+    return true;
+  }
+
+  glBindFramebuffer(GL_FRAMEBUFFER, framebuffer_);
+
+  glBindRenderbuffer(GL_RENDERBUFFER, color_renderbuffer_);
+  if (![context_ renderbufferStorage:GL_RENDERBUFFER fromDrawable:(CAEAGLLayer*)view_.layer]) {
+    return false;
+  }
+  glFramebufferRenderbuffer(GL_FRAMEBUFFER, GL_COLOR_ATTACHMENT0, GL_RENDERBUFFER, color_renderbuffer_);
+
+  glBindRenderbuffer(GL_RENDERBUFFER, depth_stencil_renderbuffer_);
+  glRenderbufferStorage(GL_RENDERBUFFER, GL_DEPTH24_STENCIL8_OES, width, height);
+  glFramebufferRenderbuffer(GL_FRAMEBUFFER, GL_DEPTH_ATTACHMENT, GL_RENDERBUFFER, depth_stencil_renderbuffer_);
+  glFramebufferRenderbuffer(GL_FRAMEBUFFER, GL_STENCIL_ATTACHMENT, GL_RENDERBUFFER, depth_stencil_renderbuffer_);
+
+  renderbuffer_width_ = width;
+  renderbuffer_height_ = height;
+  return glCheckFramebufferStatus(GL_FRAMEBUFFER) == GL_FRAMEBUFFER_COMPLETE;
+}
+
+void IOSDriver::SetActive(bool active) {
+  {
+    std::lock_guard<std::mutex> lock{mutex_};
+    active_ = active;
+  }
+  cond_.notify_all();
+}
+`))
@@ -0,0 +1,1044 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Schema describes the structs passed between Go and C++. The Go code and the C++ code generated from a schema encode
+// and decode the structs in the same binary format, so a Go program can pass a struct to the host as bytes, e.g., via
+// Game::RegisterFunc, without packing the bytes by hand.
+//
+// In the format, a struct is the fields in the order of the declaration without padding. A boolean is 1 byte, a number
+// is a little-endian value of its size, and an inline struct is its fields. A string or a slice is a pair of 32-bit
+// offset and length, and its elements follow the fixed-size part of the root struct. The C++ views read the fields in
+// place, e.g., from the linear memory via Mem::LoadSliceDirectly, without copying the bytes.
+type Schema struct {
+	// Package is the package name of the Go code, and the namespace of the C++ code under the generator's namespace.
+	// If Package is empty, "schema" is used.
+	Package string `json:"package"`
+
+	Structs []SchemaStruct `json:"structs"`
+}
+
+// SchemaStruct is a struct in a schema.
+type SchemaStruct struct {
+	// Name is the name of the struct in Go and C++, which must be exported in Go.
+	Name string `json:"name"`
+
+	Fields []SchemaField `json:"fields"`
+}
+
+// SchemaField is a field of a struct in a schema.
+type SchemaField struct {
+	// Name is the name of the field in Go and of the accessor of the C++ view, which must be exported in Go. The
+	// member of the C++ struct is in snake case like "max_count" for "MaxCount".
+	Name string `json:"name"`
+
+	// Type is the Go type of the field: bool, int8, int16, int32, int64, uint8, uint16, uint32, uint64, float32,
+	// float64, string, the name of a struct, or a slice of them like "[]int32". byte is an alias for uint8.
+	Type string `json:"type"`
+}
+
+// ParseSchema parses a schema in JSON like
+//
+//	{"package": "msg", "structs": [{"name": "Point", "fields": [{"name": "X", "type": "float64"}]}]}
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("gowasm2cpp: invalid schema: %v", err)
+	}
+	return &s, nil
+}
+
+var schemaScalarSizes = map[string]int{
+	"bool":    1,
+	"int8":    1,
+	"uint8":   1,
+	"int16":   2,
+	"uint16":  2,
+	"int32":   4,
+	"uint32":  4,
+	"float32": 4,
+	"int64":   8,
+	"uint64":  8,
+	"float64": 8,
+}
+
+var schemaCppScalars = map[string]string{
+	"bool":    "bool",
+	"int8":    "int8_t",
+	"uint8":   "uint8_t",
+	"int16":   "int16_t",
+	"uint16":  "uint16_t",
+	"int32":   "int32_t",
+	"uint32":  "uint32_t",
+	"float32": "float",
+	"int64":   "int64_t",
+	"uint64":  "uint64_t",
+	"float64": "double",
+}
+
+// schemaRefSize is the size of the offset and the length of a string or a slice.
+const schemaRefSize = 8
+
+// schemaType is a parsed type of a field or an element.
+type schemaType struct {
+	// name is a scalar type, "string" or the name of a struct. For a slice, name is the type of the elements.
+	name  string
+	slice bool
+}
+
+func parseSchemaType(str string) schemaType {
+	var t schemaType
+	if strings.HasPrefix(str, "[]") {
+		t.slice = true
+		str = str[2:]
+	}
+	if str == "byte" {
+		str = "uint8"
+	}
+	t.name = str
+	return t
+}
+
+func (t schemaType) elem() schemaType {
+	return schemaType{name: t.name}
+}
+
+func (t schemaType) isScalar() bool {
+	_, ok := schemaScalarSizes[t.name]
+	return !t.slice && ok
+}
+
+func (t schemaType) isString() bool {
+	return !t.slice && t.name == "string"
+}
+
+func (t schemaType) isStruct() bool {
+	return !t.slice && !t.isScalar() && !t.isString()
+}
+
+// isBytes reports whether t is []uint8, whose elements are accessed at once.
+func (t schemaType) isBytes() bool {
+	return t.slice && t.name == "uint8"
+}
+
+func (t schemaType) goType() string {
+	if t.slice {
+		return "[]" + t.name
+	}
+	return t.name
+}
+
+func (t schemaType) cppType() string {
+	if t.slice {
+		return "std::vector<" + t.elem().cppType() + ">"
+	}
+	if t.isString() {
+		return "std::string"
+	}
+	if c, ok := schemaCppScalars[t.name]; ok {
+		return c
+	}
+	return t.name
+}
+
+// cppViewType is the type of a value that a view returns.
+func (t schemaType) cppViewType() string {
+	if t.isStruct() {
+		return t.name + "View"
+	}
+	return t.cppType()
+}
+
+// schemaReservedNames are the names that the fields cannot use: the methods of the Go structs and the C++ views.
+var schemaReservedNames = map[string]struct{}{
+	"MarshalBinary":   {},
+	"UnmarshalBinary": {},
+	"IsValid":         {},
+}
+
+func isExported(name string) bool {
+	return name != "" && 'A' <= name[0] && name[0] <= 'Z'
+}
+
+// snakeCase returns name in snake case like "max_count" for "MaxCount" and "id" for "ID".
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			// An upper case letter starts a word when it follows a lower case letter or a digit, or when it is followed
+			// by a lower case letter in an acronym like "HTTPServer".
+			if i > 0 {
+				prev := name[i-1]
+				lowerPrev := 'a' <= prev && prev <= 'z' || '0' <= prev && prev <= '9'
+				upperPrev := 'A' <= prev && prev <= 'Z'
+				lowerNext := i+1 < len(name) && 'a' <= name[i+1] && name[i+1] <= 'z'
+				if lowerPrev || upperPrev && lowerNext {
+					b.WriteByte('_')
+				}
+			}
+			b.WriteByte(c - 'A' + 'a')
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// cppMember returns the name of the member of the C++ struct for the field.
+func (f *SchemaField) cppMember() string {
+	n := snakeCase(f.Name)
+	if isCppKeyword(n) {
+		n += "_"
+	}
+	return n
+}
+
+// cppAccessors returns the names of the methods of the C++ view for the field.
+func (f *SchemaField) cppAccessors() []string {
+	t := parseSchemaType(f.Type)
+	switch {
+	case t.isString() || t.isBytes():
+		return []string{f.Name, f.Name + "Size", f.Name + "Data"}
+	case t.slice:
+		return []string{f.Name, f.Name + "Size"}
+	}
+	return []string{f.Name}
+}
+
+func (s *Schema) packageName() string {
+	if s.Package == "" {
+		return "schema"
+	}
+	return s.Package
+}
+
+func (s *Schema) structByName(name string) *SchemaStruct {
+	for i := range s.Structs {
+		if s.Structs[i].Name == name {
+			return &s.Structs[i]
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validate() error {
+	if p := s.packageName(); !identifierRe.MatchString(p) || isCppKeyword(p) || token.IsKeyword(p) {
+		return fmt.Errorf("invalid package name: %q", p)
+	}
+	if len(s.Structs) == 0 {
+		return fmt.Errorf("no structs")
+	}
+
+	names := map[string]struct{}{}
+	for _, st := range s.Structs {
+		if !identifierRe.MatchString(st.Name) || !isExported(st.Name) || isCppKeyword(st.Name) {
+			return fmt.Errorf("struct name must be an exported identifier: %q", st.Name)
+		}
+		if _, ok := names[st.Name]; ok {
+			return fmt.Errorf("duplicated struct name: %q", st.Name)
+		}
+		names[st.Name] = struct{}{}
+	}
+	for _, st := range s.Structs {
+		if _, ok := names[st.Name+"View"]; ok {
+			return fmt.Errorf("%s: the name of the view conflicts with the struct %sView", st.Name, st.Name)
+		}
+	}
+
+	for _, st := range s.Structs {
+		fields := map[string]struct{}{}
+		members := map[string]string{}
+		accessors := map[string]string{}
+		for _, f := range st.Fields {
+			if !identifierRe.MatchString(f.Name) || !isExported(f.Name) {
+				return fmt.Errorf("%s: field name must be an exported identifier: %q", st.Name, f.Name)
+			}
+			if _, ok := schemaReservedNames[f.Name]; ok {
+				return fmt.Errorf("%s: reserved field name: %q", st.Name, f.Name)
+			}
+			if _, ok := fields[f.Name]; ok {
+				return fmt.Errorf("%s: duplicated field name: %q", st.Name, f.Name)
+			}
+			fields[f.Name] = struct{}{}
+			if other, ok := members[f.cppMember()]; ok {
+				return fmt.Errorf("%s: the fields %s and %s have the same C++ member name %q", st.Name, other, f.Name, f.cppMember())
+			}
+			members[f.cppMember()] = f.Name
+			for _, a := range f.cppAccessors() {
+				if _, ok := schemaReservedNames[a]; ok {
+					return fmt.Errorf("%s: the accessor %s of the field %s is reserved", st.Name, a, f.Name)
+				}
+				if other, ok := accessors[a]; ok {
+					return fmt.Errorf("%s: the fields %s and %s have the same C++ accessor %s", st.Name, other, f.Name, a)
+				}
+				accessors[a] = f.Name
+			}
+
+			t := parseSchemaType(f.Type)
+			if strings.HasPrefix(t.name, "[]") {
+				return fmt.Errorf("%s.%s: slices of slices are not supported: %q", st.Name, f.Name, f.Type)
+			}
+			if _, ok := schemaScalarSizes[t.name]; !ok && t.name != "string" {
+				if _, ok := names[t.name]; !ok {
+					return fmt.Errorf("%s.%s: unknown type: %q", st.Name, f.Name, f.Type)
+				}
+			}
+		}
+	}
+
+	// A struct cannot include itself inline, while a slice of the struct is fine.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := map[string]int{}
+	var visit func(st *SchemaStruct) error
+	visit = func(st *SchemaStruct) error {
+		switch states[st.Name] {
+		case visiting:
+			return fmt.Errorf("%s: the struct includes itself", st.Name)
+		case visited:
+			return nil
+		}
+		states[st.Name] = visiting
+		for _, f := range st.Fields {
+			if t := parseSchemaType(f.Type); t.isStruct() {
+				if err := visit(s.structByName(t.name)); err != nil {
+					return err
+				}
+			}
+		}
+		states[st.Name] = visited
+		return nil
+	}
+	for i := range s.Structs {
+		if err := visit(&s.Structs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// size returns the size of the fixed-size part of a value of t.
+func (s *Schema) size(t schemaType) int {
+	if t.slice || t.isString() {
+		return schemaRefSize
+	}
+	if n, ok := schemaScalarSizes[t.name]; ok {
+		return n
+	}
+	var n int
+	for _, f := range s.structByName(t.name).Fields {
+		n += s.size(parseSchemaType(f.Type))
+	}
+	return n
+}
+
+// sortedStructs returns the structs in the order that the C++ structs can be defined: a struct follows the structs of
+// its fields.
+func (s *Schema) sortedStructs() []*SchemaStruct {
+	var sorted []*SchemaStruct
+	visited := map[string]bool{}
+	var visit func(st *SchemaStruct)
+	visit = func(st *SchemaStruct) {
+		if visited[st.Name] {
+			return
+		}
+		visited[st.Name] = true
+		for _, f := range st.Fields {
+			if t := parseSchemaType(f.Type).elem(); t.isStruct() {
+				visit(s.structByName(t.name))
+			}
+		}
+		sorted = append(sorted, st)
+	}
+	for i := range s.Structs {
+		visit(&s.Structs[i])
+	}
+	return sorted
+}
+
+func offsetExpr(base string, offset int) string {
+	if offset == 0 {
+		return base
+	}
+	return fmt.Sprintf("%s + %d", base, offset)
+}
+
+// goPut returns the Go statements to write the value x of the non-slice type t at the offset o of b.
+func (s *Schema) goPut(x string, t schemaType, o string) string {
+	switch {
+	case t.isString():
+		return fmt.Sprintf("if b, err = schemaAppendString(b, %s, %s); err != nil {\nreturn nil, err\n}\n", o, x)
+	case t.isStruct():
+		return fmt.Sprintf("if b, err = %s.appendTo(b, %s); err != nil {\nreturn nil, err\n}\n", x, o)
+	}
+	switch t.name {
+	case "bool":
+		return fmt.Sprintf("b[%s] = schemaBool(%s)\n", o, x)
+	case "int8", "uint8":
+		return fmt.Sprintf("b[%s] = byte(%s)\n", o, x)
+	case "float32":
+		return fmt.Sprintf("binary.LittleEndian.PutUint32(b[%s:], math.Float32bits(%s))\n", o, x)
+	case "float64":
+		return fmt.Sprintf("binary.LittleEndian.PutUint64(b[%s:], math.Float64bits(%s))\n", o, x)
+	}
+	bits := schemaScalarSizes[t.name] * 8
+	return fmt.Sprintf("binary.LittleEndian.PutUint%d(b[%s:], uint%d(%s))\n", bits, o, bits, x)
+}
+
+// goGet returns the Go statements to read the value x of the non-slice type t at the offset o of b.
+func (s *Schema) goGet(x string, t schemaType, o string) string {
+	switch {
+	case t.isString():
+		return fmt.Sprintf("if %s, err = schemaString(b, %s); err != nil {\nreturn err\n}\n", x, o)
+	case t.isStruct():
+		return fmt.Sprintf("if err := %s.readFrom(b, %s); err != nil {\nreturn err\n}\n", x, o)
+	}
+	switch t.name {
+	case "bool":
+		return fmt.Sprintf("%s = b[%s] != 0\n", x, o)
+	case "int8":
+		return fmt.Sprintf("%s = int8(b[%s])\n", x, o)
+	case "uint8":
+		return fmt.Sprintf("%s = b[%s]\n", x, o)
+	case "float32":
+		return fmt.Sprintf("%s = math.Float32frombits(binary.LittleEndian.Uint32(b[%s:]))\n", x, o)
+	case "float64":
+		return fmt.Sprintf("%s = math.Float64frombits(binary.LittleEndian.Uint64(b[%s:]))\n", x, o)
+	}
+	bits := schemaScalarSizes[t.name] * 8
+	return fmt.Sprintf("%s = %s(binary.LittleEndian.Uint%d(b[%s:]))\n", x, t.name, bits, o)
+}
+
+// goAppendTo returns the body of appendTo of the Go struct.
+func (s *Schema) goAppendTo(st *SchemaStruct) string {
+	var b strings.Builder
+	var offset int
+	for _, f := range st.Fields {
+		t := parseSchemaType(f.Type)
+		x := "v." + f.Name
+		o := offsetExpr("off", offset)
+		switch {
+		case t.isBytes():
+			fmt.Fprintf(&b, "if b, err = schemaAppendBytes(b, %s, %s); err != nil {\nreturn nil, err\n}\n", o, x)
+		case t.slice:
+			size := s.size(t.elem())
+			fmt.Fprintf(&b, "{\nvar start int\nif b, start, err = schemaAlloc(b, %s, len(%s), %d); err != nil {\nreturn nil, err\n}\n", o, x, size)
+			fmt.Fprintf(&b, "for i := range %s {\n", x)
+			b.WriteString(s.goPut(x+"[i]", t.elem(), fmt.Sprintf("start+i*%d", size)))
+			b.WriteString("}\n}\n")
+		default:
+			b.WriteString(s.goPut(x, t, o))
+		}
+		offset += s.size(t)
+	}
+	return b.String()
+}
+
+// goReadFrom returns the body of readFrom of the Go struct.
+func (s *Schema) goReadFrom(st *SchemaStruct) string {
+	var b strings.Builder
+	var offset int
+	for _, f := range st.Fields {
+		t := parseSchemaType(f.Type)
+		x := "v." + f.Name
+		o := offsetExpr("off", offset)
+		switch {
+		case t.isBytes():
+			fmt.Fprintf(&b, "if %s, err = schemaBytes(b, %s); err != nil {\nreturn err\n}\n", x, o)
+		case t.slice:
+			size := s.size(t.elem())
+			fmt.Fprintf(&b, "{\nvar start, n int\nif start, n, err = schemaRef(b, %s, %d); err != nil {\nreturn err\n}\n", o, size)
+			fmt.Fprintf(&b, "%s = make(%s, n)\nfor i := range %s {\n", x, t.goType(), x)
+			b.WriteString(s.goGet(x+"[i]", t.elem(), fmt.Sprintf("start+i*%d", size)))
+			b.WriteString("}\n}\n")
+		default:
+			b.WriteString(s.goGet(x, t, o))
+		}
+		offset += s.size(t)
+	}
+	return b.String()
+}
+
+// cppPut returns the C++ statements to write the value x of the non-slice type t at the offset o of b.
+func (s *Schema) cppPut(x string, t schemaType, o string, indent string) string {
+	switch {
+	case t.isString():
+		return fmt.Sprintf("%sif (!PutBytes(b, %s, reinterpret_cast<const uint8_t*>(%s.data()), %s.size())) {\n%s  return false;\n%s}\n", indent, o, x, x, indent, indent)
+	case t.isStruct():
+		return fmt.Sprintf("%sif (!Put(%s, b, %s)) {\n%s  return false;\n%s}\n", indent, x, o, indent, indent)
+	}
+	return fmt.Sprintf("%sStore<%s>(b, %s, %s);\n", indent, t.cppType(), o, x)
+}
+
+// cppGet returns the C++ statements to read the value to the pointer p of the non-slice type t at the offset o.
+func (s *Schema) cppGet(p string, t schemaType, o string, indent string) string {
+	switch {
+	case t.isString():
+		return fmt.Sprintf("%sif (!GetString(data, size, %s, %s)) {\n%s  return false;\n%s}\n", indent, o, p, indent, indent)
+	case t.isStruct():
+		return fmt.Sprintf("%sif (!Get(data, size, %s, %s)) {\n%s  return false;\n%s}\n", indent, o, p, indent, indent)
+	}
+	return fmt.Sprintf("%s*%s = Load<%s>(data, %s);\n", indent, p, t.cppType(), o)
+}
+
+// cppPutBody returns the body of Put for the C++ struct.
+func (s *Schema) cppPutBody(st *SchemaStruct) string {
+	var b strings.Builder
+	var offset int
+	for _, f := range st.Fields {
+		t := parseSchemaType(f.Type)
+		x := "v." + f.cppMember()
+		o := offsetExpr("offset", offset)
+		switch {
+		case t.isBytes():
+			fmt.Fprintf(&b, "  if (!PutBytes(b, %s, %s.data(), %s.size())) {\n    return false;\n  }\n", o, x, x)
+		case t.slice:
+			size := s.size(t.elem())
+			fmt.Fprintf(&b, "  {\n    size_t start = 0;\n    if (!Alloc(b, %s, %s.size(), %d, &start)) {\n      return false;\n    }\n", o, x, size)
+			fmt.Fprintf(&b, "    for (size_t i = 0; i < %s.size(); i++) {\n", x)
+			b.WriteString(s.cppPut(x+"[i]", t.elem(), fmt.Sprintf("start + i * %d", size), "      "))
+			b.WriteString("    }\n  }\n")
+		default:
+			b.WriteString(s.cppPut(x, t, o, "  "))
+		}
+		offset += s.size(t)
+	}
+	return b.String()
+}
+
+// cppGetBody returns the body of Get for the C++ struct.
+func (s *Schema) cppGetBody(st *SchemaStruct) string {
+	var b strings.Builder
+	var offset int
+	for _, f := range st.Fields {
+		t := parseSchemaType(f.Type)
+		x := "v->" + f.cppMember()
+		o := offsetExpr("offset", offset)
+		switch {
+		case t.slice:
+			size := s.size(t.elem())
+			fmt.Fprintf(&b, "  {\n    size_t start = 0;\n    size_t n = 0;\n    if (!Ref(data, size, %s, %d, &start, &n)) {\n      return false;\n    }\n", o, size)
+			if t.isBytes() {
+				fmt.Fprintf(&b, "    %s.assign(data + start, data + start + n);\n", x)
+			} else {
+				fmt.Fprintf(&b, "    %s.resize(n);\n    for (size_t i = 0; i < n; i++) {\n", x)
+				if t.name == "bool" {
+					// std::vector<bool> doesn't give a pointer to an element.
+					fmt.Fprintf(&b, "      %s[i] = Load<bool>(data, start + i);\n", x)
+				} else {
+					b.WriteString(s.cppGet("&"+x+"[i]", t.elem(), fmt.Sprintf("start + i * %d", size), "      "))
+				}
+				b.WriteString("    }\n")
+			}
+			b.WriteString("  }\n")
+		default:
+			b.WriteString(s.cppGet("&"+x, t, o, "  "))
+		}
+		offset += s.size(t)
+	}
+	return b.String()
+}
+
+// cppAccessorDecls returns the declarations of the accessors of the C++ view.
+func (s *Schema) cppAccessorDecls(st *SchemaStruct) string {
+	var b strings.Builder
+	for _, f := range st.Fields {
+		t := parseSchemaType(f.Type)
+		switch {
+		case t.isString():
+			fmt.Fprintf(&b, "  std::string %s() const;\n  size_t %sSize() const;\n  const uint8_t* %sData() const;\n", f.Name, f.Name, f.Name)
+		case t.isBytes():
+			fmt.Fprintf(&b, "  uint8_t %s(size_t i) const;\n  size_t %sSize() const;\n  const uint8_t* %sData() const;\n", f.Name, f.Name, f.Name)
+		case t.slice:
+			fmt.Fprintf(&b, "  %s %s(size_t i) const;\n  size_t %sSize() const;\n", t.elem().cppViewType(), f.Name, f.Name)
+		default:
+			fmt.Fprintf(&b, "  %s %s() const;\n", t.cppViewType(), f.Name)
+		}
+	}
+	return b.String()
+}
+
+// cppViewValue returns the C++ expression of the value of the non-slice type t at the offset o in the view.
+func (s *Schema) cppViewValue(t schemaType, o string) string {
+	switch {
+	case t.isString():
+		return fmt.Sprintf("ViewString(data_, size_, %s)", o)
+	case t.isStruct():
+		return fmt.Sprintf("%sView{data_, size_, %s}", t.name, o)
+	}
+	return fmt.Sprintf("Load<%s>(data_, %s)", t.cppType(), o)
+}
+
+// cppAccessorDefs returns the definitions of the accessors of the C++ view.
+func (s *Schema) cppAccessorDefs(st *SchemaStruct) string {
+	var b strings.Builder
+	view := st.Name + "View"
+	var offset int
+	for _, f := range st.Fields {
+		t := parseSchemaType(f.Type)
+		o := offsetExpr("offset_", offset)
+		offset += s.size(t)
+
+		if !t.slice && !t.isString() {
+			fmt.Fprintf(&b, "%s %s::%s() const {\n  if (!valid_) {\n    return %s{};\n  }\n  return %s;\n}\n\n", t.cppViewType(), view, f.Name, t.cppViewType(), s.cppViewValue(t, o))
+			continue
+		}
+
+		size := 1
+		if t.slice {
+			size = s.size(t.elem())
+		}
+		ref := fmt.Sprintf("  size_t start = 0;\n  size_t n = 0;\n  if (!valid_ || !Ref(data_, size_, %s, %d, &start, &n)) {\n", o, size)
+		if t.isString() {
+			fmt.Fprintf(&b, "std::string %s::%s() const {\n%s    return std::string{};\n  }\n  return std::string{reinterpret_cast<const char*>(data_ + start), n};\n}\n\n", view, f.Name, ref)
+		} else {
+			e := t.elem()
+			fmt.Fprintf(&b, "%s %s::%s(size_t i) const {\n%s    return %s{};\n  }\n  if (i >= n) {\n    return %s{};\n  }\n  return %s;\n}\n\n", e.cppViewType(), view, f.Name, ref, e.cppViewType(), e.cppViewType(), s.cppViewValue(e, fmt.Sprintf("start + i * %d", size)))
+		}
+		fmt.Fprintf(&b, "size_t %s::%sSize() const {\n%s    return 0;\n  }\n  return n;\n}\n\n", view, f.Name, ref)
+		if t.isString() || t.isBytes() {
+			fmt.Fprintf(&b, "const uint8_t* %s::%sData() const {\n%s    return nullptr;\n  }\n  return data_ + start;\n}\n\n", view, f.Name, ref)
+		}
+	}
+	return b.String()
+}
+
+type schemaStructData struct {
+	Name        string
+	Fields      []schemaFieldData
+	Size        int
+	AppendTo    string
+	AppendToErr bool
+	ReadFrom    string
+	ReadFromErr bool
+	Accessors   string
+	PutBody     string
+	GetBody     string
+	AccessDefs  string
+}
+
+type schemaFieldData struct {
+	Name      string
+	GoType    string
+	CppType   string
+	CppMember string
+}
+
+func (s *Schema) templateData(structs []*SchemaStruct) []schemaStructData {
+	var data []schemaStructData
+	for _, st := range structs {
+		appendTo := s.goAppendTo(st)
+		readFrom := s.goReadFrom(st)
+		d := schemaStructData{
+			Name:     st.Name,
+			Size:     s.size(schemaType{name: st.Name}),
+			AppendTo: appendTo,
+			// The bodies assign err with "=" for the strings and the []byte fields.
+			AppendToErr: strings.Contains(appendTo, ", err = "),
+			ReadFrom:    readFrom,
+			ReadFromErr: strings.Contains(readFrom, ", err = "),
+			Accessors:   s.cppAccessorDecls(st),
+			PutBody:     s.cppPutBody(st),
+			GetBody:     s.cppGetBody(st),
+			AccessDefs:  s.cppAccessorDefs(st),
+		}
+		for _, f := range st.Fields {
+			t := parseSchemaType(f.Type)
+			d.Fields = append(d.Fields, schemaFieldData{
+				Name:      f.Name,
+				GoType:    t.goType(),
+				CppType:   t.cppType(),
+				CppMember: f.cppMember(),
+			})
+		}
+		data = append(data, d)
+	}
+	return data
+}
+
+// writeSchemaGo writes the Go code for schema to path.
+func writeSchemaGo(path string, schema *Schema) error {
+	structs := make([]*SchemaStruct, len(schema.Structs))
+	for i := range schema.Structs {
+		structs[i] = &schema.Structs[i]
+	}
+
+	var b strings.Builder
+	if err := schemaGoTmpl.Execute(&b, struct {
+		Package string
+		Structs []schemaStructData
+	}{
+		Package: schema.packageName(),
+		Structs: schema.templateData(structs),
+	}); err != nil {
+		return err
+	}
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, src, 0644)
+}
+
+func writeSchema(dir string, incpath string, namespace string, exportMacro string, schema *Schema) error {
+	data := schema.templateData(schema.sortedStructs())
+	{
+		f, err := os.Create(filepath.Join(dir, "schema.h"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := schemaHTmpl.Execute(f, struct {
+			IncludeGuard string
+			VersionCheck string
+			IncludePath  string
+			Namespace    string
+			Package      string
+			Export       string
+			Structs      []schemaStructData
+		}{
+			IncludeGuard: includeGuard(namespace) + "_SCHEMA_H",
+			VersionCheck: versionCheck(namespace, "schema.h"),
+			IncludePath:  incpath,
+			Namespace:    namespace,
+			Package:      schema.packageName(),
+			Export:       exportPrefix(exportMacro),
+			Structs:      data,
+		}); err != nil {
+			return err
+		}
+	}
+	{
+		f, err := os.Create(filepath.Join(dir, "schema.cpp"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := schemaCppTmpl.Execute(f, struct {
+			IncludePath string
+			Namespace   string
+			Package     string
+			Structs     []schemaStructData
+		}{
+			IncludePath: incpath,
+			Namespace:   namespace,
+			Package:     schema.packageName(),
+			Structs:     data,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var schemaGoTmpl = template.Must(template.New("schema.go").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	errSchemaRange    = errors.New("{{.Package}}: out of range")
+	errSchemaTooLarge = errors.New("{{.Package}}: too large")
+)
+
+func schemaBool(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// schemaAlloc appends n * size bytes to b for a string or a slice, and writes their offset and n at off.
+func schemaAlloc(b []byte, off int, n int, size int) ([]byte, int, error) {
+	start := len(b)
+	if uint64(n) > math.MaxUint32 || uint64(start)+uint64(n)*uint64(size) > math.MaxUint32 {
+		return nil, 0, errSchemaTooLarge
+	}
+	binary.LittleEndian.PutUint32(b[off:], uint32(start))
+	binary.LittleEndian.PutUint32(b[off+4:], uint32(n))
+	return append(b, make([]byte, n*size)...), start, nil
+}
+
+func schemaAppendString(b []byte, off int, str string) ([]byte, error) {
+	b, start, err := schemaAlloc(b, off, len(str), 1)
+	if err != nil {
+		return nil, err
+	}
+	copy(b[start:], str)
+	return b, nil
+}
+
+func schemaAppendBytes(b []byte, off int, bs []byte) ([]byte, error) {
+	b, start, err := schemaAlloc(b, off, len(bs), 1)
+	if err != nil {
+		return nil, err
+	}
+	copy(b[start:], bs)
+	return b, nil
+}
+
+// schemaRef returns the offset and the number of the elements of a string or a slice at off.
+func schemaRef(b []byte, off int, size int) (int, int, error) {
+	start := int64(binary.LittleEndian.Uint32(b[off:]))
+	n := int64(binary.LittleEndian.Uint32(b[off+4:]))
+	if start > int64(len(b)) || n*int64(size) > int64(len(b))-start {
+		return 0, 0, errSchemaRange
+	}
+	return int(start), int(n), nil
+}
+
+func schemaString(b []byte, off int) (string, error) {
+	start, n, err := schemaRef(b, off, 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[start : start+n]), nil
+}
+
+func schemaBytes(b []byte, off int) ([]byte, error) {
+	start, n, err := schemaRef(b, off, 1)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, b[start:start+n]...), nil
+}
+{{range .Structs}}
+type {{.Name}} struct {
+{{range .Fields}}	{{.Name}} {{.GoType}}
+{{end}}}
+
+const sizeOf{{.Name}} = {{.Size}}
+
+// MarshalBinary encodes v in the format that {{.Name}}View and Unmarshal in C++ read.
+func (v *{{.Name}}) MarshalBinary() ([]byte, error) {
+	return v.appendTo(make([]byte, sizeOf{{.Name}}), 0)
+}
+
+// UnmarshalBinary decodes the bytes encoded by MarshalBinary or Marshal in C++.
+func (v *{{.Name}}) UnmarshalBinary(b []byte) error {
+	if len(b) < sizeOf{{.Name}} {
+		return errSchemaRange
+	}
+	return v.readFrom(b, 0)
+}
+
+func (v *{{.Name}}) appendTo(b []byte, off int) ([]byte, error) {
+{{if .AppendToErr}}	var err error
+{{end}}{{.AppendTo}}	return b, nil
+}
+
+func (v *{{.Name}}) readFrom(b []byte, off int) error {
+{{if .ReadFromErr}}	var err error
+{{end}}{{.ReadFrom}}	return nil
+}
+{{end}}`))
+
+var schemaHTmpl = template.Must(template.New("schema.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include "{{.IncludePath}}config.h"
+
+#include <cstddef>
+#include <cstdint>
+#include <string>
+#include <vector>
+
+{{.VersionCheck}}
+
+namespace {{.Namespace}} {
+
+// The structs and the views in {{.Package}} are generated from the schema given to the generator. The Go code
+// generated from the same schema encodes and decodes them in the same format.
+namespace {{.Package}} {
+
+{{range .Structs}}struct {{.Name}};
+class {{.Name}}View;
+{{end}}
+{{range .Structs}}struct {{.Name}} {
+{{range .Fields}}  {{.CppType}} {{.CppMember}}{};
+{{end}}};
+
+{{end}}{{range .Structs}}// {{.Name}}View reads a {{.Name}} in place from the bytes, which must be valid while the view is used. The accessors
+// return the zero values when the data is out of the bytes.
+class {{$.Export}}{{.Name}}View {
+public:
+  static constexpr size_t kSize = {{.Size}};
+
+  {{.Name}}View() = default;
+  {{.Name}}View(const uint8_t* data, size_t size, size_t offset = 0);
+
+  // IsValid reports whether the fixed-size part of the struct is in the bytes.
+  bool IsValid() const;
+
+{{.Accessors}}
+private:
+  const uint8_t* data_ = nullptr;
+  size_t size_ = 0;
+  size_t offset_ = 0;
+  bool valid_ = false;
+};
+
+// Marshal encodes v into bytes. Marshal returns false if the encoded data exceeds 4 GiB.
+{{$.Export}}bool Marshal(const {{.Name}}& v, std::vector<uint8_t>* bytes);
+
+// Unmarshal decodes the bytes into v. Unmarshal returns false if the bytes are broken.
+{{$.Export}}bool Unmarshal(const uint8_t* data, size_t size, {{.Name}}* v);
+
+{{end}}}
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
+
+var schemaCppTmpl = template.Must(template.New("schema.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}schema.h"
+
+#include <cstring>
+
+namespace {{.Namespace}} {
+
+namespace {{.Package}} {
+
+namespace {
+
+template<typename T>
+T Load(const uint8_t* data, size_t offset) {
+  T v;
+  std::memcpy(&v, data + offset, sizeof(T));
+  return v;
+}
+
+template<>
+bool Load<bool>(const uint8_t* data, size_t offset) {
+  return data[offset] != 0;
+}
+
+template<typename T>
+void Store(std::vector<uint8_t>* b, size_t offset, T v) {
+  std::memcpy(b->data() + offset, &v, sizeof(T));
+}
+
+template<>
+void Store<bool>(std::vector<uint8_t>* b, size_t offset, bool v) {
+  (*b)[offset] = v ? 1 : 0;
+}
+
+// Alloc appends n * elem_size bytes to b for a string or a slice, and writes their offset and n at offset.
+bool Alloc(std::vector<uint8_t>* b, size_t offset, size_t n, size_t elem_size, size_t* start) {
+  size_t s = b->size();
+  if (static_cast<uint64_t>(n) > UINT32_MAX || static_cast<uint64_t>(s) + static_cast<uint64_t>(n) * elem_size > UINT32_MAX) {
+    return false;
+  }
+  b->resize(s + n * elem_size);
+  Store<uint32_t>(b, offset, static_cast<uint32_t>(s));
+  Store<uint32_t>(b, offset + 4, static_cast<uint32_t>(n));
+  *start = s;
+  return true;
+}
+
+bool PutBytes(std::vector<uint8_t>* b, size_t offset, const uint8_t* data, size_t n) {
+  size_t start = 0;
+  if (!Alloc(b, offset, n, 1, &start)) {
+    return false;
+  }
+  if (n) {
+    std::memcpy(b->data() + start, data, n);
+  }
+  return true;
+}
+
+// Ref returns the offset and the number of the elements of a string or a slice at offset.
+bool Ref(const uint8_t* data, size_t size, size_t offset, size_t elem_size, size_t* start, size_t* n) {
+  uint32_t s = Load<uint32_t>(data, offset);
+  uint32_t c = Load<uint32_t>(data, offset + 4);
+  if (s > size || static_cast<uint64_t>(c) * elem_size > size - s) {
+    return false;
+  }
+  *start = s;
+  *n = c;
+  return true;
+}
+
+bool GetString(const uint8_t* data, size_t size, size_t offset, std::string* str) {
+  size_t start = 0;
+  size_t n = 0;
+  if (!Ref(data, size, offset, 1, &start, &n)) {
+    return false;
+  }
+  str->assign(reinterpret_cast<const char*>(data + start), n);
+  return true;
+}
+
+std::string ViewString(const uint8_t* data, size_t size, size_t offset) {
+  std::string str;
+  GetString(data, size, offset, &str);
+  return str;
+}
+
+{{range .Structs}}bool Put(const {{.Name}}& v, std::vector<uint8_t>* b, size_t offset);
+bool Get(const uint8_t* data, size_t size, size_t offset, {{.Name}}* v);
+{{end}}
+{{range .Structs}}bool Put(const {{.Name}}& v, std::vector<uint8_t>* b, size_t offset) {
+{{.PutBody}}  return true;
+}
+
+bool Get(const uint8_t* data, size_t size, size_t offset, {{.Name}}* v) {
+{{.GetBody}}  return true;
+}
+
+{{end}}}
+
+{{range .Structs}}{{.Name}}View::{{.Name}}View(const uint8_t* data, size_t size, size_t offset)
+    : data_{data},
+      size_{size},
+      offset_{offset},
+      valid_{data && offset <= size && kSize <= size - offset} {
+}
+
+bool {{.Name}}View::IsValid() const {
+  return valid_;
+}
+
+{{.AccessDefs}}bool Marshal(const {{.Name}}& v, std::vector<uint8_t>* bytes) {
+  bytes->assign({{.Name}}View::kSize, 0);
+  return Put(v, bytes, 0);
+}
+
+bool Unmarshal(const uint8_t* data, size_t size, {{.Name}}* v) {
+  if (!data || size < {{.Name}}View::kSize) {
+    return false;
+  }
+  return Get(data, size, 0, v);
+}
+
+{{end}}}
+
+}
+`))
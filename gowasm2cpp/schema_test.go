@@ -0,0 +1,343 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const testSchema = `{
+  "package": "msg",
+  "structs": [
+    {"name": "Player", "fields": [
+      {"name": "ID", "type": "uint32"},
+      {"name": "Name", "type": "string"},
+      {"name": "Pos", "type": "Point"},
+      {"name": "Alive", "type": "bool"},
+      {"name": "Scores", "type": "[]int64"},
+      {"name": "Path", "type": "[]Point"},
+      {"name": "Tags", "type": "[]string"},
+      {"name": "Flags", "type": "[]bool"},
+      {"name": "Data", "type": "[]byte"}
+    ]},
+    {"name": "Point", "fields": [
+      {"name": "X", "type": "float32"},
+      {"name": "Y", "type": "float64"},
+      {"name": "Z", "type": "int8"},
+      {"name": "W", "type": "uint16"}
+    ]}
+  ]
+}`
+
+const schemaMainGo = `package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+)
+
+func main() {
+	p := &Player{
+		ID:     0xdeadbeef,
+		Name:   "gopher",
+		Pos:    Point{X: 1.5, Y: -2.25, Z: -3, W: 65535},
+		Alive:  true,
+		Scores: []int64{-1, 1 << 40},
+		Path:   []Point{{X: 1}, {Y: 2, Z: 127}},
+		Tags:   []string{"a", "", "bc"},
+		Flags:  []bool{true, false, true},
+		Data:   []byte{0, 1, 255},
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	var q Player
+	if err := q.UnmarshalBinary(b); err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(p, &q) {
+		panic(fmt.Sprintf("got: %v, want: %v", q, p))
+	}
+	os.Stdout.Write(b)
+}
+`
+
+const schemaMainCpp = `#include "schema.h"
+
+#include <cstdio>
+#include <iostream>
+#include <iterator>
+#include <vector>
+
+using namespace go2cpp_test::msg;
+
+int main() {
+  std::vector<uint8_t> in{std::istreambuf_iterator<char>(std::cin), std::istreambuf_iterator<char>()};
+
+  PlayerView view{in.data(), in.size()};
+  if (!view.IsValid() ||
+      view.ID() != 0xdeadbeef ||
+      view.Name() != "gopher" ||
+      view.Pos().X() != 1.5f ||
+      view.Pos().Z() != -3 ||
+      view.Pos().W() != 65535 ||
+      !view.Alive() ||
+      view.ScoresSize() != 2 ||
+      view.Scores(1) != (1LL << 40) ||
+      view.Scores(2) != 0 ||
+      view.Path(1).Z() != 127 ||
+      view.Tags(2) != "bc" ||
+      !view.Flags(2) ||
+      view.DataSize() != 3 ||
+      view.DataData()[2] != 255) {
+    std::fprintf(stderr, "unexpected view\n");
+    return 1;
+  }
+  if (PlayerView{in.data(), PlayerView::kSize - 1}.IsValid()) {
+    std::fprintf(stderr, "a short view must be invalid\n");
+    return 1;
+  }
+
+  Player p;
+  if (!Unmarshal(in.data(), in.size(), &p)) {
+    std::fprintf(stderr, "Unmarshal failed\n");
+    return 1;
+  }
+  if (p.pos.y != -2.25 || p.tags.size() != 3 || p.path[0].x != 1 || p.data != std::vector<uint8_t>{0, 1, 255}) {
+    std::fprintf(stderr, "unexpected struct\n");
+    return 1;
+  }
+  if (Unmarshal(in.data(), in.size() - 1, &p)) {
+    std::fprintf(stderr, "Unmarshal must fail with broken bytes\n");
+    return 1;
+  }
+
+  std::vector<uint8_t> out;
+  if (!Marshal(p, &out)) {
+    std::fprintf(stderr, "Marshal failed\n");
+    return 1;
+  }
+  std::fwrite(out.data(), 1, out.size(), stdout);
+  return 0;
+}
+`
+
+// buildSchema generates the Go code and the C++ code from testSchema, and builds mainGo and mainCpp with them.
+// buildSchema returns the paths of the executables.
+func buildSchema(t *testing.T, mainGo, mainCpp string) (string, string) {
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("C++ compiler not found")
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found")
+	}
+
+	schema, err := ParseSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema.Package = "main"
+
+	dir := t.TempDir()
+	goDir := t.TempDir()
+	if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ids.wat"), "go2cpp_test", &Options{
+		Schema:   schema,
+		SchemaGo: filepath.Join(goDir, "schema.go"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(goDir, "main.go"), []byte(mainGo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	goBin := filepath.Join(goDir, "test")
+	cmd := exec.Command(goCmd, "build", "-o", goBin, "main.go", "schema.go")
+	cmd.Dir = goDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building failed: %v\n%s", err, out)
+	}
+
+	// The C++ namespace is the package name of the Go code, so generate the C++ code again with the original name.
+	schema.Package = "msg"
+	if err := GenerateWithOptions(dir, "", filepath.Join("testdata", "ids.wat"), "go2cpp_test", &Options{
+		Schema: schema,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.cpp"), []byte(mainCpp), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cppBin := filepath.Join(dir, "test")
+	cmd = exec.Command(cxx, "-std=c++14", "-o", cppBin, "main.cpp", "schema.cpp")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("compiling failed: %v\n%s", err, out)
+	}
+	return goBin, cppBin
+}
+
+// runSchema runs the executable bin with the arguments and stdin, and returns the output.
+func runSchema(t *testing.T, bin string, stdin []byte, args ...string) []byte {
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, stderr.Bytes())
+	}
+	return out
+}
+
+// TestSchema checks that the Go code and the C++ code generated from a schema encode a struct into the same bytes.
+func TestSchema(t *testing.T) {
+	goBin, cppBin := buildSchema(t, schemaMainGo, schemaMainCpp)
+	want := runSchema(t, goBin, nil)
+	if got := runSchema(t, cppBin, want); !bytes.Equal(got, want) {
+		t.Errorf("got: %x, want: %x", got, want)
+	}
+}
+
+const schemaRoundTripMainGo = `package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		p := &Player{
+			ID:     1,
+			Name:   "gopher",
+			Pos:    Point{X: 1.5, Y: -2.25, Z: -3, W: 65535},
+			Scores: []int64{-1, 1 << 40},
+			Path:   []Point{{X: 1}, {Y: 2, Z: 127}},
+			Tags:   []string{"a", "", "bc"},
+			Flags:  []bool{true, false},
+		}
+		b, err := p.MarshalBinary()
+		if err != nil {
+			panic(err)
+		}
+		os.Stdout.Write(b)
+		return
+	}
+
+	// The bytes from the C++ program.
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		panic(err)
+	}
+	var q Player
+	if err := q.UnmarshalBinary(b); err != nil {
+		panic(err)
+	}
+	want := Player{
+		ID:     2,
+		Name:   "gopher!",
+		Pos:    Point{X: -2.25, Y: 1.5, Z: 3, W: 65534},
+		Alive:  true,
+		Scores: []int64{-2, 1 << 41},
+		Path:   []Point{{Y: 2, Z: 127}, {X: 1}},
+		Tags:   []string{"bc", "", "a", "d"},
+		Flags:  []bool{false, true},
+		Data:   []byte{3},
+	}
+	if !reflect.DeepEqual(q, want) {
+		panic(fmt.Sprintf("got: %+v, want: %+v", q, want))
+	}
+	fmt.Print("ok")
+}
+`
+
+const schemaRoundTripMainCpp = `#include "schema.h"
+
+#include <cstdio>
+#include <iostream>
+#include <iterator>
+#include <vector>
+
+using namespace go2cpp_test::msg;
+
+int main() {
+  std::vector<uint8_t> in{std::istreambuf_iterator<char>(std::cin), std::istreambuf_iterator<char>()};
+  PlayerView view{in.data(), in.size()};
+  if (!view.IsValid()) {
+    std::fprintf(stderr, "invalid view\n");
+    return 1;
+  }
+
+  // Make a new struct from the view.
+  Player p;
+  p.id = view.ID() + 1;
+  p.name = view.Name() + "!";
+  p.pos.x = static_cast<float>(view.Pos().Y());
+  p.pos.y = view.Pos().X();
+  p.pos.z = -view.Pos().Z();
+  p.pos.w = view.Pos().W() - 1;
+  p.alive = !view.Alive();
+  for (size_t i = 0; i < view.ScoresSize(); i++) {
+    p.scores.push_back(view.Scores(i) * 2);
+  }
+  for (size_t i = view.PathSize(); i > 0; i--) {
+    PointView v = view.Path(i - 1);
+    p.path.push_back(Point{v.X(), v.Y(), v.Z(), v.W()});
+  }
+  for (size_t i = view.TagsSize(); i > 0; i--) {
+    p.tags.push_back(view.Tags(i - 1));
+  }
+  p.tags.push_back("d");
+  for (size_t i = 0; i < view.FlagsSize(); i++) {
+    p.flags.push_back(!view.Flags(i));
+  }
+  p.data.push_back(static_cast<uint8_t>(view.DataSize() + 3));
+
+  std::vector<uint8_t> out;
+  if (!Marshal(p, &out)) {
+    std::fprintf(stderr, "Marshal failed\n");
+    return 1;
+  }
+  std::fwrite(out.data(), 1, out.size(), stdout);
+  return 0;
+}
+`
+
+// TestSchemaRoundTrip checks that a struct marshaled by the Go code is read by the C++ view, and that a struct made
+// from it and marshaled by the C++ code is unmarshaled by the Go code.
+func TestSchemaRoundTrip(t *testing.T) {
+	goBin, cppBin := buildSchema(t, schemaRoundTripMainGo, schemaRoundTripMainCpp)
+	b := runSchema(t, cppBin, runSchema(t, goBin, nil))
+	if got, want := string(runSchema(t, goBin, b, "check")), "ok"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	for _, tc := range []string{
+		`{"structs": [{"name": "a", "fields": []}]}`,
+		`{"structs": [{"name": "A", "fields": [{"name": "B", "type": "A"}]}]}`,
+		`{"structs": [{"name": "A", "fields": [{"name": "B", "type": "[][]int32"}]}]}`,
+		`{"structs": [{"name": "A", "fields": [{"name": "B", "type": "int"}]}]}`,
+		`{"structs": [{"name": "A", "fields": [{"name": "B", "type": "string"}, {"name": "BSize", "type": "int32"}]}]}`,
+		`{"structs": [{"name": "A", "fields": []}, {"name": "AView", "fields": []}]}`,
+		`{"package": "func", "structs": [{"name": "A", "fields": []}]}`,
+	} {
+		schema, err := ParseSchema([]byte(tc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := schema.validate(); err == nil {
+			t.Errorf("%s: validate must fail", tc)
+		}
+	}
+}
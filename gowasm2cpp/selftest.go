@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+func writeSelfTest(dir string, incpath string, namespace string, exportMacro string) error {
+	{
+		f, err := os.Create(filepath.Join(dir, "selftest.h"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := selfTestHTmpl.Execute(f, struct {
+			IncludeGuard string
+			VersionCheck string
+			IncludePath  string
+			Namespace    string
+			Export       string
+		}{
+			IncludeGuard: includeGuard(namespace) + "_SELFTEST_H",
+			VersionCheck: versionCheck(namespace, "selftest.h"),
+			IncludePath:  incpath,
+			Namespace:    namespace,
+			Export:       exportPrefix(exportMacro),
+		}); err != nil {
+			return err
+		}
+	}
+	{
+		f, err := os.Create(filepath.Join(dir, "selftest.cpp"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := selfTestCppTmpl.Execute(f, struct {
+			IncludePath string
+			Namespace   string
+		}{
+			IncludePath: incpath,
+			Namespace:   namespace,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var selfTestHTmpl = template.Must(template.New("selftest.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include "{{.IncludePath}}config.h"
+
+#include <string>
+
+{{.VersionCheck}}
+
+namespace {{.Namespace}} {
+
+// SelfTest checks the assumptions that the generated code makes about the target: little-endian and unaligned memory
+// accesses, IEEE 754 bit patterns, the helpers in bits.h and the semantics of the shifts. This catches porting
+// problems on unusual targets before the Go program misbehaves silently.
+//
+// SelfTest returns false with the failures in message. Go::Run calls SelfTest and aborts at a failure when
+// GO2CPP_SELF_TEST is defined.
+{{.Export}}bool SelfTest(std::string* message);
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
+
+var selfTestCppTmpl = template.Must(template.New("selftest.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}selftest.h"
+
+#include "{{.IncludePath}}bits.h"
+#include "{{.IncludePath}}mem.h"
+
+#include <cstdint>
+#include <iomanip>
+#include <limits>
+#include <sstream>
+#include <type_traits>
+
+namespace {{.Namespace}} {
+
+namespace {
+
+class Checker {
+public:
+  explicit Checker(std::string* message)
+      : message_{message} {
+  }
+
+  template<typename T, typename U>
+  void Equal(const char* what, T got, U want) {
+    if (got == static_cast<T>(want)) {
+      return;
+    }
+    std::ostringstream ss;
+    using Unsigned = typename std::make_unsigned<T>::type;
+    ss << what << ": got: 0x" << std::hex << static_cast<uint64_t>(static_cast<Unsigned>(got)) << ", want: 0x"
+       << static_cast<uint64_t>(static_cast<Unsigned>(static_cast<T>(want))) << "\n";
+    if (message_) {
+      *message_ += ss.str();
+    }
+    ok_ = false;
+  }
+
+  bool ok() const {
+    return ok_;
+  }
+
+private:
+  std::string* message_;
+  bool ok_ = true;
+};
+
+void CheckMem(Checker* c) {
+  Mem mem;
+  if (mem.GetSize() == 0) {
+    mem.Grow(1);
+  }
+
+  // WebAssembly's memory is little-endian.
+  mem.StoreInt32(0, 0x01020304);
+  c->Equal("the first byte of 0x01020304", mem.LoadUint8(0), static_cast<uint8_t>(0x04));
+  c->Equal("the last byte of 0x01020304", mem.LoadUint8(3), static_cast<uint8_t>(0x01));
+
+  // The addresses are not aligned.
+  mem.StoreInt8(1, -2);
+  c->Equal("LoadInt8 after StoreInt8", mem.LoadInt8(1), static_cast<int8_t>(-2));
+  c->Equal("LoadUint8 after StoreInt8", mem.LoadUint8(1), static_cast<uint8_t>(0xfe));
+  mem.StoreInt16(1, -0x1234);
+  c->Equal("LoadInt16 after StoreInt16", mem.LoadInt16(1), static_cast<int16_t>(-0x1234));
+  c->Equal("LoadUint16 after StoreInt16", mem.LoadUint16(1), static_cast<uint16_t>(0xedcc));
+  mem.StoreInt32(1, -0x12345678);
+  c->Equal("LoadInt32 after StoreInt32", mem.LoadInt32(1), static_cast<int32_t>(-0x12345678));
+  c->Equal("LoadUint32 after StoreInt32", mem.LoadUint32(1), static_cast<uint32_t>(0xedcba988u));
+  mem.StoreInt64(1, -0x123456789abcdefll);
+  c->Equal("LoadInt64 after StoreInt64", mem.LoadInt64(1), static_cast<int64_t>(-0x123456789abcdefll));
+  c->Equal("the first byte of StoreInt64", mem.LoadUint8(1), static_cast<uint8_t>(0x11));
+
+  // NaN payloads must be kept, e.g., copying a float must not be done with x87 instructions.
+  mem.StoreInt32(1, 0x7fc12345);
+  mem.StoreFloat32(9, mem.LoadFloat32(1));
+  c->Equal("a NaN payload via LoadFloat32 and StoreFloat32", mem.LoadUint32(9), static_cast<uint32_t>(0x7fc12345u));
+  mem.StoreInt64(1, 0x7ff8000012345678ll);
+  mem.StoreFloat64(9, mem.LoadFloat64(1));
+  c->Equal("a NaN payload via LoadFloat64 and StoreFloat64", mem.LoadInt64(9), static_cast<int64_t>(0x7ff8000012345678ll));
+}
+
+void CheckFloats(Checker* c) {
+  c->Equal("the bits of 1.0f", Bits::BitCast<uint32_t>(1.0f), static_cast<uint32_t>(0x3f800000u));
+  c->Equal("the bits of -0.0f", Bits::BitCast<uint32_t>(-0.0f), static_cast<uint32_t>(0x80000000u));
+  c->Equal("the bits of 1.0", Bits::BitCast<uint64_t>(1.0), static_cast<uint64_t>(0x3ff0000000000000ull));
+  c->Equal("the bits of -0.0", Bits::BitCast<uint64_t>(-0.0), static_cast<uint64_t>(0x8000000000000000ull));
+  c->Equal("the bits of +Inf", Bits::BitCast<uint64_t>(std::numeric_limits<double>::infinity()), static_cast<uint64_t>(0x7ff0000000000000ull));
+  c->Equal("the bits of 2 * the float of 0x40490fdb", Bits::BitCast<uint32_t>(2.0f * Bits::BitCast<float>(static_cast<uint32_t>(0x40490fdbu))), static_cast<uint32_t>(0x40c90fdbu));
+}
+
+void CheckBits(Checker* c) {
+  c->Equal("LeadingZeros(uint32_t{0})", Bits::LeadingZeros(static_cast<uint32_t>(0)), 32);
+  c->Equal("LeadingZeros(uint32_t{1})", Bits::LeadingZeros(static_cast<uint32_t>(1)), 31);
+  c->Equal("LeadingZeros(uint64_t{0})", Bits::LeadingZeros(static_cast<uint64_t>(0)), 64);
+  c->Equal("LeadingZeros(uint64_t{1})", Bits::LeadingZeros(static_cast<uint64_t>(1)), 63);
+  c->Equal("TrailingZeros(uint32_t{0})", Bits::TrailingZeros(static_cast<uint32_t>(0)), 32);
+  c->Equal("TrailingZeros(uint32_t{0x80000000})", Bits::TrailingZeros(static_cast<uint32_t>(0x80000000u)), 31);
+  c->Equal("TrailingZeros(uint64_t{0})", Bits::TrailingZeros(static_cast<uint64_t>(0)), 64);
+  c->Equal("TrailingZeros(uint64_t{0x8000000000000000})", Bits::TrailingZeros(static_cast<uint64_t>(0x8000000000000000ull)), 63);
+  c->Equal("OnesCount(uint32_t{0xffffffff})", Bits::OnesCount(static_cast<uint32_t>(0xffffffffu)), 32);
+  c->Equal("OnesCount(uint64_t{0x8000000000000001})", Bits::OnesCount(static_cast<uint64_t>(0x8000000000000001ull)), 2);
+  c->Equal("RotateLeft(uint32_t{0x80000001}, 1)", Bits::RotateLeft(static_cast<uint32_t>(0x80000001u), 1), static_cast<uint32_t>(3));
+  c->Equal("RotateLeft(uint32_t{0x80000001}, 0)", Bits::RotateLeft(static_cast<uint32_t>(0x80000001u), 0), static_cast<uint32_t>(0x80000001u));
+  c->Equal("RotateLeft(uint32_t{0x80000001}, -1)", Bits::RotateLeft(static_cast<uint32_t>(0x80000001u), -1), static_cast<uint32_t>(0xc0000000u));
+  c->Equal("RotateLeft(uint64_t{0x8000000000000001}, 65)", Bits::RotateLeft(static_cast<uint64_t>(0x8000000000000001ull), 65), static_cast<uint64_t>(3));
+}
+
+void CheckShifts(Checker* c) {
+  // The shift counts are not constants so that the shifts are done at runtime.
+  volatile int32_t one = 1;
+
+  // The right shifts of negative values are arithmetic, and the conversions to signed integers wrap around. These are
+  // implementation-defined before C++20.
+  c->Equal("int32_t{-8} >> 1", static_cast<int32_t>(-8) >> one, static_cast<int32_t>(-4));
+  c->Equal("int64_t{-8} >> 1", static_cast<int64_t>(-8) >> one, static_cast<int64_t>(-4));
+  c->Equal("int32_t{INT32_MIN} >> 31", std::numeric_limits<int32_t>::min() >> (one * 31), static_cast<int32_t>(-1));
+  c->Equal("static_cast<int32_t>(0xffffffff)", static_cast<int32_t>(static_cast<uint32_t>(0xffffffffu) >> (one - 1)), static_cast<int32_t>(-1));
+  c->Equal("static_cast<int64_t>(uint64_t{1} << 63)", static_cast<int64_t>(static_cast<uint64_t>(1) << (one * 63)), std::numeric_limits<int64_t>::min());
+  c->Equal("static_cast<int8_t>(0x80)", static_cast<int8_t>(static_cast<uint8_t>(0x80u + one - 1)), static_cast<int8_t>(-128));
+}
+
+}
+
+bool SelfTest(std::string* message) {
+  if (message) {
+    message->clear();
+  }
+  Checker c{message};
+  CheckMem(&c);
+  CheckFloats(&c);
+  CheckBits(&c);
+  CheckShifts(&c);
+  return c.ok();
+}
+
+}
+`))
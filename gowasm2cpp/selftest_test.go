@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const selfTestMainCpp = `#include "selftest.h"
+
+#include <cstdio>
+
+int main() {
+  std::string message;
+  if (!go2cpp_test::SelfTest(&message)) {
+    std::printf("%s", message.c_str());
+    return 1;
+  }
+  return 0;
+}
+`
+
+// TestSelfTest compiles the generated SelfTest with a C++ compiler and checks that it passes on the host.
+func TestSelfTest(t *testing.T) {
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("C++ compiler not found")
+	}
+
+	dir := t.TempDir()
+	for _, f := range []func() error{
+		func() error { return writeConfig(dir, "", "go2cpp_test", "c++14", "", false) },
+		func() error { return writeBits(dir, "", "go2cpp_test") },
+		func() error { return writeBytes(dir, "", "go2cpp_test", "") },
+		func() error { return writeLog(dir, "", "go2cpp_test", "") },
+		func() error { return writeMem(dir, "", "go2cpp_test", 0, nil) },
+		func() error { return writeSelfTest(dir, "", "go2cpp_test", "") },
+	} {
+		if err := f(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.cpp"), []byte(selfTestMainCpp), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bin := filepath.Join(dir, "test")
+	cmd := exec.Command(cxx, "-std=c++14", "-O2", "-o", bin, "test.cpp", "bits.cpp", "bytes.cpp", "log.cpp", "mem.cpp", "selftest.cpp")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("compiling failed: %v\n%s", err, out)
+	}
+	if out, err := exec.Command(bin).CombinedOutput(); err != nil {
+		t.Errorf("%v\n%s", err, out)
+	}
+}
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+type sizePackage struct {
+	Name      string `json:"name"`
+	Functions int    `json:"functions"`
+
+	// WasmSize is the bytes of the WebAssembly code of the functions.
+	WasmSize int `json:"wasmSize"`
+
+	// CppSize and Lines are the bytes and the lines of the generated C++ functions before formatting.
+	CppSize int `json:"cppSize"`
+	Lines   int `json:"lines"`
+}
+
+// sizeReport is a report of the sizes per Go package.
+type sizeReport struct {
+	// Packages are the Go packages in descending order of the generated bytes.
+	Packages []*sizePackage `json:"packages"`
+
+	// Total is the sum of Packages.
+	Total sizePackage `json:"total"`
+
+	// DataSize is the bytes of the data segments. The segments are not attributed to the packages as they have no
+	// names.
+	DataSize int `json:"dataSize"`
+}
+
+// newSizeReport creates a size report of funcs and data. The generated sizes are valid after the functions are
+// generated.
+func newSizeReport(funcs []*wasmFunc, data []wasmData) *sizeReport {
+	r := &sizeReport{}
+	pkgs := map[string]*sizePackage{}
+	for _, f := range funcs {
+		name := goPackage(f.Wasm.Name)
+		p, ok := pkgs[name]
+		if !ok {
+			p = &sizePackage{
+				Name: name,
+			}
+			pkgs[name] = p
+			r.Packages = append(r.Packages, p)
+		}
+		var wasmSize int
+		if f.Wasm.Body != nil {
+			wasmSize = len(f.Wasm.Body.Code)
+		}
+		for _, p := range []*sizePackage{p, &r.Total} {
+			p.Functions++
+			p.WasmSize += wasmSize
+			p.CppSize += f.size
+			p.Lines += f.lines
+		}
+	}
+	for _, d := range data {
+		r.DataSize += len(d.Data)
+	}
+
+	sort.Slice(r.Packages, func(i, j int) bool {
+		if r.Packages[i].CppSize != r.Packages[j].CppSize {
+			return r.Packages[i].CppSize > r.Packages[j].CppSize
+		}
+		return r.Packages[i].Name < r.Packages[j].Name
+	})
+	return r
+}
+
+func (r *sizeReport) writeJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
+
+func (r *sizeReport) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Functions\tWasm bytes\tC++ bytes\tLines\t\tPackage")
+	line := func(p *sizePackage, name string) {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t\t%s\n", p.Functions, p.WasmSize, p.CppSize, p.Lines, name)
+	}
+	for _, p := range r.Packages {
+		name := p.Name
+		if name == "" {
+			name = "(no package)"
+		}
+		line(p, name)
+	}
+	line(&r.Total, "(total)")
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nData: %d bytes\n", r.DataSize)
+	return err
+}
+
+// writeSizeReport writes the size report of funcs and data to path. The format is JSON if the extension is ".json",
+// and a text table otherwise.
+func writeSizeReport(path string, funcs []*wasmFunc, data []wasmData) error {
+	r := newSizeReport(funcs, data)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if filepath.Ext(path) == ".json" {
+		return r.writeJSON(f)
+	}
+	return r.writeText(f)
+}
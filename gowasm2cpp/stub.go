@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+// StubMode is the behavior of a stubbed-out function.
+type StubMode int
+
+const (
+	// StubTrap makes a stubbed-out function trap.
+	StubTrap StubMode = iota
+
+	// StubNop makes a stubbed-out function do nothing and return zero. The first call is reported to the standard
+	// error.
+	StubNop
+)
+
+// Stub represents functions that are stubbed out instead of being translated.
+//
+// Stubs are useful to translate a module that uses features never used at runtime, like unsupported imports.
+type Stub struct {
+	// Name is the name of an import function like "syscall/js.valueInstanceOf" or a Go function like
+	// "crypto/x509.loadSystemRoots". A name ending with ".*" like "crypto/x509.*" matches all the functions of the
+	// package.
+	Name string
+
+	// Mode is the behavior of the stubbed-out functions.
+	Mode StubMode
+}
+
+func (s *Stub) match(name string) bool {
+	if pkg := strings.TrimSuffix(s.Name, ".*"); pkg != s.Name {
+		return goPackage(name) == pkg
+	}
+	return name == s.Name
+}
+
+// findStub returns the first stub matching the function name. used records the indices of the matched stubs.
+func findStub(stubs []Stub, name string, used map[int]struct{}) (*Stub, bool) {
+	for i := range stubs {
+		if stubs[i].match(name) {
+			used[i] = struct{}{}
+			return &stubs[i], true
+		}
+	}
+	return nil, false
+}
+
+// stubBody returns the C++ body of the stubbed-out function name with sig.
+func stubBody(stub *Stub, name string, sig *wasm.FunctionSig) string {
+	lit := cppStringLiteral(name)
+	if stub.Mode == StubTrap {
+		return fmt.Sprintf(`  Trap(std::string(%s) + " is stubbed out");`, lit)
+	}
+
+	lines := []string{
+		"  static bool reported = false;",
+		"  if (!reported) {",
+		"    reported = true;",
+		fmt.Sprintf("    ReportStubCall(%s);", lit),
+		"  }",
+	}
+	if len(sig.ReturnTypes) > 0 {
+		lines = append(lines, "  return 0;")
+	}
+	return strings.Join(lines, "\n")
+}
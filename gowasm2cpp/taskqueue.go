@@ -8,7 +8,7 @@ import (
 	"text/template"
 )
 
-func writeTaskQueue(dir string, incpath string, namespace string) error {
+func writeTaskQueue(dir string, incpath string, namespace string, exportMacro string) error {
 	{
 		f, err := os.Create(filepath.Join(dir, "taskqueue.h"))
 		if err != nil {
@@ -18,12 +18,16 @@ func writeTaskQueue(dir string, incpath string, namespace string) error {
 
 		if err := taskqueueHTmpl.Execute(f, struct {
 			IncludeGuard string
+			VersionCheck string
 			IncludePath  string
 			Namespace    string
+			Export       string
 		}{
 			IncludeGuard: includeGuard(namespace) + "_TASKQUEUE_H",
+			VersionCheck: versionCheck(namespace, "taskqueue.h"),
 			IncludePath:  incpath,
 			Namespace:    namespace,
+			Export:       exportPrefix(exportMacro),
 		}); err != nil {
 			return err
 		}
@@ -53,47 +57,176 @@ var taskqueueHTmpl = template.Must(template.New("taskqueue.h").Parse(`// Code ge
 #ifndef {{.IncludeGuard}}
 #define {{.IncludeGuard}}
 
+#include "{{.IncludePath}}config.h"
+
+#include <chrono>
 #include <condition_variable>
+#include <cstdint>
 #include <functional>
+#include <map>
 #include <mutex>
 #include <queue>
-#include <future>
+#include <string>
+#include <thread>
+#include <unordered_map>
+#include <vector>
+
+{{.VersionCheck}}
 
 namespace {{.Namespace}} {
 
-class TaskQueue {
+// TaskQueue is a queue of tasks with priorities. A task with a higher priority is dequeued first, and the tasks with
+// the same priority are dequeued in FIFO order. So that a busy higher priority doesn't starve the lower ones, a task is
+// dequeued regardless of its priority after kMaxSkips tasks with higher priorities are dequeued before it.
+class {{.Export}}TaskQueue {
 public:
   using Task = std::function<void()>;
 
-  void Enqueue(Task task);
+  // Priority is the priority of a task, in the descending order.
+  enum class Priority {
+    // kAudio is for the audio callbacks, which must not be starved by the frames.
+    kAudio,
+
+    // kTimer is for the timeouts.
+    kTimer,
+
+    // kDefault is for the other tasks like events.
+    kDefault,
+
+    // kFrame is for the frames requested by requestAnimationFrame.
+    kFrame,
+  };
+
+  void Enqueue(Task task, Priority priority = Priority::kDefault);
+
+  // Dequeue waits for a task and returns it.
   Task Dequeue();
 
+  // TryDequeue returns false without waiting if there is no task.
+  bool TryDequeue(Task* task);
+
+  size_t Size();
+
+  // kMaxSkips is the maximum number of the tasks with higher priorities dequeued while a task waits.
+  static constexpr int kMaxSkips = 32;
+
 private:
+  static constexpr int kPriorityCount = 4;
+
+  // PopLocked must be called with mutex_ locked and a task in a queue.
+  Task PopLocked();
+
   std::mutex mutex_;
   std::condition_variable cond_;
-  std::queue<Task> queue_;
+  std::queue<Task> queues_[kPriorityCount];
+  // skips_ is the number of the tasks with higher priorities dequeued since each queue's last dequeue.
+  int skips_[kPriorityCount] = {};
+  size_t size_ = 0;
 };
 
-class Timer {
+// TimerThread runs the functions of all the timers on one thread. The Go runtime schedules very many short timeouts,
+// and a thread per timer would be expensive.
+//
+// The thread wakes up at most once per GO2CPP_TIMER_RESOLUTION_MS milliseconds, and runs all the expired timers
+// together.
+class {{.Export}}TimerThread {
 public:
-  Timer(std::function<void()> func, double interval);
-  ~Timer();
+  static TimerThread& Get();
+
+  // Add registers func to be called after interval milliseconds, and returns the timer's ID. func is called on the
+  // timer thread, and must return quickly.
+  uint64_t Add(std::function<void()> func, double interval);
+
+  // Remove cancels the timer. If the timer's function is running, Remove waits for it unless Remove is called by the
+  // function itself.
+  void Remove(uint64_t id);
 
 private:
-  enum class Result {
-    kTimeout,
-    kNoTimeout,
+  struct Entry {
+    std::chrono::steady_clock::time_point deadline;
+    uint64_t id;
+
+    bool operator>(const Entry& rhs) const;
   };
 
-  void Stop();
-  Result WaitFor(double milliseconds);
+  TimerThread();
+  void Loop();
 
-  // All the member variables other than the future must be initialized before the future.
-  bool stopped_ = false;
   std::mutex mutex_;
   std::condition_variable cond_;
+  std::condition_variable done_cond_;
+  std::priority_queue<Entry, std::vector<Entry>, std::greater<Entry>> queue_;
+  std::unordered_map<uint64_t, std::function<void()>> funcs_;
+  uint64_t next_id_ = 1;
+  uint64_t running_id_ = 0;
+  std::thread::id thread_id_;
+
+  // thread_ must be initialized after the other members.
+  std::thread thread_;
+};
+
+// WorkerPool runs the named jobs on its threads, e.g., for CPU-heavy work like codecs without blocking the thread
+// running Go. The threads start at the first Submit.
+class {{.Export}}WorkerPool {
+public:
+  // Job takes the payload and returns the result. A Job fails by throwing an exception, preferably a std::exception
+  // whose what() is the error.
+  using Job = std::function<std::vector<uint8_t>(std::vector<uint8_t> payload)>;
+
+  // Done is called with the result of a job on the worker thread. error is empty if the job succeeded.
+  using Done = std::function<void(std::string error, std::vector<uint8_t> result)>;
+
+  // If thread_num is 0, the number of the hardware threads is used.
+  explicit WorkerPool(size_t thread_num = 0);
+
+  // The destructor waits for the running jobs, and drops the queued jobs.
+  ~WorkerPool();
+
+  // RegisterJob and UnregisterJob are concurrent-safe.
+  void RegisterJob(const std::string& name, Job job);
+  void UnregisterJob(const std::string& name);
+
+  // Submit queues the job name with payload, and returns false if no job is registered with name. Submit is
+  // concurrent-safe.
+  bool Submit(const std::string& name, std::vector<uint8_t> payload, Done done);
+
+  // Wait waits until all the submitted jobs finish.
+  void Wait();
+
+private:
+  struct Entry {
+    Job job;
+    std::vector<uint8_t> payload;
+    Done done;
+  };
+
+  WorkerPool(const WorkerPool&) = delete;
+  WorkerPool& operator=(const WorkerPool&) = delete;
+
+  void Loop();
+
+  size_t thread_num_;
+  std::mutex mutex_;
+  std::condition_variable cond_;
+  std::condition_variable done_cond_;
+  std::map<std::string, Job> jobs_;
+  std::queue<Entry> queue_;
+  size_t running_ = 0;
+  bool stopped_ = false;
+  std::vector<std::thread> threads_;
+};
 
-  std::future<void> future_;
+// Timer calls func once after interval milliseconds unless the timer is destroyed before.
+class {{.Export}}Timer {
+public:
+  Timer(std::function<void()> func, double interval);
+  ~Timer();
+
+  Timer(const Timer&) = delete;
+  Timer& operator=(const Timer&) = delete;
+
+private:
+  uint64_t id_;
 };
 
 }
@@ -105,57 +238,262 @@ var taskqueueCppTmpl = template.Must(template.New("taskqueue.cpp").Parse(`// Cod
 
 #include "{{.IncludePath}}taskqueue.h"
 
-#include <chrono>
-#include <memory>
+#include <algorithm>
+#include <exception>
 
 namespace {{.Namespace}} {
 
-void TaskQueue::Enqueue(Task task) {
+constexpr int TaskQueue::kMaxSkips;
+constexpr int TaskQueue::kPriorityCount;
+
+void TaskQueue::Enqueue(Task task, Priority priority) {
   {
     std::lock_guard<std::mutex> lock{mutex_};
-    queue_.push(task);
+    queues_[static_cast<int>(priority)].push(std::move(task));
+    size_++;
   }
   cond_.notify_one();
 }
 
 TaskQueue::Task TaskQueue::Dequeue() {
   std::unique_lock<std::mutex> lock{mutex_};
-  cond_.wait(lock, [this]{ return !queue_.empty(); });
-  Task task = queue_.front();
-  queue_.pop();
+  cond_.wait(lock, [this]{ return size_ > 0; });
+  return PopLocked();
+}
+
+bool TaskQueue::TryDequeue(Task* task) {
+  std::lock_guard<std::mutex> lock{mutex_};
+  if (size_ == 0) {
+    return false;
+  }
+  *task = PopLocked();
+  return true;
+}
+
+size_t TaskQueue::Size() {
+  std::lock_guard<std::mutex> lock{mutex_};
+  return size_;
+}
+
+TaskQueue::Task TaskQueue::PopLocked() {
+  int priority = -1;
+  // The lowest priority skipped too many times comes first.
+  for (int i = kPriorityCount - 1; i >= 0; i--) {
+    if (!queues_[i].empty() && skips_[i] >= kMaxSkips) {
+      priority = i;
+      break;
+    }
+  }
+  if (priority < 0) {
+    for (int i = 0; i < kPriorityCount; i++) {
+      if (!queues_[i].empty()) {
+        priority = i;
+        break;
+      }
+    }
+  }
+  if (priority < 0) {
+    return nullptr;
+  }
+
+  skips_[priority] = 0;
+  for (int i = priority + 1; i < kPriorityCount; i++) {
+    if (!queues_[i].empty()) {
+      skips_[i]++;
+    }
+  }
+  auto& queue = queues_[priority];
+  Task task = std::move(queue.front());
+  queue.pop();
+  size_--;
   return task;
 }
 
-Timer::Timer(std::function<void()> func, double interval)
-    : future_{std::async(
-        std::bind([this, interval](std::function<void()> func) {
-          Result result = WaitFor(interval);
-          if (result == Timer::Result::kNoTimeout) {
-            return;
-          }
-          func();
-        }, std::move(func))
-      )} {
+TimerThread& TimerThread::Get() {
+  // The thread is never destroyed so that the timers can be used during the destruction of the static objects.
+  static TimerThread* thread = new TimerThread();
+  return *thread;
 }
 
-Timer::~Timer() {
-  Stop();
-  future_.wait();
+TimerThread::TimerThread()
+    : thread_{[this] { Loop(); }} {
+  thread_.detach();
+}
+
+bool TimerThread::Entry::operator>(const Entry& rhs) const {
+  if (deadline != rhs.deadline) {
+    return deadline > rhs.deadline;
+  }
+  return id > rhs.id;
+}
+
+uint64_t TimerThread::Add(std::function<void()> func, double interval) {
+  auto deadline = std::chrono::steady_clock::now() +
+      std::chrono::duration_cast<std::chrono::steady_clock::duration>(
+          std::chrono::duration<double, std::milli>(interval));
+
+  uint64_t id;
+  bool earliest;
+  {
+    std::lock_guard<std::mutex> lock{mutex_};
+    id = next_id_;
+    next_id_++;
+    funcs_[id] = std::move(func);
+    earliest = queue_.empty() || deadline < queue_.top().deadline;
+    queue_.push(Entry{deadline, id});
+  }
+  // Wake the thread only when it has to wait for a shorter time.
+  if (earliest) {
+    cond_.notify_one();
+  }
+  return id;
+}
+
+void TimerThread::Remove(uint64_t id) {
+  std::unique_lock<std::mutex> lock{mutex_};
+  // The entry in the queue is dropped when it comes to the top.
+  funcs_.erase(id);
+  if (std::this_thread::get_id() == thread_id_) {
+    return;
+  }
+  done_cond_.wait(lock, [this, id] { return running_id_ != id; });
+}
+
+void TimerThread::Loop() {
+  auto resolution = std::chrono::duration_cast<std::chrono::steady_clock::duration>(
+      std::chrono::duration<double, std::milli>(GO2CPP_TIMER_RESOLUTION_MS));
+
+  std::unique_lock<std::mutex> lock{mutex_};
+  thread_id_ = std::this_thread::get_id();
+  auto last_wakeup = std::chrono::steady_clock::now() - resolution;
+  for (;;) {
+    while (!queue_.empty() && funcs_.find(queue_.top().id) == funcs_.end()) {
+      queue_.pop();
+    }
+    if (queue_.empty()) {
+      cond_.wait(lock);
+      continue;
+    }
+
+    auto wakeup = std::max(queue_.top().deadline, last_wakeup + resolution);
+    if (std::chrono::steady_clock::now() < wakeup) {
+      cond_.wait_until(lock, wakeup);
+      continue;
+    }
+
+    auto now = std::chrono::steady_clock::now();
+    last_wakeup = now;
+    while (!queue_.empty() && queue_.top().deadline <= now) {
+      uint64_t id = queue_.top().id;
+      queue_.pop();
+      auto it = funcs_.find(id);
+      if (it == funcs_.end()) {
+        continue;
+      }
+      std::function<void()> func = std::move(it->second);
+      funcs_.erase(it);
+
+      running_id_ = id;
+      lock.unlock();
+      func();
+      func = nullptr;
+      lock.lock();
+      running_id_ = 0;
+      done_cond_.notify_all();
+    }
+  }
 }
 
-void Timer::Stop() {
+WorkerPool::WorkerPool(size_t thread_num)
+    : thread_num_{thread_num ? thread_num : std::max(std::thread::hardware_concurrency(), 1u)} {
+}
+
+WorkerPool::~WorkerPool() {
   {
     std::lock_guard<std::mutex> lock{mutex_};
     stopped_ = true;
   }
+  cond_.notify_all();
+  for (auto& t : threads_) {
+    t.join();
+  }
+}
+
+void WorkerPool::RegisterJob(const std::string& name, Job job) {
+  std::lock_guard<std::mutex> lock{mutex_};
+  jobs_[name] = std::move(job);
+}
+
+void WorkerPool::UnregisterJob(const std::string& name) {
+  std::lock_guard<std::mutex> lock{mutex_};
+  jobs_.erase(name);
+}
+
+bool WorkerPool::Submit(const std::string& name, std::vector<uint8_t> payload, Done done) {
+  {
+    std::lock_guard<std::mutex> lock{mutex_};
+    auto it = jobs_.find(name);
+    if (it == jobs_.end()) {
+      return false;
+    }
+    queue_.push(Entry{it->second, std::move(payload), std::move(done)});
+    if (threads_.empty()) {
+      for (size_t i = 0; i < thread_num_; i++) {
+        threads_.emplace_back([this]() {
+          Loop();
+        });
+      }
+    }
+  }
   cond_.notify_one();
+  return true;
+}
+
+void WorkerPool::Wait() {
+  std::unique_lock<std::mutex> lock{mutex_};
+  done_cond_.wait(lock, [this] { return queue_.empty() && running_ == 0; });
 }
 
-Timer::Result Timer::WaitFor(double milliseconds) {
+void WorkerPool::Loop() {
   std::unique_lock<std::mutex> lock{mutex_};
-  auto duration = std::chrono::duration<double, std::milli>(milliseconds);
-  bool result = cond_.wait_for(lock, duration, [this]{ return stopped_; });
-  return result ? Result::kNoTimeout : Result::kTimeout;
+  for (;;) {
+    cond_.wait(lock, [this] { return stopped_ || !queue_.empty(); });
+    if (stopped_) {
+      return;
+    }
+    Entry entry = std::move(queue_.front());
+    queue_.pop();
+    running_++;
+    lock.unlock();
+
+    std::string error;
+    std::vector<uint8_t> result;
+    try {
+      result = entry.job(std::move(entry.payload));
+    } catch (const std::exception& e) {
+      error = e.what();
+      if (error.empty()) {
+        error = "job failed";
+      }
+    } catch (...) {
+      // An exception not derived from std::exception must not terminate the worker thread.
+      error = "job failed";
+    }
+    entry.done(std::move(error), std::move(result));
+    entry = Entry{};
+
+    lock.lock();
+    running_--;
+    done_cond_.notify_all();
+  }
+}
+
+Timer::Timer(std::function<void()> func, double interval)
+    : id_{TimerThread::Get().Add(std::move(func), interval)} {
+}
+
+Timer::~Timer() {
+  TimerThread::Get().Remove(id_);
 }
 
 }
@@ -0,0 +1,15 @@
+;; A module importing functions from "env" like C code linked into it.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (import "env" "add" (func $add (param i32 i32) (result i32)))
+  (import "env" "debug" (func $env_debug (param i32)))
+  (memory (export "mem") 1)
+
+  (func $test (export "test") (param $a i32) (result i32)
+    local.get $a
+    call $env_debug
+    local.get $a
+    i32.const 1
+    call $add
+  )
+)
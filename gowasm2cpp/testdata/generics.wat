@@ -0,0 +1,26 @@
+;; Functions with long names like the instantiations of generics, and identical types.
+;;
+;; The names of the two functions are different only after the first 512 characters of their identifiers.
+(module
+  (type $int (func (param i32) (result i32)))
+  (type $float64 (func (param i32) (result i32)))
+  (memory 1)
+  (table 2 funcref)
+  (elem (i32.const 0) $main.Reduce[go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.int] $main.Reduce[go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.float64])
+  (func $main.Reduce[go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.int] (type $int) (param $x i32) (result i32)
+    local.get $x
+    i32.const 1
+    i32.add
+  )
+  (func $main.Reduce[go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.struct{Key_go.shape.string,Value_go.shape.map[go.shape.string]*main.Node[go.shape.int]},go.shape.float64] (type $float64) (param $x i32) (result i32)
+    local.get $x
+    i32.const 2
+    i32.add
+  )
+  ;; The first function is called via the type $float64, which is identical to $int.
+  (func $call (export "call") (param $x i32) (result i32)
+    local.get $x
+    i32.const 0
+    call_indirect (type $float64)
+  )
+)
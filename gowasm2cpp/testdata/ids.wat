@@ -0,0 +1,168 @@
+;; A module like a Go program that gets host values and finalizes them, to check the IDs given to the values.
+;;
+;; run gets Array, Object and Date, finalizes Array and Object, and waits for a timeout. At the second resume, i.e.,
+;; after the finalized values are collected, the module gets Error, JSON and TextEncoder and writes all the IDs. Each
+;; timeout is 20 ms, so that the tasks enqueued before run, e.g., the events of Game, are run before the exit.
+;;
+;; valueInvoke is not used by the module, but by the tests running their own Instance with the imports.
+(module
+  (import "gojs" "runtime.wasmExit" (func $wasmExit (param i32)))
+  (import "gojs" "runtime.wasmWrite" (func $wasmWrite (param i32)))
+  (import "gojs" "runtime.scheduleTimeoutEvent" (func $scheduleTimeoutEvent (param i32)))
+  (import "gojs" "runtime.clearTimeoutEvent" (func $clearTimeoutEvent (param i32)))
+  (import "gojs" "syscall/js.valueGet" (func $valueGet (param i32)))
+  (import "gojs" "syscall/js.finalizeRef" (func $finalizeRef (param i32)))
+  (import "gojs" "syscall/js.valueInvoke" (func $valueInvoke (param i32)))
+  (memory (export "mem") 1)
+  (data (i32.const 2048) "ArrayObjectDateErrorJSONTextEncoder")
+  (global $state (mut i32) (i32.const 0))
+  (global $timeout (mut i32) (i32.const 0))
+  (global $pos (mut i32) (i32.const 0))
+
+  ;; The stack pointer is 1024 for all the calls.
+  (func $main.getsp (export "getsp") (result i32)
+    i32.const 1024
+  )
+
+  ;; get returns the ID of the property of the global object.
+  (func $get (param $name i32) (param $len i32) (result i32)
+    i32.const 1032
+    ;; The global object is the predefined ID 5.
+    i64.const 0x7ff8000100000005
+    i64.store
+    i32.const 1040
+    local.get $name
+    i64.extend_i32_u
+    i64.store
+    i32.const 1048
+    local.get $len
+    i64.extend_i32_u
+    i64.store
+    i32.const 1024
+    call $valueGet
+    i32.const 1056
+    i32.load
+  )
+
+  (func $finalize (param $id i32)
+    i32.const 1032
+    local.get $id
+    i32.store
+    i32.const 1024
+    call $finalizeRef
+  )
+
+  ;; print appends the ID in two digits and a space to the output at 3072.
+  (func $print (param $id i32)
+    global.get $pos
+    local.get $id
+    i32.const 10
+    i32.div_u
+    i32.const 48
+    i32.add
+    i32.store8 offset=3072
+    global.get $pos
+    local.get $id
+    i32.const 10
+    i32.rem_u
+    i32.const 48
+    i32.add
+    i32.store8 offset=3073
+    global.get $pos
+    i32.const 32
+    i32.store8 offset=3074
+    global.get $pos
+    i32.const 3
+    i32.add
+    global.set $pos
+  )
+
+  (func $schedule
+    i32.const 1032
+    i64.const 20
+    i64.store
+    i32.const 1024
+    call $scheduleTimeoutEvent
+    i32.const 1040
+    i32.load
+    global.set $timeout
+  )
+
+  (func $main.run (export "run") (param $argc i32) (param $argv i32)
+    (local $array i32)
+    (local $object i32)
+    i32.const 2048
+    i32.const 5
+    call $get
+    local.tee $array
+    call $print
+    i32.const 2053
+    i32.const 6
+    call $get
+    local.tee $object
+    call $print
+    i32.const 2059
+    i32.const 4
+    call $get
+    call $print
+    local.get $array
+    call $finalize
+    local.get $object
+    call $finalize
+    call $schedule
+  )
+
+  (func $main.resume (export "resume")
+    i32.const 1032
+    global.get $timeout
+    i32.store
+    i32.const 1024
+    call $clearTimeoutEvent
+
+    global.get $state
+    i32.eqz
+    if
+      i32.const 1
+      global.set $state
+      call $schedule
+      return
+    end
+
+    i32.const 2063
+    i32.const 5
+    call $get
+    call $print
+    i32.const 2068
+    i32.const 4
+    call $get
+    call $print
+    i32.const 2072
+    i32.const 11
+    call $get
+    call $print
+
+    ;; Write the output with a newline to the standard error.
+    global.get $pos
+    i32.const 10
+    i32.store8 offset=3072
+    i32.const 1032
+    i64.const 2
+    i64.store
+    i32.const 1040
+    i64.const 3072
+    i64.store
+    i32.const 1048
+    global.get $pos
+    i32.const 1
+    i32.add
+    i32.store
+    i32.const 1024
+    call $wasmWrite
+
+    i32.const 1032
+    i32.const 0
+    i32.store
+    i32.const 1024
+    call $wasmExit
+  )
+)
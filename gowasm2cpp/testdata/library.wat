@@ -0,0 +1,37 @@
+;; A module without the Go runtime, which runs with Library.
+(module
+  (import "gojs" "runtime.wasmWrite" (func $wasmWrite (param i32)))
+  (import "gojs" "runtime.wasmExit" (func $wasmExit (param i32)))
+  (memory (export "mem") 1)
+  (data (i32.const 64) "hello\n")
+  ;; The names that are not valid as C++ functions of Inst.
+  (export "add" (func $add))
+  (export "delete" (func $quit))
+  (export "go.quit" (func $quit))
+  (func $add (export "Add") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.add
+  )
+  (func $hello (export "Hello")
+    ;; wasmWrite(1, 64, 6) with the stack pointer 0.
+    i32.const 8
+    i64.const 1
+    i64.store
+    i32.const 16
+    i64.const 64
+    i64.store
+    i32.const 24
+    i32.const 6
+    i32.store
+    i32.const 0
+    call $wasmWrite
+  )
+  (func $quit (export "Quit") (param $code i32)
+    i32.const 8
+    local.get $code
+    i32.store
+    i32.const 0
+    call $wasmExit
+  )
+)
@@ -0,0 +1,111 @@
+;; Control instructions.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (table 1 funcref)
+  (elem (i32.const 0) $debug)
+  (global $sp (mut i32) (i32.const 0))
+  (data (i32.const 0) "")
+
+  (func $block (export "test_block") (param $a i32) (result i32)
+    block $exit
+      local.get $a
+      br_if $exit
+      i32.const 1
+      local.set $a
+    end
+    local.get $a
+  )
+  (func $loop (export "test_loop") (param $n i32) (result i32)
+    (local $sum i32)
+    loop $continue
+      local.get $sum
+      local.get $n
+      i32.add
+      local.set $sum
+      local.get $n
+      i32.const 1
+      i32.sub
+      local.tee $n
+      br_if $continue
+    end
+    local.get $sum
+  )
+  (func $if_else (export "test_if_else") (param $a i32) (result i32)
+    (local $r i32)
+    local.get $a
+    if
+      i32.const 1
+      local.set $r
+    else
+      i32.const 2
+      local.set $r
+    end
+    local.get $r
+  )
+  (func $br (export "test_br") (param $a i32)
+    block $outer
+      block $inner
+        local.get $a
+        br_if $inner
+        br $outer
+      end
+      local.get $a
+      call $debug
+    end
+  )
+  (func $br_table (export "test_br_table") (param $a i32) (result i32)
+    block $c
+      block $b
+        block $a
+          local.get $a
+          br_table $a $b $c
+        end
+        i32.const 10
+        return
+      end
+      i32.const 20
+      return
+    end
+    i32.const 30
+  )
+  (func $early_return (export "test_early_return") (param $a i32) (result i32)
+    local.get $a
+    i32.eqz
+    if
+      i32.const 0
+      return
+    end
+    local.get $a
+  )
+  (func $unreachable (export "test_unreachable")
+    unreachable
+  )
+  (func $nop (export "test_nop")
+    nop
+  )
+  (func $call (export "test_call") (param $a i32)
+    local.get $a
+    call $debug
+  )
+  (func $call_indirect (export "test_call_indirect") (param $a i32)
+    local.get $a
+    i32.const 0
+    call_indirect (param i32)
+  )
+  (func $br_table_goto (export "test_br_table_goto") (param $a i32)
+    block $done
+      block $c
+        block $b
+          local.get $a
+          br_table $b $c $done
+        end
+        i32.const 1
+        call $debug
+        br $done
+      end
+      i32.const 2
+      call $debug
+    end
+  )
+)
@@ -0,0 +1,130 @@
+;; Conversion instructions.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (table 1 funcref)
+  (elem (i32.const 0) $debug)
+  (global $sp (mut i32) (i32.const 0))
+  (data (i32.const 0) "")
+
+  (func $i32_wrap_i64 (export "i32_wrap_i64") (param $a i64) (result i32)
+    local.get $a
+    i32.wrap_i64
+  )
+  (func $i32_trunc_f32_s (export "i32_trunc_f32_s") (param $a f32) (result i32)
+    local.get $a
+    i32.trunc_f32_s
+  )
+  (func $i32_trunc_f32_u (export "i32_trunc_f32_u") (param $a f32) (result i32)
+    local.get $a
+    i32.trunc_f32_u
+  )
+  (func $i32_trunc_f64_s (export "i32_trunc_f64_s") (param $a f64) (result i32)
+    local.get $a
+    i32.trunc_f64_s
+  )
+  (func $i32_trunc_f64_u (export "i32_trunc_f64_u") (param $a f64) (result i32)
+    local.get $a
+    i32.trunc_f64_u
+  )
+  (func $i64_extend_i32_s (export "i64_extend_i32_s") (param $a i32) (result i64)
+    local.get $a
+    i64.extend_i32_s
+  )
+  (func $i64_extend_i32_u (export "i64_extend_i32_u") (param $a i32) (result i64)
+    local.get $a
+    i64.extend_i32_u
+  )
+  (func $i64_trunc_f32_s (export "i64_trunc_f32_s") (param $a f32) (result i64)
+    local.get $a
+    i64.trunc_f32_s
+  )
+  (func $i64_trunc_f32_u (export "i64_trunc_f32_u") (param $a f32) (result i64)
+    local.get $a
+    i64.trunc_f32_u
+  )
+  (func $i64_trunc_f64_s (export "i64_trunc_f64_s") (param $a f64) (result i64)
+    local.get $a
+    i64.trunc_f64_s
+  )
+  (func $i64_trunc_f64_u (export "i64_trunc_f64_u") (param $a f64) (result i64)
+    local.get $a
+    i64.trunc_f64_u
+  )
+  (func $f32_convert_i32_s (export "f32_convert_i32_s") (param $a i32) (result f32)
+    local.get $a
+    f32.convert_i32_s
+  )
+  (func $f32_convert_i32_u (export "f32_convert_i32_u") (param $a i32) (result f32)
+    local.get $a
+    f32.convert_i32_u
+  )
+  (func $f32_convert_i64_s (export "f32_convert_i64_s") (param $a i64) (result f32)
+    local.get $a
+    f32.convert_i64_s
+  )
+  (func $f32_convert_i64_u (export "f32_convert_i64_u") (param $a i64) (result f32)
+    local.get $a
+    f32.convert_i64_u
+  )
+  (func $f32_demote_f64 (export "f32_demote_f64") (param $a f64) (result f32)
+    local.get $a
+    f32.demote_f64
+  )
+  (func $f64_convert_i32_s (export "f64_convert_i32_s") (param $a i32) (result f64)
+    local.get $a
+    f64.convert_i32_s
+  )
+  (func $f64_convert_i32_u (export "f64_convert_i32_u") (param $a i32) (result f64)
+    local.get $a
+    f64.convert_i32_u
+  )
+  (func $f64_convert_i64_s (export "f64_convert_i64_s") (param $a i64) (result f64)
+    local.get $a
+    f64.convert_i64_s
+  )
+  (func $f64_convert_i64_u (export "f64_convert_i64_u") (param $a i64) (result f64)
+    local.get $a
+    f64.convert_i64_u
+  )
+  (func $f64_promote_f32 (export "f64_promote_f32") (param $a f32) (result f64)
+    local.get $a
+    f64.promote_f32
+  )
+  (func $i32_reinterpret_f32 (export "i32_reinterpret_f32") (param $a f32) (result i32)
+    local.get $a
+    i32.reinterpret_f32
+  )
+  (func $i64_reinterpret_f64 (export "i64_reinterpret_f64") (param $a f64) (result i64)
+    local.get $a
+    i64.reinterpret_f64
+  )
+  (func $f32_reinterpret_i32 (export "f32_reinterpret_i32") (param $a i32) (result f32)
+    local.get $a
+    f32.reinterpret_i32
+  )
+  (func $f64_reinterpret_i64 (export "f64_reinterpret_i64") (param $a i64) (result f64)
+    local.get $a
+    f64.reinterpret_i64
+  )
+  (func $i32_extend8_s (export "i32_extend8_s") (param $a i32) (result i32)
+    local.get $a
+    i32.extend8_s
+  )
+  (func $i32_extend16_s (export "i32_extend16_s") (param $a i32) (result i32)
+    local.get $a
+    i32.extend16_s
+  )
+  (func $i64_extend8_s (export "i64_extend8_s") (param $a i64) (result i64)
+    local.get $a
+    i64.extend8_s
+  )
+  (func $i64_extend16_s (export "i64_extend16_s") (param $a i64) (result i64)
+    local.get $a
+    i64.extend16_s
+  )
+  (func $i64_extend32_s (export "i64_extend32_s") (param $a i64) (result i64)
+    local.get $a
+    i64.extend32_s
+  )
+)
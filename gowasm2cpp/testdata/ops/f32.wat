@@ -0,0 +1,114 @@
+;; f32 arithmetic and comparison instructions.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (table 1 funcref)
+  (elem (i32.const 0) $debug)
+  (global $sp (mut i32) (i32.const 0))
+  (data (i32.const 0) "")
+
+  (func $f32_abs (export "f32_abs") (param $a f32) (result f32)
+    local.get $a
+    f32.abs
+  )
+  (func $f32_neg (export "f32_neg") (param $a f32) (result f32)
+    local.get $a
+    f32.neg
+  )
+  (func $f32_ceil (export "f32_ceil") (param $a f32) (result f32)
+    local.get $a
+    f32.ceil
+  )
+  (func $f32_floor (export "f32_floor") (param $a f32) (result f32)
+    local.get $a
+    f32.floor
+  )
+  (func $f32_trunc (export "f32_trunc") (param $a f32) (result f32)
+    local.get $a
+    f32.trunc
+  )
+  (func $f32_nearest (export "f32_nearest") (param $a f32) (result f32)
+    local.get $a
+    f32.nearest
+  )
+  (func $f32_sqrt (export "f32_sqrt") (param $a f32) (result f32)
+    local.get $a
+    f32.sqrt
+  )
+  (func $f32_add (export "f32_add") (param $a f32) (param $b f32) (result f32)
+    local.get $a
+    local.get $b
+    f32.add
+  )
+  (func $f32_sub (export "f32_sub") (param $a f32) (param $b f32) (result f32)
+    local.get $a
+    local.get $b
+    f32.sub
+  )
+  (func $f32_mul (export "f32_mul") (param $a f32) (param $b f32) (result f32)
+    local.get $a
+    local.get $b
+    f32.mul
+  )
+  (func $f32_div (export "f32_div") (param $a f32) (param $b f32) (result f32)
+    local.get $a
+    local.get $b
+    f32.div
+  )
+  (func $f32_min (export "f32_min") (param $a f32) (param $b f32) (result f32)
+    local.get $a
+    local.get $b
+    f32.min
+  )
+  (func $f32_max (export "f32_max") (param $a f32) (param $b f32) (result f32)
+    local.get $a
+    local.get $b
+    f32.max
+  )
+  (func $f32_copysign (export "f32_copysign") (param $a f32) (param $b f32) (result f32)
+    local.get $a
+    local.get $b
+    f32.copysign
+  )
+  (func $f32_eq (export "f32_eq") (param $a f32) (param $b f32) (result i32)
+    local.get $a
+    local.get $b
+    f32.eq
+  )
+  (func $f32_ne (export "f32_ne") (param $a f32) (param $b f32) (result i32)
+    local.get $a
+    local.get $b
+    f32.ne
+  )
+  (func $f32_lt (export "f32_lt") (param $a f32) (param $b f32) (result i32)
+    local.get $a
+    local.get $b
+    f32.lt
+  )
+  (func $f32_gt (export "f32_gt") (param $a f32) (param $b f32) (result i32)
+    local.get $a
+    local.get $b
+    f32.gt
+  )
+  (func $f32_le (export "f32_le") (param $a f32) (param $b f32) (result i32)
+    local.get $a
+    local.get $b
+    f32.le
+  )
+  (func $f32_ge (export "f32_ge") (param $a f32) (param $b f32) (result i32)
+    local.get $a
+    local.get $b
+    f32.ge
+  )
+  (func $f32_const (export "f32_const") (result f32)
+    f32.const 1.5
+    f32.const -0x1p-3
+    f32.add
+    f32.const inf
+    f32.add
+    f32.const nan
+    f32.add
+    f32.const -0
+    f32.add
+  )
+)
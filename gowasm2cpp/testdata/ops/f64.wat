@@ -0,0 +1,114 @@
+;; f64 arithmetic and comparison instructions.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (table 1 funcref)
+  (elem (i32.const 0) $debug)
+  (global $sp (mut i32) (i32.const 0))
+  (data (i32.const 0) "")
+
+  (func $f64_abs (export "f64_abs") (param $a f64) (result f64)
+    local.get $a
+    f64.abs
+  )
+  (func $f64_neg (export "f64_neg") (param $a f64) (result f64)
+    local.get $a
+    f64.neg
+  )
+  (func $f64_ceil (export "f64_ceil") (param $a f64) (result f64)
+    local.get $a
+    f64.ceil
+  )
+  (func $f64_floor (export "f64_floor") (param $a f64) (result f64)
+    local.get $a
+    f64.floor
+  )
+  (func $f64_trunc (export "f64_trunc") (param $a f64) (result f64)
+    local.get $a
+    f64.trunc
+  )
+  (func $f64_nearest (export "f64_nearest") (param $a f64) (result f64)
+    local.get $a
+    f64.nearest
+  )
+  (func $f64_sqrt (export "f64_sqrt") (param $a f64) (result f64)
+    local.get $a
+    f64.sqrt
+  )
+  (func $f64_add (export "f64_add") (param $a f64) (param $b f64) (result f64)
+    local.get $a
+    local.get $b
+    f64.add
+  )
+  (func $f64_sub (export "f64_sub") (param $a f64) (param $b f64) (result f64)
+    local.get $a
+    local.get $b
+    f64.sub
+  )
+  (func $f64_mul (export "f64_mul") (param $a f64) (param $b f64) (result f64)
+    local.get $a
+    local.get $b
+    f64.mul
+  )
+  (func $f64_div (export "f64_div") (param $a f64) (param $b f64) (result f64)
+    local.get $a
+    local.get $b
+    f64.div
+  )
+  (func $f64_min (export "f64_min") (param $a f64) (param $b f64) (result f64)
+    local.get $a
+    local.get $b
+    f64.min
+  )
+  (func $f64_max (export "f64_max") (param $a f64) (param $b f64) (result f64)
+    local.get $a
+    local.get $b
+    f64.max
+  )
+  (func $f64_copysign (export "f64_copysign") (param $a f64) (param $b f64) (result f64)
+    local.get $a
+    local.get $b
+    f64.copysign
+  )
+  (func $f64_eq (export "f64_eq") (param $a f64) (param $b f64) (result i32)
+    local.get $a
+    local.get $b
+    f64.eq
+  )
+  (func $f64_ne (export "f64_ne") (param $a f64) (param $b f64) (result i32)
+    local.get $a
+    local.get $b
+    f64.ne
+  )
+  (func $f64_lt (export "f64_lt") (param $a f64) (param $b f64) (result i32)
+    local.get $a
+    local.get $b
+    f64.lt
+  )
+  (func $f64_gt (export "f64_gt") (param $a f64) (param $b f64) (result i32)
+    local.get $a
+    local.get $b
+    f64.gt
+  )
+  (func $f64_le (export "f64_le") (param $a f64) (param $b f64) (result i32)
+    local.get $a
+    local.get $b
+    f64.le
+  )
+  (func $f64_ge (export "f64_ge") (param $a f64) (param $b f64) (result i32)
+    local.get $a
+    local.get $b
+    f64.ge
+  )
+  (func $f64_const (export "f64_const") (result f64)
+    f64.const 1.5
+    f64.const -0x1p-3
+    f64.add
+    f64.const inf
+    f64.add
+    f64.const nan
+    f64.add
+    f64.const -0
+    f64.add
+  )
+)
@@ -0,0 +1,158 @@
+;; i32 arithmetic, bitwise and comparison instructions.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (table 1 funcref)
+  (elem (i32.const 0) $debug)
+  (global $sp (mut i32) (i32.const 0))
+  (data (i32.const 0) "")
+
+  (func $i32_clz (export "i32_clz") (param $a i32) (result i32)
+    local.get $a
+    i32.clz
+  )
+  (func $i32_ctz (export "i32_ctz") (param $a i32) (result i32)
+    local.get $a
+    i32.ctz
+  )
+  (func $i32_popcnt (export "i32_popcnt") (param $a i32) (result i32)
+    local.get $a
+    i32.popcnt
+  )
+  (func $i32_eqz (export "i32_eqz") (param $a i32) (result i32)
+    local.get $a
+    i32.eqz
+  )
+  (func $i32_add (export "i32_add") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.add
+  )
+  (func $i32_sub (export "i32_sub") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.sub
+  )
+  (func $i32_mul (export "i32_mul") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.mul
+  )
+  (func $i32_div_s (export "i32_div_s") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.div_s
+  )
+  (func $i32_div_u (export "i32_div_u") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.div_u
+  )
+  (func $i32_rem_s (export "i32_rem_s") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.rem_s
+  )
+  (func $i32_rem_u (export "i32_rem_u") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.rem_u
+  )
+  (func $i32_and (export "i32_and") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.and
+  )
+  (func $i32_or (export "i32_or") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.or
+  )
+  (func $i32_xor (export "i32_xor") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.xor
+  )
+  (func $i32_shl (export "i32_shl") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.shl
+  )
+  (func $i32_shr_s (export "i32_shr_s") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.shr_s
+  )
+  (func $i32_shr_u (export "i32_shr_u") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.shr_u
+  )
+  (func $i32_rotl (export "i32_rotl") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.rotl
+  )
+  (func $i32_rotr (export "i32_rotr") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.rotr
+  )
+  (func $i32_eq (export "i32_eq") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.eq
+  )
+  (func $i32_ne (export "i32_ne") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.ne
+  )
+  (func $i32_lt_s (export "i32_lt_s") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.lt_s
+  )
+  (func $i32_lt_u (export "i32_lt_u") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.lt_u
+  )
+  (func $i32_gt_s (export "i32_gt_s") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.gt_s
+  )
+  (func $i32_gt_u (export "i32_gt_u") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.gt_u
+  )
+  (func $i32_le_s (export "i32_le_s") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.le_s
+  )
+  (func $i32_le_u (export "i32_le_u") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.le_u
+  )
+  (func $i32_ge_s (export "i32_ge_s") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.ge_s
+  )
+  (func $i32_ge_u (export "i32_ge_u") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    local.get $b
+    i32.ge_u
+  )
+  (func $i32_const (export "i32_const") (result i32)
+    i32.const -1
+    i32.const 0x7fffffff
+    i32.add
+    i32.const 0x80000000
+    i32.add
+  )
+)
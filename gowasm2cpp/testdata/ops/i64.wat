@@ -0,0 +1,158 @@
+;; i64 arithmetic, bitwise and comparison instructions.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (table 1 funcref)
+  (elem (i32.const 0) $debug)
+  (global $sp (mut i32) (i32.const 0))
+  (data (i32.const 0) "")
+
+  (func $i64_clz (export "i64_clz") (param $a i64) (result i64)
+    local.get $a
+    i64.clz
+  )
+  (func $i64_ctz (export "i64_ctz") (param $a i64) (result i64)
+    local.get $a
+    i64.ctz
+  )
+  (func $i64_popcnt (export "i64_popcnt") (param $a i64) (result i64)
+    local.get $a
+    i64.popcnt
+  )
+  (func $i64_eqz (export "i64_eqz") (param $a i64) (result i32)
+    local.get $a
+    i64.eqz
+  )
+  (func $i64_add (export "i64_add") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.add
+  )
+  (func $i64_sub (export "i64_sub") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.sub
+  )
+  (func $i64_mul (export "i64_mul") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.mul
+  )
+  (func $i64_div_s (export "i64_div_s") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.div_s
+  )
+  (func $i64_div_u (export "i64_div_u") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.div_u
+  )
+  (func $i64_rem_s (export "i64_rem_s") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.rem_s
+  )
+  (func $i64_rem_u (export "i64_rem_u") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.rem_u
+  )
+  (func $i64_and (export "i64_and") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.and
+  )
+  (func $i64_or (export "i64_or") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.or
+  )
+  (func $i64_xor (export "i64_xor") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.xor
+  )
+  (func $i64_shl (export "i64_shl") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.shl
+  )
+  (func $i64_shr_s (export "i64_shr_s") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.shr_s
+  )
+  (func $i64_shr_u (export "i64_shr_u") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.shr_u
+  )
+  (func $i64_rotl (export "i64_rotl") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.rotl
+  )
+  (func $i64_rotr (export "i64_rotr") (param $a i64) (param $b i64) (result i64)
+    local.get $a
+    local.get $b
+    i64.rotr
+  )
+  (func $i64_eq (export "i64_eq") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.eq
+  )
+  (func $i64_ne (export "i64_ne") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.ne
+  )
+  (func $i64_lt_s (export "i64_lt_s") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.lt_s
+  )
+  (func $i64_lt_u (export "i64_lt_u") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.lt_u
+  )
+  (func $i64_gt_s (export "i64_gt_s") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.gt_s
+  )
+  (func $i64_gt_u (export "i64_gt_u") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.gt_u
+  )
+  (func $i64_le_s (export "i64_le_s") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.le_s
+  )
+  (func $i64_le_u (export "i64_le_u") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.le_u
+  )
+  (func $i64_ge_s (export "i64_ge_s") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.ge_s
+  )
+  (func $i64_ge_u (export "i64_ge_u") (param $a i64) (param $b i64) (result i32)
+    local.get $a
+    local.get $b
+    i64.ge_u
+  )
+  (func $i64_const (export "i64_const") (result i64)
+    i64.const -1
+    i64.const 0x7fffffffffffffff
+    i64.add
+    i64.const 0x8000000000000000
+    i64.add
+  )
+)
@@ -0,0 +1,118 @@
+;; Memory instructions.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (table 1 funcref)
+  (elem (i32.const 0) $debug)
+  (global $sp (mut i32) (i32.const 0))
+  (data (i32.const 0) "")
+
+  (func $i32_load (export "i32_load") (param $addr i32) (result i32)
+    local.get $addr
+    i32.load offset=8
+  )
+  (func $i64_load (export "i64_load") (param $addr i32) (result i64)
+    local.get $addr
+    i64.load offset=8
+  )
+  (func $f32_load (export "f32_load") (param $addr i32) (result f32)
+    local.get $addr
+    f32.load offset=8
+  )
+  (func $f64_load (export "f64_load") (param $addr i32) (result f64)
+    local.get $addr
+    f64.load offset=8
+  )
+  (func $i32_load8_s (export "i32_load8_s") (param $addr i32) (result i32)
+    local.get $addr
+    i32.load8_s offset=8
+  )
+  (func $i32_load8_u (export "i32_load8_u") (param $addr i32) (result i32)
+    local.get $addr
+    i32.load8_u offset=8
+  )
+  (func $i32_load16_s (export "i32_load16_s") (param $addr i32) (result i32)
+    local.get $addr
+    i32.load16_s offset=8
+  )
+  (func $i32_load16_u (export "i32_load16_u") (param $addr i32) (result i32)
+    local.get $addr
+    i32.load16_u offset=8
+  )
+  (func $i64_load8_s (export "i64_load8_s") (param $addr i32) (result i64)
+    local.get $addr
+    i64.load8_s offset=8
+  )
+  (func $i64_load8_u (export "i64_load8_u") (param $addr i32) (result i64)
+    local.get $addr
+    i64.load8_u offset=8
+  )
+  (func $i64_load16_s (export "i64_load16_s") (param $addr i32) (result i64)
+    local.get $addr
+    i64.load16_s offset=8
+  )
+  (func $i64_load16_u (export "i64_load16_u") (param $addr i32) (result i64)
+    local.get $addr
+    i64.load16_u offset=8
+  )
+  (func $i64_load32_s (export "i64_load32_s") (param $addr i32) (result i64)
+    local.get $addr
+    i64.load32_s offset=8
+  )
+  (func $i64_load32_u (export "i64_load32_u") (param $addr i32) (result i64)
+    local.get $addr
+    i64.load32_u offset=8
+  )
+  (func $i32_store (export "i32_store") (param $addr i32) (param $v i32)
+    local.get $addr
+    local.get $v
+    i32.store offset=16
+  )
+  (func $i64_store (export "i64_store") (param $addr i32) (param $v i64)
+    local.get $addr
+    local.get $v
+    i64.store offset=16
+  )
+  (func $f32_store (export "f32_store") (param $addr i32) (param $v f32)
+    local.get $addr
+    local.get $v
+    f32.store offset=16
+  )
+  (func $f64_store (export "f64_store") (param $addr i32) (param $v f64)
+    local.get $addr
+    local.get $v
+    f64.store offset=16
+  )
+  (func $i32_store8 (export "i32_store8") (param $addr i32) (param $v i32)
+    local.get $addr
+    local.get $v
+    i32.store8 offset=16
+  )
+  (func $i32_store16 (export "i32_store16") (param $addr i32) (param $v i32)
+    local.get $addr
+    local.get $v
+    i32.store16 offset=16
+  )
+  (func $i64_store8 (export "i64_store8") (param $addr i32) (param $v i64)
+    local.get $addr
+    local.get $v
+    i64.store8 offset=16
+  )
+  (func $i64_store16 (export "i64_store16") (param $addr i32) (param $v i64)
+    local.get $addr
+    local.get $v
+    i64.store16 offset=16
+  )
+  (func $i64_store32 (export "i64_store32") (param $addr i32) (param $v i64)
+    local.get $addr
+    local.get $v
+    i64.store32 offset=16
+  )
+  (func $memory_size (export "memory_size") (result i32)
+    memory.size
+  )
+  (func $memory_grow (export "memory_grow") (param $delta i32) (result i32)
+    local.get $delta
+    memory.grow
+  )
+)
@@ -0,0 +1,20 @@
+;; The stack checks of the Go runtime.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (global $sp (mut i32) (i32.const 0))
+  (data (i32.const 0) "")
+
+  (func $runtime.morestack_noctxt (param i32))
+  (func $stack_check (export "test_stack_check") (param $a i32)
+    global.get $sp
+    i32.const 16
+    i32.lt_u
+    if
+      i32.const 0
+      call $runtime.morestack_noctxt
+    end
+    local.get $a
+    call $debug
+  )
+)
@@ -0,0 +1,39 @@
+;; Parametric and variable instructions.
+(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (table 1 funcref)
+  (elem (i32.const 0) $debug)
+  (global $sp (mut i32) (i32.const 0))
+  (data (i32.const 0) "")
+
+  (func $drop (export "test_drop") (param $a i32)
+    local.get $a
+    drop
+  )
+  (func $select (export "test_select") (param $a i64) (param $b i64) (param $c i32) (result i64)
+    local.get $a
+    local.get $b
+    local.get $c
+    select
+  )
+  (func $locals (export "test_locals") (param $a i32) (result f64)
+    (local $b i64) (local $c f32) (local $d f64)
+    local.get $a
+    i64.extend_i32_u
+    local.set $b
+    local.get $b
+    f32.convert_i64_u
+    local.tee $c
+    f64.promote_f32
+    local.set $d
+    local.get $d
+  )
+  (func $globals (export "test_globals") (result i32)
+    global.get $sp
+    i32.const 8
+    i32.sub
+    global.set $sp
+    global.get $sp
+  )
+)
@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+	"github.com/hajimehoshi/go2cpp/version"
+)
+
+const go2cppModulePath = "github.com/hajimehoshi/go2cpp"
+
+type producer struct {
+	Field   string
+	Name    string
+	Version string
+}
+
+// buildInfo represents the information about the build embedded into the generated code.
+type buildInfo struct {
+	GoVersion     string
+	GoBuildID     string
+	ModuleHash    uint64
+	Go2CppVersion string
+	BuildTime     time.Time
+	Producers     []producer
+}
+
+func readVarUint32(r *bytes.Reader) (uint32, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxUint32 {
+		return 0, fmt.Errorf("integer too large: %d", n)
+	}
+	return uint32(n), nil
+}
+
+func readName(r *bytes.Reader) (string, error) {
+	n, err := readVarUint32(r)
+	if err != nil {
+		return "", err
+	}
+	if int64(n) > int64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readProducers parses the content of the "producers" custom section.
+// See https://github.com/WebAssembly/tool-conventions/blob/master/ProducersSection.md.
+func readProducers(data []byte) ([]producer, error) {
+	r := bytes.NewReader(data)
+	fieldNum, err := readVarUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	var ps []producer
+	for i := uint32(0); i < fieldNum; i++ {
+		field, err := readName(r)
+		if err != nil {
+			return nil, err
+		}
+		valueNum, err := readVarUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		for j := uint32(0); j < valueNum; j++ {
+			name, err := readName(r)
+			if err != nil {
+				return nil, err
+			}
+			version, err := readName(r)
+			if err != nil {
+				return nil, err
+			}
+			ps = append(ps, producer{
+				Field:   field,
+				Name:    name,
+				Version: version,
+			})
+		}
+	}
+	return ps, nil
+}
+
+// go2cppVersion returns the module version of go2cpp that is running.
+func go2cppVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == go2cppModulePath {
+		return info.Main.Version
+	}
+	for _, m := range info.Deps {
+		if m.Path != go2cppModulePath {
+			continue
+		}
+		if m.Replace != nil {
+			return m.Replace.Version
+		}
+		return m.Version
+	}
+	return "unknown"
+}
+
+// buildTime returns the time given by SOURCE_DATE_EPOCH, or the zero time if SOURCE_DATE_EPOCH is not set.
+// The current time is not used so that the same input always generates the same code.
+func buildTime() (time.Time, error) {
+	s, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !ok {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %q", s)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+func newBuildInfo(mod *wasm.Module, moduleHash uint64) (*buildInfo, error) {
+	t, err := buildTime()
+	if err != nil {
+		return nil, err
+	}
+	info := &buildInfo{
+		ModuleHash:    moduleHash,
+		Go2CppVersion: go2cppVersion(),
+		BuildTime:     t,
+	}
+	if s := mod.Custom("go:buildid"); s != nil {
+		info.GoBuildID = string(s.Data)
+	}
+	if s := mod.Custom("producers"); s != nil {
+		ps, err := readProducers(s.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid producers section: %v", err)
+		}
+		info.Producers = ps
+		for _, p := range ps {
+			if p.Field == "language" && p.Name == "Go" {
+				info.GoVersion = p.Version
+			}
+		}
+	}
+	return info, nil
+}
+
+func writeVersion(dir string, incpath string, namespace string, info *buildInfo) error {
+	f, err := os.Create(filepath.Join(dir, "version.h"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var bt string
+	if !info.BuildTime.IsZero() {
+		bt = info.BuildTime.Format(time.RFC3339)
+	}
+	if err := versionHTmpl.Execute(f, struct {
+		IncludeGuard     string
+		Namespace        string
+		Info             *buildInfo
+		ModuleHash       string
+		BuildTime        string
+		GeneratorVersion string
+	}{
+		IncludeGuard:     includeGuard(namespace) + "_VERSION_H",
+		Namespace:        namespace,
+		Info:             info,
+		ModuleHash:       fmt.Sprintf("0x%016xull", info.ModuleHash),
+		BuildTime:        bt,
+		GeneratorVersion: version.String(),
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+var versionHTmpl = template.Must(template.New("version.h").Funcs(template.FuncMap{
+	"cppString": cppStringLiteral,
+}).Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include <cstdint>
+
+namespace {{.Namespace}} {
+
+// The Go version and the build ID are extracted from the custom sections of the WebAssembly module.
+// They are empty when the module does not have them.
+constexpr char kGoVersion[] = {{cppString .Info.GoVersion}};
+constexpr char kGoBuildID[] = {{cppString .Info.GoBuildID}};
+
+// kModuleHash is the FNV-1a hash of the WebAssembly module.
+constexpr uint64_t kModuleHash = {{.ModuleHash}};
+
+constexpr char kGo2CppVersion[] = {{cppString .Info.Go2CppVersion}};
+
+// kGeneratorVersion is the version of go2cpp in Semantic Versioning. config.h has the same version as a number.
+constexpr char kGeneratorVersion[] = {{cppString .GeneratorVersion}};
+
+// kBuildTime is the time given by SOURCE_DATE_EPOCH at the generation in RFC 3339, or empty if it was not given.
+constexpr char kBuildTime[] = {{cppString .BuildTime}};
+
+struct ProducerEntry {
+  const char* field;
+  const char* name;
+  const char* version;
+};
+
+// kProducers is the content of the "producers" custom section, terminated by an entry with null pointers.
+constexpr ProducerEntry kProducers[] = {
+{{range .Info.Producers}}  { {{- cppString .Field}}, {{cppString .Name}}, {{cppString .Version}}},
+{{end}}  {nullptr, nullptr, nullptr},
+};
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
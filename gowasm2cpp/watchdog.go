@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package gowasm2cpp
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// writeWatchdog writes Watchdog and FunctionMarkers. funcs are the translated functions including the imports, and
+// numFuncs is the number of all the functions including the pruned ones.
+func writeWatchdog(dir string, incpath string, namespace string, exportMacro string, funcs []*wasmFunc, numFuncs int) error {
+	names := make([]string, numFuncs)
+	for _, f := range funcs {
+		names[f.Index] = cppStringLiteral(f.Wasm.Name)
+	}
+	for i, n := range names {
+		if n == "" {
+			names[i] = `""`
+		}
+	}
+
+	{
+		f, err := os.Create(filepath.Join(dir, "watchdog.h"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := watchdogHTmpl.Execute(f, struct {
+			IncludeGuard string
+			VersionCheck string
+			Namespace    string
+			IncludePath  string
+			Export       string
+		}{
+			IncludeGuard: includeGuard(namespace) + "_WATCHDOG_H",
+			VersionCheck: versionCheck(namespace, "watchdog.h"),
+			Namespace:    namespace,
+			IncludePath:  incpath,
+			Export:       exportPrefix(exportMacro),
+		}); err != nil {
+			return err
+		}
+	}
+	{
+		f, err := os.Create(filepath.Join(dir, "watchdog.cpp"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := watchdogCppTmpl.Execute(f, struct {
+			IncludePath string
+			Namespace   string
+			Names       []string
+		}{
+			IncludePath: incpath,
+			Namespace:   namespace,
+			Names:       names,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var watchdogHTmpl = template.Must(template.New("watchdog.h").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#ifndef {{.IncludeGuard}}
+#define {{.IncludeGuard}}
+
+#include "{{.IncludePath}}config.h"
+
+#include <atomic>
+#include <chrono>
+#include <condition_variable>
+#include <cstdint>
+#include <functional>
+#include <mutex>
+#include <string>
+#include <thread>
+#include <vector>
+
+{{.VersionCheck}}
+
+// GO2CPP_FUNCTION_MARKER is put at the beginning of every translated function. The markers record the function being
+// executed for Watchdog, and are enabled when NDEBUG is not defined or GO2CPP_FUNCTION_MARKERS is defined.
+#if !defined(NDEBUG) || defined(GO2CPP_FUNCTION_MARKERS)
+#  define GO2CPP_FUNCTION_MARKER(index) FunctionMarkers::Scope go2cpp_function_marker_{index}
+#else
+#  define GO2CPP_FUNCTION_MARKER(index)
+#endif
+
+namespace {{.Namespace}} {
+
+// FunctionMarkers is the stack of the indices of the translated functions being executed. The stack is shared by all
+// the threads, so this is meaningful only when one thread runs the Go program.
+class {{.Export}}FunctionMarkers {
+public:
+  // kMaxDepth is the maximum depth recorded. The deeper functions are counted but not recorded.
+  static constexpr int32_t kMaxDepth = 1024;
+
+  // Scope pushes the index of a function while the function is executed.
+  class Scope {
+  public:
+    explicit Scope(int32_t index) {
+      int32_t depth = depth_.load(std::memory_order_relaxed);
+      if (depth < kMaxDepth) {
+        stack_[depth].store(index, std::memory_order_relaxed);
+      }
+      depth_.store(depth + 1, std::memory_order_relaxed);
+    }
+
+    ~Scope() {
+      depth_.fetch_sub(1, std::memory_order_relaxed);
+    }
+
+    Scope(const Scope&) = delete;
+    Scope& operator=(const Scope&) = delete;
+  };
+
+  // GetStack returns the indices from the outermost function, and sets the depth including the functions not recorded
+  // to *depth if depth is not nullptr. GetStack is concurrent-safe, but the result might be inconsistent when the stack
+  // changes during the call.
+  static std::vector<int32_t> GetStack(int32_t* depth);
+
+  // GetName returns the name of the function at index, or an empty string for an invalid index.
+  static const char* GetName(int32_t index);
+
+private:
+  static std::atomic<int32_t> depth_;
+  static std::atomic<int32_t> stack_[kMaxDepth];
+};
+
+// Watchdog reports the functions being executed when an update doesn't end within the timeout, e.g., to diagnose an
+// infinite loop on a device. A thread checks the update in the background.
+class {{.Export}}Watchdog {
+public:
+  // Report is the state of the frozen update.
+  struct Report {
+    // elapsed is the time since the update began.
+    std::chrono::milliseconds elapsed{0};
+
+    // depth is the depth of the function calls, which might be larger than functions.
+    int32_t depth = 0;
+
+    // functions are the indices of the functions being executed from the outermost one. Get their names with
+    // FunctionMarkers::GetName. These are empty when the function markers are disabled.
+    std::vector<int32_t> functions;
+  };
+
+  // on_frozen is called in the watchdog's thread once per frozen update. If on_frozen is nullptr, the report is logged.
+  Watchdog(std::chrono::milliseconds timeout, std::function<void(const Report& report)> on_frozen);
+  ~Watchdog();
+
+  Watchdog(const Watchdog&) = delete;
+  Watchdog& operator=(const Watchdog&) = delete;
+
+  // Begin and End enclose an update. Begin and End are concurrent-safe.
+  void Begin();
+  void End();
+
+private:
+  void Loop();
+
+  const std::chrono::milliseconds timeout_;
+  std::function<void(const Report& report)> on_frozen_;
+
+  std::mutex mutex_;
+  std::condition_variable cond_;
+  bool running_ = false;
+  bool reported_ = false;
+  bool stopped_ = false;
+  uint64_t generation_ = 0;
+  std::chrono::steady_clock::time_point begin_;
+  std::thread thread_;
+};
+
+}
+
+#endif  // {{.IncludeGuard}}
+`))
+
+var watchdogCppTmpl = template.Must(template.New("watchdog.cpp").Parse(`// Code generated by go2cpp. DO NOT EDIT.
+
+#include "{{.IncludePath}}watchdog.h"
+
+#include "{{.IncludePath}}log.h"
+
+#include <algorithm>
+#include <utility>
+
+namespace {{.Namespace}} {
+
+namespace {
+
+const char* const kFunctionNames[] = {
+{{range .Names}}  {{.}},
+{{end}}};
+
+}
+
+constexpr int32_t FunctionMarkers::kMaxDepth;
+
+std::atomic<int32_t> FunctionMarkers::depth_{0};
+
+std::atomic<int32_t> FunctionMarkers::stack_[FunctionMarkers::kMaxDepth];
+
+std::vector<int32_t> FunctionMarkers::GetStack(int32_t* depth) {
+  int32_t d = depth_.load(std::memory_order_relaxed);
+  if (depth) {
+    *depth = d;
+  }
+  std::vector<int32_t> stack(std::max(0, std::min(d, kMaxDepth)));
+  for (size_t i = 0; i < stack.size(); i++) {
+    stack[i] = stack_[i].load(std::memory_order_relaxed);
+  }
+  return stack;
+}
+
+const char* FunctionMarkers::GetName(int32_t index) {
+  if (index < 0 || static_cast<size_t>(index) >= sizeof(kFunctionNames) / sizeof(kFunctionNames[0])) {
+    return "";
+  }
+  return kFunctionNames[index];
+}
+
+Watchdog::Watchdog(std::chrono::milliseconds timeout, std::function<void(const Report& report)> on_frozen)
+    : timeout_{timeout},
+      on_frozen_{std::move(on_frozen)},
+      thread_{[this] { Loop(); }} {
+}
+
+Watchdog::~Watchdog() {
+  {
+    std::lock_guard<std::mutex> lock{mutex_};
+    stopped_ = true;
+  }
+  cond_.notify_one();
+  thread_.join();
+}
+
+void Watchdog::Begin() {
+  {
+    std::lock_guard<std::mutex> lock{mutex_};
+    running_ = true;
+    reported_ = false;
+    generation_++;
+    begin_ = std::chrono::steady_clock::now();
+  }
+  cond_.notify_one();
+}
+
+void Watchdog::End() {
+  std::lock_guard<std::mutex> lock{mutex_};
+  running_ = false;
+}
+
+void Watchdog::Loop() {
+  std::unique_lock<std::mutex> lock{mutex_};
+  while (!stopped_) {
+    if (!running_ || reported_) {
+      cond_.wait(lock);
+      continue;
+    }
+    uint64_t generation = generation_;
+    std::chrono::steady_clock::time_point deadline = begin_ + timeout_;
+    if (cond_.wait_until(lock, deadline, [this, generation] {
+      return stopped_ || !running_ || generation_ != generation;
+    })) {
+      continue;
+    }
+
+    reported_ = true;
+    Report report;
+    report.elapsed = std::chrono::duration_cast<std::chrono::milliseconds>(std::chrono::steady_clock::now() - begin_);
+    report.functions = FunctionMarkers::GetStack(&report.depth);
+
+    // Don't block Begin and End during the callback.
+    lock.unlock();
+    if (on_frozen_) {
+      on_frozen_(report);
+    } else {
+      std::string msg = "watchdog: the update has not ended for " + std::to_string(report.elapsed.count()) + " ms";
+      for (auto it = report.functions.rbegin(); it != report.functions.rend(); ++it) {
+        msg += "\n  " + std::string{FunctionMarkers::GetName(*it)} + " (" + std::to_string(*it) + ")";
+      }
+      Log(LogLevel::kWarning, msg);
+    }
+    lock.lock();
+  }
+}
+
+}
+`))
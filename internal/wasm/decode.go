@@ -0,0 +1,19 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wasm
+
+// Decoder decodes WebAssembly binaries.
+type Decoder interface {
+	// Decode decodes the binary data into a module.
+	Decode(data []byte) (*Module, error)
+}
+
+// Decode decodes the binary data into a module with the default decoder.
+func Decode(data []byte) (*Module, error) {
+	return defaultDecoder.Decode(data)
+}
+
+// NativeDecoder returns the decoder implemented in this package.
+func NativeDecoder() Decoder {
+	return nativeDecoder{}
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wasm_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+// testModule has one function of type (i32) -> i32:
+//
+//	block
+//	  local.get 0
+//	  br_if 0
+//	  i32.const -1
+//	  return
+//	  i32.const 1    ;; unreachable
+//	  block          ;; unreachable
+//	  end            ;; unreachable
+//	end
+//	i32.const 300
+var testModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// type
+	0x01, 0x06, 0x01, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+	// function
+	0x03, 0x02, 0x01, 0x00,
+	// memory
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	// export "f"
+	0x07, 0x05, 0x01, 0x01, 0x66, 0x00, 0x00,
+	// code
+	0x0a, 0x16, 0x01, 0x14, 0x00,
+	0x02, 0x40,
+	0x20, 0x00,
+	0x0d, 0x00,
+	0x41, 0x7f,
+	0x0f,
+	0x41, 0x01,
+	0x02, 0x40,
+	0x0b,
+	0x0b,
+	0x41, 0xac, 0x02,
+	0x0b,
+}
+
+func TestDecode(t *testing.T) {
+	for _, d := range []struct {
+		name    string
+		decoder Decoder
+	}{
+		{"default", decoderFunc(Decode)},
+		{"native", NativeDecoder()},
+	} {
+		t.Run(d.name, func(t *testing.T) {
+			m, err := d.decoder.Decode(testModule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(m.Codes), 1; got != want {
+				t.Fatalf("len(m.Codes): got: %d, want: %d", got, want)
+			}
+			if got, want := m.Exports, []Export{{FieldStr: "f", Kind: ExternalFunction, Index: 0}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("m.Exports: got: %v, want: %v", got, want)
+			}
+			if got, want := m.Memories[0].Limits.Initial, uint32(1); got != want {
+				t.Errorf("memory pages: got: %d, want: %d", got, want)
+			}
+
+			got, err := m.Codes[0].Instrs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []Instr{
+				{Op: OpBlock, Immediates: []interface{}{BlockTypeEmpty}},
+				{Op: OpGetLocal, Immediates: []interface{}{uint32(0)}},
+				{Op: OpBrIf, Immediates: []interface{}{uint32(0)}},
+				{Op: OpI32Const, Immediates: []interface{}{int32(-1)}},
+				{Op: OpReturn},
+				{Op: OpEnd},
+				{Op: OpI32Const, Immediates: []interface{}{int32(300)}},
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got: %v, want: %v", got, want)
+			}
+			for i := range got {
+				if got[i].Op != want[i].Op || len(got[i].Immediates) != len(want[i].Immediates) {
+					t.Errorf("instruction %d: got: %v, want: %v", i, got[i], want[i])
+					continue
+				}
+				for j := range got[i].Immediates {
+					if got[i].Immediates[j] != want[i].Immediates[j] {
+						t.Errorf("instruction %d: got: %v, want: %v", i, got[i], want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	// The module is invalid when it is truncated after the function section's ID, as the function body is missing.
+	const functionSection = 16
+	for i := functionSection + 1; i < len(testModule); i++ {
+		if _, err := NativeDecoder().Decode(testModule[:i]); err == nil {
+			t.Errorf("truncated at %d: error expected", i)
+		}
+	}
+}
+
+type decoderFunc func([]byte) (*Module, error)
+
+func (d decoderFunc) Decode(data []byte) (*Module, error) {
+	return d(data)
+}
@@ -0,0 +1,7 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// +build !wagon
+
+package wasm
+
+var defaultDecoder Decoder = nativeDecoder{}
@@ -0,0 +1,258 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package wasm provides the representation of WebAssembly modules that the generator uses, and the decoders of
+// WebAssembly binaries.
+//
+// The default decoder is implemented in this package. The decoder based on github.com/go-interpreter/wagon is used
+// instead with the build tag "wagon".
+package wasm
+
+import (
+	"fmt"
+	"math"
+)
+
+// ValueType is a type of values.
+type ValueType byte
+
+const (
+	ValueTypeI32 ValueType = 0x7f
+	ValueTypeI64 ValueType = 0x7e
+	ValueTypeF32 ValueType = 0x7d
+	ValueTypeF64 ValueType = 0x7c
+)
+
+func (v ValueType) String() string {
+	switch v {
+	case ValueTypeI32:
+		return "i32"
+	case ValueTypeI64:
+		return "i64"
+	case ValueTypeF32:
+		return "f32"
+	case ValueTypeF64:
+		return "f64"
+	}
+	return fmt.Sprintf("0x%02x", byte(v))
+}
+
+// BlockType is a result type of a block, a loop or an if instruction.
+type BlockType byte
+
+// BlockTypeEmpty represents a block without results.
+const BlockTypeEmpty BlockType = 0x40
+
+// ExternalKind is a kind of imports and exports.
+type ExternalKind byte
+
+const (
+	ExternalFunction ExternalKind = 0x00
+	ExternalTable    ExternalKind = 0x01
+	ExternalMemory   ExternalKind = 0x02
+	ExternalGlobal   ExternalKind = 0x03
+)
+
+// FunctionSig is a function type.
+type FunctionSig struct {
+	ParamTypes  []ValueType
+	ReturnTypes []ValueType
+}
+
+// Limits is limits of a table or a memory.
+type Limits struct {
+	Initial uint32
+
+	// Maximum is valid only when HasMaximum is true.
+	Maximum    uint32
+	HasMaximum bool
+}
+
+// Import is an import entry.
+type Import struct {
+	ModuleName string
+	FieldName  string
+	Kind       ExternalKind
+
+	// Type is the type index of the function. This is valid only for functions.
+	Type uint32
+}
+
+// Table is a table. Only funcref is supported as the element type.
+type Table struct {
+	Limits Limits
+}
+
+// Memory is a linear memory.
+type Memory struct {
+	Limits Limits
+}
+
+// Global is a global variable.
+type Global struct {
+	Type    ValueType
+	Mutable bool
+
+	// Init is the initializer expression without the last end instruction.
+	Init []byte
+}
+
+// Export is an export entry.
+type Export struct {
+	FieldStr string
+	Kind     ExternalKind
+	Index    uint32
+}
+
+// Element is an element segment.
+type Element struct {
+	Index uint32
+
+	// Offset is the offset expression without the last end instruction.
+	Offset []byte
+
+	Elems []uint32
+}
+
+// Data is a data segment.
+type Data struct {
+	Index uint32
+
+	// Offset is the offset expression without the last end instruction.
+	Offset []byte
+
+	Data []byte
+}
+
+// Local is a run of local variables of the same type.
+type Local struct {
+	Count uint32
+	Type  ValueType
+}
+
+// FunctionBody is a body of a function.
+type FunctionBody struct {
+	Locals []Local
+
+	// Code is the instructions without the last end instruction.
+	Code []byte
+
+	disassemble func() ([]Instr, error)
+}
+
+// Instrs returns the instructions of the body. The unreachable instructions, such as those after br in the same
+// block, are not included.
+func (f *FunctionBody) Instrs() ([]Instr, error) {
+	return f.disassemble()
+}
+
+// Instr is an instruction.
+type Instr struct {
+	Op Opcode
+
+	// Immediates are the immediate arguments. The types are:
+	//
+	//   - BlockType for block, loop and if
+	//   - uint32 for indices, alignments and offsets
+	//   - uint32 for the number of the targets of br_table, followed by the targets and the default target
+	//   - uint8 for the reserved value of memory.size and memory.grow
+	//   - int32, int64, float32 and float64 for constants
+	Immediates []interface{}
+}
+
+// Function is a function with its signature.
+type Function struct {
+	Sig  *FunctionSig
+	Body *FunctionBody
+	Name string
+}
+
+// Custom is a custom section.
+type Custom struct {
+	Name string
+	Data []byte
+}
+
+// Module is a WebAssembly module.
+type Module struct {
+	Types   []FunctionSig
+	Imports []Import
+
+	// Functions are the type indices of the functions defined in the module.
+	Functions []uint32
+
+	Tables   []Table
+	Memories []Memory
+	Globals  []Global
+	Exports  []Export
+	Start    *uint32
+	Elements []Element
+	Codes    []FunctionBody
+	Data     []Data
+	Customs  []Custom
+
+	// FunctionNames is the function names in the "name" custom section, indexed by the function indices.
+	FunctionNames map[uint32]string
+}
+
+// Custom returns the first custom section with the given name, or nil if there is no such section.
+func (m *Module) Custom(name string) *Custom {
+	for i := range m.Customs {
+		if m.Customs[i].Name == name {
+			return &m.Customs[i]
+		}
+	}
+	return nil
+}
+
+// ExecInitExpr evaluates a constant expression like an offset of a data segment.
+// The result is int32, int64, float32 or float64.
+func (m *Module) ExecInitExpr(expr []byte) (interface{}, error) {
+	return m.execInitExpr(expr, true)
+}
+
+func (m *Module) execInitExpr(expr []byte, allowGlobal bool) (interface{}, error) {
+	r := &reader{buf: expr}
+	op, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	switch Opcode(op) {
+	case OpI32Const:
+		v, err = r.varint32()
+	case OpI64Const:
+		v, err = r.varint64()
+	case OpF32Const:
+		var b uint32
+		b, err = r.uint32()
+		v = math.Float32frombits(b)
+	case OpF64Const:
+		var b uint64
+		b, err = r.uint64()
+		v = math.Float64frombits(b)
+	case OpGetGlobal:
+		// Imported globals are not supported. A global's initializer must not refer to another global.
+		if !allowGlobal {
+			return nil, fmt.Errorf("wasm: global.get in a global's initializer is not supported")
+		}
+		var idx uint32
+		idx, err = r.varuint32()
+		if err != nil {
+			break
+		}
+		if int(idx) >= len(m.Globals) {
+			return nil, fmt.Errorf("wasm: global index out of range: %d", idx)
+		}
+		return m.execInitExpr(m.Globals[idx].Init, false)
+	default:
+		return nil, fmt.Errorf("wasm: invalid instruction in a constant expression: %v", Opcode(op))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !r.eof() {
+		return nil, fmt.Errorf("wasm: a constant expression must have only one instruction")
+	}
+	return v, nil
+}
@@ -0,0 +1,694 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+const (
+	sectionCustom    = 0
+	sectionType      = 1
+	sectionImport    = 2
+	sectionFunction  = 3
+	sectionTable     = 4
+	sectionMemory    = 5
+	sectionGlobal    = 6
+	sectionExport    = 7
+	sectionStart     = 8
+	sectionElement   = 9
+	sectionCode      = 10
+	sectionData      = 11
+	sectionDataCount = 12
+)
+
+// nativeDecoder is the decoder implemented in this package.
+type nativeDecoder struct{}
+
+func (nativeDecoder) Decode(data []byte) (*Module, error) {
+	if !bytes.HasPrefix(data, []byte("\x00asm")) {
+		return nil, fmt.Errorf("wasm: invalid magic number")
+	}
+	if len(data) < 8 || !bytes.Equal(data[4:8], []byte{1, 0, 0, 0}) {
+		return nil, fmt.Errorf("wasm: unknown binary version")
+	}
+
+	m := &Module{}
+	r := &reader{buf: data, pos: 8}
+	var lastID byte
+	for !r.eof() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.varuint32()
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+
+		if id != sectionCustom {
+			// The data count section is between the element section and the code section.
+			order := id
+			if id == sectionDataCount {
+				order = sectionElement
+			}
+			if order < lastID || (order == lastID && id != sectionDataCount) {
+				return nil, fmt.Errorf("wasm: section %d is out of order", id)
+			}
+			lastID = order
+		}
+
+		sr := &reader{buf: content}
+		if err := m.readSection(id, sr); err != nil {
+			return nil, fmt.Errorf("wasm: section %d: %v", id, err)
+		}
+		if !sr.eof() {
+			return nil, fmt.Errorf("wasm: section %d: section size mismatch", id)
+		}
+	}
+
+	if len(m.Functions) != len(m.Codes) {
+		return nil, fmt.Errorf("wasm: the numbers of functions and function bodies don't match: %d vs %d", len(m.Functions), len(m.Codes))
+	}
+	if c := m.Custom("name"); c != nil {
+		names, err := readFunctionNames(c.Data)
+		if err != nil {
+			return nil, fmt.Errorf("wasm: name section: %v", err)
+		}
+		m.FunctionNames = names
+	}
+	for i := range m.Codes {
+		c := &m.Codes[i]
+		c.disassemble = func() ([]Instr, error) {
+			return disassemble(c.Code)
+		}
+	}
+	return m, nil
+}
+
+func readVec(r *reader, f func() error) error {
+	n, err := r.varuint32()
+	if err != nil {
+		return err
+	}
+	// Each element has at least one byte.
+	if uint64(n) > uint64(len(r.buf)-r.pos) {
+		return fmt.Errorf("too many elements: %d", n)
+	}
+	for i := uint32(0); i < n; i++ {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readValueType(r *reader) (ValueType, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch v := ValueType(b); v {
+	case ValueTypeI32, ValueTypeI64, ValueTypeF32, ValueTypeF64:
+		return v, nil
+	}
+	return 0, fmt.Errorf("invalid value type: 0x%02x", b)
+}
+
+func readLimits(r *reader) (Limits, error) {
+	flags, err := r.byte()
+	if err != nil {
+		return Limits{}, err
+	}
+	if flags > 1 {
+		return Limits{}, fmt.Errorf("invalid limits flags: 0x%02x", flags)
+	}
+	var l Limits
+	l.Initial, err = r.varuint32()
+	if err != nil {
+		return Limits{}, err
+	}
+	if flags == 1 {
+		l.HasMaximum = true
+		l.Maximum, err = r.varuint32()
+		if err != nil {
+			return Limits{}, err
+		}
+	}
+	return l, nil
+}
+
+func readTable(r *reader) (Table, error) {
+	t, err := r.byte()
+	if err != nil {
+		return Table{}, err
+	}
+	if t != 0x70 {
+		return Table{}, fmt.Errorf("invalid element type: 0x%02x", t)
+	}
+	l, err := readLimits(r)
+	if err != nil {
+		return Table{}, err
+	}
+	return Table{Limits: l}, nil
+}
+
+func readGlobalType(r *reader) (ValueType, bool, error) {
+	t, err := readValueType(r)
+	if err != nil {
+		return 0, false, err
+	}
+	mut, err := r.byte()
+	if err != nil {
+		return 0, false, err
+	}
+	if mut > 1 {
+		return 0, false, fmt.Errorf("invalid mutability: 0x%02x", mut)
+	}
+	return t, mut == 1, nil
+}
+
+// readInitExpr reads a constant expression and returns it without the last end instruction.
+func readInitExpr(r *reader) ([]byte, error) {
+	start := r.pos
+	op, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch Opcode(op) {
+	case OpI32Const:
+		_, err = r.varint32()
+	case OpI64Const:
+		_, err = r.varint64()
+	case OpF32Const:
+		_, err = r.uint32()
+	case OpF64Const:
+		_, err = r.uint64()
+	case OpGetGlobal:
+		_, err = r.varuint32()
+	default:
+		return nil, fmt.Errorf("invalid instruction in a constant expression: %v", Opcode(op))
+	}
+	if err != nil {
+		return nil, err
+	}
+	expr := r.buf[start:r.pos]
+	end, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	if Opcode(end) != OpEnd {
+		return nil, fmt.Errorf("a constant expression must have only one instruction")
+	}
+	return expr, nil
+}
+
+func (m *Module) readSection(id byte, r *reader) error {
+	switch id {
+	case sectionCustom:
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		m.Customs = append(m.Customs, Custom{
+			Name: name,
+			Data: r.buf[r.pos:],
+		})
+		r.pos = len(r.buf)
+		return nil
+
+	case sectionType:
+		return readVec(r, func() error {
+			form, err := r.byte()
+			if err != nil {
+				return err
+			}
+			if form != 0x60 {
+				return fmt.Errorf("invalid function type form: 0x%02x", form)
+			}
+			var sig FunctionSig
+			if err := readVec(r, func() error {
+				t, err := readValueType(r)
+				if err != nil {
+					return err
+				}
+				sig.ParamTypes = append(sig.ParamTypes, t)
+				return nil
+			}); err != nil {
+				return err
+			}
+			if err := readVec(r, func() error {
+				t, err := readValueType(r)
+				if err != nil {
+					return err
+				}
+				sig.ReturnTypes = append(sig.ReturnTypes, t)
+				return nil
+			}); err != nil {
+				return err
+			}
+			m.Types = append(m.Types, sig)
+			return nil
+		})
+
+	case sectionImport:
+		return readVec(r, func() error {
+			var imp Import
+			var err error
+			imp.ModuleName, err = r.name()
+			if err != nil {
+				return err
+			}
+			imp.FieldName, err = r.name()
+			if err != nil {
+				return err
+			}
+			kind, err := r.byte()
+			if err != nil {
+				return err
+			}
+			imp.Kind = ExternalKind(kind)
+			switch imp.Kind {
+			case ExternalFunction:
+				imp.Type, err = r.varuint32()
+			case ExternalTable:
+				_, err = readTable(r)
+			case ExternalMemory:
+				_, err = readLimits(r)
+			case ExternalGlobal:
+				_, _, err = readGlobalType(r)
+			default:
+				return fmt.Errorf("invalid import kind: 0x%02x", kind)
+			}
+			if err != nil {
+				return err
+			}
+			m.Imports = append(m.Imports, imp)
+			return nil
+		})
+
+	case sectionFunction:
+		return readVec(r, func() error {
+			t, err := r.varuint32()
+			if err != nil {
+				return err
+			}
+			m.Functions = append(m.Functions, t)
+			return nil
+		})
+
+	case sectionTable:
+		return readVec(r, func() error {
+			t, err := readTable(r)
+			if err != nil {
+				return err
+			}
+			m.Tables = append(m.Tables, t)
+			return nil
+		})
+
+	case sectionMemory:
+		return readVec(r, func() error {
+			l, err := readLimits(r)
+			if err != nil {
+				return err
+			}
+			m.Memories = append(m.Memories, Memory{Limits: l})
+			return nil
+		})
+
+	case sectionGlobal:
+		return readVec(r, func() error {
+			t, mut, err := readGlobalType(r)
+			if err != nil {
+				return err
+			}
+			init, err := readInitExpr(r)
+			if err != nil {
+				return err
+			}
+			m.Globals = append(m.Globals, Global{
+				Type:    t,
+				Mutable: mut,
+				Init:    init,
+			})
+			return nil
+		})
+
+	case sectionExport:
+		return readVec(r, func() error {
+			name, err := r.name()
+			if err != nil {
+				return err
+			}
+			kind, err := r.byte()
+			if err != nil {
+				return err
+			}
+			if kind > byte(ExternalGlobal) {
+				return fmt.Errorf("invalid export kind: 0x%02x", kind)
+			}
+			idx, err := r.varuint32()
+			if err != nil {
+				return err
+			}
+			m.Exports = append(m.Exports, Export{
+				FieldStr: name,
+				Kind:     ExternalKind(kind),
+				Index:    idx,
+			})
+			return nil
+		})
+
+	case sectionStart:
+		idx, err := r.varuint32()
+		if err != nil {
+			return err
+		}
+		m.Start = &idx
+		return nil
+
+	case sectionElement:
+		return readVec(r, func() error {
+			table, err := r.varuint32()
+			if err != nil {
+				return err
+			}
+			if table != 0 {
+				return fmt.Errorf("element segment kind %d is not supported", table)
+			}
+			offset, err := readInitExpr(r)
+			if err != nil {
+				return err
+			}
+			e := Element{
+				Index:  table,
+				Offset: offset,
+			}
+			if err := readVec(r, func() error {
+				idx, err := r.varuint32()
+				if err != nil {
+					return err
+				}
+				e.Elems = append(e.Elems, idx)
+				return nil
+			}); err != nil {
+				return err
+			}
+			m.Elements = append(m.Elements, e)
+			return nil
+		})
+
+	case sectionCode:
+		return readVec(r, func() error {
+			size, err := r.varuint32()
+			if err != nil {
+				return err
+			}
+			body, err := r.bytes(size)
+			if err != nil {
+				return err
+			}
+			br := &reader{buf: body}
+			var f FunctionBody
+			if err := readVec(br, func() error {
+				n, err := br.varuint32()
+				if err != nil {
+					return err
+				}
+				t, err := readValueType(br)
+				if err != nil {
+					return err
+				}
+				f.Locals = append(f.Locals, Local{
+					Count: n,
+					Type:  t,
+				})
+				return nil
+			}); err != nil {
+				return err
+			}
+			code := br.buf[br.pos:]
+			if len(code) == 0 || Opcode(code[len(code)-1]) != OpEnd {
+				return fmt.Errorf("function body must end with end")
+			}
+			f.Code = code[:len(code)-1]
+			m.Codes = append(m.Codes, f)
+			return nil
+		})
+
+	case sectionData:
+		return readVec(r, func() error {
+			mem, err := r.varuint32()
+			if err != nil {
+				return err
+			}
+			if mem != 0 {
+				return fmt.Errorf("data segment kind %d is not supported", mem)
+			}
+			offset, err := readInitExpr(r)
+			if err != nil {
+				return err
+			}
+			n, err := r.varuint32()
+			if err != nil {
+				return err
+			}
+			data, err := r.bytes(n)
+			if err != nil {
+				return err
+			}
+			m.Data = append(m.Data, Data{
+				Index:  mem,
+				Offset: offset,
+				Data:   data,
+			})
+			return nil
+		})
+
+	case sectionDataCount:
+		_, err := r.varuint32()
+		return err
+	}
+	return fmt.Errorf("unknown section")
+}
+
+// readFunctionNames reads the function names in the content of the "name" custom section.
+func readFunctionNames(data []byte) (map[uint32]string, error) {
+	const nameFunction = 1
+
+	r := &reader{buf: data}
+	for !r.eof() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.varuint32()
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+		if id != nameFunction {
+			continue
+		}
+
+		names := map[uint32]string{}
+		sr := &reader{buf: content}
+		if err := readVec(sr, func() error {
+			idx, err := sr.varuint32()
+			if err != nil {
+				return err
+			}
+			name, err := sr.name()
+			if err != nil {
+				return err
+			}
+			names[idx] = name
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		return names, nil
+	}
+	return nil, nil
+}
+
+// disassemble decodes the instructions in code.
+//
+// The unreachable instructions are removed in the same way as wagon's disasm.NewDisassembly: the rest of a block
+// after unreachable, br, br_table or return is removed, and so are the blocks in it.
+func disassemble(code []byte) ([]Instr, error) {
+	r := &reader{buf: code}
+
+	var instrs []Instr
+	// blockNum is the number of the blocks that are open, including the unreachable blocks.
+	var blockNum int
+	// polymorphic is a stack of the reachable blocks. Each element reports whether the rest of the block is
+	// unreachable. The first element is for the function body.
+	polymorphic := []bool{false}
+
+	for !r.eof() {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		op := Opcode(b)
+		if _, ok := opcodeNames[op]; !ok {
+			return nil, fmt.Errorf("wasm: invalid opcode: %v", op)
+		}
+		instr := Instr{
+			Op: op,
+		}
+		if err := readImmediates(r, &instr); err != nil {
+			return nil, fmt.Errorf("wasm: %v: %v", op, err)
+		}
+
+		var unreachable bool
+		switch op {
+		case OpEnd, OpElse:
+			if blockNum == 0 {
+				return nil, fmt.Errorf("wasm: unexpected %v", op)
+			}
+			// end and else are unreachable when the block is unreachable.
+			unreachable = blockNum != len(polymorphic)-1
+			if op == OpEnd {
+				blockNum--
+			}
+		case OpBlock, OpLoop, OpIf:
+			unreachable = polymorphic[len(polymorphic)-1]
+			blockNum++
+		default:
+			unreachable = polymorphic[len(polymorphic)-1]
+		}
+		if unreachable {
+			continue
+		}
+
+		switch op {
+		case OpUnreachable, OpBr, OpBrTable, OpReturn:
+			polymorphic[len(polymorphic)-1] = true
+		case OpEnd:
+			polymorphic = polymorphic[:len(polymorphic)-1]
+		case OpElse:
+			polymorphic[len(polymorphic)-1] = false
+		case OpBlock, OpLoop, OpIf:
+			polymorphic = append(polymorphic, false)
+		}
+		instrs = append(instrs, instr)
+	}
+	if blockNum != 0 {
+		return nil, fmt.Errorf("wasm: unterminated block")
+	}
+	return instrs, nil
+}
+
+func readImmediates(r *reader, instr *Instr) error {
+	switch instr.Op {
+	case OpBlock, OpLoop, OpIf:
+		t, err := r.byte()
+		if err != nil {
+			return err
+		}
+		instr.Immediates = append(instr.Immediates, BlockType(t))
+
+	case OpBr, OpBrIf, OpCall, OpGetLocal, OpSetLocal, OpTeeLocal, OpGetGlobal, OpSetGlobal:
+		idx, err := r.varuint32()
+		if err != nil {
+			return err
+		}
+		instr.Immediates = append(instr.Immediates, idx)
+
+	case OpBrTable:
+		n, err := r.varuint32()
+		if err != nil {
+			return err
+		}
+		if uint64(n) > uint64(len(r.buf)-r.pos) {
+			return fmt.Errorf("too many targets: %d", n)
+		}
+		instr.Immediates = append(instr.Immediates, n)
+		// The targets and the default target.
+		for i := uint32(0); i <= n; i++ {
+			t, err := r.varuint32()
+			if err != nil {
+				return err
+			}
+			instr.Immediates = append(instr.Immediates, t)
+		}
+
+	case OpCallIndirect:
+		idx, err := r.varuint32()
+		if err != nil {
+			return err
+		}
+		table, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if table != 0 {
+			return fmt.Errorf("table index must be 0")
+		}
+		instr.Immediates = append(instr.Immediates, idx, uint32(table))
+
+	case OpCurrentMemory, OpGrowMemory:
+		mem, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if mem != 0 {
+			return fmt.Errorf("memory index must be 0")
+		}
+		instr.Immediates = append(instr.Immediates, uint8(mem))
+
+	case OpI32Const:
+		v, err := r.varint32()
+		if err != nil {
+			return err
+		}
+		instr.Immediates = append(instr.Immediates, v)
+
+	case OpI64Const:
+		v, err := r.varint64()
+		if err != nil {
+			return err
+		}
+		instr.Immediates = append(instr.Immediates, v)
+
+	case OpF32Const:
+		v, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		instr.Immediates = append(instr.Immediates, math.Float32frombits(v))
+
+	case OpF64Const:
+		v, err := r.uint64()
+		if err != nil {
+			return err
+		}
+		instr.Immediates = append(instr.Immediates, math.Float64frombits(v))
+
+	default:
+		if OpI32Load <= instr.Op && instr.Op <= OpI64Store32 {
+			align, err := r.varuint32()
+			if err != nil {
+				return err
+			}
+			offset, err := r.varuint32()
+			if err != nil {
+				return err
+			}
+			instr.Immediates = append(instr.Immediates, align, offset)
+		}
+	}
+	return nil
+}
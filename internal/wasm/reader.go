@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errOverflow = errors.New("wasm: integer overflow")
+
+// reader reads the primitive values of the binary format.
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) eof() bool {
+	return r.pos >= len(r.buf)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n uint32) ([]byte, error) {
+	if uint64(n) > uint64(len(r.buf)-r.pos) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+func (r *reader) uint32() (uint32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *reader) uint64() (uint64, error) {
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// varuint reads an unsigned LEB128 integer with at most bits bits.
+func (r *reader) varuint(bits uint) (uint64, error) {
+	var v uint64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits || (bits-shift < 7 && uint64(b&0x7f)>>(bits-shift) != 0) {
+			return 0, errOverflow
+		}
+		v |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+}
+
+// varint reads a signed LEB128 integer with at most bits bits.
+func (r *reader) varint(bits uint) (int64, error) {
+	var v int64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits {
+			return 0, errOverflow
+		}
+		v |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 != 0 {
+			continue
+		}
+		if shift > bits {
+			// The unused bits of the last byte must be the sign extension.
+			used := bits - (shift - 7)
+			if s := (b & 0x7f) >> (used - 1); s != 0 && s != 0x7f>>(used-1) {
+				return 0, errOverflow
+			}
+		}
+		if b&0x40 != 0 && shift < 64 {
+			v |= -1 << shift
+		}
+		return v, nil
+	}
+}
+
+func (r *reader) varuint32() (uint32, error) {
+	v, err := r.varuint(32)
+	return uint32(v), err
+}
+
+func (r *reader) varint32() (int32, error) {
+	v, err := r.varint(32)
+	return int32(v), err
+}
+
+func (r *reader) varint64() (int64, error) {
+	return r.varint(64)
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.varuint32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
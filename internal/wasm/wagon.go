@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// +build wagon
+
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/go-interpreter/wagon/disasm"
+	wagon "github.com/go-interpreter/wagon/wasm"
+)
+
+var defaultDecoder Decoder = wagonDecoder{}
+
+// wagonDecoder is the decoder based on github.com/go-interpreter/wagon.
+type wagonDecoder struct{}
+
+// trimEnd removes the last end instruction of wagon's initializer expression.
+func trimEnd(expr []byte) []byte {
+	if len(expr) > 0 && Opcode(expr[len(expr)-1]) == OpEnd {
+		return expr[:len(expr)-1]
+	}
+	return expr
+}
+
+func convertValueTypes(ts []wagon.ValueType) []ValueType {
+	var r []ValueType
+	for _, t := range ts {
+		r = append(r, ValueType(t))
+	}
+	return r
+}
+
+func convertLimits(l wagon.ResizableLimits) Limits {
+	return Limits{
+		Initial:    l.Initial,
+		Maximum:    l.Maximum,
+		HasMaximum: l.Flags&1 != 0,
+	}
+}
+
+func (wagonDecoder) Decode(data []byte) (*Module, error) {
+	wmod, err := wagon.DecodeModule(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// disasm.NewDisassembly requires these sections.
+	if wmod.Types == nil {
+		wmod.Types = &wagon.SectionTypes{}
+	}
+	if wmod.Import == nil {
+		wmod.Import = &wagon.SectionImports{}
+	}
+	if wmod.Function == nil {
+		wmod.Function = &wagon.SectionFunctions{}
+	}
+	if wmod.Global == nil {
+		wmod.Global = &wagon.SectionGlobals{}
+	}
+	if wmod.Code == nil {
+		wmod.Code = &wagon.SectionCode{}
+	}
+
+	m := &Module{}
+	for _, e := range wmod.Types.Entries {
+		m.Types = append(m.Types, FunctionSig{
+			ParamTypes:  convertValueTypes(e.ParamTypes),
+			ReturnTypes: convertValueTypes(e.ReturnTypes),
+		})
+	}
+	for _, e := range wmod.Import.Entries {
+		imp := Import{
+			ModuleName: e.ModuleName,
+			FieldName:  e.FieldName,
+			Kind:       ExternalKind(e.Type.Kind()),
+		}
+		if f, ok := e.Type.(wagon.FuncImport); ok {
+			imp.Type = f.Type
+		}
+		m.Imports = append(m.Imports, imp)
+	}
+	m.Functions = append(m.Functions, wmod.Function.Types...)
+	if wmod.Table != nil {
+		for _, e := range wmod.Table.Entries {
+			m.Tables = append(m.Tables, Table{
+				Limits: convertLimits(e.Limits),
+			})
+		}
+	}
+	if wmod.Memory != nil {
+		for _, e := range wmod.Memory.Entries {
+			m.Memories = append(m.Memories, Memory{
+				Limits: convertLimits(e.Limits),
+			})
+		}
+	}
+	for _, e := range wmod.Global.Globals {
+		m.Globals = append(m.Globals, Global{
+			Type:    ValueType(e.Type.Type),
+			Mutable: e.Type.Mutable,
+			Init:    trimEnd(e.Init),
+		})
+	}
+	if wmod.Export != nil {
+		for _, e := range wmod.Export.Entries {
+			m.Exports = append(m.Exports, Export{
+				FieldStr: e.FieldStr,
+				Kind:     ExternalKind(e.Kind),
+				Index:    e.Index,
+			})
+		}
+		// wagon's exports are in a map.
+		sort.Slice(m.Exports, func(i, j int) bool {
+			return m.Exports[i].FieldStr < m.Exports[j].FieldStr
+		})
+	}
+	if wmod.Start != nil {
+		idx := wmod.Start.Index
+		m.Start = &idx
+	}
+	if wmod.Elements != nil {
+		for _, e := range wmod.Elements.Entries {
+			m.Elements = append(m.Elements, Element{
+				Index:  e.Index,
+				Offset: trimEnd(e.Offset),
+				Elems:  e.Elems,
+			})
+		}
+	}
+	for _, e := range wmod.Code.Bodies {
+		f := FunctionBody{
+			Code: e.Code,
+		}
+		for _, l := range e.Locals {
+			f.Locals = append(f.Locals, Local{
+				Count: l.Count,
+				Type:  ValueType(l.Type),
+			})
+		}
+		m.Codes = append(m.Codes, f)
+	}
+	if wmod.Data != nil {
+		for _, e := range wmod.Data.Entries {
+			m.Data = append(m.Data, Data{
+				Index:  e.Index,
+				Offset: trimEnd(e.Offset),
+				Data:   e.Data,
+			})
+		}
+	}
+	for _, c := range wmod.Customs {
+		m.Customs = append(m.Customs, Custom{
+			Name: c.Name,
+			Data: c.Data,
+		})
+	}
+
+	if c := wmod.Custom(wagon.CustomSectionName); c != nil {
+		var nsec wagon.NameSection
+		if err := nsec.UnmarshalWASM(bytes.NewReader(c.Data)); err != nil {
+			return nil, err
+		}
+		if len(nsec.Types[wagon.NameFunction]) > 0 {
+			sub, err := nsec.Decode(wagon.NameFunction)
+			if err != nil {
+				return nil, err
+			}
+			m.FunctionNames = map[uint32]string(sub.(*wagon.FunctionNames).Names)
+		}
+	}
+
+	if len(m.Functions) != len(m.Codes) {
+		return nil, fmt.Errorf("wasm: the numbers of functions and function bodies don't match: %d vs %d", len(m.Functions), len(m.Codes))
+	}
+	for i := range m.Codes {
+		i := i
+		m.Codes[i].disassemble = func() ([]Instr, error) {
+			t := wmod.Function.Types[i]
+			if int(t) >= len(wmod.Types.Entries) {
+				return nil, fmt.Errorf("wasm: type index out of range: %d", t)
+			}
+			dis, err := disasm.NewDisassembly(wagon.Function{
+				Sig:  &wmod.Types.Entries[t],
+				Body: &wmod.Code.Bodies[i],
+			}, wmod)
+			if err != nil {
+				return nil, err
+			}
+			var instrs []Instr
+			for _, instr := range dis.Code {
+				imms := instr.Immediates
+				if len(imms) > 0 {
+					if t, ok := imms[0].(wagon.BlockType); ok {
+						imms = append([]interface{}{BlockType(t)}, imms[1:]...)
+					}
+				}
+				instrs = append(instrs, Instr{
+					Op:         Opcode(instr.Op.Code),
+					Immediates: imms,
+				})
+			}
+			return instrs, nil
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,401 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+type assembler struct {
+	m      *module
+	f      *function
+	labels []string
+	buf    []byte
+}
+
+func (m *module) encodeBody(f *function) ([]byte, error) {
+	var locals [][]byte
+	for i := 0; i < len(f.localTypes); {
+		j := i
+		for j < len(f.localTypes) && f.localTypes[j] == f.localTypes[i] {
+			j++
+		}
+		locals = append(locals, append(uleb(uint64(j-i)), byte(f.localTypes[i])))
+		i = j
+	}
+
+	a := &assembler{
+		m: m,
+		f: f,
+	}
+	nodes := f.body
+	for len(nodes) > 0 {
+		n := nodes[0]
+		if !n.isAtm {
+			return nil, fmt.Errorf("line %d: folded instructions are not supported", n.line)
+		}
+		num := immediateNum(n.atom, nodes[1:])
+		if err := a.instr(n, nodes[1:1+num], false); err != nil {
+			return nil, err
+		}
+		nodes = nodes[1+num:]
+	}
+	if len(a.labels) != 0 {
+		return nil, fmt.Errorf("line %d: unterminated block", f.node.line)
+	}
+	body := vec(locals)
+	body = append(body, a.buf...)
+	body = append(body, byte(wasm.OpEnd))
+	return body, nil
+}
+
+// immediateNum returns the number of the immediate tokens following the instruction op.
+func immediateNum(op string, rest []*node) int {
+	count := func(pred func(n *node) bool) int {
+		i := 0
+		for i < len(rest) && pred(rest[i]) {
+			i++
+		}
+		return i
+	}
+	isIndex := func(n *node) bool {
+		return n.isAtm && !n.str && n.atom != "" && (n.atom[0] == '$' || ('0' <= n.atom[0] && n.atom[0] <= '9'))
+	}
+	switch op {
+	case "block", "loop", "if":
+		n := 0
+		if len(rest) > 0 && rest[0].isID() {
+			n++
+		}
+		for n < len(rest) && rest[n].keyword() == "result" {
+			n++
+		}
+		return n
+	case "else", "end":
+		if len(rest) > 0 && rest[0].isID() {
+			return 1
+		}
+		return 0
+	case "br", "br_if", "call", "local.get", "local.set", "local.tee", "global.get", "global.set",
+		"get_local", "set_local", "tee_local", "get_global", "set_global",
+		"i32.const", "i64.const", "f32.const", "f64.const":
+		if len(rest) > 0 {
+			return 1
+		}
+		return 0
+	case "br_table":
+		return count(isIndex)
+	case "call_indirect":
+		return count(func(n *node) bool {
+			kw := n.keyword()
+			return kw == "type" || kw == "param" || kw == "result"
+		})
+	}
+	if _, ok := naturalAlignments[op]; ok {
+		return count(func(n *node) bool {
+			return n.isAtm && !n.str && (strings.HasPrefix(n.atom, "offset=") || strings.HasPrefix(n.atom, "align="))
+		})
+	}
+	return 0
+}
+
+// naturalAlignments are the default alignments of the memory instructions as the exponents of 2.
+var naturalAlignments = map[string]int{
+	"i32.load":     2,
+	"i64.load":     3,
+	"f32.load":     2,
+	"f64.load":     3,
+	"i32.load8_s":  0,
+	"i32.load8_u":  0,
+	"i32.load16_s": 1,
+	"i32.load16_u": 1,
+	"i64.load8_s":  0,
+	"i64.load8_u":  0,
+	"i64.load16_s": 1,
+	"i64.load16_u": 1,
+	"i64.load32_s": 2,
+	"i64.load32_u": 2,
+	"i32.store":    2,
+	"i64.store":    3,
+	"f32.store":    2,
+	"f64.store":    3,
+	"i32.store8":   0,
+	"i32.store16":  1,
+	"i64.store8":   0,
+	"i64.store16":  1,
+	"i64.store32":  2,
+}
+
+// oldNames maps the old instruction names to the current ones.
+var oldNames = map[string]string{
+	"get_local":      "local.get",
+	"set_local":      "local.set",
+	"tee_local":      "local.tee",
+	"get_global":     "global.get",
+	"set_global":     "global.set",
+	"current_memory": "memory.size",
+	"grow_memory":    "memory.grow",
+}
+
+func (a *assembler) labelIndex(n *node) (int, error) {
+	if n.isID() {
+		for i := len(a.labels) - 1; i >= 0; i-- {
+			if a.labels[i] == n.atom {
+				return len(a.labels) - 1 - i, nil
+			}
+		}
+		return 0, fmt.Errorf("line %d: unknown label: %s", n.line, n.atom)
+	}
+	v, err := parseInt(n.atom, 32)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: %v", n.line, err)
+	}
+	if int(v) >= len(a.labels) {
+		return 0, fmt.Errorf("line %d: label out of range: %d", n.line, v)
+	}
+	return int(v), nil
+}
+
+func (a *assembler) emit(bs ...byte) {
+	a.buf = append(a.buf, bs...)
+}
+
+func (a *assembler) emitOp(op wasm.Opcode) {
+	a.buf = append(a.buf, byte(op))
+}
+
+func (a *assembler) instr(opNode *node, imms []*node, constOnly bool) error {
+	if !opNode.isAtm || opNode.str {
+		return fmt.Errorf("line %d: instruction expected but %s", opNode.line, opNode)
+	}
+	op := opNode.atom
+	if n, ok := oldNames[op]; ok {
+		op = n
+	}
+	line := opNode.line
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("line %d: %s: %s", line, op, fmt.Sprintf(format, args...))
+	}
+	needImms := func(num int) error {
+		if len(imms) != num {
+			return errorf("%d immediate(s) expected but %d", num, len(imms))
+		}
+		return nil
+	}
+
+	if constOnly && op != "i32.const" && op != "i64.const" && op != "f32.const" && op != "f64.const" &&
+		op != "global.get" {
+		return errorf("not a constant instruction")
+	}
+
+	code, ok := wasm.OpcodeByName(op)
+	if !ok {
+		return errorf("unknown instruction")
+	}
+
+	if align, ok := naturalAlignments[op]; ok {
+		offset := uint64(0)
+		for _, imm := range imms {
+			switch {
+			case strings.HasPrefix(imm.atom, "offset="):
+				v, err := parseInt(imm.atom[len("offset="):], 32)
+				if err != nil {
+					return errorf("%v", err)
+				}
+				offset = v
+			case strings.HasPrefix(imm.atom, "align="):
+				v, err := parseInt(imm.atom[len("align="):], 32)
+				if err != nil || v == 0 || v&(v-1) != 0 {
+					return errorf("invalid alignment: %s", imm.atom)
+				}
+				align = 0
+				for v > 1 {
+					v >>= 1
+					align++
+				}
+			}
+		}
+		a.emitOp(code)
+		a.emit(uleb(uint64(align))...)
+		a.emit(uleb(offset)...)
+		return nil
+	}
+
+	switch op {
+	case "block", "loop", "if":
+		label := ""
+		if len(imms) > 0 && imms[0].isID() {
+			label = imms[0].atom
+			imms = imms[1:]
+		}
+		var results []wasm.ValueType
+		for _, r := range imms {
+			for _, t := range r.list[1:] {
+				v, err := parseValueType(t.atom)
+				if err != nil {
+					return errorf("%v", err)
+				}
+				results = append(results, v)
+			}
+		}
+		if len(results) > 1 {
+			return errorf("multiple results are not supported")
+		}
+		a.emitOp(code)
+		if len(results) == 0 {
+			a.emit(byte(wasm.BlockTypeEmpty))
+		} else {
+			a.emit(byte(results[0]))
+		}
+		a.labels = append(a.labels, label)
+		return nil
+	case "else":
+		if len(a.labels) == 0 {
+			return errorf("else without if")
+		}
+		a.emitOp(code)
+		return nil
+	case "end":
+		if len(a.labels) == 0 {
+			return errorf("end without block")
+		}
+		a.labels = a.labels[:len(a.labels)-1]
+		a.emitOp(code)
+		return nil
+	case "br", "br_if":
+		if err := needImms(1); err != nil {
+			return err
+		}
+		idx, err := a.labelIndex(imms[0])
+		if err != nil {
+			return err
+		}
+		a.emitOp(code)
+		a.emit(uleb(uint64(idx))...)
+		return nil
+	case "br_table":
+		if len(imms) == 0 {
+			return errorf("at least one label expected")
+		}
+		var idxs []byte
+		for _, imm := range imms {
+			idx, err := a.labelIndex(imm)
+			if err != nil {
+				return err
+			}
+			idxs = append(idxs, uleb(uint64(idx))...)
+		}
+		a.emitOp(code)
+		a.emit(uleb(uint64(len(imms) - 1))...)
+		a.emit(idxs...)
+		return nil
+	case "call":
+		if err := needImms(1); err != nil {
+			return err
+		}
+		idx, err := a.m.resolve(imms[0], a.m.funcIDs, len(a.m.funcs))
+		if err != nil {
+			return err
+		}
+		a.emitOp(code)
+		a.emit(uleb(uint64(idx))...)
+		return nil
+	case "call_indirect":
+		idx, rest, err := a.m.typeUse(imms, nil)
+		if err != nil {
+			return err
+		}
+		if len(rest) > 0 {
+			return errorf("invalid type use")
+		}
+		a.emitOp(code)
+		a.emit(uleb(uint64(idx))...)
+		// The reserved table index.
+		a.emit(0x00)
+		return nil
+	case "local.get", "local.set", "local.tee":
+		if err := needImms(1); err != nil {
+			return err
+		}
+		num := len(a.m.types[a.f.typeIndex].params) + len(a.f.localTypes)
+		idx, err := a.m.resolve(imms[0], a.f.localIDs, num)
+		if err != nil {
+			return err
+		}
+		a.emitOp(code)
+		a.emit(uleb(uint64(idx))...)
+		return nil
+	case "global.get", "global.set":
+		if err := needImms(1); err != nil {
+			return err
+		}
+		idx, err := a.m.resolve(imms[0], a.m.globalIDs, len(a.m.globals))
+		if err != nil {
+			return err
+		}
+		a.emitOp(code)
+		a.emit(uleb(uint64(idx))...)
+		return nil
+	case "memory.size", "memory.grow":
+		if err := needImms(0); err != nil {
+			return err
+		}
+		a.emitOp(code)
+		// The reserved memory index.
+		a.emit(0x00)
+		return nil
+	case "i32.const", "i64.const":
+		if err := needImms(1); err != nil {
+			return err
+		}
+		bits := 32
+		if op == "i64.const" {
+			bits = 64
+		}
+		v, err := parseInt(imms[0].atom, bits)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		if bits == 32 {
+			a.emitOp(code)
+			a.emit(sleb(int64(int32(uint32(v))))...)
+		} else {
+			a.emitOp(code)
+			a.emit(sleb(int64(v))...)
+		}
+		return nil
+	case "f32.const":
+		if err := needImms(1); err != nil {
+			return err
+		}
+		v, err := parseFloat(imms[0].atom, 32)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		a.emitOp(code)
+		a.emit(byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+		return nil
+	case "f64.const":
+		if err := needImms(1); err != nil {
+			return err
+		}
+		v, err := parseFloat(imms[0].atom, 64)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		a.emitOp(code)
+		for i := 0; i < 8; i++ {
+			a.emit(byte(v >> (8 * i)))
+		}
+		return nil
+	}
+
+	if err := needImms(0); err != nil {
+		return err
+	}
+	a.emitOp(code)
+	return nil
+}
@@ -0,0 +1,348 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wat
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+func parseValueType(str string) (wasm.ValueType, error) {
+	switch str {
+	case "i32":
+		return wasm.ValueTypeI32, nil
+	case "i64":
+		return wasm.ValueTypeI64, nil
+	case "f32":
+		return wasm.ValueTypeF32, nil
+	case "f64":
+		return wasm.ValueTypeF64, nil
+	}
+	return 0, fmt.Errorf("unknown value type: %q", str)
+}
+
+// node is an S-expression: either an atom or a list.
+type node struct {
+	list  []*node
+	atom  string
+	str   bool
+	isAtm bool
+	line  int
+}
+
+func (n *node) String() string {
+	if n.isAtm {
+		return n.atom
+	}
+	var strs []string
+	for _, c := range n.list {
+		strs = append(strs, c.String())
+	}
+	return "(" + strings.Join(strs, " ") + ")"
+}
+
+// keyword returns the first atom of the list, or an empty string.
+func (n *node) keyword() string {
+	if n.isAtm || len(n.list) == 0 || !n.list[0].isAtm || n.list[0].str {
+		return ""
+	}
+	return n.list[0].atom
+}
+
+func (n *node) isID() bool {
+	return n.isAtm && !n.str && strings.HasPrefix(n.atom, "$")
+}
+
+type parser struct {
+	src  []byte
+	pos  int
+	line int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpaces() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case bytes.HasPrefix(p.src[p.pos:], []byte(";;")):
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case bytes.HasPrefix(p.src[p.pos:], []byte("(;")):
+			depth := 0
+			for {
+				if p.pos >= len(p.src) {
+					return p.errorf("unterminated block comment")
+				}
+				if bytes.HasPrefix(p.src[p.pos:], []byte("(;")) {
+					depth++
+					p.pos += 2
+					continue
+				}
+				if bytes.HasPrefix(p.src[p.pos:], []byte(";)")) {
+					depth--
+					p.pos += 2
+					if depth == 0 {
+						break
+					}
+					continue
+				}
+				if p.src[p.pos] == '\n' {
+					p.line++
+				}
+				p.pos++
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseNode() (*node, error) {
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected EOF")
+	}
+	line := p.line
+	switch c := p.src[p.pos]; c {
+	case '(':
+		p.pos++
+		n := &node{line: line}
+		for {
+			if err := p.skipSpaces(); err != nil {
+				return nil, err
+			}
+			if p.pos >= len(p.src) {
+				return nil, p.errorf("unexpected EOF")
+			}
+			if p.src[p.pos] == ')' {
+				p.pos++
+				return n, nil
+			}
+			c, err := p.parseNode()
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, c)
+		}
+	case ')':
+		return nil, p.errorf("unexpected ')'")
+	case '"':
+		str, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &node{atom: str, str: true, isAtm: true, line: line}, nil
+	default:
+		start := p.pos
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '(' || c == ')' || c == '"' || c == ';' {
+				break
+			}
+			p.pos++
+		}
+		return &node{atom: string(p.src[start:p.pos]), isAtm: true, line: line}, nil
+	}
+}
+
+func (p *parser) parseString() (string, error) {
+	p.pos++
+	var b []byte
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		if c == '"' {
+			return string(b), nil
+		}
+		if c != '\\' {
+			b = append(b, c)
+			continue
+		}
+		if p.pos >= len(p.src) {
+			return "", p.errorf("unterminated string")
+		}
+		c = p.src[p.pos]
+		p.pos++
+		switch c {
+		case 'n':
+			b = append(b, '\n')
+		case 't':
+			b = append(b, '\t')
+		case 'r':
+			b = append(b, '\r')
+		case '\\', '\'', '"':
+			b = append(b, c)
+		case 'u':
+			end := bytes.IndexByte(p.src[p.pos:], '}')
+			if p.pos >= len(p.src) || p.src[p.pos] != '{' || end < 0 {
+				return "", p.errorf("invalid unicode escape")
+			}
+			v, err := strconv.ParseUint(string(p.src[p.pos+1:p.pos+end]), 16, 32)
+			if err != nil {
+				return "", p.errorf("invalid unicode escape")
+			}
+			p.pos += end + 1
+			b = append(b, string(rune(v))...)
+		default:
+			if p.pos >= len(p.src) {
+				return "", p.errorf("invalid escape")
+			}
+			v, err := strconv.ParseUint(string(p.src[p.pos-1:p.pos+1]), 16, 8)
+			if err != nil {
+				return "", p.errorf("invalid escape: \\%c", c)
+			}
+			p.pos++
+			b = append(b, byte(v))
+		}
+	}
+}
+
+func parseInt(str string, bits int) (uint64, error) {
+	s := strings.ReplaceAll(str, "_", "")
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	base := 10
+	if strings.HasPrefix(s, "0x") {
+		base = 16
+		s = s[2:]
+	}
+	v, err := strconv.ParseUint(s, base, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer: %q", str)
+	}
+	if neg {
+		if v > 1<<(bits-1) {
+			return 0, fmt.Errorf("integer out of range: %q", str)
+		}
+		v = -v
+	}
+	if bits < 64 {
+		v &= 1<<bits - 1
+	}
+	return v, nil
+}
+
+func parseFloat(str string, bits int) (uint64, error) {
+	s := strings.ReplaceAll(str, "_", "")
+	neg := false
+	sign := ""
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		sign = "-"
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	if strings.HasPrefix(s, "nan") {
+		var payload uint64
+		if s == "nan" {
+			if bits == 32 {
+				payload = 1 << 22
+			} else {
+				payload = 1 << 51
+			}
+		} else if strings.HasPrefix(s, "nan:0x") {
+			v, err := strconv.ParseUint(s[6:], 16, bits)
+			if err != nil {
+				return 0, fmt.Errorf("invalid NaN: %q", str)
+			}
+			payload = v
+		} else {
+			return 0, fmt.Errorf("invalid float: %q", str)
+		}
+		var v uint64
+		if bits == 32 {
+			v = 0x7f800000 | payload&(1<<23-1)
+			if neg {
+				v |= 1 << 31
+			}
+		} else {
+			v = 0x7ff0000000000000 | payload&(1<<52-1)
+			if neg {
+				v |= 1 << 63
+			}
+		}
+		return v, nil
+	}
+
+	if s == "inf" {
+		s = "Inf"
+	} else if strings.HasPrefix(s, "0x") && !strings.ContainsAny(s, "pP") {
+		s += "p0"
+	}
+	f, err := strconv.ParseFloat(sign+s, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid float: %q", str)
+	}
+	if bits == 32 {
+		return uint64(math.Float32bits(float32(f))), nil
+	}
+	return math.Float64bits(f), nil
+}
+
+func uleb(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func sleb(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func name(str string) []byte {
+	return append(uleb(uint64(len(str))), str...)
+}
+
+func vec(items [][]byte) []byte {
+	b := uleb(uint64(len(items)))
+	for _, i := range items {
+		b = append(b, i...)
+	}
+	return b
+}
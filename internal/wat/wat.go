@@ -0,0 +1,734 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package wat assembles the WebAssembly text format into the binary format.
+//
+// Only a subset of the text format is supported: the MVP instructions in the flat (non-folded) form, and the module
+// fields that go2cpp handles. Function names are emitted into the "name" custom section, as wat2wasm --debug-names
+// does.
+package wat
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+)
+
+type funcType struct {
+	params  []wasm.ValueType
+	results []wasm.ValueType
+}
+
+func (t *funcType) encode() []byte {
+	b := []byte{0x60}
+	b = append(b, uleb(uint64(len(t.params)))...)
+	for _, p := range t.params {
+		b = append(b, byte(p))
+	}
+	b = append(b, uleb(uint64(len(t.results)))...)
+	for _, r := range t.results {
+		b = append(b, byte(r))
+	}
+	return b
+}
+
+func (t *funcType) equals(other *funcType) bool {
+	if len(t.params) != len(other.params) || len(t.results) != len(other.results) {
+		return false
+	}
+	for i := range t.params {
+		if t.params[i] != other.params[i] {
+			return false
+		}
+	}
+	for i := range t.results {
+		if t.results[i] != other.results[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type function struct {
+	id         string
+	typeIndex  int
+	importMod  string
+	importName string
+	localIDs   map[string]int
+	localTypes []wasm.ValueType
+	body       []*node
+	node       *node
+}
+
+type global struct {
+	id   string
+	typ  wasm.ValueType
+	mut  bool
+	init *node
+}
+
+type export struct {
+	name  string
+	kind  wasm.ExternalKind
+	index *node
+}
+
+type module struct {
+	types     []*funcType
+	typeIDs   map[string]int
+	funcs     []*function
+	funcIDs   map[string]int
+	globals   []*global
+	globalIDs map[string]int
+	exports   []*export
+	tables    [][2]int64
+	memories  [][2]int64
+	elems     [][]byte
+	data      [][]byte
+}
+
+// Assemble converts the WebAssembly text format src into the binary format.
+func Assemble(src []byte) ([]byte, error) {
+	p := &parser{src: src, line: 1}
+	n, err := p.parseNode()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.skipSpaces(); err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.src) {
+		return nil, p.errorf("unexpected token after the module")
+	}
+	if n.keyword() != "module" {
+		return nil, fmt.Errorf("line %d: module expected", n.line)
+	}
+
+	m := &module{
+		typeIDs:   map[string]int{},
+		funcIDs:   map[string]int{},
+		globalIDs: map[string]int{},
+	}
+	fields := n.list[1:]
+	if len(fields) > 0 && fields[0].isID() {
+		fields = fields[1:]
+	}
+	// Types, imports and definitions are collected first so that the indices are fixed before the bodies are
+	// assembled. Imports must precede the definitions in the index space.
+	for _, f := range fields {
+		if f.keyword() == "type" {
+			if err := m.addType(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, imported := range []bool{true, false} {
+		for _, f := range fields {
+			switch f.keyword() {
+			case "import":
+				if imported {
+					if err := m.addImport(f); err != nil {
+						return nil, err
+					}
+				}
+			case "func":
+				if imported == isInlineImport(f) {
+					if err := m.addFunc(f); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	for _, f := range fields {
+		var err error
+		switch f.keyword() {
+		case "type", "import", "func":
+		case "table":
+			err = m.addTable(f)
+		case "memory":
+			err = m.addMemory(f)
+		case "global":
+			err = m.addGlobal(f)
+		case "export":
+			err = m.addExport(f)
+		case "elem":
+			err = m.addElem(f)
+		case "data":
+			err = m.addData(f)
+		default:
+			err = fmt.Errorf("line %d: unsupported module field: %s", f.line, f.keyword())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m.encode()
+}
+
+func isInlineImport(n *node) bool {
+	for _, c := range n.list[1:] {
+		if c.keyword() == "import" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *module) addType(n *node) error {
+	args := n.list[1:]
+	id := ""
+	if len(args) > 0 && args[0].isID() {
+		id = args[0].atom
+		args = args[1:]
+	}
+	if len(args) != 1 || args[0].keyword() != "func" {
+		return fmt.Errorf("line %d: invalid type", n.line)
+	}
+	t, rest, err := parseSignature(args[0].list[1:], nil)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("line %d: invalid type", n.line)
+	}
+	if id != "" {
+		m.typeIDs[id] = len(m.types)
+	}
+	m.types = append(m.types, t)
+	return nil
+}
+
+// parseSignature parses params and results. If localIDs is not nil, the IDs of the params are added to it.
+func parseSignature(nodes []*node, localIDs map[string]int) (*funcType, []*node, error) {
+	t := &funcType{}
+	i := 0
+	for ; i < len(nodes); i++ {
+		n := nodes[i]
+		kw := n.keyword()
+		if kw != "param" && kw != "result" {
+			break
+		}
+		args := n.list[1:]
+		if kw == "param" && len(args) > 0 && args[0].isID() {
+			if len(args) != 2 {
+				return nil, nil, fmt.Errorf("line %d: invalid param", n.line)
+			}
+			if localIDs != nil {
+				localIDs[args[0].atom] = len(t.params)
+			}
+			args = args[1:]
+		}
+		for _, a := range args {
+			if !a.isAtm {
+				return nil, nil, fmt.Errorf("line %d: invalid %s", n.line, kw)
+			}
+			v, err := parseValueType(a.atom)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %v", n.line, err)
+			}
+			if kw == "param" {
+				if len(t.results) > 0 {
+					return nil, nil, fmt.Errorf("line %d: param after result", n.line)
+				}
+				t.params = append(t.params, v)
+			} else {
+				t.results = append(t.results, v)
+			}
+		}
+	}
+	return t, nodes[i:], nil
+}
+
+// typeUse returns the type index for an optional (type ...) and the following params and results.
+func (m *module) typeUse(nodes []*node, localIDs map[string]int) (int, []*node, error) {
+	idx := -1
+	if len(nodes) > 0 && nodes[0].keyword() == "type" {
+		n := nodes[0]
+		if len(n.list) != 2 {
+			return 0, nil, fmt.Errorf("line %d: invalid type use", n.line)
+		}
+		i, err := m.resolve(n.list[1], m.typeIDs, len(m.types))
+		if err != nil {
+			return 0, nil, err
+		}
+		idx = i
+		nodes = nodes[1:]
+	}
+	t, rest, err := parseSignature(nodes, localIDs)
+	if err != nil {
+		return 0, nil, err
+	}
+	if idx >= 0 {
+		if (len(t.params) > 0 || len(t.results) > 0) && !t.equals(m.types[idx]) {
+			return 0, nil, fmt.Errorf("line %d: inconsistent type use", nodes[0].line)
+		}
+		return idx, rest, nil
+	}
+	for i, tt := range m.types {
+		if tt.equals(t) {
+			return i, rest, nil
+		}
+	}
+	m.types = append(m.types, t)
+	return len(m.types) - 1, rest, nil
+}
+
+func (m *module) resolve(n *node, ids map[string]int, num int) (int, error) {
+	if !n.isAtm || n.str {
+		return 0, fmt.Errorf("line %d: index expected but %s", n.line, n)
+	}
+	if n.isID() {
+		i, ok := ids[n.atom]
+		if !ok {
+			return 0, fmt.Errorf("line %d: unknown identifier: %s", n.line, n.atom)
+		}
+		return i, nil
+	}
+	v, err := parseInt(n.atom, 32)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: %v", n.line, err)
+	}
+	if int(v) >= num {
+		return 0, fmt.Errorf("line %d: index out of range: %d", n.line, v)
+	}
+	return int(v), nil
+}
+
+func (m *module) addImport(n *node) error {
+	if len(n.list) != 4 || !n.list[1].str || !n.list[2].str || n.list[3].keyword() != "func" {
+		return fmt.Errorf("line %d: only function imports are supported", n.line)
+	}
+	desc := n.list[3]
+	args := desc.list[1:]
+	f := &function{
+		importMod:  n.list[1].atom,
+		importName: n.list[2].atom,
+		node:       desc,
+	}
+	if len(args) > 0 && args[0].isID() {
+		f.id = args[0].atom
+		args = args[1:]
+	}
+	idx, rest, err := m.typeUse(args, nil)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("line %d: invalid import", n.line)
+	}
+	f.typeIndex = idx
+	return m.appendFunc(f)
+}
+
+func (m *module) appendFunc(f *function) error {
+	if f.id != "" {
+		if _, ok := m.funcIDs[f.id]; ok {
+			return fmt.Errorf("line %d: duplicated identifier: %s", f.node.line, f.id)
+		}
+		m.funcIDs[f.id] = len(m.funcs)
+	}
+	m.funcs = append(m.funcs, f)
+	return nil
+}
+
+func (m *module) addFunc(n *node) error {
+	args := n.list[1:]
+	f := &function{
+		localIDs: map[string]int{},
+		node:     n,
+	}
+	if len(args) > 0 && args[0].isID() {
+		f.id = args[0].atom
+		args = args[1:]
+	}
+	for len(args) > 0 {
+		switch args[0].keyword() {
+		case "export":
+			e := args[0]
+			if len(e.list) != 2 || !e.list[1].str {
+				return fmt.Errorf("line %d: invalid export", e.line)
+			}
+			m.exports = append(m.exports, &export{
+				name:  e.list[1].atom,
+				kind:  wasm.ExternalFunction,
+				index: &node{atom: strconv.Itoa(len(m.funcs)), isAtm: true, line: e.line},
+			})
+			args = args[1:]
+			continue
+		case "import":
+			e := args[0]
+			if len(e.list) != 3 || !e.list[1].str || !e.list[2].str {
+				return fmt.Errorf("line %d: invalid import", e.line)
+			}
+			f.importMod = e.list[1].atom
+			f.importName = e.list[2].atom
+			args = args[1:]
+			continue
+		}
+		break
+	}
+
+	idx, rest, err := m.typeUse(args, f.localIDs)
+	if err != nil {
+		return err
+	}
+	f.typeIndex = idx
+	numParams := len(m.types[idx].params)
+
+	if f.importMod != "" {
+		if len(rest) > 0 {
+			return fmt.Errorf("line %d: an imported function must not have a body", n.line)
+		}
+		return m.appendFunc(f)
+	}
+
+	for len(rest) > 0 && rest[0].keyword() == "local" {
+		l := rest[0]
+		largs := l.list[1:]
+		if len(largs) > 0 && largs[0].isID() {
+			if len(largs) != 2 {
+				return fmt.Errorf("line %d: invalid local", l.line)
+			}
+			f.localIDs[largs[0].atom] = numParams + len(f.localTypes)
+			largs = largs[1:]
+		}
+		for _, a := range largs {
+			if !a.isAtm {
+				return fmt.Errorf("line %d: invalid local", l.line)
+			}
+			v, err := parseValueType(a.atom)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", l.line, err)
+			}
+			f.localTypes = append(f.localTypes, v)
+		}
+		rest = rest[1:]
+	}
+	f.body = rest
+	return m.appendFunc(f)
+}
+
+func parseLimits(nodes []*node) ([2]int64, []*node, error) {
+	limits := [2]int64{0, -1}
+	var i int
+	for i = 0; i < len(nodes) && i < 2; i++ {
+		n := nodes[i]
+		if !n.isAtm || n.str {
+			break
+		}
+		v, err := parseInt(n.atom, 32)
+		if err != nil {
+			break
+		}
+		limits[i] = int64(v)
+	}
+	if i == 0 {
+		return limits, nil, fmt.Errorf("limits expected")
+	}
+	return limits, nodes[i:], nil
+}
+
+func (m *module) inlineExports(nodes []*node, kind wasm.ExternalKind, index int) []*node {
+	for len(nodes) > 0 && nodes[0].keyword() == "export" && len(nodes[0].list) == 2 && nodes[0].list[1].str {
+		m.exports = append(m.exports, &export{
+			name:  nodes[0].list[1].atom,
+			kind:  kind,
+			index: &node{atom: strconv.Itoa(index), isAtm: true, line: nodes[0].line},
+		})
+		nodes = nodes[1:]
+	}
+	return nodes
+}
+
+func (m *module) addTable(n *node) error {
+	args := n.list[1:]
+	if len(args) > 0 && args[0].isID() {
+		args = args[1:]
+	}
+	args = m.inlineExports(args, wasm.ExternalTable, len(m.tables))
+	limits, rest, err := parseLimits(args)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", n.line, err)
+	}
+	if len(rest) != 1 || !rest[0].isAtm || (rest[0].atom != "funcref" && rest[0].atom != "anyfunc") {
+		return fmt.Errorf("line %d: only funcref tables are supported", n.line)
+	}
+	m.tables = append(m.tables, limits)
+	return nil
+}
+
+func (m *module) addMemory(n *node) error {
+	args := n.list[1:]
+	if len(args) > 0 && args[0].isID() {
+		args = args[1:]
+	}
+	args = m.inlineExports(args, wasm.ExternalMemory, len(m.memories))
+	limits, rest, err := parseLimits(args)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", n.line, err)
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("line %d: invalid memory", n.line)
+	}
+	m.memories = append(m.memories, limits)
+	return nil
+}
+
+func (m *module) addGlobal(n *node) error {
+	args := n.list[1:]
+	g := &global{}
+	if len(args) > 0 && args[0].isID() {
+		g.id = args[0].atom
+		args = args[1:]
+	}
+	args = m.inlineExports(args, wasm.ExternalGlobal, len(m.globals))
+	if len(args) != 2 {
+		return fmt.Errorf("line %d: invalid global", n.line)
+	}
+	t := args[0]
+	if t.keyword() == "mut" {
+		if len(t.list) != 2 {
+			return fmt.Errorf("line %d: invalid global type", n.line)
+		}
+		g.mut = true
+		t = t.list[1]
+	}
+	if !t.isAtm {
+		return fmt.Errorf("line %d: invalid global type", n.line)
+	}
+	v, err := parseValueType(t.atom)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", n.line, err)
+	}
+	g.typ = v
+	g.init = args[1]
+	if g.id != "" {
+		m.globalIDs[g.id] = len(m.globals)
+	}
+	m.globals = append(m.globals, g)
+	return nil
+}
+
+func (m *module) addExport(n *node) error {
+	if len(n.list) != 3 || !n.list[1].str || len(n.list[2].list) != 2 {
+		return fmt.Errorf("line %d: invalid export", n.line)
+	}
+	var kind wasm.ExternalKind
+	switch n.list[2].keyword() {
+	case "func":
+		kind = wasm.ExternalFunction
+	case "table":
+		kind = wasm.ExternalTable
+	case "memory":
+		kind = wasm.ExternalMemory
+	case "global":
+		kind = wasm.ExternalGlobal
+	default:
+		return fmt.Errorf("line %d: invalid export", n.line)
+	}
+	m.exports = append(m.exports, &export{
+		name:  n.list[1].atom,
+		kind:  kind,
+		index: n.list[2].list[1],
+	})
+	return nil
+}
+
+// constExpr encodes a constant expression in the folded form like (i32.const 0).
+func (m *module) constExpr(n *node) ([]byte, error) {
+	if n.isAtm {
+		return nil, fmt.Errorf("line %d: constant expression expected", n.line)
+	}
+	a := &assembler{m: m}
+	if err := a.instr(n.list[0], n.list[1:], true); err != nil {
+		return nil, err
+	}
+	return append(a.buf, byte(wasm.OpEnd)), nil
+}
+
+func (m *module) addElem(n *node) error {
+	args := n.list[1:]
+	if len(args) > 0 && args[0].isAtm && !args[0].str {
+		// The table index.
+		if _, err := m.resolve(args[0], nil, len(m.tables)); err != nil {
+			return err
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("line %d: invalid elem", n.line)
+	}
+	offset := args[0]
+	if offset.keyword() == "offset" {
+		if len(offset.list) != 2 {
+			return fmt.Errorf("line %d: invalid offset", n.line)
+		}
+		offset = offset.list[1]
+	}
+	expr, err := m.constExpr(offset)
+	if err != nil {
+		return err
+	}
+	args = args[1:]
+	if len(args) > 0 && args[0].isAtm && args[0].atom == "func" {
+		args = args[1:]
+	}
+	// The table index is always 0 in the binary format.
+	b := append([]byte{0x00}, expr...)
+	b = append(b, uleb(uint64(len(args)))...)
+	for _, a := range args {
+		idx, err := m.resolve(a, m.funcIDs, len(m.funcs))
+		if err != nil {
+			return err
+		}
+		b = append(b, uleb(uint64(idx))...)
+	}
+	m.elems = append(m.elems, b)
+	return nil
+}
+
+func (m *module) addData(n *node) error {
+	args := n.list[1:]
+	if len(args) > 0 && args[0].isAtm && !args[0].str {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("line %d: invalid data", n.line)
+	}
+	offset := args[0]
+	if offset.keyword() == "offset" {
+		if len(offset.list) != 2 {
+			return fmt.Errorf("line %d: invalid offset", n.line)
+		}
+		offset = offset.list[1]
+	}
+	expr, err := m.constExpr(offset)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	for _, a := range args[1:] {
+		if !a.str {
+			return fmt.Errorf("line %d: string expected", a.line)
+		}
+		data = append(data, a.atom...)
+	}
+	// The memory index is always 0 in the binary format.
+	b := append([]byte{0x00}, expr...)
+	b = append(b, uleb(uint64(len(data)))...)
+	b = append(b, data...)
+	m.data = append(m.data, b)
+	return nil
+}
+
+func section(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb(uint64(len(content)))...), content...)
+}
+
+func encodeLimits(l [2]int64) []byte {
+	if l[1] < 0 {
+		return append([]byte{0x00}, uleb(uint64(l[0]))...)
+	}
+	return append(append([]byte{0x01}, uleb(uint64(l[0]))...), uleb(uint64(l[1]))...)
+}
+
+func (m *module) encode() ([]byte, error) {
+	var imports, funcs, bodies [][]byte
+	for _, f := range m.funcs {
+		if f.importMod != "" {
+			b := append(name(f.importMod), name(f.importName)...)
+			b = append(b, byte(wasm.ExternalFunction))
+			b = append(b, uleb(uint64(f.typeIndex))...)
+			imports = append(imports, b)
+			continue
+		}
+		funcs = append(funcs, uleb(uint64(f.typeIndex)))
+		body, err := m.encodeBody(f)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, append(uleb(uint64(len(body))), body...))
+	}
+
+	var types [][]byte
+	for _, t := range m.types {
+		types = append(types, t.encode())
+	}
+
+	var tables, memories, globals, exports [][]byte
+	for _, t := range m.tables {
+		tables = append(tables, append([]byte{0x70}, encodeLimits(t)...))
+	}
+	for _, mem := range m.memories {
+		memories = append(memories, encodeLimits(mem))
+	}
+	for _, g := range m.globals {
+		mut := byte(0)
+		if g.mut {
+			mut = 1
+		}
+		expr, err := m.constExpr(g.init)
+		if err != nil {
+			return nil, err
+		}
+		globals = append(globals, append([]byte{byte(g.typ), mut}, expr...))
+	}
+	for _, e := range m.exports {
+		var idx int
+		var err error
+		switch e.kind {
+		case wasm.ExternalFunction:
+			idx, err = m.resolve(e.index, m.funcIDs, len(m.funcs))
+		case wasm.ExternalTable:
+			idx, err = m.resolve(e.index, nil, len(m.tables))
+		case wasm.ExternalMemory:
+			idx, err = m.resolve(e.index, nil, len(m.memories))
+		case wasm.ExternalGlobal:
+			idx, err = m.resolve(e.index, m.globalIDs, len(m.globals))
+		}
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, append(append(name(e.name), byte(e.kind)), uleb(uint64(idx))...))
+	}
+
+	out := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	for _, s := range []struct {
+		id    byte
+		items [][]byte
+	}{
+		{1, types},
+		{2, imports},
+		{3, funcs},
+		{4, tables},
+		{5, memories},
+		{6, globals},
+		{7, exports},
+		{9, m.elems},
+		{10, bodies},
+		{11, m.data},
+	} {
+		if len(s.items) == 0 {
+			continue
+		}
+		out = append(out, section(s.id, vec(s.items))...)
+	}
+
+	var names [][]byte
+	for i, f := range m.funcs {
+		if f.id == "" {
+			continue
+		}
+		names = append(names, append(uleb(uint64(i)), name(f.id[1:])...))
+	}
+	if len(names) > 0 {
+		sub := vec(names)
+		content := name("name")
+		content = append(content, 0x01)
+		content = append(content, uleb(uint64(len(sub)))...)
+		content = append(content, sub...)
+		out = append(out, section(0, content)...)
+	}
+	return out, nil
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package wat_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/go2cpp/internal/wasm"
+	. "github.com/hajimehoshi/go2cpp/internal/wat"
+)
+
+func TestAssemble(t *testing.T) {
+	src := `(module
+  (import "go" "debug" (func $debug (param i32)))
+  (memory (export "mem") 1)
+  (global $sp (mut i32) (i32.const 1024))
+  ;; A comment.
+  (func $add (export "test_add") (param $x i32) (param $y i32) (result i32)
+    local.get $x
+    get_local 1 ;; The old name.
+    i32.add)
+  (data (i32.const 8) "\01\02"))`
+
+	b, err := Assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := wasm.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(m.Imports), 1; got != want {
+		t.Fatalf("len(m.Imports): got: %d, want: %d", got, want)
+	}
+	if got, want := m.Imports[0].FieldName, "debug"; got != want {
+		t.Errorf("import: got: %q, want: %q", got, want)
+	}
+	if got, want := m.FunctionNames[1], "add"; got != want {
+		t.Errorf("function name: got: %q, want: %q", got, want)
+	}
+	exports := map[string]wasm.Export{}
+	for _, e := range m.Exports {
+		exports[e.FieldStr] = e
+	}
+	if got, want := exports["test_add"], (wasm.Export{FieldStr: "test_add", Kind: wasm.ExternalFunction, Index: 1}); got != want {
+		t.Errorf("export: got: %v, want: %v", got, want)
+	}
+	if got, want := exports["mem"], (wasm.Export{FieldStr: "mem", Kind: wasm.ExternalMemory, Index: 0}); got != want {
+		t.Errorf("export: got: %v, want: %v", got, want)
+	}
+	if got, want := string(m.Data[0].Data), "\x01\x02"; got != want {
+		t.Errorf("data: got: %q, want: %q", got, want)
+	}
+	v, err := m.ExecInitExpr(m.Globals[0].Init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := v, interface{}(int32(1024)); got != want {
+		t.Errorf("global: got: %v, want: %v", got, want)
+	}
+
+	instrs, err := m.Codes[0].Instrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, instr := range instrs {
+		ops = append(ops, instr.Op.String())
+	}
+	if got, want := strings.Join(ops, " "), "local.get local.get i32.add"; got != want {
+		t.Errorf("instructions: got: %q, want: %q", got, want)
+	}
+}
+
+func TestAssembleError(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		err string
+	}{
+		{
+			src: "(module (func\n  i32.foo))",
+			err: "line 2: i32.foo: unknown instruction",
+		},
+		{
+			src: "(module (func (param $x i32)\n  local.get $y))",
+			err: "line 2: unknown identifier: $y",
+		},
+		{
+			src: "(module (func\n  block\n  br 1\n  end))",
+			err: "line 3: label out of range: 1",
+		},
+		{
+			src: "(module (func)",
+			err: "line 1: unexpected EOF",
+		},
+		{
+			src: "(module (func\n  (i32.const 1)))",
+			err: "line 2: folded instructions are not supported",
+		},
+	} {
+		_, err := Assemble([]byte(tc.src))
+		if err == nil {
+			t.Errorf("Assemble(%q): error expected", tc.src)
+			continue
+		}
+		if got, want := err.Error(), tc.err; got != want {
+			t.Errorf("Assemble(%q): got: %q, want: %q", tc.src, got, want)
+		}
+	}
+}
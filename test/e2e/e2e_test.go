@@ -0,0 +1,223 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// +build e2e
+
+// Package e2e_test runs the example programs both as generated C++ and in a reference WebAssembly engine, and
+// compares the results.
+//
+// Run this with `go test -tags e2e ./test/e2e`. The environment variables below change the tools:
+//
+//	CXX               the C++ compiler (default: c++)
+//	EMCC              the Emscripten compiler for TestEmscripten (default: emcc)
+//	GO2CPP_E2E_ENGINE the command to run a wasm file (default: go_js_wasm_exec in GOROOT, which requires Node.js)
+//
+// A WebAssembly engine without JavaScript like wasmtime cannot be the default, as the programs are built for
+// GOOS=js and import the functions of syscall/js.
+package e2e_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/go2cpp/gowasm2cpp"
+)
+
+// examples are the example programs to test. The programs that require a window system are not included.
+var examples = []string{
+	"helloworld",
+	"goroutine",
+}
+
+type result struct {
+	stdout   []byte
+	exitCode int
+}
+
+func run(cmd *exec.Cmd) (*result, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &result{
+			stdout:   stdout.Bytes(),
+			exitCode: exitErr.ExitCode(),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result{
+		stdout: stdout.Bytes(),
+	}, nil
+}
+
+// output runs cmd and returns an error with the stderr if the command fails.
+func output(cmd *exec.Cmd) error {
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(strings.TrimSpace(err.Error() + "\n" + string(out)))
+	}
+	return nil
+}
+
+func referenceEngine(t *testing.T) []string {
+	if e := os.Getenv("GO2CPP_E2E_ENGINE"); e != "" {
+		return strings.Fields(e)
+	}
+	goroot := runtime.GOROOT()
+	for _, dir := range []string{"lib", "misc"} {
+		path := filepath.Join(goroot, dir, "wasm", "go_js_wasm_exec")
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if _, err := exec.LookPath("node"); err != nil {
+			t.Skip("node is not found")
+		}
+		return []string{path}
+	}
+	t.Skip("go_js_wasm_exec is not found")
+	return nil
+}
+
+func cxx(t *testing.T) string {
+	c := os.Getenv("CXX")
+	if c == "" {
+		c = "c++"
+	}
+	if _, err := exec.LookPath(c); err != nil {
+		t.Skipf("%s is not found", c)
+	}
+	return c
+}
+
+func emcc(t *testing.T) string {
+	c := os.Getenv("EMCC")
+	if c == "" {
+		c = "emcc"
+	}
+	if _, err := exec.LookPath(c); err != nil {
+		t.Skipf("%s is not found", c)
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not found")
+	}
+	return c
+}
+
+// generate builds the example program name into a wasm file in dir and translates it with options. generate returns
+// the path of the wasm file and the C++ files to compile, i.e., the example's and the generated ones.
+func generate(t *testing.T, name string, dir string, options *gowasm2cpp.Options) (string, []string) {
+	src, err := filepath.Abs(filepath.Join("..", "..", "example", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wasm := filepath.Join(dir, name+".wasm")
+	build := exec.Command("go", "build", "-tags", "example", "-trimpath", "-o", wasm, ".")
+	build.Dir = src
+	build.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm")
+	if err := output(build); err != nil {
+		t.Fatal(err)
+	}
+
+	autogen := filepath.Join(dir, "autogen")
+	if err := os.Mkdir(autogen, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := gowasm2cpp.GenerateWithOptions(autogen, "autogen", wasm, "go2cpp_autogen", options); err != nil {
+		t.Fatal(err)
+	}
+
+	cpps, err := filepath.Glob(filepath.Join(src, "*.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gencpps, err := filepath.Glob(filepath.Join(autogen, "*.cpp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wasm, append(cpps, gencpps...)
+}
+
+// compare runs the wasm file in the reference engine and compares the result with got.
+func compare(t *testing.T, engine []string, wasm string, got *result) {
+	want, err := run(exec.Command(engine[0], append(engine[1:], wasm)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got.stdout, want.stdout) {
+		t.Errorf("stdout:\ngot:\n%s\nwant:\n%s", got.stdout, want.stdout)
+	}
+	if got.exitCode != want.exitCode {
+		t.Errorf("exit code: got: %d, want: %d", got.exitCode, want.exitCode)
+	}
+}
+
+func TestExamples(t *testing.T) {
+	engine := referenceEngine(t)
+	cxx := cxx(t)
+
+	for _, name := range examples {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			wasm, cpps := generate(t, name, dir, nil)
+
+			exe := filepath.Join(dir, name)
+			args := []string{"-O2", "-std=c++14", "-pthread", "-I" + dir, "-o", exe}
+			args = append(args, cpps...)
+			if err := output(exec.Command(cxx, args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := run(exec.Command(exe))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compare(t, engine, wasm, got)
+		})
+	}
+}
+
+// TestEmscripten compiles the generated C++ back to WebAssembly with Emscripten, and compares the behavior of the
+// round-tripped module with the original one. This checks the semantics of the translation and the portability of the
+// generated runtime at once.
+func TestEmscripten(t *testing.T) {
+	engine := referenceEngine(t)
+	emcc := emcc(t)
+
+	for _, name := range examples {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			wasm, cpps := generate(t, name, dir, &gowasm2cpp.Options{
+				Emscripten: true,
+			})
+
+			js := filepath.Join(dir, name+".js")
+			args := []string{
+				"-O2", "-std=c++14", "-pthread", "-I" + dir, "-o", js,
+				"-sPROXY_TO_PTHREAD", "-sPTHREAD_POOL_SIZE=8", "-sALLOW_MEMORY_GROWTH", "-sSTACK_SIZE=8MB", "-sEXIT_RUNTIME",
+			}
+			args = append(args, cpps...)
+			if err := output(exec.Command(emcc, args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := run(exec.Command("node", js))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compare(t, engine, wasm, got)
+		})
+	}
+}
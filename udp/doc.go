@@ -0,0 +1,6 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package udp provides UDP sockets to the Go programs run by go2cpp. The sockets are provided by Driver::CreateUDPSocket
+// of the generated C++ code via go2cpp.createUDPSocket, so this package works only with GOOS=js and GOARCH=wasm on the
+// drivers that support UDP.
+package udp
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build js && wasm
+// +build js,wasm
+
+package udp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+)
+
+// ErrClosed is returned by the operations on a closed Conn.
+var ErrClosed = errors.New("udp: use of closed connection")
+
+// queueSize is the number of the received datagrams kept until they are read. As UDP is unreliable, the datagrams over
+// this are dropped.
+const queueSize = 256
+
+// Addr is the address of a UDP peer.
+type Addr struct {
+	Host string
+	Port int
+}
+
+func (a Addr) String() string {
+	return fmt.Sprintf("%s:%d", a.Host, a.Port)
+}
+
+type packet struct {
+	addr Addr
+	data []byte
+}
+
+// Conn is a UDP socket bound to a local port.
+type Conn struct {
+	v          js.Value
+	onReceived js.Func
+	packets    chan packet
+
+	m      sync.Mutex
+	closed bool
+}
+
+// Listen binds a UDP socket to the local port, or to any port if port is 0.
+func Listen(port int) (*Conn, error) {
+	go2cpp := js.Global().Get("go2cpp")
+	if go2cpp.Type() != js.TypeObject || go2cpp.Get("createUDPSocket").Type() != js.TypeFunction {
+		return nil, errors.New("udp: go2cpp.createUDPSocket is not available")
+	}
+
+	c := &Conn{
+		packets: make(chan packet, queueSize),
+	}
+	c.onReceived = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		data := make([]byte, args[2].Get("length").Int())
+		js.CopyBytesToGo(data, args[2])
+		c.receive(packet{
+			addr: Addr{Host: args[0].String(), Port: args[1].Int()},
+			data: data,
+		})
+		return nil
+	})
+	v := go2cpp.Call("createUDPSocket", port, c.onReceived)
+	if v.IsNull() {
+		c.onReceived.Release()
+		return nil, fmt.Errorf("udp: binding to the port %d failed", port)
+	}
+	c.v = v
+	return c, nil
+}
+
+func (c *Conn) receive(p packet) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.packets <- p:
+	default:
+	}
+}
+
+// LocalPort returns the local port that c is bound to.
+func (c *Conn) LocalPort() int {
+	return c.v.Get("localPort").Int()
+}
+
+// ReadFrom waits for a datagram and copies it to b. If b is shorter than the datagram, the rest is discarded.
+func (c *Conn) ReadFrom(b []byte) (int, Addr, error) {
+	p, ok := <-c.packets
+	if !ok {
+		return 0, Addr{}, ErrClosed
+	}
+	return copy(b, p.data), p.addr, nil
+}
+
+// WriteTo sends b as a datagram to addr.
+func (c *Conn) WriteTo(b []byte, addr Addr) (int, error) {
+	c.m.Lock()
+	closed := c.closed
+	c.m.Unlock()
+	if closed {
+		return 0, ErrClosed
+	}
+
+	u8 := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(u8, b)
+	if !c.v.Call("sendTo", addr.Host, addr.Port, u8).Bool() {
+		return 0, fmt.Errorf("udp: sending to %s failed", addr)
+	}
+	return len(b), nil
+}
+
+// Close closes c. The blocked ReadFrom calls return ErrClosed.
+func (c *Conn) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	c.v.Call("close")
+	c.onReceived.Release()
+	close(c.packets)
+	return nil
+}
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package version provides the version of go2cpp.
+//
+// The version follows Semantic Versioning. The generated C++ code records the version, and the generated headers
+// refuse to be compiled with the files generated by another version.
+package version
+
+import (
+	"fmt"
+)
+
+// Major, Minor and Patch are the parts of the version.
+const (
+	Major = 0
+	Minor = 1
+	Patch = 0
+)
+
+// String returns the version like "0.1.0".
+func String() string {
+	return fmt.Sprintf("%d.%d.%d", Major, Minor, Patch)
+}
+
+// Number returns the version as an integer Major * 10000 + Minor * 100 + Patch so that the preprocessor can compare
+// the versions.
+func Number() int {
+	return Major*10000 + Minor*100 + Patch
+}